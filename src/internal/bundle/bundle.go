@@ -0,0 +1,429 @@
+// Package bundle builds and installs offline install bundles: a tar holding
+// everything `dh install` would otherwise download (Python wheels and uv),
+// optionally with a Temurin JDK and the Firecracker binary and kernel for
+// VM mode, so Deephaven can be installed on an air-gapped machine.
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// FormatVersion is the bundle layout version written to manifest.json.
+const FormatVersion = 1
+
+// ManifestName is the bundle's manifest, the first entry in the tar.
+const ManifestName = "manifest.json"
+
+// Manifest describes what a bundle contains.
+type Manifest struct {
+	Format  int       `json:"format"`
+	Version string    `json:"version"`
+	Python  string    `json:"python"`
+	Plugins []string  `json:"plugins"`
+	OS      string    `json:"os"`
+	Arch    string    `json:"arch"`
+	Created time.Time `json:"created"`
+	Tool    string    `json:"tool"` // dh CLI version that built the bundle
+	Wheels  int       `json:"wheels"`
+	UV      bool      `json:"uv"`
+	JDK     int       `json:"jdk,omitempty"` // Temurin major version, 0 if not bundled
+	VM      bool      `json:"vm"`            // Firecracker and kernel included
+}
+
+// CreateOptions configures Create.
+type CreateOptions struct {
+	DHHome     string
+	Version    string
+	Python     string
+	Plugins    []string
+	Tool       string
+	UV         string // uv binary to include; "" looks it up on PATH
+	JDKVersion int    // Temurin major version to include; 0 for none
+	WithVM     bool
+}
+
+// Hooks for downloads that tests replace.
+var (
+	DownloadWheels    = versions.DownloadWheels
+	DownloadJDK       = java.DownloadJDK
+	EnsureFirecracker = vm.EnsureFirecracker
+	EnsureKernel      = vm.EnsureKernel
+)
+
+// Create gathers the bundle contents and writes the tar to out.
+func Create(ctx context.Context, opts CreateOptions, out string, onProgress func(string), stderr io.Writer) (*Manifest, error) {
+	progress := func(msg string) {
+		if onProgress != nil {
+			onProgress(msg)
+		}
+	}
+
+	staging, err := os.MkdirTemp("", "dh-bundle-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	m := &Manifest{
+		Format:  FormatVersion,
+		Version: opts.Version,
+		Python:  opts.Python,
+		Plugins: opts.Plugins,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Created: time.Now().UTC(),
+		Tool:    opts.Tool,
+	}
+	if m.Plugins == nil {
+		m.Plugins = []string{}
+	}
+
+	progress(fmt.Sprintf("Downloading wheels for Deephaven %s (Python %s)...", opts.Version, opts.Python))
+	wheelDir := filepath.Join(staging, "wheels")
+	if err := os.MkdirAll(wheelDir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := DownloadWheels(ctx, opts.DHHome, opts.Version, opts.Python, opts.Plugins, wheelDir, stderr); err != nil {
+		return nil, err
+	}
+	entries, _ := os.ReadDir(wheelDir)
+	m.Wheels = len(entries)
+
+	uv := opts.UV
+	if uv == "" {
+		uv, err = exec.LookPath("uv")
+		if err != nil {
+			return nil, fmt.Errorf("uv not found on PATH; it is needed to install the bundle offline")
+		}
+	}
+	progress("Adding uv...")
+	if err := copyFile(uv, filepath.Join(staging, "bin", uvName()), 0o755); err != nil {
+		return nil, fmt.Errorf("copying uv: %w", err)
+	}
+	m.UV = true
+
+	if opts.JDKVersion > 0 {
+		progress(fmt.Sprintf("Downloading Temurin JDK %d...", opts.JDKVersion))
+		if err := writeFile(filepath.Join(staging, "jdk", java.JDKArchiveName(opts.JDKVersion)), func(w io.Writer) error {
+			return DownloadJDK(opts.JDKVersion, w)
+		}); err != nil {
+			return nil, err
+		}
+		m.JDK = opts.JDKVersion
+	}
+
+	if opts.WithVM {
+		progress("Adding Firecracker and kernel...")
+		paths := vm.NewVMPaths(opts.DHHome)
+		if err := EnsureFirecracker(paths, stderr); err != nil {
+			return nil, err
+		}
+		if err := EnsureKernel(paths, stderr); err != nil {
+			return nil, err
+		}
+		if err := copyFile(paths.Firecracker, filepath.Join(staging, "vm", "firecracker"), 0o755); err != nil {
+			return nil, err
+		}
+		if err := copyFile(paths.Kernel, filepath.Join(staging, "vm", "vmlinux"), 0o644); err != nil {
+			return nil, err
+		}
+		m.VM = true
+	}
+
+	progress(fmt.Sprintf("Writing %s...", out))
+	if err := writeTar(out, staging, m); err != nil {
+		os.Remove(out)
+		return nil, err
+	}
+	return m, nil
+}
+
+// InstallResult reports what Install did.
+type InstallResult struct {
+	Manifest         *Manifest
+	Dir              string // where the bundle was unpacked
+	VersionInstalled bool   // false if the version was already installed
+	JavaInstalled    bool
+	VMInstalled      bool
+}
+
+// Install unpacks a bundle into <dhHome>/bundles/<version>/, where the
+// versions, java and vm packages look for it, then installs the version
+// from its wheels. The bundled JDK is installed when no usable Java is
+// found, and the VM files are put in place if the bundle has them.
+func Install(ctx context.Context, dhHome, path string, onProgress func(string), stderr io.Writer) (*InstallResult, error) {
+	progress := func(msg string) {
+		if onProgress != nil {
+			onProgress(msg)
+		}
+	}
+
+	m, err := ReadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if m.OS != runtime.GOOS || m.Arch != runtime.GOARCH {
+		return nil, fmt.Errorf("bundle is for %s/%s, this machine is %s/%s", m.OS, m.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+
+	progress(fmt.Sprintf("Unpacking bundle for Deephaven %s...", m.Version))
+	bundlesDir := filepath.Join(dhHome, "bundles")
+	if err := os.MkdirAll(bundlesDir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp(bundlesDir, ".incoming-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractTar(path, tmp); err != nil {
+		return nil, fmt.Errorf("unpacking bundle: %w", err)
+	}
+	dir := versions.BundleDir(dhHome, m.Version)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return nil, err
+	}
+	res := &InstallResult{Manifest: m, Dir: dir}
+
+	if m.UV {
+		if _, err := exec.LookPath("uv"); err != nil {
+			progress("Installing bundled uv...")
+			if err := copyFile(filepath.Join(dir, "bin", uvName()), filepath.Join(dhHome, "bin", uvName()), 0o755); err != nil {
+				return nil, fmt.Errorf("installing uv: %w", err)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dhHome, "versions", m.Version)); err == nil {
+		progress(fmt.Sprintf("Version %s is already installed.", m.Version))
+	} else {
+		if err := versions.InstallContext(ctx, dhHome, m.Version, m.Python, m.Plugins, onProgress, stderr); err != nil {
+			return nil, err
+		}
+		res.VersionInstalled = true
+	}
+
+	if m.JDK > 0 {
+		info, _ := java.Detect(dhHome)
+		if info == nil || !info.Found || !java.MeetsMinimum(info.Version, java.MinimumVersion) {
+			if _, err := java.Install(dhHome, m.JDK, true); err != nil {
+				return nil, fmt.Errorf("installing bundled JDK: %w", err)
+			}
+			res.JavaInstalled = true
+		}
+	}
+
+	if m.VM {
+		paths := vm.NewVMPaths(dhHome)
+		if err := EnsureFirecracker(paths, stderr); err != nil {
+			return nil, err
+		}
+		if err := EnsureKernel(paths, stderr); err != nil {
+			return nil, err
+		}
+		res.VMInstalled = true
+	}
+
+	return res, nil
+}
+
+// ReadManifest reads the manifest of a bundle without unpacking it.
+func ReadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s is not a dh bundle: no %s", path, ManifestName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a dh bundle: %w", path, err)
+		}
+		if hdr.Name != ManifestName {
+			continue
+		}
+		var m Manifest
+		if err := json.NewDecoder(tr).Decode(&m); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", ManifestName, err)
+		}
+		if m.Format != FormatVersion {
+			return nil, fmt.Errorf("unsupported bundle format %d (this dh reads format %d)", m.Format, FormatVersion)
+		}
+		if m.Version == "" {
+			return nil, fmt.Errorf("bundle manifest has no version")
+		}
+		return &m, nil
+	}
+}
+
+func uvName() string {
+	if runtime.GOOS == "windows" {
+		return "uv.exe"
+	}
+	return "uv"
+}
+
+// writeTar writes the manifest followed by every file under dir.
+func writeTar(out, dir string, m *Manifest) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    ManifestName,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: m.Created,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// extractTar unpacks the directories and regular files of a bundle into
+// dest, skipping anything that would land outside it.
+func extractTar(path, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			continue
+		}
+		target := filepath.Join(dest, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, func(w io.Writer) error {
+				_, err := io.Copy(w, tr)
+				return err
+			}); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFile creates path and its parent directories and fills it with fill.
+func writeFile(path string, fill func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := fill(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := writeFile(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	}); err != nil {
+		return err
+	}
+	return os.Chmod(dst, mode)
+}
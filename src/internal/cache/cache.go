@@ -0,0 +1,216 @@
+// Package cache finds the data dh keeps only to save time or downloads
+// (package caches, fetched metadata, VM downloads and snapshots) so that
+// it can be listed and removed. Everything here is recreated on demand.
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// Category is one kind of cached data.
+type Category struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Refill      string `json:"refill"` // what recreates it
+
+	entries func(dhHome string) []string
+	remove  func(path string) error
+}
+
+// Entry is one removable item of a category: a file or directory.
+type Entry struct {
+	Category  string    `json:"category"`
+	Path      string    `json:"path"`
+	SizeBytes int64     `json:"size_bytes"`
+	Modified  time.Time `json:"modified"` // newest modification in the tree
+}
+
+// Categories are the kinds of cached data, in listing order.
+var Categories = []Category{
+	{
+		Name:        "uv",
+		Description: "uv package cache used to build the version venvs (shared with other uv projects)",
+		Refill:      "refilled by the next install",
+		entries:     uvEntries,
+		remove:      uvClean,
+	},
+	{
+		Name:        "exec-with",
+		Description: "Packages installed for dh exec --with, per version and package set",
+		Refill:      "installed again by the next dh exec --with",
+		entries: func(dhHome string) []string {
+			matches, _ := filepath.Glob(filepath.Join(dhHome, "versions", "*", "with", "*"))
+			return matches
+		},
+	},
+	{
+		Name:        "metadata",
+		Description: "Fetched release notes and JVM flag checks",
+		Refill:      "fetched or checked again when needed",
+		entries: func(dhHome string) []string {
+			return files(filepath.Join(dhHome, "cache"))
+		},
+	},
+	{
+		Name:        "vm-downloads",
+		Description: "Firecracker binary and guest kernel",
+		Refill:      "downloaded again by dh vm prepare",
+		entries: func(dhHome string) []string {
+			p := vm.NewVMPaths(dhHome)
+			return existing(p.Firecracker, p.Kernel, p.Toolchain)
+		},
+	},
+	{
+		Name:        "vm-rootfs",
+		Description: "VM root filesystem images, per version",
+		Refill:      "rebuilt by dh vm prepare",
+		entries: func(dhHome string) []string {
+			matches, _ := filepath.Glob(filepath.Join(vm.NewVMPaths(dhHome).RootfsDir, "*"))
+			return matches
+		},
+	},
+	{
+		Name:        "snapshots",
+		Description: "VM snapshots (memory and disk), per version",
+		Refill:      "recreated by dh vm prepare",
+		entries: func(dhHome string) []string {
+			matches, _ := filepath.Glob(filepath.Join(vm.NewVMPaths(dhHome).SnapshotDir, "*"))
+			return matches
+		},
+	},
+}
+
+// Lookup returns the category called name.
+func Lookup(name string) (*Category, error) {
+	var names []string
+	for i := range Categories {
+		if Categories[i].Name == name {
+			return &Categories[i], nil
+		}
+		names = append(names, Categories[i].Name)
+	}
+	return nil, fmt.Errorf("unknown cache %q (one of: %s)", name, strings.Join(names, ", "))
+}
+
+// Entries returns the entries of c, with their sizes.
+func (c *Category) Entries(dhHome string) []Entry {
+	var out []Entry
+	for _, path := range c.entries(dhHome) {
+		out = append(out, Entry{
+			Category:  c.Name,
+			Path:      path,
+			SizeBytes: vm.DiskUsage(path),
+			Modified:  newest(path),
+		})
+	}
+	return out
+}
+
+// Remove deletes e.
+func (c *Category) Remove(e Entry) error {
+	if c.remove != nil {
+		return c.remove(e.Path)
+	}
+	return os.RemoveAll(e.Path)
+}
+
+// uvEntries returns uv's cache directory: UV_CACHE_DIR, or what uv
+// reports. Without uv there is nothing to list.
+func uvEntries(string) []string {
+	dir := os.Getenv("UV_CACHE_DIR")
+	if dir == "" {
+		out, err := dhexec.ExecCommand("uv", "cache", "dir").Output()
+		if err != nil {
+			return nil
+		}
+		dir = strings.TrimSpace(string(out))
+	}
+	return existing(dir)
+}
+
+// uvClean clears uv's cache with uv itself, which knows its layout.
+func uvClean(dir string) error {
+	cmd := dhexec.ExecCommand("uv", "cache", "clean", "--cache-dir", dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("uv cache clean: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func existing(paths ...string) []string {
+	var out []string
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if _, err := os.Stat(p); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// files returns the regular files under dir.
+func files(dir string) []string {
+	var out []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			out = append(out, path)
+		}
+		return nil
+	})
+	return out
+}
+
+// newest returns the newest modification time in the tree at path.
+func newest(path string) time.Time {
+	var t time.Time
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+		return nil
+	})
+	return t
+}
+
+// ParseAge parses an age such as "30d", "2w" or "12h". Days and weeks are
+// added to time.ParseDuration's units.
+func ParseAge(s string) (time.Duration, error) {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err == nil && days >= 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	} else if n, ok := strings.CutSuffix(s, "w"); ok {
+		weeks, err := strconv.Atoi(n)
+		if err == nil && weeks >= 0 {
+			return time.Duration(weeks) * 7 * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid age %q (use e.g. 30d, 2w or 12h)", s)
+}
+
+// FormatSize renders a byte count in binary units.
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
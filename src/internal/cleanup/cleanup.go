@@ -0,0 +1,239 @@
+// Package cleanup finds what dh leaves behind when it is killed or
+// crashes: runner and Firecracker processes whose dh process is gone,
+// VM instance directories, sockets nothing listens on, and installs
+// that never finished.
+package cleanup
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// Kinds of leftovers.
+const (
+	KindRunner         = "runner-process" // dh exec/serve runner whose dh exited
+	KindVMM            = "vmm-process"    // Firecracker whose owner exited
+	KindInstance       = "instance-dir"   // VM run directory of a VM that is gone
+	KindSocket         = "socket"         // Unix socket nothing listens on
+	KindPartialInstall = "partial-install"
+)
+
+// partialInstallGrace is how long a version directory without meta.toml
+// is left alone, so an install still in progress isn't taken for one that
+// was interrupted.
+const partialInstallGrace = time.Hour
+
+// runnerMarker starts the runner script dh exec and dh serve pass to
+// python -c (the docstring of internal/exec/runner.py).
+const runnerMarker = `"""Embedded runner for dh exec/serve.`
+
+// Item is one leftover.
+type Item struct {
+	Kind   string `json:"kind"`
+	PID    int    `json:"pid,omitempty"`  // for processes
+	Path   string `json:"path,omitempty"` // for files and directories
+	Reason string `json:"reason"`
+
+	// For KindInstance, the VM whose jail and network go with it
+	instance *vm.StaleInstance
+	paths    *vm.VMPaths
+}
+
+func (it Item) String() string {
+	if it.PID > 0 {
+		return fmt.Sprintf("pid %d", it.PID)
+	}
+	return it.Path
+}
+
+// Find returns every leftover under dhHome.
+func Find(dhHome string) []Item {
+	items := findProcesses(vm.NewVMPaths(dhHome))
+	items = append(items, FindQuick(dhHome)...)
+	return append(items, findPartialInstalls(dhHome)...)
+}
+
+// FindQuick returns the leftovers that are cheap to find and always safe
+// to remove: instance directories and abandoned sockets. dh sweeps these
+// on every run.
+func FindQuick(dhHome string) []Item {
+	paths := vm.NewVMPaths(dhHome)
+	var items []Item
+	for _, s := range vm.StaleInstances(paths) {
+		items = append(items, Item{Kind: KindInstance, Path: s.Dir, Reason: s.Reason, instance: &s, paths: paths})
+	}
+	return append(items, findSockets(paths)...)
+}
+
+// Sweep removes what FindQuick finds and returns what it removed.
+func Sweep(dhHome string) []Item {
+	var removed []Item
+	for _, it := range FindQuick(dhHome) {
+		if Remove(it) == nil {
+			removed = append(removed, it)
+		}
+	}
+	return removed
+}
+
+// Remove removes a leftover: kills the process, or deletes the file or
+// directory. An instance directory goes with its VM's jail and network;
+// its console log is kept for dh vm logs.
+func Remove(it Item) error {
+	switch it.Kind {
+	case KindRunner, KindVMM:
+		return killProcess(it.PID)
+	case KindInstance:
+		if it.instance != nil {
+			return vm.RemoveStaleInstance(it.paths, *it.instance)
+		}
+		return vm.RemoveInstanceDir(it.Path)
+	case KindSocket:
+		err := os.Remove(it.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	default:
+		return os.RemoveAll(it.Path)
+	}
+}
+
+// ClassifyCmdline returns KindRunner or KindVMM for the command line of a
+// process dh starts, or "" for anything else. runDir is the VM run
+// directory, where dh puts the API socket of every Firecracker it starts.
+func ClassifyCmdline(argv []string, runDir string) string {
+	if len(argv) >= 3 && argv[1] == "-c" && strings.HasPrefix(argv[2], runnerMarker) {
+		return KindRunner
+	}
+	if len(argv) > 0 && filepath.Base(argv[0]) == "firecracker" {
+		if sock := flagValue(argv, "--api-sock"); sock != "" && strings.HasPrefix(sock, runDir+string(filepath.Separator)) {
+			return KindVMM
+		}
+	}
+	return ""
+}
+
+// flagValue returns the value of --name in argv, given as "--name value"
+// or "--name=value".
+func flagValue(argv []string, name string) string {
+	for i, a := range argv {
+		if a == name && i+1 < len(argv) {
+			return argv[i+1]
+		}
+		if v, ok := strings.CutPrefix(a, name+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// process is a running process, as read from the process table.
+type process struct {
+	pid        int
+	ppid       int
+	parentComm string
+	argv       []string
+}
+
+// orphaned reports whether p's parent exited: it was reparented to init,
+// or to the systemd user manager acting as subreaper.
+func (p process) orphaned() bool {
+	return p.ppid == 1 || p.parentComm == "systemd"
+}
+
+func findProcesses(paths *vm.VMPaths) []Item {
+	var items []Item
+	for _, p := range processes() {
+		switch ClassifyCmdline(p.argv, paths.RunDir) {
+		case KindRunner:
+			if p.orphaned() {
+				reason := "dh exited"
+				if port := flagValue(p.argv, "--port"); port != "" {
+					reason = fmt.Sprintf("dh exited (server on port %s)", port)
+				}
+				items = append(items, Item{Kind: KindRunner, PID: p.pid, Reason: reason})
+			}
+		case KindVMM:
+			instanceDir := filepath.Dir(flagValue(p.argv, "--api-sock"))
+			if _, err := os.Stat(instanceDir); err != nil {
+				items = append(items, Item{Kind: KindVMM, PID: p.pid, Reason: "instance directory is gone"})
+			} else if p.orphaned() {
+				items = append(items, Item{Kind: KindVMM, PID: p.pid, Reason: "dh exited"})
+			}
+		}
+	}
+	return items
+}
+
+// findSockets returns the sockets dh creates outside instance directories
+// that nothing listens on: the vsock sockets of snapshots, and the pool
+// daemon's socket.
+func findSockets(paths *vm.VMPaths) []Item {
+	candidates, _ := filepath.Glob(filepath.Join(paths.SnapshotDir, "*", "vsock.sock*"))
+	if p := poolSocketPath(); p != "" {
+		candidates = append(candidates, p)
+	}
+
+	listening := listeningSockets()
+	var items []Item
+	for _, path := range candidates {
+		fi, err := os.Lstat(path)
+		if err != nil || fi.Mode()&os.ModeSocket == 0 || listening[path] {
+			continue
+		}
+		// Not listed as listening. Connecting confirms it, and catches a
+		// socket bound through a different path to the same file.
+		conn, err := net.DialTimeout("unix", path, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			continue
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			items = append(items, Item{Kind: KindSocket, Path: path, Reason: "nothing listens on it"})
+		}
+	}
+	return items
+}
+
+// findPartialInstalls returns version directories that have no meta.toml,
+// which dh install writes last, and haven't changed for
+// partialInstallGrace.
+func findPartialInstalls(dhHome string) []Item {
+	versionsDir := filepath.Join(dhHome, "versions")
+	entries, err := os.ReadDir(versionsDir)
+	if err != nil {
+		return nil
+	}
+	var items []Item
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(versionsDir, e.Name())
+		if _, err := os.Stat(filepath.Join(dir, "meta.toml")); err == nil {
+			continue
+		}
+		if recentlyModified(dir, filepath.Join(dir, ".venv"), filepath.Join(dir, ".venv", "lib")) {
+			continue
+		}
+		items = append(items, Item{Kind: KindPartialInstall, Path: dir, Reason: "install did not finish (no meta.toml)"})
+	}
+	return items
+}
+
+func recentlyModified(paths ...string) bool {
+	return slices.ContainsFunc(paths, func(p string) bool {
+		fi, err := os.Stat(p)
+		return err == nil && time.Since(fi.ModTime()) < partialInstallGrace
+	})
+}
@@ -0,0 +1,100 @@
+//go:build linux
+
+package cleanup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// processes reads the process table from /proc.
+func processes() []process {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var procs []process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		if err != nil || len(cmdline) == 0 {
+			continue // exited, or a kernel thread
+		}
+		ppid := readPPID(pid)
+		procs = append(procs, process{
+			pid:        pid,
+			ppid:       ppid,
+			parentComm: readComm(ppid),
+			argv:       strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00"),
+		})
+	}
+	return procs
+}
+
+// readPPID reads the parent PID from /proc/<pid>/stat. The command name
+// in parentheses may hold spaces, so fields are counted after the last ')'.
+func readPPID(pid int) int {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	s := string(data)
+	fields := strings.Fields(s[strings.LastIndexByte(s, ')')+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+func readComm(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// killProcess kills pid's process group if it leads one, as the runner
+// does, or else just pid.
+func killProcess(pid int) error {
+	if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid {
+		return syscall.Kill(-pid, syscall.SIGKILL)
+	}
+	return syscall.Kill(pid, syscall.SIGKILL)
+}
+
+// listeningSockets returns the paths of the listening Unix sockets in
+// /proc/net/unix.
+func listeningSockets() map[string]bool {
+	f, err := os.Open("/proc/net/unix")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	const listening = "00010000" // __SO_ACCEPTCON
+	paths := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 8 && fields[3] == listening {
+			paths[fields[7]] = true
+		}
+	}
+	return paths
+}
+
+func poolSocketPath() string {
+	return vm.PoolSocketPath()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package cleanup
+
+import "errors"
+
+func processes() []process { return nil }
+
+func killProcess(int) error { return errors.New("not supported on this platform") }
+
+func listeningSockets() map[string]bool { return nil }
+
+func poolSocketPath() string { return "" }
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/credentials"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// defaultServerPort is the port of a server named without one, as for
+// --port.
+const defaultServerPort = 10000
+
+func addAuthCommands(parent *cobra.Command) {
+	authCmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Store server auth tokens",
+		Long: `Store the auth tokens of remote servers, so dh exec, dh repl, dh diff and
+dh notebook --host need no --auth-token, which shell history and process
+listings would show.
+
+Tokens go to the OS keychain, or to ~/.dh/credentials.toml (readable only
+by you) where there is none; DH_CREDENTIALS_STORE=file always uses the
+file. The file is encrypted with a key derived from
+DH_CREDENTIALS_PASSPHRASE when it is set, or else with a random key in
+~/.dh/credentials.key. A token is stored for a server, HOST:PORT, and used for it whatever
+--host names it; a token from --auth-token or [hosts.NAME] comes first.
+
+Examples:
+  dh auth login prod                        # [hosts.prod]; prompts for the token
+  echo "$TOKEN" | dh auth login dh.example.com:8443
+  dh auth logout prod`,
+	}
+
+	loginCmd := &cobra.Command{
+		Use:   "login <HOST>",
+		Short: "Store the auth token of a server",
+		Long:  "Store the auth token of HOST: a [hosts.NAME] alias, or HOST[:PORT] (port 10000 by default). The token is read from stdin, with a prompt that doesn't echo it when stdin is a terminal.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runAuthLogin,
+	}
+
+	logoutCmd := &cobra.Command{
+		Use:   "logout <HOST>",
+		Short: "Delete the stored auth token of a server",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runAuthLogout,
+	}
+
+	authCmd.AddCommand(loginCmd, logoutCmd)
+	parent.AddCommand(authCmd)
+}
+
+// authServer returns the credentials key of arg: a [hosts.NAME] alias, or
+// HOST[:PORT].
+func authServer(arg string) (string, error) {
+	cfg, _, err := config.LoadEffective()
+	if err != nil {
+		return "", err
+	}
+	if h, ok := cfg.Hosts[arg]; ok && h.Host != "" {
+		port := h.Port
+		if port == 0 {
+			port = defaultServerPort
+		}
+		return credentials.Key(h.Host, port), nil
+	}
+	host, portStr, err := net.SplitHostPort(arg)
+	if err != nil {
+		return credentials.Key(arg, defaultServerPort), nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", fmt.Errorf("invalid port in %q", arg)
+	}
+	return credentials.Key(host, port), nil
+}
+
+// readToken reads a token from stdin, prompting without echo when stdin is
+// a terminal.
+func readToken(cmd *cobra.Command, server string) (string, error) {
+	var token string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Token for %s: ", server)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(cmd.ErrOrStderr())
+		if err != nil {
+			return "", fmt.Errorf("reading token: %w", err)
+		}
+		token = string(b)
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("reading token: %w", err)
+		}
+		token = string(b)
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("no token given")
+	}
+	return token, nil
+}
+
+func authError(cmd *cobra.Command, err error) error {
+	if output.IsJSON() {
+		return output.PrintError(cmd.ErrOrStderr(), "auth_error", err.Error())
+	}
+	return err
+}
+
+func runAuthLogin(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	server, err := authServer(args[0])
+	if err != nil {
+		return authError(cmd, err)
+	}
+	token, err := readToken(cmd, server)
+	if err != nil {
+		return authError(cmd, err)
+	}
+	store, err := credentials.Set(config.DHHome(), server, token)
+	if err != nil {
+		return authError(cmd, err)
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"server": server,
+			"store":  store,
+			"status": "saved",
+		})
+	}
+	if !output.IsQuiet() {
+		where := "the OS keychain"
+		if store == credentials.StoreFile {
+			where = credentials.Path(config.DHHome())
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Saved the token for %s in %s\n", server, where)
+	}
+	return nil
+}
+
+func runAuthLogout(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	server, err := authServer(args[0])
+	if err != nil {
+		return authError(cmd, err)
+	}
+	found, err := credentials.Delete(config.DHHome(), server)
+	if err != nil {
+		return authError(cmd, err)
+	}
+
+	if output.IsJSON() {
+		status := "removed"
+		if !found {
+			status = "not_found"
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"server": server,
+			"status": status,
+		})
+	}
+	if !output.IsQuiet() {
+		if found {
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed the token for %s\n", server)
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "No token stored for %s\n", server)
+		}
+	}
+	return nil
+}
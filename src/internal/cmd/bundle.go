@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/dsmmcken/dh-cli/src/internal/bundle"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bundleOutputFlag     string
+	bundlePythonFlag     string
+	bundleNoPluginsFlag  bool
+	bundleWithJavaFlag   bool
+	bundleJDKVersionFlag int
+	bundleWithVMFlag     bool
+)
+
+func addBundleCommands(parent *cobra.Command) {
+	bundleCmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Build and install offline install bundles",
+		Long: `Build and install offline install bundles for air-gapped machines.
+
+On a machine with network access, 'dh bundle create' downloads the wheels
+for a Deephaven version and copies the uv binary into a single tar, with
+an optional Temurin JDK and the Firecracker binary and kernel for VM mode.
+Copy the tar across and run 'dh bundle install' to install from it.
+
+Bundles are platform specific: build on the same OS and architecture, and
+with the same Python version, as the target machine.`,
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create <VERSION>",
+		Short: "Build an offline bundle for a version",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runBundleCreate,
+	}
+	createCmd.Flags().StringVarP(&bundleOutputFlag, "output", "o", "", "Bundle file to write (default dh-bundle-<VERSION>-<os>-<arch>.tar)")
+	createCmd.Flags().StringVar(&bundlePythonFlag, "python", "3.13", "Python version the wheels are for")
+	createCmd.Flags().BoolVar(&bundleNoPluginsFlag, "no-plugins", false, "Leave out the default plugins")
+	createCmd.Flags().BoolVar(&bundleWithJavaFlag, "with-java", false, "Include a Temurin JDK")
+	createCmd.Flags().IntVar(&bundleJDKVersionFlag, "jdk-version", 21, "JDK major version to include with --with-java")
+	createCmd.Flags().BoolVar(&bundleWithVMFlag, "with-vm", false, "Include the Firecracker binary and kernel for VM mode (Linux only)")
+
+	installCmd := &cobra.Command{
+		Use:   "install <BUNDLE>",
+		Short: "Install a version from an offline bundle",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runBundleInstall,
+	}
+
+	bundleCmd.AddCommand(createCmd, installCmd)
+	parent.AddCommand(bundleCmd)
+}
+
+func runBundleCreate(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	version := args[0]
+	if versions.IsConstraint(version) {
+		matched, err := versions.FetchMatchingVersion(version)
+		if err != nil {
+			if output.IsJSON() {
+				return output.PrintError(cmd.ErrOrStderr(), "version_error", err.Error())
+			}
+			return err
+		}
+		version = matched
+	}
+
+	cfg, _, err := config.LoadEffective()
+	if err != nil {
+		return err
+	}
+	var plugins []string
+	if !bundleNoPluginsFlag {
+		plugins = cfg.Install.Plugins
+		if len(plugins) == 0 {
+			plugins = []string{
+				"deephaven-plugin-ui",
+				"deephaven-plugin-plotly-express",
+			}
+		}
+	}
+	pythonVer := bundlePythonFlag
+	if pythonVer == "3.13" && cfg.Install.PythonVersion != "" {
+		pythonVer = cfg.Install.PythonVersion
+	}
+
+	out := bundleOutputFlag
+	if out == "" {
+		out = fmt.Sprintf("dh-bundle-%s-%s-%s.tar", version, runtime.GOOS, runtime.GOARCH)
+	}
+
+	opts := bundle.CreateOptions{
+		DHHome:  dhHome,
+		Version: version,
+		Python:  pythonVer,
+		Plugins: plugins,
+		Tool:    Version,
+		WithVM:  bundleWithVMFlag,
+	}
+	if bundleWithJavaFlag {
+		opts.JDKVersion = bundleJDKVersionFlag
+	}
+
+	onProgress := func(msg string) {
+		if !output.IsQuiet() {
+			fmt.Fprintln(cmd.ErrOrStderr(), msg)
+		}
+	}
+	m, err := bundle.Create(context.Background(), opts, out, onProgress, cmd.ErrOrStderr())
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "bundle_error", err.Error())
+		}
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"path":     out,
+			"manifest": m,
+		})
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote bundle for Deephaven %s to %s (%d wheels)\n", m.Version, out, m.Wheels)
+	return nil
+}
+
+func runBundleInstall(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	onProgress := func(msg string) {
+		if !output.IsQuiet() {
+			fmt.Fprintln(cmd.ErrOrStderr(), msg)
+		}
+	}
+	res, err := bundle.Install(context.Background(), dhHome, args[0], onProgress, cmd.ErrOrStderr())
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "bundle_error", err.Error())
+		}
+		return err
+	}
+	version := res.Manifest.Version
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	setAsDefault := false
+	if cfg.DefaultVersion == "" {
+		cfg.DefaultVersion = version
+		if err := config.Save(cfg); err != nil {
+			return fmt.Errorf("saving config: %w", err)
+		}
+		setAsDefault = true
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"version":           version,
+			"bundle_dir":        res.Dir,
+			"version_installed": res.VersionInstalled,
+			"java_installed":    res.JavaInstalled,
+			"vm_installed":      res.VMInstalled,
+			"set_as_default":    setAsDefault,
+		})
+	}
+
+	if res.VersionInstalled {
+		fmt.Fprintf(cmd.OutOrStdout(), "Installed Deephaven %s from bundle\n", version)
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "Deephaven %s was already installed; bundle unpacked to %s\n", version, res.Dir)
+	}
+	if res.JavaInstalled {
+		fmt.Fprintf(cmd.OutOrStdout(), "Installed bundled JDK %d\n", res.Manifest.JDK)
+	}
+	if res.VMInstalled {
+		fmt.Fprintln(cmd.OutOrStdout(), "Installed Firecracker and kernel for VM mode")
+	}
+	if setAsDefault {
+		fmt.Fprintln(cmd.OutOrStdout(), "Set as default version.")
+	}
+	return nil
+}
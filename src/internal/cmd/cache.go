@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/cache"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheOlderThanFlag string
+	cacheDryRunFlag    bool
+)
+
+func addCacheCommands(parent *cobra.Command) {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "List and clear cached data",
+		Long: `List and clear the data dh keeps only to save time or downloads:
+
+  uv            uv package cache used to build the version venvs
+  metadata      fetched release notes and JVM flag checks
+  vm-downloads  Firecracker binary and guest kernel
+  vm-rootfs     VM root filesystem images
+  snapshots     VM snapshots
+
+Everything is recreated when needed. Installed versions and your config
+are never touched.`,
+		Args: cobra.NoArgs,
+		RunE: runCacheList,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Show the size of each cache",
+		Args:  cobra.NoArgs,
+		RunE:  runCacheList,
+	}
+
+	cleanCmd := &cobra.Command{
+		Use:   "clean [CACHE...]",
+		Short: "Remove cached data",
+		Long: `Remove cached data: the named caches, or all of them.
+
+With --older-than, only entries not modified within that age are
+removed (a snapshot or rootfs per version, a file of metadata, the whole
+uv cache). Ages are like 30d, 2w or 12h.
+
+Examples:
+  dh cache clean snapshots
+  dh cache clean --older-than 30d
+  dh cache clean uv metadata --dry-run`,
+		RunE: runCacheClean,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			var names []string
+			for _, c := range cache.Categories {
+				names = append(names, withDesc(c.Name, c.Description))
+			}
+			return names, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+	cleanCmd.Flags().StringVar(&cacheOlderThanFlag, "older-than", "", "Only remove entries not modified within this age (e.g. 30d)")
+	cleanCmd.Flags().BoolVar(&cacheDryRunFlag, "dry-run", false, "Show what would be removed without removing it")
+
+	cacheCmd.AddCommand(listCmd, cleanCmd)
+	parent.AddCommand(cacheCmd)
+}
+
+func runCacheList(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	type row struct {
+		cache.Category
+		SizeBytes int64    `json:"size_bytes"`
+		Entries   int      `json:"entries"`
+		Paths     []string `json:"paths"`
+	}
+	var rows []row
+	var total int64
+	for i := range cache.Categories {
+		c := &cache.Categories[i]
+		r := row{Category: *c, Paths: []string{}}
+		for _, e := range c.Entries(dhHome) {
+			r.SizeBytes += e.SizeBytes
+			r.Entries++
+			r.Paths = append(r.Paths, e.Path)
+		}
+		total += r.SizeBytes
+		rows = append(rows, r)
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"caches":      rows,
+			"total_bytes": total,
+		})
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CACHE\tSIZE\tENTRIES\tDESCRIPTION")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", r.Name, cache.FormatSize(r.SizeBytes), r.Entries, r.Description)
+	}
+	fmt.Fprintf(w, "total\t%s\t\t\n", cache.FormatSize(total))
+	return w.Flush()
+}
+
+func runCacheClean(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	fail := func(err error) error {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "cache_error", err.Error())
+		}
+		return err
+	}
+
+	var cutoff time.Time
+	if cacheOlderThanFlag != "" {
+		age, err := cache.ParseAge(cacheOlderThanFlag)
+		if err != nil {
+			return fail(err)
+		}
+		cutoff = time.Now().Add(-age)
+	}
+
+	categories := []*cache.Category{}
+	if len(args) == 0 {
+		for i := range cache.Categories {
+			categories = append(categories, &cache.Categories[i])
+		}
+	}
+	for _, name := range args {
+		c, err := cache.Lookup(name)
+		if err != nil {
+			return fail(err)
+		}
+		categories = append(categories, c)
+	}
+
+	removed := []cache.Entry{}
+	var freed int64
+	for _, c := range categories {
+		for _, e := range c.Entries(dhHome) {
+			if !cutoff.IsZero() && e.Modified.After(cutoff) {
+				continue
+			}
+			if !cacheDryRunFlag {
+				if err := c.Remove(e); err != nil {
+					return fail(fmt.Errorf("removing %s: %w", e.Path, err))
+				}
+			}
+			removed = append(removed, e)
+			freed += e.SizeBytes
+			if output.IsVerbose() && !cacheDryRunFlag {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Removed %s (%s)\n", e.Path, cache.FormatSize(e.SizeBytes))
+			}
+		}
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"removed":     removed,
+			"freed_bytes": freed,
+			"dry_run":     cacheDryRunFlag,
+		})
+	}
+	if cacheDryRunFlag {
+		fmt.Fprintf(cmd.OutOrStdout(), "Would free %s from %s\n", cache.FormatSize(freed), cacheEntries(len(removed)))
+		for _, e := range removed {
+			fmt.Fprintf(cmd.OutOrStdout(), "  %s (%s)\n", e.Path, cache.FormatSize(e.SizeBytes))
+		}
+		return nil
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Freed %s from %s\n", cache.FormatSize(freed), cacheEntries(len(removed)))
+	return nil
+}
+
+func cacheEntries(n int) string {
+	if n == 1 {
+		return "1 entry"
+	}
+	return fmt.Sprintf("%d entries", n)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dsmmcken/dh-cli/src/internal/cleanup"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var cleanupForceFlag bool
+
+func addCleanupCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Find and remove processes and files left behind by dh",
+		Long: `Find what dh leaves behind when it is killed or crashes:
+
+  runner-process   dh exec/serve runner (and its server) whose dh exited
+  vmm-process      Firecracker VM whose dh exited, or whose run directory is gone
+  instance-dir     VM run directory of a VM that is gone
+  socket           vsock or pool daemon socket nothing listens on
+  partial-install  version directory of an install that did not finish
+
+Without --force the leftovers are only listed. Every dh command already
+removes instance directories and sockets quietly when it starts.`,
+		Args: cobra.NoArgs,
+		RunE: runCleanup,
+	}
+	cmd.Flags().BoolVar(&cleanupForceFlag, "force", false, "Kill the processes and remove the files")
+	parent.AddCommand(cmd)
+}
+
+func runCleanup(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	items := cleanup.Find(config.DHHome())
+	if items == nil {
+		items = []cleanup.Item{}
+	}
+
+	var failed []string
+	if cleanupForceFlag {
+		for _, it := range items {
+			if err := cleanup.Remove(it); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", it, err))
+			} else if output.IsVerbose() {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Removed %s %s\n", it.Kind, it)
+			}
+		}
+	}
+
+	if output.IsJSON() {
+		if len(failed) > 0 {
+			output.PrintError(os.Stderr, "cleanup_error", fmt.Sprintf("could not remove %s", failed[0]))
+			os.Exit(output.ExitError)
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"leftovers": items,
+			"removed":   cleanupForceFlag,
+		})
+	}
+
+	if len(items) == 0 {
+		if !output.IsQuiet() {
+			fmt.Fprintln(cmd.OutOrStdout(), "Nothing to clean up.")
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tPROCESS/PATH\tREASON")
+	for _, it := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", it.Kind, it, it.Reason)
+	}
+	w.Flush()
+
+	if !cleanupForceFlag {
+		fmt.Fprintf(cmd.OutOrStdout(), "\nFound %s. Run 'dh cleanup --force' to remove them.\n", pluralize(len(items), "leftover"))
+		return nil
+	}
+	for _, f := range failed {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Error: could not remove %s\n", f)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "\nRemoved %d of %s.\n", len(items)-len(failed), pluralize(len(items), "leftover"))
+	if len(failed) > 0 {
+		os.Exit(output.ExitError)
+	}
+	return nil
+}
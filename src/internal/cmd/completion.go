@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/completion"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/snippet"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/spf13/cobra"
+)
+
+func addCompletionCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "completion",
+		Short: "Generate shell completion scripts",
+		Long: `Generate the completion script for a shell, or install it with
+dh completion install.
+
+Besides commands and flags, the scripts complete installed versions,
+host aliases, named servers, snippet names and the ports of running
+servers.
+
+Examples:
+  dh completion install
+  dh completion install fish
+  source <(dh completion bash)`,
+		Args: cobra.NoArgs,
+	}
+
+	for _, shell := range completion.Shells {
+		cmd.AddCommand(&cobra.Command{
+			Use:   shell,
+			Short: fmt.Sprintf("Print the %s completion script", shell),
+			Args:  cobra.NoArgs,
+			RunE: func(c *cobra.Command, args []string) error {
+				script, err := completion.Script(c.Root(), shell)
+				if err != nil {
+					return err
+				}
+				_, err = c.OutOrStdout().Write(script)
+				return err
+			},
+		})
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "install [SHELL]",
+		Short: "Install the completion script for your shell",
+		Long: `Write the completion script where the shell loads it from:
+
+  bash     ~/.local/share/bash-completion/completions/dh
+  zsh      ~/.zfunc/_dh (add ~/.zfunc to fpath)
+  fish     ~/.config/fish/completions/dh.fish
+  nushell  <config dir>/nushell/autoload/dh.nu
+
+SHELL defaults to the shell you are running. Run it again after upgrading
+dh to pick up new commands.`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: cobra.FixedCompletions(completion.Shells, cobra.ShellCompDirectiveNoFileComp),
+		RunE:              runCompletionInstall,
+	})
+
+	parent.AddCommand(cmd)
+}
+
+func runCompletionInstall(cmd *cobra.Command, args []string) error {
+	shell := completion.Detect()
+	if len(args) > 0 {
+		shell = args[0]
+	}
+	if shell == "" {
+		err := fmt.Errorf("could not detect your shell; name it: dh completion install <%s>", strings.Join(completion.Shells, "|"))
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "completion_error", err.Error())
+		}
+		return err
+	}
+
+	path, hint, err := completion.Install(cmd.Root(), shell)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "completion_error", err.Error())
+		}
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"shell": shell,
+			"path":  path,
+		})
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Installed %s completions to %s\n", shell, path)
+	if !output.IsQuiet() {
+		if hint != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "To enable them, %s\n", hint)
+		} else {
+			fmt.Fprintln(cmd.OutOrStdout(), "Start a new shell to use them.")
+		}
+	}
+	return nil
+}
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// registerCompletions adds completions of values to the commands under
+// root: flags by name, and arguments by command.
+func registerCompletions(root *cobra.Command) {
+	flagCompletions := map[string]completionFunc{
+		"version": completeVersions,
+		"host":    completeHosts,
+		"target":  completeTargets,
+		"attach":  completeAttach,
+	}
+	argCompletions := map[string]completionFunc{
+		"dh use":             completeOne(completeVersions),
+		"dh uninstall":       completeOne(completeVersions),
+		"dh versions sbom":   completeOne(completeVersions),
+		"dh bundle create":   completeOne(completeVersions),
+		"dh export-portable": completeOne(completeVersions),
+		"dh kill":            completeOne(completePorts),
+		"dh snippet show":    completeOne(completeSnippets),
+		"dh snippet run":     completeOne(completeSnippets),
+		"dh snippet remove":  completeOne(completeSnippets),
+		"dh snippet export":  completeSnippets,
+		"dh auth login":      completeOne(completeHosts),
+		"dh auth logout":     completeOne(completeHosts),
+	}
+
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		for name, fn := range flagCompletions {
+			if c.LocalNonPersistentFlags().Lookup(name) != nil {
+				_ = c.RegisterFlagCompletionFunc(name, fn)
+			}
+		}
+		if fn, ok := argCompletions[c.CommandPath()]; ok {
+			c.ValidArgsFunction = fn
+		}
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+}
+
+// completeOne completes the first argument with fn, and nothing after it.
+func completeOne(fn completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return fn(cmd, args, toComplete)
+	}
+}
+
+func completeVersions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config.SetConfigDir(ConfigDir)
+	return versions.InstalledNames(config.DHHome()), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+}
+
+func completeHosts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config.SetConfigDir(ConfigDir)
+	eff, _, err := config.LoadEffective()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var out []string
+	for name, h := range eff.Hosts {
+		desc := h.Host
+		if h.Port != 0 {
+			desc += ":" + strconv.Itoa(h.Port)
+		}
+		out = append(out, withDesc(name, desc))
+	}
+	sort.Strings(out)
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeTargets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config.SetConfigDir(ConfigDir)
+	var out []string
+	for _, r := range discovery.Registered(config.DHHome()) {
+		if r.Name != "" {
+			out = append(out, withDesc(r.Name, fmt.Sprintf("port %d", r.Port)))
+		}
+	}
+	sort.Strings(out)
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeSnippets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config.SetConfigDir(ConfigDir)
+	list, err := snippet.List(config.DHHome())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		out = append(out, withDesc(s.Name, s.Description))
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completePorts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config.SetConfigDir(ConfigDir)
+	servers, err := discovery.Discover()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	out := make([]string, 0, len(servers))
+	for _, s := range servers {
+		desc := s.Source
+		if s.Name != "" {
+			desc += " " + s.Name
+		}
+		out = append(out, withDesc(strconv.Itoa(s.Port), desc))
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeAttach completes --attach with the ports of running servers,
+// as :PORT.
+func completeAttach(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	ports, directive := completePorts(cmd, args, toComplete)
+	for i, p := range ports {
+		ports[i] = ":" + p
+	}
+	return ports, directive
+}
+
+// withDesc formats a completion with a description, for the shells that
+// show them.
+func withDesc(value, desc string) string {
+	if desc == "" {
+		return value
+	}
+	return value + "\t" + desc
+}
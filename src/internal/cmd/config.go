@@ -1,7 +1,13 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/dsmmcken/dh-cli/src/internal/config"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
@@ -12,21 +18,76 @@ func addConfigCommands(rootCmd *cobra.Command) {
 	configCmd := &cobra.Command{
 		Use:   "config",
 		Short: "Manage dh configuration",
-		Long:  "Show, get, and set values in the dh config file (~/.dh/config.toml).",
-		Args:  cobra.NoArgs,
+		Long: `Show, get, and set values in the dh config file (~/.dh/config.toml).
+
+A project can override settings in .dh/config.toml, or a [tool.dh] table in
+pyproject.toml, found by walking up from the current directory. 'dh config'
+and 'dh config get' show the settings in effect here; 'dh config set' always
+writes the global file.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			config.SetConfigDir(ConfigDir)
-			cfg, err := config.Load()
+			cfg, proj, err := config.LoadEffective()
 			if err != nil {
 				return err
 			}
+			cfg = cfg.Redacted()
 			if output.IsJSON() {
-				return output.PrintJSON(cmd.OutOrStdout(), cfg)
+				return output.PrintJSON(cmd.OutOrStdout(), struct {
+					*config.Config
+					ProjectConfig *config.ProjectConfig `json:"project_config"`
+				}{cfg, proj})
+			}
+			w := cmd.OutOrStdout()
+			fmt.Fprintf(w, "Config file: %s\n", config.ConfigPath())
+			if proj != nil {
+				fmt.Fprintf(w, "Project config: %s\n", proj.Path)
+			}
+			fmt.Fprintf(w, "default_version = %s\n", cfg.DefaultVersion)
+			fmt.Fprintf(w, "backend = %s\n", cfg.Backend)
+			fmt.Fprintf(w, "install.python_version = %s\n", cfg.Install.PythonVersion)
+			fmt.Fprintf(w, "install.plugins = %v\n", cfg.Install.Plugins)
+			fmt.Fprintf(w, "exec.pythonpath = %v\n", cfg.Exec.PythonPath)
+			fmt.Fprintf(w, "vm.mounts = %v\n", cfg.VM.Mounts)
+			fmt.Fprintf(w, "vm.base_image = %s\n", cfg.VM.BaseImage)
+			fmt.Fprintf(w, "vm.python_version = %s\n", cfg.VM.PythonVersion)
+			fmt.Fprintf(w, "vm.guest_jvm_args = %s\n", cfg.VM.GuestJVMArgs)
+			fmt.Fprintf(w, "vm.auto_gc = %t\n", cfg.VM.AutoGC)
+			fmt.Fprintf(w, "vm.gc_keep_latest = %d\n", cfg.VM.GCKeepLatest)
+			fmt.Fprintf(w, "vm.gc_older_than = %s\n", cfg.VM.GCOlderThan)
+			fmt.Fprintf(w, "vm.backend = %s\n", cfg.VM.Backend)
+			eagerMB := ""
+			if cfg.VM.EagerMB != nil {
+				eagerMB = strconv.Itoa(*cfg.VM.EagerMB)
+			}
+			fmt.Fprintf(w, "vm.eager_mb = %s\n", eagerMB)
+			fmt.Fprintf(w, "vm.no_pool = %t\n", cfg.VM.NoPool)
+			fmt.Fprintf(w, "history.max_entries = %d\n", cfg.History.MaxEntries)
+			fmt.Fprintf(w, "history.dedup = %s\n", cfg.History.Dedup)
+			fmt.Fprintf(w, "history.exclude = %v\n", cfg.History.Exclude)
+			fmt.Fprintf(w, "history.scope = %s\n", cfg.History.Scope)
+			fmt.Fprintf(w, "tui.skip_confirm = %v\n", cfg.TUI.SkipConfirm)
+			names := slices.Sorted(maps.Keys(cfg.Hosts))
+			for _, name := range names {
+				h := cfg.Hosts[name]
+				fmt.Fprintf(w, "hosts.%s = %s", name, h.Host)
+				if h.Port != 0 {
+					fmt.Fprintf(w, " port=%d", h.Port)
+				}
+				if h.AuthType != "" {
+					fmt.Fprintf(w, " auth_type=%s", h.AuthType)
+				}
+				if h.AuthToken != "" {
+					fmt.Fprintf(w, " auth_token=%s", h.AuthToken)
+				}
+				if h.TLS {
+					fmt.Fprint(w, " tls")
+				}
+				fmt.Fprintln(w)
+			}
+			for _, check := range slices.Sorted(maps.Keys(cfg.Doctor.Severity)) {
+				fmt.Fprintf(w, "doctor.severity.%s = %s\n", check, cfg.Doctor.Severity[check])
 			}
-			fmt.Fprintf(cmd.OutOrStdout(), "Config file: %s\n", config.ConfigPath())
-			fmt.Fprintf(cmd.OutOrStdout(), "default_version = %s\n", cfg.DefaultVersion)
-			fmt.Fprintf(cmd.OutOrStdout(), "install.python_version = %s\n", cfg.Install.PythonVersion)
-			fmt.Fprintf(cmd.OutOrStdout(), "install.plugins = %v\n", cfg.Install.Plugins)
 			return nil
 		},
 	}
@@ -73,6 +134,143 @@ func addConfigCommands(rootCmd *cobra.Command) {
 		},
 	}
 
-	configCmd.AddCommand(configGetCmd, configSetCmd, configPathCmd)
+	configExportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the config file",
+		Long: `Print the global config (~/.dh/config.toml) as TOML, or write it to a
+file with -o, to copy settings to another machine or share them with a team.
+--redact replaces secrets such as host auth tokens with "<redacted>".`,
+		Args: cobra.NoArgs,
+		RunE: runConfigExport,
+	}
+	configExportCmd.Flags().StringVarP(&configExportOutputFlag, "output", "o", "", "Write to a file instead of stdout")
+	configExportCmd.Flags().BoolVar(&configExportRedactFlag, "redact", false, "Replace secrets with <redacted>")
+
+	configImportCmd := &cobra.Command{
+		Use:   "import <FILE>",
+		Short: "Import settings from a config file",
+		Long: `Apply the settings in FILE to the global config (~/.dh/config.toml).
+
+With --merge (the default) each setting in FILE replaces the current value
+and everything else is kept; with --replace FILE becomes the whole config.
+The changes are shown as a diff and applied after confirmation. Redacted
+secrets keep their current value.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runConfigImport,
+	}
+	configImportCmd.Flags().BoolVar(&configImportMergeFlag, "merge", false, "Merge FILE into the current config (default)")
+	configImportCmd.Flags().BoolVar(&configImportReplaceFlag, "replace", false, "Replace the current config with FILE")
+	configImportCmd.Flags().BoolVar(&configImportDryRunFlag, "dry-run", false, "Show the diff without applying it")
+	configImportCmd.Flags().BoolVarP(&configImportYesFlag, "yes", "y", false, "Apply without asking for confirmation")
+	configImportCmd.MarkFlagsMutuallyExclusive("merge", "replace")
+
+	configCmd.AddCommand(configGetCmd, configSetCmd, configPathCmd, configExportCmd, configImportCmd)
 	rootCmd.AddCommand(configCmd)
 }
+
+var (
+	configExportOutputFlag  string
+	configExportRedactFlag  bool
+	configImportMergeFlag   bool
+	configImportReplaceFlag bool
+	configImportDryRunFlag  bool
+	configImportYesFlag     bool
+)
+
+func runConfigExport(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	data, err := config.Export(configExportRedactFlag)
+	if err != nil {
+		return err
+	}
+	if configExportOutputFlag == "" {
+		_, err := cmd.OutOrStdout().Write(data)
+		return err
+	}
+	if err := os.WriteFile(configExportOutputFlag, data, 0o600); err != nil {
+		return err
+	}
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"path":     configExportOutputFlag,
+			"redacted": configExportRedactFlag,
+		})
+	}
+	if !output.IsQuiet() {
+		fmt.Fprintf(cmd.OutOrStdout(), "Exported %s to %s\n", config.ConfigPath(), configExportOutputFlag)
+	}
+	return nil
+}
+
+func runConfigImport(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	cur, err := config.Load()
+	if err != nil {
+		return err
+	}
+	next, err := config.PlanImport(cur, data, configImportReplaceFlag)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "config_import_error", err.Error())
+		}
+		return err
+	}
+	diff, err := config.Diff(cur, next)
+	if err != nil {
+		return err
+	}
+
+	mode := "merge"
+	if configImportReplaceFlag {
+		mode = "replace"
+	}
+	apply := len(diff) > 0 && !configImportDryRunFlag
+
+	if output.IsJSON() {
+		if apply {
+			if err := config.Save(next); err != nil {
+				return err
+			}
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"mode":    mode,
+			"diff":    diff,
+			"applied": apply,
+		})
+	}
+
+	w := cmd.OutOrStdout()
+	if len(diff) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return nil
+	}
+	fmt.Fprintf(w, "Changes to %s (%s):\n", config.ConfigPath(), mode)
+	for _, line := range diff {
+		fmt.Fprintln(w, "  "+line)
+	}
+	if !apply {
+		return nil
+	}
+
+	if !configImportYesFlag {
+		fmt.Fprint(cmd.ErrOrStderr(), "Apply these changes? [y/N] ")
+		reader := bufio.NewReader(cmd.InOrStdin())
+		answer, _ := reader.ReadString('\n')
+		answer = strings.TrimSpace(strings.ToLower(answer))
+		if answer != "y" && answer != "yes" {
+			fmt.Fprintln(cmd.ErrOrStderr(), "Cancelled.")
+			return nil
+		}
+	}
+	if err := config.Save(next); err != nil {
+		return err
+	}
+	if !output.IsQuiet() {
+		fmt.Fprintf(w, "Imported %s into %s\n", args[0], config.ConfigPath())
+	}
+	return nil
+}
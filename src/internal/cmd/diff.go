@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/tablediff"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffCompareFlag   bool
+	diffSetupFlag     string
+	diffKeyFlag       []string
+	diffTableFlag     []string
+	diffMaxRowsFlag   int
+	diffFormatFlag    string
+	diffPortFlag      int
+	diffJVMArgsFlag   string
+	diffTimeoutFlag   int
+	diffVersionFlag   string
+	diffHostFlag      string
+	diffAuthTypeFlag  string
+	diffAuthTokenFlag string
+	diffTLSFlag       bool
+	diffVMFlag        bool
+)
+
+func addDiffCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "diff <TABLE_A> <TABLE_B>",
+		Short: "Compare two tables",
+		Long: `Compare two Deephaven tables and show their schema and row differences.
+
+The comparison runs on the server, so only the differences (and a sample
+of the differing rows) are sent back. Rows are matched on all columns
+unless --key names the columns that identify a row; with --key, rows whose
+key is in both tables but whose other values differ are reported as
+changed.
+
+TABLE_A and TABLE_B are tables on the server: on a --host, or created by
+the --setup script. With --compare, the arguments are two scripts instead
+(for example a query before and after a refactor); both are run and the
+tables they assign are compared by name.
+
+Exits with code 1 when there are differences.
+
+Examples:
+  dh diff trades trades_v2 --host prod --key Sym,Timestamp
+  dh diff a b --setup build_tables.py
+  dh diff --compare old_query.py new_query.py --key Id
+  dh diff --compare old.py new.py --table summary --format patch`,
+		Args: cobra.ExactArgs(2),
+		RunE: runDiff,
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&diffCompareFlag, "compare", false, "Arguments are two scripts whose tables are compared by name")
+	flags.StringVar(&diffSetupFlag, "setup", "", "Script to run before comparing, e.g. one that creates the tables")
+	flags.StringSliceVar(&diffKeyFlag, "key", nil, "Columns that identify a row (default: all columns)")
+	flags.StringSliceVar(&diffTableFlag, "table", nil, "With --compare, only compare these tables")
+	flags.IntVar(&diffMaxRowsFlag, "max-rows", 10, "Differing rows to show per kind of difference")
+	flags.StringVar(&diffFormatFlag, "format", "diff", "Output format: diff or patch (JSON patch operations)")
+	flags.IntVar(&diffPortFlag, "port", 10000, "Server port (0 = any free port)")
+	flags.StringVar(&diffJVMArgsFlag, "jvm-args", defaultJVMArgs, "JVM arguments (quoted string)")
+	flags.IntVar(&diffTimeoutFlag, "timeout", 0, "Timeout in seconds (0 = no timeout)")
+	flags.StringVar(&diffVersionFlag, "version", "", "Deephaven version to use")
+	flags.StringVar(&diffHostFlag, "host", "", "Remote server host (enables remote mode)")
+	flags.StringVar(&diffAuthTypeFlag, "auth-type", "", "Authentication type for remote connection")
+	flags.StringVar(&diffAuthTokenFlag, "auth-token", "", "Authentication token for remote connection")
+	flags.BoolVar(&diffTLSFlag, "tls", false, "Use TLS for remote connection")
+	flags.BoolVar(&diffVMFlag, "vm", false, "Run in a Firecracker microVM (experimental, Linux only)")
+
+	parent.AddCommand(cmd)
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	res, err := diffTables(cmd, args)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "diff_error", err.Error())
+		}
+		return err
+	}
+
+	switch {
+	case output.IsJSON():
+		if err := output.PrintJSON(cmd.OutOrStdout(), res); err != nil {
+			return err
+		}
+	case diffFormatFlag == "patch":
+		if err := output.PrintJSON(cmd.OutOrStdout(), res.Patch()); err != nil {
+			return err
+		}
+	default:
+		tablediff.Render(cmd.OutOrStdout(), res, useColor(cmd))
+	}
+	if !res.Equal() {
+		os.Exit(output.ExitError)
+	}
+	return nil
+}
+
+// diffTables runs the comparison selected by the flags and args.
+func diffTables(cmd *cobra.Command, args []string) (*tablediff.Result, error) {
+	if diffFormatFlag != "diff" && diffFormatFlag != "patch" {
+		return nil, fmt.Errorf("invalid --format %q: use diff or patch", diffFormatFlag)
+	}
+	if len(diffTableFlag) > 0 && !diffCompareFlag {
+		return nil, fmt.Errorf("--table requires --compare")
+	}
+	if diffMaxRowsFlag < 0 {
+		return nil, fmt.Errorf("--max-rows must not be negative")
+	}
+
+	opts := tablediff.Options{
+		Left:    args[0],
+		Right:   args[1],
+		Compare: diffCompareFlag,
+		Tables:  diffTableFlag,
+		Key:     diffKeyFlag,
+		MaxRows: diffMaxRowsFlag,
+	}
+	if diffCompareFlag {
+		left, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := os.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		opts.LeftCode, opts.RightCode = string(left), string(right)
+	}
+	code, err := tablediff.Script(opts)
+	if err != nil {
+		return nil, err
+	}
+	if diffSetupFlag != "" {
+		setup, err := os.ReadFile(diffSetupFlag)
+		if err != nil {
+			return nil, err
+		}
+		code = string(setup) + "\n" + code
+	}
+
+	cfg := &dhexec.ExecConfig{
+		Code:         code,
+		Port:         diffPortFlag,
+		JVMArgs:      diffJVMArgsFlag,
+		Timeout:      diffTimeoutFlag,
+		JSONMode:     true,
+		Verbose:      output.IsVerbose(),
+		Quiet:        output.IsQuiet(),
+		Version:      diffVersionFlag,
+		Host:         diffHostFlag,
+		AuthType:     diffAuthTypeFlag,
+		AuthToken:    diffAuthTokenFlag,
+		TLS:          diffTLSFlag,
+		VMMode:       diffVMFlag,
+		ConfigDir:    ConfigDir,
+		ProcessStart: ProcessStart,
+		Stderr:       cmd.ErrOrStderr(),
+		Stdout:       cmd.OutOrStdout(),
+	}
+	// The daemon's servers have JVM settings and ports of their own
+	cfg.NoDaemon = cmd.Flags().Changed("jvm-args") || cmd.Flags().Changed("port")
+	config.SetConfigDir(ConfigDir)
+	eff, _, err := config.LoadEffective()
+	if err != nil {
+		return nil, err
+	}
+	if err := applyHostAlias(cmd, eff, &cfg.Host, &cfg.Port, &cfg.AuthType, &cfg.AuthToken, &cfg.TLS, nil); err != nil {
+		return nil, err
+	}
+	if eff.Backend == "vm" && cfg.Host == "" && !cmd.Flags().Changed("vm") {
+		cfg.VMMode = true
+	}
+
+	_, result, err := dhexec.Run(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if errText, _ := result["error"].(string); errText != "" {
+		return nil, fmt.Errorf("%s", lastLine(errText))
+	}
+	stdout, _ := result["stdout"].(string)
+	return tablediff.Parse(stdout)
+}
+
+// lastLine returns the last non-blank line of a traceback: the exception.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// useColor reports whether output to cmd's stdout should be colored: it
+// is a terminal and neither --no-color nor NO_COLOR is set.
+func useColor(cmd *cobra.Command) bool {
+	if noColorFlag {
+		return false
+	}
+	f, ok := cmd.OutOrStdout().(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
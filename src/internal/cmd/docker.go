@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/image"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dockerVersionFlag   string
+	dockerTagFlag       string
+	dockerPackageFlag   []string
+	dockerNoPluginsFlag bool
+	dockerAddFlag       []string
+	dockerExitFlag      bool
+	dockerPortFlag      int
+	dockerJVMArgsFlag   string
+	dockerPushFlag      bool
+	dockerContextFlag   string
+)
+
+func addDockerCommands(parent *cobra.Command) {
+	dockerCmd := &cobra.Command{
+		Use:   "docker",
+		Short: "Build container images of a Deephaven environment",
+	}
+
+	buildCmd := &cobra.Command{
+		Use:   "build [SCRIPT]",
+		Short: "Build a container image that serves Deephaven or runs a script",
+		Long: `Build an OCI image with Docker that runs a Deephaven version the way dh
+does locally: the server, the plugins from install.plugins in the config
+(or the default plugins), any --package, and an entrypoint.
+
+Without SCRIPT the container just serves. With SCRIPT it runs the script
+on start and keeps serving, like dh serve; with --exit it stops when the
+script finishes, for batch jobs. --add copies more files from the
+script's directory (modules it imports, data), keeping their layout.
+
+The container listens on --port. DH_JVM_ARGS and DH_PORT can be set on
+the container to override the defaults.
+
+Examples:
+  dh docker build
+  dh docker build dashboard.py --add lib/ -t registry.example.com/dash:1 --push
+  dh docker build nightly.py --exit --package polars
+  dh docker build dashboard.py --context build/`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runDockerBuild,
+	}
+	flags := buildCmd.Flags()
+	flags.StringVar(&dockerVersionFlag, "version", "", "Deephaven version to put in the image")
+	flags.StringVarP(&dockerTagFlag, "tag", "t", "", "Image tag (default dh-<script>:<version>, or dh-server:<version>)")
+	flags.StringArrayVar(&dockerPackageFlag, "package", nil, "Extra pip package to install (repeatable)")
+	flags.BoolVar(&dockerNoPluginsFlag, "no-plugins", false, "Leave out the plugins")
+	flags.StringArrayVar(&dockerAddFlag, "add", nil, "File or directory next to the script to copy into the image (repeatable)")
+	flags.BoolVar(&dockerExitFlag, "exit", false, "Exit when the script finishes instead of serving")
+	flags.IntVar(&dockerPortFlag, "port", image.DefaultPort, "Port the server listens on in the container")
+	flags.StringVar(&dockerJVMArgsFlag, "jvm-args", image.DefaultJVMArgs, "JVM arguments (quoted string)")
+	flags.BoolVar(&dockerPushFlag, "push", false, "Push the image after building it")
+	flags.StringVar(&dockerContextFlag, "context", "", "Write the build context to DIR instead of building")
+
+	dockerCmd.AddCommand(buildCmd)
+	parent.AddCommand(dockerCmd)
+}
+
+func runDockerBuild(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+
+	fail := func(err error) error {
+		if output.IsJSON() {
+			output.PrintError(cmd.ErrOrStderr(), "docker_error", err.Error())
+			os.Exit(output.ExitError)
+		}
+		return err
+	}
+
+	opts := image.Options{
+		Port:    dockerPortFlag,
+		JVMArgs: dockerJVMArgsFlag,
+		Tool:    Version,
+		Exit:    dockerExitFlag,
+	}
+	if len(args) == 1 {
+		if fi, err := os.Stat(args[0]); err != nil {
+			return fail(fmt.Errorf("reading script: %w", err))
+		} else if fi.IsDir() {
+			return fail(fmt.Errorf("%s is a directory, not a script", args[0]))
+		}
+		opts.Script = args[0]
+		opts.Files = dockerAddFlag
+	} else if len(dockerAddFlag) > 0 || dockerExitFlag {
+		return fail(fmt.Errorf("--add and --exit need a SCRIPT"))
+	}
+
+	version := dockerVersionFlag
+	if versions.IsConstraint(version) {
+		matched, err := versions.FetchMatchingVersion(version)
+		if err != nil {
+			if output.IsJSON() {
+				return output.PrintError(cmd.ErrOrStderr(), "version_error", err.Error())
+			}
+			return err
+		}
+		version = matched
+	} else if version == "" {
+		v, err := config.ResolveVersion("", os.Getenv("DH_VERSION"))
+		if err != nil {
+			return fail(err)
+		}
+		version = v
+	}
+	opts.Version = version
+
+	cfg, _, err := config.LoadEffective()
+	if err != nil {
+		return err
+	}
+	if !dockerNoPluginsFlag {
+		opts.Packages = cfg.Install.Plugins
+		if len(opts.Packages) == 0 {
+			opts.Packages = vm.DefaultPlugins
+		}
+	}
+	opts.Packages = append(append([]string{}, opts.Packages...), dockerPackageFlag...)
+
+	tag := dockerTagFlag
+	if tag == "" {
+		tag = image.DefaultTag(opts)
+	}
+
+	if dockerContextFlag != "" {
+		if err := image.WriteContext(dockerContextFlag, opts); err != nil {
+			return fail(err)
+		}
+		if output.IsJSON() {
+			return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+				"version":  version,
+				"packages": opts.Packages,
+				"script":   opts.Script,
+				"context":  dockerContextFlag,
+			})
+		}
+		if !output.IsQuiet() {
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote build context for Deephaven %s to %s\n", version, dockerContextFlag)
+			fmt.Fprintf(cmd.OutOrStdout(), "Build it with: docker build -t %s %s\n", tag, dockerContextFlag)
+		}
+		return nil
+	}
+
+	if !output.IsQuiet() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Building image %s (Deephaven %s)...\n", tag, version)
+	}
+	if err := image.Build(opts, tag, cmd.ErrOrStderr()); err != nil {
+		return fail(err)
+	}
+	if dockerPushFlag {
+		if !output.IsQuiet() {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Pushing %s...\n", tag)
+		}
+		if err := image.Push(tag, cmd.ErrOrStderr()); err != nil {
+			return fail(err)
+		}
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"tag":      tag,
+			"version":  version,
+			"packages": opts.Packages,
+			"script":   opts.Script,
+			"pushed":   dockerPushFlag,
+		})
+	}
+	if output.IsQuiet() {
+		return nil
+	}
+	verb := "Built"
+	if dockerPushFlag {
+		verb = "Built and pushed"
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", verb, tag)
+	fmt.Fprintf(cmd.OutOrStdout(), "Run it with: docker run --rm -p %d:%d %s\n", dockerPortFlag, dockerPortFlag, tag)
+	return nil
+}
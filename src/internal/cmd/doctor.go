@@ -2,42 +2,80 @@ package cmd
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/dsmmcken/dh-cli/src/internal/config"
 	"github.com/dsmmcken/dh-cli/src/internal/java"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
 	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
 )
 
-var fixFlag bool
+var (
+	fixFlag            bool
+	doctorCIFlag       bool
+	doctorStrictFlag   bool
+	doctorOnlyFlag     []string
+	doctorSeverityFlag []string
+)
 
 func addDoctorCommand(parent *cobra.Command) {
 	doctorCmd := &cobra.Command{
 		Use:   "doctor",
 		Short: "Check environment health",
-		Long:  "Run diagnostic checks across all subsystems and report environment health.",
-		Args:  cobra.NoArgs,
-		RunE:  runDoctor,
+		Long: `Run diagnostic checks across all subsystems and report environment health.
+
+For CI pipelines, --ci exits with code 1 when a check fails and prints a
+GitHub Actions annotation for each failure. --strict counts warnings as
+failures. --only runs a subset of the checks (uv, java, versions, default,
+disk, vm); the vm check only runs when named. --severity CHECK=LEVEL, or
+[doctor.severity] in config.toml, makes a check's failure an error or a
+warning.`,
+		Args: cobra.NoArgs,
+		RunE: runDoctor,
 	}
 
 	doctorCmd.Flags().BoolVar(&fixFlag, "fix", false, "Attempt to auto-fix problems")
+	doctorCmd.Flags().BoolVar(&doctorCIFlag, "ci", false, "Exit 1 on failure and print GitHub Actions annotations")
+	doctorCmd.Flags().BoolVar(&doctorStrictFlag, "strict", false, "Count warnings as failures")
+	doctorCmd.Flags().StringSliceVar(&doctorOnlyFlag, "only", nil, "Run only these checks (uv, java, versions, default, disk, vm)")
+	doctorCmd.Flags().StringSliceVar(&doctorSeverityFlag, "severity", nil, "Override a check's failure severity, as CHECK=error or CHECK=warning")
 
 	parent.AddCommand(doctorCmd)
 }
 
 // CheckResult holds the result of a single doctor check.
 type CheckResult struct {
-	Name   string `json:"name"`
-	Status string `json:"status"` // "ok", "warning", "error"
-	Detail string `json:"detail"`
+	Name   string      `json:"name"`
+	Status string      `json:"status"` // "ok", "warning", "error"
+	Detail string      `json:"detail"`
+	Fixes  []FixAction `json:"fixes,omitempty"` // remediations for a failed check, best first
+}
+
+// FixAction is a machine-readable remediation for a failed check, so
+// scripts and editors can apply it without parsing Detail.
+type FixAction struct {
+	Description string `json:"description"`  // what the fix does, e.g. "install Java"
+	Command     string `json:"command"`      // shell command that applies it
+	AutoFixable bool   `json:"auto_fixable"` // true if 'dh doctor --fix' applies it
+	Privileges  string `json:"privileges"`   // PrivilegesUser or PrivilegesAdmin
 }
 
+// Privileges a FixAction needs.
+const (
+	PrivilegesUser  = "user"  // runs as the current user
+	PrivilegesAdmin = "admin" // needs root, e.g. via sudo
+)
+
+const uvInstallCommand = "curl -LsSf https://astral.sh/uv/install.sh | sh"
+
 // DoctorReport holds the complete doctor output.
 type DoctorReport struct {
 	Healthy bool          `json:"healthy"`
@@ -51,23 +89,62 @@ var (
 	VersionsChecker       = checkVersions
 	DefaultVersionChecker = checkDefaultVersion
 	DiskSpaceChecker      = checkDiskSpace
+	VMChecker             = checkVM
 )
 
+// doctorCheck is a check as selected by --only.
+type doctorCheck struct {
+	id    string // name for --only and doctor.severity
+	optIn bool   // runs only when named with --only
+	run   func(dhHome string) CheckResult
+}
+
+// doctorChecks returns the checks in report order.
+func doctorChecks() []doctorCheck {
+	return []doctorCheck{
+		{id: "uv", run: func(string) CheckResult { return UVChecker() }},
+		{id: "java", run: JavaChecker},
+		{id: "versions", run: VersionsChecker},
+		{id: "default", run: DefaultVersionChecker},
+		{id: "disk", run: DiskSpaceChecker},
+		{id: "vm", optIn: true, run: VMChecker},
+	}
+}
+
 func runDoctor(cmd *cobra.Command, args []string) error {
 	config.SetConfigDir(ConfigDir)
 	dhHome := config.DHHome()
 
-	checks := []CheckResult{
-		UVChecker(),
-		JavaChecker(dhHome),
-		VersionsChecker(dhHome),
-		DefaultVersionChecker(dhHome),
-		DiskSpaceChecker(dhHome),
+	severity, err := doctorSeverities()
+	if err == nil {
+		err = checkDoctorOnly(doctorOnlyFlag)
+	}
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "invalid_flag", err.Error())
+		}
+		return err
+	}
+
+	var checks []CheckResult
+	for _, dc := range doctorChecks() {
+		if len(doctorOnlyFlag) > 0 {
+			if !slices.Contains(doctorOnlyFlag, dc.id) {
+				continue
+			}
+		} else if dc.optIn {
+			continue
+		}
+		c := dc.run(dhHome)
+		if sev, ok := severity[dc.id]; ok && c.Status != "ok" {
+			c.Status = sev
+		}
+		checks = append(checks, c)
 	}
 
 	healthy := true
 	for _, c := range checks {
-		if c.Status == "error" {
+		if c.Status == "error" || (doctorStrictFlag && c.Status == "warning") {
 			healthy = false
 			break
 		}
@@ -79,7 +156,17 @@ func runDoctor(cmd *cobra.Command, args []string) error {
 	}
 
 	if output.IsJSON() {
-		return output.PrintJSON(cmd.OutOrStdout(), report)
+		if err := output.PrintJSON(cmd.OutOrStdout(), report); err != nil {
+			return err
+		}
+		if doctorCIFlag && !healthy {
+			os.Exit(output.ExitError)
+		}
+		return nil
+	}
+
+	if doctorCIFlag || output.IsAnnotate() {
+		printAnnotations(cmd, checks)
 	}
 
 	// Human output
@@ -123,9 +210,62 @@ func runDoctor(cmd *cobra.Command, args []string) error {
 		runFixes(cmd, checks, dhHome)
 	}
 
+	if doctorCIFlag && !healthy {
+		os.Exit(output.ExitError)
+	}
 	return nil
 }
 
+// doctorSeverities merges doctor.severity from the config with --severity
+// flags, which win.
+func doctorSeverities() (map[string]string, error) {
+	cfg, _, err := config.LoadEffective()
+	if err != nil {
+		return nil, err
+	}
+	sev := &config.Config{Doctor: config.Doctor{Severity: map[string]string{}}}
+	maps.Copy(sev.Doctor.Severity, cfg.Doctor.Severity)
+	for _, s := range doctorSeverityFlag {
+		check, level, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --severity %q: use CHECK=error or CHECK=warning", s)
+		}
+		sev.Doctor.Severity[strings.ToLower(check)] = strings.ToLower(level)
+	}
+	if err := sev.Validate(); err != nil {
+		return nil, err
+	}
+	return sev.Doctor.Severity, nil
+}
+
+func checkDoctorOnly(only []string) error {
+	for _, id := range only {
+		if !slices.Contains(config.DoctorChecks, id) {
+			return fmt.Errorf("unknown check %q for --only: use %s", id, strings.Join(config.DoctorChecks, ", "))
+		}
+	}
+	return nil
+}
+
+// printAnnotations prints a GitHub Actions annotation for each failed
+// check, with its first fix.
+func printAnnotations(cmd *cobra.Command, checks []CheckResult) {
+	for _, c := range checks {
+		level := c.Status
+		if level == "ok" {
+			continue
+		}
+		if doctorStrictFlag {
+			level = "error"
+		}
+		msg := c.Detail
+		if len(c.Fixes) > 0 {
+			msg += fmt.Sprintf(" (fix: run '%s' to %s)", c.Fixes[0].Command, c.Fixes[0].Description)
+		}
+		output.Annotate(cmd.OutOrStdout(), output.Annotation{Level: level, Title: "dh doctor: " + c.Name, Message: msg})
+	}
+}
+
 func pluralize(n int, word string) string {
 	if n == 1 {
 		return fmt.Sprintf("%d %s", n, word)
@@ -140,6 +280,7 @@ func checkUV() CheckResult {
 			Name:   "uv",
 			Status: "error",
 			Detail: "not found in PATH",
+			Fixes:  []FixAction{{Description: "install uv", Command: uvInstallCommand, Privileges: PrivilegesUser}},
 		}
 	}
 
@@ -149,6 +290,7 @@ func checkUV() CheckResult {
 			Name:   "uv",
 			Status: "error",
 			Detail: fmt.Sprintf("found at %s but failed to get version", uvPath),
+			Fixes:  []FixAction{{Description: "reinstall uv", Command: uvInstallCommand, Privileges: PrivilegesUser}},
 		}
 	}
 
@@ -163,6 +305,9 @@ func checkUV() CheckResult {
 	}
 }
 
+// javaInstallFix installs a JDK under ~/.dh.
+var javaInstallFix = FixAction{Description: "install Java", Command: "dh java install", Privileges: PrivilegesUser}
+
 func checkJava(dhHome string) CheckResult {
 	info, err := java.Detect(dhHome)
 	if err != nil {
@@ -170,6 +315,7 @@ func checkJava(dhHome string) CheckResult {
 			Name:   "Java",
 			Status: "error",
 			Detail: fmt.Sprintf("detection failed: %s", err),
+			Fixes:  []FixAction{javaInstallFix},
 		}
 	}
 
@@ -178,6 +324,7 @@ func checkJava(dhHome string) CheckResult {
 			Name:   "Java",
 			Status: "error",
 			Detail: "not found",
+			Fixes:  []FixAction{javaInstallFix},
 		}
 	}
 
@@ -186,6 +333,7 @@ func checkJava(dhHome string) CheckResult {
 			Name:   "Java",
 			Status: "warning",
 			Detail: fmt.Sprintf("%s (%s) — below minimum %d", info.Version, info.Source, java.MinimumVersion),
+			Fixes:  []FixAction{javaInstallFix},
 		}
 	}
 
@@ -212,6 +360,7 @@ func checkVersions(dhHome string) CheckResult {
 			Name:   "Versions",
 			Status: "warning",
 			Detail: "0 installed",
+			Fixes:  []FixAction{{Description: "install a Deephaven version", Command: "dh install", Privileges: PrivilegesUser}},
 		}
 	}
 
@@ -237,6 +386,25 @@ func checkDefaultVersion(dhHome string) CheckResult {
 			Name:   "Default",
 			Status: "error",
 			Detail: "not set",
+			Fixes:  defaultVersionFixes(dhHome, ""),
+		}
+	}
+
+	// A constraint must match at least one installed version
+	if versions.IsConstraint(cfg.DefaultVersion) {
+		v, ok := versions.Select(cfg.DefaultVersion, versions.InstalledNames(dhHome))
+		if !ok {
+			return CheckResult{
+				Name:   "Default",
+				Status: "error",
+				Detail: fmt.Sprintf("%s (no installed version matches)", cfg.DefaultVersion),
+				Fixes:  defaultVersionFixes(dhHome, cfg.DefaultVersion),
+			}
+		}
+		return CheckResult{
+			Name:   "Default",
+			Status: "ok",
+			Detail: fmt.Sprintf("%s (%s)", cfg.DefaultVersion, v),
 		}
 	}
 
@@ -248,6 +416,7 @@ func checkDefaultVersion(dhHome string) CheckResult {
 			Name:   "Default",
 			Status: "error",
 			Detail: fmt.Sprintf("%s (not installed)", cfg.DefaultVersion),
+			Fixes:  defaultVersionFixes(dhHome, cfg.DefaultVersion),
 		}
 	}
 
@@ -258,13 +427,62 @@ func checkDefaultVersion(dhHome string) CheckResult {
 	}
 }
 
-func checkDiskSpace(dhHome string) CheckResult {
+// defaultVersionFixes returns the fixes for a default version that is unset
+// or not installed: installing want, and switching to the newest installed
+// version, which 'dh doctor --fix' does itself.
+func defaultVersionFixes(dhHome, want string) []FixAction {
+	var fixes []FixAction
+	if want != "" {
+		fixes = append(fixes, FixAction{
+			Description: fmt.Sprintf("install Deephaven %s", want),
+			Command:     "dh install " + shellQuote(want),
+			Privileges:  PrivilegesUser,
+		})
+	}
+	installed, err := versions.ListInstalled(dhHome)
+	if err == nil && len(installed) > 0 {
+		latest := installed[0].Version
+		fixes = append(fixes, FixAction{
+			Description: fmt.Sprintf("set the default version to %s", latest),
+			Command:     "dh use " + latest,
+			AutoFixable: true,
+			Privileges:  PrivilegesUser,
+		})
+	} else if want == "" {
+		fixes = append(fixes, FixAction{
+			Description: "install a Deephaven version and make it the default",
+			Command:     "dh install",
+			Privileges:  PrivilegesUser,
+		})
+	}
+	return fixes
+}
+
+// shellQuote single-quotes s if it has characters the shell would treat
+// specially, as version constraints like ">=0.35" do.
+func shellQuote(s string) string {
+	if !strings.ContainsAny(s, " <>=|&;*?~^!,$'\"") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// diskFree returns the bytes available to the user on dhHome's filesystem.
+func diskFree(dhHome string) (uint64, error) {
 	var stat unix.Statfs_t
 	target := dhHome
 	if _, err := os.Stat(target); err != nil {
 		target = filepath.Dir(target)
 	}
 	if err := unix.Statfs(target, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func checkDiskSpace(dhHome string) CheckResult {
+	freeBytes, err := diskFree(dhHome)
+	if err != nil {
 		return CheckResult{
 			Name:   "Disk",
 			Status: "warning",
@@ -272,7 +490,6 @@ func checkDiskSpace(dhHome string) CheckResult {
 		}
 	}
 
-	freeBytes := stat.Bavail * uint64(stat.Bsize)
 	freeGB := float64(freeBytes) / (1024 * 1024 * 1024)
 
 	status := "ok"
@@ -289,6 +506,68 @@ func checkDiskSpace(dhHome string) CheckResult {
 	}
 }
 
+func checkVM(dhHome string) CheckResult {
+	paths := vm.NewVMPaths(dhHome)
+	errs := vm.CheckPrerequisites(paths)
+	if len(errs) == 0 {
+		return checkVMResources(paths)
+	}
+	var problems []string
+	for _, e := range errs {
+		problems = append(problems, fmt.Sprintf("%s: %s", e.Check, e.Message))
+	}
+	c := CheckResult{
+		Name:   "VM",
+		Status: "error",
+		Detail: strings.Join(problems, "; "),
+	}
+	if !vm.HasNonAutoFixErrors(errs) {
+		privileges := PrivilegesUser
+		if !vm.KVMAccessible() {
+			// Granting /dev/kvm access runs setfacl through sudo.
+			privileges = PrivilegesAdmin
+		}
+		c.Fixes = []FixAction{{Description: "set up VM mode", Command: "dh vm prepare", Privileges: privileges}}
+	}
+	return c
+}
+
+// checkVMResources reports how many more VMs of the largest snapshot the
+// host has memory and disk for, as dh checks before restoring one.
+func checkVMResources(paths *vm.VMPaths) CheckResult {
+	r, err := vm.ReadHostResources(paths)
+	if err != nil {
+		return CheckResult{
+			Name:   "VM",
+			Status: "warning",
+			Detail: fmt.Sprintf("prerequisites met; could not check resources: %s", err),
+		}
+	}
+	vmMiB := 0
+	snaps, _ := vm.ListSnapshots(paths)
+	for _, s := range snaps {
+		if s.Meta != nil {
+			vmMiB = max(vmMiB, vm.VMMemEstimateMiB(s.Meta))
+		}
+	}
+	if vmMiB == 0 {
+		vmMiB = vm.VMMemEstimateMiB(&vm.SnapshotMetadata{})
+	}
+	if err := r.CheckVMs(1, vmMiB); err != nil {
+		return CheckResult{
+			Name:   "VM",
+			Status: "error",
+			Detail: "prerequisites met; " + err.Error(),
+		}
+	}
+	return CheckResult{
+		Name:   "VM",
+		Status: "ok",
+		Detail: fmt.Sprintf("prerequisites met; room for %s of ~%d MiB (memory %s, %.1f GB disk free)",
+			pluralize(r.VMsFit(vmMiB), "VM"), vmMiB, r, float64(r.DiskFreeMiB)/1024),
+	}
+}
+
 func shortenHome(path string) string {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -300,36 +579,51 @@ func shortenHome(path string) string {
 	return path
 }
 
+// runFixes applies the auto-fixable fix of each failed check, and prints
+// the best fix for the rest.
 func runFixes(cmd *cobra.Command, checks []CheckResult, dhHome string) {
 	for _, c := range checks {
-		if c.Status == "ok" {
+		if c.Status == "ok" || len(c.Fixes) == 0 {
 			continue
 		}
-		switch c.Name {
-		case "uv":
-			if c.Status == "error" {
-				fmt.Fprintln(cmd.OutOrStdout(), "\nFix: Install uv with: curl -LsSf https://astral.sh/uv/install.sh | sh")
-			}
-		case "Java":
-			if c.Status == "error" {
-				fmt.Fprintln(cmd.OutOrStdout(), "\nFix: Run 'dh java install' to install Java.")
+		fix := c.Fixes[0]
+		for _, f := range c.Fixes {
+			if f.AutoFixable {
+				fix = f
+				break
 			}
-		case "Versions":
-			if c.Status == "warning" && strings.Contains(c.Detail, "0 installed") {
-				fmt.Fprintln(cmd.OutOrStdout(), "\nFix: Run 'dh install' to install a Deephaven version.")
-			}
-		case "Default":
-			if c.Status == "error" {
-				installed, err := versions.ListInstalled(dhHome)
-				if err == nil && len(installed) > 0 {
-					latest := installed[0].Version
-					if err := config.Set("default_version", latest); err == nil {
-						fmt.Fprintf(cmd.OutOrStdout(), "\nFix: Set default version to %s.\n", latest)
-					}
-				} else {
-					fmt.Fprintln(cmd.OutOrStdout(), "\nFix: Install a version first with 'dh install', then run 'dh use <version>'.")
-				}
+		}
+		if fix.AutoFixable {
+			if err := applyFix(c.Name, dhHome); err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "\nFix failed: could not %s: %s\n", fix.Description, err)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "\nFix: %s.\n", capitalize(fix.Description))
 			}
+			continue
 		}
+		fmt.Fprintf(cmd.OutOrStdout(), "\nFix: Run '%s' to %s.\n", fix.Command, fix.Description)
+	}
+}
+
+// applyFix applies the auto-fixable fix for the named check.
+func applyFix(check, dhHome string) error {
+	switch check {
+	case "Default":
+		installed, err := versions.ListInstalled(dhHome)
+		if err != nil {
+			return err
+		}
+		if len(installed) == 0 {
+			return fmt.Errorf("no versions installed")
+		}
+		return config.Set("default_version", installed[0].Version)
+	}
+	return fmt.Errorf("no automatic fix for %s", check)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
 	}
+	return strings.ToUpper(s[:1]) + s[1:]
 }
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/dsmmcken/dh-cli/src/internal/completion"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/shellenv"
+	"github.com/spf13/cobra"
+)
+
+var (
+	envVersionFlag    string
+	envShellFlag      string
+	envDeactivateFlag bool
+)
+
+func addEnvCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Print shell commands to activate a version's environment",
+		Long: `Print the shell commands that put a Deephaven version's environment into
+your shell: its venv's bin first on PATH, VIRTUAL_ENV, JAVA_HOME and
+DH_VERSION. python and pip then run in the version's venv.
+
+Evaluate the output to apply it. --deactivate prints the commands that
+restore the values from before.
+
+Examples:
+  eval "$(dh env)"
+  eval "$(dh env --version 0.36.0)"
+  eval "$(dh env --deactivate)"
+  dh env --shell fish | source`,
+		Args: cobra.NoArgs,
+		RunE: runEnv,
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&envVersionFlag, "version", "", "Deephaven version to activate")
+	flags.StringVar(&envShellFlag, "shell", "", "Shell syntax: bash, zsh or fish (default: your shell)")
+	flags.BoolVar(&envDeactivateFlag, "deactivate", false, "Print the commands that restore the environment from before activation")
+	_ = cmd.RegisterFlagCompletionFunc("shell", cobra.FixedCompletions(shellenv.Shells, cobra.ShellCompDirectiveNoFileComp))
+
+	parent.AddCommand(cmd)
+}
+
+func runEnv(cmd *cobra.Command, args []string) error {
+	shell := envShellFlag
+	if shell == "" {
+		shell = completion.Detect()
+		if !slices.Contains(shellenv.Shells, shell) {
+			shell = "bash"
+		}
+	}
+	if !slices.Contains(shellenv.Shells, shell) {
+		return envError(cmd, fmt.Errorf("unsupported shell %q (supported: bash, zsh, fish)", shell))
+	}
+
+	var changes shellenv.Changes
+	version := ""
+	if envDeactivateFlag {
+		var active bool
+		changes, active = shellenv.Deactivate(os.LookupEnv)
+		if !active && !output.IsQuiet() {
+			fmt.Fprintln(cmd.ErrOrStderr(), "No dh environment is active")
+		}
+	} else {
+		e, err := envFor(cmd)
+		if err != nil {
+			return envError(cmd, err)
+		}
+		version = e.Version
+		changes = shellenv.Activate(*e, os.LookupEnv)
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"version": version,
+			"shell":   shell,
+			"set":     changes.Set,
+			"unset":   changes.Unset,
+		})
+	}
+	script, err := shellenv.Format(changes, shell)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(cmd.OutOrStdout(), script)
+	if f, ok := cmd.OutOrStdout().(*os.File); ok && isTerminal(f) && !output.IsQuiet() {
+		hint := `eval "$(dh env)"`
+		if shell == "fish" {
+			hint = "dh env | source"
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "# These commands change nothing by themselves; run: %s\n", hint)
+	}
+	return nil
+}
+
+// envFor resolves the version to activate, its venv and Java.
+func envFor(cmd *cobra.Command) (*shellenv.Env, error) {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	version, err := config.ResolveVersion(envVersionFlag, os.Getenv("DH_VERSION"))
+	if err != nil {
+		return nil, fmt.Errorf("resolving version: %w", err)
+	}
+	pythonBin, err := dhexec.FindVenvPython(dhHome, version)
+	if err != nil {
+		return nil, err
+	}
+	e := &shellenv.Env{
+		Version: version,
+		BinDir:  filepath.Dir(pythonBin),
+		VenvDir: filepath.Dir(filepath.Dir(pythonBin)),
+	}
+
+	javaInfo, err := java.Detect(dhHome)
+	if err == nil && javaInfo.Found {
+		e.JavaHome = javaInfo.Home
+	} else if !output.IsQuiet() {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Warning: Java not found; JAVA_HOME is left unchanged (run dh java install)")
+	}
+	return e, nil
+}
+
+func envError(cmd *cobra.Command, err error) error {
+	if output.IsJSON() {
+		return output.PrintError(cmd.ErrOrStderr(), "env_error", err.Error())
+	}
+	return err
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
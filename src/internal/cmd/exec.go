@@ -3,58 +3,155 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
+	"github.com/dsmmcken/dh-cli/src/internal/config"
 	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
 	"github.com/spf13/cobra"
 )
 
 var (
-	execCodeFlag          string
-	execPortFlag          int
-	execJVMArgsFlag       string
-	execTimeoutFlag       int
-	execNoShowTablesFlag  bool
-	execNoTableMetaFlag   bool
-	execVersionFlag       string
-	execHostFlag          string
-	execAuthTypeFlag      string
-	execAuthTokenFlag     string
-	execTLSFlag           bool
-	execTLSCACertFlag     string
-	execTLSClientCertFlag string
-	execTLSClientKeyFlag  string
-	execVMFlag            bool
+	execCodeFlag           string
+	execPortFlag           int
+	execJVMArgsFlag        string
+	execTimeoutFlag        int
+	execConnectTimeoutFlag int
+	execExecTimeoutFlag    int
+	execKillAfterFlag      int
+	execFailOnWarningFlag  bool
+	execNoShowTablesFlag   bool
+	execNoTableMetaFlag    bool
+	execVersionFlag        string
+	execHostFlags          []string
+	execHostsFileFlag      string
+	execParallelFlag       int
+	execTargetFlag         string
+	execAttachFlag         string
+	execAuthTypeFlag       string
+	execAuthTokenFlag      string
+	execTLSFlag            bool
+	execTLSCACertFlag      string
+	execTLSClientCertFlag  string
+	execTLSClientKeyFlag   string
+	execVMFlag             bool
+	execJailedFlag         bool
+	execNetFlag            string
+	execAutoRebuildFlag    bool
+	execMountFlags         []string
+	execAllowWriteFlags    []string
+	execMountModeFlag      string
+	execCollectOutputsFlag string
+	execProfileFlag        string
+	execPythonPathFlags    []string
+	execRecordFlag         string
+	execReplayFlag         string
+	execVMBackendFlag      string
+	execVMEagerMBFlag      int
+	execNoPoolFlag         bool
+	execInstanceFlag       string
+	execSessionFlag        string
+	execTableFormatFlag    string
+	execTableDirFlag       string
+	execWatchFlag          bool
+	execWatchGlobFlags     []string
+	execImportTableFlags   []string
+	execExportTableFlags   []string
+	execTableOutputFlag    string
+	execCheckFlag          bool
+	execJSONStreamFlag     bool
+	execLanguageFlag       string
+	execCellTagFlags       []string
+	execWithFlags          []string
+	execNoDaemonFlag       bool
+	execMaxRowsFlag        int
+	execMaxColsFlag        int
+	execGitFlag            string
+	execAllowRemoteFlag    bool
+	execMaxRemoteKBFlag    int
 )
 
+// defaultJVMArgs are the JVM arguments used for embedded servers unless
+// overridden with --jvm-args.
+const defaultJVMArgs = "-Xmx4g -DAuthHandlers=io.deephaven.auth.AnonymousAuthenticationHandler"
+
 func addExecCommand(parent *cobra.Command) {
 	cmd := &cobra.Command{
-		Use:   "exec [SCRIPT]",
+		Use:   "exec [SCRIPT...]",
 		Short: "Execute Python code on a Deephaven server",
 		Long: `Execute Python code on a Deephaven server in batch mode.
 
-Code can be provided via -c flag, a script file, or stdin (use - for stdin).
+Code can be provided via -c flag, a script file, a Jupyter notebook (.ipynb),
+or stdin (use - for stdin). Several script files run one after another in
+the same session, stopping at the first that fails. A script can also be
+fetched from an http(s) URL or, with --git, from a git ref; both need
+--allow-remote-code.
 
 Examples:
   dh exec -c "print('hello')"
   dh exec script.py
   echo "print('hi')" | dh exec -
   dh exec -c "from deephaven import empty_table; t = empty_table(5)"
-  dh exec -c "print('remote')" --host remote.example.com`,
-		Args:              cobra.MaximumNArgs(1),
+  dh exec -c "print('remote')" --host remote.example.com
+  dh exec report.py --target analytics       # Server from dh serve --name
+  dh exec report.py --attach :10000          # Server already running on port 10000
+  dh exec health.py --host prod-a --host prod-b --json   # Both at once, worst exit code
+  dh exec --vm --mount ../shared:libs script.py
+  dh exec --vm --allow-write=out report.py   # Save results to ./out
+  dh exec --vm --collect-outputs=plots chart.py
+  dh exec --vm --profile myproj model.py     # Snapshot from dh vm prepare --profile
+  dh exec --vm --instance dev step2.py       # VM kept up by dh vm up --name dev
+  dh exec --vm --session work load.py        # Later runs in session work see its tables
+  dh exec --vm --table-format arrow --table-dir data build.py
+  dh exec --import-table trades=trades.csv summary.py
+  dh exec --with polars --with "requests>=2" fetch.py
+  dh exec etl.py --export-table result=out/result.parquet
+  dh exec report.py --table-output markdown --max-rows 5
+  dh exec long_job.py --json-stream | jq -c 'select(.event == "stdout")'
+  dh exec setup.py queries.py report.py      # One session, in order
+  dh exec --allow-remote-code https://example.com/runbooks/check.py
+  dh exec --allow-remote-code --git https://github.com/org/ops@v2:runbooks/check.py
+  dh exec analysis.ipynb --cell-tag setup --cell-tag report
+  dh exec --host prod --language groovy -c 't = emptyTable(5).update("X = i")'
+  dh exec --watch report.py                  # Re-run on every save
+  dh exec --vm --watch --watch-glob 'data/*.csv' load.py
+  dh exec report.py --record golden/
+  dh exec report.py --replay golden/
+  dh exec --check etl.py                     # Compile and check the setup; run nothing
+  dh exec --connect-timeout 120 --exec-timeout 30 job.py`,
+		Args:              cobra.ArbitraryArgs,
 		DisableFlagParsing: false,
 		RunE:              runExec,
 	}
 
 	flags := cmd.Flags()
 	flags.StringVarP(&execCodeFlag, "code", "c", "", "Python code to execute")
-	flags.IntVar(&execPortFlag, "port", 10000, "Server port")
-	flags.StringVar(&execJVMArgsFlag, "jvm-args", "-Xmx4g -DAuthHandlers=io.deephaven.auth.AnonymousAuthenticationHandler", "JVM arguments (quoted string)")
-	flags.IntVar(&execTimeoutFlag, "timeout", 0, "Execution timeout in seconds (0 = no timeout)")
+	flags.StringVar(&execGitFlag, "git", "", "Run the script at PATH in REF of a git repository: REPO@REF:PATH (needs --allow-remote-code)")
+	flags.BoolVar(&execAllowRemoteFlag, "allow-remote-code", false, "Allow running code fetched from an http(s) URL script or --git, as it is, unreviewed")
+	flags.IntVar(&execMaxRemoteKBFlag, "max-remote-kb", dhexec.DefaultMaxRemoteKB, "Largest script, in KiB, to fetch from a URL or --git")
+	flags.IntVar(&execPortFlag, "port", 10000, "Server port (0 = any free port)")
+	flags.StringVar(&execJVMArgsFlag, "jvm-args", defaultJVMArgs, "JVM arguments (quoted string)")
+	flags.IntVar(&execTimeoutFlag, "timeout", 0, "Timeout in seconds for the whole run, server startup included (0 = no timeout)")
+	flags.IntVar(&execConnectTimeoutFlag, "connect-timeout", 0, "Seconds to start or reach the server, or restore the --vm VM (0 = no timeout)")
+	flags.IntVar(&execExecTimeoutFlag, "exec-timeout", 0, "Seconds the code may run, counted from when the server is ready (0 = no timeout)")
+	flags.IntVar(&execKillAfterFlag, "kill-after", 5, "Seconds between interrupting a run that timed out and killing it (0 = kill at once)")
+	flags.BoolVar(&execFailOnWarningFlag, "fail-on-warning", false, "Exit non-zero if the script emits any warnings")
 	flags.BoolVar(&execNoShowTablesFlag, "no-show-tables", false, "Do not show table previews")
 	flags.BoolVar(&execNoTableMetaFlag, "no-table-meta", false, "Do not show column types and row counts")
+	flags.BoolVar(&execJSONStreamFlag, "json-stream", false, "Write NDJSON events (started, stdout, stderr, table, result, finished) as the run goes")
+	flags.StringVar(&execTableOutputFlag, "table-output", "pretty", "Format of table previews: pretty, csv, markdown, html or json")
+	flags.IntVar(&execMaxRowsFlag, "max-rows", 10, "Rows per table preview (0 = all)")
+	flags.IntVar(&execMaxColsFlag, "max-cols", 0, "Columns per table preview (0 = all)")
 	flags.StringVar(&execVersionFlag, "version", "", "Deephaven version to use")
-	flags.StringVar(&execHostFlag, "host", "", "Remote server host (enables remote mode)")
+	flags.StringVar(&execLanguageFlag, "language", "", "Language of the code: python or groovy, which needs --host or --target (default: groovy for .groovy scripts, else python)")
+	flags.StringArrayVar(&execCellTagFlags, "cell-tag", nil, "With a .ipynb script, run only the code cells with this tag (repeatable)")
+	flags.BoolVar(&execNoDaemonFlag, "no-daemon", false, "Start a server for this run even when 'dh server-daemon' is running")
+	flags.StringArrayVar(&execHostFlags, "host", nil, "Remote server host (enables remote mode; repeatable: run on each host in parallel)")
+	flags.StringVar(&execHostsFileFlag, "hosts-file", "", "Run on each host listed in FILE, one per line, in parallel")
+	flags.IntVar(&execParallelFlag, "parallel", 0, "With several hosts, how many run at a time (default: all)")
+	flags.StringVar(&execTargetFlag, "target", "", "Run on the running server started with dh serve --name NAME")
+	flags.StringVar(&execAttachFlag, "attach", "", "Run on the local server already running on a port (:PORT) or as a process (PID), as dh list shows them")
 	flags.StringVar(&execAuthTypeFlag, "auth-type", "", "Authentication type for remote connection")
 	flags.StringVar(&execAuthTokenFlag, "auth-token", "", "Authentication token for remote connection")
 	flags.BoolVar(&execTLSFlag, "tls", false, "Use TLS for remote connection")
@@ -62,48 +159,193 @@ Examples:
 	flags.StringVar(&execTLSClientCertFlag, "tls-client-cert", "", "Path to client certificate for TLS")
 	flags.StringVar(&execTLSClientKeyFlag, "tls-client-key", "", "Path to client private key for TLS")
 	flags.BoolVar(&execVMFlag, "vm", false, "Execute in a Firecracker microVM (experimental, Linux only)")
+	flags.BoolVar(&execJailedFlag, "jailed", false, "With --vm, run Firecracker under the jailer (needs root and a snapshot from 'dh vm prepare --jailed')")
+	flags.StringVar(&execNetFlag, "net", "", "With --vm, the VM's network: none (default) or nat for outbound access through NAT on the host (needs root and a snapshot from 'dh vm prepare --net=nat')")
+	flags.BoolVar(&execAutoRebuildFlag, "auto-rebuild", false, "With --vm, retake a snapshot the installed Firecracker or kernel can't restore, instead of failing")
+	flags.StringArrayVar(&execMountFlags, "mount", nil, "Expose a host directory to --vm as /workspace/ALIAS: HOST_PATH[:ALIAS][:ro|rw] (repeatable)")
+	flags.StringArrayVar(&execAllowWriteFlags, "allow-write", nil, "Let --vm scripts write files under PATH in the working directory (repeatable; alone: the whole directory)")
+	flags.Lookup("allow-write").NoOptDefVal = "."
+	flags.StringVar(&execCollectOutputsFlag, "collect-outputs", "", "Save the files a --vm script writes to $DH_OUTPUT_DIR in DIR on the host")
+	flags.StringVar(&execVMBackendFlag, "vm-backend", "", "With --vm, how snapshot memory is restored: uffd (page faults served by dh) or file (mapped by Firecracker)")
+	flags.IntVar(&execVMEagerMBFlag, "vm-eager-mb", 0, "With --vm, MiB of snapshot memory to copy before the VM resumes (default: the snapshot's hot chunks)")
+	flags.BoolVar(&execNoPoolFlag, "no-pool", false, "With --vm, always restore a new VM instead of using the pool daemon")
+	flags.StringVar(&execProfileFlag, "profile", "", "With --vm, restore the snapshot profile made by 'dh vm prepare --profile NAME'")
+	flags.StringVar(&execInstanceFlag, "instance", "", "With --vm, run in the VM started by 'dh vm up --name NAME', keeping its state between runs")
+	flags.StringVar(&execSessionFlag, "session", "", "With --vm, run in session NAME's VM, starting it if needed, so variables and tables carry over between runs")
+	flags.StringVar(&execTableFormatFlag, "table-format", "text", "With --vm, text previews, or arrow to also save each table the script assigns as NAME.arrow (base64 in --json)")
+	flags.StringVar(&execTableDirFlag, "table-dir", "", "Directory for the NAME.arrow files of --table-format arrow (default: the working directory)")
+	flags.StringVar(&execMountModeFlag, "mount-mode", "preload", "How --vm scripts see /workspace: preload (LD_PRELOAD library) or fuse (FUSE mount)")
+	flags.BoolVar(&execWatchFlag, "watch", false, "Run again each time the script, or a file matching --watch-glob, changes")
+	flags.StringArrayVar(&execWatchGlobFlags, "watch-glob", nil, "With --watch, also run again when a file matching this pattern changes (repeatable)")
+	flags.StringArrayVar(&execImportTableFlags, "import-table", nil, "Load a local .csv or .parquet file as table NAME before the code runs: NAME=PATH (repeatable)")
+	flags.StringArrayVar(&execExportTableFlags, "export-table", nil, "Save table NAME, left in scope by the code, to a local .parquet, .csv or .arrow file: NAME=PATH (repeatable)")
+	flags.StringArrayVar(&execWithFlags, "with", nil, "Run with this package installed, in a cached overlay of the version's venv rather than in the venv (repeatable)")
+	flags.StringArrayVar(&execPythonPathFlags, "pythonpath", nil, "Prepend a directory to PYTHONPATH so local helper modules can be imported (repeatable)")
+	flags.StringVar(&execRecordFlag, "record", "", "Save stdout, table schemas and table content hashes to DIR as a golden recording")
+	flags.StringVar(&execReplayFlag, "replay", "", "Re-run and compare against the recording in DIR; exit non-zero on drift")
+	flags.BoolVar(&execCheckFlag, "check", false, "Compile the code and check the version, venv, Java or VM snapshot it needs, without running it")
 
 	parent.AddCommand(cmd)
 }
 
 func runExec(cmd *cobra.Command, args []string) error {
 	cfg := &dhexec.ExecConfig{
-		Code:          execCodeFlag,
-		Port:          execPortFlag,
-		JVMArgs:       execJVMArgsFlag,
-		Timeout:       execTimeoutFlag,
-		ShowTables:    !execNoShowTablesFlag,
-		ShowTableMeta: !execNoTableMetaFlag,
-		JSONMode:      output.IsJSON(),
-		Verbose:       output.IsVerbose(),
-		Quiet:         output.IsQuiet(),
-		Version:       execVersionFlag,
-		Host:          execHostFlag,
-		AuthType:      execAuthTypeFlag,
-		AuthToken:     execAuthTokenFlag,
-		TLS:           execTLSFlag,
-		TLSCACert:     execTLSCACertFlag,
-		TLSClientCert: execTLSClientCertFlag,
-		TLSClientKey:  execTLSClientKeyFlag,
-		VMMode:        execVMFlag,
-		ConfigDir:     ConfigDir,
-		ProcessStart:  ProcessStart,
-		Stderr:        cmd.ErrOrStderr(),
-		Stdout:        cmd.OutOrStdout(),
-	}
-
-	// Positional arg is a script path
+		Code:           execCodeFlag,
+		Port:           execPortFlag,
+		JVMArgs:        execJVMArgsFlag,
+		Timeout:        execTimeoutFlag,
+		ConnectTimeout: execConnectTimeoutFlag,
+		ExecTimeout:    execExecTimeoutFlag,
+		KillAfter:      execKillAfterFlag,
+		FailOnWarning:  execFailOnWarningFlag,
+		ShowTables:     !execNoShowTablesFlag,
+		ShowTableMeta:  !execNoTableMetaFlag,
+		TableOutput:    execTableOutputFlag,
+		MaxCols:        execMaxColsFlag,
+		JSONMode:       output.IsJSON(),
+		JSONStream:     execJSONStreamFlag,
+		Language:       execLanguageFlag,
+		CellTags:       execCellTagFlags,
+		Verbose:        output.IsVerbose(),
+		Quiet:          output.IsQuiet(),
+		Version:        execVersionFlag,
+		AuthType:       execAuthTypeFlag,
+		AuthToken:      execAuthTokenFlag,
+		TLS:            execTLSFlag,
+		TLSCACert:      execTLSCACertFlag,
+		TLSClientCert:  execTLSClientCertFlag,
+		TLSClientKey:   execTLSClientKeyFlag,
+		VMMode:         execVMFlag,
+		Mounts:         execMountFlags,
+		AllowWrite:     execAllowWriteFlags,
+		MountMode:      execMountModeFlag,
+		CollectOutputs: execCollectOutputsFlag,
+		Profile:        execProfileFlag,
+		Jailed:         execJailedFlag,
+		Net:            execNetFlag,
+		AutoRebuild:    execAutoRebuildFlag,
+		PythonPath:     execPythonPathFlags,
+		With:           execWithFlags,
+		NoDaemon:       execNoDaemonFlag,
+		ImportTables:   execImportTableFlags,
+		ExportTables:   execExportTableFlags,
+		Record:         execRecordFlag,
+		Replay:         execReplayFlag,
+		VMBackend:      execVMBackendFlag,
+		NoPool:         execNoPoolFlag,
+		Instance:       execInstanceFlag,
+		Session:        execSessionFlag,
+		TableFormat:    execTableFormatFlag,
+		TableDir:       execTableDirFlag,
+		Annotate:       output.IsAnnotate(),
+		Check:          execCheckFlag,
+		ConfigDir:      ConfigDir,
+		ProcessStart:   ProcessStart,
+		Stderr:         cmd.ErrOrStderr(),
+		Stdout:         cmd.OutOrStdout(),
+	}
+
+	if cmd.Flags().Changed("vm-eager-mb") {
+		cfg.VMEagerMB = &execVMEagerMBFlag
+	}
+	if cmd.Flags().Changed("max-rows") {
+		cfg.MaxRows = &execMaxRowsFlag
+	}
+	// The daemon's servers have JVM settings and ports of their own, so a
+	// run that sets them starts a server of its own
+	if cmd.Flags().Changed("jvm-args") || cmd.Flags().Changed("port") {
+		cfg.NoDaemon = true
+	}
+
+	// Positional args are script paths, or a URL
 	if len(args) > 0 {
 		cfg.ScriptPath = args[0]
+		cfg.ExtraScripts = args[1:]
+	}
+	cfg.Git = execGitFlag
+	cfg.AllowRemoteCode = execAllowRemoteFlag
+	cfg.MaxRemoteKB = execMaxRemoteKBFlag
+
+	// Remote code is fetched once, up front, so the log and history keep
+	// the code that ran
+	if execWatchFlag && (cfg.Git != "" || dhexec.IsURL(cfg.ScriptPath)) {
+		return finishExec(cmd, output.ExitError, nil, fmt.Errorf("--watch can't watch code from a URL or --git"))
+	}
+	if err := dhexec.FetchRemoteCode(cfg); err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+
+	// Host aliases, the default backend and VM mounts from the effective
+	// (project or global) config
+	config.SetConfigDir(ConfigDir)
+	eff, _, err := config.LoadEffective()
+	if err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+	if len(execHostFlags) > 1 || execHostsFileFlag != "" {
+		return runExecHosts(cmd, cfg, eff)
+	}
+	if cmd.Flags().Changed("parallel") {
+		return finishExec(cmd, output.ExitError, nil, fmt.Errorf("--parallel requires several hosts"))
+	}
+	if len(execHostFlags) == 1 {
+		cfg.Host = execHostFlags[0]
+	}
+	if err := applyHostAlias(cmd, eff, &cfg.Host, &cfg.Port, &cfg.AuthType, &cfg.AuthToken, &cfg.TLS,
+		&tlsFiles{&cfg.TLSCACert, &cfg.TLSClientCert, &cfg.TLSClientKey}); err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+	if err := applyTarget(cmd, execTargetFlag, &cfg.Host, &cfg.Port, &cfg.AuthType, &cfg.AuthToken, &cfg.Version); err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+	if err := applyAttach(cmd, execAttachFlag, &cfg.Host, &cfg.Port, &cfg.AuthType, &cfg.AuthToken, &cfg.Version); err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+	switch eff.Backend {
+	case "", "local":
+	case "vm":
+		if cfg.Host == "" && !cmd.Flags().Changed("vm") {
+			cfg.VMMode = true
+		}
+	default:
+		return finishExec(cmd, output.ExitError, nil, fmt.Errorf("invalid backend %q in config: use %s", eff.Backend, strings.Join(config.Backends, " or ")))
+	}
+	if cfg.VMMode && len(eff.VM.Mounts) > 0 {
+		cfg.Mounts = append(slices.Clone(eff.VM.Mounts), cfg.Mounts...)
 	}
 
+	if len(execWatchGlobFlags) > 0 && !execWatchFlag {
+		return finishExec(cmd, output.ExitError, nil, fmt.Errorf("--watch-glob requires --watch"))
+	}
+	if execCheckFlag {
+		if execWatchFlag {
+			return finishExec(cmd, output.ExitError, nil, fmt.Errorf("--check can't be used with --watch"))
+		}
+		// Nothing runs, so nothing is logged
+		exitCode, jsonResult, err := dhexec.Run(cfg)
+		return finishExec(cmd, exitCode, jsonResult, err)
+	}
+	if execWatchFlag {
+		return runExecWatch(cmd, cfg)
+	}
+
+	logRun := startExecLog(cfg, eff, nil)
 	exitCode, jsonResult, err := dhexec.Run(cfg)
+	logRun(exitCode, jsonResult, err)
+	return finishExec(cmd, exitCode, jsonResult, err)
+}
+
+// finishExec reports the outcome of dhexec.Run and exits with its code.
+func finishExec(cmd *cobra.Command, exitCode int, jsonResult map[string]any, err error) error {
 	if err != nil {
 		if output.IsJSON() {
 			_ = output.PrintError(cmd.ErrOrStderr(), "exec_error", err.Error())
 		} else {
 			fmt.Fprintln(cmd.ErrOrStderr(), "Error:", err)
 		}
+		if output.IsAnnotate() {
+			output.Annotate(cmd.ErrOrStderr(), output.Annotation{Level: output.AnnotationError, Title: "dh exec", Message: err.Error()})
+		}
 		os.Exit(exitCode)
 	}
 
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// runExecHosts runs cfg on every host of --host and --hosts-file in
+// parallel. Each host is resolved as a lone --host would be: a
+// [hosts.NAME] alias supplies its port, auth and TLS settings.
+func runExecHosts(cmd *cobra.Command, cfg *dhexec.ExecConfig, eff *config.Config) error {
+	flags := cmd.Flags()
+	for _, f := range []string{"target", "attach", "vm", "watch", "check"} {
+		if flags.Changed(f) {
+			return finishExec(cmd, output.ExitError, nil, fmt.Errorf("--%s can't be used with several hosts", f))
+		}
+	}
+	hosts := execHostFlags
+	if execHostsFileFlag != "" {
+		fromFile, err := readHostsFile(execHostsFileFlag)
+		if err != nil {
+			return finishExec(cmd, output.ExitError, nil, err)
+		}
+		hosts = append(hosts, fromFile...)
+	}
+
+	logRun := startExecLog(cfg, eff, hosts)
+	exitCode, jsonResult, err := dhexec.RunHosts(cfg, hosts, execParallelFlag, func(c *dhexec.ExecConfig) error {
+		return applyHostAlias(cmd, eff, &c.Host, &c.Port, &c.AuthType, &c.AuthToken, &c.TLS,
+			&tlsFiles{&c.TLSCACert, &c.TLSClientCert, &c.TLSClientKey})
+	})
+	logRun(exitCode, jsonResult, err)
+	return finishExec(cmd, exitCode, jsonResult, err)
+}
+
+// readHostsFile returns the hosts listed in path, one per line, as --host
+// takes them. Blank lines and lines starting with # are skipped.
+func readHostsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading hosts file: %w", err)
+	}
+	defer f.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading hosts file: %w", err)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts in %s", path)
+	}
+	return hosts, nil
+}
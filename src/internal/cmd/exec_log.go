@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/execlog"
+	"github.com/dsmmcken/dh-cli/src/internal/history"
+)
+
+// startExecLog prepares the history entry of the run of cfg on hosts
+// (nil for one server), with its execution log record, and returns the
+// function that completes and adds it. It reads stdin code into cfg.Code,
+// so the log can keep it, and tees cfg.Stdout and cfg.Stderr to keep their
+// ends. Errors are ignored so log problems never affect the run itself.
+func startExecLog(cfg *dhexec.ExecConfig, eff *config.Config, hosts []string) func(exitCode int, result map[string]any, err error) {
+	run := &history.Run{Hosts: hosts}
+	e := &history.Entry{Run: run}
+	e.Dir, _ = os.Getwd()
+	switch {
+	case len(hosts) > 0:
+		run.Mode = execlog.ModeHosts
+	case cfg.VMMode:
+		run.Mode = execlog.ModeVM
+	case cfg.Host != "":
+		run.Mode = execlog.ModeRemote
+	default:
+		run.Mode = execlog.ModeEmbedded
+	}
+	run.Version, _ = config.ResolveVersion(cfg.Version, os.Getenv("DH_VERSION"))
+
+	// The REPL's history recalls -c code, so only that goes in e.Code;
+	// scripts are named by their path
+	code := cfg.Code
+	switch {
+	case cfg.ScriptPath == "-" && len(cfg.ExtraScripts) == 0:
+		data, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			code = string(data)
+			cfg.Code, cfg.ScriptPath = code, ""
+		}
+		run.Stdin = true
+	case cfg.ScriptPath != "":
+		run.Scripts, code = readLoggedScripts(append([]string{cfg.ScriptPath}, cfg.ExtraScripts...))
+		if len(run.Scripts) > 0 {
+			e.Script = run.Scripts[0]
+		}
+	default:
+		e.Code = code
+	}
+
+	keepCode := false
+	if opts, err := history.OptionsFromConfig(eff.History); err == nil {
+		keepCode = true
+		for _, re := range opts.Exclude {
+			if re.MatchString(code) {
+				keepCode = false
+			}
+		}
+	}
+	run.Args = execlog.RedactArgs(os.Args[1:], !keepCode)
+
+	stdout := &execlog.TailWriter{W: cfg.Stdout}
+	stderr := &execlog.TailWriter{W: cfg.Stderr}
+	cfg.Stdout, cfg.Stderr = stdout, stderr
+	start := time.Now()
+
+	return func(exitCode int, result map[string]any, err error) {
+		run.Elapsed = time.Since(start).Seconds()
+		run.ExitCode = exitCode
+		if err != nil {
+			run.Error = err.Error()
+		}
+		run.Stdout, run.Stderr = stdout.String(), stderr.String()
+		// With --json the script's output is in the result
+		if out, ok := result["stdout"].(string); ok {
+			run.Stdout = out
+		}
+		if out, ok := result["stderr"].(string); ok {
+			run.Stderr = out
+		}
+		if msg, ok := result["error"].(string); ok && run.Error == "" {
+			run.Error = msg
+		}
+		_ = execlog.Append(config.DHHome(), e, code, keepCode)
+	}
+}
+
+// readLoggedScripts returns the absolute paths of scripts and their
+// contents, joined. Scripts that can't be read are left out; the run
+// reports them.
+func readLoggedScripts(scripts []string) ([]string, string) {
+	var paths, contents []string
+	for _, p := range scripts {
+		if abs, err := filepath.Abs(p); err == nil {
+			paths = append(paths, abs)
+		}
+		if data, err := os.ReadFile(p); err == nil {
+			contents = append(contents, string(data))
+		}
+	}
+	return paths, strings.Join(contents, "\n")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/serverdaemon"
+	"github.com/spf13/cobra"
+)
+
+// runExecWatch runs cfg, then again each time its script, or a file
+// matching --watch-glob, changes, until interrupted. Local runs share one
+// embedded server, started here, instead of starting one each; --vm runs
+// take warm VMs from the pool, or run in the VM of --instance or
+// --session, as single runs do.
+func runExecWatch(cmd *cobra.Command, cfg *dhexec.ExecConfig) error {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if !cfg.VMMode && cfg.Host == "" {
+		server, err := startWatchServer(ctx, cfg)
+		if err != nil {
+			return finishExec(cmd, output.ExitError, nil, err)
+		}
+		defer server.Stop()
+	}
+
+	err := dhexec.Watch(ctx, cfg, execWatchGlobFlags, func(exitCode int, result map[string]any, err error) {
+		if err != nil {
+			if output.IsJSON() {
+				_ = output.PrintError(cmd.ErrOrStderr(), "exec_error", err.Error())
+			} else {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Error:", err)
+			}
+			return
+		}
+		if result != nil {
+			if err := output.PrintJSON(cmd.OutOrStdout(), result); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Error writing JSON:", err)
+			}
+		}
+	})
+	if err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+	return nil
+}
+
+// startWatchServer starts the embedded server of cfg and points cfg at it.
+// What one run leaves in the server, the next sees.
+func startWatchServer(ctx context.Context, cfg *dhexec.ExecConfig) (*serverdaemon.Server, error) {
+	version, err := config.ResolveVersion(cfg.Version, os.Getenv("DH_VERSION"))
+	if err != nil {
+		return nil, fmt.Errorf("resolving version: %w", err)
+	}
+	if !cfg.Quiet {
+		fmt.Fprintf(cfg.Stderr, "Starting Deephaven %s for --watch...\n", version)
+	}
+	s, err := serverdaemon.StartServer(ctx, config.DHHome(), serverdaemon.ServerOptions{
+		Purpose:   "--watch",
+		Name:      fmt.Sprintf("exec-watch-%d", os.Getpid()),
+		Version:   version,
+		Port:      cfg.Port,
+		JVMArgs:   cfg.JVMArgs,
+		ConfigDir: cfg.ConfigDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Verbose {
+		fmt.Fprintf(cfg.Stderr, "Watch server on port %d (pid %d, log %s)\n", s.Reg.Port, s.PID(), s.Log())
+	}
+	cfg.Host = "localhost"
+	cfg.Port = s.Reg.Port
+	cfg.AuthType, cfg.AuthToken = s.Reg.AuthType, s.Reg.AuthToken
+	return s, nil
+}
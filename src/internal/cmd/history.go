@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/execlog"
+	"github.com/dsmmcken/dh-cli/src/internal/history"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var historyLimitFlag int
+
+func addHistoryCommands(parent *cobra.Command) {
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List, show and rerun past dh exec runs",
+		Long: `Read the execution log: every dh exec run, with its time, mode,
+version, code hash, duration, exit code and the end of its output, kept in
+the history store the REPL shares (see the [history] settings).
+
+Runs are named by ID, or by the start of it; "last" is the latest run.
+
+Examples:
+  dh history                  # The latest runs
+  dh history show last        # What the latest run did and printed
+  dh history rerun 3fa9       # Run it again, from the directory it ran in`,
+		Args: cobra.NoArgs,
+		RunE: runHistoryList,
+	}
+	historyCmd.Flags().IntVarP(&historyLimitFlag, "limit", "n", 20, "Show the latest N runs (0 for all)")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the latest runs",
+		Args:  cobra.NoArgs,
+		RunE:  runHistoryList,
+	}
+	listCmd.Flags().IntVarP(&historyLimitFlag, "limit", "n", 20, "Show the latest N runs (0 for all)")
+
+	showCmd := &cobra.Command{
+		Use:   "show <ID>",
+		Short: "Show a run, its code and the end of its output",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runHistoryShow,
+	}
+
+	rerunCmd := &cobra.Command{
+		Use:   "rerun <ID>",
+		Short: "Run a past run again",
+		Long: `Run dh again with the arguments of a past run, from the directory it ran
+in. A run that read its code from stdin gets the same code again. Scripts
+are read as they are now; a warning says when they have changed since.
+--auth-token is not logged, so the token comes from dh auth login or
+[hosts.NAME] instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runHistoryRerun,
+	}
+
+	historyCmd.AddCommand(listCmd, showCmd, rerunCmd)
+	parent.AddCommand(historyCmd)
+}
+
+// historyError reports err in the active output mode and exits non-zero.
+// A missing run exits with ExitNotFound.
+func historyError(cmd *cobra.Command, err error) error {
+	code, exitCode := "history_error", output.ExitError
+	if errors.Is(err, execlog.ErrNotFound) {
+		code, exitCode = "not_found", output.ExitNotFound
+	}
+	if output.IsJSON() {
+		_ = output.PrintError(cmd.ErrOrStderr(), code, err.Error())
+		os.Exit(exitCode)
+	}
+	if exitCode != output.ExitError {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Error:", err)
+		os.Exit(exitCode)
+	}
+	return err
+}
+
+func runHistoryList(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	cwd, _ := os.Getwd()
+	entries, err := execlog.List(config.DHHome(), cwd)
+	if err != nil {
+		return historyError(cmd, err)
+	}
+	if historyLimitFlag > 0 && len(entries) > historyLimitFlag {
+		entries = entries[len(entries)-historyLimitFlag:]
+	}
+
+	if output.IsJSON() {
+		if entries == nil {
+			entries = []history.Entry{}
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{"runs": entries})
+	}
+	if len(entries) == 0 {
+		if !output.IsQuiet() {
+			fmt.Fprintln(cmd.OutOrStdout(), "No runs logged yet. dh exec logs every run.")
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tMODE\tVERSION\tEXIT\tELAPSED\tCODE")
+	for _, e := range entries {
+		r := e.Run
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%.1fs\t%s\n", r.ID, e.Time.Local().Format("2006-01-02 15:04:05"),
+			r.Mode, r.Version, r.ExitCode, r.Elapsed, runSummary(r))
+	}
+	return w.Flush()
+}
+
+// runSummary names the code of run r in a few words: its scripts, stdin, or
+// the first line of its -c code.
+func runSummary(r *history.Run) string {
+	switch {
+	case len(r.Scripts) > 0:
+		names := make([]string, len(r.Scripts))
+		for i, s := range r.Scripts {
+			names[i] = filepath.Base(s)
+		}
+		return strings.Join(names, " ")
+	case r.Stdin:
+		return "(stdin)"
+	}
+	for i, a := range r.Args {
+		code, ok := strings.CutPrefix(a, "--code=")
+		if !ok && (a == "-c" || a == "--code") && i+1 < len(r.Args) {
+			code, ok = r.Args[i+1], true
+		}
+		if ok {
+			line, _, cut := strings.Cut(strings.TrimSpace(code), "\n")
+			if cut || len(line) > 40 {
+				line = line[:min(len(line), 40)] + "..."
+			}
+			return "-c " + line
+		}
+	}
+	return ""
+}
+
+func runHistoryShow(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+	cwd, _ := os.Getwd()
+	e, err := execlog.Find(dhHome, cwd, args[0])
+	if err != nil {
+		return historyError(cmd, err)
+	}
+	code, codeErr := execlog.Code(dhHome, e)
+
+	if output.IsJSON() {
+		result := map[string]any{"run": e}
+		if codeErr == nil {
+			result["code"] = code
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), result)
+	}
+
+	r := e.Run
+	w := cmd.OutOrStdout()
+	fmt.Fprintf(w, "Run %s\n", r.ID)
+	fmt.Fprintf(w, "  Time:     %s\n", e.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "  Command:  dh %s\n", quotedArgs(r.Args))
+	fmt.Fprintf(w, "  Dir:      %s\n", e.Dir)
+	fmt.Fprintf(w, "  Mode:     %s\n", r.Mode)
+	if len(r.Hosts) > 0 {
+		fmt.Fprintf(w, "  Hosts:    %s\n", strings.Join(r.Hosts, ", "))
+	}
+	if r.Version != "" {
+		fmt.Fprintf(w, "  Version:  %s\n", r.Version)
+	}
+	fmt.Fprintf(w, "  Code:     sha256 %s\n", r.CodeHash[:12])
+	fmt.Fprintf(w, "  Elapsed:  %.2fs\n", r.Elapsed)
+	fmt.Fprintf(w, "  Exit:     %d\n", r.ExitCode)
+	if r.Error != "" {
+		fmt.Fprintf(w, "  Error:    %s\n", strings.TrimSpace(r.Error))
+	}
+	for _, s := range []struct{ name, text string }{{"Code", code}, {"Stdout", r.Stdout}, {"Stderr", r.Stderr}} {
+		if s.name == "Code" && codeErr != nil {
+			fmt.Fprintf(w, "\n── Code ──\n(%v)\n", codeErr)
+			continue
+		}
+		if s.text == "" {
+			continue
+		}
+		fmt.Fprintf(w, "\n── %s ──\n%s", s.name, s.text)
+		if !strings.HasSuffix(s.text, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
+
+func runHistoryRerun(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+	cwd, _ := os.Getwd()
+	e, err := execlog.Find(dhHome, cwd, args[0])
+	if err != nil {
+		return historyError(cmd, err)
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return historyError(cmd, fmt.Errorf("getting executable path: %w", err))
+	}
+
+	r := e.Run
+	if !r.CodeKept && len(r.Scripts) == 0 {
+		_, err := execlog.Code(dhHome, e)
+		return historyError(cmd, err)
+	}
+
+	child := exec.Command(exePath, r.Args...)
+	child.Dir = e.Dir
+	child.Stdout, child.Stderr = cmd.OutOrStdout(), cmd.ErrOrStderr()
+	if r.Stdin {
+		code, err := execlog.Code(dhHome, e)
+		if err != nil {
+			return historyError(cmd, err)
+		}
+		child.Stdin = strings.NewReader(code)
+	} else {
+		child.Stdin = os.Stdin
+	}
+	if len(r.Scripts) > 0 && !output.IsQuiet() {
+		if _, now := readLoggedScripts(r.Scripts); execlog.Hash(now) != r.CodeHash {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: the scripts have changed since run %s; dh history show %s has the code it ran\n", r.ID, r.ID)
+		}
+	}
+	if output.IsVerbose() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Running: dh %s (in %s)\n", quotedArgs(r.Args), e.Dir)
+	}
+
+	if err := child.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		return historyError(cmd, fmt.Errorf("running dh: %w", err))
+	}
+	return nil
+}
+
+// quotedArgs joins args as they would be typed in a shell.
+func quotedArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/credentials"
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
+	"github.com/spf13/cobra"
+)
+
+// tlsFiles points at the --tls-ca-cert, --tls-client-cert and
+// --tls-client-key flags of a command that has them.
+type tlsFiles struct {
+	caCert, clientCert, clientKey *string
+}
+
+// applyHostAlias replaces *host with the server configured as
+// [hosts.<name>] when *host names one. The alias also supplies the port,
+// auth type, auth token and TLS settings unless those flags were given;
+// tls is nil for commands without the TLS file flags. A remote server
+// left without a token gets the one dh auth login stored for it. It fails
+// when a token is stored for the server and can't be read; a credentials
+// file that can't be read at all only gets a warning, as the server may
+// need no token.
+func applyHostAlias(cmd *cobra.Command, cfg *config.Config, host *string, port *int, authType, authToken *string, useTLS *bool, tls *tlsFiles) error {
+	flags := cmd.Flags()
+	if h, ok := cfg.Hosts[*host]; ok && h.Host != "" {
+		name := *host
+		*host = h.Host
+		if h.Port != 0 && !flags.Changed("port") {
+			*port = h.Port
+		}
+		if h.AuthType != "" && !flags.Changed("auth-type") {
+			*authType = h.AuthType
+		}
+		if !flags.Changed("auth-token") {
+			token, err := h.Token()
+			if err != nil {
+				return fmt.Errorf("hosts.%s: %w", name, err)
+			}
+			if token != "" {
+				*authToken = token
+			}
+		}
+		if h.TLS && !flags.Changed("tls") {
+			*useTLS = true
+		}
+		if tls != nil {
+			for _, f := range []struct {
+				flag  string
+				value string
+				dst   *string
+			}{
+				{"tls-ca-cert", h.TLSCACert, tls.caCert},
+				{"tls-client-cert", h.TLSClientCert, tls.clientCert},
+				{"tls-client-key", h.TLSClientKey, tls.clientKey},
+			} {
+				if f.value != "" && !flags.Changed(f.flag) {
+					*f.dst = config.ExpandHome(f.value)
+				}
+			}
+		}
+	}
+
+	if *host != "" && *authToken == "" && !flags.Changed("auth-token") {
+		token, err := credentials.Get(config.DHHome(), credentials.Key(*host, *port))
+		if errors.Is(err, credentials.ErrUnreadable) {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v; going on without a stored token\n", err)
+		} else if err != nil {
+			return err
+		}
+		*authToken = token
+	}
+	return nil
+}
+
+// applyTarget points *host and *port at the running server registered as
+// name (dh serve --name), with the auth it was started with and its
+// Deephaven version. Explicit --port, --auth-type, --auth-token and
+// --version flags win. It does nothing when name is empty.
+func applyTarget(cmd *cobra.Command, name string, host *string, port *int, authType, authToken, version *string) error {
+	if name == "" {
+		return nil
+	}
+	flags := cmd.Flags()
+	for _, f := range []string{"host", "vm"} {
+		if flags.Changed(f) {
+			return fmt.Errorf("cannot use both --target and --%s", f)
+		}
+	}
+	reg, err := discovery.Lookup(config.DHHome(), name)
+	if err != nil {
+		return err
+	}
+	*host = "localhost"
+	if !flags.Changed("port") {
+		*port = reg.Port
+	}
+	if !flags.Changed("auth-type") && !flags.Changed("auth-token") {
+		*authType, *authToken = reg.AuthType, reg.AuthToken
+	}
+	if reg.Version != "" && !flags.Changed("version") {
+		*version = reg.Version
+	}
+	return nil
+}
+
+// applyAttach points *host and *port at the running server given as
+// ":PORT" or "PID" (see discovery.ParseAttach), found as dh list finds
+// it, with the auth and version of its record when dh started it.
+// Explicit --auth-type, --auth-token and --version flags win. It does
+// nothing when spec is empty.
+func applyAttach(cmd *cobra.Command, spec string, host *string, port *int, authType, authToken, version *string) error {
+	if spec == "" {
+		return nil
+	}
+	flags := cmd.Flags()
+	for _, f := range []string{"host", "target", "port", "vm"} {
+		if flags.Changed(f) {
+			return fmt.Errorf("cannot use both --attach and --%s", f)
+		}
+	}
+	attachPort, attachPID, err := discovery.ParseAttach(spec)
+	if err != nil {
+		return err
+	}
+	srv, err := discovery.Find(config.DHHome(), attachPort, attachPID)
+	if err != nil {
+		return err
+	}
+	*host = "localhost"
+	*port = srv.Port
+	if !flags.Changed("auth-type") && !flags.Changed("auth-token") {
+		*authType, *authToken = srv.AuthType, srv.AuthToken
+	}
+	if srv.Version != "" && !flags.Changed("version") {
+		*version = srv.Version
+	}
+	return nil
+}
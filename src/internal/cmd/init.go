@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/scaffold"
+	"github.com/spf13/cobra"
+)
+
+var (
+	initVersionFlag        string
+	initVSCodeFlag         bool
+	initForceFlag          bool
+	initNonInteractiveFlag bool
+)
+
+func addInitCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "init [DIR]",
+		Short: "Create a starter Deephaven project",
+		Long: `Create a starter project in DIR (default: the current directory):
+
+  .dh/config.toml     project settings for dh
+  .dhrc               the Deephaven version the project uses
+  example.py          an example script, for dh exec
+  .gitignore
+  .vscode/tasks.json  VS Code tasks for dh exec and dh lint (with --vscode)
+
+It asks for the version and whether to add the VS Code tasks, unless
+they are given as flags or --non-interactive is set. Files that exist
+are kept unless --force is set.
+
+Examples:
+  dh init
+  dh init analytics --version 0.36.0 --vscode
+  dh init --non-interactive`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runInit,
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&initVersionFlag, "version", "", "Deephaven version to pin in .dhrc (default: the version dh would use)")
+	flags.BoolVar(&initVSCodeFlag, "vscode", false, "Add VS Code tasks")
+	flags.BoolVar(&initForceFlag, "force", false, "Overwrite files that exist")
+	flags.BoolVar(&initNonInteractiveFlag, "non-interactive", false, "Don't ask; use the flags and defaults")
+
+	parent.AddCommand(cmd)
+}
+
+func runInit(cmd *cobra.Command, args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	config.SetConfigDir(ConfigDir)
+
+	opts := scaffold.Options{Version: initVersionFlag, VSCode: initVSCodeFlag, Force: initForceFlag}
+	if opts.Version == "" {
+		// A version pinned by a parent .dhrc resolves too; pinning it again
+		// keeps the project's version when it is moved.
+		opts.Version, _ = config.ResolveVersion("", os.Getenv("DH_VERSION"))
+	}
+
+	if !initNonInteractiveFlag && !output.IsJSON() {
+		in := bufio.NewReader(cmd.InOrStdin())
+		if !cmd.Flags().Changed("version") {
+			opts.Version = promptVersion(cmd.ErrOrStderr(), in, opts.Version)
+		}
+		if !cmd.Flags().Changed("vscode") {
+			opts.VSCode = promptYesNo(cmd.ErrOrStderr(), in, "Add VS Code tasks?")
+		}
+	}
+
+	files, err := scaffold.Init(dir, opts)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "init_error", err.Error())
+		}
+		return err
+	}
+
+	if output.IsJSON() {
+		abs, _ := filepath.Abs(dir)
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"dir":     abs,
+			"version": opts.Version,
+			"files":   files,
+		})
+	}
+
+	skipped := false
+	for _, f := range files {
+		fmt.Fprintf(cmd.OutOrStdout(), "  %-11s %s\n", f.Status, f.Path)
+		skipped = skipped || f.Status == scaffold.Skipped
+	}
+	if !output.IsQuiet() {
+		if skipped {
+			fmt.Fprintln(cmd.OutOrStdout(), "Existing files were kept; use --force to overwrite them.")
+		}
+		if opts.Version == "" {
+			fmt.Fprintln(cmd.OutOrStdout(), "No version is pinned; run dh install, then dh use --local VERSION in the project.")
+		}
+		run := "dh exec example.py"
+		if dir != "." {
+			run = fmt.Sprintf("cd %s && %s", dir, run)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "\nNext: %s\n", run)
+	}
+	return nil
+}
+
+// promptVersion asks for the version to pin, defaulting to def. An
+// answer of "none" pins no version.
+func promptVersion(w io.Writer, in *bufio.Reader, def string) string {
+	shown := def
+	if shown == "" {
+		shown = "none"
+	}
+	fmt.Fprintf(w, "Deephaven version to pin [%s]: ", shown)
+	answer, _ := in.ReadString('\n')
+	switch answer = strings.TrimSpace(answer); answer {
+	case "":
+		return def
+	case "none":
+		return ""
+	}
+	return answer
+}
+
+func promptYesNo(w io.Writer, in *bufio.Reader, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+	answer, _ := in.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	return answer == "y" || answer == "yes"
+}
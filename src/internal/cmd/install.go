@@ -19,9 +19,13 @@ func newInstallCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install [VERSION]",
 		Short: "Install a Deephaven version",
-		Long:  "Install a Deephaven version. If VERSION is omitted, installs the latest version from PyPI.",
-		Args:  cobra.MaximumNArgs(1),
-		RunE:  runInstall,
+		Long: `Install a Deephaven version. If VERSION is omitted, installs the latest version from PyPI.
+
+VERSION may also be a constraint, which installs the newest matching release:
+  dh install "~41.0"         # newest 41.0.x
+  dh install ">=0.39,<41"    # newest release in that range`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runInstall,
 	}
 
 	cmd.Flags().BoolVar(&installNoPluginsFlag, "no-plugins", false, "Skip installing default plugins")
@@ -52,17 +56,29 @@ func runInstall(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("failed to fetch latest version: %w", err)
 		}
 		version = latest
+	} else if versions.IsConstraint(version) {
+		if !output.IsQuiet() {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Finding newest release matching %s on PyPI...\n", version)
+		}
+		matched, err := versions.FetchMatchingVersion(version)
+		if err != nil {
+			if output.IsJSON() {
+				return output.PrintError(cmd.ErrOrStderr(), "version_error", err.Error())
+			}
+			return err
+		}
+		version = matched
 	}
 
-	// Load config for plugins
-	cfg, err := config.Load()
+	// Plugins and Python come from the effective (project or global) config
+	eff, _, err := config.LoadEffective()
 	if err != nil {
 		return err
 	}
 
 	var plugins []string
 	if !installNoPluginsFlag {
-		plugins = cfg.Install.Plugins
+		plugins = eff.Install.Plugins
 		if len(plugins) == 0 {
 			plugins = []string{
 				"deephaven-plugin-ui",
@@ -72,8 +88,8 @@ func runInstall(cmd *cobra.Command, args []string) error {
 	}
 
 	pythonVer := installPythonFlag
-	if pythonVer == "3.13" && cfg.Install.PythonVersion != "" {
-		pythonVer = cfg.Install.PythonVersion
+	if pythonVer == "3.13" && eff.Install.PythonVersion != "" {
+		pythonVer = eff.Install.PythonVersion
 	}
 
 	start := time.Now()
@@ -92,6 +108,10 @@ func runInstall(cmd *cobra.Command, args []string) error {
 	}
 
 	// If first installed version or no default, set as default
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
 	setAsDefault := false
 	if cfg.DefaultVersion == "" {
 		cfg.DefaultVersion = version
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -30,13 +31,26 @@ func addJavaCommands(parent *cobra.Command) {
 	installCmd.Flags().IntVar(&jdkVersionFlag, "jdk-version", 21, "JDK major version to install")
 	installCmd.Flags().BoolVar(&forceFlag, "force", false, "Force reinstall even if already present")
 
-	javaCmd.AddCommand(installCmd)
+	checkFlagsCmd := &cobra.Command{
+		Use:   "check-flags",
+		Short: "Check JVM arguments without starting a server",
+		Long: `Start a short-lived JVM with the given arguments to catch typos and
+unsupported options, reporting the failing flag. dh exec, serve and repl run
+the same check automatically before starting a server.`,
+		Example: `  dh java check-flags --jvm-args "-Xmx8g -XX:+UseZGC"`,
+		Args:    cobra.NoArgs,
+		RunE:    runJavaCheckFlags,
+	}
+	checkFlagsCmd.Flags().StringVar(&checkJVMArgsFlag, "jvm-args", defaultJVMArgs, "JVM arguments to check (quoted string)")
+
+	javaCmd.AddCommand(installCmd, checkFlagsCmd)
 	parent.AddCommand(javaCmd)
 }
 
 var (
-	jdkVersionFlag int
-	forceFlag      bool
+	jdkVersionFlag   int
+	forceFlag        bool
+	checkJVMArgsFlag string
 )
 
 func getDhgHome() string {
@@ -100,3 +114,52 @@ func runJavaInstall(cmd *cobra.Command, args []string) error {
 	fmt.Fprintf(cmd.OutOrStdout(), "Installed Java %s at %s\n", info.Version, info.Home)
 	return nil
 }
+
+func runJavaCheckFlags(cmd *cobra.Command, args []string) error {
+	dhHome := getDhgHome()
+	info, err := java.Detect(dhHome)
+	if err == nil && !info.Found {
+		err = fmt.Errorf("Java not found; install Java 17+ or set JAVA_HOME")
+	}
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "java_detect_error", err.Error())
+		}
+		return err
+	}
+
+	flags := java.SplitFlags(checkJVMArgsFlag)
+	err = java.CheckFlags(info.Path, flags)
+	var flagErr *java.FlagError
+	if err != nil && !errors.As(err, &flagErr) {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "java_check_error", err.Error())
+		}
+		return err
+	}
+
+	if output.IsJSON() {
+		result := map[string]any{
+			"valid": flagErr == nil,
+			"java":  info.Path,
+			"args":  flags,
+		}
+		if flagErr != nil {
+			result["flag"] = flagErr.Flag
+			result["message"] = flagErr.Message
+		}
+		if err := output.PrintJSON(cmd.OutOrStdout(), result); err != nil {
+			return err
+		}
+		if flagErr != nil {
+			os.Exit(output.ExitError)
+		}
+		return nil
+	}
+
+	if flagErr != nil {
+		return flagErr
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "JVM flags OK (Java %s at %s)\n", info.Version, info.Path)
+	return nil
+}
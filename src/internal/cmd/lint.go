@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/lint"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lintVersionFlag string
+	lintStrictFlag  bool
+)
+
+func addLintCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "lint [PATH...]",
+		Short: "Check the query strings in Python scripts",
+		Long: `Check the Deephaven query strings in Python scripts without running them.
+
+The formulas passed to update(), view() and friends, the filters passed
+to where(), and the column names passed to the agg functions are checked
+for common mistakes: Python operators, literals and casts (and, True,
+int(X)), formulas without NAME =, unbalanced brackets and quotes, columns
+that don't exist in a chain starting at empty_table() or time_table(),
+and functions that were renamed in the target Deephaven version.
+
+PATH is a script or a directory to search for *.py files (default: the
+current directory). The target version is --version, or the version
+dh would use. Problems are printed as file:line:column for editors;
+--json prints them as a list.
+
+Exits with code 1 when there are errors, or warnings with --strict.
+
+Examples:
+  dh lint
+  dh lint query.py --version 0.35.1
+  dh lint scripts/ --strict --json`,
+		RunE: runLint,
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&lintVersionFlag, "version", "", "Deephaven version the scripts target")
+	flags.BoolVar(&lintStrictFlag, "strict", false, "Exit with code 1 on warnings too")
+
+	parent.AddCommand(cmd)
+}
+
+func runLint(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	files, err := lintFiles(args)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "lint_error", err.Error())
+		}
+		return err
+	}
+
+	opts := lint.Options{Version: lintVersionFlag}
+	if opts.Version == "" {
+		config.SetConfigDir(ConfigDir)
+		// Unknown is fine: deprecations are then reported as warnings.
+		opts.Version, _ = config.ResolveVersion("", os.Getenv("DH_VERSION"))
+	}
+
+	diags := []lint.Diagnostic{}
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			if output.IsJSON() {
+				return output.PrintError(cmd.ErrOrStderr(), "lint_error", err.Error())
+			}
+			return err
+		}
+		diags = append(diags, lint.File(f, string(src), opts)...)
+	}
+
+	errors, warnings := 0, 0
+	for _, d := range diags {
+		if d.Severity == lint.SeverityError {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+
+	if output.IsJSON() {
+		if err := output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"version":     opts.Version,
+			"files":       len(files),
+			"errors":      errors,
+			"warnings":    warnings,
+			"diagnostics": diags,
+		}); err != nil {
+			return err
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Fprintln(cmd.OutOrStdout(), d)
+		}
+		if output.IsAnnotate() {
+			for _, d := range diags {
+				level := d.Severity
+				if lintStrictFlag {
+					level = output.AnnotationError
+				}
+				output.Annotate(cmd.OutOrStdout(), output.Annotation{
+					Level: level, File: d.File, Line: d.Line, Col: d.Column,
+					Title: "dh lint: " + d.Rule, Message: d.Message,
+				})
+			}
+		}
+		if !output.IsQuiet() {
+			fmt.Fprintf(cmd.ErrOrStderr(), "%s, %s in %s\n",
+				pluralize(errors, "error"), pluralize(warnings, "warning"), pluralize(len(files), "file"))
+		}
+	}
+
+	if errors > 0 || (lintStrictFlag && warnings > 0) {
+		os.Exit(output.ExitError)
+	}
+	return nil
+}
+
+// lintFiles expands paths into the Python scripts to lint. Directories
+// are searched recursively, skipping hidden directories, virtualenvs and
+// caches.
+func lintFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != p && (strings.HasPrefix(name, ".") || name == "__pycache__" || name == "node_modules" || name == "venv") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, ".py") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
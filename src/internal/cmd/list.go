@@ -45,7 +45,7 @@ func runList(cmd *cobra.Command, args []string) error {
 	}
 
 	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
-	fmt.Fprintln(w, "PORT\tPID\tSOURCE\tSCRIPT")
+	fmt.Fprintln(w, "PORT\tPID\tSOURCE\tNAME\tSCRIPT")
 	for _, s := range servers {
 		pid := ""
 		if s.PID > 0 {
@@ -55,7 +55,7 @@ func runList(cmd *cobra.Command, args []string) error {
 		if s.ContainerID != "" {
 			script = fmt.Sprintf("%s (%s)", s.Script, s.ContainerID[:minLen(12, len(s.ContainerID))])
 		}
-		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Port, pid, s.Source, script)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", s.Port, pid, s.Source, s.Name, script)
 	}
 	return w.Flush()
 }
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/notebook"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	notebookVersionFlag    string
+	notebookPortFlag       int
+	notebookJVMArgsFlag    string
+	notebookHostFlag       string
+	notebookTargetFlag     string
+	notebookAuthTypeFlag   string
+	notebookAuthTokenFlag  string
+	notebookTLSFlag        bool
+	notebookKernelOnlyFlag bool
+	notebookNoBrowserFlag  bool
+)
+
+func addNotebookCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "notebook [DIR] [-- JUPYTER_ARGS...]",
+		Short: "Launch JupyterLab with a Deephaven kernel",
+		Long: `Launch JupyterLab with a kernel for a Deephaven version.
+
+The kernel runs in the version's venv with JAVA_HOME set, and starts an
+embedded Deephaven server when it starts (available as "server"). With
+--host or --target it connects to a running server instead (available
+as "session"). ipykernel and JupyterLab are installed into the venv the
+first time.
+
+The kernel is kept in ~/.dh/jupyter; --kernel-only installs it without
+starting JupyterLab, for use from another Jupyter. Arguments after --
+are passed to JupyterLab.
+
+Examples:
+  dh notebook
+  dh notebook notebooks/ --version 0.36.0
+  dh notebook --target analytics
+  dh notebook --kernel-only
+  dh notebook -- --ServerApp.port=9999`,
+		RunE: runNotebook,
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&notebookVersionFlag, "version", "", "Deephaven version to use")
+	flags.IntVar(&notebookPortFlag, "port", 10000, "Deephaven server port (0 = any free port)")
+	flags.StringVar(&notebookJVMArgsFlag, "jvm-args", defaultJVMArgs, "JVM arguments for the embedded server (quoted string)")
+	flags.StringVar(&notebookHostFlag, "host", "", "Connect the kernel to this server instead of starting one")
+	flags.StringVar(&notebookTargetFlag, "target", "", "Connect the kernel to the running server started with dh serve --name NAME")
+	flags.StringVar(&notebookAuthTypeFlag, "auth-type", "", "Authentication type for remote connection")
+	flags.StringVar(&notebookAuthTokenFlag, "auth-token", "", "Authentication token for remote connection")
+	flags.BoolVar(&notebookTLSFlag, "tls", false, "Use TLS for remote connection")
+	flags.BoolVar(&notebookKernelOnlyFlag, "kernel-only", false, "Install the kernel and exit without starting JupyterLab")
+	flags.BoolVar(&notebookNoBrowserFlag, "no-browser", false, "Don't open a browser")
+
+	parent.AddCommand(cmd)
+}
+
+func runNotebook(cmd *cobra.Command, args []string) error {
+	var jupyterArgs []string
+	if n := cmd.ArgsLenAtDash(); n >= 0 {
+		args, jupyterArgs = args[:n], args[n:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("accepts at most 1 directory, received %d", len(args))
+	}
+
+	kernel, dhHome, kernelDir, err := setupNotebook(cmd)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "notebook_error", err.Error())
+		}
+		return err
+	}
+
+	if notebookKernelOnlyFlag {
+		if output.IsJSON() {
+			return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+				"kernel":       kernel.Name(),
+				"display_name": kernel.DisplayName(),
+				"path":         kernelDir,
+			})
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Installed kernel %q (%s) in %s\n", kernel.Name(), kernel.DisplayName(), kernelDir)
+		if !output.IsQuiet() {
+			fmt.Fprintf(cmd.OutOrStdout(), "To use it from another Jupyter, add %s to JUPYTER_PATH or run:\n  jupyter kernelspec install --user %s\n",
+				notebook.DataDir(dhHome), kernelDir)
+		}
+		return nil
+	}
+
+	labArgs := []string{"-m", "jupyterlab"}
+	if len(args) > 0 {
+		labArgs = append(labArgs, args[0])
+	}
+	if notebookNoBrowserFlag {
+		labArgs = append(labArgs, "--no-browser")
+	}
+	labArgs = append(labArgs, "--MultiKernelManager.default_kernel_name="+kernel.Name())
+	labArgs = append(labArgs, jupyterArgs...)
+
+	if !output.IsQuiet() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Starting JupyterLab with kernel %s...\n", kernel.DisplayName())
+	}
+	lab := exec.CommandContext(cmd.Context(), kernel.PythonBin, labArgs...)
+	lab.Env = append(os.Environ(), notebook.JupyterPathEnv(dhHome))
+	if kernel.JavaHome != "" {
+		lab.Env = append(lab.Env, "JAVA_HOME="+kernel.JavaHome)
+	}
+	lab.Stdin = cmd.InOrStdin()
+	lab.Stdout = cmd.OutOrStdout()
+	lab.Stderr = cmd.ErrOrStderr()
+	output.TraceCommand(lab)
+	if err := lab.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return fmt.Errorf("running JupyterLab: %w", err)
+	}
+	return nil
+}
+
+// setupNotebook installs the kernel (and Jupyter, if missing) and
+// returns it with the config directory and the kernel's directory.
+func setupNotebook(cmd *cobra.Command) (*notebook.Kernel, string, string, error) {
+	kernel, dhHome, err := notebookKernel(cmd)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if err := notebook.EnsureJupyter(kernel.PythonBin, output.IsQuiet(), cmd.ErrOrStderr()); err != nil {
+		return nil, "", "", err
+	}
+	kernelDir, err := notebook.Install(dhHome, kernel)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return kernel, dhHome, kernelDir, nil
+}
+
+// notebookKernel resolves the version, venv and server settings for the
+// kernel from the flags and config.
+func notebookKernel(cmd *cobra.Command) (*notebook.Kernel, string, error) {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	k := &notebook.Kernel{
+		Version:   notebookVersionFlag,
+		JVMArgs:   notebookJVMArgsFlag,
+		Port:      notebookPortFlag,
+		Host:      notebookHostFlag,
+		AuthType:  notebookAuthTypeFlag,
+		AuthToken: notebookAuthTokenFlag,
+		TLS:       notebookTLSFlag,
+	}
+	eff, _, err := config.LoadEffective()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := applyHostAlias(cmd, eff, &k.Host, &k.Port, &k.AuthType, &k.AuthToken, &k.TLS, nil); err != nil {
+		return nil, "", err
+	}
+	if err := applyTarget(cmd, notebookTargetFlag, &k.Host, &k.Port, &k.AuthType, &k.AuthToken, &k.Version); err != nil {
+		return nil, "", err
+	}
+
+	version, err := config.ResolveVersion(k.Version, os.Getenv("DH_VERSION"))
+	if err != nil {
+		return nil, "", fmt.Errorf("resolving version: %w", err)
+	}
+	k.Version = version
+	if k.PythonBin, err = dhexec.FindVenvPython(dhHome, version); err != nil {
+		return nil, "", fmt.Errorf("finding venv python: %w", err)
+	}
+	if err := dhexec.EnsurePydeephaven(k.PythonBin, version, output.IsQuiet(), cmd.ErrOrStderr()); err != nil {
+		return nil, "", fmt.Errorf("ensuring pydeephaven: %w", err)
+	}
+
+	if k.Host == "" {
+		javaInfo, err := java.Detect(dhHome)
+		if err != nil {
+			return nil, "", fmt.Errorf("detecting Java: %w", err)
+		}
+		if !javaInfo.Found {
+			return nil, "", fmt.Errorf("Java not found; install Java 17+ or set JAVA_HOME")
+		}
+		if err := java.ValidateFlags(dhHome, javaInfo.Path, java.SplitFlags(k.JVMArgs)); err != nil {
+			return nil, "", err
+		}
+		k.JavaHome = javaInfo.Home
+	}
+	return k, dhHome, nil
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/portable"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportPortableOutputFlag string
+	exportPortableNoJavaFlag bool
+	importPortableDirFlag    string
+)
+
+func addPortableCommands(parent *cobra.Command) {
+	exportCmd := &cobra.Command{
+		Use:   "export-portable <VERSION>",
+		Short: "Export a version as a portable directory for hosts without network access",
+		Long: `Export an installed version as a relocatable directory, packed in a tar:
+the dh binary, the version's venv with the Python it was made from, a JDK,
+and a launcher script. The directory is named after the output file.
+
+On the target machine (same OS and architecture) unpack it anywhere and
+run the launcher, which uses the directory as its DH_HOME:
+
+  tar xf dh-portable.tar
+  ./dh-portable/dh exec script.py
+
+or, where dh is already installed, 'dh import-portable dh-portable.tar'.
+The venv must use a Python managed by uv (the default for dh install).`,
+		Args: cobra.ExactArgs(1),
+		RunE: runExportPortable,
+	}
+	exportCmd.Flags().StringVarP(&exportPortableOutputFlag, "output", "o", "dh-portable.tar", "Tar to write; gzipped if it ends in .gz or .tgz")
+	exportCmd.Flags().BoolVar(&exportPortableNoJavaFlag, "no-java", false, "Leave out the JDK (the target has Java 17+)")
+
+	importCmd := &cobra.Command{
+		Use:   "import-portable <FILE|DIR>",
+		Short: "Unpack a portable export and make it runnable where it is",
+		Long: `Unpack a tar made by 'dh export-portable' into --dir and point its venv
+at the Python in the new location. Given a portable directory instead, it
+only does the second step; the launcher does that itself when the
+directory has moved.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runImportPortable,
+	}
+	importCmd.Flags().StringVarP(&importPortableDirFlag, "dir", "d", ".", "Directory to unpack into")
+
+	parent.AddCommand(exportCmd, importCmd)
+}
+
+func runExportPortable(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding dh binary: %w", err)
+	}
+	opts := portable.ExportOptions{
+		DHHome:  config.DHHome(),
+		Version: args[0],
+		Binary:  binary,
+		Tool:    Version,
+		NoJava:  exportPortableNoJavaFlag,
+	}
+	onProgress := func(msg string) {
+		if !output.IsQuiet() {
+			fmt.Fprintln(cmd.ErrOrStderr(), msg)
+		}
+	}
+	out := exportPortableOutputFlag
+	m, err := portable.Export(opts, out, onProgress)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "portable_error", err.Error())
+		}
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"path":     out,
+			"manifest": m,
+		})
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote portable Deephaven %s to %s\n", m.Version, out)
+	if !output.IsQuiet() {
+		fmt.Fprintf(cmd.OutOrStdout(), "On the target: tar xf %s && ./%s/%s exec script.py\n", filepath.Base(out), portable.DirName(out), portable.LauncherName)
+	}
+	return nil
+}
+
+func runImportPortable(cmd *cobra.Command, args []string) error {
+	res, err := portable.Import(args[0], importPortableDirFlag)
+	if err != nil {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "portable_error", err.Error())
+		}
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), res)
+	}
+	if output.IsQuiet() {
+		return nil
+	}
+	launcher := filepath.Join(res.Dir, portable.LauncherName)
+	if !filepath.IsAbs(launcher) && !strings.HasPrefix(launcher, ".") {
+		launcher = "./" + launcher
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Deephaven %s is ready in %s\n", res.Manifest.Version, res.Dir)
+	fmt.Fprintf(cmd.OutOrStdout(), "Run it with: %s exec script.py\n", launcher)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
 	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
 	"github.com/dsmmcken/dh-cli/src/internal/java"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
@@ -18,12 +19,15 @@ var (
 	replJVMArgsFlag       string
 	replVersionFlag       string
 	replHostFlag          string
+	replTargetFlag        string
+	replNameFlag          string
 	replAuthTypeFlag      string
 	replAuthTokenFlag     string
 	replTLSFlag           bool
 	replTLSCACertFlag     string
 	replTLSClientCertFlag string
 	replTLSClientKeyFlag  string
+	replLanguageFlag      string
 )
 
 func addReplCommand(parent *cobra.Command) {
@@ -38,22 +42,28 @@ stdout, stderr, errors, and result values.
 Examples:
   dh repl                                    # Embedded mode
   dh repl --host localhost:10000             # Remote mode
-  dh repl --port 8080                        # Custom port`,
+  dh repl --port 8080                        # Custom port
+  dh repl --target analytics                 # Server from dh serve --name
+  dh repl --name scratch                     # Let --target scratch use this server
+  dh repl --host prod --language groovy      # Groovy console on a remote server`,
 		Args: cobra.NoArgs,
 		RunE: runRepl,
 	}
 
 	flags := cmd.Flags()
-	flags.IntVar(&replPortFlag, "port", 10000, "Server port")
+	flags.IntVar(&replPortFlag, "port", 10000, "Server port (0 = any free port)")
 	flags.StringVar(&replJVMArgsFlag, "jvm-args", "-Xmx4g -DAuthHandlers=io.deephaven.auth.AnonymousAuthenticationHandler", "JVM arguments (quoted string)")
 	flags.StringVar(&replVersionFlag, "version", "", "Deephaven version to use")
 	flags.StringVar(&replHostFlag, "host", "", "Remote server host (enables remote mode)")
+	flags.StringVar(&replTargetFlag, "target", "", "Connect to the running server started with dh serve --name NAME")
+	flags.StringVar(&replNameFlag, "name", "", "Name the embedded server so --target NAME can use it")
 	flags.StringVar(&replAuthTypeFlag, "auth-type", "", "Authentication type for remote connection")
 	flags.StringVar(&replAuthTokenFlag, "auth-token", "", "Authentication token for remote connection")
 	flags.BoolVar(&replTLSFlag, "tls", false, "Use TLS for remote connection")
 	flags.StringVar(&replTLSCACertFlag, "tls-ca-cert", "", "Path to CA certificate for TLS")
 	flags.StringVar(&replTLSClientCertFlag, "tls-client-cert", "", "Path to client certificate for TLS")
 	flags.StringVar(&replTLSClientKeyFlag, "tls-client-key", "", "Path to client private key for TLS")
+	flags.StringVar(&replLanguageFlag, "language", "python", "Console language: python or groovy, which needs --host or --target")
 
 	parent.AddCommand(cmd)
 }
@@ -64,6 +74,18 @@ func runRepl(cmd *cobra.Command, args []string) error {
 	dhHome := config.DHHome()
 	envVersion := os.Getenv("DH_VERSION")
 
+	if err := applyTarget(cmd, replTargetFlag, &replHostFlag, &replPortFlag, &replAuthTypeFlag, &replAuthTokenFlag, &replVersionFlag); err != nil {
+		return err
+	}
+	if replNameFlag != "" {
+		if replHostFlag != "" {
+			return fmt.Errorf("--name names an embedded server; it cannot be used with --host or --target")
+		}
+		if other, err := discovery.Lookup(dhHome, replNameFlag); err == nil {
+			return fmt.Errorf("a server named %q is already running on port %d", replNameFlag, other.Port)
+		}
+	}
+
 	version, err := config.ResolveVersion(replVersionFlag, envVersion)
 	if err != nil {
 		return fmt.Errorf("resolving version: %w", err)
@@ -80,9 +102,25 @@ func runRepl(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("ensuring pydeephaven: %w", err)
 	}
 
+	if eff, _, err := config.LoadEffective(); err == nil {
+		if err := applyHostAlias(cmd, eff, &replHostFlag, &replPortFlag, &replAuthTypeFlag, &replAuthTokenFlag, &replTLSFlag,
+			&tlsFiles{&replTLSCACertFlag, &replTLSClientCertFlag, &replTLSClientKeyFlag}); err != nil {
+			return err
+		}
+	}
+
 	// Detect Java for embedded mode
 	var javaHome string
 	isRemote := replHostFlag != ""
+	switch replLanguageFlag {
+	case "python":
+	case "groovy":
+		if !isRemote {
+			return fmt.Errorf("--language groovy requires --host or --target: the embedded server runs Python")
+		}
+	default:
+		return fmt.Errorf("invalid --language %q: must be python or groovy", replLanguageFlag)
+	}
 	if !isRemote {
 		javaInfo, err := java.Detect(dhHome)
 		if err != nil {
@@ -92,6 +130,16 @@ func runRepl(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("Java not found; install Java 17+ or set JAVA_HOME")
 		}
 		javaHome = javaInfo.Home
+		if err := java.ValidateFlags(dhHome, javaInfo.Path, java.SplitFlags(replJVMArgsFlag)); err != nil {
+			return err
+		}
+
+		port, err := dhexec.ResolvePort(replPortFlag)
+		if err != nil {
+			return err
+		}
+		dhexec.ReportPort(replPortFlag, port, output.IsVerbose(), output.IsQuiet(), cmd.ErrOrStderr())
+		replPortFlag = port
 	}
 
 	// Build session config
@@ -100,6 +148,8 @@ func runRepl(cmd *cobra.Command, args []string) error {
 		JVMArgs:       replJVMArgsFlag,
 		Version:       version,
 		Host:          replHostFlag,
+		Name:          replNameFlag,
+		Language:      replLanguageFlag,
 		AuthType:      replAuthTypeFlag,
 		AuthToken:     replAuthTokenFlag,
 		TLS:           replTLSFlag,
@@ -3,9 +3,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dsmmcken/dh-cli/src/internal/cleanup"
 	"github.com/dsmmcken/dh-cli/src/internal/config"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
 	"github.com/dsmmcken/dh-cli/src/internal/tui"
@@ -20,16 +22,18 @@ var ProcessStart = time.Now()
 var Version = "dev"
 
 var (
-	jsonFlag    bool
-	verboseFlag bool
-	quietFlag   bool
-	noColorFlag bool
-	ConfigDir   string
+	jsonFlag     bool
+	verboseFlag  int
+	quietFlag    bool
+	noColorFlag  bool
+	annotateFlag bool
+	ConfigDir    string
 )
 
 func NewRootCmd() *cobra.Command {
 	cmd := newRootCmd()
 	addConfigCommands(cmd)
+	addAuthCommands(cmd)
 	addJavaCommands(cmd)
 	addDiscoveryCommands(cmd)
 	addVersionCommands(cmd)
@@ -37,8 +41,25 @@ func NewRootCmd() *cobra.Command {
 	addSetupCommand(cmd)
 	addExecCommand(cmd)
 	addServeCommand(cmd)
+	addServerDaemonCommands(cmd)
 	addReplCommand(cmd)
 	addVMCommands(cmd)
+	addSnippetCommands(cmd)
+	addHistoryCommands(cmd)
+	addBundleCommands(cmd)
+	addDiffCommand(cmd)
+	addNotebookCommand(cmd)
+	addLintCommand(cmd)
+	addTestCommand(cmd)
+	addEnvCommand(cmd)
+	addCacheCommands(cmd)
+	addInitCommand(cmd)
+	addStatusCommand(cmd)
+	addCleanupCommand(cmd)
+	addDockerCommands(cmd)
+	addPortableCommands(cmd)
+	addCompletionCommand(cmd)
+	registerCompletions(cmd)
 	return cmd
 }
 
@@ -51,13 +72,20 @@ func newRootCmd() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			if verboseFlag && quietFlag {
+			if verboseFlag > 0 && quietFlag {
 				return fmt.Errorf("--verbose and --quiet are mutually exclusive")
 			}
 			if jsonFlag {
 				quietFlag = true
 			}
-			output.SetFlags(jsonFlag, quietFlag, verboseFlag)
+			output.SetFlags(jsonFlag, quietFlag, verboseFlag > 0)
+			output.SetTrace(verboseFlag > 1)
+			// Annotations are on in GitHub Actions unless --annotate=false
+			if !cmd.Flags().Changed("annotate") {
+				annotateFlag = os.Getenv("GITHUB_ACTIONS") == "true"
+			}
+			output.SetAnnotate(annotateFlag)
+			sweepLeftovers(cmd)
 			return nil
 		},
 		Args: cobra.NoArgs,
@@ -88,9 +116,10 @@ func newRootCmd() *cobra.Command {
 
 	pflags := rootCmd.PersistentFlags()
 	pflags.BoolVarP(&jsonFlag, "json", "j", false, "Output as JSON")
-	pflags.BoolVarP(&verboseFlag, "verbose", "v", false, "Extra detail to stderr")
+	pflags.CountVarP(&verboseFlag, "verbose", "v", "Extra detail to stderr (-vv adds trace output of internals)")
 	pflags.BoolVarP(&quietFlag, "quiet", "q", false, "Suppress non-essential output")
 	pflags.BoolVar(&noColorFlag, "no-color", false, "Disable ANSI colors")
+	pflags.BoolVar(&annotateFlag, "annotate", false, "Also report failures as GitHub Actions annotations (default: on in GitHub Actions)")
 	pflags.StringVar(&ConfigDir, "config-dir", "", "Override config directory (default: ~/.dh)")
 
 	// Environment variable bindings
@@ -107,6 +136,19 @@ func newRootCmd() *cobra.Command {
 	return rootCmd
 }
 
+// sweepLeftovers removes the VM instance directories and sockets left by
+// dh processes that were killed. Completion requests skip it to stay fast,
+// and dh cleanup to report what it finds.
+func sweepLeftovers(cmd *cobra.Command) {
+	if strings.HasPrefix(cmd.Name(), "__") || cmd.Name() == "cleanup" {
+		return
+	}
+	config.SetConfigDir(ConfigDir)
+	for _, it := range cleanup.Sweep(config.DHHome()) {
+		output.Tracef("removed leftover %s %s (%s)", it.Kind, it, it.Reason)
+	}
+}
+
 func Execute() error {
 	cmd := NewRootCmd()
 	return cmd.Execute()
@@ -13,6 +13,7 @@ import (
 
 	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
 	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
 	"github.com/dsmmcken/dh-cli/src/internal/java"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
 	"github.com/dsmmcken/dh-cli/src/internal/tui/screens"
@@ -25,6 +26,7 @@ var (
 	serveNoBrowserFlag bool
 	serveIframeFlag    string
 	serveVersionFlag   string
+	serveNameFlag      string
 )
 
 func addServeCommand(parent *cobra.Command) {
@@ -40,21 +42,26 @@ Use for:
 
 Opens browser automatically. Server runs until Ctrl+C.
 
+With --name, other commands can use the running server instead of
+starting their own: dh exec --target NAME, dh repl --target NAME.
+
 Examples:
   dh serve dashboard.py
   dh serve dashboard.py --port 8080
   dh serve dashboard.py --iframe my_widget
-  dh serve dashboard.py --no-browser`,
+  dh serve dashboard.py --no-browser
+  dh serve pipeline.py --name analytics --no-browser`,
 		Args: cobra.ExactArgs(1),
 		RunE: runServe,
 	}
 
 	flags := cmd.Flags()
-	flags.IntVar(&servePortFlag, "port", 10000, "Server port")
+	flags.IntVar(&servePortFlag, "port", 10000, "Server port (0 = any free port)")
 	flags.StringVar(&serveJVMArgsFlag, "jvm-args", "-Xmx4g -DAuthHandlers=io.deephaven.auth.AnonymousAuthenticationHandler", "JVM arguments (quoted string)")
 	flags.BoolVar(&serveNoBrowserFlag, "no-browser", false, "Don't open browser automatically")
 	flags.StringVar(&serveIframeFlag, "iframe", "", "Open browser to iframe URL for the given widget name")
 	flags.StringVar(&serveVersionFlag, "version", "", "Deephaven version to use")
+	flags.StringVar(&serveNameFlag, "name", "", "Name the server so --target NAME can use it")
 
 	parent.AddCommand(cmd)
 }
@@ -72,6 +79,12 @@ func runServe(cmd *cobra.Command, args []string) error {
 	// Resolve version
 	config.SetConfigDir(ConfigDir)
 	dhHome := config.DHHome()
+	if serveNameFlag != "" {
+		if other, err := discovery.Lookup(dhHome, serveNameFlag); err == nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Error: a server named %q is already running on port %d\n", serveNameFlag, other.Port)
+			os.Exit(output.ExitError)
+		}
+	}
 	envVersion := os.Getenv("DH_VERSION")
 
 	version, err := config.ResolveVersion(serveVersionFlag, envVersion)
@@ -115,10 +128,21 @@ func runServe(cmd *cobra.Command, args []string) error {
 	if output.IsVerbose() {
 		fmt.Fprintf(cmd.ErrOrStderr(), "Using Java: %s (version %s)\n", javaInfo.Path, javaInfo.Version)
 	}
+	if err := java.ValidateFlags(dhHome, javaInfo.Path, java.SplitFlags(serveJVMArgsFlag)); err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Error: %v\n", err)
+		os.Exit(output.ExitError)
+	}
+
+	port, err := dhexec.ResolvePort(servePortFlag)
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Error: %v\n", err)
+		os.Exit(output.ExitError)
+	}
+	dhexec.ReportPort(servePortFlag, port, output.IsVerbose(), output.IsQuiet(), cmd.ErrOrStderr())
 
 	// Build runner args
 	runnerArgs := []string{"--mode", "serve"}
-	runnerArgs = append(runnerArgs, "--port", fmt.Sprintf("%d", servePortFlag))
+	runnerArgs = append(runnerArgs, "--port", fmt.Sprintf("%d", port))
 	if serveJVMArgsFlag != "" {
 		runnerArgs = append(runnerArgs, fmt.Sprintf("--jvm-args=%s", serveJVMArgsFlag))
 	}
@@ -144,6 +168,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 	// Set JAVA_HOME
 	process.Env = append(os.Environ(), fmt.Sprintf("JAVA_HOME=%s", javaInfo.Home))
+	process.Env = append(process.Env, dhexec.GRPCTraceEnv()...)
 
 	// Process group for clean cleanup
 	process.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
@@ -151,6 +176,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 	// Pipe user script to stdin
 	process.Stdin = strings.NewReader(string(scriptContent))
 	process.Stderr = cmd.ErrOrStderr()
+	output.TraceCommand(process)
 
 	// Pipe stdout so we can detect the ready sentinel
 	stdoutPipe, err := process.StdoutPipe()
@@ -163,6 +189,21 @@ func runServe(cmd *cobra.Command, args []string) error {
 		fmt.Fprintf(cmd.ErrOrStderr(), "Error: starting runner: %v\n", err)
 		os.Exit(output.ExitError)
 	}
+	reg := discovery.Registration{Server: discovery.Server{
+		Port:    port,
+		PID:     process.Process.Pid,
+		Source:  "dh serve",
+		Script:  scriptPath,
+		CWD:     callerCwd,
+		Name:    serveNameFlag,
+		Version: version,
+	}}
+	reg.AuthType, reg.AuthToken = discovery.AuthFromJVMArgs(strings.Fields(serveJVMArgsFlag))
+	if unregister, err := discovery.Register(dhHome, reg); err == nil {
+		defer unregister()
+	} else if serveNameFlag != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: %v; --target %s will not find this server\n", err, serveNameFlag)
+	}
 
 	// Signal handling: first SIGINT → graceful, second → force kill
 	sigCh := make(chan os.Signal, 1)
@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/serverdaemon"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serverDaemonVersionsFlag    []string
+	serverDaemonIdleTimeoutFlag string
+	serverDaemonJVMArgsFlag     string
+	serverDaemonBackgroundFlag  bool
+)
+
+func addServerDaemonCommands(parent *cobra.Command) {
+	daemonCmd := &cobra.Command{
+		Use:   "server-daemon",
+		Short: "Keep embedded servers warm for dh exec",
+		Long: `Manage a daemon that keeps one embedded Deephaven server running per
+version, so dh exec without --host, --target or --vm skips the JVM startup.
+
+While the daemon runs, dh exec borrows the server of its version over the
+daemon's Unix socket, one run at a time, instead of starting its own.
+Before each run, the names earlier runs left in the server's scope are
+deleted. dh exec --no-daemon starts a server of its own as before.
+
+Subcommands:
+  start   Start the daemon
+  stop    Stop the daemon and its servers
+  status  Show the daemon's servers`,
+	}
+
+	startCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start the server daemon",
+		Long: `Start the server daemon in the foreground, or in the background with
+--background, logging to ~/.dh/server-daemon.log.
+
+The servers of --version start right away; the default is the resolved
+version. Other versions start the first time dh exec asks for them. The
+daemon stops its servers and exits after --idle-timeout without runs.`,
+		Args: cobra.NoArgs,
+		RunE: runServerDaemonStart,
+	}
+	startCmd.Flags().StringArrayVar(&serverDaemonVersionsFlag, "version", nil, "Deephaven version to start a server for right away (repeatable; default: resolved version)")
+	startCmd.Flags().StringVar(&serverDaemonIdleTimeoutFlag, "idle-timeout", "30m", "Shut down after this long without runs (0: never)")
+	startCmd.Flags().StringVar(&serverDaemonJVMArgsFlag, "jvm-args", defaultJVMArgs, "JVM arguments of the servers (quoted string)")
+	startCmd.Flags().BoolVar(&serverDaemonBackgroundFlag, "background", false, "Run the daemon in the background")
+
+	stopCmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the server daemon and its servers",
+		Args:  cobra.NoArgs,
+		RunE:  runServerDaemonStop,
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the server daemon's servers",
+		Args:  cobra.NoArgs,
+		RunE:  runServerDaemonStatus,
+	}
+
+	daemonCmd.AddCommand(startCmd, stopCmd, statusCmd)
+	parent.AddCommand(daemonCmd)
+}
+
+func runServerDaemonStart(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	idleTimeout, err := time.ParseDuration(serverDaemonIdleTimeoutFlag)
+	if err != nil {
+		return fmt.Errorf("invalid idle-timeout: %w", err)
+	}
+	versions := serverDaemonVersionsFlag
+	if len(versions) == 0 {
+		if v, err := config.ResolveVersion("", os.Getenv("DH_VERSION")); err == nil {
+			versions = []string{v}
+		}
+	}
+	if serverdaemon.Probe() {
+		return fmt.Errorf("the server daemon is already running; stop it with 'dh server-daemon stop'")
+	}
+
+	if serverDaemonBackgroundFlag {
+		return runServerDaemonBackground(cmd, dhHome, versions, idleTimeout)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	d := serverdaemon.New(serverdaemon.Config{
+		DHHome:      dhHome,
+		ConfigDir:   ConfigDir,
+		Versions:    versions,
+		IdleTimeout: idleTimeout,
+		JVMArgs:     serverDaemonJVMArgsFlag,
+		Verbose:     output.IsVerbose(),
+	})
+	return d.Start(ctx, cmd.ErrOrStderr())
+}
+
+// runServerDaemonBackground starts the daemon again, without --background,
+// in a session of its own, and waits for its socket.
+func runServerDaemonBackground(cmd *cobra.Command, dhHome string, versions []string, idleTimeout time.Duration) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("getting executable path: %w", err)
+	}
+	daemonArgs := []string{"server-daemon", "start",
+		"--idle-timeout", idleTimeout.String(),
+		"--jvm-args=" + serverDaemonJVMArgsFlag,
+	}
+	for _, v := range versions {
+		daemonArgs = append(daemonArgs, "--version", v)
+	}
+	if ConfigDir != "" {
+		daemonArgs = append(daemonArgs, "--config-dir", ConfigDir)
+	}
+	if output.IsVerbose() {
+		daemonArgs = append(daemonArgs, "-v")
+	}
+
+	if err := os.MkdirAll(dhHome, 0o755); err != nil {
+		return err
+	}
+	logPath := filepath.Join(dhHome, "server-daemon.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	defer logFile.Close()
+
+	daemonCmd := exec.Command(exePath, daemonArgs...)
+	daemonCmd.Stdout = logFile
+	daemonCmd.Stderr = logFile
+	daemonCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	daemonCmd.Env = os.Environ()
+	if err := daemonCmd.Start(); err != nil {
+		return fmt.Errorf("starting daemon: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), 10*time.Second)
+	defer cancel()
+	for ctx.Err() == nil {
+		if serverdaemon.Probe() {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Server daemon started (pid=%d, log=%s)\n", daemonCmd.Process.Pid, logPath)
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("the server daemon did not start; check %s", logPath)
+}
+
+func runServerDaemonStop(cmd *cobra.Command, args []string) error {
+	if !serverdaemon.Probe() {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Server daemon is not running.")
+		return nil
+	}
+	resp, err := serverdaemon.Command(&serverdaemon.Request{Type: "stop"})
+	if err != nil {
+		return fmt.Errorf("sending stop: %w", err)
+	}
+	if resp.Type == "error" {
+		return fmt.Errorf("server daemon error: %s", resp.Error)
+	}
+	// The daemon stops its servers before it exits
+	for serverdaemon.Probe() {
+		time.Sleep(200 * time.Millisecond)
+	}
+	fmt.Fprintln(cmd.ErrOrStderr(), "Server daemon stopped.")
+	return nil
+}
+
+func runServerDaemonStatus(cmd *cobra.Command, args []string) error {
+	if !serverdaemon.Probe() {
+		if output.IsJSON() {
+			return output.PrintJSON(cmd.OutOrStdout(), map[string]any{"running": false})
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Server daemon is not running.")
+		return nil
+	}
+	resp, err := serverdaemon.Command(&serverdaemon.Request{Type: "status"})
+	if err != nil {
+		return fmt.Errorf("getting status: %w", err)
+	}
+	if resp.Type == "error" || resp.Status == nil {
+		return fmt.Errorf("server daemon error: %s", resp.Error)
+	}
+
+	s := resp.Status
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"running":      true,
+			"pid":          s.PID,
+			"idle_seconds": s.IdleSeconds,
+			"idle_timeout": s.IdleTimeout,
+			"servers":      s.Servers,
+		})
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Server daemon (pid=%d)\n", s.PID)
+	if s.IdleTimeout > 0 {
+		fmt.Fprintf(out, "  Idle:  %ds (timeout: %ds)\n", s.IdleSeconds, s.IdleTimeout)
+	} else {
+		fmt.Fprintf(out, "  Idle:  %ds (no timeout)\n", s.IdleSeconds)
+	}
+	if len(s.Servers) == 0 {
+		fmt.Fprintln(out, "  No servers yet")
+	}
+	for _, srv := range s.Servers {
+		line := fmt.Sprintf("  %-12s %-8s", srv.Version, srv.State)
+		if srv.Port != 0 {
+			line += fmt.Sprintf(" port %d, pid %d,", srv.Port, srv.PID)
+		}
+		line += fmt.Sprintf(" %d runs", srv.Runs)
+		if srv.Waiting > 0 {
+			line += fmt.Sprintf(", %d waiting", srv.Waiting)
+		}
+		fmt.Fprintln(out, line)
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/snippet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	snippetCodeFlag        string
+	snippetDescriptionFlag string
+	snippetForceFlag       bool
+	snippetParamFlags      []string
+	snippetVMFlag          bool
+	snippetVersionFlag     string
+	snippetTimeoutFlag     int
+	snippetOutputFlag      string
+)
+
+func addSnippetCommands(parent *cobra.Command) {
+	snippetCmd := &cobra.Command{
+		Use:   "snippet",
+		Short: "Save and run named code snippets",
+		Long: `Save, list, and run named code snippets stored in ~/.dh/snippets.toml.
+
+Snippet code may contain {{name}} or {{name:default}} placeholders, filled
+in at run time with -p name=value.
+
+Examples:
+  dh snippet add top10 -c "t = {{table}}.head({{n:10}})"
+  dh snippet run top10 -p table=trades --vm
+  dh snippet export -o team.toml
+  dh snippet import team.toml`,
+		Args: cobra.NoArgs,
+		RunE: runSnippetList,
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add <NAME> [FILE]",
+		Short: "Save a snippet",
+		Long:  "Save a snippet from -c CODE, a file, or stdin (use - for stdin).",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  runSnippetAdd,
+	}
+	addCmd.Flags().StringVarP(&snippetCodeFlag, "code", "c", "", "Snippet code")
+	addCmd.Flags().StringVarP(&snippetDescriptionFlag, "description", "d", "", "Short description shown by list")
+	addCmd.Flags().BoolVar(&snippetForceFlag, "force", false, "Replace an existing snippet with the same name")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List saved snippets",
+		Args:  cobra.NoArgs,
+		RunE:  runSnippetList,
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show <NAME>",
+		Short: "Print a snippet's code",
+		Long:  "Print a snippet's code, with placeholders filled in when -p values are given.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runSnippetShow,
+	}
+	showCmd.Flags().StringArrayVarP(&snippetParamFlags, "param", "p", nil, "Parameter value NAME=VALUE (repeatable)")
+
+	runCmd := &cobra.Command{
+		Use:   "run <NAME>",
+		Short: "Run a snippet",
+		Long:  "Run a saved snippet the same way as dh exec, substituting -p NAME=VALUE parameters.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runSnippetRun,
+	}
+	runCmd.Flags().StringArrayVarP(&snippetParamFlags, "param", "p", nil, "Parameter value NAME=VALUE (repeatable)")
+	runCmd.Flags().BoolVar(&snippetVMFlag, "vm", false, "Execute in a Firecracker microVM (experimental, Linux only)")
+	runCmd.Flags().StringVar(&snippetVersionFlag, "version", "", "Deephaven version to use")
+	runCmd.Flags().IntVar(&snippetTimeoutFlag, "timeout", 0, "Execution timeout in seconds (0 = no timeout)")
+
+	removeCmd := &cobra.Command{
+		Use:     "remove <NAME>",
+		Aliases: []string{"rm"},
+		Short:   "Delete a snippet",
+		Args:    cobra.ExactArgs(1),
+		RunE:    runSnippetRemove,
+	}
+
+	exportCmd := &cobra.Command{
+		Use:   "export [NAME...]",
+		Short: "Export snippets to a file",
+		Long:  "Write snippets (all, or the named ones) as TOML to stdout or the -o file.",
+		RunE:  runSnippetExport,
+	}
+	exportCmd.Flags().StringVarP(&snippetOutputFlag, "output", "o", "", "Write to FILE instead of stdout")
+
+	importCmd := &cobra.Command{
+		Use:   "import <FILE>",
+		Short: "Import snippets from a file",
+		Long:  "Import snippets from a file written by dh snippet export (use - for stdin).",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runSnippetImport,
+	}
+	importCmd.Flags().BoolVar(&snippetForceFlag, "force", false, "Replace existing snippets with the same name")
+
+	snippetCmd.AddCommand(addCmd, listCmd, showCmd, runCmd, removeCmd, exportCmd, importCmd)
+	parent.AddCommand(snippetCmd)
+}
+
+// snippetError reports err in the active output mode and exits non-zero.
+// A missing snippet exits with ExitNotFound.
+func snippetError(cmd *cobra.Command, err error) error {
+	code, exitCode := "snippet_error", output.ExitError
+	if errors.Is(err, snippet.ErrNotFound) {
+		code, exitCode = "not_found", output.ExitNotFound
+	}
+	if output.IsJSON() {
+		_ = output.PrintError(cmd.ErrOrStderr(), code, err.Error())
+		os.Exit(exitCode)
+	}
+	if exitCode != output.ExitError {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Error:", err)
+		os.Exit(exitCode)
+	}
+	return err
+}
+
+func runSnippetAdd(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	code := snippetCodeFlag
+	switch {
+	case code != "" && len(args) == 2:
+		return snippetError(cmd, fmt.Errorf("cannot use both -c and a file"))
+	case code == "" && len(args) < 2:
+		return snippetError(cmd, fmt.Errorf("must provide either -c CODE or a file (use - for stdin)"))
+	case len(args) == 2:
+		data, err := readSnippetSource(args[1])
+		if err != nil {
+			return snippetError(cmd, err)
+		}
+		code = data
+	}
+
+	s := snippet.Snippet{Name: args[0], Description: snippetDescriptionFlag, Code: code}
+	if err := snippet.Add(dhHome, s, snippetForceFlag); err != nil {
+		return snippetError(cmd, err)
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"name":   s.Name,
+			"params": snippet.Params(code),
+			"status": "saved",
+		})
+	}
+	if !output.IsQuiet() {
+		fmt.Fprintf(cmd.OutOrStdout(), "Saved snippet %s\n", s.Name)
+	}
+	return nil
+}
+
+func readSnippetSource(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+func runSnippetList(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	list, err := snippet.List(dhHome)
+	if err != nil {
+		return snippetError(cmd, err)
+	}
+
+	if output.IsJSON() {
+		type entry struct {
+			snippet.Snippet
+			Params []string `json:"params"`
+		}
+		entries := make([]entry, 0, len(list))
+		for _, s := range list {
+			params := snippet.Params(s.Code)
+			if params == nil {
+				params = []string{}
+			}
+			entries = append(entries, entry{s, params})
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{"snippets": entries})
+	}
+
+	if len(list) == 0 {
+		if !output.IsQuiet() {
+			fmt.Fprintln(cmd.OutOrStdout(), "No snippets saved. Add one with: dh snippet add NAME -c CODE")
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPARAMS\tDESCRIPTION")
+	for _, s := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, strings.Join(snippet.Params(s.Code), ","), s.Description)
+	}
+	return w.Flush()
+}
+
+func runSnippetShow(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	s, err := snippet.Get(dhHome, args[0])
+	if err != nil {
+		return snippetError(cmd, err)
+	}
+
+	code := s.Code
+	if len(snippetParamFlags) > 0 {
+		params, err := snippet.ParseParams(snippetParamFlags)
+		if err != nil {
+			return snippetError(cmd, err)
+		}
+		if code, err = snippet.Render(s.Code, params); err != nil {
+			return snippetError(cmd, err)
+		}
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"name":        s.Name,
+			"description": s.Description,
+			"code":        code,
+			"params":      snippet.Params(s.Code),
+		})
+	}
+	fmt.Fprint(cmd.OutOrStdout(), code)
+	if !strings.HasSuffix(code, "\n") {
+		fmt.Fprintln(cmd.OutOrStdout())
+	}
+	return nil
+}
+
+func runSnippetRun(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	s, err := snippet.Get(dhHome, args[0])
+	if err != nil {
+		return snippetError(cmd, err)
+	}
+	params, err := snippet.ParseParams(snippetParamFlags)
+	if err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+	code, err := snippet.Render(s.Code, params)
+	if err != nil {
+		return finishExec(cmd, output.ExitError, nil, err)
+	}
+
+	cfg := &dhexec.ExecConfig{
+		Code:          code,
+		Port:          10000,
+		JVMArgs:       defaultJVMArgs,
+		Timeout:       snippetTimeoutFlag,
+		ShowTables:    true,
+		ShowTableMeta: true,
+		JSONMode:      output.IsJSON(),
+		Verbose:       output.IsVerbose(),
+		Quiet:         output.IsQuiet(),
+		Version:       snippetVersionFlag,
+		VMMode:        snippetVMFlag,
+		ConfigDir:     ConfigDir,
+		ProcessStart:  ProcessStart,
+		Stderr:        cmd.ErrOrStderr(),
+		Stdout:        cmd.OutOrStdout(),
+	}
+	exitCode, jsonResult, err := dhexec.Run(cfg)
+	return finishExec(cmd, exitCode, jsonResult, err)
+}
+
+func runSnippetRemove(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	if err := snippet.Remove(dhHome, args[0]); err != nil {
+		return snippetError(cmd, err)
+	}
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"name":   args[0],
+			"status": "removed",
+		})
+	}
+	if !output.IsQuiet() {
+		fmt.Fprintf(cmd.OutOrStdout(), "Removed snippet %s\n", args[0])
+	}
+	return nil
+}
+
+func runSnippetExport(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	w := cmd.OutOrStdout()
+	if snippetOutputFlag != "" {
+		f, err := os.Create(snippetOutputFlag)
+		if err != nil {
+			return snippetError(cmd, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := snippet.Export(dhHome, args, w); err != nil {
+		return snippetError(cmd, err)
+	}
+	if snippetOutputFlag != "" && !output.IsQuiet() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Exported snippets to %s\n", snippetOutputFlag)
+	}
+	return nil
+}
+
+func runSnippetImport(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return snippetError(cmd, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	imported, skipped, err := snippet.Import(dhHome, r, snippetForceFlag)
+	if err != nil {
+		return snippetError(cmd, err)
+	}
+
+	if output.IsJSON() {
+		if imported == nil {
+			imported = []string{}
+		}
+		if skipped == nil {
+			skipped = []string{}
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"imported": imported,
+			"skipped":  skipped,
+		})
+	}
+	if !output.IsQuiet() {
+		fmt.Fprintf(cmd.OutOrStdout(), "Imported %d snippet(s)\n", len(imported))
+		if len(skipped) > 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), "Skipped existing: %s (use --force to replace)\n", strings.Join(skipped, ", "))
+		}
+	}
+	return nil
+}
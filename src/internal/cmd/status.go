@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/cache"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+	"github.com/spf13/cobra"
+)
+
+var statusOfflineFlag bool
+
+// LatestVersionFetcher looks up the newest release for dh status.
+// Replaceable in unit tests.
+var LatestVersionFetcher = versions.FetchLatestVersion
+
+// updateCheckTimeout bounds the PyPI lookup so an unreachable network
+// doesn't hold up the rest of the report.
+const updateCheckTimeout = 5 * time.Second
+
+// StatusReport is the output of dh status.
+type StatusReport struct {
+	Version        string                      `json:"version"`         // resolved for this directory, or ""
+	DefaultVersion string                      `json:"default_version"` // from config.toml, or ""
+	Installed      []versions.InstalledVersion `json:"installed"`
+	Java           java.JavaInfo               `json:"java"`
+	Servers        []discovery.Server          `json:"servers"`
+	ServersError   string                      `json:"servers_error,omitempty"`
+	Pool           vm.PoolStatus               `json:"pool"`
+	Snapshots      []StatusSnapshot            `json:"snapshots"`
+	Disk           StatusDisk                  `json:"disk"`
+	Update         StatusUpdate                `json:"update"`
+}
+
+// StatusSnapshot is a VM snapshot and whether it can be restored.
+type StatusSnapshot struct {
+	Version string `json:"version"`
+	Ready   bool   `json:"ready"`
+}
+
+// StatusDisk is the disk space dh uses.
+type StatusDisk struct {
+	DHHome        string `json:"dh_home"`
+	UsedBytes     int64  `json:"used_bytes"`     // all of DH_HOME
+	VersionsBytes int64  `json:"versions_bytes"` // installed versions
+	CacheBytes    int64  `json:"cache_bytes"`    // what dh cache clean can free
+	FreeBytes     uint64 `json:"free_bytes"`
+}
+
+// StatusUpdate is the result of the PyPI check for a newer release.
+type StatusUpdate struct {
+	Checked   bool   `json:"checked"`
+	Latest    string `json:"latest,omitempty"`
+	Available bool   `json:"available"` // Latest is newer than every installed version
+	Error     string `json:"error,omitempty"`
+}
+
+func addStatusCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Summarize the dh environment",
+		Long: `Summarize the whole environment in one place: the version in use and
+the installed ones, Java, running servers, the VM pool daemon and
+snapshots, disk usage, and whether a newer Deephaven release is out.
+
+When asking for help, include the output of 'dh status --json'.
+--offline skips the release check, the only part that needs the network.`,
+		Args: cobra.NoArgs,
+		RunE: runStatus,
+	}
+	cmd.Flags().BoolVar(&statusOfflineFlag, "offline", false, "Skip the check for a newer release on PyPI")
+	parent.AddCommand(cmd)
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	// The release check is the slow part; run it alongside the rest.
+	updateCh := make(chan StatusUpdate, 1)
+	if statusOfflineFlag {
+		updateCh <- StatusUpdate{}
+	} else {
+		go func() { updateCh <- checkForUpdate(dhHome) }()
+	}
+
+	r := StatusReport{Servers: []discovery.Server{}, Snapshots: []StatusSnapshot{}}
+	r.Version, _ = config.ResolveVersion("", os.Getenv("DH_VERSION"))
+	if cfg, err := config.Load(); err == nil {
+		r.DefaultVersion = cfg.DefaultVersion
+	}
+	r.Installed, _ = versions.ListInstalled(dhHome)
+	if r.Installed == nil {
+		r.Installed = []versions.InstalledVersion{}
+	}
+	if info, err := java.Detect(dhHome); err == nil {
+		r.Java = *info
+	}
+
+	if servers, err := discovery.Discover(); err != nil {
+		r.ServersError = err.Error()
+	} else if servers != nil {
+		r.Servers = servers
+	}
+
+	if vm.PoolProbe() {
+		if resp, err := vm.PoolCommand(&vm.PoolRequest{Type: "status"}); err == nil && resp.Status != nil {
+			r.Pool = *resp.Status
+			r.Pool.Running = true
+		}
+	}
+
+	paths := vm.NewVMPaths(dhHome)
+	snaps, _ := vm.ListSnapshots(paths)
+	for _, s := range snaps {
+		r.Snapshots = append(r.Snapshots, StatusSnapshot{Version: s.Version, Ready: s.Complete})
+	}
+
+	r.Disk.DHHome = dhHome
+	r.Disk.UsedBytes = vm.DiskUsage(dhHome)
+	r.Disk.VersionsBytes = vm.DiskUsage(filepath.Join(dhHome, "versions"))
+	for i := range cache.Categories {
+		for _, e := range cache.Categories[i].Entries(dhHome) {
+			r.Disk.CacheBytes += e.SizeBytes
+		}
+	}
+	r.Disk.FreeBytes, _ = diskFree(dhHome)
+
+	r.Update = <-updateCh
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), r)
+	}
+	printStatus(cmd, &r)
+	return nil
+}
+
+// checkForUpdate asks PyPI for the latest release, giving up after
+// updateCheckTimeout.
+func checkForUpdate(dhHome string) StatusUpdate {
+	type result struct {
+		latest string
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		latest, err := LatestVersionFetcher()
+		ch <- result{latest, err}
+	}()
+
+	var res result
+	select {
+	case res = <-ch:
+	case <-time.After(updateCheckTimeout):
+		res.err = fmt.Errorf("timed out after %s", updateCheckTimeout)
+	}
+	if res.err != nil {
+		return StatusUpdate{Checked: true, Error: res.err.Error()}
+	}
+
+	u := StatusUpdate{Checked: true, Latest: res.latest, Available: true}
+	installed := versions.InstalledNames(dhHome)
+	if len(installed) > 0 {
+		newest := []string{res.latest, installed[0]}
+		versions.SortVersionsDesc(newest)
+		u.Available = newest[0] == res.latest && res.latest != installed[0]
+	}
+	return u
+}
+
+func printStatus(cmd *cobra.Command, r *StatusReport) {
+	w := cmd.OutOrStdout()
+	row := func(label, format string, a ...any) {
+		fmt.Fprintf(w, "%-11s %s\n", label, fmt.Sprintf(format, a...))
+	}
+
+	switch {
+	case r.Version == "" && len(r.Installed) == 0:
+		row("Deephaven", "none installed (run: dh install)")
+	case r.Version == "":
+		row("Deephaven", "no version set (run: dh use VERSION)")
+	default:
+		note := ""
+		if r.Version == r.DefaultVersion {
+			note = "default, "
+		}
+		var names []string
+		installed := false
+		for _, v := range r.Installed {
+			names = append(names, v.Version)
+			installed = installed || v.Version == r.Version
+		}
+		if !installed {
+			row("Deephaven", "%s (%snot installed; run: dh install %s)", r.Version, note, r.Version)
+		} else {
+			row("Deephaven", "%s (%s%s installed: %s)", r.Version, note, pluralize(len(r.Installed), "version"), strings.Join(names, ", "))
+		}
+	}
+
+	if r.Java.Found {
+		row("Java", "%s (%s)", r.Java.Version, r.Java.Source)
+	} else {
+		row("Java", "not found (run: dh java install)")
+	}
+
+	switch {
+	case r.ServersError != "":
+		row("Servers", "could not check: %s", r.ServersError)
+	case len(r.Servers) == 0:
+		row("Servers", "none running")
+	default:
+		var ports []string
+		for _, s := range r.Servers {
+			p := fmt.Sprintf(":%d", s.Port)
+			if s.Name != "" {
+				p += " " + s.Name
+			}
+			ports = append(ports, p)
+		}
+		row("Servers", "%d running (%s)", len(r.Servers), strings.Join(ports, ", "))
+	}
+
+	if r.Pool.Running {
+		served := r.Pool.Version
+		if len(r.Pool.Versions) > 1 {
+			var vs []string
+			for _, v := range r.Pool.Versions {
+				vs = append(vs, v.Version)
+			}
+			served = strings.Join(vs, ", ")
+		}
+		row("Pool", "running (%s, %d/%d VMs ready, idle %ds)", served, r.Pool.Ready, r.Pool.TargetSize, r.Pool.IdleSeconds)
+	} else {
+		row("Pool", "not running")
+	}
+
+	if len(r.Snapshots) == 0 {
+		row("Snapshots", "none")
+	} else {
+		var snaps []string
+		for _, s := range r.Snapshots {
+			state := "ready"
+			if !s.Ready {
+				state = "incomplete"
+			}
+			snaps = append(snaps, fmt.Sprintf("%s %s", s.Version, state))
+		}
+		row("Snapshots", "%s", strings.Join(snaps, ", "))
+	}
+
+	row("Disk", "%s in %s (%s versions, %s cache), %s free",
+		cache.FormatSize(r.Disk.UsedBytes), shortenHome(r.Disk.DHHome),
+		cache.FormatSize(r.Disk.VersionsBytes), cache.FormatSize(r.Disk.CacheBytes),
+		cache.FormatSize(int64(r.Disk.FreeBytes)))
+
+	u := r.Update
+	switch {
+	case !u.Checked:
+		row("Updates", "not checked (--offline)")
+	case u.Error != "":
+		row("Updates", "could not check: %s", u.Error)
+	case u.Available:
+		row("Updates", "%s available (run: dh install %s)", u.Latest, u.Latest)
+	default:
+		row("Updates", "up to date (%s)", u.Latest)
+	}
+}
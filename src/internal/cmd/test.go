@@ -0,0 +1,379 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/junit"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/spf13/cobra"
+)
+
+var (
+	testPatternFlag         string
+	testVMFlag              bool
+	testVersionFlag         string
+	testTimeoutFlag         int
+	testJUnitFlag           string
+	testSnapshotsFlag       string
+	testUpdateSnapshotsFlag bool
+	testFailFastFlag        bool
+)
+
+// Test statuses. A test fails when a check it makes does not hold: an
+// assertion, or drift from its snapshot. Anything else that stops it,
+// from an exception to a server that won't start, is an error.
+const (
+	testPassed = "passed"
+	testFailed = "failed"
+	testError  = "error"
+)
+
+// testResult is the outcome of one test script.
+type testResult struct {
+	Name      string                 `json:"name"`
+	Status    string                 `json:"status"`
+	ExitCode  int                    `json:"exit_code"`
+	ErrorType any                    `json:"error_type"`
+	Message   string                 `json:"message,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Snapshot  string                 `json:"snapshot,omitempty"`
+	Drift     []string               `json:"drift,omitempty"`
+	Stdout    string                 `json:"stdout"`
+	Stderr    string                 `json:"stderr"`
+	Tables    []dhexec.RecordedTable `json:"tables"`
+	Elapsed   float64                `json:"elapsed_seconds"`
+}
+
+func addTestCommand(parent *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "test [PATH...]",
+		Short: "Run test_*.py scripts, each in a fresh server",
+		Long: `Find the test scripts under PATH (default: the current directory) and run
+each in a server of its own, started for it (or a VM of its own with --vm),
+so no test sees what another left behind. A test passes when its script
+runs to the end; a failed assert fails it, and any other exception, or a
+server that can't be reached, is an error.
+
+The tables each test assigns are captured: their columns, row count and
+content hash. With --snapshots DIR they are also compared, with the
+test's stdout, to the snapshot saved in DIR by an earlier run, as
+dh exec --replay does; drift fails the test. A test without a snapshot
+saves one, and --update-snapshots saves them all again.
+
+--junit FILE writes a JUnit XML report for CI; --json prints the results.
+Exits with code 1 when a test fails or errors, and 4 when there are no
+tests.
+
+Examples:
+  dh test
+  dh test tests/ --vm --junit report.xml
+  dh test --snapshots tests/snapshots
+  dh test tests/test_orders.py --update-snapshots --snapshots tests/snapshots`,
+		RunE: runTest,
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&testPatternFlag, "pattern", "test_*.py", "File name pattern of test scripts in directories")
+	flags.BoolVar(&testVMFlag, "vm", false, "Run each test in a Firecracker microVM (experimental, Linux only)")
+	flags.StringVar(&testVersionFlag, "version", "", "Deephaven version to use")
+	flags.IntVar(&testTimeoutFlag, "timeout", 0, "Timeout of each test in seconds (0 = no timeout)")
+	flags.StringVar(&testJUnitFlag, "junit", "", "Write a JUnit XML report to FILE")
+	flags.StringVar(&testSnapshotsFlag, "snapshots", "", "Compare each test's stdout and tables to its snapshot in DIR, saving the missing ones")
+	flags.BoolVar(&testUpdateSnapshotsFlag, "update-snapshots", false, "With --snapshots, save every test's snapshot again instead of comparing")
+	flags.BoolVar(&testFailFastFlag, "fail-fast", false, "Stop at the first test that fails or errors")
+
+	parent.AddCommand(cmd)
+}
+
+// testCommandError reports err in the active output mode and exits
+// with exitCode.
+func testCommandError(cmd *cobra.Command, exitCode int, err error) error {
+	if output.IsJSON() {
+		code := "test_error"
+		if exitCode == output.ExitNotFound {
+			code = "not_found"
+		}
+		_ = output.PrintError(cmd.ErrOrStderr(), code, err.Error())
+		os.Exit(exitCode)
+	}
+	if exitCode != output.ExitError {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Error:", err)
+		os.Exit(exitCode)
+	}
+	return err
+}
+
+func runTest(cmd *cobra.Command, args []string) error {
+	if testUpdateSnapshotsFlag && testSnapshotsFlag == "" {
+		return testCommandError(cmd, output.ExitError, fmt.Errorf("--update-snapshots requires --snapshots"))
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	if _, err := filepath.Match(testPatternFlag, ""); err != nil {
+		return testCommandError(cmd, output.ExitError, fmt.Errorf("invalid --pattern %q: %w", testPatternFlag, err))
+	}
+	files, err := testFiles(args, testPatternFlag)
+	if err != nil {
+		return testCommandError(cmd, output.ExitError, err)
+	}
+	if len(files) == 0 {
+		return testCommandError(cmd, output.ExitNotFound, fmt.Errorf("no %s files in %s", testPatternFlag, strings.Join(args, ", ")))
+	}
+
+	start := time.Now()
+	var results []testResult
+	passed, failed, errored := 0, 0, 0
+	for _, path := range files {
+		r := runTestFile(path)
+		results = append(results, r)
+		switch r.Status {
+		case testPassed:
+			passed++
+		case testFailed:
+			failed++
+		default:
+			errored++
+		}
+		if !output.IsJSON() {
+			printTestResult(cmd, &r)
+		}
+		if r.ExitCode == output.ExitInterrupted || (testFailFastFlag && r.Status != testPassed) {
+			break
+		}
+	}
+	elapsed := time.Since(start).Seconds()
+
+	if testJUnitFlag != "" {
+		if err := writeJUnitReport(testJUnitFlag, start, results); err != nil {
+			return testCommandError(cmd, output.ExitError, err)
+		}
+	}
+
+	if output.IsJSON() {
+		if err := output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"tests":           len(results),
+			"passed":          passed,
+			"failed":          failed,
+			"errors":          errored,
+			"elapsed_seconds": elapsed,
+			"results":         results,
+		}); err != nil {
+			return err
+		}
+	} else if !output.IsQuiet() || failed+errored > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "\n%s: %d passed, %d failed, %s in %.2fs\n",
+			pluralize(len(results), "test"), passed, failed, pluralize(errored, "error"), elapsed)
+		if len(results) < len(files) {
+			fmt.Fprintf(cmd.OutOrStdout(), "%d not run\n", len(files)-len(results))
+		}
+	}
+
+	if failed+errored > 0 {
+		os.Exit(output.ExitError)
+	}
+	return nil
+}
+
+// testFiles expands paths into the test scripts to run: files are taken
+// as they are, and directories are searched recursively for files whose
+// name matches pattern, skipping what lintFiles skips. They are run in
+// the order found, each directory's in name order.
+func testFiles(paths []string, pattern string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != p && (strings.HasPrefix(name, ".") || name == "__pycache__" || name == "node_modules" || name == "venv") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if ok, _ := filepath.Match(pattern, d.Name()); ok {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// runTestFile runs the test script at path as dh exec --json would, in a
+// server of its own, and returns its result.
+func runTestFile(path string) testResult {
+	var stderr bytes.Buffer
+	cfg := &dhexec.ExecConfig{
+		ScriptPath:    path,
+		Port:          10000,
+		JVMArgs:       defaultJVMArgs,
+		Timeout:       testTimeoutFlag,
+		ShowTables:    true,
+		ShowTableMeta: true,
+		HashTables:    true,
+		JSONMode:      true,
+		Quiet:         true,
+		Version:       testVersionFlag,
+		VMMode:        testVMFlag,
+		NoDaemon:      true, // the daemon's server keeps the modules earlier runs imported
+		ConfigDir:     ConfigDir,
+		ProcessStart:  ProcessStart,
+		Stderr:        &stderr,
+		Stdout:        &bytes.Buffer{},
+	}
+	if testSnapshotsFlag != "" {
+		// Snapshots mirror the tree of the tests, so tests of the same
+		// name in different directories keep their own
+		dir := filepath.Join(testSnapshotsFlag, filepath.Dir(path))
+		if _, err := os.Stat(dhexec.RecordingPath(dir, path)); err == nil && !testUpdateSnapshotsFlag {
+			cfg.Replay = dir
+		} else {
+			cfg.Record = dir
+		}
+	}
+
+	start := time.Now()
+	exitCode, result, err := dhexec.Run(cfg)
+	r := testResult{Name: path, ExitCode: exitCode, Tables: []dhexec.RecordedTable{}, Elapsed: time.Since(start).Seconds()}
+	if err != nil {
+		if r.ExitCode == output.ExitSuccess {
+			r.ExitCode = output.ExitError
+		}
+		r.Status, r.ErrorType, r.Error = testError, dhexec.ErrorTypeError, err.Error()
+		r.Message = lastLine(r.Error)
+		r.Stderr = stderr.String()
+		return r
+	}
+
+	r.ErrorType = result["error_type"]
+	r.Error, _ = result["error"].(string)
+	r.Stdout, _ = result["stdout"].(string)
+	r.Stderr, _ = result["stderr"].(string)
+	r.Tables = dhexec.RecordingFromResult(result).Tables
+	r.Snapshot, _ = result["recording"].(string)
+	if replay, ok := result["replay"].(map[string]any); ok {
+		r.Snapshot, _ = replay["recording"].(string)
+		r.Drift, _ = replay["drift"].([]string)
+	}
+
+	switch {
+	case exitCode == output.ExitSuccess:
+		r.Status = testPassed
+	case r.ErrorType == dhexec.ErrorTypeAssertion || r.ErrorType == dhexec.ErrorTypeDrift:
+		r.Status = testFailed
+	default:
+		r.Status = testError
+	}
+	switch {
+	case r.Error != "":
+		r.Message = lastLine(r.Error)
+	case len(r.Drift) > 0:
+		r.Message = fmt.Sprintf("drifted from snapshot %s: %s", r.Snapshot, r.Drift[0])
+	case r.ErrorType != nil:
+		r.Message = fmt.Sprintf("%v (exit %d)", r.ErrorType, r.ExitCode)
+	}
+	return r
+}
+
+// printTestResult prints a line for r, followed by the traceback and
+// drift of a test that did not pass.
+func printTestResult(cmd *cobra.Command, r *testResult) {
+	w := cmd.OutOrStdout()
+	if r.Status == testPassed {
+		if !output.IsQuiet() {
+			fmt.Fprintf(w, "PASS  %s (%.2fs)\n", r.Name, r.Elapsed)
+		}
+		return
+	}
+	label := "FAIL "
+	if r.Status == testError {
+		label = "ERROR"
+	}
+	fmt.Fprintf(w, "%s %s (%.2fs)\n", label, r.Name, r.Elapsed)
+	detail := r.Error
+	if detail == "" {
+		detail = r.Message
+	}
+	for _, line := range strings.Split(strings.TrimRight(detail, "\n"), "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+	for _, d := range r.Drift {
+		fmt.Fprintf(w, "    %s\n", d)
+	}
+}
+
+// writeJUnitReport writes results, started at start, to path as JUnit XML.
+func writeJUnitReport(path string, start time.Time, results []testResult) error {
+	cases := make([]junit.Case, len(results))
+	for i, r := range results {
+		c := junit.Case{
+			Name:      r.Name,
+			Classname: strings.ReplaceAll(strings.TrimSuffix(filepath.ToSlash(r.Name), filepath.Ext(r.Name)), "/", "."),
+			File:      r.Name,
+			Time:      junit.Seconds(r.Elapsed),
+			SystemOut: r.Stdout,
+			SystemErr: r.Stderr,
+		}
+		if len(r.Tables) > 0 {
+			c.Properties = &junit.Properties{}
+		}
+		for _, t := range r.Tables {
+			hash := t.ContentHash
+			if t.HashError != "" {
+				hash = "no content hash: " + t.HashError
+			}
+			c.Properties.Items = append(c.Properties.Items, junit.Property{
+				Name:  "table." + t.Name,
+				Value: fmt.Sprintf("%d rows, %d columns, %s", t.RowCount, len(t.Columns), hash),
+			})
+		}
+		if r.Status != testPassed {
+			p := &junit.Problem{Message: r.Message, Type: fmt.Sprint(r.ErrorType), Text: r.Error}
+			if len(r.Drift) > 0 {
+				p.Text = strings.Join(r.Drift, "\n")
+			}
+			if r.Status == testFailed {
+				c.Failure = p
+			} else {
+				c.Error = p
+			}
+		}
+		cases[i] = c
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("writing JUnit report: %w", err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("writing JUnit report: %w", err)
+	}
+	defer f.Close()
+	if err := junit.Write(f, "dh test", junit.NewSuite("dh test", start, cases)); err != nil {
+		return fmt.Errorf("writing JUnit report: %w", err)
+	}
+	return f.Close()
+}
@@ -17,7 +17,7 @@ func newUseCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "use <VERSION>",
 		Short: "Set the default Deephaven version",
-		Long:  "Set a specific installed Deephaven version as the default. Use --local to set for the current directory only.",
+		Long:  "Set a specific installed Deephaven version as the default. Use --local to set for the current directory only.\n\nVERSION may be a constraint such as \"~41.0\" or \">=0.39,<41\"; it is saved as written and resolves to the newest matching installed version.",
 		Args:  cobra.ExactArgs(1),
 		RunE:  runUse,
 	}
@@ -38,18 +38,43 @@ func runUse(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	found := false
-	for _, v := range installed {
-		if v.Version == version {
-			found = true
-			break
+	// A constraint is stored as written and resolved each time a version is
+	// needed; it must match at least one installed version now.
+	resolved := ""
+	if versions.IsConstraint(version) {
+		c, err := versions.ParseConstraint(version)
+		if err != nil {
+			if output.IsJSON() {
+				return output.PrintError(cmd.ErrOrStderr(), "version_error", err.Error())
+			}
+			return err
 		}
-	}
-	if !found {
-		if output.IsJSON() {
-			return output.PrintError(cmd.ErrOrStderr(), "not_installed", fmt.Sprintf("version %s is not installed", version))
+		var names []string
+		for _, v := range installed {
+			names = append(names, v.Version)
+		}
+		match, ok := c.Newest(names)
+		if !ok {
+			if output.IsJSON() {
+				return output.PrintError(cmd.ErrOrStderr(), "not_installed", fmt.Sprintf("no installed version matches %s", version))
+			}
+			return fmt.Errorf("no installed version matches %s; run 'dh install \"%s\"' first", version, version)
+		}
+		resolved = match
+	} else {
+		found := false
+		for _, v := range installed {
+			if v.Version == version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if output.IsJSON() {
+				return output.PrintError(cmd.ErrOrStderr(), "not_installed", fmt.Sprintf("version %s is not installed", version))
+			}
+			return fmt.Errorf("version %s is not installed; run 'dh install %s' first", version, version)
 		}
-		return fmt.Errorf("version %s is not installed; run 'dh install %s' first", version, version)
 	}
 
 	scope := "global"
@@ -72,17 +97,25 @@ func runUse(cmd *cobra.Command, args []string) error {
 	}
 
 	if output.IsJSON() {
-		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+		result := map[string]any{
 			"version":     version,
 			"scope":       scope,
 			"config_path": configPath,
-		})
+		}
+		if resolved != "" {
+			result["resolved_version"] = resolved
+		}
+		return output.PrintJSON(cmd.OutOrStdout(), result)
 	}
 
+	shown := version
+	if resolved != "" {
+		shown = fmt.Sprintf("%s (currently %s)", version, resolved)
+	}
 	if useLocalFlag {
-		fmt.Fprintf(cmd.OutOrStdout(), "Set local version to %s (wrote .dhrc)\n", version)
+		fmt.Fprintf(cmd.OutOrStdout(), "Set local version to %s (wrote .dhrc)\n", shown)
 	} else {
-		fmt.Fprintf(cmd.OutOrStdout(), "Set default version to %s\n", version)
+		fmt.Fprintf(cmd.OutOrStdout(), "Set default version to %s\n", shown)
 	}
 
 	return nil
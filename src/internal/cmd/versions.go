@@ -28,6 +28,7 @@ func addVersionCommands(parent *cobra.Command) {
 	versionsCmd.Flags().BoolVar(&versionsRemoteFlag, "remote", false, "Also show available remote versions from PyPI")
 	versionsCmd.Flags().IntVar(&versionsLimitFlag, "limit", 20, "Limit number of remote versions shown")
 	versionsCmd.Flags().BoolVar(&versionsAllFlag, "all", false, "Show all remote versions (no limit)")
+	versionsCmd.AddCommand(newVersionsSBOMCmd())
 
 	parent.AddCommand(versionsCmd)
 	parent.AddCommand(newInstallCmd())
@@ -52,9 +53,14 @@ func runVersions(cmd *cobra.Command, args []string) error {
 		installed = []versions.InstalledVersion{}
 	}
 
-	// Mark the default version
+	// Mark the default version, which may be a constraint
+	names := make([]string, len(installed))
+	for i, v := range installed {
+		names[i] = v.Version
+	}
+	dflt, _ := versions.Select(cfg.DefaultVersion, names)
 	for i := range installed {
-		if installed[i].Version == cfg.DefaultVersion {
+		if dflt != "" && installed[i].Version == dflt {
 			installed[i].IsDefault = true
 		}
 	}
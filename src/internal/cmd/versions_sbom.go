@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/sbom"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sbomFormatFlag string
+	sbomOutputFlag string
+)
+
+func newVersionsSBOMCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sbom <VERSION>",
+		Short: "Generate an SBOM for an installed version",
+		Long: `Generate a software bill of materials for an installed Deephaven version.
+
+The document lists the Python packages in the version's venv, the Java
+runtime, and, if a VM rootfs was built for the version, its OS and Python
+packages. Formats are CycloneDX 1.5 JSON (default) and SPDX 2.3 JSON.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runVersionsSBOM,
+	}
+	cmd.Flags().StringVar(&sbomFormatFlag, "format", "cyclonedx", "SBOM format: cyclonedx or spdx")
+	cmd.Flags().StringVarP(&sbomOutputFlag, "output", "o", "", "Write the SBOM to a file instead of stdout")
+	return cmd
+}
+
+func runVersionsSBOM(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	if !slices.Contains(sbom.Formats, sbomFormatFlag) {
+		return fmt.Errorf("unknown SBOM format %q (use cyclonedx or spdx)", sbomFormatFlag)
+	}
+
+	version, ok := versions.Select(args[0], versions.InstalledNames(dhHome))
+	if !ok {
+		if output.IsJSON() {
+			return output.PrintError(cmd.ErrOrStderr(), "not_installed", fmt.Sprintf("version %s is not installed", args[0]))
+		}
+		return fmt.Errorf("version %s is not installed", args[0])
+	}
+
+	inv, err := sbom.Collect(dhHome, version, Version)
+	if err != nil {
+		return err
+	}
+	doc, err := sbom.Encode(inv, sbomFormatFlag)
+	if err != nil {
+		return err
+	}
+
+	if sbomOutputFlag == "" {
+		_, err := fmt.Fprintln(cmd.OutOrStdout(), string(doc))
+		return err
+	}
+	if err := os.WriteFile(sbomOutputFlag, append(doc, '\n'), 0o644); err != nil {
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"version":    version,
+			"format":     sbomFormatFlag,
+			"path":       sbomOutputFlag,
+			"components": len(inv.Components),
+			"notes":      inv.Notes,
+		})
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s SBOM for Deephaven %s to %s (%d components)\n",
+		sbomFormatFlag, version, sbomOutputFlag, len(inv.Components))
+	for _, n := range inv.Notes {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Note: %s\n", n)
+	}
+	return nil
+}
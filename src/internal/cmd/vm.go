@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/dsmmcken/dh-cli/src/internal/cache"
 	"github.com/dsmmcken/dh-cli/src/internal/config"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
 	"github.com/dsmmcken/dh-cli/src/internal/versions"
@@ -11,7 +18,32 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var vmVersionFlag string
+var (
+	vmVersionFlag  string
+	vmCompressFlag bool
+	vmNoPoolFlag   bool
+	vmVCPUsFlag    int
+	vmMemoryFlag   int
+	vmJailedFlag   bool
+	vmNetFlag      string
+	vmOutputFlag   string
+	vmForceFlag    bool
+	vmProfileFlag  string
+	vmRequireFlag  string
+	vmBaseImage    string
+	vmPythonFlag   string
+	vmJVMArgsFlag  string
+	vmKernelFlag   string
+	vmHugePages    bool
+	vmWritableRoot bool
+	vmKeepLatest   int
+	vmOlderThan    string
+	vmDryRunFlag   bool
+	vmInstanceFlag string
+	vmLastFlag     bool
+	vmFollowFlag   bool
+	vmRunnerFlag   bool
+)
 
 func addVMCommands(parent *cobra.Command) {
 	vmCmd := &cobra.Command{
@@ -25,7 +57,15 @@ near-instant Deephaven server startup via snapshot restore.
 Subcommands:
   prepare  Build rootfs and create snapshot for a Deephaven version
   status   Show snapshot and prerequisite status
-  clean    Remove VM artifacts (rootfs, snapshots, run state)`,
+  shell    Open an interactive shell in a restored VM
+  up       Start a named VM that stays up between execs
+  down     Stop named VMs started by up
+  session  List and stop the named VMs of 'dh exec --vm --session'
+  export   Write a snapshot to an archive for use on another machine
+  import   Install a snapshot from an archive written by export
+  clean    Remove VM artifacts (rootfs, snapshots, run state)
+  gc       Remove unused rootfs images, snapshots and run directories
+  logs     Show the serial console and runner logs of VMs`,
 	}
 
 	// dh vm prepare
@@ -36,7 +76,8 @@ Subcommands:
 
 This command:
   1. Downloads Firecracker binary and kernel (if needed)
-  2. Builds an ext4 rootfs image with JVM + Deephaven (via Docker)
+  2. Builds an ext4 rootfs image with JVM + Deephaven (via Docker, Podman,
+     nerdctl or mmdebstrap)
   3. Boots a fresh Firecracker VM from the rootfs
   4. Waits for the Deephaven server to fully initialize
   5. Pauses the VM and creates a memory+state snapshot
@@ -44,10 +85,88 @@ This command:
 First run takes 2-5 minutes. Subsequent runs for the same version
 skip the rootfs build.
 
-Requirements: Linux, /dev/kvm access, Docker.`,
+The kernel downloaded is the one pinned in this dh for the host's
+architecture, checked against its SHA-256. Without one, install a kernel
+with --kernel PATH, or set DH_VM_UNVERIFIED_KERNEL=1 to download the newest
+CI kernel unverified.
+
+With --compress the snapshot memory is stored zstd-compressed in 2 MiB
+blocks, typically a fraction of its size, and decompressed block by block
+as the restored VM touches it. Restoring a compressed snapshot needs
+userfaultfd.
+
+--vcpus and --memory size the VM (default 2 vCPUs, 4608 MiB). The size is
+stored with the snapshot and every VM restored from it gets the same; the
+JVM heap is the memory less 512 MiB.
+
+With --jailed Firecracker runs under its jailer: chrooted in ~/.dh/vm/jail,
+in its own cgroup, with seccomp filters and as an unprivileged user
+(DH_VM_JAIL_UID and DH_VM_JAIL_GID, default 65534). This needs root. A
+jailed snapshot is only restored jailed ('dh exec --vm --jailed'), and the
+pool daemon does not serve it.
+
+With --hugepages the guest memory is backed by 2 MiB huge pages, so the
+restored VM takes fewer page faults and TLB misses. The host must have a
+free huge page for every 2 MiB of VM memory (sudo sysctl -w
+vm.nr_hugepages=N), for the prepare and for every VM restored from the
+snapshot, and restoring it needs userfaultfd. Such a snapshot has no
+balloon device and is not made sparse by one, so it is larger on disk.
+
+With --net=nat the VM has a network interface with outbound access through
+NAT on the host, e.g. for pip installs or fetching data; by default VMs are
+isolated and reach the host only over vsock. Every VM restored from such a
+snapshot gets its own network namespace, tap device and iptables rules,
+which needs root and ip and iptables on the host, and it is only restored
+with --net=nat ('dh exec --vm --net=nat'); the pool daemon does not serve
+it. A rootfs built before --net existed can't configure the network: run
+'dh vm clean --version VERSION' first.
+
+With --profile the snapshot is a named variant of the version's, kept in
+~/.dh/vm/snapshots/VERSION/PROFILE, whose rootfs also has the packages of
+the --requirements file installed with pip. Run code in it with
+'dh exec --vm --profile PROFILE'. The profile's rootfs is rebuilt when the
+requirements file changes; without --requirements the existing one is used.
+
+The rootfs is built with the first of docker, podman and nerdctl whose
+daemon answers (rootless engines work too), or with the one named by
+DH_VM_CONTAINER_ENGINE. Without any, mmdebstrap installs Ubuntu from
+DH_VM_UBUNTU_MIRROR (default: the public archive) and the same steps run in
+it; DH_VM_CONTAINER_ENGINE=none picks mmdebstrap.
+
+The guest's root is read-only: the disk is attached read-only and the guest
+runs on an overlay of it that keeps its changes, /tmp and /workspace
+included, in memory. VMs restored from the snapshot then share the disk
+without copies, and a script can't change it for the next. With
+--writable-root the guest mounts the disk read-write as before, and every
+restored VM gets a copy of it. A rootfs built before read-only roots gets a
+writable root until rebuilt with 'dh vm clean --version VERSION'.
+
+--base-image builds the rootfs on another Debian or Ubuntu image with
+OpenJDK 17 packages (default ubuntu:22.04; needs a container engine), and
+--python installs that Python version from the image's packages instead of
+its python3. The rootfs is rebuilt when either differs from the one it was
+built with. --guest-jvm-args adds JVM options to the Deephaven server in
+the VM, e.g. "-Xss4m -Duser.timezone=UTC"; they are part of the snapshot.
+The defaults come from the vm.base_image, vm.python_version and
+vm.guest_jvm_args config keys.
+
+Requirements: Linux, /dev/kvm access, Docker, Podman, nerdctl or mmdebstrap.`,
 		RunE: runVMPrepare,
 	}
 	prepareCmd.Flags().StringVar(&vmVersionFlag, "version", "", "Deephaven version (default: resolved version)")
+	prepareCmd.Flags().BoolVar(&vmCompressFlag, "compress", false, "Compress the snapshot memory (smaller on disk, needs userfaultfd to restore)")
+	prepareCmd.Flags().IntVar(&vmVCPUsFlag, "vcpus", vm.DefaultVCPUCount, "Number of vCPUs of the VM")
+	prepareCmd.Flags().IntVar(&vmMemoryFlag, "memory", vm.DefaultMemSizeMiB, "VM memory in MiB")
+	prepareCmd.Flags().BoolVar(&vmJailedFlag, "jailed", false, "Run Firecracker under the jailer (needs root)")
+	prepareCmd.Flags().StringVar(&vmNetFlag, "net", "", "The VM's network: none (default) or nat for outbound access through NAT on the host (needs root)")
+	prepareCmd.Flags().StringVar(&vmProfileFlag, "profile", "", "Prepare a named snapshot profile of the version")
+	prepareCmd.Flags().StringVar(&vmRequireFlag, "requirements", "", "requirements.txt to install in the profile's rootfs (needs --profile)")
+	prepareCmd.Flags().StringVar(&vmBaseImage, "base-image", "", "Image to build the rootfs on (default: vm.base_image or ubuntu:22.04)")
+	prepareCmd.Flags().StringVar(&vmPythonFlag, "python", "", "Python version to install in the rootfs, e.g. 3.11 (default: vm.python_version or the image's python3)")
+	prepareCmd.Flags().StringVar(&vmJVMArgsFlag, "guest-jvm-args", "", "Extra JVM options for the server in the VM (default: vm.guest_jvm_args)")
+	prepareCmd.Flags().StringVar(&vmKernelFlag, "kernel", "", "Use this vmlinux as the guest kernel instead of the pinned download")
+	prepareCmd.Flags().BoolVar(&vmHugePages, "hugepages", false, "Back the guest memory with 2 MiB huge pages (needs free huge pages and userfaultfd)")
+	prepareCmd.Flags().BoolVar(&vmWritableRoot, "writable-root", false, "Mount the guest's root read-write instead of on an overlay of the read-only disk")
 
 	// dh vm status
 	statusCmd := &cobra.Command{
@@ -63,9 +182,122 @@ Requirements: Linux, /dev/kvm access, Docker.`,
 		Long:  "Remove rootfs images, snapshots, and runtime state from ~/.dh/vm/.",
 		RunE:  runVMClean,
 	}
-	cleanCmd.Flags().StringVar(&vmVersionFlag, "version", "", "Clean only this version (default: all)")
+	cleanCmd.Flags().StringVar(&vmVersionFlag, "version", "", "Clean only this version and its profiles (default: all)")
+	cleanCmd.Flags().StringVar(&vmProfileFlag, "profile", "", "Clean only this profile of --version")
+
+	// dh vm gc
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove unused rootfs images, snapshots and run directories",
+		Long: `Remove what accumulates under ~/.dh/vm: run directories of VMs that are
+gone, incomplete snapshots, and rootfs images no snapshot was made from.
+
+--keep-latest N also removes the snapshots of all but the N newest
+versions, with their rootfs images. --older-than spares anything changed
+(for a snapshot: restored) within that age; alone, it removes every
+snapshot not used for that long. Ages are like 30d, 2w or 12h. The
+snapshots of the version dh resolves to are always kept, and nothing that
+changed in the last hour is removed, so a running 'dh vm prepare' is
+safe.
+
+The defaults come from the vm.gc_keep_latest and vm.gc_older_than config
+keys. With vm.auto_gc set to true, 'dh vm prepare' runs it after every
+snapshot it makes, keeping the version it prepared.
+
+Examples:
+  dh vm gc --dry-run
+  dh vm gc --keep-latest 2
+  dh vm gc --older-than 30d`,
+		Args: cobra.NoArgs,
+		RunE: runVMGC,
+	}
+	gcCmd.Flags().IntVar(&vmKeepLatest, "keep-latest", 0, "Keep snapshots of only the N newest versions (default: vm.gc_keep_latest, or all)")
+	gcCmd.Flags().StringVar(&vmOlderThan, "older-than", "", "Only remove what was not changed or used within this age, e.g. 30d (default: vm.gc_older_than)")
+	gcCmd.Flags().BoolVar(&vmDryRunFlag, "dry-run", false, "Show what would be removed without removing it")
+
+	// dh vm logs
+	logsCmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Show the serial console and runner logs of VMs",
+		Long: `Show the serial console log of a VM: the guest kernel, init.sh, the
+Deephaven server and the runner daemon. Every VM dh starts, for dh vm
+prepare, dh exec --vm, dh vm shell or the pool, writes one to its run
+directory; when the VM is torn down it moves to ~/.dh/vm/logs, which keeps
+the newest 50.
+
+Without --instance or --last the logs are listed, newest first. --runner
+shows only the runner daemon's lines, and -f keeps printing what a running
+VM adds until it exits.
+
+Examples:
+  dh vm logs
+  dh vm logs --last
+  dh vm logs --instance exec-1712345678901234567 --runner
+  dh vm logs --last -f`,
+		Args: cobra.NoArgs,
+		RunE: runVMLogs,
+	}
+	logsCmd.Flags().StringVar(&vmInstanceFlag, "instance", "", "Show the log of this instance ID")
+	logsCmd.Flags().BoolVar(&vmLastFlag, "last", false, "Show the log of the newest instance")
+	logsCmd.Flags().BoolVarP(&vmFollowFlag, "follow", "f", false, "Keep printing while the VM runs")
+	logsCmd.Flags().BoolVar(&vmRunnerFlag, "runner", false, "Show only the runner daemon's lines")
+	logsCmd.MarkFlagsMutuallyExclusive("instance", "last")
+
+	// dh vm shell
+	shellCmd := &cobra.Command{
+		Use:   "shell",
+		Short: "Open an interactive shell in a restored VM",
+		Long: `Open an interactive shell in a Firecracker VM restored from snapshot.
+
+The shell runs on a PTY in the guest, with the Deephaven server of the
+snapshot already up, and the current directory is available at /workspace.
+A warm VM is taken from the pool daemon when it is running for the same
+version; otherwise the VM is restored from the snapshot. Either way the VM
+is destroyed when the shell exits.
+
+The terminal size is taken when the shell starts; resizing the window
+afterwards does not reach the guest.`,
+		Args: cobra.NoArgs,
+		RunE: runVMShell,
+	}
+	shellCmd.Flags().StringVar(&vmVersionFlag, "version", "", "Deephaven version (default: resolved version)")
+	shellCmd.Flags().BoolVar(&vmNoPoolFlag, "no-pool", false, "Always restore a new VM instead of using the pool daemon")
+	shellCmd.Flags().BoolVar(&vmJailedFlag, "jailed", false, "Restore the VM under the jailer from a jailed snapshot (needs root, never uses the pool)")
+	shellCmd.Flags().StringVar(&vmNetFlag, "net", "", "Restore the VM with a network from a snapshot prepared with --net=nat (needs root, never uses the pool)")
+
+	// dh vm export
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write a snapshot to an archive",
+		Long: `Write the snapshot for a version to a zstd-compressed tar, so machines
+without Docker can import it instead of running 'dh vm prepare'.
+
+The archive holds the snapshot's disk, memory, VM state and metadata, the
+kernel it was taken with, and a SHA-256 checksum of each. It can only be
+imported on the same architecture with the same Firecracker release.`,
+		Args: cobra.NoArgs,
+		RunE: runVMExport,
+	}
+	exportCmd.Flags().StringVar(&vmVersionFlag, "version", "", "Deephaven version (default: resolved version)")
+	exportCmd.Flags().StringVarP(&vmOutputFlag, "output", "o", "", "Archive to write (default: dh-vm-VERSION-ARCH.tar.zst)")
+
+	// dh vm import
+	importCmd := &cobra.Command{
+		Use:   "import ARCHIVE",
+		Short: "Install a snapshot from an archive",
+		Long: `Install a snapshot from an archive written by 'dh vm export'.
+
+Every file is checked against its checksum before the snapshot is put in
+place. The archive's kernel is installed if there is none yet; if a
+different kernel is installed, the import is refused. An existing snapshot
+for the same version is only replaced with --force.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runVMImport,
+	}
+	importCmd.Flags().BoolVar(&vmForceFlag, "force", false, "Replace an existing snapshot for the version")
 
-	vmCmd.AddCommand(prepareCmd, statusCmd, cleanCmd)
+	vmCmd.AddCommand(prepareCmd, statusCmd, shellCmd, exportCmd, importCmd, cleanCmd, gcCmd, logsCmd)
+	addNamedVMCommands(vmCmd)
 	addPoolCommands(vmCmd)
 	parent.AddCommand(vmCmd)
 }
@@ -74,6 +306,56 @@ func runVMPrepare(cmd *cobra.Command, args []string) error {
 	config.SetConfigDir(ConfigDir)
 	dhHome := config.DHHome()
 
+	if err := vm.CheckMachineSize(vmVCPUsFlag, vmMemoryFlag); err != nil {
+		return err
+	}
+	if vmRequireFlag != "" && vmProfileFlag == "" {
+		return fmt.Errorf("--requirements requires --profile")
+	}
+	if vmProfileFlag != "" {
+		if err := vm.ValidateProfile(vmProfileFlag); err != nil {
+			return err
+		}
+	}
+	if vmRequireFlag != "" {
+		if _, err := os.Stat(vmRequireFlag); err != nil {
+			return fmt.Errorf("reading requirements: %w", err)
+		}
+	}
+	if vmKernelFlag != "" {
+		if _, err := os.Stat(vmKernelFlag); err != nil {
+			return fmt.Errorf("reading kernel: %w", err)
+		}
+	}
+	net, err := vm.ParseNetMode(vmNetFlag)
+	if err != nil {
+		return err
+	}
+
+	// The base image, Python and JVM options default to the effective
+	// (project or global) config.
+	eff, _, err := config.LoadEffective()
+	if err != nil {
+		return err
+	}
+	opts := vm.RootfsOptions{BaseImage: vmBaseImage, Python: vmPythonFlag, Requirements: vmRequireFlag}
+	jvmArgs := vmJVMArgsFlag
+	if !cmd.Flags().Changed("base-image") {
+		opts.BaseImage = eff.VM.BaseImage
+	}
+	if !cmd.Flags().Changed("python") {
+		opts.Python = eff.VM.PythonVersion
+	}
+	if !cmd.Flags().Changed("guest-jvm-args") {
+		jvmArgs = eff.VM.GuestJVMArgs
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if err := vm.ValidateGuestJVMArgs(jvmArgs); err != nil {
+		return err
+	}
+
 	version, err := config.ResolveVersion(vmVersionFlag, os.Getenv("DH_VERSION"))
 	if err != nil {
 		// No version configured anywhere — fetch latest from PyPI
@@ -86,17 +368,30 @@ func runVMPrepare(cmd *cobra.Command, args []string) error {
 	}
 
 	paths := vm.NewVMPaths(dhHome)
+	key := vm.SnapshotKey(version, vmProfileFlag)
 
 	// Step 1: Download firecracker binary
 	fmt.Fprintf(cmd.ErrOrStderr(), "Ensuring Firecracker binary...\n")
 	if err := vm.EnsureFirecracker(paths, cmd.ErrOrStderr()); err != nil {
 		return fmt.Errorf("ensuring firecracker: %w", err)
 	}
+	if vmJailedFlag {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Ensuring jailer binary...\n")
+		if err := vm.EnsureJailer(paths, cmd.ErrOrStderr()); err != nil {
+			return fmt.Errorf("ensuring jailer: %w", err)
+		}
+	}
 
-	// Step 2: Download kernel
-	fmt.Fprintf(cmd.ErrOrStderr(), "Ensuring kernel...\n")
-	if err := vm.EnsureKernel(paths, cmd.ErrOrStderr()); err != nil {
-		return fmt.Errorf("ensuring kernel: %w", err)
+	// Step 2: Download kernel, or install the one given
+	if vmKernelFlag != "" {
+		if err := vm.InstallKernel(paths, vmKernelFlag, cmd.ErrOrStderr()); err != nil {
+			return fmt.Errorf("installing kernel: %w", err)
+		}
+	} else {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Ensuring kernel...\n")
+		if err := vm.EnsureKernel(paths, cmd.ErrOrStderr()); err != nil {
+			return fmt.Errorf("ensuring kernel: %w", err)
+		}
 	}
 
 	// Step 3: Check prerequisites and auto-fix what we can
@@ -124,35 +419,79 @@ func runVMPrepare(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// Step 4: Build rootfs (if not exists)
-	rootfsPath := paths.RootfsForVersion(version)
-	if _, err := os.Stat(rootfsPath); os.IsNotExist(err) {
-		fmt.Fprintf(cmd.ErrOrStderr(), "Building rootfs for version %s (this may take a few minutes)...\n", version)
-		if err := vm.EnsureRootfs(paths, version, cmd.ErrOrStderr()); err != nil {
+	// Step 4: Build rootfs (if not exists, or its options changed)
+	rootfsPath := paths.RootfsForVersion(key)
+	if !vm.RootfsCurrent(paths, key, opts) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Building rootfs for version %s (this may take a few minutes)...\n", key)
+		if err := vm.EnsureRootfs(paths, key, opts, cmd.ErrOrStderr()); err != nil {
 			return fmt.Errorf("building rootfs: %w", err)
 		}
 	} else {
 		fmt.Fprintf(cmd.ErrOrStderr(), "Rootfs exists: %s\n", rootfsPath)
 	}
 
+	writableRoot := vmWritableRoot
+	if m, err := vm.ReadRootfsManifest(paths, key); !writableRoot && (err != nil || !m.ReadOnlyRoot) {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Note: the rootfs predates read-only roots, so the guest's root is writable; run 'dh vm clean --version %s' to rebuild it\n", key)
+		writableRoot = true
+	}
+
 	// Step 5: Boot VM and create snapshot
-	fmt.Fprintf(cmd.ErrOrStderr(), "Booting VM and creating snapshot for version %s...\n", version)
+	fmt.Fprintf(cmd.ErrOrStderr(), "Booting VM and creating snapshot for version %s...\n", key)
 	vmCfg := &vm.VMConfig{
-		DHHome: dhHome,
-		Version: version,
-		Verbose: output.IsVerbose(),
+		DHHome:       dhHome,
+		Version:      version,
+		Profile:      vmProfileFlag,
+		Verbose:      output.IsVerbose(),
+		Compress:     vmCompressFlag,
+		VCPUs:        vmVCPUsFlag,
+		MemSizeMiB:   vmMemoryFlag,
+		Jailed:       vmJailedFlag,
+		Net:          net,
+		GuestJVMArgs: jvmArgs,
+		HugePages:    vmHugePages,
+		WritableRoot: writableRoot,
 	}
 	if err := vm.BootAndSnapshot(cmd.Context(), vmCfg, paths, cmd.ErrOrStderr()); err != nil {
 		return fmt.Errorf("creating snapshot: %w", err)
 	}
 
-	fmt.Fprintf(cmd.ErrOrStderr(), "Snapshot ready for version %s. Use 'dh exec --vm' for fast execution.\n", version)
+	if vmProfileFlag != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Snapshot ready for version %s. Use 'dh exec --vm --profile %s' for fast execution.\n", key, vmProfileFlag)
+	} else {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Snapshot ready for version %s. Use 'dh exec --vm' for fast execution.\n", version)
+	}
+
+	if eff.VM.AutoGC {
+		policy, err := gcPolicy(cmd, eff)
+		if err != nil {
+			return err
+		}
+		policy.Keep = append(policy.Keep, version)
+		removed, freed, err := collectVMGarbage(paths, policy, false)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Warning: vm gc: %v\n", err)
+		} else if len(removed) > 0 {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Removed %d unused VM artifacts (%s)\n", len(removed), cache.FormatSize(freed))
+		}
+	}
 
 	if output.IsJSON() {
 		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
-			"version":      version,
-			"snapshot_dir": paths.SnapshotDirForVersion(version),
-			"status":       "ready",
+			"version":        version,
+			"profile":        vmProfileFlag,
+			"snapshot_dir":   paths.SnapshotDirForVersion(key),
+			"compressed":     vmCompressFlag,
+			"vcpus":          vmVCPUsFlag,
+			"memory_mib":     vmMemoryFlag,
+			"jailed":         vmJailedFlag,
+			"net":            cmp.Or(net, "none"),
+			"huge_pages":     vmHugePages,
+			"read_only_root": !writableRoot,
+			"base_image":     opts.BaseImage,
+			"python":         opts.Python,
+			"guest_jvm_args": jvmArgs,
+			"status":         "ready",
 		})
 	}
 
@@ -175,58 +514,351 @@ func runVMStatus(cmd *cobra.Command, args []string) error {
 
 	// List snapshots
 	fmt.Fprintln(cmd.OutOrStdout(), "\nSnapshots:")
-	entries, err := os.ReadDir(paths.SnapshotDir)
-	if err != nil || len(entries) == 0 {
+	snaps, _ := vm.ListSnapshots(paths)
+	if len(snaps) == 0 {
 		fmt.Fprintln(cmd.OutOrStdout(), "  No snapshots found.")
-	} else {
-		for _, e := range entries {
-			if e.IsDir() {
-				ver := e.Name()
-				if err := vm.CheckSnapshot(paths, ver); err == nil {
-					fmt.Fprintf(cmd.OutOrStdout(), "  %s: ready\n", ver)
-				} else {
-					fmt.Fprintf(cmd.OutOrStdout(), "  %s: incomplete\n", ver)
-				}
-			}
+	}
+	for _, s := range snaps {
+		switch status, problems := snapshotStatus(paths, s); status {
+		case "ready":
+			fmt.Fprintf(cmd.OutOrStdout(), "  %s: ready%s\n", s.Version, snapshotBuild(s.Meta))
+		case "incompatible":
+			fmt.Fprintf(cmd.OutOrStdout(), "  %s: needs rebuild (%s). Run: %s\n", s.Version, strings.Join(problems, "; "), vm.PrepareCommand(s.Version))
+		default:
+			fmt.Fprintf(cmd.OutOrStdout(), "  %s: incomplete\n", s.Version)
 		}
 	}
 
+	// List named VMs
+	named := namedVMStatuses(paths)
+	if len(named) > 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "\nNamed VMs:")
+	}
+	for _, s := range named {
+		fmt.Fprintf(cmd.OutOrStdout(), "  %s: %s, up %s, %d execs\n", s.Name, vm.SnapshotKey(s.Version, s.Profile), time.Since(s.StartedAt).Round(time.Second), s.Execs)
+	}
+
 	if output.IsJSON() {
 		snapshots := []map[string]any{}
-		if entries, err := os.ReadDir(paths.SnapshotDir); err == nil {
-			for _, e := range entries {
-				if e.IsDir() {
-					status := "ready"
-					if err := vm.CheckSnapshot(paths, e.Name()); err != nil {
-						status = "incomplete"
-					}
-					snapshots = append(snapshots, map[string]any{
-						"version": e.Name(),
-						"status":  status,
-					})
-				}
+		for _, s := range snaps {
+			status, problems := snapshotStatus(paths, s)
+			snap := map[string]any{
+				"version": s.Version,
+				"status":  status,
+			}
+			if problems != nil {
+				snap["problems"] = problems
 			}
+			if s.Meta != nil {
+				snap["base_image"] = s.Meta.BaseImage
+				snap["python"] = s.Meta.Python
+				snap["guest_jvm_args"] = s.Meta.GuestJVMArgs
+			}
+			snapshots = append(snapshots, snap)
 		}
 		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
 			"prerequisites_ok": len(prereqErrs) == 0,
 			"snapshots":        snapshots,
+			"named_vms":        named,
 		})
 	}
 
 	return nil
 }
 
+// namedVMStatuses asks the daemon of each named VM for its status,
+// skipping those that are not up.
+func namedVMStatuses(paths *vm.VMPaths) []*vm.NamedVMStatus {
+	statuses := []*vm.NamedVMStatus{}
+	for _, name := range vm.NamedVMs(paths) {
+		resp, err := vm.NamedVMCommand(paths, name, &vm.PoolRequest{Type: "status"})
+		if err != nil || resp.Named == nil {
+			continue
+		}
+		statuses = append(statuses, resp.Named)
+	}
+	return statuses
+}
+
+// snapshotStatus returns "ready", "incomplete", or "incompatible" with
+// what changed when the installed Firecracker or kernel can't restore it.
+func snapshotStatus(paths *vm.VMPaths, s vm.SnapshotInfo) (string, []string) {
+	if !s.Complete {
+		return "incomplete", nil
+	}
+	var incompatible *vm.IncompatibleSnapshotError
+	if errors.As(vm.CheckSnapshot(paths, s.Version), &incompatible) {
+		return "incompatible", incompatible.Problems
+	}
+	return "ready", nil
+}
+
+func runVMGC(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	paths := vm.NewVMPaths(config.DHHome())
+
+	eff, _, err := config.LoadEffective()
+	if err != nil {
+		return err
+	}
+	policy, err := gcPolicy(cmd, eff)
+	if err != nil {
+		return err
+	}
+	if version, err := config.ResolveVersion("", os.Getenv("DH_VERSION")); err == nil {
+		policy.Keep = append(policy.Keep, version)
+	}
+
+	removed, freed, err := collectVMGarbage(paths, policy, vmDryRunFlag)
+	if err != nil {
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"removed":     removed,
+			"freed_bytes": freed,
+			"dry_run":     vmDryRunFlag,
+		})
+	}
+	verb := "Freed"
+	if vmDryRunFlag {
+		verb = "Would free"
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s %s from %s\n", verb, cache.FormatSize(freed), cacheEntries(len(removed)))
+	if vmDryRunFlag || output.IsVerbose() {
+		for _, g := range removed {
+			fmt.Fprintf(cmd.OutOrStdout(), "  %s %s (%s): %s\n", g.Kind, g.Paths[0], cache.FormatSize(g.SizeBytes), g.Reason)
+		}
+	}
+	return nil
+}
+
+func runVMLogs(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	paths := vm.NewVMPaths(config.DHHome())
+
+	if vmInstanceFlag == "" && !vmLastFlag {
+		if vmFollowFlag || vmRunnerFlag {
+			return fmt.Errorf("-f and --runner need --instance or --last")
+		}
+		logs := vm.ListInstanceLogs(paths)
+		if output.IsJSON() {
+			if logs == nil {
+				logs = []vm.InstanceLog{}
+			}
+			return output.PrintJSON(cmd.OutOrStdout(), map[string]any{"logs": logs})
+		}
+		if len(logs) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No VM logs.")
+			return nil
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "INSTANCE\tSTATE\tMODIFIED\tSIZE")
+		for _, l := range logs {
+			state := "exited"
+			if l.Running {
+				state = "running"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", l.ID, state, l.Modified.Format(time.DateTime), cache.FormatSize(l.SizeBytes))
+		}
+		return w.Flush()
+	}
+
+	log, err := vm.FindInstanceLog(paths, vmInstanceFlag)
+	if err != nil {
+		return err
+	}
+	if output.IsVerbose() {
+		fmt.Fprintf(cmd.ErrOrStderr(), "%s\n", log.Path)
+	}
+	return vm.WriteInstanceLog(cmd.Context(), log, cmd.OutOrStdout(), vmRunnerFlag, vmFollowFlag)
+}
+
+// gcPolicy returns the dh vm gc policy of the --keep-latest and
+// --older-than flags, or of the config where they are not given.
+func gcPolicy(cmd *cobra.Command, eff *config.Config) (vm.GCPolicy, error) {
+	keepLatest, olderThan := eff.VM.GCKeepLatest, eff.VM.GCOlderThan
+	if cmd.Flags().Changed("keep-latest") {
+		keepLatest = vmKeepLatest
+	}
+	if cmd.Flags().Changed("older-than") {
+		olderThan = vmOlderThan
+	}
+	if keepLatest < 0 {
+		return vm.GCPolicy{}, fmt.Errorf("--keep-latest must not be negative")
+	}
+	policy := vm.GCPolicy{KeepLatest: keepLatest}
+	if olderThan != "" {
+		age, err := cache.ParseAge(olderThan)
+		if err != nil {
+			return vm.GCPolicy{}, err
+		}
+		policy.OlderThan = age
+	}
+	return policy, nil
+}
+
+// collectVMGarbage removes the VM garbage policy picks, or with dryRun
+// only finds it, and returns it with the space it takes.
+func collectVMGarbage(paths *vm.VMPaths, policy vm.GCPolicy, dryRun bool) ([]vm.Garbage, int64, error) {
+	removed := []vm.Garbage{}
+	var freed int64
+	for _, g := range vm.FindGarbage(paths, policy, time.Now()) {
+		if !dryRun {
+			if err := vm.RemoveGarbage(paths, g); err != nil {
+				return removed, freed, fmt.Errorf("removing %s: %w", g.Paths[0], err)
+			}
+		}
+		removed = append(removed, g)
+		freed += g.SizeBytes
+	}
+	return removed, freed, nil
+}
+
+// snapshotBuild describes how a snapshot was built for dh vm status, e.g.
+// " (ubuntu:22.04, Python 3.10.12, JVM args -Xss4m)", or "" if unrecorded.
+func snapshotBuild(meta *vm.SnapshotMetadata) string {
+	if meta == nil {
+		return ""
+	}
+	var parts []string
+	if meta.BaseImage != "" {
+		parts = append(parts, meta.BaseImage)
+	}
+	if meta.Python != "" {
+		parts = append(parts, "Python "+meta.Python)
+	}
+	if meta.GuestJVMArgs != "" {
+		parts = append(parts, "JVM args "+meta.GuestJVMArgs)
+	}
+	if meta.HugePages {
+		parts = append(parts, "huge pages")
+	}
+	if meta.Net != "" {
+		parts = append(parts, meta.Net+" network")
+	}
+	if meta.ReadOnlyRoot {
+		parts = append(parts, "read-only root")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+func runVMShell(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	version, err := config.ResolveVersion(vmVersionFlag, os.Getenv("DH_VERSION"))
+	if err != nil {
+		return err
+	}
+
+	tuning, err := config.ResolveVMTuning("", nil, vmNoPoolFlag)
+	if err != nil {
+		return err
+	}
+	net, err := vm.ParseNetMode(vmNetFlag)
+	if err != nil {
+		return err
+	}
+
+	shellCfg := &vm.ShellConfig{
+		DHHome:  dhHome,
+		Version: version,
+		Verbose: output.IsVerbose(),
+		UseUffd: tuning.Backend == "uffd",
+		EagerMB: tuning.EagerMB,
+		UsePool: !tuning.NoPool && !vmJailedFlag && net == "",
+		Jailed:  vmJailedFlag,
+		Net:     net,
+	}
+	return vm.RunShell(cmd.Context(), shellCfg, os.Stdin, cmd.OutOrStdout(), cmd.ErrOrStderr())
+}
+
+func runVMExport(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	paths := vm.NewVMPaths(config.DHHome())
+
+	version, err := config.ResolveVersion(vmVersionFlag, os.Getenv("DH_VERSION"))
+	if err != nil {
+		return err
+	}
+	out := vmOutputFlag
+	if out == "" {
+		out = fmt.Sprintf("dh-vm-%s-%s.tar.zst", version, runtime.GOARCH)
+	}
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "Exporting snapshot for version %s to %s...\n", version, out)
+	m, err := vm.ExportSnapshot(paths, version, out)
+	if err != nil {
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"version": m.Version,
+			"arch":    m.Arch,
+			"archive": out,
+			"files":   m.Files,
+		})
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Exported snapshot for version %s to %s\n", m.Version, out)
+	return nil
+}
+
+func runVMImport(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	paths := vm.NewVMPaths(config.DHHome())
+
+	fmt.Fprintf(cmd.ErrOrStderr(), "Importing snapshot from %s...\n", args[0])
+	m, err := vm.ImportSnapshot(paths, args[0], vmForceFlag)
+	if err != nil {
+		return err
+	}
+
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{
+			"version":      m.Version,
+			"snapshot_dir": paths.SnapshotDirForVersion(m.Version),
+			"status":       "ready",
+		})
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Snapshot ready for version %s. Use 'dh exec --vm' for fast execution.\n", m.Version)
+	return nil
+}
+
 func runVMClean(cmd *cobra.Command, args []string) error {
 	config.SetConfigDir(ConfigDir)
 	dhHome := config.DHHome()
 	paths := vm.NewVMPaths(dhHome)
 
-	if vmVersionFlag != "" {
-		// Clean specific version
+	if vmProfileFlag != "" && vmVersionFlag == "" {
+		return fmt.Errorf("--profile requires --version")
+	}
+	if vmProfileFlag != "" {
+		if err := vm.ValidateProfile(vmProfileFlag); err != nil {
+			return err
+		}
+		// Clean one profile of a version
+		key := vm.SnapshotKey(vmVersionFlag, vmProfileFlag)
+		os.RemoveAll(paths.SnapshotDirForVersion(key))
+		os.Remove(paths.RootfsForVersion(key))
+		os.Remove(paths.ManifestForVersion(key))
+		os.Remove(paths.RequirementsForVersion(key))
+		fmt.Fprintf(cmd.ErrOrStderr(), "Cleaned VM artifacts for version %s\n", key)
+	} else if vmVersionFlag != "" {
+		// Clean specific version, with its profiles
 		snapDir := paths.SnapshotDirForVersion(vmVersionFlag)
 		rootfs := paths.RootfsForVersion(vmVersionFlag)
 		os.RemoveAll(snapDir)
 		os.Remove(rootfs)
+		os.Remove(paths.ManifestForVersion(vmVersionFlag))
+		for _, f := range paths.ProfileRootfsFiles(vmVersionFlag) {
+			os.Remove(f)
+		}
 		fmt.Fprintf(cmd.ErrOrStderr(), "Cleaned VM artifacts for version %s\n", vmVersionFlag)
 	} else {
 		// Clean everything
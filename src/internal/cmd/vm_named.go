@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+	"github.com/spf13/cobra"
+)
+
+var (
+	vmUpNameFlag       string
+	vmUpForegroundFlag bool
+	vmUpIdleTimeout    string
+)
+
+func addNamedVMCommands(vmCmd *cobra.Command) {
+	// dh vm up
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Start a named VM that stays up between execs",
+		Long: `Restore a VM from snapshot and keep it running under a name, so that
+'dh exec --vm --instance NAME' runs code in it without a restore, and the
+variables and tables each run leaves are there for the next. Runs take
+turns; the VM keeps running until 'dh vm down NAME', or with
+--idle-timeout until it has had no runs for that long.
+
+The VM is served by a daemon of its own, which logs to
+~/.dh/vm/named/NAME.log; with --foreground it runs in this process until
+interrupted instead.
+
+--profile, --jailed and --net pick the snapshot as they do for
+'dh exec --vm', and apply to every run in the VM.
+
+Examples:
+  dh vm up --name dev
+  dh exec --vm --instance dev load.py
+  dh exec --vm --instance dev -c "print(t.size)"
+  dh vm down dev`,
+		Args: cobra.NoArgs,
+		RunE: runVMUp,
+	}
+	upCmd.Flags().StringVar(&vmUpNameFlag, "name", "", "Name of the VM, for --instance and dh vm down")
+	upCmd.Flags().StringVar(&vmVersionFlag, "version", "", "Deephaven version (default: resolved version)")
+	upCmd.Flags().StringVar(&vmProfileFlag, "profile", "", "Restore the snapshot profile made by 'dh vm prepare --profile NAME'")
+	upCmd.Flags().BoolVar(&vmJailedFlag, "jailed", false, "Restore the VM under the jailer from a jailed snapshot (needs root)")
+	upCmd.Flags().StringVar(&vmNetFlag, "net", "", "Restore the VM with a network from a snapshot prepared with --net=nat (needs root)")
+	upCmd.Flags().StringVar(&vmUpIdleTimeout, "idle-timeout", "0", "Stop the VM after this long without execs (0: never)")
+	upCmd.Flags().BoolVar(&vmUpForegroundFlag, "foreground", false, "Serve the VM from this process until interrupted")
+	upCmd.MarkFlagRequired("name")
+
+	// dh vm down
+	downCmd := &cobra.Command{
+		Use:   "down NAME...",
+		Short: "Stop named VMs started by dh vm up",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runVMDown,
+	}
+
+	// dh vm session
+	sessionCmd := &cobra.Command{
+		Use:   "session",
+		Short: "List and stop exec sessions and other named VMs",
+		Long: `'dh exec --vm --session NAME' runs code in the named VM NAME, starting it
+as 'dh vm up --name NAME' would if it is not up, so that the variables and
+tables one run leaves are there for the next run in the same session. A
+session's VM stops after 30 minutes without runs, or with
+'dh vm session kill NAME'.
+
+Sessions are named VMs: list shows those of 'dh vm up' too, and kill is
+'dh vm down'.`,
+	}
+	sessionCmd.AddCommand(
+		&cobra.Command{
+			Use:   "list",
+			Short: "List exec sessions and other named VMs",
+			Args:  cobra.NoArgs,
+			RunE:  runVMSessionList,
+		},
+		&cobra.Command{
+			Use:   "kill NAME...",
+			Short: "Stop exec sessions, destroying their state",
+			Args:  cobra.MinimumNArgs(1),
+			RunE:  runVMDown,
+		},
+	)
+
+	vmCmd.AddCommand(upCmd, downCmd, sessionCmd)
+}
+
+func runVMUp(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+	paths := vm.NewVMPaths(dhHome)
+
+	if err := vm.ValidateVMName(vmUpNameFlag); err != nil {
+		return err
+	}
+	if vmProfileFlag != "" {
+		if err := vm.ValidateProfile(vmProfileFlag); err != nil {
+			return err
+		}
+	}
+	net, err := vm.ParseNetMode(vmNetFlag)
+	if err != nil {
+		return err
+	}
+	idleTimeout, err := time.ParseDuration(vmUpIdleTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid idle-timeout: %w", err)
+	}
+	version, err := config.ResolveVersion(vmVersionFlag, os.Getenv("DH_VERSION"))
+	if err != nil {
+		return err
+	}
+	if vm.NamedVMProbe(paths, vmUpNameFlag) {
+		return fmt.Errorf("VM %s is already up; stop it with 'dh vm down %s'", vmUpNameFlag, vmUpNameFlag)
+	}
+	if err := vm.CheckSnapshot(paths, vm.SnapshotKey(version, vmProfileFlag)); err != nil {
+		return err
+	}
+
+	if !vmUpForegroundFlag {
+		return runNamedVMBackground(cmd, paths, version, net, idleTimeout)
+	}
+
+	tuning, err := config.ResolveVMTuning("", nil, false)
+	if err != nil {
+		return err
+	}
+
+	// Handle signals
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	return vm.RunNamedVM(ctx, &vm.NamedVMConfig{
+		DHHome:      dhHome,
+		Name:        vmUpNameFlag,
+		Version:     version,
+		Profile:     vmProfileFlag,
+		UseUffd:     tuning.Backend == "uffd",
+		EagerMB:     tuning.EagerMB,
+		Jailed:      vmJailedFlag,
+		Net:         net,
+		IdleTimeout: idleTimeout,
+	}, cmd.ErrOrStderr())
+}
+
+// runNamedVMBackground starts the daemon of the named VM as a background
+// process and waits for it to serve the VM.
+func runNamedVMBackground(cmd *cobra.Command, paths *vm.VMPaths, version, net string, idleTimeout time.Duration) error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("getting executable path: %w", err)
+	}
+
+	upArgs := []string{"vm", "up", "--foreground",
+		"--name", vmUpNameFlag,
+		"--version", version,
+		"--idle-timeout", idleTimeout.String(),
+	}
+	if vmProfileFlag != "" {
+		upArgs = append(upArgs, "--profile", vmProfileFlag)
+	}
+	if vmJailedFlag {
+		upArgs = append(upArgs, "--jailed")
+	}
+	if net != "" {
+		upArgs = append(upArgs, "--net", net)
+	}
+	if output.IsVerbose() {
+		upArgs = append(upArgs, "-v")
+	}
+
+	if err := os.MkdirAll(paths.NamedDir, 0o700); err != nil {
+		return err
+	}
+	logPath := paths.NamedLog(vmUpNameFlag)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+
+	daemonCmd := exec.Command(exePath, upArgs...)
+	daemonCmd.Stdout = logFile
+	daemonCmd.Stderr = logFile
+	daemonCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	daemonCmd.Env = os.Environ()
+
+	if err := daemonCmd.Start(); err != nil {
+		logFile.Close()
+		return fmt.Errorf("starting daemon: %w", err)
+	}
+	logFile.Close()
+	exited := make(chan struct{})
+	go func() {
+		daemonCmd.Wait()
+		close(exited)
+	}()
+
+	// Wait for the socket to appear (up to 30s), or the daemon to fail
+	deadline := time.After(30 * time.Second)
+	for !vm.NamedVMProbe(paths, vmUpNameFlag) {
+		select {
+		case <-exited:
+			log, _ := os.ReadFile(logPath)
+			return fmt.Errorf("VM %s failed to start:\n%s", vmUpNameFlag, log)
+		case <-deadline:
+			return fmt.Errorf("VM %s started (pid=%d) but is not serving yet; check %s", vmUpNameFlag, daemonCmd.Process.Pid, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	resp, err := vm.NamedVMCommand(paths, vmUpNameFlag, &vm.PoolRequest{Type: "status"})
+	if err != nil {
+		return err
+	}
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), resp.Named)
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "VM %s is up (version=%s, pid=%d, log=%s)\n", vmUpNameFlag, version, daemonCmd.Process.Pid, logPath)
+	fmt.Fprintf(cmd.ErrOrStderr(), "Run code in it with 'dh exec --vm --instance %s'; stop it with 'dh vm down %s'.\n", vmUpNameFlag, vmUpNameFlag)
+	return nil
+}
+
+func runVMSessionList(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	paths := vm.NewVMPaths(config.DHHome())
+
+	named := namedVMStatuses(paths)
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{"sessions": named})
+	}
+	if len(named) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No sessions.")
+		return nil
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tUP\tIDLE\tEXECS\tINSTANCE")
+	for _, s := range named {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", s.Name, vm.SnapshotKey(s.Version, s.Profile),
+			time.Since(s.StartedAt).Round(time.Second), time.Duration(s.IdleSeconds)*time.Second, s.Execs, s.InstanceID)
+	}
+	return w.Flush()
+}
+
+func runVMDown(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	paths := vm.NewVMPaths(config.DHHome())
+
+	var stopped []string
+	for _, name := range args {
+		if err := vm.ValidateVMName(name); err != nil {
+			return err
+		}
+		if !vm.NamedVMProbe(paths, name) {
+			os.Remove(paths.NamedSocket(name)) // left by a daemon that died
+			return fmt.Errorf("VM %s is not up", name)
+		}
+		resp, err := vm.NamedVMCommand(paths, name, &vm.PoolRequest{Type: "stop"})
+		if err != nil {
+			return fmt.Errorf("stopping VM %s: %w", name, err)
+		}
+		if resp.Type == "error" {
+			return fmt.Errorf("VM %s: %s", name, resp.Error)
+		}
+		stopped = append(stopped, name)
+		if !output.IsJSON() {
+			fmt.Fprintf(cmd.ErrOrStderr(), "VM %s stopped.\n", name)
+		}
+	}
+	if output.IsJSON() {
+		return output.PrintJSON(cmd.OutOrStdout(), map[string]any{"stopped": stopped})
+	}
+	return nil
+}
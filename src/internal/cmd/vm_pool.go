@@ -3,10 +3,15 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,6 +27,16 @@ var (
 	poolIdleTimeoutFlag string
 	poolBackgroundFlag  bool
 	poolJSONFlag        bool
+	poolVersionsFlag    []string
+	poolScaleVersion    string
+	poolMetricsAddrFlag string
+	poolAllowUsersFlag  []string
+
+	poolServiceSize        int
+	poolServiceIdleTimeout string
+	poolServiceVersions    []string
+	poolServiceMetricsAddr string
+	poolServiceAllowUsers  []string
 )
 
 func addPoolCommands(vmCmd *cobra.Command) {
@@ -37,7 +52,10 @@ Subcommands:
   start   Start the pool daemon
   stop    Stop the pool daemon
   status  Show pool status
-  scale   Adjust pool size`,
+  scale   Adjust pool size
+  metrics Print pool metrics in the Prometheus text format
+  install-service  Install a systemd user unit for the daemon
+  service          Enable, disable or show the systemd user unit`,
 	}
 
 	// dh vm pool start
@@ -47,12 +65,26 @@ Subcommands:
 		Long: `Start the VM pool daemon in the foreground (or background with --background).
 
 The daemon pre-warms VMs from a snapshot and serves exec requests over a Unix
-socket. It auto-shuts down after the idle timeout.`,
+socket. It auto-shuts down after the idle timeout.
+
+--version can be repeated to keep warm VMs of several versions, -n of each;
+requests are routed to the queue of their version, and requests that name
+no version go to the first.
+
+--metrics-addr serves the metrics of 'dh vm pool metrics' over HTTP at
+/metrics for Prometheus to scrape, e.g. --metrics-addr 127.0.0.1:9464.
+
+Only the user running the daemon may use it. --allow-user lets another user,
+by name or UID, run code in the pool's VMs, for shared runners; they point
+dh at the socket with DH_POOL_SOCKET. Their VMs see only the files of their
+working directory and mounts that they could read themselves.`,
 		RunE: runPoolStart,
 	}
-	startCmd.Flags().IntVarP(&poolSizeFlag, "size", "n", 1, "Number of warm VMs to maintain")
+	startCmd.Flags().IntVarP(&poolSizeFlag, "size", "n", 1, "Number of warm VMs to maintain per version")
 	startCmd.Flags().StringVar(&poolIdleTimeoutFlag, "idle-timeout", "5m", "Shut down after this duration of inactivity")
-	startCmd.Flags().StringVar(&vmVersionFlag, "version", "", "Deephaven version (default: resolved version)")
+	startCmd.Flags().StringArrayVar(&poolVersionsFlag, "version", nil, "Deephaven version to serve (repeatable; default: resolved version)")
+	startCmd.Flags().StringVar(&poolMetricsAddrFlag, "metrics-addr", "", "Serve Prometheus metrics over HTTP at this address")
+	startCmd.Flags().StringArrayVar(&poolAllowUsersFlag, "allow-user", nil, "Also serve requests from this user, by name or UID (repeatable)")
 	startCmd.Flags().BoolVar(&poolBackgroundFlag, "background", false, "Daemonize the pool daemon (internal)")
 	startCmd.Flags().MarkHidden("background")
 
@@ -75,11 +107,94 @@ socket. It auto-shuts down after the idle timeout.`,
 	scaleCmd := &cobra.Command{
 		Use:   "scale N",
 		Short: "Adjust pool size",
+		Long:  "Set the number of warm VMs kept for each version, or for the version given with --version.",
 		Args:  cobra.ExactArgs(1),
 		RunE:  runPoolScale,
 	}
+	scaleCmd.Flags().StringVar(&poolScaleVersion, "version", "", "Scale only this version's queue (default: all)")
+
+	// dh vm pool metrics
+	metricsCmd := &cobra.Command{
+		Use:   "metrics",
+		Short: "Print pool metrics in the Prometheus text format",
+		Long: `Print the pool daemon's metrics in the Prometheus text format: warm and
+target VMs per version, shells in progress, idle time, exec requests by
+result, an exec latency histogram, backfill failures and UFFD page faults.
+Counters start at zero when the daemon starts.`,
+		Args: cobra.NoArgs,
+		RunE: runPoolMetrics,
+	}
 
-	poolCmd.AddCommand(startCmd, stopCmd, statusCmd, scaleCmd)
+	// dh vm pool install-service
+	installServiceCmd := &cobra.Command{
+		Use:   "install-service",
+		Short: "Install a systemd user unit for the pool daemon",
+		Long: `Write a systemd user unit, ~/.config/systemd/user/dh-pool.service, that
+runs the pool daemon with these settings, and reload systemd. Run
+'dh vm pool service enable' afterwards to start it now and at every login.
+Rerun install-service to change the settings.
+
+Unlike the daemon 'dh exec --vm' starts on demand, the service has no idle
+timeout by default, and systemd restarts it if it fails.`,
+		Args: cobra.NoArgs,
+		RunE: runPoolInstallService,
+	}
+	installServiceCmd.Flags().IntVarP(&poolServiceSize, "size", "n", 1, "Number of warm VMs to maintain per version")
+	installServiceCmd.Flags().StringVar(&poolServiceIdleTimeout, "idle-timeout", "0", "Shut down after this duration of inactivity (0: never)")
+	installServiceCmd.Flags().StringArrayVar(&poolServiceVersions, "version", nil, "Deephaven version to serve (repeatable; default: resolved version)")
+	installServiceCmd.Flags().StringVar(&poolServiceMetricsAddr, "metrics-addr", "", "Serve Prometheus metrics over HTTP at this address")
+	installServiceCmd.Flags().StringArrayVar(&poolServiceAllowUsers, "allow-user", nil, "Also serve requests from this user, by name or UID (repeatable)")
+
+	// dh vm pool service
+	serviceCmd := &cobra.Command{
+		Use:   "service",
+		Short: "Manage the pool daemon's systemd user unit",
+		Long: `Wrappers around systemctl --user for the unit written by
+'dh vm pool install-service'.`,
+	}
+	serviceCmd.AddCommand(
+		&cobra.Command{
+			Use:   "enable",
+			Short: "Start the pool daemon now and at every login",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := runSystemctl(cmd, "enable", "--now", vm.PoolServiceName); err != nil {
+					return err
+				}
+				if u, err := user.Current(); err == nil {
+					if _, err := os.Stat("/var/lib/systemd/linger/" + u.Username); err != nil {
+						fmt.Fprintln(cmd.ErrOrStderr(), "The pool daemon stops when you log out; run 'loginctl enable-linger' to keep it running and start it at boot.")
+					}
+				}
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "disable",
+			Short: "Stop the pool daemon and no longer start it at login",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return runSystemctl(cmd, "disable", "--now", vm.PoolServiceName)
+			},
+		},
+		&cobra.Command{
+			Use:   "status",
+			Short: "Show the systemd status of the pool daemon",
+			Args:  cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				err := runSystemctl(cmd, "status", "--no-pager", vm.PoolServiceName)
+				// systemctl status exits 3 for a unit that isn't running,
+				// which it has already reported.
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+					return nil
+				}
+				return err
+			},
+		},
+	)
+
+	poolCmd.AddCommand(startCmd, stopCmd, statusCmd, scaleCmd, metricsCmd, installServiceCmd, serviceCmd)
 	vmCmd.AddCommand(poolCmd)
 }
 
@@ -87,14 +202,9 @@ func runPoolStart(cmd *cobra.Command, args []string) error {
 	config.SetConfigDir(ConfigDir)
 	dhHome := config.DHHome()
 
-	version, err := config.ResolveVersion(vmVersionFlag, os.Getenv("DH_VERSION"))
+	poolVersions, err := resolvePoolVersions(cmd, poolVersionsFlag)
 	if err != nil {
-		fmt.Fprintf(cmd.ErrOrStderr(), "No version specified, fetching latest from PyPI...\n")
-		latest, pypiErr := versions.FetchLatestVersion()
-		if pypiErr != nil {
-			return fmt.Errorf("resolving version: %w (PyPI fallback also failed: %v)", err, pypiErr)
-		}
-		version = latest
+		return err
 	}
 
 	idleTimeout, err := time.ParseDuration(poolIdleTimeoutFlag)
@@ -102,20 +212,31 @@ func runPoolStart(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid idle-timeout: %w", err)
 	}
 
+	allowUIDs, err := lookupUIDs(poolAllowUsersFlag)
+	if err != nil {
+		return err
+	}
+
 	// If --background, daemonize by re-execing ourselves
 	if poolBackgroundFlag {
-		return runPoolDaemonBackground(cmd, version, dhHome, idleTimeout)
+		return runPoolDaemonBackground(cmd, poolVersions, dhHome, idleTimeout)
 	}
 
-	useUffd := os.Getenv("DH_VM_NO_UFFD") != "1"
+	tuning, err := config.ResolveVMTuning("", nil, false)
+	if err != nil {
+		return err
+	}
 
 	pool := vm.NewPool(vm.PoolConfig{
 		DHHome:      dhHome,
-		Version:     version,
+		Versions:    poolVersions,
 		TargetSize:  poolSizeFlag,
 		IdleTimeout: idleTimeout,
 		Verbose:     output.IsVerbose(),
-		UseUffd:     useUffd,
+		UseUffd:     tuning.Backend == "uffd",
+		EagerMB:     tuning.EagerMB,
+		MetricsAddr: poolMetricsAddrFlag,
+		AllowUIDs:   allowUIDs,
 	})
 
 	// Handle signals
@@ -131,8 +252,45 @@ func runPoolStart(cmd *cobra.Command, args []string) error {
 	return pool.Start(ctx, cmd.ErrOrStderr())
 }
 
+// resolvePoolVersions returns flagVersions, or the resolved version when
+// none are given.
+func resolvePoolVersions(cmd *cobra.Command, flagVersions []string) ([]string, error) {
+	if len(flagVersions) > 0 {
+		return flagVersions, nil
+	}
+	version, err := config.ResolveVersion("", os.Getenv("DH_VERSION"))
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "No version specified, fetching latest from PyPI...\n")
+		latest, pypiErr := versions.FetchLatestVersion()
+		if pypiErr != nil {
+			return nil, fmt.Errorf("resolving version: %w (PyPI fallback also failed: %v)", err, pypiErr)
+		}
+		version = latest
+	}
+	return []string{version}, nil
+}
+
+// lookupUIDs resolves --allow-user values, user names or UIDs, to UIDs.
+func lookupUIDs(users []string) ([]int, error) {
+	var uids []int
+	for _, name := range users {
+		u, err := user.Lookup(name)
+		if err != nil {
+			if u, err = user.LookupId(name); err != nil {
+				return nil, fmt.Errorf("--allow-user %s: no such user", name)
+			}
+		}
+		uid, err := strconv.Atoi(u.Uid)
+		if err != nil {
+			return nil, fmt.Errorf("--allow-user %s: %w", name, err)
+		}
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
 // runPoolDaemonBackground forks the pool daemon as a background process.
-func runPoolDaemonBackground(cmd *cobra.Command, version, dhHome string, idleTimeout time.Duration) error {
+func runPoolDaemonBackground(cmd *cobra.Command, poolVersions []string, dhHome string, idleTimeout time.Duration) error {
 	// Build the command to run in background (without --background to avoid recursion)
 	exePath, err := os.Executable()
 	if err != nil {
@@ -142,7 +300,15 @@ func runPoolDaemonBackground(cmd *cobra.Command, version, dhHome string, idleTim
 	poolArgs := []string{"vm", "pool", "start",
 		"-n", fmt.Sprintf("%d", poolSizeFlag),
 		"--idle-timeout", idleTimeout.String(),
-		"--version", version,
+	}
+	for _, v := range poolVersions {
+		poolArgs = append(poolArgs, "--version", v)
+	}
+	if poolMetricsAddrFlag != "" {
+		poolArgs = append(poolArgs, "--metrics-addr", poolMetricsAddrFlag)
+	}
+	for _, u := range poolAllowUsersFlag {
+		poolArgs = append(poolArgs, "--allow-user", u)
 	}
 	if output.IsVerbose() {
 		poolArgs = append(poolArgs, "-v")
@@ -180,7 +346,7 @@ func runPoolDaemonBackground(cmd *cobra.Command, version, dhHome string, idleTim
 	for time.Now().Before(deadline) {
 		if vm.PoolProbe() {
 			fmt.Fprintf(cmd.ErrOrStderr(), "Pool daemon started (pid=%d, version=%s, size=%d, log=%s)\n",
-				daemonCmd.Process.Pid, version, poolSizeFlag, logPath)
+				daemonCmd.Process.Pid, strings.Join(poolVersions, ","), poolSizeFlag, logPath)
 			return nil
 		}
 		time.Sleep(200 * time.Millisecond)
@@ -236,12 +402,38 @@ func runPoolStatus(cmd *cobra.Command, args []string) error {
 
 	s := resp.Status
 	fmt.Fprintf(cmd.OutOrStdout(), "Pool daemon (pid=%d)\n", s.PID)
-	fmt.Fprintf(cmd.OutOrStdout(), "  Version:      %s\n", s.Version)
-	fmt.Fprintf(cmd.OutOrStdout(), "  Ready VMs:    %d / %d\n", s.Ready, s.TargetSize)
+	if len(s.Versions) > 1 {
+		fmt.Fprintf(cmd.OutOrStdout(), "  Ready VMs:    %d / %d\n", s.Ready, s.TargetSize)
+		for _, v := range s.Versions {
+			fmt.Fprintf(cmd.OutOrStdout(), "    %-12s %d / %d\n", v.Version, v.Ready, v.TargetSize)
+		}
+	} else {
+		fmt.Fprintf(cmd.OutOrStdout(), "  Version:      %s\n", s.Version)
+		fmt.Fprintf(cmd.OutOrStdout(), "  Ready VMs:    %d / %d\n", s.Ready, s.TargetSize)
+	}
 	fmt.Fprintf(cmd.OutOrStdout(), "  Idle:         %ds (timeout: %ds)\n", s.IdleSeconds, s.IdleTimeout)
 	return nil
 }
 
+func runPoolMetrics(cmd *cobra.Command, args []string) error {
+	if !vm.PoolProbe() {
+		return fmt.Errorf("pool daemon is not running")
+	}
+
+	resp, err := vm.PoolCommand(&vm.PoolRequest{Type: "metrics"})
+	if err != nil {
+		return fmt.Errorf("getting metrics: %w", err)
+	}
+	if resp.Type != "metrics" {
+		if resp.Type == "error" {
+			return fmt.Errorf("pool error: %s", resp.Error)
+		}
+		return fmt.Errorf("the running pool daemon has no metrics; restart it with 'dh vm pool stop' and 'dh vm pool start'")
+	}
+	fmt.Fprint(cmd.OutOrStdout(), resp.Metrics)
+	return nil
+}
+
 func runPoolScale(cmd *cobra.Command, args []string) error {
 	if !vm.PoolProbe() {
 		return fmt.Errorf("pool daemon is not running")
@@ -252,7 +444,7 @@ func runPoolScale(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid size: %s", args[0])
 	}
 
-	resp, err := vm.PoolCommand(&vm.PoolRequest{Type: "scale", TargetSize: n})
+	resp, err := vm.PoolCommand(&vm.PoolRequest{Type: "scale", TargetSize: n, Version: poolScaleVersion})
 	if err != nil {
 		return fmt.Errorf("sending scale: %w", err)
 	}
@@ -260,6 +452,79 @@ func runPoolScale(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("pool error: %s", resp.Error)
 	}
 
-	fmt.Fprintf(cmd.ErrOrStderr(), "Pool size set to %d.\n", n)
+	if poolScaleVersion != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Pool size for version %s set to %d.\n", poolScaleVersion, n)
+	} else {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Pool size set to %d.\n", n)
+	}
+	return nil
+}
+
+func runPoolInstallService(cmd *cobra.Command, args []string) error {
+	config.SetConfigDir(ConfigDir)
+	dhHome := config.DHHome()
+
+	poolVersions, err := resolvePoolVersions(cmd, poolServiceVersions)
+	if err != nil {
+		return err
+	}
+	idleTimeout, err := time.ParseDuration(poolServiceIdleTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid idle-timeout: %w", err)
+	}
+	if _, err := lookupUIDs(poolServiceAllowUsers); err != nil {
+		return err
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("getting executable path: %w", err)
+	}
+	if exePath, err = filepath.Abs(exePath); err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(dhHome); err == nil {
+		dhHome = abs
+	}
+
+	unitPath, err := vm.PoolServicePath()
+	if err != nil {
+		return fmt.Errorf("locating systemd user units: %w", err)
+	}
+	unit := vm.PoolServiceUnit(vm.PoolServiceConfig{
+		Executable:  exePath,
+		DHHome:      dhHome,
+		SocketPath:  vm.PoolSocketPath(),
+		Versions:    poolVersions,
+		TargetSize:  poolServiceSize,
+		IdleTimeout: idleTimeout,
+		MetricsAddr: poolServiceMetricsAddr,
+		AllowUsers:  poolServiceAllowUsers,
+	})
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(unitPath, []byte(unit), 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", unitPath, err)
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Wrote %s (version=%s, size=%d)\n", unitPath, strings.Join(poolVersions, ","), poolServiceSize)
+
+	if err := exec.Command("systemctl", "--user", "daemon-reload").Run(); err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Warning: systemctl --user daemon-reload failed: %v\n", err)
+	}
+	fmt.Fprintln(cmd.ErrOrStderr(), "Run 'dh vm pool service enable' to start it now and at every login.")
+	return nil
+}
+
+// runSystemctl runs systemctl --user with args, passing its output through.
+func runSystemctl(cmd *cobra.Command, args ...string) error {
+	c := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	c.Stdout = cmd.OutOrStdout()
+	c.Stderr = cmd.ErrOrStderr()
+	if err := c.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return err
+		}
+		return fmt.Errorf("running systemctl: %w", err)
+	}
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
 	"github.com/spf13/cobra"
 )
 
@@ -30,7 +31,7 @@ func TestVMSubcommandRegistered(t *testing.T) {
 		subNames[c.Name()] = true
 	}
 
-	for _, name := range []string{"prepare", "status", "clean"} {
+	for _, name := range []string{"prepare", "status", "shell", "export", "import", "clean", "gc", "logs"} {
 		if !subNames[name] {
 			t.Errorf("'vm %s' subcommand not found", name)
 		}
@@ -84,3 +85,66 @@ func TestVMStatusCommand(t *testing.T) {
 		t.Errorf("vm status output missing 'Snapshots:', got:\n%s", output)
 	}
 }
+
+func TestVMPoolServiceCommands(t *testing.T) {
+	root := NewRootCmd()
+	cmd, _, err := root.Find([]string{"vm", "pool", "install-service"})
+	if err != nil || cmd.Name() != "install-service" {
+		t.Fatalf("'vm pool install-service' not found: %v", err)
+	}
+	if f := cmd.Flags().Lookup("idle-timeout"); f == nil || f.DefValue != "0" {
+		t.Errorf("install-service --idle-timeout = %+v, want default 0", f)
+	}
+	for _, name := range []string{"enable", "disable", "status"} {
+		if c, _, err := root.Find([]string{"vm", "pool", "service", name}); err != nil || c.Name() != name {
+			t.Errorf("'vm pool service %s' not found: %v", name, err)
+		}
+	}
+}
+
+func TestVMPrepareProfileValidation(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"vm", "prepare", "--requirements", "requirements.txt"}, "--requirements requires --profile"},
+		{[]string{"vm", "prepare", "--version", "0.36.0", "--profile", "My_Proj"}, "invalid profile"},
+		{[]string{"vm", "clean", "--profile", "myproj"}, "--profile requires --version"},
+		{[]string{"vm", "prepare", "--version", "0.36.0", "--python", "3"}, "invalid Python version"},
+		{[]string{"vm", "prepare", "--version", "0.36.0", "--guest-jvm-args", "-Xss4m UTC"}, "invalid guest JVM argument"},
+		{[]string{"vm", "prepare", "--version", "0.36.0", "--kernel", "no-such-vmlinux"}, "reading kernel"},
+		{[]string{"vm", "prepare", "--version", "0.36.0", "--net", "wifi"}, "invalid --net"},
+		{[]string{"vm", "shell", "--version", "0.36.0", "--net", "bridge"}, "invalid --net"},
+		{[]string{"vm", "gc", "--older-than", "soon"}, "invalid age"},
+		{[]string{"vm", "gc", "--keep-latest", "-1"}, "--keep-latest must not be negative"},
+		{[]string{"vm", "logs", "-f"}, "need --instance or --last"},
+		{[]string{"vm", "logs", "--last", "--instance", "exec-1"}, "none of the others can be"},
+	} {
+		root := NewRootCmd()
+		buf := new(bytes.Buffer)
+		root.SetOut(buf)
+		root.SetErr(buf)
+		root.SetArgs(tc.args)
+		if err := root.Execute(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: expected %q error, got %v", tc.args, tc.want, err)
+		}
+	}
+}
+
+func TestSnapshotBuild(t *testing.T) {
+	for _, tc := range []struct {
+		meta *vm.SnapshotMetadata
+		want string
+	}{
+		{nil, ""},
+		{&vm.SnapshotMetadata{Version: "0.36.0"}, ""},
+		{&vm.SnapshotMetadata{BaseImage: "ubuntu:24.04", Python: "3.12.3"}, " (ubuntu:24.04, Python 3.12.3)"},
+		{&vm.SnapshotMetadata{BaseImage: "ubuntu:22.04", GuestJVMArgs: "-Xss4m"}, " (ubuntu:22.04, JVM args -Xss4m)"},
+		{&vm.SnapshotMetadata{Python: "3.11", HugePages: true}, " (Python 3.11, huge pages)"},
+		{&vm.SnapshotMetadata{Net: vm.NetNAT}, " (nat network)"},
+	} {
+		if got := snapshotBuild(tc.meta); got != tc.want {
+			t.Errorf("snapshotBuild(%+v) = %q, want %q", tc.meta, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package completion generates shell completion scripts for dh and
+// installs them where each shell looks for them.
+package completion
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Shells are the shells completions can be generated for.
+var Shells = []string{"bash", "zsh", "fish", "nushell", "powershell"}
+
+// Script returns the completion script for shell. The scripts call back
+// into the program (dh __complete ...), so completions of installed
+// versions, hosts and the like are always current.
+func Script(root *cobra.Command, shell string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch shell {
+	case "bash":
+		err = root.GenBashCompletionV2(&buf, true)
+	case "zsh":
+		err = root.GenZshCompletion(&buf)
+	case "fish":
+		err = root.GenFishCompletion(&buf, true)
+	case "nushell":
+		buf.WriteString(NushellScript(root.Name()))
+	case "powershell":
+		err = root.GenPowerShellCompletionWithDesc(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported shell %q (supported: %s)", shell, strings.Join(Shells, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NushellScript returns the nushell completion script for the program
+// name. Nushell has no per-command completion scripts for external
+// commands, so it chains an external completer in front of any already
+// configured: name is completed through name __complete, and anything
+// else goes to the previous completer.
+func NushellScript(name string) string {
+	return strings.NewReplacer("{{name}}", name, "{{var}}", strings.ReplaceAll(name, "-", "_")).Replace(nushellTemplate)
+}
+
+const nushellTemplate = `# nushell completion for {{name}}
+# Generated by {{name}} completion nushell; regenerate rather than edit.
+
+let __{{var}}_previous_completer = ($env.config?.completions?.external?.completer?)
+
+let __{{var}}_completer = {|spans: list<string>|
+    let out = (^{{name}} __complete ...($spans | skip 1) | complete)
+    let lines = ($out.stdout | lines | where {|l| $l != "" })
+    if ($lines | is-empty) { return null }
+
+    # The last line is :DIRECTIVE; 4 means "don't fall back to files"
+    let directive = ($lines | last | str replace ":" "" | into int)
+    let candidates = ($lines | drop 1 | each {|l|
+        let parts = ($l | split row "\t")
+        if ($parts | length) > 1 {
+            {value: $parts.0, description: $parts.1}
+        } else {
+            {value: $parts.0}
+        }
+    })
+    if ($candidates | is-empty) and (($directive | bits and 4) == 0) {
+        null
+    } else {
+        $candidates
+    }
+}
+
+$env.config.completions.external.enable = true
+$env.config.completions.external.completer = {|spans: list<string>|
+    if ($spans | first) == "{{name}}" {
+        do $__{{var}}_completer $spans
+    } else if $__{{var}}_previous_completer != null {
+        do $__{{var}}_previous_completer $spans
+    } else {
+        null
+    }
+}
+`
+
+// Detect returns the user's shell, or "" when it can't tell. Nushell
+// doesn't set SHELL, but does set NU_VERSION.
+func Detect() string {
+	if os.Getenv("NU_VERSION") != "" {
+		return "nushell"
+	}
+	switch filepath.Base(os.Getenv("SHELL")) {
+	case "bash":
+		return "bash"
+	case "zsh":
+		return "zsh"
+	case "fish":
+		return "fish"
+	case "nu":
+		return "nushell"
+	case "pwsh", "powershell":
+		return "powershell"
+	}
+	return ""
+}
+
+// InstallPath returns where Install writes the completion script for
+// shell, and what the user still has to do for the shell to load it from
+// there ("" when nothing).
+func InstallPath(shell, name string) (path, hint string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	xdg := func(env, def string) string {
+		if d := os.Getenv(env); d != "" {
+			return d
+		}
+		return filepath.Join(home, def)
+	}
+
+	switch shell {
+	case "bash":
+		// Loaded on demand by bash-completion 2
+		return filepath.Join(xdg("XDG_DATA_HOME", ".local/share"), "bash-completion", "completions", name), "", nil
+	case "zsh":
+		dir := filepath.Join(home, ".zfunc")
+		return filepath.Join(dir, "_"+name),
+			fmt.Sprintf("add %s to fpath before compinit in ~/.zshrc:\n  fpath=(%s $fpath)\n  autoload -Uz compinit && compinit", dir, dir), nil
+	case "fish":
+		return filepath.Join(xdg("XDG_CONFIG_HOME", ".config"), "fish", "completions", name+".fish"), "", nil
+	case "nushell":
+		// Nushell 0.101+ sources the files in its autoload directory.
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", "", err
+		}
+		path := filepath.Join(dir, "nushell", "autoload", name+".nu")
+		return path, fmt.Sprintf("nushell older than 0.101 doesn't autoload it; add to config.nu:\n  source %s", path), nil
+	case "powershell":
+		return "", "", fmt.Errorf("install doesn't support powershell; add this to your $PROFILE instead:\n  %s completion powershell | Out-String | Invoke-Expression", name)
+	}
+	return "", "", fmt.Errorf("unsupported shell %q (supported: %s)", shell, strings.Join(Shells, ", "))
+}
+
+// Install writes the completion script for shell to InstallPath and
+// returns the path and hint.
+func Install(root *cobra.Command, shell string) (path, hint string, err error) {
+	path, hint, err = InstallPath(shell, root.Name())
+	if err != nil {
+		return "", "", err
+	}
+	script, err := Script(root, shell)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", "", fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, script, 0o644); err != nil {
+		return "", "", fmt.Errorf("writing %s: %w", path, err)
+	}
+	return path, hint, nil
+}
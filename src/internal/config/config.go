@@ -4,23 +4,124 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
 	"github.com/pelletier/go-toml/v2"
 )
 
-// Config represents the ~/.dh/config.toml file.
+// Config represents the ~/.dh/config.toml file. Project config files
+// (see FindProjectConfig) use the same layout.
 type Config struct {
-	DefaultVersion string  `toml:"default_version,omitempty" json:"default_version"`
-	Install        Install `toml:"install,omitempty" json:"install"`
+	DefaultVersion string          `toml:"default_version,omitempty" json:"default_version"`
+	Backend        string          `toml:"backend,omitempty" json:"backend"` // local (default) or vm
+	Install        Install         `toml:"install,omitempty" json:"install"`
+	Exec           Exec            `toml:"exec,omitempty" json:"exec"`
+	Hosts          map[string]Host `toml:"hosts,omitempty" json:"hosts"`
+	VM             VM              `toml:"vm,omitempty" json:"vm"`
+	History        History         `toml:"history,omitempty" json:"history"`
+	TUI            TUI             `toml:"tui,omitempty" json:"tui"`
+	Doctor         Doctor          `toml:"doctor,omitempty" json:"doctor"`
 }
 
+// Backends are the values of backend: where dh exec runs code when
+// neither --host nor --vm is given.
+var Backends = []string{"local", "vm"}
+
 // Install holds installation preferences.
 type Install struct {
 	Plugins       []string `toml:"plugins,omitempty" json:"plugins"`
 	PythonVersion string   `toml:"python_version,omitempty" json:"python_version"`
 }
 
+// Exec holds defaults for dh exec.
+type Exec struct {
+	PythonPath []string `toml:"pythonpath,omitempty" json:"pythonpath"`
+}
+
+// Host is a named remote server; --host NAME uses its settings.
+type Host struct {
+	Host          string `toml:"host" json:"host"`
+	Port          int    `toml:"port,omitempty" json:"port,omitempty"`
+	AuthType      string `toml:"auth_type,omitempty" json:"auth_type,omitempty"`
+	AuthToken     string `toml:"auth_token,omitempty" json:"auth_token,omitempty"`           // secret; redacted on export
+	AuthTokenEnv  string `toml:"auth_token_env,omitempty" json:"auth_token_env,omitempty"`   // environment variable holding the token
+	AuthTokenFile string `toml:"auth_token_file,omitempty" json:"auth_token_file,omitempty"` // file holding the token
+	TLS           bool   `toml:"tls,omitempty" json:"tls,omitempty"`
+	TLSCACert     string `toml:"tls_ca_cert,omitempty" json:"tls_ca_cert,omitempty"`
+	TLSClientCert string `toml:"tls_client_cert,omitempty" json:"tls_client_cert,omitempty"`
+	TLSClientKey  string `toml:"tls_client_key,omitempty" json:"tls_client_key,omitempty"`
+}
+
+// Token returns the auth token of h: auth_token, or the value of the
+// environment variable auth_token_env, or the contents of auth_token_file
+// without surrounding whitespace. It returns "" when none is set.
+func (h Host) Token() (string, error) {
+	switch {
+	case h.AuthTokenEnv != "":
+		v, ok := os.LookupEnv(h.AuthTokenEnv)
+		if !ok {
+			return "", fmt.Errorf("auth_token_env: $%s is not set", h.AuthTokenEnv)
+		}
+		return v, nil
+	case h.AuthTokenFile != "":
+		data, err := os.ReadFile(ExpandHome(h.AuthTokenFile))
+		if err != nil {
+			return "", fmt.Errorf("auth_token_file: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return h.AuthToken, nil
+}
+
+// VM holds defaults for dh exec --vm.
+type VM struct {
+	Mounts        []string `toml:"mounts,omitempty" json:"mounts"`                 // --mount specs added to every run
+	BaseImage     string   `toml:"base_image,omitempty" json:"base_image"`         // dh vm prepare --base-image
+	PythonVersion string   `toml:"python_version,omitempty" json:"python_version"` // dh vm prepare --python
+	GuestJVMArgs  string   `toml:"guest_jvm_args,omitempty" json:"guest_jvm_args"` // dh vm prepare --guest-jvm-args
+	AutoGC        bool     `toml:"auto_gc,omitempty" json:"auto_gc"`               // run dh vm gc after dh vm prepare
+	GCKeepLatest  int      `toml:"gc_keep_latest,omitempty" json:"gc_keep_latest"` // dh vm gc --keep-latest
+	GCOlderThan   string   `toml:"gc_older_than,omitempty" json:"gc_older_than"`   // dh vm gc --older-than
+	Backend       string   `toml:"backend,omitempty" json:"backend"`               // dh exec --vm-backend
+	EagerMB       *int     `toml:"eager_mb,omitempty" json:"eager_mb"`             // dh exec --vm-eager-mb; unset for the hot chunks
+	NoPool        bool     `toml:"no_pool,omitempty" json:"no_pool"`               // dh exec --no-pool
+}
+
+// History configures the shared REPL and exec history store.
+type History struct {
+	MaxEntries int      `toml:"max_entries,omitempty" json:"max_entries"` // 0 = default (500)
+	Dedup      string   `toml:"dedup,omitempty" json:"dedup"`             // consecutive (default), all, none
+	Exclude    []string `toml:"exclude,omitempty" json:"exclude"`         // extra regexps for lines never saved
+	Scope      string   `toml:"scope,omitempty" json:"scope"`             // global (default) or project
+}
+
+// TUI holds interactive UI preferences.
+type TUI struct {
+	SkipConfirm []string `toml:"skip_confirm,omitempty" json:"skip_confirm"` // actions that no longer ask for confirmation
+}
+
+// ConfirmActions are the destructive TUI actions that ask for confirmation
+// unless listed in tui.skip_confirm.
+var ConfirmActions = []string{"uninstall", "kill", "vm-clean", "pool-stop"}
+
+// Doctor configures dh doctor.
+type Doctor struct {
+	Severity map[string]string `toml:"severity,omitempty" json:"severity"` // check -> severity of a failure
+}
+
+// DoctorChecks are the dh doctor checks, as named by --only and
+// doctor.severity.
+var DoctorChecks = []string{"uv", "java", "versions", "default", "disk", "vm"}
+
+// Severities are the values of doctor.severity: what a failed check
+// counts as.
+var Severities = []string{"error", "warning"}
+
 // configDirOverride is set by the --config-dir flag or DH_HOME env var.
 var configDirOverride string
 
@@ -45,6 +146,19 @@ func DHHome() string {
 	return filepath.Join(home, ".dh")
 }
 
+// ExpandHome replaces a leading ~ of path with the user's home directory.
+func ExpandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~")
+	if !ok || (rest != "" && rest[0] != '/') {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return home + rest
+}
+
 // ConfigPath returns the full path to config.toml.
 func ConfigPath() string {
 	return filepath.Join(DHHome(), "config.toml")
@@ -87,23 +201,42 @@ func Save(cfg *Config) error {
 // validKeys lists the dot-separated keys that can be used with Get/Set.
 var validKeys = map[string]bool{
 	"default_version":        true,
+	"backend":                true,
 	"install.plugins":        true,
 	"install.python_version": true,
+	"exec.pythonpath":        true,
+	"vm.mounts":              true,
+	"vm.base_image":          true,
+	"vm.python_version":      true,
+	"vm.guest_jvm_args":      true,
+	"vm.auto_gc":             true,
+	"vm.gc_keep_latest":      true,
+	"vm.gc_older_than":       true,
+	"vm.backend":             true,
+	"vm.eager_mb":            true,
+	"vm.no_pool":             true,
+	"history.max_entries":    true,
+	"history.dedup":          true,
+	"history.exclude":        true,
+	"history.scope":          true,
+	"tui.skip_confirm":       true,
 }
 
-// Get retrieves a single config value by dot-separated key.
+// Get retrieves a single config value by dot-separated key, as in effect
+// in the current directory (a project config can override it).
 func Get(key string) (string, error) {
 	if !validKeys[key] {
 		return "", fmt.Errorf("unknown config key: %s", key)
 	}
-	cfg, err := Load()
+	cfg, _, err := LoadEffective()
 	if err != nil {
 		return "", err
 	}
 	return getField(cfg, key)
 }
 
-// Set sets a single config value by dot-separated key.
+// Set sets a single config value by dot-separated key in the global
+// config.toml.
 func Set(key, value string) error {
 	if !validKeys[key] {
 		return fmt.Errorf("unknown config key: %s", key)
@@ -122,10 +255,53 @@ func getField(cfg *Config, key string) (string, error) {
 	switch key {
 	case "default_version":
 		return cfg.DefaultVersion, nil
+	case "backend":
+		return cfg.Backend, nil
 	case "install.plugins":
 		return strings.Join(cfg.Install.Plugins, ","), nil
 	case "install.python_version":
 		return cfg.Install.PythonVersion, nil
+	case "exec.pythonpath":
+		return strings.Join(cfg.Exec.PythonPath, ","), nil
+	case "vm.mounts":
+		return strings.Join(cfg.VM.Mounts, ","), nil
+	case "vm.base_image":
+		return cfg.VM.BaseImage, nil
+	case "vm.python_version":
+		return cfg.VM.PythonVersion, nil
+	case "vm.guest_jvm_args":
+		return cfg.VM.GuestJVMArgs, nil
+	case "vm.auto_gc":
+		return strconv.FormatBool(cfg.VM.AutoGC), nil
+	case "vm.gc_keep_latest":
+		if cfg.VM.GCKeepLatest == 0 {
+			return "", nil
+		}
+		return strconv.Itoa(cfg.VM.GCKeepLatest), nil
+	case "vm.gc_older_than":
+		return cfg.VM.GCOlderThan, nil
+	case "vm.backend":
+		return cfg.VM.Backend, nil
+	case "vm.eager_mb":
+		if cfg.VM.EagerMB == nil {
+			return "", nil
+		}
+		return strconv.Itoa(*cfg.VM.EagerMB), nil
+	case "vm.no_pool":
+		return strconv.FormatBool(cfg.VM.NoPool), nil
+	case "history.max_entries":
+		if cfg.History.MaxEntries == 0 {
+			return "", nil
+		}
+		return strconv.Itoa(cfg.History.MaxEntries), nil
+	case "history.dedup":
+		return cfg.History.Dedup, nil
+	case "history.exclude":
+		return strings.Join(cfg.History.Exclude, ","), nil
+	case "history.scope":
+		return cfg.History.Scope, nil
+	case "tui.skip_confirm":
+		return strings.Join(cfg.TUI.SkipConfirm, ","), nil
 	default:
 		return "", fmt.Errorf("unknown config key: %s", key)
 	}
@@ -134,7 +310,17 @@ func getField(cfg *Config, key string) (string, error) {
 func setField(cfg *Config, key, value string) error {
 	switch key {
 	case "default_version":
+		if value != "" {
+			if _, err := versions.ParseConstraint(value); err != nil {
+				return fmt.Errorf("invalid default_version: %w", err)
+			}
+		}
 		cfg.DefaultVersion = value
+	case "backend":
+		if value != "" && !slices.Contains(Backends, value) {
+			return fmt.Errorf("invalid backend %q: use %s", value, strings.Join(Backends, " or "))
+		}
+		cfg.Backend = value
 	case "install.plugins":
 		if value == "" {
 			cfg.Install.Plugins = nil
@@ -143,8 +329,139 @@ func setField(cfg *Config, key, value string) error {
 		}
 	case "install.python_version":
 		cfg.Install.PythonVersion = value
+	case "exec.pythonpath":
+		if value == "" {
+			cfg.Exec.PythonPath = nil
+		} else {
+			cfg.Exec.PythonPath = strings.Split(value, ",")
+		}
+	case "vm.mounts":
+		if value == "" {
+			cfg.VM.Mounts = nil
+		} else {
+			cfg.VM.Mounts = strings.Split(value, ",")
+		}
+	case "vm.base_image":
+		cfg.VM.BaseImage = value
+	case "vm.python_version":
+		cfg.VM.PythonVersion = value
+	case "vm.guest_jvm_args":
+		cfg.VM.GuestJVMArgs = value
+	case "vm.auto_gc":
+		if value == "" {
+			cfg.VM.AutoGC = false
+			break
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid vm.auto_gc %q: use true or false", value)
+		}
+		cfg.VM.AutoGC = b
+	case "vm.gc_keep_latest":
+		if value == "" {
+			cfg.VM.GCKeepLatest = 0
+			break
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid vm.gc_keep_latest %q: must be a positive integer", value)
+		}
+		cfg.VM.GCKeepLatest = n
+	case "vm.gc_older_than":
+		if value != "" && !validAge(value) {
+			return fmt.Errorf("invalid vm.gc_older_than %q (use e.g. 30d, 2w or 12h)", value)
+		}
+		cfg.VM.GCOlderThan = value
+	case "vm.backend":
+		if value != "" && !slices.Contains(VMBackends, value) {
+			return fmt.Errorf("invalid vm.backend %q: use %s", value, strings.Join(VMBackends, " or "))
+		}
+		cfg.VM.Backend = value
+	case "vm.eager_mb":
+		if value == "" {
+			cfg.VM.EagerMB = nil
+			break
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid vm.eager_mb %q: must be a number of MiB", value)
+		}
+		cfg.VM.EagerMB = &n
+	case "vm.no_pool":
+		if value == "" {
+			cfg.VM.NoPool = false
+			break
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid vm.no_pool %q: use true or false", value)
+		}
+		cfg.VM.NoPool = b
+	case "history.max_entries":
+		if value == "" {
+			cfg.History.MaxEntries = 0
+			break
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid history.max_entries %q: must be a positive integer", value)
+		}
+		cfg.History.MaxEntries = n
+	case "history.dedup":
+		switch value {
+		case "", "consecutive", "all", "none":
+			cfg.History.Dedup = value
+		default:
+			return fmt.Errorf("invalid history.dedup %q: use consecutive, all or none", value)
+		}
+	case "history.exclude":
+		if value == "" {
+			cfg.History.Exclude = nil
+			break
+		}
+		patterns := strings.Split(value, ",")
+		for _, p := range patterns {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("invalid history.exclude pattern %q: %w", p, err)
+			}
+		}
+		cfg.History.Exclude = patterns
+	case "history.scope":
+		switch value {
+		case "", "global", "project":
+			cfg.History.Scope = value
+		default:
+			return fmt.Errorf("invalid history.scope %q: use global or project", value)
+		}
+	case "tui.skip_confirm":
+		if value == "" {
+			cfg.TUI.SkipConfirm = nil
+			break
+		}
+		actions := strings.Split(value, ",")
+		for _, a := range actions {
+			if !slices.Contains(ConfirmActions, a) {
+				return fmt.Errorf("invalid tui.skip_confirm action %q: use %s", a, strings.Join(ConfirmActions, ", "))
+			}
+		}
+		cfg.TUI.SkipConfirm = actions
 	default:
 		return fmt.Errorf("unknown config key: %s", key)
 	}
 	return nil
 }
+
+// validAge reports whether s is an age as dh cache clean and dh vm gc
+// take for --older-than: days ("30d"), weeks ("2w"), or a duration.
+func validAge(s string) bool {
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		_, err := strconv.ParseUint(n, 10, 32)
+		return err == nil
+	}
+	if n, ok := strings.CutSuffix(s, "w"); ok {
+		_, err := strconv.ParseUint(n, 10, 32)
+		return err == nil
+	}
+	d, err := time.ParseDuration(s)
+	return err == nil && d >= 0
+}
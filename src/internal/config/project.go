@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	projectConfigFile = ".dh/config.toml"
+	pyprojectFile     = "pyproject.toml"
+)
+
+// ProjectConfig is a per-project config file: .dh/config.toml, or the
+// [tool.dh] table of pyproject.toml.
+type ProjectConfig struct {
+	Path string `json:"path"` // the file the settings came from
+	Dir  string `json:"dir"`  // project root; relative paths resolve against it
+	data []byte // the settings as config.toml content
+}
+
+// FindProjectConfig walks up from startDir to the nearest directory with a
+// .dh/config.toml, or a pyproject.toml that has a [tool.dh] table. In one
+// directory .dh/config.toml wins. The global config.toml, and ~/.dh in the
+// home directory, are never treated as project config. Returns nil if
+// there is none.
+func FindProjectConfig(startDir string) (*ProjectConfig, error) {
+	dir, err := filepath.Abs(startDir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving path: %w", err)
+	}
+	global, _ := filepath.Abs(ConfigPath())
+	home, _ := os.UserHomeDir()
+
+	for {
+		candidate := filepath.Join(dir, projectConfigFile)
+		if candidate != global && dir != home {
+			if data, err := os.ReadFile(candidate); err == nil {
+				return &ProjectConfig{Path: candidate, Dir: dir, data: data}, nil
+			}
+		}
+
+		candidate = filepath.Join(dir, pyprojectFile)
+		if data, err := os.ReadFile(candidate); err == nil {
+			tool, err := pyprojectTable(data)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", candidate, err)
+			}
+			if tool != nil {
+				return &ProjectConfig{Path: candidate, Dir: dir, data: tool}, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// pyprojectTable returns the [tool.dh] table of a pyproject.toml as
+// config.toml content, or nil if there is no such table.
+func pyprojectTable(data []byte) ([]byte, error) {
+	var py struct {
+		Tool struct {
+			DH map[string]any `toml:"dh"`
+		} `toml:"tool"`
+	}
+	if err := toml.Unmarshal(data, &py); err != nil {
+		return nil, err
+	}
+	if py.Tool.DH == nil {
+		return nil, nil
+	}
+	return toml.Marshal(py.Tool.DH)
+}
+
+// LoadEffective returns the configuration in effect in the current
+// directory; see LoadEffectiveAt.
+func LoadEffective() (*Config, *ProjectConfig, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		cfg, err := Load()
+		return cfg, nil, err
+	}
+	return LoadEffectiveAt(cwd)
+}
+
+// LoadEffectiveAt returns the configuration in effect in dir: the global
+// config.toml, with any setting in the nearest project config taking its
+// place, over the built-in defaults. It also returns the project config,
+// or nil if there is none. Relative paths in exec.pythonpath, vm.mounts
+// and the TLS files of hosts of a project config resolve against the
+// project root.
+//
+// Use Load to read or modify the global config.toml on its own.
+func LoadEffectiveAt(dir string) (*Config, *ProjectConfig, error) {
+	cfg, err := Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	proj, err := FindProjectConfig(dir)
+	if err != nil || proj == nil {
+		return cfg, nil, err
+	}
+
+	var over Config
+	if err := toml.Unmarshal(proj.data, &over); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", proj.Path, err)
+	}
+	for i, p := range over.Exec.PythonPath {
+		over.Exec.PythonPath[i] = projectPath(proj.Dir, p)
+	}
+	for i, m := range over.VM.Mounts {
+		host, rest, _ := strings.Cut(m, ":")
+		if rest != "" {
+			rest = ":" + rest
+		}
+		over.VM.Mounts[i] = projectPath(proj.Dir, host) + rest
+	}
+	for name, h := range over.Hosts {
+		// A project would otherwise send the user's secrets to a server of
+		// its choosing.
+		if h.AuthTokenEnv != "" || h.AuthTokenFile != "" {
+			return nil, nil, fmt.Errorf("%s: hosts.%s: auth_token_env and auth_token_file can only be set in %s", proj.Path, name, ConfigPath())
+		}
+		h.TLSCACert = projectPath(proj.Dir, h.TLSCACert)
+		h.TLSClientCert = projectPath(proj.Dir, h.TLSClientCert)
+		h.TLSClientKey = projectPath(proj.Dir, h.TLSClientKey)
+		over.Hosts[name] = h
+	}
+
+	// Decoding over cfg replaces exactly the keys the project sets.
+	if err := toml.Unmarshal(proj.data, cfg); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", proj.Path, err)
+	}
+	if over.Exec.PythonPath != nil {
+		cfg.Exec.PythonPath = over.Exec.PythonPath
+	}
+	if over.VM.Mounts != nil {
+		cfg.VM.Mounts = over.VM.Mounts
+	}
+	for name, h := range over.Hosts {
+		cfg.Hosts[name] = h
+	}
+	return cfg, proj, nil
+}
+
+func projectPath(root, p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" || filepath.IsAbs(p) || strings.HasPrefix(p, "~") {
+		return p
+	}
+	return filepath.Join(root, p)
+}
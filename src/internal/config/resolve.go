@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
 )
 
 // ResolveVersion determines which Deephaven version to use.
@@ -12,9 +16,20 @@ import (
 //  1. flagVersion (from --version flag)
 //  2. envVersion (from DH_VERSION env var)
 //  3. .dhrc walk-up from cwd
-//  4. config.toml default_version
+//  4. default_version from the project config, then config.toml
 //  5. Latest installed version (scan ~/.dh/versions/)
+//
+// Any of 1-4 may be a constraint such as "~41.0" or ">=0.39,<41", which
+// resolves to the newest installed version that satisfies it.
 func ResolveVersion(flagVersion, envVersion string) (string, error) {
+	spec, err := resolveVersionSpec(flagVersion, envVersion)
+	if err != nil || !versions.IsConstraint(spec) {
+		return spec, err
+	}
+	return resolveInstalledConstraint(spec)
+}
+
+func resolveVersionSpec(flagVersion, envVersion string) (string, error) {
 	// 1. Explicit flag
 	if flagVersion != "" {
 		return flagVersion, nil
@@ -35,8 +50,8 @@ func ResolveVersion(flagVersion, envVersion string) (string, error) {
 		}
 	}
 
-	// 4. config.toml default_version
-	cfg, err := Load()
+	// 4. default_version (project config over config.toml)
+	cfg, _, err := LoadEffective()
 	if err == nil && cfg.DefaultVersion != "" {
 		return cfg.DefaultVersion, nil
 	}
@@ -50,25 +65,105 @@ func ResolveVersion(flagVersion, envVersion string) (string, error) {
 	return "", fmt.Errorf("no Deephaven version configured; use --version, set DH_VERSION, create .dhrc, or run dh install")
 }
 
-// latestInstalledVersion scans ~/.dh/versions/ and returns the latest
-// directory name (sorted lexicographically, last = latest).
+// resolveInstalledConstraint returns the newest installed version that
+// satisfies spec.
+func resolveInstalledConstraint(spec string) (string, error) {
+	c, err := versions.ParseConstraint(spec)
+	if err != nil {
+		return "", err
+	}
+	installed, _ := installedVersions()
+	if v, ok := c.Newest(installed); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("no installed version matches %q; run: dh install '%s'", spec, spec)
+}
+
+// latestInstalledVersion scans ~/.dh/versions/ and returns the newest
+// version directory.
 func latestInstalledVersion() (string, error) {
-	versionsDir := filepath.Join(DHHome(), "versions")
-	entries, err := os.ReadDir(versionsDir)
+	installed, err := installedVersions()
 	if err != nil {
 		return "", err
 	}
+	if len(installed) == 0 {
+		return "", fmt.Errorf("no versions installed in %s", filepath.Join(DHHome(), "versions"))
+	}
+	versions.SortVersionsDesc(installed)
+	return installed[0], nil
+}
 
-	var versions []string
+func installedVersions() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(DHHome(), "versions"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
 	for _, e := range entries {
 		if e.IsDir() {
-			versions = append(versions, e.Name())
+			names = append(names, e.Name())
 		}
 	}
-	if len(versions) == 0 {
-		return "", fmt.Errorf("no versions installed in %s", versionsDir)
+	return names, nil
+}
+
+// VMBackends are the values of --vm-backend and vm.backend: how a restored
+// VM's memory is loaded from the snapshot.
+var VMBackends = []string{"uffd", "file"}
+
+// VMTuning is how dh exec restores VMs.
+type VMTuning struct {
+	Backend string // uffd or file
+	EagerMB *int   // MiB copied before the VM resumes; nil for the snapshot's hot chunks
+	NoPool  bool   // never take a VM from the pool daemon
+}
+
+// ResolveVMTuning determines how VMs are restored. Each setting comes
+// from the first of:
+//  1. its flag: backendFlag, eagerMBFlag and noPoolFlag ("", nil and
+//     false when not given)
+//  2. its environment variable: DH_VM_NO_UFFD=1, DH_VM_EAGER_MB and
+//     DH_VM_POOL=0
+//  3. vm.backend, vm.eager_mb and vm.no_pool from the project config,
+//     then config.toml
+//  4. the default: uffd, the snapshot's hot chunks, and the pool
+func ResolveVMTuning(backendFlag string, eagerMBFlag *int, noPoolFlag bool) (VMTuning, error) {
+	var vm VM
+	if cfg, _, err := LoadEffective(); err == nil {
+		vm = cfg.VM
+	}
+	t := VMTuning{Backend: "uffd", EagerMB: vm.EagerMB, NoPool: vm.NoPool}
+
+	switch {
+	case backendFlag != "":
+		if !slices.Contains(VMBackends, backendFlag) {
+			return t, fmt.Errorf("invalid --vm-backend %q: use %s", backendFlag, strings.Join(VMBackends, " or "))
+		}
+		t.Backend = backendFlag
+	case os.Getenv("DH_VM_NO_UFFD") == "1":
+		t.Backend = "file"
+	case vm.Backend != "":
+		if !slices.Contains(VMBackends, vm.Backend) {
+			return t, fmt.Errorf("invalid vm.backend %q in config: use %s", vm.Backend, strings.Join(VMBackends, " or "))
+		}
+		t.Backend = vm.Backend
 	}
 
-	sort.Strings(versions)
-	return versions[len(versions)-1], nil
+	if eagerMBFlag != nil {
+		if *eagerMBFlag < 0 {
+			return t, fmt.Errorf("--vm-eager-mb must not be negative")
+		}
+		t.EagerMB = eagerMBFlag
+	} else if v := os.Getenv("DH_VM_EAGER_MB"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 0 {
+			return t, fmt.Errorf("invalid DH_VM_EAGER_MB %q: must be a number of MiB", v)
+		}
+		t.EagerMB = &mb
+	}
+
+	if noPoolFlag || os.Getenv("DH_VM_POOL") == "0" {
+		t.NoPool = true
+	}
+	return t, nil
 }
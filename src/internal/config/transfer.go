@@ -0,0 +1,234 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// RedactedValue stands in for a secret in an exported or displayed config.
+// Importing it keeps the secret already configured.
+const RedactedValue = "<redacted>"
+
+// Redacted returns a copy of cfg with secrets (host auth tokens) replaced
+// by RedactedValue.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if c.Hosts != nil {
+		out.Hosts = make(map[string]Host, len(c.Hosts))
+		for name, h := range c.Hosts {
+			if h.AuthToken != "" {
+				h.AuthToken = RedactedValue
+			}
+			out.Hosts[name] = h
+		}
+	}
+	return &out
+}
+
+// Validate checks the values that dh config set would reject.
+func (c *Config) Validate() error {
+	if c.DefaultVersion != "" {
+		if _, err := versions.ParseConstraint(c.DefaultVersion); err != nil {
+			return fmt.Errorf("invalid default_version: %w", err)
+		}
+	}
+	if c.Backend != "" && !slices.Contains(Backends, c.Backend) {
+		return fmt.Errorf("invalid backend %q: use %s", c.Backend, strings.Join(Backends, " or "))
+	}
+	for name, h := range c.Hosts {
+		if h.Host == "" {
+			return fmt.Errorf("invalid hosts.%s: host is required", name)
+		}
+		sources := 0
+		for _, s := range []string{h.AuthToken, h.AuthTokenEnv, h.AuthTokenFile} {
+			if s != "" {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return fmt.Errorf("invalid hosts.%s: set only one of auth_token, auth_token_env and auth_token_file", name)
+		}
+		if (h.TLSClientCert == "") != (h.TLSClientKey == "") {
+			return fmt.Errorf("invalid hosts.%s: tls_client_cert and tls_client_key go together", name)
+		}
+	}
+	if c.History.MaxEntries < 0 {
+		return fmt.Errorf("invalid history.max_entries %d: must be a positive integer", c.History.MaxEntries)
+	}
+	switch c.History.Dedup {
+	case "", "consecutive", "all", "none":
+	default:
+		return fmt.Errorf("invalid history.dedup %q: use consecutive, all or none", c.History.Dedup)
+	}
+	for _, p := range c.History.Exclude {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid history.exclude pattern %q: %w", p, err)
+		}
+	}
+	switch c.History.Scope {
+	case "", "global", "project":
+	default:
+		return fmt.Errorf("invalid history.scope %q: use global or project", c.History.Scope)
+	}
+	for _, a := range c.TUI.SkipConfirm {
+		if !slices.Contains(ConfirmActions, a) {
+			return fmt.Errorf("invalid tui.skip_confirm action %q: use %s", a, strings.Join(ConfirmActions, ", "))
+		}
+	}
+	for check, sev := range c.Doctor.Severity {
+		if !slices.Contains(DoctorChecks, check) {
+			return fmt.Errorf("invalid doctor.severity check %q: use %s", check, strings.Join(DoctorChecks, ", "))
+		}
+		if !slices.Contains(Severities, sev) {
+			return fmt.Errorf("invalid doctor.severity.%s %q: use error or warning", check, sev)
+		}
+	}
+	return nil
+}
+
+// Export returns the global config.toml settings as TOML, optionally with
+// secrets redacted.
+func Export(redact bool) ([]byte, error) {
+	cfg, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if redact {
+		cfg = cfg.Redacted()
+	}
+	return toml.Marshal(cfg)
+}
+
+// PlanImport returns the config that importing data over cur produces.
+// With replace the file becomes the whole config; otherwise each setting
+// in the file replaces the current one and the rest are kept. Unknown keys
+// and invalid values are errors. Redacted host tokens keep the token cur
+// has for that host.
+func PlanImport(cur *Config, data []byte, replace bool) (*Config, error) {
+	var in Config
+	dec := toml.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&in); err != nil {
+		return nil, fmt.Errorf("parsing import file: %w", err)
+	}
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	next := &Config{}
+	if !replace {
+		// Decoding over a copy of cur replaces exactly the keys in data.
+		b, err := toml.Marshal(cur)
+		if err != nil {
+			return nil, err
+		}
+		if err := toml.Unmarshal(b, next); err != nil {
+			return nil, err
+		}
+	}
+	if err := toml.Unmarshal(data, next); err != nil {
+		return nil, fmt.Errorf("parsing import file: %w", err)
+	}
+
+	for name, h := range next.Hosts {
+		if h.AuthToken == RedactedValue {
+			h.AuthToken = cur.Hosts[name].AuthToken
+			next.Hosts[name] = h
+		}
+	}
+	return next, nil
+}
+
+// Diff compares two configs as TOML and returns the changed lines, "- "
+// for removed and "+ " for added, under the [section] header they belong
+// to. It returns nil when the configs are the same.
+func Diff(old, new *Config) ([]string, error) {
+	a, err := toml.Marshal(old.Redacted())
+	if err != nil {
+		return nil, err
+	}
+	b, err := toml.Marshal(new.Redacted())
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(a, b) {
+		// Tokens are hidden in the diff, so compare them separately.
+		if !maps.EqualFunc(old.Hosts, new.Hosts, func(x, y Host) bool { return x == y }) {
+			return []string{"~ host auth tokens changed"}, nil
+		}
+		return nil, nil
+	}
+	return lineDiff(splitLines(a), splitLines(b)), nil
+}
+
+// splitLines returns the non-blank lines of b.
+func splitLines(b []byte) []string {
+	var lines []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(l) != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// lineDiff diffs a and b by longest common subsequence. Only changed
+// lines are kept, each group preceded by its section header.
+func lineDiff(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	section, shown := "", ""
+	emit := func(line string) {
+		if section != "" && section != shown {
+			out = append(out, "  "+section)
+			shown = section
+		}
+		out = append(out, line)
+	}
+	isHeader := func(l string) bool { return strings.HasPrefix(l, "[") }
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			if isHeader(a[i]) {
+				section = a[i]
+			}
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			if isHeader(a[i]) {
+				section, shown = a[i], a[i]
+			}
+			emit("- " + a[i])
+			i++
+		default:
+			if isHeader(b[j]) {
+				section, shown = b[j], b[j]
+			}
+			emit("+ " + b[j])
+			j++
+		}
+	}
+	return out
+}
@@ -0,0 +1,326 @@
+// Package credentials stores the server auth tokens saved by dh auth
+// login, so they need not be passed with --auth-token, where they would
+// end up in shell history and process listings.
+//
+// Tokens go to the OS keychain: the macOS Keychain, the Windows
+// Credential Manager, or the Secret Service (GNOME Keyring, KWallet) on
+// Linux. Where there is none, as on a headless server, or when
+// DH_CREDENTIALS_STORE=file, they go to ~/.dh/credentials.toml, readable
+// only by the user and encrypted with AES-256-GCM. The key is derived from
+// DH_CREDENTIALS_PASSPHRASE when it is set, or else is a random key kept in
+// ~/.dh/credentials.key, so the file alone gives no token away.
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/zalando/go-keyring"
+)
+
+// service is the keychain service the tokens are stored under.
+const service = "dh-cli"
+
+// Stores a token can be in.
+const (
+	StoreKeychain = "keychain"
+	StoreFile     = "file"
+)
+
+// Ways the key of the file store is made.
+const (
+	kdfKeyFile    = "keyfile"       // random, kept in credentials.key
+	kdfPassphrase = "pbkdf2-sha256" // from DH_CREDENTIALS_PASSPHRASE
+)
+
+// pbkdf2Iterations is the PBKDF2-SHA256 work factor OWASP recommends.
+const pbkdf2Iterations = 600_000
+
+// file is the on-disk layout of credentials.toml. Sealed holds the tokens,
+// encrypted, and Servers the keys they are stored under, in the clear, so
+// that a server with no token needs no key. Tokens holds those an older dh
+// stored in the clear, until the next save encrypts them.
+type file struct {
+	KDF     string            `toml:"kdf,omitempty"`
+	Salt    string            `toml:"salt,omitempty"`
+	Servers []string          `toml:"servers,omitempty"`
+	Sealed  string            `toml:"sealed,omitempty"`
+	Tokens  map[string]string `toml:"tokens,omitempty"`
+}
+
+// ErrUnreadable is wrapped by the errors of Get when the file store can't
+// be read and isn't known to hold a token for the server asked about, so
+// a run that needs no token can go on without one.
+var ErrUnreadable = errors.New("the credentials file can't be read")
+
+// Key returns the key the token of the server at host and port is stored
+// under. Tokens belong to a server rather than to a [hosts.NAME] alias, so
+// an alias that a project config points elsewhere can't pick up the token
+// of another server.
+func Key(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Path returns the location of the file store under dhHome.
+func Path(dhHome string) string {
+	return filepath.Join(dhHome, "credentials.toml")
+}
+
+// KeyPath returns the location of the file store's key under dhHome.
+func KeyPath(dhHome string) string {
+	return filepath.Join(dhHome, "credentials.key")
+}
+
+// fileOnly reports whether DH_CREDENTIALS_STORE=file keeps the keychain
+// out of it.
+func fileOnly() bool {
+	return os.Getenv("DH_CREDENTIALS_STORE") == StoreFile
+}
+
+// Get returns the token stored under key, and "" when there is none.
+func Get(dhHome, key string) (string, error) {
+	if !fileOnly() {
+		token, err := keyring.Get(service, key)
+		if err == nil {
+			return token, nil
+		}
+		// Without a keychain, or without the token in it, look in the
+		// file store.
+	}
+	f, err := readFile(dhHome)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrUnreadable, err)
+	}
+	if f.Sealed != "" && !slices.Contains(f.Servers, key) {
+		return "", nil
+	}
+	tokens, err := unseal(dhHome, f)
+	if err != nil {
+		return "", err
+	}
+	return tokens[key], nil
+}
+
+// Set stores token under key and returns the store it went to: the
+// keychain, or the file store where there is no keychain.
+func Set(dhHome, key, token string) (string, error) {
+	if !fileOnly() {
+		if err := keyring.Set(service, key, token); err == nil {
+			// Drop any copy an earlier login left in the file store.
+			if _, err := deleteFile(dhHome, key); err != nil {
+				return "", err
+			}
+			return StoreKeychain, nil
+		}
+	}
+	tokens, err := load(dhHome)
+	if err != nil {
+		return "", err
+	}
+	tokens[key] = token
+	if err := save(dhHome, tokens); err != nil {
+		return "", err
+	}
+	return StoreFile, nil
+}
+
+// Delete removes the token stored under key from both stores. It reports
+// whether there was one.
+func Delete(dhHome, key string) (bool, error) {
+	found := false
+	if !fileOnly() {
+		err := keyring.Delete(service, key)
+		found = err == nil
+	}
+	inFile, err := deleteFile(dhHome, key)
+	return found || inFile, err
+}
+
+func deleteFile(dhHome, key string) (bool, error) {
+	tokens, err := load(dhHome)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := tokens[key]; !ok {
+		return false, nil
+	}
+	delete(tokens, key)
+	return true, save(dhHome, tokens)
+}
+
+func load(dhHome string) (map[string]string, error) {
+	f, err := readFile(dhHome)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(dhHome, f)
+}
+
+// readFile parses the file store, which is empty when there is none.
+func readFile(dhHome string) (*file, error) {
+	var f file
+	data, err := os.ReadFile(Path(dhHome))
+	if errors.Is(err, os.ErrNotExist) {
+		return &f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials: %w", err)
+	}
+	if err := toml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", Path(dhHome), err)
+	}
+	return &f, nil
+}
+
+// unseal returns the tokens of f.
+func unseal(dhHome string, f *file) (map[string]string, error) {
+	if f.Sealed == "" {
+		if f.Tokens == nil {
+			f.Tokens = map[string]string{}
+		}
+		return f.Tokens, nil
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(f.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: bad salt: %w", Path(dhHome), err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(f.Sealed)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: bad sealed tokens: %w", Path(dhHome), err)
+	}
+	key, err := fileKey(dhHome, f.KDF, salt, false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("parsing %s: sealed tokens too short", Path(dhHome))
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		if f.KDF == kdfPassphrase {
+			return nil, fmt.Errorf("decrypting %s: wrong DH_CREDENTIALS_PASSPHRASE", Path(dhHome))
+		}
+		return nil, fmt.Errorf("decrypting %s: it doesn't match %s", Path(dhHome), KeyPath(dhHome))
+	}
+	tokens := map[string]string{}
+	if err := toml.Unmarshal(plain, &tokens); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", Path(dhHome), err)
+	}
+	return tokens, nil
+}
+
+// fileKey returns the key of the file store, made as kdf says: from
+// DH_CREDENTIALS_PASSPHRASE and salt, or read from credentials.key, which
+// create makes when there is none.
+func fileKey(dhHome, kdf string, salt []byte, create bool) ([]byte, error) {
+	switch kdf {
+	case kdfPassphrase:
+		passphrase := os.Getenv("DH_CREDENTIALS_PASSPHRASE")
+		if passphrase == "" {
+			return nil, fmt.Errorf("%s is encrypted with a passphrase; set DH_CREDENTIALS_PASSPHRASE", Path(dhHome))
+		}
+		return pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, 32)
+	case kdfKeyFile:
+		key, err := os.ReadFile(KeyPath(dhHome))
+		if errors.Is(err, os.ErrNotExist) && create {
+			key = make([]byte, 32)
+			rand.Read(key)
+			err = os.WriteFile(KeyPath(dhHome), key, 0o600)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading credentials key: %w", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%s is not a 32-byte key", KeyPath(dhHome))
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s has an unknown kdf %q", Path(dhHome), kdf)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts tokens into a file, with a key from
+// DH_CREDENTIALS_PASSPHRASE when it is set, or else from credentials.key.
+func seal(dhHome string, tokens map[string]string) (file, error) {
+	plain, err := toml.Marshal(tokens)
+	if err != nil {
+		return file{}, fmt.Errorf("marshaling credentials: %w", err)
+	}
+	f := file{KDF: kdfKeyFile, Servers: slices.Sorted(maps.Keys(tokens))}
+	var salt []byte
+	if os.Getenv("DH_CREDENTIALS_PASSPHRASE") != "" {
+		f.KDF = kdfPassphrase
+		salt = make([]byte, 16)
+		rand.Read(salt)
+		f.Salt = base64.StdEncoding.EncodeToString(salt)
+	}
+	key, err := fileKey(dhHome, f.KDF, salt, true)
+	if err != nil {
+		return file{}, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return file{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	f.Sealed = base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil))
+	return f, nil
+}
+
+func save(dhHome string, tokens map[string]string) error {
+	if err := os.MkdirAll(dhHome, 0o755); err != nil {
+		return fmt.Errorf("creating config dir: %w", err)
+	}
+	f, err := seal(dhHome, tokens)
+	if err != nil {
+		return err
+	}
+	data, err := toml.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("marshaling credentials: %w", err)
+	}
+	// Write a new file rather than truncating, so the token is never in a
+	// file others can read, even one left by an older dh.
+	tmp, err := os.CreateTemp(dhHome, ".credentials-*.toml")
+	if err != nil {
+		return fmt.Errorf("writing credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing credentials: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), Path(dhHome)); err != nil {
+		return fmt.Errorf("writing credentials: %w", err)
+	}
+	return nil
+}
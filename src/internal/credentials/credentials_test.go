@@ -0,0 +1,154 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestFileStore(t *testing.T) {
+	t.Setenv("DH_CREDENTIALS_STORE", "file")
+	dir := t.TempDir()
+	key := Key("dh.example.com", 8443)
+	if key != "dh.example.com:8443" {
+		t.Fatalf("Key = %q", key)
+	}
+
+	if token, err := Get(dir, key); err != nil || token != "" {
+		t.Fatalf("Get before login = %q, %v", token, err)
+	}
+	store, err := Set(dir, key, "s3cret")
+	if err != nil || store != StoreFile {
+		t.Fatalf("Set = %q, %v", store, err)
+	}
+	fi, err := os.Stat(Path(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("credentials.toml mode = %o, want 600", perm)
+	}
+	if token, err := Get(dir, key); err != nil || token != "s3cret" {
+		t.Fatalf("Get = %q, %v", token, err)
+	}
+	if data, _ := os.ReadFile(Path(dir)); strings.Contains(string(data), "s3cret") {
+		t.Errorf("credentials.toml has the token in the clear:\n%s", data)
+	}
+	if fi, err := os.Stat(KeyPath(dir)); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("credentials.key = %v, %v; want mode 600", fi, err)
+	}
+
+	if found, err := Delete(dir, key); err != nil || !found {
+		t.Fatalf("Delete = %v, %v", found, err)
+	}
+	if found, err := Delete(dir, key); err != nil || found {
+		t.Fatalf("second Delete = %v, %v", found, err)
+	}
+}
+
+func TestFileStorePassphrase(t *testing.T) {
+	t.Setenv("DH_CREDENTIALS_STORE", "file")
+	t.Setenv("DH_CREDENTIALS_PASSPHRASE", "open sesame")
+	dir := t.TempDir()
+	key := Key("localhost", 10000)
+
+	if _, err := Set(dir, key, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(KeyPath(dir)); !os.IsNotExist(err) {
+		t.Error("a passphrase should leave no credentials.key")
+	}
+	if token, err := Get(dir, key); err != nil || token != "s3cret" {
+		t.Fatalf("Get = %q, %v", token, err)
+	}
+
+	t.Setenv("DH_CREDENTIALS_PASSPHRASE", "wrong")
+	if _, err := Get(dir, key); err == nil || !strings.Contains(err.Error(), "wrong DH_CREDENTIALS_PASSPHRASE") {
+		t.Errorf("Get with the wrong passphrase: %v", err)
+	}
+	t.Setenv("DH_CREDENTIALS_PASSPHRASE", "")
+	if _, err := Get(dir, key); err == nil || !strings.Contains(err.Error(), "set DH_CREDENTIALS_PASSPHRASE") {
+		t.Errorf("Get without the passphrase: %v", err)
+	}
+}
+
+func TestGetUnreadable(t *testing.T) {
+	t.Setenv("DH_CREDENTIALS_STORE", "file")
+	t.Setenv("DH_CREDENTIALS_PASSPHRASE", "open sesame")
+	dir := t.TempDir()
+	stored, other := Key("prod", 10000), Key("localhost", 10000)
+	if _, err := Set(dir, stored, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the passphrase, only the server the file has a token for
+	// needs it.
+	t.Setenv("DH_CREDENTIALS_PASSPHRASE", "")
+	if token, err := Get(dir, other); err != nil || token != "" {
+		t.Errorf("Get of a server without a token = %q, %v", token, err)
+	}
+	if _, err := Get(dir, stored); err == nil || errors.Is(err, ErrUnreadable) {
+		t.Errorf("Get of the stored token without the passphrase: %v", err)
+	}
+
+	// A file that doesn't parse may or may not hold the token.
+	os.WriteFile(Path(dir), []byte("not = [toml"), 0o600)
+	if _, err := Get(dir, other); !errors.Is(err, ErrUnreadable) {
+		t.Errorf("Get from a corrupt file: %v", err)
+	}
+}
+
+func TestFileStoreClearTokens(t *testing.T) {
+	t.Setenv("DH_CREDENTIALS_STORE", "file")
+	dir := t.TempDir()
+	key := Key("localhost", 10000)
+
+	// Tokens an older dh stored in the clear are read, and encrypted by
+	// the next save.
+	os.WriteFile(Path(dir), []byte("[tokens]\n'localhost:10000' = 'old'\n"), 0o600)
+	if token, err := Get(dir, key); err != nil || token != "old" {
+		t.Fatalf("Get = %q, %v", token, err)
+	}
+	if _, err := Set(dir, Key("localhost", 10001), "other"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(Path(dir)); strings.Contains(string(data), "[tokens]") {
+		t.Errorf("credentials.toml still has a token in the clear:\n%s", data)
+	}
+	if token, err := Get(dir, key); err != nil || token != "old" {
+		t.Fatalf("Get after save = %q, %v", token, err)
+	}
+}
+
+func TestKeychainStore(t *testing.T) {
+	keyring.MockInit()
+	dir := t.TempDir()
+	key := Key("localhost", 10000)
+
+	// A token an earlier login left in the file store moves to the
+	// keychain.
+	t.Setenv("DH_CREDENTIALS_STORE", "file")
+	if _, err := Set(dir, key, "old"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DH_CREDENTIALS_STORE", "")
+	store, err := Set(dir, key, "new")
+	if err != nil || store != StoreKeychain {
+		t.Fatalf("Set = %q, %v", store, err)
+	}
+	if tokens, _ := load(dir); tokens[key] != "" {
+		t.Errorf("file store still has %q", tokens[key])
+	}
+	if token, err := Get(dir, key); err != nil || token != "new" {
+		t.Fatalf("Get = %q, %v", token, err)
+	}
+	if found, err := Delete(dir, key); err != nil || !found {
+		t.Fatalf("Delete = %v, %v", found, err)
+	}
+	if token, _ := Get(dir, key); token != "" {
+		t.Errorf("Get after Delete = %q", token)
+	}
+}
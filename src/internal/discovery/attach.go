@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseAttach parses the server given to --attach: ":PORT" for the server
+// listening on PORT, or "PID" for the server process PID. Exactly one of
+// port and pid is set.
+func ParseAttach(spec string) (port, pid int, err error) {
+	s, isPort := strings.CutPrefix(spec, ":")
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("invalid --attach %q: want :PORT or PID", spec)
+	}
+	if isPort {
+		if n > 65535 {
+			return 0, 0, fmt.Errorf("invalid --attach %q: port out of range", spec)
+		}
+		return n, 0, nil
+	}
+	return 0, n, nil
+}
+
+// Find returns the running Deephaven server listening on port, or run by
+// process pid. Servers started by dh come with the auth they were started
+// with; for other processes the auth is read from their -Dauthentication.psk
+// flag, if any. Servers in Docker can only be found by port.
+func Find(dhHome string, port, pid int) (*Registration, error) {
+	matches := func(s Server) bool {
+		return (port != 0 && s.Port == port) || (pid != 0 && s.PID == pid)
+	}
+	for _, r := range Registered(dhHome) {
+		if matches(r.Server) {
+			return &r, nil
+		}
+	}
+
+	servers, err := Discover()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range servers {
+		if !matches(s) {
+			continue
+		}
+		r := &Registration{Server: s}
+		if s.PID != 0 {
+			r.AuthType, r.AuthToken = AuthFromJVMArgs(strings.Fields(readProcCmdline(s.PID)))
+		}
+		return r, nil
+	}
+	if port != 0 {
+		return nil, fmt.Errorf("no Deephaven server is listening on port %d; dh list shows the running servers", port)
+	}
+	return nil, fmt.Errorf("process %d is not a running Deephaven server; dh list shows the running servers", pid)
+}
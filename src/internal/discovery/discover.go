@@ -14,10 +14,16 @@ type Server struct {
 	Script      string `json:"script,omitempty"`
 	CWD         string `json:"cwd,omitempty"`
 	ContainerID string `json:"container_id,omitempty"`
+
+	// Set for servers started by dh (see Register)
+	Name     string `json:"name,omitempty"`
+	Version  string `json:"version,omitempty"`
+	AuthType string `json:"auth_type,omitempty"`
 }
 
-// Discover finds all running Deephaven servers by combining platform-specific
-// process discovery with Docker container discovery. Results are deduplicated by port.
+// Discover finds all running Deephaven servers by combining the servers dh
+// started (see Register) with platform-specific process discovery and Docker
+// container discovery. Results are deduplicated by port.
 func Discover() ([]Server, error) {
 	procServers, err := discoverProcesses()
 	if err != nil {
@@ -29,6 +35,7 @@ func Discover() ([]Server, error) {
 		return nil, fmt.Errorf("docker discovery: %w", err)
 	}
 
+	procServers = deduplicateByPort(registeredServers(), procServers)
 	return deduplicateByPort(procServers, dockerServers), nil
 }
 
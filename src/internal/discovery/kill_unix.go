@@ -7,3 +7,9 @@ import "syscall"
 func killProcess(pid int) error {
 	return syscall.Kill(pid, syscall.SIGTERM)
 }
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
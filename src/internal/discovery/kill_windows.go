@@ -2,8 +2,21 @@
 
 package discovery
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
 func killProcess(pid int) error {
 	return fmt.Errorf("process kill is not supported on Windows")
 }
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
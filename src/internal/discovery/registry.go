@@ -0,0 +1,113 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+)
+
+// registryDir is the directory under the config directory that holds one
+// <port>.json file per server started by dh.
+const registryDir = "servers"
+
+// Registration is the record Register keeps for a server started by dh.
+// The auth token is kept out of Server so dh list never shows it.
+type Registration struct {
+	Server
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// Register records a server started by dh, so Discover finds it with the
+// command that started it as its source, even while it is still starting
+// up, and Lookup finds it by name. Names are unique among running
+// servers. The returned function removes the record.
+func Register(dhHome string, r Registration) (func(), error) {
+	if r.Name != "" {
+		if other, err := Lookup(dhHome, r.Name); err == nil && other.Port != r.Port {
+			return nil, fmt.Errorf("a server named %q is already running on port %d", r.Name, other.Port)
+		}
+	}
+	dir := filepath.Join(dhHome, registryDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating %s: %w", dir, err)
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	// 0600: the record may hold an auth token
+	path := filepath.Join(dir, fmt.Sprintf("%d.json", r.Port))
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// Registered returns the records kept by Register whose process is still
+// running. Records left behind by processes that have exited are removed.
+func Registered(dhHome string) []Registration {
+	dir := filepath.Join(dhHome, registryDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var regs []Registration
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var r Registration
+		if err := json.Unmarshal(data, &r); err != nil || r.PID <= 0 || !processAlive(r.PID) {
+			os.Remove(path)
+			continue
+		}
+		regs = append(regs, r)
+	}
+	return regs
+}
+
+// Lookup returns the running server registered under name.
+func Lookup(dhHome, name string) (*Registration, error) {
+	for _, r := range Registered(dhHome) {
+		if r.Name == name {
+			return &r, nil
+		}
+	}
+	return nil, fmt.Errorf("no running server named %q; start one with dh serve --name %s", name, name)
+}
+
+// registeredServers returns the registered servers in the current config
+// directory.
+func registeredServers() []Server {
+	var servers []Server
+	for _, r := range Registered(config.DHHome()) {
+		servers = append(servers, r.Server)
+	}
+	return servers
+}
+
+// PSKAuthType is the pydeephaven auth type for a server that uses a
+// pre-shared key.
+const PSKAuthType = "io.deephaven.authentication.psk.PskAuthenticationHandler"
+
+// AuthFromJVMArgs returns the auth type and token a client needs for an
+// embedded server started with the given JVM flags: the pre-shared key
+// from -Dauthentication.psk, or none (anonymous).
+func AuthFromJVMArgs(flags []string) (authType, authToken string) {
+	for _, f := range flags {
+		if key, ok := strings.CutPrefix(f, "-Dauthentication.psk="); ok {
+			return PSKAuthType, key
+		}
+	}
+	return "", ""
+}
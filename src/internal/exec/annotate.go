@@ -0,0 +1,104 @@
+package exec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// userCodeFrame matches a traceback frame in the user's code, which the
+// runner compiles with the file name "<string>". Line numbers are those
+// of the script.
+var userCodeFrame = regexp.MustCompile(`File "<string>", line (\d+)`)
+
+// runAnnotated runs cfg with JSON capture forced on, prints the result as
+// a normal run would once it finishes, and annotates it.
+func runAnnotated(cfg *ExecConfig) (int, map[string]any, error) {
+	inner := *cfg
+	inner.Annotate = false
+	inner.JSONMode = true
+
+	exitCode, result, err := Run(&inner)
+	if err != nil || result == nil {
+		return exitCode, result, err
+	}
+	printResult(cfg, result)
+	annotateResult(cfg, result, "", nil)
+	return exitCode, nil, nil
+}
+
+// annotateResult writes GitHub Actions annotations for the error and
+// warnings of a JSON run result, pointing at the script's line where the
+// traceback or warning has one, and for drift from the recording at
+// recording.
+func annotateResult(cfg *ExecConfig, result map[string]any, recording string, drift []string) {
+	script := ""
+	if cfg.ScriptPath != "-" {
+		script = cfg.ScriptPath
+	}
+	// Several scripts, or a notebook's cells, run one by one, so line
+	// numbers are those of the script or cell that failed: the error
+	// points at the script, and at the notebook without a line.
+	_, cells := result["cells"]
+	files, _ := result["files"].([]any)
+	errScript, lines := script, !cells
+	if len(files) > 0 {
+		if f, _ := files[len(files)-1].(map[string]any); f["error"] != nil {
+			errScript = append([]string{cfg.ScriptPath}, cfg.ExtraScripts...)[len(files)-1]
+			lines = true
+		}
+	}
+
+	if errText, _ := result["error"].(string); errText != "" {
+		line, msg := ParseTraceback(errText)
+		if !lines {
+			line = 0
+		}
+		a := output.Annotation{Level: output.AnnotationError, File: errScript, Line: line, Title: "dh exec", Message: msg}
+		output.Annotate(cfg.Stderr, a)
+	}
+
+	warnings, _ := result["warnings"].([]any)
+	for _, item := range warnings {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		category, _ := m["category"].(string)
+		message, _ := m["message"].(string)
+		a := output.Annotation{Level: output.AnnotationWarning, Title: "dh exec: " + category, Message: message}
+		if filename, _ := m["filename"].(string); filename == "<string>" && !cells && files == nil {
+			a.File, a.Line = script, toInt(m["lineno"])
+		}
+		if cfg.FailOnWarning {
+			a.Level = output.AnnotationError
+		}
+		output.Annotate(cfg.Stderr, a)
+	}
+
+	if len(drift) > 0 {
+		output.Annotate(cfg.Stderr, output.Annotation{
+			Level:   output.AnnotationError,
+			File:    script,
+			Title:   "dh exec --replay",
+			Message: fmt.Sprintf("Output drifted from %s:\n%s", recording, strings.Join(drift, "\n")),
+		})
+	}
+}
+
+// ParseTraceback returns the line of the user's script a Python traceback
+// points at (0 if none), and its last line, the exception. Text that isn't
+// a traceback is returned whole.
+func ParseTraceback(text string) (line int, message string) {
+	text = strings.TrimSpace(text)
+	if matches := userCodeFrame.FindAllStringSubmatch(text, -1); len(matches) > 0 {
+		fmt.Sscanf(matches[len(matches)-1][1], "%d", &line)
+	}
+	if !strings.HasPrefix(text, "Traceback") {
+		return line, text
+	}
+	lines := strings.Split(text, "\n")
+	return line, strings.TrimSpace(lines[len(lines)-1])
+}
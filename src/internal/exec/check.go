@@ -0,0 +1,236 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// compileScript compiles each source it reads from stdin, a JSON list of
+// {"name", "source"}, without running it, and writes the syntax errors
+// it finds as a JSON list.
+const compileScript = `
+import json, sys
+found = []
+for i, s in enumerate(json.load(sys.stdin)):
+    try:
+        compile(s["source"], s["name"], "exec", dont_inherit=True)
+    except SyntaxError as e:
+        found.append({"part": i, "line": e.lineno or 0, "column": e.offset or 0,
+                      "message": "%s: %s" % (type(e).__name__, e.msg), "text": (e.text or "").rstrip("\n")})
+    except ValueError as e:
+        found.append({"part": i, "line": 0, "column": 0, "message": "ValueError: %s" % e, "text": ""})
+json.dump(found, sys.stdout)
+`
+
+// Check is one thing --check verified.
+type Check struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// Diagnostic is a syntax error --check found.
+type Diagnostic struct {
+	Path    string `json:"path"` // script path, "cell N" of a notebook, "<string>" for -c or "<stdin>"
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	Text    string `json:"text,omitempty"`
+}
+
+// runCheck does what Run would up to starting a server or VM: it reads
+// the code and compiles it, resolves the version, and checks that what
+// the run needs is installed: the venv and Java, or the VM snapshot. The
+// flags were validated by Run already.
+func runCheck(cfg *ExecConfig) (int, map[string]any, error) {
+	if cfg.VMMode && cfg.Host != "" {
+		return output.ExitError, nil, fmt.Errorf("cannot use both --vm and --host flags")
+	}
+	userCode, err := readCode(cfg)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+
+	var checks []Check
+	add := func(name string, err error, detail string) {
+		if err != nil {
+			checks = append(checks, Check{Name: name, Detail: err.Error()})
+		} else {
+			checks = append(checks, Check{Name: name, OK: true, Detail: detail})
+		}
+	}
+
+	config.SetConfigDir(cfg.ConfigDir)
+	dhHome := config.DHHome()
+	version, err := config.ResolveVersion(cfg.Version, os.Getenv("DH_VERSION"))
+	if err != nil && cfg.VMMode {
+		if v, snapErr := latestSnapshotVersion(dhHome); snapErr == nil {
+			version, err = v, nil
+		}
+	}
+	add("version", err, version)
+
+	var pythonBin string
+	if version != "" {
+		if cfg.VMMode {
+			if cfg.Instance != "" {
+				add("snapshot", nil, fmt.Sprintf("not needed: runs in VM %s", cfg.Instance))
+			} else {
+				paths := vm.NewVMPaths(dhHome)
+				key := vm.SnapshotKey(version, cfg.Profile)
+				prereqs := vm.CheckPrerequisites(paths)
+				for _, e := range prereqs {
+					add("vm "+e.Check, errors.New(e.Message), "")
+				}
+				if len(prereqs) == 0 {
+					add("vm", nil, "prerequisites met")
+				}
+				add("snapshot", vm.CheckSnapshot(paths, key), key)
+			}
+		} else {
+			pythonBin, err = FindVenvPython(dhHome, version)
+			add("venv", err, pythonBin)
+			if cfg.Host == "" {
+				info, err := java.Detect(dhHome)
+				if err == nil && !info.Found {
+					err = fmt.Errorf("Java not found; install Java 17+ or set JAVA_HOME")
+				}
+				detail := ""
+				if err == nil {
+					detail = fmt.Sprintf("%s (version %s)", info.Path, info.Version)
+				}
+				add("java", err, detail)
+			}
+		}
+	}
+
+	// --vm runs don't need Python on the host, and a missing venv is
+	// reported above; any Python will do to compile
+	if pythonBin == "" {
+		pythonBin, _ = exec.LookPath("python3")
+	}
+	var diags []Diagnostic
+	switch {
+	case cfg.Language != "python":
+		add("syntax", nil, fmt.Sprintf("not checked for %s", cfg.Language))
+	case pythonBin == "":
+		add("syntax", fmt.Errorf("not checked: no Python to compile with"), "")
+	default:
+		diags, err = compileCode(cfg, pythonBin, userCode)
+		if err == nil && len(diags) > 0 {
+			err = fmt.Errorf("%d syntax error(s)", len(diags))
+		}
+		add("syntax", err, "ok")
+	}
+
+	exitCode := output.ExitSuccess
+	for _, c := range checks {
+		if !c.OK {
+			exitCode = output.ExitError
+		}
+	}
+	if cfg.JSONMode {
+		if diags == nil {
+			diags = []Diagnostic{}
+		}
+		return exitCode, map[string]any{
+			"check":       true,
+			"ok":          exitCode == output.ExitSuccess,
+			"exit_code":   exitCode,
+			"version":     version,
+			"checks":      checks,
+			"diagnostics": diags,
+		}, nil
+	}
+
+	for _, d := range diags {
+		fmt.Fprintf(cfg.Stderr, "%s:%d:%d: %s\n", d.Path, d.Line, d.Column, d.Message)
+		if d.Text != "" {
+			fmt.Fprintf(cfg.Stderr, "    %s\n", strings.TrimSpace(d.Text))
+		}
+	}
+	if !cfg.Quiet || exitCode != output.ExitSuccess {
+		for _, c := range checks {
+			symbol := "\u2713" // checkmark
+			if !c.OK {
+				symbol = "\u2717" // X mark
+			}
+			fmt.Fprintf(cfg.Stderr, "  %s %-12s %s\n", symbol, c.Name, c.Detail)
+		}
+	}
+	return exitCode, nil, nil
+}
+
+// compileCode compiles userCode with pythonBin, each script or notebook
+// cell on its own, and returns the syntax errors with the line in the
+// script or cell they are on.
+func compileCode(cfg *ExecConfig, pythonBin, userCode string) ([]Diagnostic, error) {
+	type source struct {
+		Name   string `json:"name"`
+		Source string `json:"source"`
+	}
+	var sources []source
+	if len(cfg.parts) > 0 {
+		lines := strings.SplitAfter(userCode, "\n")
+		for _, p := range cfg.parts {
+			name := p.Path
+			if name == "" {
+				name = fmt.Sprintf("cell %d", p.Index)
+			}
+			end := min(p.Line-1+p.Lines, len(lines))
+			sources = append(sources, source{name, strings.Join(lines[p.Line-1:end], "")})
+		}
+	} else {
+		name := "<string>"
+		switch cfg.ScriptPath {
+		case "":
+		case "-":
+			name = "<stdin>"
+		default:
+			name = cfg.ScriptPath
+			if abs, err := filepath.Abs(name); err == nil {
+				name = abs
+			}
+		}
+		sources = append(sources, source{name, userCode})
+	}
+
+	input, err := json.Marshal(sources)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := ExecCommand(pythonBin, "-c", compileScript)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	output.TraceCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("compiling with %s: %v: %s", pythonBin, err, strings.TrimSpace(stderr.String()))
+	}
+	var found []struct {
+		Part    int    `json:"part"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+		Message string `json:"message"`
+		Text    string `json:"text"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &found); err != nil {
+		return nil, fmt.Errorf("reading compile results: %w", err)
+	}
+	diags := make([]Diagnostic, 0, len(found))
+	for _, f := range found {
+		diags = append(diags, Diagnostic{Path: sources[f.Part].Name, Line: f.Line, Column: f.Column, Message: f.Message, Text: f.Text})
+	}
+	return diags, nil
+}
@@ -2,7 +2,6 @@ package exec
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	_ "embed"
 	"fmt"
@@ -18,8 +17,11 @@ import (
 	"time"
 
 	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
 	"github.com/dsmmcken/dh-cli/src/internal/java"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/dsmmcken/dh-cli/src/internal/serverdaemon"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
 )
 
 //go:embed runner.py
@@ -33,8 +35,15 @@ func RunnerScript() string {
 // ExecConfig holds all configuration for an exec invocation.
 type ExecConfig struct {
 	// Code source (exactly one must be set)
-	Code       string // from -c flag
-	ScriptPath string // positional arg (file path or "-" for stdin)
+	Code         string   // from -c flag
+	ScriptPath   string   // positional arg (file path, http(s) URL, or "-" for stdin)
+	ExtraScripts []string // more positional args, run after ScriptPath in the same session until one fails
+	Git          string   // --git REPO@REF:PATH
+
+	// Code from a URL or --git is only fetched with AllowRemoteCode, and
+	// only up to MaxRemoteKB (0 for DefaultMaxRemoteKB)
+	AllowRemoteCode bool
+	MaxRemoteKB     int
 
 	// Server options
 	Port    int
@@ -45,9 +54,49 @@ type ExecConfig struct {
 	ShowTables    bool
 	ShowTableMeta bool
 	JSONMode      bool
+	JSONStream    bool // write NDJSON events as the run goes instead of one JSON result
 	Verbose       bool
 	Quiet         bool
-	Timeout       int // seconds, 0 = no timeout
+	Timeout       int    // seconds for the whole run, 0 = no timeout
+	FailOnWarning bool   // exit non-zero when the script emits warnings
+	HashTables    bool   // include a content hash in each table's JSON info
+	TableOutput   string // preview format: pretty (default), csv, markdown, html or json
+	MaxRows       *int   // rows per preview; nil for 10, 0 for all
+	MaxCols       int    // columns per preview; 0 for all
+
+	// Timeouts of the phases of a run, in seconds, 0 for none:
+	// ConnectTimeout for starting or reaching the server, or restoring the
+	// VM, and ExecTimeout for the code, from when the server is ready. A
+	// run that times out is interrupted (SIGINT), and killed KillAfter
+	// seconds later if it is still going. Runs with a timeout never use
+	// the pool.
+	ConnectTimeout int
+	ExecTimeout    int
+	KillAfter      int
+
+	// Regression mode (at most one): save the run to, or compare it
+	// against, a golden directory
+	Record string
+	Replay string
+
+	// Also report the script's error, warnings and replay drift as GitHub
+	// Actions annotations on Stderr
+	Annotate bool
+
+	// Only compile the code and check what the run needs (--check),
+	// without starting a server or VM
+	Check bool
+
+	// Language of the code: "python" (default) or "groovy", which only
+	// remote servers run; "" picks groovy for .groovy scripts
+	Language string
+
+	// With a .ipynb script, run only the code cells that have one of
+	// these tags (--cell-tag)
+	CellTags []string
+
+	// Run in a server of its own even when the server daemon is running
+	NoDaemon bool
 
 	// Remote options
 	Host          string
@@ -59,9 +108,45 @@ type ExecConfig struct {
 	TLSClientKey  string
 
 	// VM mode (experimental)
-	VMMode bool
+	VMMode         bool
+	Mounts         []string // --mount specs: host_path[:guest_alias][:ro|rw]
+	AllowWrite     []string // paths in the working directory the VM may write
+	MountMode      string   // how the guest sees /workspace: "preload" (default) or "fuse"
+	CollectOutputs string   // host dir for the files the script saves in DH_OUTPUT_DIR; never uses the pool
+	Profile        string   // snapshot profile from 'dh vm prepare --profile'; never uses the pool
+	Jailed         bool     // run Firecracker under the jailer; never uses the pool
+	Net            string   // --net: "none" or "nat" (outbound access through NAT); never uses the pool
+	AutoRebuild    bool     // retake a snapshot the installed Firecracker or kernel can't restore
+	VMBackend      string   // --vm-backend: uffd or file; "" for DH_VM_NO_UFFD or vm.backend
+	VMEagerMB      *int     // --vm-eager-mb; nil for DH_VM_EAGER_MB or vm.eager_mb
+	NoPool         bool     // --no-pool; false leaves it to DH_VM_POOL and vm.no_pool
+	Instance       string   // --instance: run in this named VM from 'dh vm up' instead of restoring one
+	Session        string   // --session: like Instance, starting the named VM if it is not up
+	TableFormat    string   // --table-format: "text" previews, or "arrow" to also save assigned tables as Arrow IPC streams
+	TableDir       string   // where --table-format arrow writes NAME.arrow; default: the working directory
+
+	// Files loaded as tables before the code runs (--import-table
+	// NAME=PATH); parsed by Run into TableImports
+	ImportTables []string
+
+	// Tables saved to files after the code runs (--export-table
+	// NAME=PATH); parsed by Run into TableExports
+	ExportTables []string
+
+	// Extra import dirs (--pythonpath); merged with exec.pythonpath
+	// from config.toml and resolved to absolute paths by Run
+	PythonPath []string
+
+	// Packages to run with (--with), installed once per set in an overlay
+	// of the version's venv rather than in the venv itself
+	With []string
 
 	// Resolved state (populated by Run)
+	TableImports []vm.TableImport
+	TableExports []vm.TableExport
+	events       *eventWriter // set by runStreamed for --json-stream
+	timing       *Timing      // set by Run
+	parts        []codePart   // the cells of a notebook, or ScriptPath and ExtraScripts; set by readCode
 	ConfigDir    string
 	Stderr       io.Writer
 	Stdout       io.Writer
@@ -72,7 +157,22 @@ type ExecConfig struct {
 var ExecCommand = exec.Command
 
 // Run executes the dh exec workflow. Returns exit code, optional JSON result, and error.
+// JSON results carry the error_type of a failed run and the timing of its phases.
 func Run(cfg *ExecConfig) (int, map[string]any, error) {
+	// Runs within this one (--record, --json-stream) fill in its timing
+	if cfg.timing == nil {
+		cfg.timing = newTiming(cfg.ProcessStart)
+		defer func() { cfg.timing = nil }()
+	}
+	exitCode, result, err := run(cfg)
+	if result != nil {
+		result["error_type"] = errorType(exitCode, result)
+		result["timing"] = cfg.timing.finish()
+	}
+	return exitCode, result, err
+}
+
+func run(cfg *ExecConfig) (int, map[string]any, error) {
 	runStart := time.Now()
 
 	if cfg.Stderr == nil {
@@ -81,6 +181,19 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 	if cfg.Stdout == nil {
 		cfg.Stdout = os.Stdout
 	}
+	if err := FetchRemoteCode(cfg); err != nil {
+		return output.ExitError, nil, err
+	}
+
+	if cfg.JSONStream {
+		if cfg.JSONMode {
+			return output.ExitError, nil, fmt.Errorf("cannot use both --json and --json-stream")
+		}
+		if cfg.Check {
+			return output.ExitError, nil, fmt.Errorf("--check can't be used with --json-stream; use --json")
+		}
+		return runStreamed(cfg)
+	}
 
 	// Validate inputs
 	if cfg.Code != "" && cfg.ScriptPath != "" {
@@ -89,6 +202,167 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 	if cfg.Code == "" && cfg.ScriptPath == "" {
 		return output.ExitError, nil, fmt.Errorf("must provide either -c CODE or a script file (use - for stdin)")
 	}
+	for _, p := range cfg.ExtraScripts {
+		if p == "-" || cfg.ScriptPath == "-" {
+			return output.ExitError, nil, fmt.Errorf("stdin (-) can't be one of several scripts")
+		}
+		if isNotebook(p) || isNotebook(cfg.ScriptPath) {
+			return output.ExitError, nil, fmt.Errorf("a notebook can't be one of several scripts")
+		}
+	}
+	if cfg.Jailed && !cfg.VMMode {
+		return output.ExitError, nil, fmt.Errorf("--jailed requires --vm")
+	}
+	if cfg.AutoRebuild && !cfg.VMMode {
+		return output.ExitError, nil, fmt.Errorf("--auto-rebuild requires --vm")
+	}
+	if (cfg.VMBackend != "" || cfg.VMEagerMB != nil || cfg.NoPool) && !cfg.VMMode {
+		return output.ExitError, nil, fmt.Errorf("--vm-backend, --vm-eager-mb and --no-pool require --vm")
+	}
+	if len(cfg.Mounts) > 0 && !cfg.VMMode {
+		return output.ExitError, nil, fmt.Errorf("--mount requires --vm")
+	}
+	if len(cfg.AllowWrite) > 0 && !cfg.VMMode {
+		return output.ExitError, nil, fmt.Errorf("--allow-write requires --vm")
+	}
+	if cfg.CollectOutputs != "" && !cfg.VMMode {
+		return output.ExitError, nil, fmt.Errorf("--collect-outputs requires --vm")
+	}
+	if cfg.Profile != "" {
+		if !cfg.VMMode {
+			return output.ExitError, nil, fmt.Errorf("--profile requires --vm")
+		}
+		if err := vm.ValidateProfile(cfg.Profile); err != nil {
+			return output.ExitError, nil, err
+		}
+	}
+	net, err := vm.ParseNetMode(cfg.Net)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+	if net != "" && !cfg.VMMode {
+		return output.ExitError, nil, fmt.Errorf("--net=%s requires --vm", net)
+	}
+	cfg.Net = net
+	if cfg.Instance != "" && cfg.Session != "" {
+		return output.ExitError, nil, fmt.Errorf("cannot use both --instance and --session")
+	}
+	if cfg.Instance != "" {
+		if !cfg.VMMode {
+			return output.ExitError, nil, fmt.Errorf("--instance requires --vm")
+		}
+		if err := vm.ValidateVMName(cfg.Instance); err != nil {
+			return output.ExitError, nil, err
+		}
+		if cfg.Profile != "" || cfg.Jailed || cfg.Net != "" {
+			return output.ExitError, nil, fmt.Errorf("--profile, --jailed and --net are set by 'dh vm up', not with --instance")
+		}
+		if cfg.CollectOutputs != "" {
+			return output.ExitError, nil, fmt.Errorf("--collect-outputs can't be used with --instance")
+		}
+	}
+	if cfg.Session != "" {
+		if !cfg.VMMode {
+			return output.ExitError, nil, fmt.Errorf("--session requires --vm")
+		}
+		if err := vm.ValidateVMName(cfg.Session); err != nil {
+			return output.ExitError, nil, fmt.Errorf("invalid session name: %w", err)
+		}
+		if cfg.CollectOutputs != "" {
+			return output.ExitError, nil, fmt.Errorf("--collect-outputs can't be used with --session")
+		}
+	}
+	switch cfg.TableFormat {
+	case "", "text":
+		if cfg.TableDir != "" {
+			return output.ExitError, nil, fmt.Errorf("--table-dir requires --table-format arrow")
+		}
+	case "arrow":
+		if !cfg.VMMode {
+			return output.ExitError, nil, fmt.Errorf("--table-format arrow requires --vm")
+		}
+	default:
+		return output.ExitError, nil, fmt.Errorf("invalid --table-format %q: must be text or arrow", cfg.TableFormat)
+	}
+	switch cfg.TableOutput {
+	case "", "pretty", "csv", "markdown", "html", "json":
+	default:
+		return output.ExitError, nil, fmt.Errorf("invalid --table-output %q: must be pretty, csv, markdown, html or json", cfg.TableOutput)
+	}
+	if cfg.MaxRows != nil && *cfg.MaxRows < 0 {
+		return output.ExitError, nil, fmt.Errorf("--max-rows must be 0 or more")
+	}
+	if cfg.MaxCols < 0 {
+		return output.ExitError, nil, fmt.Errorf("--max-cols must be 0 or more")
+	}
+	if cfg.Timeout < 0 || cfg.ConnectTimeout < 0 || cfg.ExecTimeout < 0 || cfg.KillAfter < 0 {
+		return output.ExitError, nil, fmt.Errorf("--timeout, --connect-timeout, --exec-timeout and --kill-after must be 0 or more")
+	}
+	switch cfg.MountMode {
+	case "", "preload":
+	case "fuse":
+		if !cfg.VMMode {
+			return output.ExitError, nil, fmt.Errorf("--mount-mode=fuse requires --vm")
+		}
+	default:
+		return output.ExitError, nil, fmt.Errorf("invalid --mount-mode %q: must be preload or fuse", cfg.MountMode)
+	}
+	if cfg.TableImports, err = ParseTableImports(cfg.ImportTables); err != nil {
+		return output.ExitError, nil, err
+	}
+	if cfg.TableExports, err = ParseTableExports(cfg.ExportTables); err != nil {
+		return output.ExitError, nil, err
+	}
+	if cfg.Language == "" {
+		cfg.Language = "python"
+		if strings.EqualFold(filepath.Ext(cfg.ScriptPath), ".groovy") {
+			cfg.Language = "groovy"
+		}
+	}
+	if isNotebook(cfg.ScriptPath) && cfg.Language != "python" {
+		return output.ExitError, nil, fmt.Errorf("--language %s can't be used with a notebook: dh exec runs Python notebooks", cfg.Language)
+	}
+	if len(cfg.CellTags) > 0 && !isNotebook(cfg.ScriptPath) {
+		return output.ExitError, nil, fmt.Errorf("--cell-tag requires a .ipynb script")
+	}
+	switch cfg.Language {
+	case "python":
+	case "groovy":
+		if cfg.Host == "" {
+			return output.ExitError, nil, fmt.Errorf("--language groovy requires --host or --target: the embedded server and --vm run Python")
+		}
+		if len(cfg.PythonPath) > 0 {
+			return output.ExitError, nil, fmt.Errorf("--pythonpath can't be used with --language groovy")
+		}
+		if len(cfg.ExtraScripts) > 0 {
+			return output.ExitError, nil, fmt.Errorf("--language groovy runs one script at a time")
+		}
+	default:
+		return output.ExitError, nil, fmt.Errorf("invalid --language %q: must be python or groovy", cfg.Language)
+	}
+	if len(cfg.With) > 0 {
+		if cfg.Host != "" {
+			return output.ExitError, nil, fmt.Errorf("--with can't be used with --host or --target: the code runs with the server's packages")
+		}
+		if cfg.VMMode {
+			return output.ExitError, nil, fmt.Errorf("--with can't be used with --vm")
+		}
+		if cfg.With, err = parseWith(cfg.With); err != nil {
+			return output.ExitError, nil, err
+		}
+	}
+	if cfg.Record != "" && cfg.Replay != "" {
+		return output.ExitError, nil, fmt.Errorf("cannot use both --record and --replay")
+	}
+	if cfg.Check {
+		return runCheck(cfg)
+	}
+	if cfg.Record != "" || cfg.Replay != "" {
+		return runRecorded(cfg)
+	}
+	if cfg.Annotate && !cfg.JSONMode {
+		return runAnnotated(cfg)
+	}
 
 	// Read code from source
 	userCode, err := readCode(cfg)
@@ -104,6 +378,7 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 				"stderr":      "",
 				"result_repr": nil,
 				"error":       nil,
+				"warnings":    []any{},
 				"tables":      []any{},
 			}, nil
 		}
@@ -126,11 +401,18 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 	if err != nil {
 		return output.ExitError, nil, fmt.Errorf("resolving version: %w", err)
 	}
+	cfg.timing.ResolveMs = millis(time.Since(runStart))
 
 	if cfg.Verbose {
 		fmt.Fprintf(cfg.Stderr, "Resolved version: %s (resolve=%dms)\n", version, time.Since(runStart).Milliseconds())
 	}
 
+	pythonPath, err := resolvePythonPath(cfg.PythonPath)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+	cfg.PythonPath = pythonPath
+
 	// VM mode: delegate to Firecracker-based execution
 	isRemote := cfg.Host != ""
 	if cfg.VMMode {
@@ -140,7 +422,29 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 		return runVM(cfg, userCode, version, dhHome)
 	}
 
+	// Local runs borrow the warm server of a running server daemon rather
+	// than start one, unless they need a Python path of their own
+	var daemonLease *serverdaemon.Lease
+	if !isRemote && !cfg.NoDaemon && len(cfg.PythonPath) == 0 && len(cfg.With) == 0 && serverdaemon.Probe() {
+		lease, err := serverdaemon.Acquire(version)
+		if err != nil {
+			if !cfg.Quiet {
+				fmt.Fprintf(cfg.Stderr, "Warning: %v; starting a server for this run\n", err)
+			}
+		} else {
+			defer lease.Close()
+			daemonLease = lease
+			cfg.Host, cfg.Port = "localhost", lease.Port
+			cfg.AuthType, cfg.AuthToken = lease.AuthType, lease.AuthToken
+			isRemote = true
+			if cfg.Verbose {
+				fmt.Fprintf(cfg.Stderr, "Using the server daemon's Deephaven %s on port %d\n", version, lease.Port)
+			}
+		}
+	}
+
 	// Find venv python
+	venvStart := time.Now()
 	pythonBin, err := FindVenvPython(dhHome, version)
 	if err != nil {
 		return output.ExitError, nil, fmt.Errorf("finding venv python: %w", err)
@@ -150,14 +454,37 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 		fmt.Fprintf(cfg.Stderr, "Venv python: %s\n", pythonBin)
 	}
 
+	// Serialize the setup of runs against the same version: concurrent
+	// invocations would otherwise race on the pydeephaven install and, in
+	// embedded mode, on the free port they pick. Remote runs hold the lock
+	// while the venv is checked, embedded ones until their server has its
+	// port; the servers themselves run side by side.
+	unlock, err := lockVersion(dhHome, version, cfg.Quiet, cfg.Stderr)
+	if err != nil {
+		return output.ExitError, nil, fmt.Errorf("locking version: %w", err)
+	}
+	defer unlock()
+
 	// Ensure pydeephaven is installed
 	if err := EnsurePydeephaven(pythonBin, version, cfg.Quiet, cfg.Stderr); err != nil {
 		return output.ExitError, nil, fmt.Errorf("ensuring pydeephaven: %w", err)
 	}
+	if len(cfg.With) > 0 {
+		dir, err := EnsureWith(dhHome, version, pythonBin, cfg.With, cfg.Quiet, cfg.Stderr)
+		if err != nil {
+			return output.ExitError, nil, err
+		}
+		cfg.PythonPath = append(cfg.PythonPath, dir)
+	}
+	cfg.timing.VenvMs = millis(time.Since(venvStart))
+	if isRemote {
+		unlock()
+	}
 
 	// Detect Java for embedded mode
 	var javaHome string
 	if !isRemote {
+		javaStart := time.Now()
 		javaInfo, err := java.Detect(dhHome)
 		if err != nil {
 			return output.ExitError, nil, fmt.Errorf("detecting Java: %w", err)
@@ -169,10 +496,26 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 		if cfg.Verbose {
 			fmt.Fprintf(cfg.Stderr, "Using Java: %s (version %s)\n", javaInfo.Path, javaInfo.Version)
 		}
+		if err := java.ValidateFlags(dhHome, javaInfo.Path, java.SplitFlags(cfg.JVMArgs)); err != nil {
+			return output.ExitError, nil, err
+		}
+
+		// Pick the port here rather than letting the server fail to bind
+		port, err := ResolvePort(cfg.Port)
+		if err != nil {
+			return output.ExitError, nil, err
+		}
+		ReportPort(cfg.Port, port, cfg.Verbose, cfg.Quiet, cfg.Stderr)
+		cfg.Port = port
+		cfg.timing.JavaMs = millis(time.Since(javaStart))
 	}
 
 	// Build runner args
 	runnerArgs := buildRunnerArgs(cfg, isRemote)
+	if daemonLease != nil {
+		// The daemon's server has run other code; this run starts clean
+		runnerArgs = append(runnerArgs, "--reset-scope")
+	}
 
 	// Resolve script path and CWD for the runner
 	callerCwd, _ := os.Getwd()
@@ -184,45 +527,73 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 	}
 	runnerArgs = append(runnerArgs, "--cwd", callerCwd)
 
-	// Set up context with optional timeout
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if cfg.Timeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
-		defer cancel()
-	} else {
-		ctx, cancel = context.WithCancel(ctx)
-		defer cancel()
+	// The runner creates the ready file once it has connected, which
+	// ends the connect phase of the timeouts and, for an embedded server,
+	// means it has bound its port and the version lock can go
+	var readyFile string
+	if !isRemote || cfg.ConnectTimeout > 0 || cfg.ExecTimeout > 0 {
+		readyFile = filepath.Join(os.TempDir(), fmt.Sprintf("dh-ready-%d-%d", os.Getpid(), time.Now().UnixNano()))
+		runnerArgs = append(runnerArgs, "--ready-file", readyFile)
+		defer os.Remove(readyFile)
+	}
+	if !isRemote {
+		done := make(chan struct{})
+		defer close(done)
+		unlockWhenExists(readyFile, unlock, done)
+	}
+	connecting := "starting the server"
+	if isRemote {
+		connecting = "connecting to " + cfg.Host
 	}
 
 	// Build command: python -c "<runner script>" <args...>
 	cmdArgs := append([]string{"-c", runnerScript}, runnerArgs...)
-	cmd := exec.CommandContext(ctx, pythonBin, cmdArgs...)
+	cmd := exec.Command(pythonBin, cmdArgs...)
 
 	// Set JAVA_HOME for embedded mode
 	cmd.Env = os.Environ()
 	if !isRemote && javaHome != "" {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("JAVA_HOME=%s", javaHome))
 	}
+	cmd.Env = append(cmd.Env, GRPCTraceEnv()...)
+	if len(cfg.PythonPath) > 0 {
+		cmd.Env = append(cmd.Env, "PYTHONPATH="+pythonPathEnv(cfg.PythonPath))
+		if cfg.Verbose {
+			fmt.Fprintf(cfg.Stderr, "PYTHONPATH: %s\n", pythonPathEnv(cfg.PythonPath))
+		}
+	}
 
 	// Process group for clean cleanup
 	cmd.SysProcAttr = processGroupAttr()
 
 	// Pipe user code to stdin
 	cmd.Stdin = strings.NewReader(userCode)
+	output.TraceCommand(cmd)
 
 	start := time.Now()
 
 	if cfg.JSONMode {
-		// JSON mode: capture stdout, forward stderr
+		// JSON mode: capture stdout, forward stderr. For --json-stream,
+		// the embedded server's runner also sends the script's output as
+		// it is written, in frames ahead of the result.
 		var stdoutBuf bytes.Buffer
 		cmd.Stdout = &stdoutBuf
 		cmd.Stderr = cfg.Stderr
+		var frames *frameWriter
+		if cfg.events != nil && !isRemote {
+			frames = &frameWriter{events: cfg.events, rest: &stdoutBuf}
+			cmd.Stdout = frames
+		}
 
 		err := cmd.Start()
 		if err != nil {
 			return output.ExitError, nil, fmt.Errorf("starting runner: %w", err)
 		}
+		if !isRemote {
+			defer register(dhHome, cfg, version, cmd.Process.Pid, callerCwd)()
+		}
+		timer := watchRunner(cfg, cmd.Process.Pid, connecting, readyFile)
+		defer timer.stop()
 
 		// Forward SIGINT to child process group
 		sigCh := make(chan os.Signal, 1)
@@ -237,22 +608,26 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 		defer func() { signal.Stop(sigCh); close(sigCh) }()
 
 		waitErr := cmd.Wait()
+		timer.stop()
 		elapsed := time.Since(start).Seconds()
+		if frames != nil {
+			frames.flush()
+		}
 
 		// Check for timeout
-		if ctx.Err() == context.DeadlineExceeded {
-			if cmd.Process != nil {
-				killProcessGroup(cmd.Process.Pid)
-			}
+		if msg := timer.expired(); msg != "" {
+			killProcessGroup(cmd.Process.Pid)
 			jsonResult := map[string]any{
 				"exit_code":       output.ExitTimeout,
 				"stdout":          "",
 				"stderr":          "",
 				"result_repr":     nil,
-				"error":           fmt.Sprintf("Execution timed out after %d seconds", cfg.Timeout),
+				"error":           msg,
+				"warnings":        []any{},
 				"tables":          []any{},
 				"version":         version,
 				"java_home":       javaHome,
+				"port":            cfg.Port,
 				"elapsed_seconds": elapsed,
 			}
 			return output.ExitTimeout, jsonResult, nil
@@ -271,14 +646,30 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 				"stderr":      "",
 				"result_repr": nil,
 				"error":       nil,
+				"warnings":    []any{},
 				"tables":      []any{},
 			}
 		}
 
+		// The runner's own timing: when it connected, counted here from
+		// its start, and how long the code and tables took
+		if rt, ok := runnerResult["_timing"].(map[string]any); ok {
+			if at, ok := rt["connected_at"].(float64); ok {
+				cfg.timing.ServerStartMs = millis(time.Unix(0, int64(at*1e9)).Sub(start))
+			}
+			cfg.timing.ScriptMs = runnerMillis(rt["script_ms"])
+			cfg.timing.TablesMs = runnerMillis(rt["tables_ms"])
+			delete(runnerResult, "_timing")
+		}
+
 		// Augment with Go-side info
 		runnerResult["version"] = version
 		runnerResult["java_home"] = javaHome
+		runnerResult["port"] = cfg.Port
 		runnerResult["elapsed_seconds"] = elapsed
+		if daemonLease != nil {
+			runnerResult["server_daemon"] = true
+		}
 
 		return exitCode, runnerResult, nil
 	}
@@ -291,6 +682,11 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 	if err != nil {
 		return output.ExitError, nil, fmt.Errorf("starting runner: %w", err)
 	}
+	if !isRemote {
+		defer register(dhHome, cfg, version, cmd.Process.Pid, callerCwd)()
+	}
+	timer := watchRunner(cfg, cmd.Process.Pid, connecting, readyFile)
+	defer timer.stop()
 
 	// Forward SIGINT to child process group
 	sigCh := make(chan os.Signal, 1)
@@ -305,24 +701,57 @@ func Run(cfg *ExecConfig) (int, map[string]any, error) {
 	defer func() { signal.Stop(sigCh); close(sigCh) }()
 
 	waitErr := cmd.Wait()
+	timer.stop()
 
 	// Check for timeout
-	if ctx.Err() == context.DeadlineExceeded {
-		if cmd.Process != nil {
-			killProcessGroup(cmd.Process.Pid)
-		}
-		fmt.Fprintf(cfg.Stderr, "Error: Execution timed out after %d seconds\n", cfg.Timeout)
+	if msg := timer.expired(); msg != "" {
+		killProcessGroup(cmd.Process.Pid)
+		fmt.Fprintf(cfg.Stderr, "Error: %s\n", msg)
 		return output.ExitTimeout, nil, nil
 	}
 
 	return exitCodeFromErr(waitErr), nil, nil
 }
 
-// readCode reads user code from -c flag, file, or stdin.
+// register records the embedded server started by the runner with
+// discovery, so dh list and dh kill find it. It returns the function that
+// removes the record; failing to register is not an error.
+func register(dhHome string, cfg *ExecConfig, version string, pid int, cwd string) func() {
+	script := cfg.ScriptPath
+	if script == "" || script == "-" {
+		script = "-c"
+	}
+	unregister, err := discovery.Register(dhHome, discovery.Registration{Server: discovery.Server{
+		Port:    cfg.Port,
+		PID:     pid,
+		Source:  "dh exec",
+		Script:  script,
+		CWD:     cwd,
+		Version: version,
+	}})
+	if err != nil {
+		output.Tracef("registering server: %v", err)
+		return func() {}
+	}
+	return unregister
+}
+
+// readCode reads user code from -c flag, file, or stdin. For a notebook
+// or several scripts it returns them as one script and sets cfg.parts.
 func readCode(cfg *ExecConfig) (string, error) {
 	if cfg.Code != "" {
 		return cfg.Code, nil
 	}
+	if isNotebook(cfg.ScriptPath) {
+		code, cells, err := readNotebook(cfg.ScriptPath, cfg.CellTags)
+		cfg.parts = cells
+		return code, err
+	}
+	if len(cfg.ExtraScripts) > 0 {
+		code, scripts, err := readScripts(append([]string{cfg.ScriptPath}, cfg.ExtraScripts...))
+		cfg.parts = scripts
+		return code, err
+	}
 	if cfg.ScriptPath == "-" {
 		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
@@ -365,6 +794,49 @@ func buildRunnerArgs(cfg *ExecConfig, isRemote bool) []string {
 		args = append(args, "--output-json")
 	}
 
+	if cfg.FailOnWarning {
+		args = append(args, "--fail-on-warning")
+	}
+
+	if cfg.HashTables {
+		args = append(args, "--hash-tables")
+	}
+
+	if cfg.TableOutput != "" && cfg.TableOutput != "pretty" {
+		args = append(args, "--table-output", cfg.TableOutput)
+	}
+
+	if cfg.MaxRows != nil {
+		args = append(args, "--max-rows", fmt.Sprintf("%d", *cfg.MaxRows))
+	}
+
+	if cfg.MaxCols > 0 {
+		args = append(args, "--max-cols", fmt.Sprintf("%d", cfg.MaxCols))
+	}
+
+	if len(cfg.TableImports) > 0 {
+		imports, _ := json.Marshal(cfg.TableImports)
+		args = append(args, "--import-tables", string(imports))
+	}
+
+	if cfg.events != nil && !isRemote {
+		args = append(args, "--stream-output")
+	}
+
+	if cfg.Language == "groovy" {
+		args = append(args, "--language", cfg.Language)
+	}
+
+	if len(cfg.parts) > 0 {
+		parts, _ := json.Marshal(cfg.parts)
+		args = append(args, "--parts", string(parts))
+	}
+
+	if len(cfg.TableExports) > 0 {
+		exports, _ := json.Marshal(cfg.TableExports)
+		args = append(args, "--export-tables", string(exports))
+	}
+
 	// Remote auth options
 	if isRemote {
 		if cfg.AuthType != "" {
@@ -420,12 +892,22 @@ func EnsurePydeephaven(pythonBin, version string, quiet bool, stderr io.Writer)
 	}
 	installCmd := ExecCommand("uv", "pip", "install", "--python", pythonBin, fmt.Sprintf("pydeephaven==%s", version))
 	installCmd.Stderr = stderr
+	output.TraceCommand(installCmd)
 	if err := installCmd.Run(); err != nil {
 		return fmt.Errorf("installing pydeephaven: %w", err)
 	}
 	return nil
 }
 
+// GRPCTraceEnv returns environment variables that turn on gRPC client
+// debug logging in the runner when -vv is given, or nil.
+func GRPCTraceEnv() []string {
+	if !output.IsTrace() {
+		return nil
+	}
+	return []string{"GRPC_VERBOSITY=DEBUG", "GRPC_TRACE=connectivity_state,call_error,http"}
+}
+
 // latestSnapshotVersion scans the VM snapshots directory and returns the
 // latest version that has a complete snapshot. This allows --vm mode to
 // work without an explicit version when a snapshot has been prepared.
@@ -1,8 +1,23 @@
 package exec
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
 )
 
 func TestRun_VMAndHostMutuallyExclusive(t *testing.T) {
@@ -32,3 +47,1148 @@ func TestExecConfig_VMModeFlagExists(t *testing.T) {
 		t.Error("VMMode should default to false")
 	}
 }
+
+func TestRun_MountRequiresVM(t *testing.T) {
+	cfg := &ExecConfig{
+		Code:   "print('hello')",
+		Mounts: []string{"."},
+	}
+
+	_, _, err := Run(cfg)
+	if err == nil || !strings.Contains(err.Error(), "--mount requires --vm") {
+		t.Errorf("expected --mount requires --vm error, got %v", err)
+	}
+}
+
+func TestRun_AutoRebuildRequiresVM(t *testing.T) {
+	cfg := &ExecConfig{
+		Code:        "print('hello')",
+		AutoRebuild: true,
+	}
+
+	_, _, err := Run(cfg)
+	if err == nil || !strings.Contains(err.Error(), "--auto-rebuild requires --vm") {
+		t.Errorf("expected --auto-rebuild requires --vm error, got %v", err)
+	}
+}
+
+func TestRun_VMTuningRequiresVM(t *testing.T) {
+	eagerMB := 64
+	for _, cfg := range []*ExecConfig{
+		{Code: "print('hello')", VMBackend: "file"},
+		{Code: "print('hello')", VMEagerMB: &eagerMB},
+		{Code: "print('hello')", NoPool: true},
+	} {
+		_, _, err := Run(cfg)
+		if err == nil || !strings.Contains(err.Error(), "require --vm") {
+			t.Errorf("expected a requires --vm error, got %v", err)
+		}
+	}
+}
+
+func TestRun_NetRequiresVM(t *testing.T) {
+	_, _, err := Run(&ExecConfig{Code: "print('hello')", Net: "nat"})
+	if err == nil || !strings.Contains(err.Error(), "--net=nat requires --vm") {
+		t.Errorf("expected a requires --vm error, got %v", err)
+	}
+
+	_, _, err = Run(&ExecConfig{Code: "print('hello')", VMMode: true, Net: "host"})
+	if err == nil || !strings.Contains(err.Error(), "invalid --net") {
+		t.Errorf("expected an invalid --net error, got %v", err)
+	}
+}
+
+func TestRun_AllowWriteRequiresVM(t *testing.T) {
+	cfg := &ExecConfig{
+		Code:       "print('hello')",
+		AllowWrite: []string{"."},
+	}
+
+	_, _, err := Run(cfg)
+	if err == nil || !strings.Contains(err.Error(), "--allow-write requires --vm") {
+		t.Errorf("expected --allow-write requires --vm error, got %v", err)
+	}
+}
+
+func TestRun_CollectOutputsRequiresVM(t *testing.T) {
+	cfg := &ExecConfig{
+		Code:           "print('hello')",
+		CollectOutputs: "out",
+	}
+
+	_, _, err := Run(cfg)
+	if err == nil || !strings.Contains(err.Error(), "--collect-outputs requires --vm") {
+		t.Errorf("expected --collect-outputs requires --vm error, got %v", err)
+	}
+}
+
+func TestRun_TableFormat(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "t = 1", TableFormat: "arrow"}, "--table-format arrow requires --vm"},
+		{ExecConfig{Code: "t = 1", VMMode: true, TableFormat: "csv"}, "invalid --table-format"},
+		{ExecConfig{Code: "t = 1", VMMode: true, TableDir: "data"}, "--table-dir requires --table-format arrow"},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Run(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestRun_TableOutput(t *testing.T) {
+	negative := -1
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{TableOutput: "yaml"}, `invalid --table-output "yaml"`},
+		{ExecConfig{MaxRows: &negative}, "--max-rows must be 0 or more"},
+		{ExecConfig{MaxCols: -1}, "--max-cols must be 0 or more"},
+	} {
+		tc.cfg.Code = "t = 1"
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Run(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestBuildRunnerArgs_TableOutput(t *testing.T) {
+	if args := strings.Join(buildRunnerArgs(&ExecConfig{}, false), " "); strings.Contains(args, "--table-output") || strings.Contains(args, "--max-") {
+		t.Errorf("default args = %s", args)
+	}
+	all := 0
+	args := strings.Join(buildRunnerArgs(&ExecConfig{TableOutput: "markdown", MaxRows: &all, MaxCols: 3}, false), " ")
+	for _, want := range []string{"--table-output markdown", "--max-rows 0", "--max-cols 3"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args = %s, want %s", args, want)
+		}
+	}
+}
+
+func TestRun_Language(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "x = 1", Language: "groovy"}, "--language groovy requires --host"},
+		{ExecConfig{Code: "x = 1", Language: "groovy", VMMode: true}, "--language groovy requires --host"},
+		{ExecConfig{ScriptPath: "job.groovy"}, "--language groovy requires --host"},
+		{ExecConfig{Code: "x = 1", Language: "groovy", Host: "dh.example.com", PythonPath: []string{"lib"}}, "--pythonpath can't be used"},
+		{ExecConfig{Code: "x = 1", Language: "scala"}, `invalid --language "scala"`},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Run(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+
+	args := strings.Join(buildRunnerArgs(&ExecConfig{Host: "dh.example.com", Language: "groovy"}, true), " ")
+	if !strings.Contains(args, "--language groovy") {
+		t.Errorf("args = %s", args)
+	}
+	if args := strings.Join(buildRunnerArgs(&ExecConfig{Language: "python"}, false), " "); strings.Contains(args, "--language") {
+		t.Errorf("python args = %s", args)
+	}
+}
+
+func TestReadNotebook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analysis.ipynb")
+	nb := `{
+  "metadata": {"kernelspec": {"language": "python"}},
+  "cells": [
+    {"cell_type": "markdown", "source": ["# Title"]},
+    {"cell_type": "code", "metadata": {"tags": ["setup"]}, "source": ["%matplotlib inline\n", "x = 1"]},
+    {"cell_type": "code", "metadata": {}, "source": "   "},
+    {"cell_type": "code", "metadata": {"tags": ["report"]}, "source": "!pip list\nprint(x)\n"},
+    {"cell_type": "code", "metadata": {}, "source": "%%bash\necho hi\n"}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(nb), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, cells, err := readNotebook(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# %matplotlib inline\nx = 1\n# !pip list\nprint(x)\n# %%bash\n# echo hi\n"
+	if code != want {
+		t.Errorf("code = %q, want %q", code, want)
+	}
+	wantCells := []codePart{{Index: 2, Line: 1, Lines: 2}, {Index: 4, Line: 3, Lines: 2}, {Index: 5, Line: 5, Lines: 2}}
+	if !slices.Equal(cells, wantCells) {
+		t.Errorf("cells = %+v, want %+v", cells, wantCells)
+	}
+
+	code, cells, err = readNotebook(path, []string{"report"})
+	if err != nil || code != "# !pip list\nprint(x)\n" || len(cells) != 1 || cells[0].Index != 4 {
+		t.Errorf("tagged: code = %q, cells = %+v, err = %v", code, cells, err)
+	}
+	if _, _, err := readNotebook(path, []string{"missing"}); err == nil || !strings.Contains(err.Error(), "no code cells") {
+		t.Errorf("missing tag: err = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"metadata": {"language_info": {"name": "R"}}, "cells": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readNotebook(path, nil); err == nil || !strings.Contains(err.Error(), "is a R notebook") {
+		t.Errorf("R notebook: err = %v", err)
+	}
+}
+
+func TestRun_Notebook(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "x = 1", CellTags: []string{"setup"}}, "--cell-tag requires a .ipynb script"},
+		{ExecConfig{ScriptPath: "analysis.ipynb", Language: "groovy", Host: "dh.example.com"}, "can't be used with a notebook"},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Run(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+
+	cfg := &ExecConfig{parts: []codePart{{Index: 2, Line: 1, Lines: 2}}}
+	args := strings.Join(buildRunnerArgs(cfg, false), " ")
+	if !strings.Contains(args, `--parts [{"index":2,"line":1,"lines":2}]`) {
+		t.Errorf("args = %s", args)
+	}
+}
+
+func TestReadScripts(t *testing.T) {
+	dir := t.TempDir()
+	setup, report := filepath.Join(dir, "setup.py"), filepath.Join(dir, "report.py")
+	if err := os.WriteFile(setup, []byte("x = 1\ny = 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(report, []byte("print(x + y)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, parts, err := readScripts([]string{setup, report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "x = 1\ny = 2\nprint(x + y)\n" {
+		t.Errorf("code = %q", code)
+	}
+	want := []codePart{{Index: 1, Path: setup, Line: 1, Lines: 2}, {Index: 2, Path: report, Line: 3, Lines: 1}}
+	if !slices.Equal(parts, want) {
+		t.Errorf("parts = %+v, want %+v", parts, want)
+	}
+
+	if _, _, err := readScripts([]string{setup, filepath.Join(dir, "missing.py")}); err == nil || !strings.Contains(err.Error(), "missing.py") {
+		t.Errorf("missing script: err = %v", err)
+	}
+}
+
+func TestRun_SeveralScripts(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{ScriptPath: "a.py", ExtraScripts: []string{"-"}}, "stdin (-) can't be one of several scripts"},
+		{ExecConfig{ScriptPath: "analysis.ipynb", ExtraScripts: []string{"b.py"}}, "a notebook can't be one of several scripts"},
+		{ExecConfig{ScriptPath: "a.groovy", ExtraScripts: []string{"b.groovy"}, Host: "dh.example.com"}, "runs one script at a time"},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Run(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	got, err := parseWith([]string{" requests>=2", "polars", "polars"})
+	if err != nil || !slices.Equal(got, []string{"polars", "requests>=2"}) {
+		t.Errorf("parseWith = %v, %v", got, err)
+	}
+	for _, bad := range []string{"", "--index-url=http://x"} {
+		if _, err := parseWith([]string{bad}); err == nil {
+			t.Errorf("parseWith(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestEnsureWith_Cached(t *testing.T) {
+	dhHome := t.TempDir()
+	specs := []string{"polars"}
+	dir := withDir(dhHome, "0.36.0", specs)
+	if dir == withDir(dhHome, "0.36.0", []string{"polars", "requests"}) || dir == withDir(dhHome, "0.37.0", specs) {
+		t.Errorf("overlays share %s", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// An existing overlay is used as it is, without running uv
+	got, err := EnsureWith(dhHome, "0.36.0", "/no/python", specs, true, io.Discard)
+	if err != nil || got != dir {
+		t.Errorf("EnsureWith = %q, %v; want %q", got, err, dir)
+	}
+}
+
+func TestRun_With(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "x = 1", With: []string{"polars"}, Host: "dh.example.com"}, "--with can't be used with --host"},
+		{ExecConfig{Code: "x = 1", With: []string{"polars"}, VMMode: true}, "--with can't be used with --vm"},
+		{ExecConfig{Code: "x = 1", With: []string{"-e ."}}, `invalid --with "-e ."`},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Run(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestRun_Profile(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "print('hello')", Profile: "myproj"}, "--profile requires --vm"},
+		{ExecConfig{Code: "print('hello')", VMMode: true, Profile: "../x"}, "invalid profile"},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Profile %q: expected %q error, got %v", tc.cfg.Profile, tc.want, err)
+		}
+	}
+}
+
+func TestRun_NamedVM(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "print('hello')", Instance: "dev"}, "--instance requires --vm"},
+		{ExecConfig{Code: "print('hello')", Session: "work"}, "--session requires --vm"},
+		{ExecConfig{Code: "print('hello')", VMMode: true, Instance: "dev", Session: "work"}, "both --instance and --session"},
+		{ExecConfig{Code: "print('hello')", VMMode: true, Instance: "Dev"}, "invalid VM name"},
+		{ExecConfig{Code: "print('hello')", VMMode: true, Instance: "dev", Profile: "myproj"}, "set by 'dh vm up'"},
+		{ExecConfig{Code: "print('hello')", VMMode: true, Session: "work", CollectOutputs: "out"}, "--collect-outputs can't be used with --session"},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("instance %q, session %q: expected %q error, got %v", tc.cfg.Instance, tc.cfg.Session, tc.want, err)
+		}
+	}
+}
+
+func TestRun_MountMode(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{MountMode: "fuse"}, "--mount-mode=fuse requires --vm"},
+		{ExecConfig{MountMode: "virtiofs", VMMode: true}, `invalid --mount-mode "virtiofs"`},
+	} {
+		tc.cfg.Code = "print('hello')"
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("MountMode %q: expected %q error, got %v", tc.cfg.MountMode, tc.want, err)
+		}
+	}
+}
+
+func TestLockVersion_SerializesConcurrentRuns(t *testing.T) {
+	dhHome := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dhHome, "versions", "0.36.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockVersion(dhHome, "0.36.0", false, nil)
+	if err != nil {
+		t.Fatalf("first lock: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	acquired := make(chan func(), 1)
+	go func() {
+		second, err := lockVersion(dhHome, "0.36.0", false, &stderr)
+		if err != nil {
+			t.Errorf("second lock: %v", err)
+			return
+		}
+		acquired <- second
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second lock acquired while first was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlock()
+	unlock() // idempotent
+
+	select {
+	case second := <-acquired:
+		second()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lock not acquired after first was released")
+	}
+	if !strings.Contains(stderr.String(), "Waiting for another dh exec") {
+		t.Errorf("expected wait message, got %q", stderr.String())
+	}
+}
+
+func TestUnlockWhenExists(t *testing.T) {
+	dhHome := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dhHome, "versions", "0.36.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockVersion(dhHome, "0.36.0", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	ready := filepath.Join(t.TempDir(), "ready")
+	done := make(chan struct{})
+	defer close(done)
+	unlockWhenExists(ready, unlock, done)
+
+	acquired := make(chan func(), 1)
+	go func() {
+		second, err := lockVersion(dhHome, "0.36.0", true, nil)
+		if err != nil {
+			t.Errorf("second lock: %v", err)
+			return
+		}
+		acquired <- second
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second lock acquired before the server was ready")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	os.WriteFile(ready, nil, 0o644)
+	select {
+	case second := <-acquired:
+		second()
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not released once the server was ready")
+	}
+}
+
+func TestResolvePythonPath(t *testing.T) {
+	t.Setenv("DH_HOME", t.TempDir())
+	libDir := t.TempDir()
+
+	got, err := resolvePythonPath([]string{libDir, libDir})
+	if err != nil {
+		t.Fatalf("resolvePythonPath: %v", err)
+	}
+	if len(got) != 1 || got[0] != libDir {
+		t.Errorf("resolvePythonPath = %v, want [%s]", got, libDir)
+	}
+
+	if _, err := resolvePythonPath([]string{filepath.Join(libDir, "missing")}); err == nil {
+		t.Error("expected error for missing dir, got nil")
+	}
+}
+
+func TestGuestPythonPath(t *testing.T) {
+	cwd := "/home/user/project"
+	mounts := []vm.Mount{{HostPath: "/srv/shared", Alias: "shared", ReadOnly: true}}
+	dirs := []string{cwd, cwd + "/lib", "/srv/shared/helpers", "/opt/pkgs"}
+
+	guest, extra := guestPythonPath(dirs, cwd, mounts)
+	want := []string{"/workspace", "/workspace/lib", "/workspace/shared/helpers", "/workspace/.pythonpath-0"}
+	if strings.Join(guest, ",") != strings.Join(want, ",") {
+		t.Errorf("guest paths = %v, want %v", guest, want)
+	}
+	if len(extra) != 1 || extra[0].HostPath != "/opt/pkgs" || extra[0].Alias != ".pythonpath-0" {
+		t.Errorf("extra mounts = %+v, want one mount of /opt/pkgs", extra)
+	}
+}
+
+func TestParseTableImports(t *testing.T) {
+	dir := t.TempDir()
+	csv := filepath.Join(dir, "trades.csv")
+	pq := filepath.Join(dir, "quotes.PARQUET")
+	os.WriteFile(csv, []byte("Sym,Price\nA,1\n"), 0o644)
+	os.WriteFile(pq, nil, 0o644)
+
+	got, err := ParseTableImports([]string{"trades=" + csv, "quotes=" + pq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vm.TableImport{{Name: "trades", Path: csv, Format: "csv"}, {Name: "quotes", Path: pq, Format: "parquet"}}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("ParseTableImports = %+v, want %+v", got, want)
+	}
+
+	for _, spec := range []string{
+		csv,                                   // no name
+		"1st=" + csv,                          // not an identifier
+		"t=" + filepath.Join(dir, "t.json"),   // unknown format
+		"t=" + filepath.Join(dir, "gone.csv"), // missing
+	} {
+		if _, err := ParseTableImports([]string{spec}); err == nil {
+			t.Errorf("ParseTableImports(%q) succeeded", spec)
+		}
+	}
+	if _, err := ParseTableImports([]string{"t=" + csv, "t=" + pq}); err == nil {
+		t.Error("a name given twice was accepted")
+	}
+}
+
+func TestGuestTableImports(t *testing.T) {
+	cwd := "/home/user/project"
+	imports := []vm.TableImport{
+		{Name: "a", Path: cwd + "/data/a.csv", Format: "csv"},
+		{Name: "b", Path: "/srv/data/b.parquet", Format: "parquet"},
+		{Name: "c", Path: "/srv/data/c.csv", Format: "csv"},
+	}
+	guest, extra := guestTableImports(imports, cwd, nil)
+	want := []string{"/workspace/data/a.csv", "/workspace/.import-0/b.parquet", "/workspace/.import-0/c.csv"}
+	for i, imp := range guest {
+		if imp.Path != want[i] || imp.Name != imports[i].Name {
+			t.Errorf("guest import %d = %+v, want path %s", i, imp, want[i])
+		}
+	}
+	if len(extra) != 1 || extra[0].HostPath != "/srv/data" || !extra[0].ReadOnly {
+		t.Errorf("extra mounts = %+v, want one read-only mount of /srv/data", extra)
+	}
+}
+
+func TestParseTableExports(t *testing.T) {
+	dir := t.TempDir()
+	got, err := ParseTableExports([]string{
+		"a=" + filepath.Join(dir, "out", "a.parquet"),
+		"b=" + filepath.Join(dir, "b.CSV"),
+		"c=" + filepath.Join(dir, "c.arrow"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []vm.TableExport{
+		{Name: "a", Path: filepath.Join(dir, "out", "a.parquet"), Format: "parquet"},
+		{Name: "b", Path: filepath.Join(dir, "b.CSV"), Format: "csv"},
+		{Name: "c", Path: filepath.Join(dir, "c.arrow"), Format: "arrow"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseTableExports = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("export %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if v := vsockTableExports(got); v[0].Path != "" || v[0].Name != "a" || got[0].Path == "" {
+		t.Errorf("vsockTableExports = %+v", v)
+	}
+
+	os.Mkdir(filepath.Join(dir, "d.csv"), 0o755)
+	for _, spec := range []string{
+		"a.csv",                              // no name
+		"a-b=" + filepath.Join(dir, "x.csv"), // not an identifier
+		"a=" + filepath.Join(dir, "a.json"),  // unknown format
+		"d=" + filepath.Join(dir, "d.csv"),   // a directory
+	} {
+		if _, err := ParseTableExports([]string{spec}); err == nil {
+			t.Errorf("ParseTableExports(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestSaveExportedTables(t *testing.T) {
+	dir := t.TempDir()
+	exports := []vm.TableExport{{Name: "t", Path: filepath.Join(dir, "out", "t.csv"), Format: "csv"}}
+	saved, err := saveExportedTables(exports, []vm.ExportedTable{{Name: "t", Size: 4, Data: []byte("A\n1\n")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 1 || saved[0]["path"] != exports[0].Path {
+		t.Errorf("saved = %v", saved)
+	}
+	if got, err := os.ReadFile(exports[0].Path); err != nil || string(got) != "A\n1\n" {
+		t.Errorf("t.csv = %q, %v", got, err)
+	}
+	if _, err := saveExportedTables(exports, []vm.ExportedTable{{Name: "other"}}); err == nil {
+		t.Error("a table that was not asked for was saved")
+	}
+}
+
+func TestWarningExitCode(t *testing.T) {
+	warnings := []any{map[string]any{"category": "DeprecationWarning", "message": "old api"}}
+
+	if got := warningExitCode(&ExecConfig{}, 0, warnings); got != 0 {
+		t.Errorf("without --fail-on-warning: exit = %d, want 0", got)
+	}
+	if got := warningExitCode(&ExecConfig{FailOnWarning: true}, 0, warnings); got != 1 {
+		t.Errorf("with --fail-on-warning: exit = %d, want 1", got)
+	}
+	if got := warningExitCode(&ExecConfig{FailOnWarning: true}, 0, nil); got != 0 {
+		t.Errorf("with --fail-on-warning and no warnings: exit = %d, want 0", got)
+	}
+	if got := warningExitCode(&ExecConfig{FailOnWarning: true}, 3, warnings); got != 3 {
+		t.Errorf("existing failure should be preserved: exit = %d, want 3", got)
+	}
+}
+
+func TestPrintWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	printWarnings(&buf, []any{map[string]any{"category": "UserWarning", "message": "check input"}})
+	if got := buf.String(); got != "Warning: UserWarning: check input\n" {
+		t.Errorf("printWarnings = %q", got)
+	}
+}
+
+func TestRecordingPath(t *testing.T) {
+	if got := RecordingPath("golden", "scripts/report.py"); got != filepath.Join("golden", "report.json") {
+		t.Errorf("script recording path = %q", got)
+	}
+	if got := RecordingPath("golden", "-"); got != filepath.Join("golden", "exec.json") {
+		t.Errorf("stdin recording path = %q", got)
+	}
+}
+
+func TestDiffRecordings(t *testing.T) {
+	result := map[string]any{
+		"exit_code": float64(0),
+		"stdout":    "total: 5\n",
+		"tables": []any{map[string]any{
+			"name":         "t",
+			"row_count":    float64(5),
+			"columns":      []any{map[string]any{"name": "X", "type": "int32"}},
+			"content_hash": "abc",
+		}},
+	}
+	golden := RecordingFromResult(result)
+	if drift := diffRecordings(golden, RecordingFromResult(result)); len(drift) != 0 {
+		t.Fatalf("identical runs drifted: %v", drift)
+	}
+
+	actual := RecordingFromResult(result)
+	actual.Stdout = "total: 6\n"
+	actual.Tables[0].ContentHash = "def"
+	actual.Tables = append(actual.Tables, RecordedTable{Name: "u"})
+	drift := diffRecordings(golden, actual)
+	want := []string{
+		`stdout line 1: recorded "total: 5", got "total: 6"`,
+		"table t: content changed",
+		"table u: not in recording",
+	}
+	if strings.Join(drift, "\n") != strings.Join(want, "\n") {
+		t.Errorf("drift = %q, want %q", drift, want)
+	}
+
+	// A table whose content can't be hashed is kept and compared by its
+	// schema and rows.
+	unhashable := RecordingFromResult(result)
+	unhashable.Tables[0].ContentHash, unhashable.Tables[0].HashError = "", "ArrowNotImplementedError: map"
+	if drift := diffRecordings(unhashable, unhashable); len(drift) != 0 {
+		t.Errorf("unhashable table drifted: %v", drift)
+	}
+	if drift := diffRecordings(golden, unhashable); len(drift) != 1 || !strings.Contains(drift[0], "can't be hashed: ArrowNotImplementedError") {
+		t.Errorf("drift of a table that can no longer be hashed = %q", drift)
+	}
+
+	// Refreshing tables only have their schema compared.
+	golden.Tables[0].IsRefreshing = true
+	actual = RecordingFromResult(result)
+	actual.Tables[0].RowCount = 9
+	if drift := diffRecordings(golden, actual); len(drift) != 0 {
+		t.Errorf("refreshing table drifted: %v", drift)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "report.py")
+	os.WriteFile(script, []byte("print(1)\n"), 0o644)
+	os.MkdirAll(filepath.Join(dir, "data"), 0o755)
+
+	w, err := NewWatcher(script, []string{filepath.Join(dir, "data", "*.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	next := func() []string {
+		t.Helper()
+		select {
+		case changed := <-w.Changes():
+			return changed
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change reported")
+			return nil
+		}
+	}
+
+	// Files that match nothing are ignored; a script replaced by a rename
+	// and a new data file are one batch.
+	os.WriteFile(filepath.Join(dir, "data", "notes.txt"), []byte("x"), 0o644)
+	os.WriteFile(script+".tmp", []byte("print(2)\n"), 0o644)
+	os.Rename(script+".tmp", script)
+	os.WriteFile(filepath.Join(dir, "data", "prices.csv"), []byte("a,b\n"), 0o644)
+	changed := next()
+	if len(changed) != 2 || !strings.HasSuffix(changed[0], "prices.csv") || !strings.HasSuffix(changed[1], "report.py") {
+		t.Errorf("changed = %v, want prices.csv and report.py", changed)
+	}
+
+	os.WriteFile(script, []byte("print(3)\n"), 0o644)
+	if changed := next(); len(changed) != 1 || !strings.HasSuffix(changed[0], "report.py") {
+		t.Errorf("changed = %v, want report.py", changed)
+	}
+
+	if _, err := NewWatcher(script, []string{filepath.Join(dir, "missing", "*.csv")}); err == nil {
+		t.Error("NewWatcher accepted a glob whose directory does not exist")
+	}
+}
+
+func TestWatch_NeedsAFile(t *testing.T) {
+	report := func(int, map[string]any, error) { t.Error("ran without a file to watch") }
+	for _, cfg := range []*ExecConfig{{Code: "print(1)"}, {ScriptPath: "-"}} {
+		if err := Watch(context.Background(), cfg, nil, report); err == nil || !strings.Contains(err.Error(), "--watch") {
+			t.Errorf("Watch(%+v) = %v", cfg, err)
+		}
+	}
+}
+
+func TestRunStreamed(t *testing.T) {
+	var buf bytes.Buffer
+	_, result, err := Run(&ExecConfig{Code: " ", JSONStream: true, Stdout: &buf, Stderr: io.Discard})
+	if err != nil || result != nil {
+		t.Fatalf("Run = %v, %v", result, err)
+	}
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		events = append(events, ev["event"].(string))
+	}
+	if got := strings.Join(events, ","); got != "started,result,finished" {
+		t.Errorf("events = %s", got)
+	}
+
+	if _, _, err := Run(&ExecConfig{Code: " ", JSONStream: true, JSONMode: true}); err == nil {
+		t.Error("--json with --json-stream was accepted")
+	}
+}
+
+func TestFrameWriter(t *testing.T) {
+	var events, rest bytes.Buffer
+	f := &frameWriter{events: &eventWriter{w: &events}, rest: &rest}
+	f.Write([]byte(`{"type": "stdout", "data": "hi\n"}` + "\n" + `{"type": "std`))
+	f.Write([]byte(`err", "data": "oops"}` + "\n" + `{"exit_code": 0}`))
+	f.flush()
+	if got := events.String(); got != `{"data":"hi\n","event":"stdout"}`+"\n"+`{"data":"oops","event":"stderr"}`+"\n" {
+		t.Errorf("events = %q", got)
+	}
+	if rest.String() != `{"exit_code": 0}` {
+		t.Errorf("rest = %q", rest.String())
+	}
+	if !f.events.streamed {
+		t.Error("streamed not set")
+	}
+}
+
+func TestRunHosts(t *testing.T) {
+	configure := func(c *ExecConfig) error { return nil }
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "x = 1", VMMode: true}, "--vm can't be used with several hosts"},
+		{ExecConfig{Code: "x = 1", JSONStream: true}, "--json-stream can't be used with several hosts"},
+		{ExecConfig{Code: "x = 1", Replay: "golden"}, "--record and --replay run on one server"},
+		{ExecConfig{Code: "x = 1", ExportTables: []string{"t=t.csv"}}, "--export-table can't be used with several hosts"},
+	} {
+		if _, _, err := RunHosts(&tc.cfg, []string{"a", "b"}, 0, configure); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("RunHosts(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+
+	_, _, err := RunHosts(&ExecConfig{Code: "x = 1"}, []string{"a", "b"}, 0, func(c *ExecConfig) error {
+		if c.Host == "b" {
+			return errors.New("no token")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "b: no token" {
+		t.Errorf("configure error = %v", err)
+	}
+
+	// Runs that fail before they reach a server: each host reports its
+	// error, in the order given
+	var stderr bytes.Buffer
+	exitCode, result, err := RunHosts(&ExecConfig{Code: "x = 1", Jailed: true, JSONMode: true, Stderr: &stderr}, []string{"a", "b"}, 1, configure)
+	if err != nil || exitCode != 1 {
+		t.Fatalf("RunHosts = %d, %v", exitCode, err)
+	}
+	hosts := result["hosts"].([]map[string]any)
+	if len(hosts) != 2 || hosts[0]["host"] != "a" || hosts[1]["host"] != "b" || result["failed"] != 2 {
+		t.Fatalf("result = %v", result)
+	}
+	if hosts[0]["exit_code"] != 1 || !strings.Contains(hosts[0]["error"].(string), "--jailed requires --vm") {
+		t.Errorf("host a = %v", hosts[0])
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	p := &prefixWriter{prefix: "[a] ", w: &buf, mu: &sync.Mutex{}}
+	p.Write([]byte("one\ntw"))
+	p.Write([]byte("o\nthree"))
+	p.flush()
+	if got := buf.String(); got != "[a] one\n[a] two\n[a] three\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRun_Check(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  ExecConfig
+		want string
+	}{
+		{ExecConfig{Code: "x = 1", Check: true, JSONStream: true}, "--check can't be used with --json-stream"},
+		{ExecConfig{Code: "x = 1", Check: true, VMMode: true, Host: "dh.example.com"}, "cannot use both --vm and --host"},
+	} {
+		_, _, err := Run(&tc.cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Run(%+v) error = %v, want %q", tc.cfg, err, tc.want)
+		}
+	}
+}
+
+func TestCompileCode(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not on PATH")
+	}
+
+	cfg := &ExecConfig{Code: "x = (1,"}
+	diags, err := compileCode(cfg, python, cfg.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Path != "<string>" || diags[0].Line != 1 || !strings.HasPrefix(diags[0].Message, "SyntaxError: ") {
+		t.Errorf("diags = %+v", diags)
+	}
+
+	// Lines are counted from the start of each script, not the joined code
+	cfg = &ExecConfig{parts: []codePart{{Index: 1, Path: "/a.py", Line: 1, Lines: 2}, {Index: 2, Path: "/b.py", Line: 3, Lines: 2}}}
+	diags, err = compileCode(cfg, python, "x = 1\ny = 2\nif x:\nprint(x)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Path != "/b.py" || diags[0].Line != 2 || !strings.HasPrefix(diags[0].Message, "IndentationError: ") {
+		t.Errorf("diags = %+v", diags)
+	}
+}
+
+func TestResetScope(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not on PATH")
+	}
+
+	// Two leased runs against one scope, as a daemon's server keeps it
+	harness := `
+import sys
+runner = {"__name__": "dh_runner"}
+exec(sys.stdin.read(), runner)
+reset = runner["RESET_SCOPE_SCRIPT"]
+scope = {"__name__": "__main__", "server_name": 1}
+exec(reset, scope)
+exec("leaked = 42", scope)
+exec(reset, scope)
+print(sorted(n for n in scope if not n.startswith("__")))
+`
+	cmd := exec.Command(python, "-c", harness)
+	cmd.Stdin = strings.NewReader(runnerScript)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "['server_name']" {
+		t.Errorf("scope after the second run = %s, want only the server's own names", got)
+	}
+}
+
+func TestIsAssertion(t *testing.T) {
+	for errText, want := range map[string]bool{
+		"Traceback (most recent call last):\n  File \"<string>\", line 1\nAssertionError: 3 rows, want 4\n":    true,
+		"Traceback (most recent call last):\n  File \"<string>\", line 1\nAssertionError\n":                    true,
+		"Error in cell 2:\nTraceback (most recent call last):\nAssertionError":                                 true,
+		"org.codehaus.groovy.runtime.powerassert.PowerAssertionError: assert x == 2":                           true,
+		"Traceback (most recent call last):\nAssertionError\n\nDuring handling...\nKeyError: 'x'":              false,
+		"Traceback (most recent call last):\n  File \"<string>\", line 1\nZeroDivisionError: division by zero": false,
+		"": false,
+	} {
+		if got := isAssertion(errText); got != want {
+			t.Errorf("isAssertion(%q) = %v, want %v", errText, got, want)
+		}
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	traceback := "Traceback (most recent call last):\n  File \"<string>\", line 1\nZeroDivisionError: division by zero"
+	for _, tc := range []struct {
+		exitCode int
+		result   map[string]any
+		want     any
+	}{
+		{0, map[string]any{}, nil},
+		{5, map[string]any{"error": "AssertionError"}, ErrorTypeAssertion},
+		{2, map[string]any{"error": "Failed to connect to prod:10000"}, ErrorTypeConnection},
+		{3, map[string]any{}, ErrorTypeTimeout},
+		{130, map[string]any{}, ErrorTypeInterrupted},
+		{1, map[string]any{"error": "--export-table t: no table t in scope", "error_type": "error"}, ErrorTypeError},
+		{1, map[string]any{"error": traceback}, ErrorTypeScript},
+		{1, map[string]any{"error": nil, "warnings": []any{map[string]any{"message": "old"}}}, ErrorTypeWarnings},
+		{1, map[string]any{"error": "--import-table t: reading t.csv: no such file"}, ErrorTypeError},
+	} {
+		if got := errorType(tc.exitCode, tc.result); got != tc.want {
+			t.Errorf("errorType(%d, %v) = %v, want %v", tc.exitCode, tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestParseGitSpec(t *testing.T) {
+	for spec, want := range map[string][3]string{
+		"https://github.com/org/ops@main:runbooks/check.py": {"https://github.com/org/ops", "main", "runbooks/check.py"},
+		"git@github.com:org/ops.git@v1.2:check.py":          {"git@github.com:org/ops.git", "v1.2", "check.py"},
+		"../ops@abc123:/jobs/a@b.py":                        {"../ops", "abc123", "jobs/a@b.py"},
+	} {
+		repo, ref, file, err := ParseGitSpec(spec)
+		if err != nil || [3]string{repo, ref, file} != want {
+			t.Errorf("ParseGitSpec(%q) = %q, %q, %q, %v; want %q", spec, repo, ref, file, err, want)
+		}
+	}
+	for _, spec := range []string{"", "repo", "repo@main", "repo@:x.py", "@main:x.py", "--upload-pack=touch x@main:x.py", "repo@--output=x:x.py"} {
+		if _, _, _, err := ParseGitSpec(spec); err == nil {
+			t.Errorf("ParseGitSpec(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestFetchRemoteCode_URL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/check.py":
+			io.WriteString(w, "print('hi')\n")
+		case "/check.groovy":
+			io.WriteString(w, "println 'hi'\n")
+		case "/big.py":
+			io.WriteString(w, strings.Repeat("#", 2048))
+		case "/moved.py":
+			http.Redirect(w, r, "/check.py", http.StatusFound)
+		case "/insecure.py":
+			http.Redirect(w, r, "http://example.com/check.py", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	plain := &ExecConfig{ScriptPath: "http://example.com/check.py", AllowRemoteCode: true}
+	if err := FetchRemoteCode(plain); err == nil || !strings.Contains(err.Error(), "plain http") {
+		t.Errorf("plain http: err = %v", err)
+	}
+
+	cfg := &ExecConfig{ScriptPath: srv.URL + "/check.py"}
+	if err := FetchRemoteCode(cfg); err == nil || !strings.Contains(err.Error(), "--allow-remote-code") {
+		t.Fatalf("without --allow-remote-code: err = %v", err)
+	}
+	cfg.AllowRemoteCode = true
+	if err := FetchRemoteCode(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Code != "print('hi')\n" || cfg.ScriptPath != "" {
+		t.Errorf("Code = %q, ScriptPath = %q", cfg.Code, cfg.ScriptPath)
+	}
+
+	cfg = &ExecConfig{ScriptPath: srv.URL + "/check.groovy?raw=1", AllowRemoteCode: true}
+	if err := FetchRemoteCode(cfg); err != nil || cfg.Language != "groovy" {
+		t.Errorf("groovy: Language = %q, err = %v", cfg.Language, err)
+	}
+
+	cfg = &ExecConfig{ScriptPath: srv.URL + "/big.py", AllowRemoteCode: true, MaxRemoteKB: 1}
+	if err := FetchRemoteCode(cfg); err == nil || !strings.Contains(err.Error(), "--max-remote-kb") {
+		t.Errorf("over the limit: err = %v", err)
+	}
+	cfg = &ExecConfig{ScriptPath: srv.URL + "/moved.py", AllowRemoteCode: true}
+	if err := FetchRemoteCode(cfg); err != nil || cfg.Code != "print('hi')\n" {
+		t.Errorf("redirect: Code = %q, err = %v", cfg.Code, err)
+	}
+	cfg = &ExecConfig{ScriptPath: srv.URL + "/insecure.py", AllowRemoteCode: true}
+	if err := FetchRemoteCode(cfg); err == nil || !strings.Contains(err.Error(), "plain http") {
+		t.Errorf("redirect to plain http: err = %v", err)
+	}
+	cfg = &ExecConfig{ScriptPath: srv.URL + "/missing.py", AllowRemoteCode: true}
+	if err := FetchRemoteCode(cfg); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Errorf("missing: err = %v", err)
+	}
+	cfg = &ExecConfig{ScriptPath: srv.URL + "/check.py", ExtraScripts: []string{"b.py"}, AllowRemoteCode: true}
+	if err := FetchRemoteCode(cfg); err == nil {
+		t.Error("a URL with other scripts: want error")
+	}
+
+	// Local code is left alone
+	cfg = &ExecConfig{ScriptPath: "check.py"}
+	if err := FetchRemoteCode(cfg); err != nil || cfg.Code != "" || cfg.ScriptPath != "check.py" {
+		t.Errorf("local script: cfg = %+v, err = %v", cfg, err)
+	}
+}
+
+func TestFetchRemoteCode_Git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not on PATH")
+	}
+	repo := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	run("init", "-q")
+	os.MkdirAll(filepath.Join(repo, "jobs"), 0o755)
+	os.WriteFile(filepath.Join(repo, "jobs", "check.py"), []byte("print('v1')\n"), 0o644)
+	run("add", ".")
+	run("commit", "-q", "-m", "v1")
+	run("tag", "v1")
+	os.WriteFile(filepath.Join(repo, "jobs", "check.py"), []byte("print('v2')\n"), 0o644)
+	run("commit", "-q", "-am", "v2")
+
+	// A local repository is read in place; file:// is fetched like any
+	// other remote
+	for _, spec := range []string{repo + "@v1:jobs/check.py", "file://" + repo + "@v1:jobs/check.py"} {
+		cfg := &ExecConfig{Git: spec, AllowRemoteCode: true}
+		if err := FetchRemoteCode(cfg); err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if cfg.Code != "print('v1')\n" || cfg.Git != "" {
+			t.Errorf("%s: Code = %q, Git = %q", spec, cfg.Code, cfg.Git)
+		}
+	}
+
+	cfg := &ExecConfig{Git: repo + "@v1:jobs/missing.py", AllowRemoteCode: true}
+	if err := FetchRemoteCode(cfg); err == nil {
+		t.Error("missing file: want error")
+	}
+	cfg = &ExecConfig{Git: repo + "@v1:jobs/check.py", ScriptPath: "other.py", AllowRemoteCode: true}
+	if err := FetchRemoteCode(cfg); err == nil {
+		t.Error("--git with a script: want error")
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	closed := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	t.Run("connect", func(t *testing.T) {
+		t.Parallel()
+		w := startWatchdog(&ExecConfig{ConnectTimeout: 1, ExecTimeout: 1}, "starting the server")
+		defer w.stop()
+		select {
+		case <-w.killed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not killed")
+		}
+		if msg := w.expired(); msg != "Timed out after 1 seconds starting the server (--connect-timeout)" {
+			t.Errorf("expired() = %q", msg)
+		}
+	})
+
+	t.Run("exec from ready", func(t *testing.T) {
+		t.Parallel()
+		w := startWatchdog(&ExecConfig{ConnectTimeout: 1, ExecTimeout: 2, KillAfter: 1}, "starting the server")
+		defer w.stop()
+		interrupted := make(chan time.Time, 1)
+		w.onInterrupt(func() { interrupted <- time.Now() })
+		w.ready()
+		var at time.Time
+		select {
+		case at = <-interrupted:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not interrupted")
+		}
+		if msg := w.expired(); msg != "Execution timed out after 2 seconds (--exec-timeout)" {
+			t.Errorf("expired() = %q", msg)
+		}
+		if closed(w.killed) {
+			t.Error("killed with the interrupt, want --kill-after later")
+		}
+		<-w.killed
+		if d := time.Since(at); d < 900*time.Millisecond {
+			t.Errorf("killed %v after the interrupt, want 1s", d)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		t.Parallel()
+		w := startWatchdog(&ExecConfig{Timeout: 1}, "")
+		w.stop()
+		time.Sleep(1200 * time.Millisecond)
+		if msg := w.expired(); msg != "" || closed(w.interrupted) {
+			t.Errorf("stopped watchdog expired: %q", msg)
+		}
+	})
+}
+
+func TestRun_Timing(t *testing.T) {
+	cfg := &ExecConfig{Code: "   ", JSONMode: true, ProcessStart: time.Now().Add(-time.Second)}
+	_, result, err := Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timing, ok := result["timing"].(*Timing)
+	if !ok {
+		t.Fatalf("timing = %#v, want *Timing", result["timing"])
+	}
+	if timing.StartupMs == nil || *timing.StartupMs < 1000 {
+		t.Errorf("startup_ms = %v, want at least 1000", timing.StartupMs)
+	}
+	if cfg.timing != nil {
+		t.Error("Run left its timing on cfg")
+	}
+
+	data, err := json.Marshal(timing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys map[string]any
+	if err := json.Unmarshal(data, &keys); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"startup_ms", "resolve_ms", "venv_ms", "java_ms", "vm_check_ms", "server_start_ms", "script_ms", "tables_ms", "total_ms"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("timing has no %s: %s", key, data)
+		}
+	}
+	if keys["java_ms"] != nil {
+		t.Errorf("java_ms = %v for a run without a server, want null", keys["java_ms"])
+	}
+
+	if ms := runnerMillis(float64(12)); ms == nil || *ms != 12 {
+		t.Errorf("runnerMillis(12) = %v", ms)
+	}
+	if ms := runnerMillis(nil); ms != nil {
+		t.Errorf("runnerMillis(nil) = %v, want nil", *ms)
+	}
+}
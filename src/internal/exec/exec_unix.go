@@ -2,7 +2,10 @@
 
 package exec
 
-import "syscall"
+import (
+	"os"
+	"syscall"
+)
 
 // processGroupAttr returns SysProcAttr to create a new process group on unix.
 func processGroupAttr() *syscall.SysProcAttr {
@@ -13,3 +16,28 @@ func processGroupAttr() *syscall.SysProcAttr {
 func killProcessGroup(pid int) error {
 	return syscall.Kill(-pid, syscall.SIGKILL)
 }
+
+// interruptProcessGroup sends SIGINT to the process group.
+func interruptProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGINT)
+}
+
+// lockFile blocks until an exclusive flock is held on f.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// tryLockFile attempts an exclusive flock on f without blocking.
+// Returns false if another process holds the lock.
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockFile releases the flock on f.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -3,16 +3,20 @@
 package exec
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/dsmmcken/dh-cli/src/internal/config"
 	"github.com/dsmmcken/dh-cli/src/internal/output"
 	"github.com/dsmmcken/dh-cli/src/internal/vm"
 )
@@ -22,28 +26,70 @@ import (
 func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]any, error) {
 	entryTime := time.Now()
 
+	mounts, err := vm.ParseMounts(cfg.Mounts)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+	cwd, _ := os.Getwd()
+	guestPath, pathMounts := guestPythonPath(cfg.PythonPath, cwd, mounts)
+	mounts = append(mounts, pathMounts...)
+	imports, importMounts := guestTableImports(cfg.TableImports, cwd, mounts)
+	mounts = append(mounts, importMounts...)
+	writePaths, err := vm.ResolveWritePaths(cwd, cfg.AllowWrite)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+	if cfg.Session != "" {
+		if err := startSession(cfg, version, dhHome); err != nil {
+			return output.ExitError, nil, err
+		}
+	}
+	if name := cmp.Or(cfg.Instance, cfg.Session); name != "" {
+		return runNamedExec(cfg, name, userCode, version, dhHome, guestPath, imports, mounts, writePaths, entryTime)
+	}
+	var outputs *vm.OutputDir
+	if cfg.CollectOutputs != "" {
+		if outputs, err = vm.OpenOutputDir(cfg.CollectOutputs); err != nil {
+			return output.ExitError, nil, fmt.Errorf("--collect-outputs: %w", err)
+		}
+		defer outputs.Close()
+	}
+
+	tuning, err := config.ResolveVMTuning(cfg.VMBackend, cfg.VMEagerMB, cfg.NoPool)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+
 	// Try pool first (fast path ~20ms vs ~700ms cold restore).
-	// Skip pool if DH_VM_POOL=0 is set.
-	if os.Getenv("DH_VM_POOL") != "0" {
-		if exitCode, jsonResult, resp, err := tryPoolExec(cfg, userCode, version, dhHome, entryTime); err == nil {
+	// Skip pool with --no-pool (or DH_VM_POOL=0). The pool protocol does not carry
+	// write access to the working directory or output files, so those runs
+	// always take the cold path, as do jailed and --net runs: pool VMs
+	// aren't jailed and have no network. Pool VMs are restored from the
+	// version's own snapshot, so profile runs restore their own. Pool runs
+	// can't be interrupted, so runs with a timeout restore their own too.
+	timed := cfg.Timeout > 0 || cfg.ConnectTimeout > 0 || cfg.ExecTimeout > 0
+	if !tuning.NoPool && len(writePaths) == 0 && outputs == nil && !cfg.Jailed && cfg.Net == "" && cfg.Profile == "" && !timed {
+		if exitCode, jsonResult, resp, err := tryPoolExec(cfg, userCode, version, dhHome, guestPath, imports, mounts, entryTime); err == nil {
 			return formatVsockResponse(cfg, resp, version, entryTime, exitCode, jsonResult)
 		}
 	}
 
 	// Cold restore path
 	vmPaths := vm.NewVMPaths(dhHome)
+	key := vm.SnapshotKey(version, cfg.Profile)
 
 	// Start page cache warming ASAP — overlaps with prereq checks,
 	// Firecracker startup, and the beginning of VM execution.
-	vm.WarmSnapshotPageCacheAsync(vmPaths, version)
+	vm.WarmSnapshotPageCacheAsync(vmPaths, key)
 
 	// Run prereqs, snapshot check, and stale cleanup concurrently
+	checkStart := time.Now()
 	var prereqErrs []*vm.PrereqError
 	var snapErr error
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() { defer wg.Done(); prereqErrs = vm.CheckPrerequisites(vmPaths) }()
-	go func() { defer wg.Done(); snapErr = vm.CheckSnapshot(vmPaths, version) }()
+	go func() { defer wg.Done(); snapErr = vm.CheckSnapshot(vmPaths, key) }()
 	go vm.CleanupStaleInstances(vmPaths) // fire-and-forget
 	wg.Wait()
 
@@ -54,28 +100,44 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 		}
 		return output.ExitError, nil, fmt.Errorf("VM prerequisites not met:\n  %s", strings.Join(msgs, "\n  "))
 	}
+	var incompatible *vm.IncompatibleSnapshotError
+	if cfg.AutoRebuild && errors.As(snapErr, &incompatible) {
+		fmt.Fprintf(cfg.Stderr, "Rebuilding the snapshot for version %s (%s)...\n", key, strings.Join(incompatible.Problems, "; "))
+		rebuildCfg := &vm.VMConfig{DHHome: dhHome, Version: version, Profile: cfg.Profile, Verbose: cfg.Verbose}
+		if err := vm.RebuildSnapshot(context.Background(), rebuildCfg, vmPaths, cfg.Stderr); err != nil {
+			return output.ExitError, nil, fmt.Errorf("rebuilding snapshot: %w", err)
+		}
+		snapErr = vm.CheckSnapshot(vmPaths, key)
+	}
 	if snapErr != nil {
 		return output.ExitError, nil, snapErr
 	}
+	cfg.timing.VMCheckMs = millis(time.Since(checkStart))
 
 	prereqMs := time.Since(entryTime).Milliseconds()
 
-	// Set up context with optional timeout
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if cfg.Timeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
-	} else {
-		ctx, cancel = context.WithCancel(ctx)
-	}
+	// Timeouts: restoring the VM is the connect phase. When one passes,
+	// the script is interrupted, and cancelling ctx kills the VM
+	// cfg.KillAfter seconds later.
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-
-	useUffd := os.Getenv("DH_VM_NO_UFFD") != "1"
+	interruptCtx, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+	timer := startWatchdog(cfg, "restoring the VM")
+	defer timer.stop()
+	timer.onInterrupt(interrupt)
+	timer.onKill(cancel)
+
+	useUffd := tuning.Backend == "uffd"
 	vmCfg := &vm.VMConfig{
-		DHHome: dhHome,
+		DHHome:  dhHome,
 		Version: version,
+		Profile: cfg.Profile,
 		Verbose: cfg.Verbose,
 		UseUffd: useUffd,
+		EagerMB: tuning.EagerMB,
+		Jailed:  cfg.Jailed,
+		Net:     cfg.Net,
 	}
 
 	if cfg.Verbose {
@@ -88,13 +150,16 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 		if !useUffd {
 			backend = "File"
 		}
-		fmt.Fprintf(cfg.Stderr, "Restoring VM from snapshot for version %s (backend=%s)...\n", version, backend)
+		fmt.Fprintf(cfg.Stderr, "Restoring VM from snapshot for version %s (backend=%s)...\n", key, backend)
 	}
 
 	start := time.Now()
 
 	info, machine, uffdCloser, err := vm.RestoreFromSnapshot(ctx, vmCfg, vmPaths, cfg.Stderr)
 	if err != nil {
+		if msg := timer.expired(); msg != "" {
+			return vmTimeout(cfg, msg, version, nil, time.Since(start).Seconds())
+		}
 		return output.ExitError, nil, fmt.Errorf("restoring VM: %w", err)
 	}
 	defer func() {
@@ -103,12 +168,15 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 			uffdCloser.Close()
 		}
 	}()
+	if msg := timer.expired(); msg != "" {
+		return vmTimeout(cfg, msg, version, nil, time.Since(start).Seconds())
+	}
+	timer.ready()
 
 	// Start host file server after VM restore. The guest LD_PRELOAD library
-	// connects to this server to fetch workspace files on demand. We use the
-	// instance's vsock path so pool and non-pool VMs both work correctly.
-	cwd, _ := os.Getwd()
-	fileServer, err := vm.StartFileServer(info.VsockPath, cwd)
+	// connects to this server to fetch workspace files on demand, at the
+	// path guest connections go to.
+	fileServer, err := vm.StartFileServer(info.GuestVsockPath, cwd, writePaths, mounts...)
 	if err != nil && cfg.Verbose {
 		fmt.Fprintf(cfg.Stderr, "Warning: file server: %v\n", err)
 	}
@@ -117,24 +185,30 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 	}
 
 	restoreMs := time.Since(start).Milliseconds()
+	cfg.timing.ServerStartMs = millis(time.Since(start))
 	if cfg.Verbose {
 		fmt.Fprintf(cfg.Stderr, "VM restored in %dms (instance %s)\n",
 			restoreMs, info.ID)
 	}
 
-	// Register signal handler for cleanup
-	sigCh := make(chan os.Signal, 1)
+	// Register signal handler: the first SIGINT interrupts the script in
+	// the VM, which still returns the output so far; a second one, or
+	// SIGTERM, destroys the VM.
+	sigCh := make(chan os.Signal, 2)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		sig := <-sigCh
-		if sig == nil {
-			return // channel closed on normal exit
-		}
-		vm.DestroyInstance(machine, info, vmPaths)
-		if uffdCloser != nil {
-			uffdCloser.Close()
+		for sig := range sigCh {
+			if sig == syscall.SIGINT && interruptCtx.Err() == nil {
+				fmt.Fprintln(cfg.Stderr, "\nInterrupting... (Ctrl+C again to stop the VM)")
+				interrupt()
+				continue
+			}
+			vm.DestroyInstance(machine, info, vmPaths)
+			if uffdCloser != nil {
+				uffdCloser.Close()
+			}
+			os.Exit(output.ExitInterrupted)
 		}
-		os.Exit(130)
 	}()
 	defer func() { signal.Stop(sigCh); close(sigCh) }()
 
@@ -143,6 +217,18 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 		Code:          userCode,
 		ShowTables:    cfg.ShowTables,
 		ShowTableMeta: cfg.ShowTableMeta,
+		PythonPath:    guestPath,
+		HashTables:    cfg.HashTables,
+		MountMode:     vsockMountMode(cfg.MountMode),
+		Outputs:       outputs,
+		TableFormat:   vsockTableFormat(cfg.TableFormat),
+		ImportTables:  imports,
+		ExportTables:  vsockTableExports(cfg.TableExports),
+		TableOutput:   cfg.TableOutput,
+		MaxRows:       cfg.MaxRows,
+		MaxCols:       cfg.MaxCols,
+
+		InterruptGrace: time.Duration(cfg.KillAfter) * time.Second,
 	}
 
 	// Run vsock request with context-aware timeout
@@ -150,37 +236,60 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 		resp *vm.VsockResponse
 		err  error
 	}
+	// Outside JSON mode, print output as the script writes it, and with
+	// --json-stream send it as events. Runners in older snapshots don't
+	// stream; their output is printed at the end.
+	var onOutput func(stream, data string)
+	if cfg.events != nil {
+		onOutput = cfg.events.output
+	} else if !cfg.JSONMode {
+		onOutput = func(stream, data string) {
+			if stream == "stderr" {
+				fmt.Fprint(cfg.Stderr, data)
+			} else {
+				fmt.Fprint(cfg.Stdout, data)
+			}
+		}
+	}
 	resultCh := make(chan vsockResult, 1)
 	go func() {
-		resp, err := vm.ExecuteViaVsock(info.VsockPath, vm.VsockPort, req)
+		resp, err := vm.ExecuteViaVsockStream(interruptCtx, info.VsockPath, vm.VsockPort, req, onOutput)
 		resultCh <- vsockResult{resp, err}
 	}()
 
 	var resp *vm.VsockResponse
 	select {
 	case r := <-resultCh:
+		if msg := timer.expired(); msg != "" {
+			// Interrupted by the timeout; any output so far is kept
+			return vmTimeout(cfg, msg, version, r.resp, time.Since(start).Seconds())
+		}
+		if r.err != nil && interruptCtx.Err() != nil {
+			// The runner predates interrupts; the VM is destroyed on return.
+			if cfg.JSONMode {
+				return output.ExitInterrupted, map[string]any{
+					"exit_code":       output.ExitInterrupted,
+					"stdout":          "",
+					"stderr":          "",
+					"result_repr":     nil,
+					"error":           "Interrupted",
+					"warnings":        []any{},
+					"tables":          []any{},
+					"version":         version,
+					"vm_mode":         true,
+					"elapsed_seconds": time.Since(start).Seconds(),
+				}, nil
+			}
+			fmt.Fprintln(cfg.Stderr, "Interrupted.")
+			return output.ExitInterrupted, nil, nil
+		}
 		if r.err != nil {
 			return output.ExitError, nil, fmt.Errorf("executing via vsock: %w", r.err)
 		}
 		resp = r.resp
 	case <-ctx.Done():
-		elapsed := time.Since(start).Seconds()
-		if cfg.JSONMode {
-			jsonResult := map[string]any{
-				"exit_code":       output.ExitTimeout,
-				"stdout":          "",
-				"stderr":          "",
-				"result_repr":     nil,
-				"error":           fmt.Sprintf("Execution timed out after %d seconds", cfg.Timeout),
-				"tables":          []any{},
-				"version":         version,
-				"vm_mode":         true,
-				"elapsed_seconds": elapsed,
-			}
-			return output.ExitTimeout, jsonResult, nil
-		}
-		fmt.Fprintf(cfg.Stderr, "Error: Execution timed out after %d seconds\n", cfg.Timeout)
-		return output.ExitTimeout, nil, nil
+		// Killed by the timeout: the runner did not stop the script
+		return vmTimeout(cfg, timer.expired(), version, nil, time.Since(start).Seconds())
 	}
 
 	elapsed := time.Since(start).Seconds()
@@ -189,7 +298,7 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 	vsockMs := time.Since(start).Milliseconds() - restoreMs
 	if cfg.Verbose && resp.Timing != nil {
 		fmt.Fprintf(cfg.Stderr, "VM timing: prereqs=%dms restore=%dms vsock=%dms", prereqMs, restoreMs, vsockMs)
-		for _, key := range []string{"build_wrapper_ms", "run_script_ms", "read_result_ms"} {
+		for _, key := range []string{"build_wrapper_ms", "run_script_ms", "read_result_ms", "tables_ms"} {
 			if v, ok := resp.Timing[key]; ok {
 				fmt.Fprintf(cfg.Stderr, " %s=%v", key, v)
 			}
@@ -197,13 +306,33 @@ func runVM(cfg *ExecConfig, userCode, version, dhHome string) (int, map[string]a
 		fmt.Fprintf(cfg.Stderr, " total=%.0fms (since entry=%.0fms)\n", elapsed*1000, float64(time.Since(entryTime).Milliseconds()))
 	}
 
+	// How UFFD populated the VM's memory, to tune --vm-eager-mb.
+	if st := vm.UffdStatsOf(uffdCloser); st != nil {
+		if cfg.Verbose {
+			source := "leading data"
+			if st.Learned {
+				source = "hot chunks"
+			}
+			pages := ""
+			if st.HugePages {
+				pages = ", huge pages"
+			}
+			fmt.Fprintf(cfg.Stderr, "UFFD: eager=%.0fMiB (%s) in %.0fms, lazy=%.0fMiB in %d faults (p50=%.0fus p99=%.0fus%s)\n",
+				float64(st.EagerBytes)/(1<<20), source, st.EagerMs, float64(st.LazyBytes)/(1<<20), st.Faults, st.FaultP50Us, st.FaultP99Us, pages)
+		}
+		if resp.Timing == nil {
+			resp.Timing = map[string]any{}
+		}
+		resp.Timing["uffd"] = st
+	}
+
 	return formatVsockResponse(cfg, resp, version, entryTime, exitCode, nil)
 }
 
 // tryPoolExec attempts to execute code via the pool daemon.
 // Returns (exitCode, jsonResult, resp, nil) on success, or (0, nil, nil, err) on failure.
 // On failure, the caller should fall through to cold restore.
-func tryPoolExec(cfg *ExecConfig, userCode, version, dhHome string, entryTime time.Time) (int, map[string]any, *vm.VsockResponse, error) {
+func tryPoolExec(cfg *ExecConfig, userCode, version, dhHome string, guestPath []string, imports []vm.TableImport, mounts []vm.Mount, entryTime time.Time) (int, map[string]any, *vm.VsockResponse, error) {
 	poolRunning := vm.PoolProbe()
 
 	// Auto-start: if pool is not running, fork a daemon in the background
@@ -221,12 +350,26 @@ func tryPoolExec(cfg *ExecConfig, userCode, version, dhHome string, entryTime ti
 	}
 
 	cwd, _ := os.Getwd()
+	generation := vm.SnapshotGeneration(vm.NewVMPaths(dhHome), version)
 	poolResp, err := vm.PoolExec(&vm.PoolRequest{
 		Type:          "exec",
+		Version:       version,
+		Generation:    generation,
+		WaitMs:        vm.PoolWaitMs,
 		Code:          userCode,
 		CWD:           cwd,
 		ShowTables:    cfg.ShowTables,
 		ShowTableMeta: cfg.ShowTableMeta,
+		PythonPath:    guestPath,
+		HashTables:    cfg.HashTables,
+		MountMode:     vsockMountMode(cfg.MountMode),
+		Mounts:        mounts,
+		TableFormat:   vsockTableFormat(cfg.TableFormat),
+		ImportTables:  imports,
+		ExportTables:  vsockTableExports(cfg.TableExports),
+		TableOutput:   cfg.TableOutput,
+		MaxRows:       cfg.MaxRows,
+		MaxCols:       cfg.MaxCols,
 	})
 	if err != nil {
 		if cfg.Verbose {
@@ -251,10 +394,21 @@ func tryPoolExec(cfg *ExecConfig, userCode, version, dhHome string, entryTime ti
 		return 0, nil, nil, fmt.Errorf("version mismatch")
 	}
 
+	// A pool that predates generations can't tell it serves VMs of a
+	// snapshot replaced since; those that know refuse them.
+	if generation != "" && poolResp.Generation != "" && poolResp.Generation != generation {
+		if cfg.Verbose {
+			fmt.Fprintf(cfg.Stderr, "Pool VM is from a replaced snapshot (pool=%s, snapshot=%s), falling back to cold restore\n",
+				poolResp.Generation, generation)
+		}
+		return 0, nil, nil, fmt.Errorf("snapshot generation mismatch")
+	}
+
 	resp := poolResp.Exec
 	if resp == nil {
 		return 0, nil, nil, fmt.Errorf("no exec result from pool")
 	}
+	resp.ExitCode = vsockExitCode(resp)
 
 	elapsed := time.Since(entryTime).Seconds()
 	if cfg.Verbose {
@@ -262,12 +416,14 @@ func tryPoolExec(cfg *ExecConfig, userCode, version, dhHome string, entryTime ti
 	}
 
 	if cfg.JSONMode {
+		exitCode := warningExitCode(cfg, resp.ExitCode, resp.Warnings)
 		jsonResult := map[string]any{
-			"exit_code":       resp.ExitCode,
+			"exit_code":       exitCode,
 			"stdout":          resp.Stdout,
 			"stderr":          resp.Stderr,
 			"result_repr":     resp.ResultRepr,
 			"error":           resp.Error,
+			"warnings":        warningsOrEmpty(resp.Warnings),
 			"tables":          resp.Tables,
 			"version":         version,
 			"vm_mode":         true,
@@ -277,12 +433,165 @@ func tryPoolExec(cfg *ExecConfig, userCode, version, dhHome string, entryTime ti
 		if resp.Timing != nil {
 			jsonResult["_timing"] = resp.Timing
 		}
-		return resp.ExitCode, jsonResult, resp, nil
+		return exitCode, jsonResult, resp, nil
 	}
 
 	return resp.ExitCode, nil, resp, nil
 }
 
+// runNamedExec runs code in named VM name, from 'dh vm up' or a session.
+// The VM is kept, with what earlier runs left in its server. The version
+// is the VM's unless --version names another, which is refused.
+func runNamedExec(cfg *ExecConfig, name, userCode, version, dhHome string, guestPath []string, imports []vm.TableImport, mounts []vm.Mount, writePaths []string, entryTime time.Time) (int, map[string]any, error) {
+	vmPaths := vm.NewVMPaths(dhHome)
+	if !vm.NamedVMProbe(vmPaths, name) {
+		return output.ExitError, nil, fmt.Errorf("VM %s is not up; start it with 'dh vm up --name %s'", name, name)
+	}
+
+	req := &vm.PoolRequest{
+		Type:          "exec",
+		Code:          userCode,
+		ShowTables:    cfg.ShowTables,
+		ShowTableMeta: cfg.ShowTableMeta,
+		PythonPath:    guestPath,
+		HashTables:    cfg.HashTables,
+		MountMode:     vsockMountMode(cfg.MountMode),
+		Mounts:        mounts,
+		AllowWrite:    writePaths,
+		TableFormat:   vsockTableFormat(cfg.TableFormat),
+		ImportTables:  imports,
+		ExportTables:  vsockTableExports(cfg.TableExports),
+		TableOutput:   cfg.TableOutput,
+		MaxRows:       cfg.MaxRows,
+		MaxCols:       cfg.MaxCols,
+	}
+	req.CWD, _ = os.Getwd()
+	if cfg.Version != "" {
+		req.Version = version
+	}
+	// The VM is up, so there is no connect phase. Hanging up on a timeout
+	// interrupts the script; the VM is kept.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timer := startWatchdog(cfg, "")
+	defer timer.stop()
+	timer.ready()
+	timer.onInterrupt(cancel)
+	resp, err := vm.NamedVMCommandContext(ctx, vmPaths, name, req)
+	if msg := timer.expired(); msg != "" {
+		return vmTimeout(cfg, msg, version, nil, time.Since(entryTime).Seconds())
+	}
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+	if resp.Type == "error" {
+		return output.ExitError, nil, fmt.Errorf("VM %s: %s", name, resp.Error)
+	}
+	if resp.Exec == nil {
+		return output.ExitError, nil, fmt.Errorf("no exec result from VM %s", name)
+	}
+	if cfg.Verbose {
+		fmt.Fprintf(cfg.Stderr, "Exec in VM %s completed in %dms\n", name, time.Since(entryTime).Milliseconds())
+	}
+
+	exitCode, jsonResult, err := formatVsockResponse(cfg, resp.Exec, resp.Version, entryTime, resp.Exec.ExitCode, nil)
+	if jsonResult != nil && cfg.Session != "" {
+		jsonResult["session"] = name
+	} else if jsonResult != nil {
+		jsonResult["instance"] = name
+	}
+	return exitCode, jsonResult, err
+}
+
+// startSession starts the named VM of session cfg.Session, with the
+// snapshot and network of cfg, unless it is up already, and waits until it
+// serves. A session that is up must have been started with the same.
+func startSession(cfg *ExecConfig, version, dhHome string) error {
+	vmPaths := vm.NewVMPaths(dhHome)
+	if vm.NamedVMProbe(vmPaths, cfg.Session) {
+		resp, err := vm.NamedVMCommand(vmPaths, cfg.Session, &vm.PoolRequest{Type: "status"})
+		if err != nil {
+			return err
+		}
+		if s := resp.Named; s != nil && (s.Profile != cfg.Profile || s.Net != cfg.Net || s.Jailed != cfg.Jailed) {
+			return fmt.Errorf("session %s was started with another --profile, --jailed or --net; end it with 'dh vm session kill %s' to change them", cfg.Session, cfg.Session)
+		}
+		return nil
+	}
+
+	key := vm.SnapshotKey(version, cfg.Profile)
+	if err := vm.CheckSnapshot(vmPaths, key); err != nil {
+		return err
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("getting executable path: %w", err)
+	}
+	args := []string{"vm", "up", "--foreground",
+		"--name", cfg.Session,
+		"--version", version,
+		"--idle-timeout", vm.SessionIdleTimeout.String(),
+	}
+	if cfg.Profile != "" {
+		args = append(args, "--profile", cfg.Profile)
+	}
+	if cfg.Jailed {
+		args = append(args, "--jailed")
+	}
+	if cfg.Net != "" {
+		args = append(args, "--net", cfg.Net)
+	}
+	if cfg.Verbose {
+		fmt.Fprintf(cfg.Stderr, "Starting session %s (version %s)...\n", cfg.Session, key)
+		args = append(args, "-v")
+	}
+
+	cmd := exec.Command(exePath, args...)
+	cmd.Env = os.Environ()
+	if dhHome != "" {
+		cmd.Env = append(cmd.Env, "DH_HOME="+dhHome)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := os.MkdirAll(vmPaths.NamedDir, 0o700); err != nil {
+		return err
+	}
+	logPath := vmPaths.NamedLog(cfg.Session)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening session log: %w", err)
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	err = cmd.Start()
+	logFile.Close()
+	if err != nil {
+		return fmt.Errorf("starting session %s: %w", cfg.Session, err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	// Another exec may start the same session at the same time; its VM
+	// serving is as good as ours.
+	deadline := time.After(30 * time.Second)
+	for !vm.NamedVMProbe(vmPaths, cfg.Session) {
+		select {
+		case <-exited:
+			if vm.NamedVMProbe(vmPaths, cfg.Session) {
+				return nil
+			}
+			log, _ := os.ReadFile(logPath)
+			return fmt.Errorf("session %s failed to start:\n%s", cfg.Session, log)
+		case <-deadline:
+			return fmt.Errorf("session %s is not serving after 30s; check %s", cfg.Session, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
 // autoStartPool forks a pool daemon in the background.
 func autoStartPool(dhHome, version string, verbose bool) {
 	exePath, err := os.Executable()
@@ -319,21 +628,101 @@ func autoStartPool(dhHome, version string, verbose bool) {
 	logFile.Close()
 }
 
+// vsockMountMode is the runner's mount_mode for --mount-mode: empty for
+// the LD_PRELOAD library, which every runner supports.
+func vsockMountMode(mode string) string {
+	if mode == "fuse" {
+		return mode
+	}
+	return ""
+}
+
+// vsockTableFormat is the runner's table_format for --table-format: empty
+// for text previews, which every runner sends.
+func vsockTableFormat(format string) string {
+	if format == "arrow" {
+		return format
+	}
+	return ""
+}
+
+// saveArrowTables writes each table to dir as NAME.arrow.
+func saveArrowTables(dir string, tables []vm.ArrowTable) error {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("--table-dir: %w", err)
+	}
+	for _, t := range tables {
+		name := t.Name + ".arrow"
+		if !filepath.IsLocal(name) || strings.ContainsRune(t.Name, filepath.Separator) {
+			return fmt.Errorf("invalid table name %q", t.Name)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), t.Data, 0o644); err != nil {
+			return fmt.Errorf("saving table %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
 // formatVsockResponse formats and prints the VsockResponse output.
 // Returns (exitCode, jsonResult, error) suitable for returning from runVM.
 func formatVsockResponse(cfg *ExecConfig, resp *vm.VsockResponse, version string, entryTime time.Time, exitCode int, jsonResult map[string]any) (int, map[string]any, error) {
+	resp.ExitCode = vsockExitCode(resp)
+	if exitCode == output.ExitError {
+		exitCode = resp.ExitCode
+	}
+	// Runners in older snapshots don't time the tables
+	cfg.timing.ScriptMs = runnerMillis(resp.Timing["run_script_ms"])
+	cfg.timing.TablesMs = runnerMillis(resp.Timing["tables_ms"])
+	if cfg.Verbose && resp.Runner != nil {
+		if resp.Runner.Protocol == 0 {
+			fmt.Fprintf(cfg.Stderr, "Runner predates protocol negotiation; newer features are sent on trust (rebuild with 'dh vm clean --version %s' and 'dh vm prepare --version %s')\n", version, version)
+		} else {
+			fmt.Fprintf(cfg.Stderr, "Runner protocol %d (features: %s)\n", resp.Runner.Protocol, strings.Join(resp.Runner.Features, ", "))
+		}
+	}
+	if cfg.MountMode == "fuse" && resp.MountMode != "fuse" {
+		fmt.Fprintf(cfg.Stderr, "Warning: the runner in this snapshot has no FUSE mount, so /workspace was served by the LD_PRELOAD library; rebuild it with 'dh vm clean --version %s' and 'dh vm prepare --version %s'\n", version, version)
+	}
+	if cfg.CollectOutputs != "" && resp.Outputs == nil {
+		fmt.Fprintf(cfg.Stderr, "Warning: the runner in this snapshot can't send back output files; rebuild it with 'dh vm clean --version %s' and 'dh vm prepare --version %s'\n", version, version)
+	}
+	previewOpts := cmp.Or(cfg.TableOutput, "pretty") != "pretty" || cfg.MaxRows != nil || cfg.MaxCols > 0
+	if previewOpts && resp.Runner != nil && !resp.Runner.Supports(vm.FeatureTableOutput) {
+		fmt.Fprintf(cfg.Stderr, "Warning: the runner in this snapshot ignores --table-output, --max-rows and --max-cols; rebuild it with 'dh vm clean --version %s' and 'dh vm prepare --version %s'\n", version, version)
+	}
+	arrow := cfg.TableFormat == "arrow" && resp.ArrowTables != nil
+	if cfg.TableFormat == "arrow" && resp.ArrowTables == nil && !resp.Interrupted {
+		fmt.Fprintf(cfg.Stderr, "Warning: the runner in this snapshot can't send Arrow tables; rebuild it with 'dh vm clean --version %s' and 'dh vm prepare --version %s'\n", version, version)
+	}
+
+	exported, err := saveExportedTables(cfg.TableExports, resp.ExportedTables)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+
 	if jsonResult != nil {
+		if arrow {
+			jsonResult["arrow_tables"] = resp.ArrowTables
+		}
+		if len(cfg.TableExports) > 0 {
+			jsonResult["exported_tables"] = append([]map[string]any{}, exported...)
+		}
 		return exitCode, jsonResult, nil
 	}
 
 	if cfg.JSONMode {
 		elapsed := time.Since(entryTime).Seconds()
+		exitCode := warningExitCode(cfg, resp.ExitCode, resp.Warnings)
 		jsonResult := map[string]any{
-			"exit_code":       resp.ExitCode,
+			"exit_code":       exitCode,
 			"stdout":          resp.Stdout,
 			"stderr":          resp.Stderr,
 			"result_repr":     resp.ResultRepr,
 			"error":           resp.Error,
+			"warnings":        warningsOrEmpty(resp.Warnings),
 			"tables":          resp.Tables,
 			"version":         version,
 			"vm_mode":         true,
@@ -342,31 +731,64 @@ func formatVsockResponse(cfg *ExecConfig, resp *vm.VsockResponse, version string
 		if resp.Timing != nil {
 			jsonResult["_timing"] = resp.Timing
 		}
-		return resp.ExitCode, jsonResult, nil
+		if cfg.CollectOutputs != "" {
+			jsonResult["outputs"] = append([]vm.OutputFile{}, resp.Outputs...)
+		}
+		if arrow {
+			jsonResult["arrow_tables"] = resp.ArrowTables
+		}
+		if len(cfg.TableExports) > 0 {
+			jsonResult["exported_tables"] = append([]map[string]any{}, exported...)
+		}
+		return exitCode, jsonResult, nil
 	}
 
-	// Normal mode: print output directly
+	// Normal mode: print output directly, unless it was streamed already
 	if resp.Stdout != "" {
-		fmt.Fprint(cfg.Stdout, resp.Stdout)
+		if !resp.Streamed {
+			fmt.Fprint(cfg.Stdout, resp.Stdout)
+		}
 		if !strings.HasSuffix(resp.Stdout, "\n") {
 			fmt.Fprintln(cfg.Stdout)
 		}
 	}
 
 	if resp.Stderr != "" {
-		fmt.Fprint(cfg.Stderr, resp.Stderr)
+		if !resp.Streamed {
+			fmt.Fprint(cfg.Stderr, resp.Stderr)
+		}
 		if !strings.HasSuffix(resp.Stderr, "\n") {
 			fmt.Fprintln(cfg.Stderr)
 		}
 	}
 
+	printWarnings(cfg.Stderr, resp.Warnings)
+
+	if len(resp.Outputs) > 0 {
+		fmt.Fprintf(cfg.Stderr, "Saved %d output file(s) to %s\n", len(resp.Outputs), cfg.CollectOutputs)
+	}
+	if len(resp.ArrowTables) > 0 {
+		if err := saveArrowTables(cfg.TableDir, resp.ArrowTables); err != nil {
+			return output.ExitError, nil, err
+		}
+		fmt.Fprintf(cfg.Stderr, "Saved %d Arrow table(s) to %s\n", len(resp.ArrowTables), cmp.Or(cfg.TableDir, "."))
+	}
+	for _, e := range exported {
+		fmt.Fprintf(cfg.Stderr, "Exported table %s to %s\n", e["name"], e["path"])
+	}
+
 	if resp.ResultRepr != nil && *resp.ResultRepr != "None" {
 		fmt.Fprintln(cfg.Stdout, *resp.ResultRepr)
 	}
 
+	if resp.Interrupted {
+		fmt.Fprintln(cfg.Stderr, "Interrupted.")
+		return output.ExitInterrupted, nil, nil
+	}
+
 	if resp.Error != nil && *resp.Error != "" {
 		fmt.Fprintln(cfg.Stderr, *resp.Error)
-		return 1, nil, nil
+		return cmp.Or(resp.ExitCode, output.ExitError), nil, nil
 	}
 
 	// Print table previews
@@ -393,5 +815,40 @@ func formatVsockResponse(cfg *ExecConfig, resp *vm.VsockResponse, version string
 		}
 	}
 
-	return exitCode, nil, nil
+	return warningExitCode(cfg, exitCode, resp.Warnings), nil, nil
+}
+
+// vmTimeout reports a --vm run stopped by the timeout msg after elapsed
+// seconds, with the output resp has so far, if any.
+func vmTimeout(cfg *ExecConfig, msg, version string, resp *vm.VsockResponse, elapsed float64) (int, map[string]any, error) {
+	var stdout, stderr string
+	if resp != nil {
+		stdout, stderr = resp.Stdout, resp.Stderr
+	}
+	if cfg.JSONMode {
+		return output.ExitTimeout, map[string]any{
+			"exit_code":       output.ExitTimeout,
+			"stdout":          stdout,
+			"stderr":          stderr,
+			"result_repr":     nil,
+			"error":           msg,
+			"warnings":        []any{},
+			"tables":          []any{},
+			"version":         version,
+			"vm_mode":         true,
+			"elapsed_seconds": elapsed,
+		}, nil
+	}
+	fmt.Fprintf(cfg.Stderr, "Error: %s\n", msg)
+	return output.ExitTimeout, nil, nil
+}
+
+// vsockExitCode returns the exit code of resp, with ExitAssertion for a
+// script that raised AssertionError: the runner in the guest exits 1 for
+// every exception.
+func vsockExitCode(resp *vm.VsockResponse) int {
+	if resp.ExitCode == output.ExitError && resp.Error != nil && isAssertion(*resp.Error) {
+		return output.ExitAssertion
+	}
+	return resp.ExitCode
 }
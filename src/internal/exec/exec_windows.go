@@ -4,8 +4,11 @@ package exec
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"syscall"
+
+	"golang.org/x/sys/windows"
 )
 
 // processGroupAttr returns SysProcAttr to create a new process group on Windows.
@@ -18,3 +21,32 @@ func killProcessGroup(pid int) error {
 	cmd := exec.Command("taskkill", "/F", "/T", "/PID", fmt.Sprintf("%d", pid))
 	return cmd.Run()
 }
+
+// interruptProcessGroup kills the process tree: Windows has no SIGINT to
+// send to another console's processes.
+func interruptProcessGroup(pid int) error {
+	return killProcessGroup(pid)
+}
+
+// lockFile blocks until an exclusive lock is held on f.
+func lockFile(f *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol)
+}
+
+// tryLockFile attempts an exclusive lock on f without blocking.
+// Returns false if another process holds the lock.
+func tryLockFile(f *os.File) (bool, error) {
+	ol := new(windows.Overlapped)
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockFile releases the lock on f.
+func unlockFile(f *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
@@ -0,0 +1,64 @@
+package exec
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// Kinds of failure, reported as the error_type of a --json result so CI
+// can tell a script that found wrong data from a flaky server without
+// reading the traceback.
+const (
+	ErrorTypeAssertion   = "assertion"    // the code raised AssertionError; ExitAssertion
+	ErrorTypeScript      = "script_error" // the code raised another exception; ExitError
+	ErrorTypeWarnings    = "warnings"     // --fail-on-warning and the code warned; ExitError
+	ErrorTypeDrift       = "drift"        // --replay found drift; ExitError
+	ErrorTypeConnection  = "connection"   // the server could not be reached or went away; ExitNetwork
+	ErrorTypeTimeout     = "timeout"      // --timeout passed; ExitTimeout
+	ErrorTypeInterrupted = "interrupted"  // Ctrl+C; ExitInterrupted
+	ErrorTypeError       = "error"        // dh failed around the code, e.g. reading an --import-table file; ExitError
+)
+
+// assertionLine matches the last line of a traceback of AssertionError,
+// or of a Java or Groovy assertion error.
+var assertionLine = regexp.MustCompile(`^([\w$]+\.)*\w*AssertionError\b`)
+
+// isAssertion reports whether errText, the traceback of the exception a
+// script raised, is of an AssertionError.
+func isAssertion(errText string) bool {
+	lines := strings.Split(strings.TrimSpace(errText), "\n")
+	return assertionLine.MatchString(strings.TrimSpace(lines[len(lines)-1]))
+}
+
+// errorType names the kind of failure of a run that exited with exitCode
+// and produced result, for its error_type. The runner names it when it
+// can; results from Go and from runners in VM snapshots are told apart by
+// exit code, error and warnings.
+func errorType(exitCode int, result map[string]any) any {
+	switch exitCode {
+	case output.ExitSuccess:
+		return nil
+	case output.ExitAssertion:
+		return ErrorTypeAssertion
+	case output.ExitNetwork:
+		return ErrorTypeConnection
+	case output.ExitTimeout:
+		return ErrorTypeTimeout
+	case output.ExitInterrupted:
+		return ErrorTypeInterrupted
+	}
+	if t, ok := result["error_type"].(string); ok && t != "" {
+		return t
+	}
+	errText, _ := result["error"].(string)
+	warnings, _ := result["warnings"].([]any)
+	switch {
+	case errText == "" && len(warnings) > 0:
+		return ErrorTypeWarnings
+	case strings.Contains(errText, "Traceback (most recent call last)"):
+		return ErrorTypeScript
+	}
+	return ErrorTypeError
+}
@@ -0,0 +1,192 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// RunHosts runs the code of cfg on each of hosts, up to parallel at a time
+// (0 for all at once), and returns the highest exit code among them.
+// configure points each host's copy of cfg at its server: cfg.Host is the
+// host as given, for configure to resolve. Each host's stderr is written
+// to cfg.Stderr as it comes, with the host in front of every line. Its
+// stdout is written to cfg.Stdout when it finishes, under a header; with
+// --json the result instead has one entry per host, in the order given.
+func RunHosts(cfg *ExecConfig, hosts []string, parallel int, configure func(c *ExecConfig) error) (int, map[string]any, error) {
+	runStart := time.Now()
+	if cfg.Stderr == nil {
+		cfg.Stderr = os.Stderr
+	}
+	if cfg.Stdout == nil {
+		cfg.Stdout = os.Stdout
+	}
+
+	switch {
+	case len(hosts) == 0:
+		return output.ExitError, nil, fmt.Errorf("no hosts to run on")
+	case cfg.VMMode:
+		return output.ExitError, nil, fmt.Errorf("--vm can't be used with several hosts")
+	case cfg.JSONStream:
+		return output.ExitError, nil, fmt.Errorf("--json-stream can't be used with several hosts; use --json")
+	case cfg.Record != "" || cfg.Replay != "":
+		return output.ExitError, nil, fmt.Errorf("--record and --replay run on one server")
+	case len(cfg.ExportTables) > 0:
+		return output.ExitError, nil, fmt.Errorf("--export-table can't be used with several hosts: each would write the same files")
+	}
+
+	// Every host runs the same code, so stdin and remote code are read once
+	if err := FetchRemoteCode(cfg); err != nil {
+		return output.ExitError, nil, err
+	}
+	if cfg.ScriptPath == "-" {
+		code, err := readCode(cfg)
+		if err != nil {
+			return output.ExitError, nil, err
+		}
+		cfg.Code, cfg.ScriptPath = code, ""
+	}
+
+	configs := make([]*ExecConfig, len(hosts))
+	for i, h := range hosts {
+		c := *cfg
+		c.Host = h
+		c.Annotate = false
+		if err := configure(&c); err != nil {
+			return output.ExitError, nil, fmt.Errorf("%s: %w", h, err)
+		}
+		configs[i] = &c
+	}
+
+	if parallel <= 0 || parallel > len(hosts) {
+		parallel = len(hosts)
+	}
+	var (
+		mu      sync.Mutex // serializes writes to cfg.Stdout and cfg.Stderr
+		wg      sync.WaitGroup
+		slots   = make(chan struct{}, parallel)
+		results = make([]map[string]any, len(hosts))
+		codes   = make([]int, len(hosts))
+	)
+	for i, c := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			host := hosts[i]
+			var stdout bytes.Buffer
+			stderr := &prefixWriter{prefix: "[" + host + "] ", w: cfg.Stderr, mu: &mu}
+			c.Stdout, c.Stderr = &stdout, stderr
+			start := time.Now()
+			exitCode, result, err := Run(c)
+			stderr.flush()
+			if err != nil && exitCode == output.ExitSuccess {
+				exitCode = output.ExitError
+			}
+			codes[i] = exitCode
+
+			if cfg.JSONMode {
+				if result == nil {
+					result = map[string]any{"exit_code": exitCode}
+				}
+				if err != nil {
+					result["error"] = err.Error()
+				}
+				result["error_type"] = errorType(exitCode, result)
+				result["host"] = host
+				results[i] = result
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			status := fmt.Sprintf("exit %d", exitCode)
+			if err != nil {
+				status += ": " + err.Error()
+			}
+			fmt.Fprintf(cfg.Stdout, "==> %s (%s, %.2fs) <==\n", host, status, time.Since(start).Seconds())
+			cfg.Stdout.Write(stdout.Bytes())
+			if stdout.Len() > 0 && !bytes.HasSuffix(stdout.Bytes(), []byte("\n")) {
+				fmt.Fprintln(cfg.Stdout)
+			}
+		}()
+	}
+	wg.Wait()
+
+	worst, worstHost := output.ExitSuccess, 0
+	var failed []string
+	for i, code := range codes {
+		if code > worst {
+			worst, worstHost = code, i
+		}
+		if code != output.ExitSuccess {
+			failed = append(failed, fmt.Sprintf("%s (exit %d)", hosts[i], code))
+		}
+	}
+
+	if cfg.JSONMode {
+		return worst, map[string]any{
+			"exit_code":       worst,
+			"error_type":      results[worstHost]["error_type"],
+			"hosts":           results,
+			"failed":          len(failed),
+			"elapsed_seconds": time.Since(runStart).Seconds(),
+		}, nil
+	}
+	if !cfg.Quiet {
+		summary := fmt.Sprintf("%d of %d hosts succeeded", len(hosts)-len(failed), len(hosts))
+		if len(failed) > 0 {
+			summary += "; failed: " + strings.Join(failed, ", ")
+		}
+		fmt.Fprintf(cfg.Stderr, "── %s in %.2fs ──\n", summary, time.Since(runStart).Seconds())
+	}
+	return worst, nil, nil
+}
+
+// prefixWriter writes each line to w with prefix in front, holding back
+// an unfinished line until it ends or flush.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	p.writeLines(p.buf[:i+1])
+	p.buf = p.buf[i+1:]
+	return len(b), nil
+}
+
+// flush writes what is left of an unfinished line.
+func (p *prefixWriter) flush() {
+	if len(p.buf) > 0 {
+		p.writeLines(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLines(lines []byte) {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) > 0 {
+			out.WriteString(p.prefix)
+			out.Write(line)
+		}
+	}
+	p.mu.Lock()
+	p.w.Write(out.Bytes())
+	p.mu.Unlock()
+}
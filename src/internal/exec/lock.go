@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// versionLockName is the lock file created inside a version directory to
+// serialize concurrent dh exec invocations against that version.
+const versionLockName = "exec.lock"
+
+// lockVersion takes an exclusive lock on the version directory, waiting if
+// another dh exec holds it. The returned unlock func is safe to call more
+// than once, and from several goroutines.
+func lockVersion(dhHome, version string, quiet bool, stderr io.Writer) (func(), error) {
+	lockPath := filepath.Join(dhHome, "versions", version, versionLockName)
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock %s: %w", lockPath, err)
+	}
+
+	locked, err := tryLockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("acquiring lock %s: %w", lockPath, err)
+	}
+	if !locked {
+		if !quiet && stderr != nil {
+			fmt.Fprintf(stderr, "Waiting for another dh exec using version %s...\n", version)
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("acquiring lock %s: %w", lockPath, err)
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			unlockFile(f)
+			f.Close()
+		})
+	}, nil
+}
+
+// unlockWhenExists calls unlock once path exists, unless done is closed
+// first.
+func unlockWhenExists(path string, unlock func(), done <-chan struct{}) {
+	go func() {
+		tick := time.NewTicker(50 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				if _, err := os.Stat(path); err == nil {
+					unlock()
+					return
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,110 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// isNotebook reports whether path is a Jupyter notebook.
+func isNotebook(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".ipynb")
+}
+
+// readNotebook reads the code cells of the notebook at path, in order and,
+// with tags, only those with at least one of them. It returns the cells
+// joined into one script, with IPython magics and shell escapes commented
+// out, and where each cell is in it.
+func readNotebook(path string, tags []string) (string, []codePart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading notebook %s: %w", path, err)
+	}
+	var nb struct {
+		Metadata struct {
+			Kernelspec struct {
+				Language string `json:"language"`
+			} `json:"kernelspec"`
+			LanguageInfo struct {
+				Name string `json:"name"`
+			} `json:"language_info"`
+		} `json:"metadata"`
+		Cells []struct {
+			CellType string          `json:"cell_type"`
+			Source   json.RawMessage `json:"source"`
+			Metadata struct {
+				Tags []string `json:"tags"`
+			} `json:"metadata"`
+		} `json:"cells"`
+	}
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return "", nil, fmt.Errorf("reading notebook %s: %w", path, err)
+	}
+	for _, lang := range []string{nb.Metadata.LanguageInfo.Name, nb.Metadata.Kernelspec.Language} {
+		if lang != "" && !strings.EqualFold(lang, "python") {
+			return "", nil, fmt.Errorf("%s is a %s notebook: dh exec runs Python notebooks", path, lang)
+		}
+	}
+
+	var b strings.Builder
+	var cells []codePart
+	line := 1
+	for i, c := range nb.Cells {
+		if c.CellType != "code" {
+			continue
+		}
+		if len(tags) > 0 && !slices.ContainsFunc(c.Metadata.Tags, func(t string) bool { return slices.Contains(tags, t) }) {
+			continue
+		}
+		source, err := cellSource(c.Source)
+		if err != nil {
+			return "", nil, fmt.Errorf("reading notebook %s: cell %d: %w", path, i+1, err)
+		}
+		if strings.TrimSpace(source) == "" {
+			continue
+		}
+		cell := appendPart(&b, commentMagics(source), line)
+		cell.Index = i + 1
+		cells = append(cells, cell)
+		line += cell.Lines
+	}
+	if len(cells) == 0 && len(tags) > 0 {
+		return "", nil, fmt.Errorf("no code cells in %s are tagged %s", path, strings.Join(tags, " or "))
+	}
+	return b.String(), cells, nil
+}
+
+// cellSource decodes the source of a cell, which nbformat stores as a
+// string or as a list of lines.
+func cellSource(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return "", fmt.Errorf("invalid source: %w", err)
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// commentMagics comments out the lines of source that only IPython runs:
+// line magics (%) and shell escapes (!), or every line of a cell magic
+// (%%) cell. Line numbers are kept.
+func commentMagics(source string) string {
+	lines := strings.SplitAfter(source, "\n")
+	cellMagic := strings.HasPrefix(strings.TrimSpace(source), "%%")
+	for i, l := range lines {
+		t := strings.TrimLeft(l, " \t")
+		if cellMagic && t != "" || strings.HasPrefix(t, "%") || strings.HasPrefix(t, "!") {
+			lines[i] = "# " + l
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,52 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// codePart locates a part of the script dh runs, a code cell of a
+// notebook or one of several script files, in the script made by joining
+// them: the runner splits the script back into parts to run them one by
+// one in the same session and report on each.
+type codePart struct {
+	Index int    `json:"index"`          // position in the notebook or among the scripts, from 1
+	Path  string `json:"path,omitempty"` // absolute path of a script file
+	Line  int    `json:"line"`           // first line in the joined script, from 1
+	Lines int    `json:"lines"`
+}
+
+// appendPart adds source to the script in b, ending it with a newline, and
+// returns where it is, with line the first line it starts on.
+func appendPart(b *strings.Builder, source string, line int) codePart {
+	if !strings.HasSuffix(source, "\n") {
+		source += "\n"
+	}
+	b.WriteString(source)
+	return codePart{Line: line, Lines: strings.Count(source, "\n")}
+}
+
+// readScripts reads the script files dh exec runs one after another, as
+// one script, and where each is in it.
+func readScripts(paths []string) (string, []codePart, error) {
+	var b strings.Builder
+	var parts []codePart
+	line := 1
+	for i, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return "", nil, fmt.Errorf("reading script file %s: %w", p, err)
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", nil, err
+		}
+		part := appendPart(&b, string(data), line)
+		part.Index, part.Path = i+1, abs
+		parts = append(parts, part)
+		line += part.Lines
+	}
+	return b.String(), parts, nil
+}
@@ -0,0 +1,43 @@
+package exec
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// ResolvePort returns the port an embedded server should listen on: port
+// itself if it is free, otherwise (and always for port 0) a free port
+// picked by the OS. The probe binds on all interfaces, as the server does,
+// so a port taken on any of them is not chosen.
+func ResolvePort(port int) (int, error) {
+	if port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %d", port)
+	}
+	if port > 0 {
+		if l, err := net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
+			l.Close()
+			return port, nil
+		}
+	}
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, fmt.Errorf("finding a free port: %w", err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// ReportPort tells the user when the server uses a port other than the
+// one requested; requested is 0 when --port 0 asked for any free port.
+func ReportPort(requested, port int, verbose, quiet bool, w io.Writer) {
+	switch {
+	case requested == port || quiet:
+	case requested == 0:
+		if verbose {
+			fmt.Fprintf(w, "Using port %d\n", port)
+		}
+	default:
+		fmt.Fprintf(w, "Port %d is in use; using port %d\n", requested, port)
+	}
+}
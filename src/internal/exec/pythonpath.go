@@ -0,0 +1,110 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// resolvePythonPath combines --pythonpath dirs with exec.pythonpath from
+// the effective config (flag entries first), resolving each to an absolute
+// path. Relative entries resolve against the current directory, or the
+// project root for entries from a project config. Every entry must
+// be an existing directory; duplicates are dropped.
+func resolvePythonPath(flagDirs []string) ([]string, error) {
+	dirs := append([]string{}, flagDirs...)
+	if cfg, _, err := config.LoadEffective(); err == nil {
+		dirs = append(dirs, cfg.Exec.PythonPath...)
+	}
+
+	var resolved []string
+	seen := make(map[string]bool, len(dirs))
+	for _, d := range dirs {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		abs, err := filepath.Abs(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pythonpath entry %q: %w", d, err)
+		}
+		fi, err := os.Stat(abs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pythonpath entry %q: %w", d, err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("invalid pythonpath entry %q: not a directory", d)
+		}
+		if seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		resolved = append(resolved, abs)
+	}
+	return resolved, nil
+}
+
+// pythonPathEnv returns the PYTHONPATH value with dirs prepended to any
+// existing value from the environment.
+func pythonPathEnv(dirs []string) string {
+	parts := append([]string{}, dirs...)
+	if existing := os.Getenv("PYTHONPATH"); existing != "" {
+		parts = append(parts, existing)
+	}
+	return strings.Join(parts, string(os.PathListSeparator))
+}
+
+// guestPythonPath maps host pythonpath dirs to their paths inside the VM.
+// Dirs under cwd or an existing mount reuse that root; any other dir is
+// served through an extra read-only mount, returned alongside the paths.
+func guestPythonPath(dirs []string, cwd string, mounts []vm.Mount) ([]string, []vm.Mount) {
+	return guestDirs(dirs, cwd, mounts, ".pythonpath")
+}
+
+// guestDirs maps host dirs to their paths inside the VM as
+// guestPythonPath does, naming the extra mounts aliasPrefix-N.
+func guestDirs(dirs []string, cwd string, mounts []vm.Mount, aliasPrefix string) ([]string, []vm.Mount) {
+	var guest []string
+	var extra []vm.Mount
+	for _, d := range dirs {
+		if p, ok := guestPathUnder(d, cwd, "/workspace"); ok {
+			guest = append(guest, p)
+			continue
+		}
+		found := false
+		for _, m := range append(append([]vm.Mount{}, mounts...), extra...) {
+			if p, ok := guestPathUnder(d, m.HostPath, "/workspace/"+m.Alias); ok {
+				guest = append(guest, p)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		// Hidden alias so the mount never shadows a workspace entry.
+		m := vm.Mount{HostPath: d, Alias: fmt.Sprintf("%s-%d", aliasPrefix, len(extra)), ReadOnly: true}
+		extra = append(extra, m)
+		guest = append(guest, "/workspace/"+m.Alias)
+	}
+	return guest, extra
+}
+
+// guestPathUnder returns the guest path for hostPath if it lies under root.
+func guestPathUnder(hostPath, root, guestRoot string) (string, bool) {
+	if root == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, hostPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return guestRoot, true
+	}
+	return guestRoot + "/" + filepath.ToSlash(rel), true
+}
@@ -0,0 +1,331 @@
+package exec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// Recording is the golden output of a run saved by --record and compared
+// by --replay. Only output that should stay stable across Deephaven
+// upgrades is kept: timing, versions and warnings are left out.
+type Recording struct {
+	ExitCode int             `json:"exit_code"`
+	Stdout   string          `json:"stdout"`
+	Tables   []RecordedTable `json:"tables"`
+}
+
+// RecordedTable is the schema and content fingerprint of one table.
+type RecordedTable struct {
+	Name         string           `json:"name"`
+	Columns      []RecordedColumn `json:"columns"`
+	RowCount     int64            `json:"row_count"`
+	IsRefreshing bool             `json:"is_refreshing"`
+	ContentHash  string           `json:"content_hash,omitempty"`
+	HashError    string           `json:"content_hash_error,omitempty"` // why there is no ContentHash
+}
+
+// RecordedColumn is a column name and its Arrow type.
+type RecordedColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// RecordingPath returns the golden file for a run: <dir>/<script>.json for
+// script files, <dir>/exec.json for -c code and stdin.
+func RecordingPath(dir, scriptPath string) string {
+	name := "exec"
+	if scriptPath != "" && scriptPath != "-" {
+		base := filepath.Base(scriptPath)
+		name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return filepath.Join(dir, name+".json")
+}
+
+// runRecorded runs cfg with table hashing and JSON capture forced on, then
+// saves the result (--record) or diffs it against the saved one (--replay).
+// A replay that drifts from its recording fails with ExitError.
+func runRecorded(cfg *ExecConfig) (int, map[string]any, error) {
+	inner := *cfg
+	inner.Record, inner.Replay = "", ""
+	inner.Annotate = false
+	inner.JSONMode = true
+	inner.ShowTables = true
+	inner.HashTables = true
+
+	exitCode, result, err := Run(&inner)
+	if err != nil || result == nil {
+		return exitCode, result, err
+	}
+	// Only record runs that reached the script; connection failures,
+	// timeouts and interrupts are passed through untouched.
+	if exitCode != output.ExitSuccess && exitCode != output.ExitError && exitCode != output.ExitAssertion {
+		if !cfg.JSONMode {
+			printResult(cfg, result)
+		}
+		return exitCode, result, nil
+	}
+
+	rec := RecordingFromResult(result)
+	if cfg.Record != "" {
+		path := RecordingPath(cfg.Record, cfg.ScriptPath)
+		if err := writeRecording(path, rec); err != nil {
+			return output.ExitError, nil, err
+		}
+		result["recording"] = path
+		if !cfg.JSONMode {
+			printResult(cfg, result)
+			if !cfg.Quiet {
+				fmt.Fprintf(cfg.Stderr, "Recorded to %s\n", path)
+			}
+		}
+		if cfg.Annotate {
+			annotateResult(cfg, result, "", nil)
+		}
+		return exitCode, jsonOrNil(cfg, result), nil
+	}
+
+	path := RecordingPath(cfg.Replay, cfg.ScriptPath)
+	golden, err := readRecording(path)
+	if err != nil {
+		return output.ExitError, nil, err
+	}
+	drift := diffRecordings(golden, rec)
+	if len(drift) > 0 && exitCode == output.ExitSuccess {
+		exitCode = output.ExitError
+		result["error_type"] = ErrorTypeDrift
+	}
+	result["exit_code"] = exitCode
+	result["replay"] = map[string]any{
+		"recording": path,
+		"drift":     append([]string{}, drift...),
+	}
+	if !cfg.JSONMode {
+		printResult(cfg, result)
+		if len(drift) > 0 {
+			fmt.Fprintf(cfg.Stderr, "Replay drifted from %s:\n", path)
+			for _, d := range drift {
+				fmt.Fprintf(cfg.Stderr, "  %s\n", d)
+			}
+		} else if !cfg.Quiet {
+			fmt.Fprintf(cfg.Stderr, "Replay matches %s\n", path)
+		}
+	}
+	if cfg.Annotate {
+		annotateResult(cfg, result, path, drift)
+	}
+	return exitCode, jsonOrNil(cfg, result), nil
+}
+
+func jsonOrNil(cfg *ExecConfig, result map[string]any) map[string]any {
+	if cfg.JSONMode {
+		return result
+	}
+	return nil
+}
+
+// RecordingFromResult extracts the recorded fields from a JSON run result.
+func RecordingFromResult(result map[string]any) Recording {
+	rec := Recording{Tables: []RecordedTable{}}
+	rec.ExitCode = toInt(result["exit_code"])
+	rec.Stdout, _ = result["stdout"].(string)
+	tables, _ := result["tables"].([]any)
+	for _, t := range tables {
+		m, ok := t.(map[string]any)
+		if !ok {
+			continue
+		}
+		rt := RecordedTable{Columns: []RecordedColumn{}}
+		rt.Name, _ = m["name"].(string)
+		rt.RowCount = int64(toInt(m["row_count"]))
+		rt.IsRefreshing, _ = m["is_refreshing"].(bool)
+		rt.ContentHash, _ = m["content_hash"].(string)
+		rt.HashError, _ = m["content_hash_error"].(string)
+		cols, _ := m["columns"].([]any)
+		for _, c := range cols {
+			cm, ok := c.(map[string]any)
+			if !ok {
+				continue
+			}
+			name, _ := cm["name"].(string)
+			typ, _ := cm["type"].(string)
+			rt.Columns = append(rt.Columns, RecordedColumn{Name: name, Type: typ})
+		}
+		rec.Tables = append(rec.Tables, rt)
+	}
+	// VM runs report tables in no particular order.
+	sort.Slice(rec.Tables, func(i, j int) bool { return rec.Tables[i].Name < rec.Tables[j].Name })
+	return rec
+}
+
+func toInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func writeRecording(path string, rec Recording) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("creating recording dir: %w", err)
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling recording: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing recording: %w", err)
+	}
+	return nil
+}
+
+func readRecording(path string) (Recording, error) {
+	var rec Recording
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rec, fmt.Errorf("no recording at %s (create one with --record)", path)
+		}
+		return rec, fmt.Errorf("reading recording: %w", err)
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("parsing recording %s: %w", path, err)
+	}
+	return rec, nil
+}
+
+// diffRecordings describes each difference between a recording and a new
+// run, or returns nil when they match. Contents of refreshing tables are
+// not compared since they change from run to run.
+func diffRecordings(golden, actual Recording) []string {
+	var drift []string
+	if golden.ExitCode != actual.ExitCode {
+		drift = append(drift, fmt.Sprintf("exit code: recorded %d, got %d", golden.ExitCode, actual.ExitCode))
+	}
+	if d := diffLines(golden.Stdout, actual.Stdout); d != "" {
+		drift = append(drift, "stdout "+d)
+	}
+
+	got := make(map[string]RecordedTable, len(actual.Tables))
+	for _, t := range actual.Tables {
+		got[t.Name] = t
+	}
+	for _, want := range golden.Tables {
+		have, ok := got[want.Name]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("table %s: missing", want.Name))
+			continue
+		}
+		delete(got, want.Name)
+		if w, h := formatColumns(want.Columns), formatColumns(have.Columns); w != h {
+			drift = append(drift, fmt.Sprintf("table %s: schema: recorded %s, got %s", want.Name, w, h))
+			continue
+		}
+		if want.IsRefreshing || have.IsRefreshing {
+			continue
+		}
+		if want.RowCount != have.RowCount {
+			drift = append(drift, fmt.Sprintf("table %s: rows: recorded %d, got %d", want.Name, want.RowCount, have.RowCount))
+		} else if have.HashError != "" && want.HashError == "" {
+			drift = append(drift, fmt.Sprintf("table %s: content can't be hashed: %s", want.Name, have.HashError))
+		} else if want.ContentHash != have.ContentHash {
+			drift = append(drift, fmt.Sprintf("table %s: content changed", want.Name))
+		}
+	}
+	var added []string
+	for name := range got {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		drift = append(drift, fmt.Sprintf("table %s: not in recording", name))
+	}
+	return drift
+}
+
+func formatColumns(cols []RecordedColumn) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = fmt.Sprintf("%s (%s)", c.Name, c.Type)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// diffLines reports the first differing line between two texts, or "" when
+// they are equal.
+func diffLines(want, got string) string {
+	if want == got {
+		return ""
+	}
+	w := strings.Split(want, "\n")
+	g := strings.Split(got, "\n")
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var wl, gl string
+		if i < len(w) {
+			wl = w[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if i >= len(w) || i >= len(g) || wl != gl {
+			return fmt.Sprintf("line %d: recorded %q, got %q", i+1, wl, gl)
+		}
+	}
+	return "differs"
+}
+
+// printResult writes a JSON run result the way normal (non-JSON) mode
+// would have printed it.
+func printResult(cfg *ExecConfig, result map[string]any) {
+	if stdout, _ := result["stdout"].(string); stdout != "" {
+		fmt.Fprint(cfg.Stdout, stdout)
+		if !strings.HasSuffix(stdout, "\n") {
+			fmt.Fprintln(cfg.Stdout)
+		}
+	}
+	if stderr, _ := result["stderr"].(string); stderr != "" {
+		fmt.Fprint(cfg.Stderr, stderr)
+		if !strings.HasSuffix(stderr, "\n") {
+			fmt.Fprintln(cfg.Stderr)
+		}
+	}
+	warnings, _ := result["warnings"].([]any)
+	printWarnings(cfg.Stderr, warnings)
+	if repr, ok := result["result_repr"].(string); ok && repr != "None" {
+		fmt.Fprintln(cfg.Stdout, repr)
+	}
+	if cfg.ShowTables {
+		tables, _ := result["tables"].([]any)
+		for _, t := range tables {
+			m, ok := t.(map[string]any)
+			if !ok {
+				continue
+			}
+			name, _ := m["name"].(string)
+			preview, _ := m["preview"].(string)
+			if cfg.ShowTableMeta {
+				status := "static"
+				if refreshing, _ := m["is_refreshing"].(bool); refreshing {
+					status = "refreshing"
+				}
+				fmt.Fprintf(cfg.Stdout, "\n=== Table: %s (%d rows, %s) ===\n", name, toInt(m["row_count"]), status)
+			} else {
+				fmt.Fprintf(cfg.Stdout, "\n=== Table: %s ===\n", name)
+			}
+			fmt.Fprintln(cfg.Stdout, preview)
+		}
+	}
+	if errText, _ := result["error"].(string); errText != "" {
+		fmt.Fprintln(cfg.Stderr, errText)
+	}
+}
@@ -0,0 +1,225 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// DefaultMaxRemoteKB is how much code FetchRemoteCode reads from a URL or
+// git ref unless --max-remote-kb says otherwise.
+const DefaultMaxRemoteKB = 1024
+
+// remoteFetchTimeout bounds the download of a URL script.
+const remoteFetchTimeout = 30 * time.Second
+
+// IsURL reports whether the script path is an http or https URL.
+func IsURL(scriptPath string) bool {
+	return strings.HasPrefix(scriptPath, "https://") || strings.HasPrefix(scriptPath, "http://")
+}
+
+// ParseGitSpec splits a --git spec, REPO@REF:PATH, into its parts. REPO
+// can be a URL, an scp-style address (git@host:org/repo.git) or a local
+// repository; refs can't contain ':', so the first ':' after the '@' of
+// the ref ends it. A REPO or REF starting with '-' is refused, as git
+// would take it for an option.
+func ParseGitSpec(spec string) (repo, ref, file string, err error) {
+	for i := strings.LastIndex(spec, "@"); i > 0; i = strings.LastIndex(spec[:i], "@") {
+		ref, file, ok := strings.Cut(spec[i+1:], ":")
+		if !ok || ref == "" || file == "" {
+			continue
+		}
+		if strings.HasPrefix(spec, "-") || strings.HasPrefix(ref, "-") {
+			return "", "", "", fmt.Errorf("invalid --git %q: REPO and REF can't start with '-'", spec)
+		}
+		return spec[:i], ref, strings.TrimPrefix(file, "/"), nil
+	}
+	return "", "", "", fmt.Errorf("invalid --git %q: must be REPO@REF:PATH", spec)
+}
+
+// FetchRemoteCode reads the script at an http(s) URL given as
+// cfg.ScriptPath, or at the --git ref cfg.Git, into cfg.Code, so the run
+// treats it as -c code. It does nothing for local code. Code is only
+// fetched with cfg.AllowRemoteCode, and refused when it is larger than
+// cfg.MaxRemoteKB. Run calls it; callers that log or share the code call
+// it first.
+func FetchRemoteCode(cfg *ExecConfig) error {
+	if cfg.Git == "" && !IsURL(cfg.ScriptPath) {
+		return nil
+	}
+	source := cfg.Git
+	if source == "" {
+		source = cfg.ScriptPath
+	} else if cfg.Code != "" || cfg.ScriptPath != "" {
+		return fmt.Errorf("cannot use --git with -c or a script file")
+	}
+	switch {
+	case cfg.Code != "":
+		return fmt.Errorf("cannot use both -c and a script file")
+	case len(cfg.ExtraScripts) > 0:
+		return fmt.Errorf("code from a URL or --git can't be one of several scripts")
+	case !cfg.AllowRemoteCode:
+		return fmt.Errorf("running code from %s needs --allow-remote-code: dh runs whatever it fetches, unreviewed", source)
+	case cfg.MaxRemoteKB < 0:
+		return fmt.Errorf("--max-remote-kb must be 0 or more")
+	}
+	maxBytes := int64(cfg.MaxRemoteKB) * 1024
+	if maxBytes == 0 {
+		maxBytes = DefaultMaxRemoteKB * 1024
+	}
+
+	name := source
+	var data []byte
+	var err error
+	if cfg.Git != "" {
+		var repo, ref string
+		if repo, ref, name, err = ParseGitSpec(cfg.Git); err != nil {
+			return err
+		}
+		data, err = fetchGit(repo, ref, name, maxBytes)
+	} else {
+		data, err = fetchURL(cfg.ScriptPath, maxBytes)
+		if p := strings.IndexAny(name, "?#"); p >= 0 {
+			name = name[:p]
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if isNotebook(name) {
+		return fmt.Errorf("%s is a notebook: dh exec runs notebooks from local files only", source)
+	}
+	if cfg.Language == "" && strings.EqualFold(path.Ext(name), ".groovy") {
+		cfg.Language = "groovy"
+	}
+	output.Tracef("fetched %d bytes of code from %s", len(data), source)
+	cfg.Code, cfg.ScriptPath, cfg.Git = string(data), "", ""
+	return nil
+}
+
+// fetchURL downloads the script at url, refusing it when it is larger
+// than maxBytes.
+func fetchURL(url string, maxBytes int64) ([]byte, error) {
+	if err := checkPlainHTTP(url); err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout: remoteFetchTimeout,
+		// Every hop is checked, so https can't redirect to plain http.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return checkPlainHTTP(req.URL.String())
+		},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: HTTP %d", url, resp.StatusCode)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, remoteTooLarge(url, maxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, remoteTooLarge(url, maxBytes)
+	}
+	return data, nil
+}
+
+// checkPlainHTTP refuses an http:// URL of a host other than the local
+// one: code about to run is not fetched where anyone on the way can
+// change it.
+func checkPlainHTTP(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	if u.Scheme != "http" {
+		return nil
+	}
+	if host := u.Hostname(); host == "localhost" {
+		return nil
+	} else if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("refusing to run code fetched over plain http from %s: use https", u.Host)
+}
+
+// fetchGit reads file at ref of repo, refusing it when it is larger than
+// maxBytes. A local repository is read in place; any other is fetched,
+// at depth 1, into a temporary one.
+func fetchGit(repo, ref, file string, maxBytes int64) ([]byte, error) {
+	source := fmt.Sprintf("%s@%s:%s", repo, ref, file)
+	dir, object := repo, ref+":"+file
+	if info, err := os.Stat(repo); err != nil || !info.IsDir() {
+		dir, err = os.MkdirTemp("", "dh-git-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if _, err := git(dir, "init", "-q"); err != nil {
+			return nil, err
+		}
+		if _, err := git(dir, "fetch", "-q", "--depth", "1", "--", repo, ref); err != nil {
+			return nil, fmt.Errorf("fetching %s from %s: %w", ref, repo, err)
+		}
+		object = "FETCH_HEAD:" + file
+	}
+
+	out, err := git(dir, "cat-file", "-s", "--", object)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", source, err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: unexpected size %q", source, out)
+	}
+	if size > maxBytes {
+		return nil, remoteTooLarge(source, maxBytes)
+	}
+	data, err := git(dir, "cat-file", "blob", "--", object)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", source, err)
+	}
+	return data, nil
+}
+
+// git runs git with args in dir and returns its stdout, or an error with
+// its stderr. The ext:: transport, which runs a command of the URL's
+// choosing, is turned off whatever the user's git config says.
+func git(dir string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := ExecCommand("git", append([]string{"-c", "protocol.ext.allow=never", "-C", dir}, args...)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	output.TraceCommand(cmd)
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func remoteTooLarge(source string, maxBytes int64) error {
+	return fmt.Errorf("%s is larger than the --max-remote-kb limit of %d KB", source, maxBytes/1024)
+}
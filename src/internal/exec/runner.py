@@ -7,8 +7,10 @@ import base64
 import json
 import os
 import pickle
+import re
 import sys
 import textwrap
+import threading
 
 
 # --- AST helpers (ported from executor.py) ---
@@ -46,8 +48,13 @@ def _extract_names(target) -> set[str]:
 
 # --- Wrapper script builder (ported from executor.py) ---
 
-def build_wrapper(code: str, script_path: str | None = None, cwd: str | None = None) -> str:
-    """Build the wrapper script that captures output and creates result table."""
+def build_wrapper(code: str, script_path: str | None = None, cwd: str | None = None,
+                  stream_file: str | None = None) -> str:
+    """Build the wrapper script that captures output and creates result table.
+
+    With stream_file, output is also appended to it as ["stdout"|"stderr",
+    text] lines as it is written.
+    """
     code_repr = repr(code)
     lines: list[str] = []
 
@@ -62,15 +69,53 @@ def build_wrapper(code: str, script_path: str | None = None, cwd: str | None = N
     lines.append("import sys as __dh_sys")
     lines.append("import pickle as __dh_pickle")
     lines.append("import base64 as __dh_base64")
+    lines.append("import warnings as __dh_warnings")
     lines.append("")
     lines.append("__dh_stdout_buf = __dh_io.StringIO()")
     lines.append("__dh_stderr_buf = __dh_io.StringIO()")
     lines.append("__dh_orig_stdout = __dh_sys.stdout")
     lines.append("__dh_orig_stderr = __dh_sys.stderr")
-    lines.append("__dh_sys.stdout = __dh_stdout_buf")
-    lines.append("__dh_sys.stderr = __dh_stderr_buf")
+    if stream_file is not None:
+        # Names inside the class body avoid a __ prefix, which Python
+        # would mangle.
+        lines.append("import json as __dh_json")
+        lines.append(f"__dh_stream_f = open({stream_file!r}, 'a', buffering=1)")
+        lines.append("class __DhStreamTee(__dh_io.TextIOBase):")
+        lines.append("    def __init__(self, name, buf, out, dumps):")
+        lines.append("        self.name, self.buf, self.out, self.dumps = name, buf, out, dumps")
+        lines.append("    def writable(self):")
+        lines.append("        return True")
+        lines.append("    def write(self, s):")
+        lines.append("        self.buf.write(s)")
+        lines.append("        self.out.write(self.dumps([self.name, s]) + '\\n')")
+        lines.append("        return len(s)")
+        lines.append('__dh_sys.stdout = __DhStreamTee("stdout", __dh_stdout_buf, __dh_stream_f, __dh_json.dumps)')
+        lines.append('__dh_sys.stderr = __DhStreamTee("stderr", __dh_stderr_buf, __dh_stream_f, __dh_json.dumps)')
+    else:
+        lines.append("__dh_sys.stdout = __dh_stdout_buf")
+        lines.append("__dh_sys.stderr = __dh_stderr_buf")
     lines.append("__dh_result = None")
     lines.append("__dh_error = None")
+    lines.append("__dh_error_type = None")
+    # Record warnings separately from stderr so they can be reported
+    # (and optionally fail the run) on their own channel.
+    lines.append("__dh_warn_ctx = __dh_warnings.catch_warnings(record=True)")
+    lines.append("__dh_warn_list = __dh_warn_ctx.__enter__()")
+    lines.append('__dh_warnings.simplefilter("default")')
+    # Warnings the server logs while the code runs (Python logging records
+    # at WARNING and above, from Deephaven's modules or the code itself)
+    # are reported too, apart from the warnings module's by their source.
+    lines.append("import logging as __dh_logging")
+    lines.append("class __DhLogRecorder(__dh_logging.Handler):")
+    lines.append("    def emit(self, record):")
+    lines.append("        try:")
+    lines.append('            self.records.append({"source": "server_log", "category": record.name, "level": record.levelname, '
+                 '"message": record.getMessage(), "filename": record.pathname, "lineno": record.lineno})')
+    lines.append("        except Exception:")
+    lines.append("            self.handleError(record)")
+    lines.append("__dh_log_handler = __DhLogRecorder(__dh_logging.WARNING)")
+    lines.append("__dh_log_handler.records = []")
+    lines.append("__dh_logging.getLogger().addHandler(__dh_log_handler)")
     lines.append("")
     lines.append("try:")
     lines.append("    try:")
@@ -80,9 +125,15 @@ def build_wrapper(code: str, script_path: str | None = None, cwd: str | None = N
     lines.append("except Exception as __dh_e:")
     lines.append("    import traceback as __dh_tb")
     lines.append("    __dh_error = __dh_tb.format_exc()")
+    lines.append('    __dh_error_type = "assertion" if isinstance(__dh_e, AssertionError) else "script_error"')
     lines.append("finally:")
+    lines.append("    __dh_warn_ctx.__exit__(None, None, None)")
+    lines.append("    __dh_logging.getLogger().removeHandler(__dh_log_handler)")
     lines.append("    __dh_sys.stdout = __dh_orig_stdout")
     lines.append("    __dh_sys.stderr = __dh_orig_stderr")
+    if stream_file is not None:
+        lines.append("    __dh_stream_f.close()")
+        lines.append("    del __dh_stream_f, __DhStreamTee, __dh_json")
     if cwd is not None:
         lines.append("    __dh_os.chdir(__dh_orig_cwd)")
     lines.append("")
@@ -91,6 +142,10 @@ def build_wrapper(code: str, script_path: str | None = None, cwd: str | None = N
     lines.append('    "stderr": __dh_stderr_buf.getvalue(),')
     lines.append('    "result_repr": repr(__dh_result) if __dh_result is not None else None,')
     lines.append('    "error": __dh_error,')
+    lines.append('    "error_type": __dh_error_type,')
+    lines.append('    "warnings": [{"source": "python", "category": __dh_w.category.__name__, "message": str(__dh_w.message), '
+                 '"filename": __dh_w.filename, "lineno": __dh_w.lineno} for __dh_w in __dh_warn_list]'
+                 ' + __dh_log_handler.records,')
     lines.append("}")
     lines.append(
         '__dh_pickled = __dh_base64.b64encode('
@@ -103,13 +158,61 @@ def build_wrapper(code: str, script_path: str | None = None, cwd: str | None = N
     lines.append("")
     if cwd is not None:
         lines.append("del __dh_os, __dh_orig_cwd")
-    lines.append("del __dh_io, __dh_sys, __dh_pickle, __dh_base64")
+    lines.append("del __dh_io, __dh_sys, __dh_pickle, __dh_base64, __dh_warnings")
     lines.append("del __dh_stdout_buf, __dh_stderr_buf, __dh_orig_stdout, __dh_orig_stderr")
-    lines.append("del __dh_result, __dh_error, __dh_results_dict, __dh_pickled, __dh_empty_table")
+    lines.append("del __dh_warn_ctx, __dh_warn_list, __dh_logging, __DhLogRecorder, __dh_log_handler")
+    lines.append("del __dh_result, __dh_error, __dh_error_type, __dh_results_dict, __dh_pickled, __dh_empty_table")
 
     return "\n".join(lines)
 
 
+# --- Groovy (dh exec --language groovy) ---
+
+# Run around Groovy code. println in a Groovy script writes to the
+# binding's "out" when there is one, so pointing it at a StringWriter
+# captures what the code prints.
+GROOVY_CAPTURE_START = textwrap.dedent("""\
+    __dh_sw = new StringWriter()
+    out = new PrintWriter(__dh_sw, true)
+""")
+GROOVY_CAPTURE_END = textwrap.dedent("""\
+    out.flush()
+    __dh_result_table = emptyTable(1).update("data = `" + java.util.Base64.getEncoder().encodeToString(__dh_sw.toString().getBytes("UTF-8")) + "`")
+    binding.removeVariable("out")
+    binding.removeVariable("__dh_sw")
+""")
+
+_GROOVY_ASSIGNMENT = re.compile(r"^\s*(?:final\s+)?(?:[A-Z][\w.]*(?:<[^>]*>)?\s+)?([A-Za-z_$][\w$]*)\s*=(?!=)", re.MULTILINE)
+
+
+def get_groovy_assigned_names(code: str) -> set[str]:
+    """Return the names Groovy code assigns at the start of a line. Only
+    those without def end up in the binding, which session.tables lists."""
+    return set(_GROOVY_ASSIGNMENT.findall(code))
+
+
+def run_groovy(session, code: str) -> dict:
+    """Run Groovy code, capturing what it prints. Returns the dict
+    read_result_table would: Groovy scripts have no result value, and
+    what they write to System.err goes to the server log."""
+    session.run_script(GROOVY_CAPTURE_START)
+    error = error_type = None
+    try:
+        session.run_script(code)
+    except Exception as e:
+        error = str(e)
+        # assert fails with PowerAssertionError, throw with any exception
+        error_type = "assertion" if "AssertionError" in error else "script_error"
+    try:
+        session.run_script(GROOVY_CAPTURE_END)
+        df = session.open_table("__dh_result_table").to_arrow().to_pandas()
+        stdout = base64.b64decode(df.iloc[0]["data"].encode("ascii")).decode("utf-8")
+        session.run_script('binding.removeVariable("__dh_result_table")')
+    except Exception as e:
+        return {"error": error or f"Failed to read results: {e}"}
+    return {"stdout": stdout, "stderr": "", "result_repr": None, "error": error, "error_type": error_type, "warnings": []}
+
+
 # --- Result reading (ported from executor.py) ---
 
 def read_result_table(session) -> dict:
@@ -127,9 +230,50 @@ def read_result_table(session) -> dict:
     return {}
 
 
+# --- Content hashing (for dh exec --record/--replay) ---
+
+def _table_content_hash(arrow_table):
+    """Return a sha256 hex digest of a table's row values, independent of
+    Arrow metadata so it stays stable across Deephaven upgrades."""
+    import hashlib
+    import pandas as pd
+    digest = hashlib.sha256()
+    if arrow_table.num_rows > 0:
+        df = arrow_table.to_pandas()
+        digest.update(pd.util.hash_pandas_object(df, index=False).values.tobytes())
+    return digest.hexdigest()
+
+
 # --- Table preview (ported from executor.py) ---
 
-def get_table_preview(session, name: str, show_meta: bool = True) -> dict | None:
+def _markdown_table(df) -> str:
+    """Render df as a GitHub-flavored Markdown table."""
+    def cell(v) -> str:
+        return str(v).replace("|", "\\|").replace("\n", " ")
+    lines = ["| " + " | ".join(cell(c) for c in df.columns) + " |",
+             "|" + "|".join(" --- " for _ in df.columns) + "|"]
+    for row in df.itertuples(index=False):
+        lines.append("| " + " | ".join(cell(v) for v in row) + " |")
+    return "\n".join(lines)
+
+
+def format_preview(df, table_output: str) -> str:
+    """Render the preview rows df in the --table-output format."""
+    if table_output == "csv":
+        return df.to_csv(index=False).rstrip("\n")
+    if table_output == "markdown":
+        return _markdown_table(df)
+    if table_output == "html":
+        return df.to_html(index=False)
+    if table_output == "json":
+        return df.to_json(orient="records", date_format="iso")
+    if len(df) == 0:
+        return "(empty table)"
+    return df.to_string(index=False)
+
+
+def get_table_preview(session, name: str, show_meta: bool = True, hash_content: bool = False,
+                      table_output: str = "pretty", max_rows: int = 10, max_cols: int = 0) -> dict | None:
     """Get table metadata and preview string. Returns dict or None on error."""
     try:
         table = session.open_table(name)
@@ -140,7 +284,8 @@ def get_table_preview(session, name: str, show_meta: bool = True) -> dict | None
         columns = [{"name": field.name, "type": str(field.type)} for field in schema]
 
         lines = []
-        if show_meta:
+        # The column list would not parse as the other formats.
+        if show_meta and table_output == "pretty":
             col_info = ", ".join(f"{c['name']} ({c['type']})" for c in columns)
             if len(f"Columns: {col_info}") > 80:
                 lines.append("Columns:")
@@ -150,23 +295,114 @@ def get_table_preview(session, name: str, show_meta: bool = True) -> dict | None
                 lines.append(f"Columns: {col_info}")
             lines.append("")
 
-        if total_rows == 0:
-            lines.append("(empty table)")
-        else:
-            preview_df = arrow_table.slice(0, 10).to_pandas()
-            lines.append(preview_df.to_string(index=False))
+        preview_table = arrow_table.slice(0, max_rows) if max_rows else arrow_table
+        if max_cols and preview_table.num_columns > max_cols:
+            preview_table = preview_table.select(range(max_cols))
+        preview_df = preview_table.to_pandas()
+        lines.append(format_preview(preview_df, table_output))
 
-        return {
+        info = {
             "name": name,
             "row_count": total_rows,
             "is_refreshing": is_refreshing,
             "columns": columns,
             "preview": "\n".join(lines),
         }
+        if table_output == "json":
+            info["rows"] = json.loads(lines[-1])
+        if hash_content:
+            # A table whose content can't be hashed is still reported,
+            # with the reason, rather than dropped.
+            try:
+                info["content_hash"] = _table_content_hash(arrow_table)
+            except Exception as e:
+                info["content_hash"] = None
+                info["content_hash_error"] = f"{type(e).__name__}: {e}"
+        return info
     except Exception:
         return None
 
 
+# --- Table imports (dh exec --import-table) ---
+
+def import_tables(session, imports) -> str | None:
+    """Read each local file of imports and upload it to the server as a
+    table bound to its name, so remote servers get it too. Returns an
+    error message, or None."""
+    for imp in imports:
+        name, path = imp["name"], imp["path"]
+        try:
+            if imp.get("format") == "parquet":
+                import pyarrow.parquet as pq
+                arrow_table = pq.read_table(path)
+            else:
+                import pyarrow.csv as pa_csv
+                arrow_table = pa_csv.read_csv(path)
+            session.bind_table(name, session.import_table(arrow_table))
+        except Exception as e:
+            return f"--import-table {name}: reading {path}: {e}"
+    return None
+
+
+# --- Table exports (dh exec --export-table) ---
+
+def export_tables(session, exports) -> tuple[list, str | None]:
+    """Fetch each table of exports over Arrow Flight and write it to its
+    local path, so remote servers' tables land here too. Returns the
+    [{"name", "path", "size"}] written, and an error message or None."""
+    written = []
+    for exp in exports:
+        name, path = exp["name"], exp["path"]
+        try:
+            arrow_table = session.open_table(name).to_arrow()
+        except Exception:
+            return written, f"--export-table {name}: no table {name} in scope"
+        try:
+            os.makedirs(os.path.dirname(path), exist_ok=True)
+            fmt = exp.get("format")
+            if fmt == "parquet":
+                import pyarrow.parquet as pq
+                pq.write_table(arrow_table, path)
+            elif fmt == "csv":
+                import pyarrow.csv as pa_csv
+                pa_csv.write_csv(arrow_table, path)
+            else:
+                import pyarrow as pa
+                with pa.OSFile(path, "wb") as sink:
+                    with pa.ipc.new_stream(sink, arrow_table.schema) as writer:
+                        writer.write_table(arrow_table)
+        except Exception as e:
+            return written, f"--export-table {name}: writing {path}: {e}"
+        written.append({"name": name, "path": path, "size": os.path.getsize(path)})
+    return written, None
+
+
+# --- Output streaming (dh exec --json-stream) ---
+
+def forward_stream(path: str, out, done) -> None:
+    """Write lines appended to path to out as {"type": "stdout"|"stderr",
+    "data"} frames until done is set and the file has been read to the
+    end. out must be the real stdout: while the script runs in the
+    embedded server, which shares this interpreter, sys.stdout is its
+    tee."""
+    pending = b""
+    with open(path, "rb") as f:
+        while True:
+            finished = done.is_set()
+            chunk = f.read()
+            if chunk:
+                pending += chunk
+                *complete, pending = pending.split(b"\n")
+                for line in complete:
+                    name, data = json.loads(line)
+                    out.write(json.dumps({"type": name, "data": data}) + "\n")
+                    out.flush()
+            elif finished:
+                return
+            else:
+                done.wait(0.05)
+
+
 # --- Cleanup ---
 
 def cleanup_result_table(session):
@@ -184,28 +420,28 @@ def cleanup_result_table(session):
 
 # --- Execution modes ---
 
-def _is_port_available(port: int) -> bool:
-    """Check if a port is available for binding."""
+def _free_port(port: int) -> int:
+    """Return port if it is free on all interfaces, else a free port picked by the OS."""
     import socket
-    if port == 0:
-        return True
     with socket.socket(socket.AF_INET, socket.SOCK_STREAM) as s:
-        try:
-            s.bind(('localhost', port))
-            return True
-        except OSError:
-            return False
+        if port:
+            try:
+                s.bind(('', port))
+                return port
+            except OSError:
+                pass
+        s.bind(('', 0))
+        return s.getsockname()[1]
 
 
 def run_embedded(args, code: str):
     """Start an embedded server, connect, execute, return results."""
     from deephaven_server import Server
 
-    # Check port availability and fall back to auto-assign if needed
-    port_to_use = args.port
-    if not _is_port_available(port_to_use):
-        print(f"Port {port_to_use} is in use, finding available port...", file=sys.stderr)
-        port_to_use = 0
+    # dh normally passes a free port; check again in case it was taken since
+    port_to_use = _free_port(args.port)
+    if port_to_use != args.port and args.port:
+        print(f"Port {args.port} is in use; using port {port_to_use}", file=sys.stderr)
 
     # Suppress JVM/server output
     original_stdout_fd = os.dup(1)
@@ -254,10 +490,104 @@ def run_remote(args, code: str):
     if args.tls_client_key:
         with open(args.tls_client_key, "rb") as f:
             kwargs["client_private_key"] = f.read()
+    if args.language != "python":
+        kwargs["session_type"] = args.language
 
     return _execute_on_server(args.host, args.port, args, code, **kwargs)
 
 
+def run_python(session, args, code: str, script_path: str | None = None) -> dict | None:
+    """Run Python code in the wrapper and return its results, with
+    __file__ set to script_path, or else --script-path. With
+    --stream-output, only passed for the embedded server, the script's
+    output is forwarded as it is written. Returns None, having reported
+    the error, when the wrapper itself fails."""
+    stream_file = None
+    if args.stream_output:
+        import tempfile
+        fd, stream_file = tempfile.mkstemp(prefix="dh-stream-", suffix=".ndjson")
+        os.close(fd)
+    wrapper = build_wrapper(code, script_path=script_path or args.script_path, cwd=args.cwd, stream_file=stream_file)
+
+    forwarder = None
+    done = threading.Event()
+    if stream_file is not None:
+        forwarder = threading.Thread(target=forward_stream, args=(stream_file, sys.stdout, done), daemon=True)
+        forwarder.start()
+    try:
+        session.run_script(wrapper)
+    except Exception as e:
+        _emit_error(args, str(e), exit_code=1)
+        return None
+    finally:
+        done.set()
+        if forwarder is not None:
+            forwarder.join()
+            os.remove(stream_file)
+
+    result = read_result_table(session)
+    cleanup_result_table(session)
+    return result
+
+
+def split_parts(code: str, parts: list) -> list[str]:
+    """Split the script dh joined from a notebook's cells or several
+    script files back into them."""
+    lines = code.split("\n")
+    return ["\n".join(lines[p["line"] - 1:p["line"] - 1 + p["lines"]]) + "\n" for p in parts]
+
+
+def run_parts(session, args, code: str, parts: list) -> dict | None:
+    """Run the parts of code one after another in the session, stopping
+    at the first that fails. Returns what run_python does for all of
+    them, with a "parts" list of each part's index, or path for a script
+    file, stdout, stderr, result_repr, error and elapsed_seconds."""
+    import time
+
+    merged = {"stdout": "", "stderr": "", "result_repr": None, "error": None, "warnings": [], "parts": []}
+    for part, source in zip(parts, split_parts(code, parts)):
+        start = time.monotonic()
+        result = run_python(session, args, source, script_path=part.get("path"))
+        if result is None:
+            return None
+        merged["stdout"] += result.get("stdout", "")
+        merged["stderr"] += result.get("stderr", "")
+        merged["result_repr"] = result.get("result_repr")
+        merged["warnings"].extend(result.get("warnings") or [])
+        info = {"path": part["path"]} if part.get("path") else {"index": part["index"]}
+        info.update({
+            "stdout": result.get("stdout", ""),
+            "stderr": result.get("stderr", ""),
+            "result_repr": result.get("result_repr"),
+            "error": result.get("error"),
+            "elapsed_seconds": time.monotonic() - start,
+        })
+        merged["parts"].append(info)
+        if result.get("error"):
+            where = part["path"] if part.get("path") else f"cell {part['index']}"
+            merged["error"] = f"Error in {where}:\n{result['error']}"
+            merged["error_type"] = result.get("error_type")
+            break
+    return merged
+
+
+# Run before the code on a server daemon's server, which earlier runs have
+# used: the first time it records the names in scope, and after that it
+# deletes every name that wasn't there, so a run sees none of what runs
+# before it left behind.
+RESET_SCOPE_SCRIPT = """
+def __dh_reset_scope(g):
+    baseline = g.get("__dh_scope_baseline")
+    if baseline is None:
+        g["__dh_scope_baseline"] = frozenset(g) | {"__dh_scope_baseline"}
+        return
+    for name in [n for n in g if n not in baseline]:
+        del g[name]
+__dh_reset_scope(globals())
+del __dh_reset_scope
+"""
+
+
 def _execute_on_server(host: str, port: int, args, code: str, **session_kwargs):
     """Connect to server at host:port, execute code, return exit code."""
     import time
@@ -278,25 +608,45 @@ def _execute_on_server(host: str, port: int, args, code: str, **session_kwargs):
     if session is None:
         _emit_error(args, f"Failed to connect to {host}:{port}: {last_err}", exit_code=2)
         return 2
+    if args.ready_file:
+        # Tells dh the connect phase is over, for --exec-timeout
+        open(args.ready_file, "w").close()
+    # For the timing of --json: dh counts the server's start up to here
+    connected_at = time.time()
 
     try:
-        # Get assigned names from user code
-        assigned_names = get_assigned_names(code)
+        if args.reset_scope and args.language == "python":
+            try:
+                session.run_script(RESET_SCOPE_SCRIPT)
+            except Exception as e:
+                _emit_error(args, f"Failed to clear the server's scope: {e}", exit_code=1)
+                return 1
 
-        # Build and execute wrapper
-        wrapper = build_wrapper(code, script_path=args.script_path, cwd=args.cwd)
+        if args.import_tables:
+            err = import_tables(session, json.loads(args.import_tables))
+            if err:
+                _emit_error(args, err, exit_code=1)
+                return 1
 
-        try:
-            session.run_script(wrapper)
-        except Exception as e:
-            _emit_error(args, str(e), exit_code=1)
-            return 1
+        script_start = time.monotonic()
 
-        # Read results
-        result = read_result_table(session)
-        cleanup_result_table(session)
+        parts = json.loads(args.parts) if args.parts else []
+        if args.language == "groovy":
+            assigned_names = get_groovy_assigned_names(code)
+            result = run_groovy(session, code)
+        else:
+            if parts:
+                assigned_names = set().union(*(get_assigned_names(p) for p in split_parts(code, parts)))
+                result = run_parts(session, args, code, parts)
+            else:
+                assigned_names = get_assigned_names(code)
+                result = run_python(session, args, code)
+            if result is None:
+                return 1
+        script_ms = int((time.monotonic() - script_start) * 1000)
 
         # Find assigned tables
+        tables_start = time.monotonic()
         server_tables = set(session.tables) - {"__dh_result_table"}
         assigned_tables = [name for name in assigned_names if name in server_tables]
 
@@ -305,7 +655,8 @@ def _execute_on_server(host: str, port: int, args, code: str, **session_kwargs):
         tables_info = []
         if args.show_tables and assigned_tables:
             for tname in assigned_tables:
-                info = get_table_preview(session, tname, show_meta=show_meta)
+                info = get_table_preview(session, tname, show_meta=show_meta, hash_content=args.hash_tables,
+                                         table_output=args.table_output, max_rows=args.max_rows, max_cols=args.max_cols)
                 if info:
                     tables_info.append(info)
 
@@ -313,17 +664,37 @@ def _execute_on_server(host: str, port: int, args, code: str, **session_kwargs):
         stderr_text = result.get("stderr", "")
         result_repr = result.get("result_repr")
         error_text = result.get("error")
+        error_type = (result.get("error_type") or "error") if error_text else None
+        warnings_list = result.get("warnings") or []
+        exported_tables = []
+        if args.export_tables and not error_text:
+            exported_tables, export_err = export_tables(session, json.loads(args.export_tables))
+            if export_err:
+                error_text, error_type = export_err, "error"
+        tables_ms = int((time.monotonic() - tables_start) * 1000)
+        if not error_text and args.fail_on_warning and warnings_list:
+            error_type = "warnings"
+        # AssertionError exits 5, so CI can tell wrong data from other failures
+        exit_code = 0 if not error_type else 5 if error_type == "assertion" else 1
 
         if args.output_json:
             # JSON output mode
             output = {
-                "exit_code": 1 if error_text else 0,
+                "exit_code": exit_code,
                 "stdout": stdout_text,
                 "stderr": stderr_text,
                 "result_repr": result_repr,
                 "error": error_text,
+                "error_type": error_type,
+                "warnings": warnings_list,
                 "tables": tables_info,
+                "_timing": {"connected_at": connected_at, "script_ms": script_ms, "tables_ms": tables_ms},
             }
+            if args.export_tables:
+                output["exported_tables"] = exported_tables
+            if parts:
+                # Notebook cells, or several script files
+                output["files" if "path" in parts[0] else "cells"] = result["parts"]
             print(json.dumps(output))
         else:
             # Normal output mode
@@ -337,6 +708,8 @@ def _execute_on_server(host: str, port: int, args, code: str, **session_kwargs):
                 if not stderr_text.endswith("\n"):
                     print(file=sys.stderr)
 
+            _print_warnings(warnings_list)
+
             if result_repr is not None and result_repr != "None":
                 print(result_repr)
 
@@ -351,14 +724,16 @@ def _execute_on_server(host: str, port: int, args, code: str, **session_kwargs):
                         print(f"\n=== Table: {info['name']} ===")
                     print(info["preview"])
 
+            for exp in exported_tables:
+                print(f"Exported table {exp['name']} to {exp['path']}", file=sys.stderr)
+
             if error_text:
                 print(error_text, file=sys.stderr)
-                hint = _suggest_backtick_hint(code, error_text)
+                hint = _suggest_backtick_hint(code, error_text) if args.language == "python" else None
                 if hint:
                     print(hint, file=sys.stderr)
-                return 1
 
-        return 1 if error_text else 0
+        return exit_code
 
     except KeyboardInterrupt:
         print("\nInterrupted.", file=sys.stderr)
@@ -378,11 +753,10 @@ def run_serve(args, code: str):
     import time
     from deephaven_server import Server
 
-    # Check port availability and fall back to auto-assign if needed
-    port_to_use = args.port
-    if not _is_port_available(port_to_use):
-        print(f"Port {port_to_use} is in use, finding available port...", file=sys.stderr)
-        port_to_use = 0
+    # dh normally passes a free port; check again in case it was taken since
+    port_to_use = _free_port(args.port)
+    if port_to_use != args.port and args.port:
+        print(f"Port {args.port} is in use; using port {port_to_use}", file=sys.stderr)
 
     # Suppress JVM/server output
     original_stdout_fd = os.dup(1)
@@ -480,6 +854,18 @@ def _suggest_backtick_hint(script_content: str, error: str) -> str | None:
     return None
 
 
+def _print_warnings(warnings_list: list) -> None:
+    """Print captured warnings to stderr, dimmed when stderr is a terminal."""
+    if not warnings_list:
+        return
+    dim = sys.stderr.isatty() and not os.environ.get("NO_COLOR")
+    for w in warnings_list:
+        line = f"Warning: {w.get('category', 'Warning')}: {w.get('message', '')}"
+        if dim:
+            line = f"\x1b[2m{line}\x1b[0m"
+        print(line, file=sys.stderr)
+
+
 def _emit_error(args, message: str, exit_code: int):
     """Emit an error in the appropriate format."""
     if args.output_json:
@@ -489,6 +875,8 @@ def _emit_error(args, message: str, exit_code: int):
             "stderr": "",
             "result_repr": None,
             "error": message,
+            "error_type": "connection" if exit_code == 2 else "error",
+            "warnings": [],
             "tables": [],
         }
         print(json.dumps(output))
@@ -509,6 +897,16 @@ def main():
     parser.add_argument("--script-path", default=None)
     parser.add_argument("--cwd", default=None)
     parser.add_argument("--output-json", action="store_true")
+    parser.add_argument("--fail-on-warning", action="store_true")
+    parser.add_argument("--hash-tables", action="store_true")
+    parser.add_argument("--table-output", choices=["pretty", "csv", "markdown", "html", "json"], default="pretty")
+    parser.add_argument("--max-rows", type=int, default=10)
+    parser.add_argument("--max-cols", type=int, default=0)
+    parser.add_argument("--import-tables", default=None)
+    parser.add_argument("--export-tables", default=None)
+    parser.add_argument("--stream-output", action="store_true")
+    parser.add_argument("--language", choices=["python", "groovy"], default="python")
+    parser.add_argument("--parts", default=None)
     parser.add_argument("--auth-type", default=None)
     parser.add_argument("--auth-token", default=None)
     parser.add_argument("--tls", action="store_true")
@@ -516,6 +914,8 @@ def main():
     parser.add_argument("--tls-client-cert", default=None)
     parser.add_argument("--tls-client-key", default=None)
     parser.add_argument("--iframe", default=None)
+    parser.add_argument("--ready-file", default=None)
+    parser.add_argument("--reset-scope", action="store_true")
 
     args = parser.parse_args()
 
@@ -530,6 +930,7 @@ def main():
                 "stderr": "",
                 "result_repr": None,
                 "error": None,
+                "warnings": [],
                 "tables": [],
             }))
         sys.exit(0)
@@ -0,0 +1,142 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// eventWriter writes the NDJSON events of --json-stream, one object per
+// line with an "event" key: "started", "stdout", "stderr", "table",
+// "result" and "finished".
+type eventWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	streamed bool // stdout and stderr went out as the script wrote them
+}
+
+// emit writes one event with fields.
+func (e *eventWriter) emit(event string, fields map[string]any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	m := map[string]any{"event": event}
+	for k, v := range fields {
+		m[k] = v
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	e.w.Write(append(data, '\n'))
+}
+
+// output emits what the script wrote to stream, "stdout" or "stderr", as
+// it writes it.
+func (e *eventWriter) output(stream, data string) {
+	e.mu.Lock()
+	e.streamed = true
+	e.mu.Unlock()
+	e.emit(stream, map[string]any{"data": data})
+}
+
+// resultKeys are the keys of a JSON run result that runStreamed sends in
+// events of their own rather than in "finished".
+var resultKeys = map[string]bool{
+	"stdout": true, "stderr": true, "tables": true,
+	"exit_code": true, "result_repr": true, "error": true, "error_type": true, "warnings": true,
+}
+
+// runStreamed runs cfg as --json does and writes its result to Stdout as
+// events. Output arrives as the script writes it where the run can
+// stream it (the embedded server, and VMs restored for the run), and
+// otherwise in one event per stream when it ends.
+func runStreamed(cfg *ExecConfig) (int, map[string]any, error) {
+	start := time.Now()
+	ev := &eventWriter{w: cfg.Stdout}
+	mode := "embedded"
+	if cfg.VMMode {
+		mode = "vm"
+	} else if cfg.Host != "" {
+		mode = "remote"
+	}
+	ev.emit("started", map[string]any{"mode": mode, "script": cfg.ScriptPath})
+
+	run := *cfg
+	run.JSONStream = false
+	run.JSONMode = true
+	run.events = ev
+	exitCode, result, err := Run(&run)
+	elapsed := time.Since(start).Seconds()
+	if err != nil {
+		ev.emit("finished", map[string]any{"exit_code": exitCode, "error": err.Error(), "elapsed_seconds": elapsed})
+		return exitCode, nil, err
+	}
+
+	if !ev.streamed {
+		for _, stream := range []string{"stdout", "stderr"} {
+			if data, _ := result[stream].(string); data != "" {
+				ev.emit(stream, map[string]any{"data": data})
+			}
+		}
+	}
+	tables, _ := result["tables"].([]any)
+	warnings, _ := result["warnings"].([]any)
+	for _, t := range tables {
+		if m, ok := t.(map[string]any); ok {
+			ev.emit("table", m)
+		}
+	}
+	ev.emit("result", map[string]any{
+		"exit_code":   exitCode,
+		"result_repr": result["result_repr"],
+		"error":       result["error"],
+		"error_type":  result["error_type"],
+		"warnings":    warningsOrEmpty(warnings),
+	})
+	finished := map[string]any{"exit_code": exitCode, "elapsed_seconds": elapsed}
+	for k, v := range result {
+		if !resultKeys[k] && k != "elapsed_seconds" {
+			finished[k] = v
+		}
+	}
+	ev.emit("finished", finished)
+	return exitCode, nil, nil
+}
+
+// frameWriter passes the lines the runner writes to its stdout with
+// --stream-output: {"type": "stdout"|"stderr", "data"} frames go to
+// events, and everything else, the JSON result, to rest.
+type frameWriter struct {
+	events  *eventWriter
+	rest    *bytes.Buffer
+	pending []byte
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	f.pending = append(f.pending, p...)
+	for {
+		i := bytes.IndexByte(f.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := f.pending[:i+1]
+		var frame struct {
+			Type string `json:"type"`
+			Data string `json:"data"`
+		}
+		if json.Unmarshal(line, &frame) == nil && (frame.Type == "stdout" || frame.Type == "stderr") {
+			f.events.output(frame.Type, frame.Data)
+		} else {
+			f.rest.Write(line)
+		}
+		f.pending = f.pending[i+1:]
+	}
+}
+
+// flush passes on a last line without a newline.
+func (f *frameWriter) flush() {
+	f.rest.Write(f.pending)
+	f.pending = nil
+}
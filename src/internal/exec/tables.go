@@ -0,0 +1,153 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// tableNameRegexp matches the names --import-table binds tables to: Python
+// identifiers.
+var tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// tableFormats maps file extensions to the formats tables are read in.
+var tableFormats = map[string]string{
+	".csv":     "csv",
+	".parquet": "parquet",
+	".pq":      "parquet",
+}
+
+// exportFormats maps file extensions to the formats tables are written in.
+var exportFormats = map[string]string{
+	".parquet": "parquet",
+	".pq":      "parquet",
+	".csv":     "csv",
+	".arrow":   "arrow",
+}
+
+// ParseTableImports parses --import-table NAME=PATH specs, resolving each
+// path to an absolute one and its format from the extension. Every file
+// must exist, and every name be a Python identifier used once.
+func ParseTableImports(specs []string) ([]vm.TableImport, error) {
+	var imports []vm.TableImport
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		name, p, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || p == "" {
+			return nil, fmt.Errorf("invalid --import-table %q: use NAME=PATH", spec)
+		}
+		if !tableNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid --import-table name %q: use a Python identifier", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("--import-table %s given twice", name)
+		}
+		seen[name] = true
+		format, ok := tableFormats[strings.ToLower(filepath.Ext(p))]
+		if !ok {
+			return nil, fmt.Errorf("--import-table %s: %s is not a .csv or .parquet file", name, p)
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("--import-table %s: %w", name, err)
+		}
+		if fi, err := os.Stat(abs); err != nil {
+			return nil, fmt.Errorf("--import-table %s: %w", name, err)
+		} else if fi.IsDir() {
+			return nil, fmt.Errorf("--import-table %s: %s is a directory", name, p)
+		}
+		imports = append(imports, vm.TableImport{Name: name, Path: abs, Format: format})
+	}
+	return imports, nil
+}
+
+// guestTableImports maps the host paths of imports to their paths inside
+// the VM, as guestPythonPath does for dirs, returning the extra mounts
+// their dirs need.
+func guestTableImports(imports []vm.TableImport, cwd string, mounts []vm.Mount) ([]vm.TableImport, []vm.Mount) {
+	dirs := make([]string, len(imports))
+	for i, imp := range imports {
+		dirs[i] = filepath.Dir(imp.Path)
+	}
+	inGuest, extra := guestDirs(dirs, cwd, mounts, ".import")
+	guest := make([]vm.TableImport, len(imports))
+	for i, imp := range imports {
+		imp.Path = path.Join(inGuest[i], filepath.Base(imp.Path))
+		guest[i] = imp
+	}
+	return guest, extra
+}
+
+// ParseTableExports parses --export-table NAME=PATH specs, resolving each
+// path to an absolute one and its format from the extension. Every name
+// must be a Python identifier used once; the files need not exist yet.
+func ParseTableExports(specs []string) ([]vm.TableExport, error) {
+	var exports []vm.TableExport
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		name, p, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || p == "" {
+			return nil, fmt.Errorf("invalid --export-table %q: use NAME=PATH", spec)
+		}
+		if !tableNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid --export-table name %q: use a Python identifier", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("--export-table %s given twice", name)
+		}
+		seen[name] = true
+		format, ok := exportFormats[strings.ToLower(filepath.Ext(p))]
+		if !ok {
+			return nil, fmt.Errorf("--export-table %s: %s is not a .parquet, .csv or .arrow file", name, p)
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("--export-table %s: %w", name, err)
+		}
+		if fi, err := os.Stat(abs); err == nil && fi.IsDir() {
+			return nil, fmt.Errorf("--export-table %s: %s is a directory", name, p)
+		}
+		exports = append(exports, vm.TableExport{Name: name, Path: abs, Format: format})
+	}
+	return exports, nil
+}
+
+// vsockTableExports returns exports without their host paths, for VM
+// requests.
+func vsockTableExports(exports []vm.TableExport) []vm.TableExport {
+	var out []vm.TableExport
+	for _, e := range exports {
+		e.Path = ""
+		out = append(out, e)
+	}
+	return out
+}
+
+// saveExportedTables writes each table the runner sent back to the path
+// its --export-table gave.
+func saveExportedTables(exports []vm.TableExport, tables []vm.ExportedTable) ([]map[string]any, error) {
+	paths := map[string]string{}
+	for _, e := range exports {
+		paths[e.Name] = e.Path
+	}
+	var saved []map[string]any
+	for _, t := range tables {
+		p, ok := paths[t.Name]
+		if !ok {
+			return saved, fmt.Errorf("runner sent table %s, which was not asked for", t.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return saved, fmt.Errorf("--export-table %s: %w", t.Name, err)
+		}
+		if err := os.WriteFile(p, t.Data, 0o644); err != nil {
+			return saved, fmt.Errorf("--export-table %s: %w", t.Name, err)
+		}
+		saved = append(saved, map[string]any{"name": t.Name, "path": p, "size": t.Size})
+	}
+	return saved, nil
+}
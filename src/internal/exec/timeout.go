@@ -0,0 +1,149 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// watchdog enforces the timeouts of a run: cfg.Timeout from its start,
+// cfg.ConnectTimeout until ready is called, and cfg.ExecTimeout from then
+// on. When one passes, the onInterrupt functions run, and the onKill ones
+// cfg.KillAfter seconds later, unless the run ends first.
+type watchdog struct {
+	cfg         *ExecConfig
+	readyCh     chan struct{}
+	interrupted chan struct{}
+	killed      chan struct{}
+	done        chan struct{}
+	readyOnce   sync.Once
+	stopOnce    sync.Once
+
+	mu      sync.Mutex
+	message string // of the timeout that passed
+}
+
+// startWatchdog starts the clocks of cfg's timeouts. connecting says what
+// the run does until it is ready, for the message of --connect-timeout,
+// e.g. "starting the server".
+func startWatchdog(cfg *ExecConfig, connecting string) *watchdog {
+	w := &watchdog{
+		cfg:         cfg,
+		readyCh:     make(chan struct{}),
+		interrupted: make(chan struct{}),
+		killed:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go w.run(connecting)
+	return w
+}
+
+func (w *watchdog) run(connecting string) {
+	var total, connect, execute <-chan time.Time
+	if w.cfg.Timeout > 0 {
+		total = time.After(time.Duration(w.cfg.Timeout) * time.Second)
+	}
+	if w.cfg.ConnectTimeout > 0 {
+		connect = time.After(time.Duration(w.cfg.ConnectTimeout) * time.Second)
+	}
+	ready := w.readyCh
+	var message string
+	for message == "" {
+		select {
+		case <-w.done:
+			return
+		case <-ready:
+			ready, connect = nil, nil
+			if w.cfg.ExecTimeout > 0 {
+				execute = time.After(time.Duration(w.cfg.ExecTimeout) * time.Second)
+			}
+		case <-connect:
+			message = fmt.Sprintf("Timed out after %d seconds %s (--connect-timeout)", w.cfg.ConnectTimeout, connecting)
+		case <-execute:
+			message = fmt.Sprintf("Execution timed out after %d seconds (--exec-timeout)", w.cfg.ExecTimeout)
+		case <-total:
+			message = fmt.Sprintf("Execution timed out after %d seconds", w.cfg.Timeout)
+		}
+	}
+
+	w.mu.Lock()
+	w.message = message
+	w.mu.Unlock()
+	close(w.interrupted)
+	select {
+	case <-time.After(time.Duration(w.cfg.KillAfter) * time.Second):
+		close(w.killed)
+	case <-w.done:
+	}
+}
+
+// ready ends the connect phase: the server is up and reached, or the VM
+// restored, and the code starts.
+func (w *watchdog) ready() {
+	w.readyOnce.Do(func() { close(w.readyCh) })
+}
+
+// readyWhenExists calls ready once path exists; the runner creates it
+// when it has connected to the server.
+func (w *watchdog) readyWhenExists(path string) {
+	go func() {
+		tick := time.NewTicker(50 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-tick.C:
+				if _, err := os.Stat(path); err == nil {
+					w.ready()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// onInterrupt calls f when a timeout passes, unless the run ends first.
+func (w *watchdog) onInterrupt(f func()) { w.after(w.interrupted, f) }
+
+// onKill calls f cfg.KillAfter seconds after a timeout passes, unless the
+// run ends first.
+func (w *watchdog) onKill(f func()) { w.after(w.killed, f) }
+
+func (w *watchdog) after(ch <-chan struct{}, f func()) {
+	go func() {
+		select {
+		case <-ch:
+			f()
+		case <-w.done:
+		}
+	}()
+}
+
+// stop ends the clocks; call it when the run ends.
+func (w *watchdog) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
+
+// expired returns the error message of the timeout that passed, or "" if
+// none did.
+func (w *watchdog) expired() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.message
+}
+
+// watchRunner starts the watchdog of the runner process pid, which
+// interrupts its process group on a timeout and kills it cfg.KillAfter
+// seconds later. The connect phase ends when readyFile exists, which only
+// --connect-timeout and --exec-timeout need.
+func watchRunner(cfg *ExecConfig, pid int, connecting, readyFile string) *watchdog {
+	w := startWatchdog(cfg, connecting)
+	if readyFile != "" {
+		w.readyWhenExists(readyFile)
+	}
+	w.onInterrupt(func() { interruptProcessGroup(pid) })
+	w.onKill(func() { killProcessGroup(pid) })
+	return w
+}
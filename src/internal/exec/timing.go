@@ -0,0 +1,56 @@
+package exec
+
+import (
+	"time"
+)
+
+// Timing is the timing object of a --json result: how long each phase of
+// the run took, in milliseconds. Phases the run did not have, such as
+// java_ms for --host or vm_check_ms outside --vm, are null, so every mode
+// reports the same keys.
+type Timing struct {
+	StartupMs     *int64 `json:"startup_ms"`      // from the start of dh to the start of the run
+	ResolveMs     *int64 `json:"resolve_ms"`      // reading the code and resolving the version
+	VenvMs        *int64 `json:"venv_ms"`         // finding the venv and checking pydeephaven and --with packages
+	JavaMs        *int64 `json:"java_ms"`         // detecting and checking Java for the embedded server
+	VMCheckMs     *int64 `json:"vm_check_ms"`     // checking the VM prerequisites and snapshot
+	ServerStartMs *int64 `json:"server_start_ms"` // starting the embedded server or connecting to one, or restoring the VM
+	ScriptMs      *int64 `json:"script_ms"`       // running the code
+	TablesMs      *int64 `json:"tables_ms"`       // previewing and exporting the tables
+	TotalMs       int64  `json:"total_ms"`        // the whole run
+
+	start time.Time
+}
+
+// newTiming starts the timing of a run in a process that started at
+// processStart, if known.
+func newTiming(processStart time.Time) *Timing {
+	t := &Timing{start: time.Now()}
+	if !processStart.IsZero() {
+		t.StartupMs = millis(t.start.Sub(processStart))
+	}
+	return t
+}
+
+// finish records the length of the whole run and returns t.
+func (t *Timing) finish() *Timing {
+	t.TotalMs = time.Since(t.start).Milliseconds()
+	return t
+}
+
+// millis returns d in whole milliseconds, for a phase of Timing.
+func millis(d time.Duration) *int64 {
+	ms := d.Milliseconds()
+	return &ms
+}
+
+// runnerMillis returns the milliseconds a runner reported as v, a JSON
+// number, or nil if it reported none.
+func runnerMillis(v any) *int64 {
+	f, ok := v.(float64)
+	if !ok {
+		return nil
+	}
+	ms := int64(f)
+	return &ms
+}
@@ -0,0 +1,45 @@
+package exec
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// printWarnings writes captured Python warnings to w, one per line,
+// dimmed when w is a color-capable terminal.
+func printWarnings(w io.Writer, warnings []any) {
+	style := lipgloss.NewRenderer(w).NewStyle().Faint(true)
+	for _, item := range warnings {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		category, _ := m["category"].(string)
+		if category == "" {
+			category = "Warning"
+		}
+		message, _ := m["message"].(string)
+		fmt.Fprintln(w, style.Render(fmt.Sprintf("Warning: %s: %s", category, message)))
+	}
+}
+
+// warningExitCode applies --fail-on-warning: a run that otherwise succeeded
+// fails with ExitError when any warnings were captured.
+func warningExitCode(cfg *ExecConfig, exitCode int, warnings []any) int {
+	if cfg.FailOnWarning && exitCode == output.ExitSuccess && len(warnings) > 0 {
+		return output.ExitError
+	}
+	return exitCode
+}
+
+// warningsOrEmpty returns warnings, or an empty slice so JSON output always
+// carries a "warnings" array.
+func warningsOrEmpty(warnings []any) []any {
+	if warnings == nil {
+		return []any{}
+	}
+	return warnings
+}
@@ -0,0 +1,200 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long a watcher waits after a change for more before
+// reporting them together: editors write a file in several steps, often by
+// renaming a new one over it.
+const watchDebounce = 150 * time.Millisecond
+
+// Watcher reports changes to a script and to the files matching glob
+// patterns. It watches their directories rather than the files, so files
+// replaced by a rename and files created later are seen too.
+type Watcher struct {
+	fsw     *fsnotify.Watcher
+	script  string   // absolute path of the script
+	globs   []string // absolute patterns, as for filepath.Match
+	changes chan []string
+}
+
+// NewWatcher watches script and the files matching globs, relative to the
+// working directory. Call Close when done.
+func NewWatcher(script string, globs []string) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("watching files: %w", err)
+	}
+	w := &Watcher{fsw: fsw, changes: make(chan []string)}
+
+	var dirs []string
+	if script != "" {
+		if w.script, err = filepath.Abs(script); err != nil {
+			fsw.Close()
+			return nil, err
+		}
+		dirs = append(dirs, filepath.Dir(w.script))
+	}
+	for _, g := range globs {
+		abs, err := filepath.Abs(g)
+		if err != nil {
+			fsw.Close()
+			return nil, err
+		}
+		if _, err := filepath.Match(abs, ""); err != nil {
+			fsw.Close()
+			return nil, fmt.Errorf("invalid --watch-glob %q: %w", g, err)
+		}
+		w.globs = append(w.globs, abs)
+		matched, _ := filepath.Glob(filepath.Dir(abs))
+		if len(matched) == 0 {
+			fsw.Close()
+			return nil, fmt.Errorf("--watch-glob %q: no directory matches %s", g, filepath.Dir(g))
+		}
+		dirs = append(dirs, matched...)
+	}
+	slices.Sort(dirs)
+	for _, dir := range slices.Compact(dirs) {
+		if err := fsw.Add(dir); err != nil {
+			fsw.Close()
+			return nil, fmt.Errorf("watching %s: %w", dir, err)
+		}
+	}
+	return w, nil
+}
+
+// Changes returns the channel Run sends the changed files on, each batch
+// sorted and relative to the working directory where possible.
+func (w *Watcher) Changes() <-chan []string { return w.changes }
+
+// Close stops watching.
+func (w *Watcher) Close() error { return w.fsw.Close() }
+
+// matches reports whether the watcher reports changes to path.
+func (w *Watcher) matches(path string) bool {
+	if path == w.script {
+		return true
+	}
+	for _, g := range w.globs {
+		if ok, _ := filepath.Match(g, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Run sends the changes to the watched files on Changes, debounced, until
+// ctx is done or watching fails.
+func (w *Watcher) Run(ctx context.Context) error {
+	cwd, _ := os.Getwd()
+	pending := map[string]bool{}
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op == fsnotify.Chmod || !w.matches(ev.Name) {
+				continue
+			}
+			name := ev.Name
+			if rel, err := filepath.Rel(cwd, name); err == nil && !strings.HasPrefix(rel, "..") {
+				name = rel
+			}
+			pending[name] = true
+			timer = time.After(watchDebounce)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("watching files: %w", err)
+		case <-timer:
+			timer = nil
+			changed := make([]string, 0, len(pending))
+			for name := range pending {
+				changed = append(changed, name)
+			}
+			slices.Sort(changed)
+			clear(pending)
+			select {
+			case w.changes <- changed:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// Watch runs cfg, then runs it again each time its script or a file
+// matching globs changes, until ctx is done. Each run gets a copy of cfg,
+// and report is called with its outcome. A separator with the changed
+// files goes before every run after the first, and the run's time after
+// each, on cfg.Stderr.
+func Watch(ctx context.Context, cfg *ExecConfig, globs []string, report func(exitCode int, result map[string]any, err error)) error {
+	if cfg.ScriptPath == "-" {
+		return fmt.Errorf("--watch can't read the script from stdin")
+	}
+	if cfg.ScriptPath == "" && len(globs) == 0 {
+		return fmt.Errorf("--watch needs a script file, or -c with --watch-glob")
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = os.Stderr
+	}
+	// The other scripts of a multi-script run are watched as patterns
+	// that match only themselves.
+	w, err := NewWatcher(cfg.ScriptPath, append(slices.Clone(globs), cfg.ExtraScripts...))
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchErr := make(chan error, 1)
+	go func() { watchErr <- w.Run(ctx) }()
+
+	run := func(n int) {
+		c := *cfg
+		if n > 1 {
+			c.ProcessStart = time.Time{} // dh started long before this run
+		}
+		start := time.Now()
+		exitCode, result, err := Run(&c)
+		report(exitCode, result, err)
+		if !cfg.Quiet {
+			status := fmt.Sprintf("exit %d", exitCode)
+			if err != nil && exitCode == output.ExitSuccess {
+				status = "failed"
+			}
+			fmt.Fprintf(cfg.Stderr, "── run %d: %s in %.2fs; watching for changes (Ctrl+C to stop) ──\n", n, status, time.Since(start).Seconds())
+		}
+	}
+
+	run(1)
+	for n := 2; ; n++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watchErr:
+			return err
+		case changed := <-w.Changes():
+			if !cfg.Quiet {
+				fmt.Fprintf(cfg.Stderr, "\n── %s changed at %s ──\n", strings.Join(changed, ", "), time.Now().Format("15:04:05"))
+			}
+			run(n)
+		}
+	}
+}
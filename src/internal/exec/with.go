@@ -0,0 +1,96 @@
+package exec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// parseWith checks and normalizes --with package specs: trimmed, sorted
+// and without duplicates, so the same set always maps to one overlay.
+func parseWith(specs []string) ([]string, error) {
+	var out []string
+	for _, s := range specs {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.HasPrefix(s, "-") {
+			return nil, fmt.Errorf("invalid --with %q: use a package requirement, e.g. polars or \"requests>=2\"", s)
+		}
+		out = append(out, s)
+	}
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// withDir returns the overlay directory for specs in version's install:
+// one per package set, named by its hash.
+func withDir(dhHome, version string, specs []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(specs, "\n")))
+	return filepath.Join(dhHome, "versions", version, "with", hex.EncodeToString(sum[:8]))
+}
+
+// EnsureWith returns the overlay directory holding the --with packages
+// specs for the venv of pythonBin, installing them there first if no run
+// has yet. The overlay goes on PYTHONPATH and leaves the venv untouched.
+// Packages the venv already has are pinned to its versions, so the
+// overlay never shadows them with different ones.
+func EnsureWith(dhHome, version, pythonBin string, specs []string, quiet bool, stderr io.Writer) (string, error) {
+	dir := withDir(dhHome, version, specs)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	if !quiet && stderr != nil {
+		fmt.Fprintf(stderr, "Installing %s...\n", strings.Join(specs, ", "))
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", filepath.Dir(dir), err)
+	}
+	freeze := ExecCommand("uv", "pip", "freeze", "--python", pythonBin)
+	output.TraceCommand(freeze)
+	pins, err := freeze.Output()
+	if err != nil {
+		return "", fmt.Errorf("listing the packages of %s: %w", pythonBin, err)
+	}
+	constraints, err := os.CreateTemp(filepath.Dir(dir), ".constraints-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(constraints.Name())
+	if _, err := constraints.Write(pins); err != nil {
+		constraints.Close()
+		return "", err
+	}
+	constraints.Close()
+
+	// Install next to the overlay and rename it into place, so a failed
+	// or concurrent install never leaves a partial one
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".install-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	args := append([]string{"pip", "install", "--python", pythonBin, "--target", tmp, "--constraint", constraints.Name()}, specs...)
+	install := ExecCommand("uv", args...)
+	install.Stderr = stderr
+	output.TraceCommand(install)
+	if err := install.Run(); err != nil {
+		return "", fmt.Errorf("installing --with packages: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, ".dh-with"), []byte(strings.Join(specs, "\n")+"\n"), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return dir, nil // another run installed it first
+		}
+		return "", fmt.Errorf("installing --with packages: %w", err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,209 @@
+// Package execlog keeps the execution log: the dh exec runs of the
+// history store (package history), one entry per run with its record in
+// Entry.Run, and the code of the runs kept once per content hash beside
+// it. dh history reads it.
+package execlog
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/history"
+)
+
+// MaxOutput is how much of a run's stdout and of its stderr the log
+// keeps: the last MaxOutput bytes of each.
+const MaxOutput = 4096
+
+// Modes of a run.
+const (
+	ModeEmbedded = "embedded" // a server started for the run, or the server daemon's
+	ModeRemote   = "remote"   // --host, --target or --attach
+	ModeVM       = "vm"
+	ModeHosts    = "hosts" // several --host or --hosts-file
+)
+
+// ErrNotFound is returned (wrapped) when no run has the ID asked for.
+var ErrNotFound = errors.New("not found")
+
+// CodeDir returns the directory the code of the runs is kept in under
+// dhHome.
+func CodeDir(dhHome string) string {
+	return filepath.Join(dhHome, "history", "code")
+}
+
+func codePath(dhHome, hash string) string {
+	return filepath.Join(CodeDir(dhHome), hash)
+}
+
+// Hash returns the content hash of code as the log records it.
+func Hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Append gives the run of e an ID and adds e to the history store of
+// e.Dir. code is the code of the run; it is kept, once per hash, unless
+// keepCode is false, in which case e.Code is dropped too. The output in
+// e.Run is cut to its last MaxOutput bytes.
+func Append(dhHome string, e *history.Entry, code string, keepCode bool) error {
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	e.Source = history.SourceExec
+	e.Run.ID = hex.EncodeToString(id[:])
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC().Truncate(time.Second)
+	}
+	e.Run.CodeHash = Hash(code)
+	e.Run.Stdout, e.Run.Stderr = Tail(e.Run.Stdout), Tail(e.Run.Stderr)
+
+	if keepCode {
+		if err := os.MkdirAll(CodeDir(dhHome), 0o700); err != nil {
+			return fmt.Errorf("creating exec log dir: %w", err)
+		}
+		path := codePath(dhHome, e.Run.CodeHash)
+		if _, err := os.Stat(path); err != nil {
+			if err := os.WriteFile(path, []byte(code), 0o600); err != nil {
+				return fmt.Errorf("writing run code: %w", err)
+			}
+		}
+		e.Run.CodeKept = true
+	} else {
+		e.Code = ""
+	}
+
+	store, err := history.Open(dhHome, e.Dir)
+	if err != nil {
+		return err
+	}
+	_, err = store.Add(*e)
+	return err
+}
+
+// List returns the runs in the history store of dir, oldest first.
+func List(dhHome, dir string) ([]history.Entry, error) {
+	store, err := history.Open(dhHome, dir)
+	if err != nil {
+		return nil, err
+	}
+	var runs []history.Entry
+	for _, e := range store.Entries() {
+		if e.Run != nil && e.Run.ID != "" {
+			runs = append(runs, e)
+		}
+	}
+	return runs, nil
+}
+
+// Find returns the run in the history store of dir whose ID is id or
+// starts with it, or the latest run for "last".
+func Find(dhHome, dir, id string) (*history.Entry, error) {
+	entries, err := List(dhHome, dir)
+	if err != nil {
+		return nil, err
+	}
+	if id == "last" {
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("no runs logged yet: %w", ErrNotFound)
+		}
+		return &entries[len(entries)-1], nil
+	}
+	var found *history.Entry
+	for i := range entries {
+		if id != "" && strings.HasPrefix(entries[i].Run.ID, id) {
+			if found != nil && found.Run.ID != entries[i].Run.ID {
+				return nil, fmt.Errorf("run ID %q is ambiguous; give more of it", id)
+			}
+			found = &entries[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("run %q: %w", id, ErrNotFound)
+	}
+	return found, nil
+}
+
+// Code returns the kept code of the run of e.
+func Code(dhHome string, e *history.Entry) (string, error) {
+	if !e.Run.CodeKept {
+		return "", fmt.Errorf("the code of run %s was not kept: it looked like it held a secret", e.Run.ID)
+	}
+	data, err := os.ReadFile(codePath(dhHome, e.Run.CodeHash))
+	if err != nil {
+		return "", fmt.Errorf("reading the code of run %s: %w", e.Run.ID, err)
+	}
+	return string(data), nil
+}
+
+// Tail returns the last MaxOutput bytes of s, marked as cut when it is.
+func Tail(s string) string {
+	if len(s) <= MaxOutput {
+		return s
+	}
+	return "[...]" + s[len(s)-MaxOutput:]
+}
+
+// NotKept stands in the logged arguments for -c code the log didn't keep.
+const NotKept = "[not kept]"
+
+// RedactArgs returns args without --auth-token and its value, so tokens
+// given on the command line stay out of the log. With dropCode, the code
+// of -c is replaced by NotKept as well.
+func RedactArgs(args []string, dropCode bool) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "--":
+			return append(out, args[i:]...)
+		case a == "--auth-token":
+			i++
+		case strings.HasPrefix(a, "--auth-token="):
+		case dropCode && (a == "-c" || a == "--code") && i+1 < len(args):
+			out = append(out, a, NotKept)
+			i++
+		case dropCode && strings.HasPrefix(a, "--code="):
+			out = append(out, "--code="+NotKept)
+		case dropCode && strings.HasPrefix(a, "-c") && a != "-c":
+			out = append(out, "-c"+NotKept)
+		default:
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// TailWriter passes writes on to W, keeping the last MaxOutput bytes of
+// them for the log. It is safe for concurrent use.
+type TailWriter struct {
+	W   io.Writer
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *TailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*MaxOutput {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-MaxOutput-1:]...)
+	}
+	return t.W.Write(p)
+}
+
+// String returns what was written, cut as Tail cuts it.
+func (t *TailWriter) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Tail(string(t.buf))
+}
@@ -0,0 +1,348 @@
+package history
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+)
+
+// Sources recorded in the store.
+const (
+	SourceREPL = "repl"
+	SourceExec = "exec"
+)
+
+// Dedup policies.
+const (
+	DedupConsecutive = "consecutive" // skip an entry equal to the previous one
+	DedupAll         = "all"         // drop older copies of a re-added entry
+	DedupNone        = "none"        // keep everything
+)
+
+// Scopes.
+const (
+	ScopeGlobal  = "global"  // one history for all directories
+	ScopeProject = "project" // one history per project root
+)
+
+// DefaultMaxEntries is used when history.max_entries is unset.
+const DefaultMaxEntries = 500
+
+// defaultExclude matches lines that look like they carry secrets. Entries
+// containing a match are never written to disk.
+var defaultExclude = []string{
+	`(?i)(password|passwd|secret|token|api[_-]?key|access[_-]?key|credential)s?\s*[:=]`,
+	`(?i)authorization\s*:\s*(bearer|basic)\s`,
+	`AKIA[0-9A-Z]{16}`,
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----`,
+}
+
+// Entry is one history item. REPL entries and exec -c runs carry Code;
+// exec runs of a script file carry Script instead. Exec runs also carry
+// Run, the execution log record dh history reads.
+type Entry struct {
+	Source string    `json:"source"`
+	Code   string    `json:"code,omitempty"`
+	Script string    `json:"script,omitempty"`
+	Dir    string    `json:"dir,omitempty"`
+	Time   time.Time `json:"time"`
+	Run    *Run      `json:"run,omitempty"`
+}
+
+// Run is the record of one dh exec run: how it ran and how it went. The
+// code it ran is kept apart, once per CodeHash (see package execlog).
+type Run struct {
+	ID       string   `json:"id"`
+	Mode     string   `json:"mode"`
+	Version  string   `json:"version,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+	Args     []string `json:"args"`              // of dh, without --auth-token
+	Scripts  []string `json:"scripts,omitempty"` // absolute paths
+	Stdin    bool     `json:"stdin,omitempty"`   // the code came from stdin
+	CodeHash string   `json:"code_hash"`
+	CodeKept bool     `json:"code_kept"` // false when the code looked like it held a secret
+	Elapsed  float64  `json:"elapsed_seconds"`
+	ExitCode int      `json:"exit_code"`
+	Error    string   `json:"error,omitempty"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+}
+
+func (e Entry) key() string {
+	if e.Run != nil {
+		return "run:" + e.Run.ID // every run is logged
+	}
+	if e.Script != "" {
+		return "script:" + e.Script
+	}
+	return "code:" + e.Code
+}
+
+// Options control what the store keeps.
+type Options struct {
+	MaxEntries int
+	Dedup      string
+	Exclude    []*regexp.Regexp
+}
+
+// Store is an append-mostly history file shared by the REPL and dh exec.
+type Store struct {
+	path    string
+	opts    Options
+	entries []Entry
+}
+
+// OptionsFromConfig builds Options from the [history] config section,
+// filling in defaults. The built-in secret patterns are always applied in
+// addition to history.exclude.
+func OptionsFromConfig(h config.History) (Options, error) {
+	opts := Options{MaxEntries: h.MaxEntries, Dedup: h.Dedup}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	if opts.Dedup == "" {
+		opts.Dedup = DedupConsecutive
+	}
+	for _, p := range append(append([]string{}, defaultExclude...), h.Exclude...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid history.exclude pattern %q: %w", p, err)
+		}
+		opts.Exclude = append(opts.Exclude, re)
+	}
+	return opts, nil
+}
+
+// Path returns the history file for scope. Project scope keys the file by
+// the project root: the directory holding the nearest .dhrc, else the
+// nearest git repository root, else cwd itself.
+func Path(dhHome, scope, cwd string) string {
+	if scope != ScopeProject {
+		return filepath.Join(dhHome, "history.jsonl")
+	}
+	root := ProjectRoot(cwd)
+	sum := sha256.Sum256([]byte(root))
+	name := fmt.Sprintf("%s-%s.jsonl", filepath.Base(root), hex.EncodeToString(sum[:])[:12])
+	return filepath.Join(dhHome, "history", name)
+}
+
+// ProjectRoot returns the project root for cwd used by project scope.
+func ProjectRoot(cwd string) string {
+	abs, err := filepath.Abs(cwd)
+	if err != nil {
+		abs = cwd
+	}
+	if rc, err := config.FindDHRC(abs); err == nil && rc != "" {
+		return filepath.Dir(rc)
+	}
+	for dir := abs; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs
+		}
+		dir = parent
+	}
+}
+
+// Open loads the history store for cwd using the [history] settings in
+// effect there (config.toml or a project config). The legacy REPL history file (repl_history) is imported the
+// first time the global store is created.
+func Open(dhHome, cwd string) (*Store, error) {
+	cfg, _, err := config.LoadEffectiveAt(cwd)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := OptionsFromConfig(cfg.History)
+	if err != nil {
+		return nil, err
+	}
+	path := Path(dhHome, cfg.History.Scope, cwd)
+	s := &Store{path: path, opts: opts}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if cfg.History.Scope != ScopeProject && len(s.entries) == 0 {
+		s.importLegacy(filepath.Join(dhHome, "repl_history"))
+	}
+	return s, nil
+}
+
+// NewStore returns a store at path with explicit options, loading any
+// existing entries.
+func NewStore(path string, opts Options) (*Store, error) {
+	s := &Store{path: path, opts: opts}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the file backing the store.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Entries returns all entries, oldest first.
+func (s *Store) Entries() []Entry {
+	return append([]Entry{}, s.entries...)
+}
+
+// Excluded reports whether text matches a sensitive pattern.
+func (s *Store) Excluded(text string) bool {
+	for _, re := range s.opts.Exclude {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add records e, applying the dedup policy, sensitive-pattern exclusion and
+// the entry cap. It reports whether the entry was stored. The file is
+// re-read first so concurrent dh processes do not drop each other's entries.
+// Exec runs are never deduplicated, and one whose code is excluded is
+// stored without it.
+func (s *Store) Add(e Entry) (bool, error) {
+	e.Code = strings.TrimSpace(e.Code)
+	if e.Code == "" && e.Script == "" && e.Run == nil {
+		return false, nil
+	}
+	if s.Excluded(e.Code) {
+		if e.Run == nil {
+			return false, nil
+		}
+		e.Code = ""
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC().Truncate(time.Second)
+	}
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	switch s.opts.Dedup {
+	case DedupConsecutive, "":
+		if n := len(s.entries); n > 0 && s.entries[n-1].key() == e.key() {
+			return false, nil
+		}
+	case DedupAll:
+		kept := s.entries[:0]
+		for _, old := range s.entries {
+			if old.key() != e.key() {
+				kept = append(kept, old)
+			}
+		}
+		s.entries = kept
+	}
+
+	s.entries = append(s.entries, e)
+	s.trim()
+	return true, s.save()
+}
+
+func (s *Store) trim() {
+	max := s.opts.MaxEntries
+	if max <= 0 {
+		max = DefaultMaxEntries
+	}
+	if len(s.entries) > max {
+		s.entries = s.entries[len(s.entries)-max:]
+	}
+}
+
+func (s *Store) load() error {
+	s.entries = nil
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading history: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			continue
+		}
+		s.entries = append(s.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading history: %w", err)
+	}
+	s.trim()
+	return nil
+}
+
+func (s *Store) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("creating history dir: %w", err)
+	}
+	var buf strings.Builder
+	for _, e := range s.entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("marshaling history: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	// Write to a temp file and rename so readers never see a partial file.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(buf.String()), 0o600); err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	return nil
+}
+
+// importLegacy converts the old one-JSON-string-per-line repl_history file.
+// Entries matching a sensitive pattern are dropped on the way in.
+func (s *Store) importLegacy(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var code string
+		if err := json.Unmarshal([]byte(line), &code); err != nil {
+			code = line
+		}
+		if s.Excluded(code) {
+			continue
+		}
+		s.entries = append(s.entries, Entry{Source: SourceREPL, Code: code})
+	}
+	if len(s.entries) == 0 {
+		return
+	}
+	s.trim()
+	if s.save() == nil {
+		os.Remove(path)
+	}
+}
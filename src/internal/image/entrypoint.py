@@ -0,0 +1,61 @@
+"""Entrypoint of images built by dh docker build. Starts Deephaven, runs the script, then serves or exits."""
+import os
+import sys
+import time
+
+
+def main() -> int:
+    port = int(os.environ.get("DH_PORT", "10000"))
+    jvm_args = os.environ.get("DH_JVM_ARGS", "-Xmx4g").split()
+    script = os.environ.get("DH_SCRIPT", "")
+    exit_after = os.environ.get("DH_EXIT", "") not in ("", "0", "false")
+
+    from deephaven_server import Server
+
+    server = Server(port=port, jvm_args=jvm_args)
+    server.start()
+
+    if script:
+        with open(script) as f:
+            code = f.read()
+
+        # Connect with retry — gRPC services may not be ready immediately after server.start()
+        from pydeephaven import Session
+
+        session = None
+        last_err = None
+        for attempt in range(10):
+            try:
+                session = Session(host="localhost", port=port)
+                break
+            except Exception as e:
+                last_err = e
+                time.sleep(0.5)
+        if session is None:
+            print(f"Error: Failed to connect to server: {last_err}", file=sys.stderr, flush=True)
+            return 2
+
+        try:
+            session.run_script(code)
+        except Exception as e:
+            print(f"Error: Script execution failed: {e}", file=sys.stderr, flush=True)
+            return 1
+        finally:
+            if exit_after:
+                session.close()
+
+        if exit_after:
+            print(f"Finished {script}", flush=True)
+            return 0
+
+    print(f"Server running on port {port}", flush=True)
+    try:
+        while True:
+            time.sleep(3600)
+    except KeyboardInterrupt:
+        pass
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,204 @@
+// Package image builds container images that run Deephaven the way dh
+// does locally: a pinned server version, the configured plugins and extra
+// packages, and an entrypoint that runs a script and keeps serving (or
+// exits, for batch jobs). The images start from the same Dockerfile as the
+// VM rootfs.
+package image
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+//go:embed entrypoint.py
+var entrypointScript string
+
+// DefaultPort is the port the server listens on inside the container.
+const DefaultPort = 10000
+
+// DefaultJVMArgs are the JVM arguments of the server, as for dh serve.
+const DefaultJVMArgs = "-Xmx4g -DAuthHandlers=io.deephaven.auth.AnonymousAuthenticationHandler"
+
+// Options configures an image.
+type Options struct {
+	Version  string
+	Packages []string // plugins and other pip packages
+	Script   string   // script to run at startup; "" just serves
+	Files    []string // other files copied next to the script
+	Exit     bool     // exit when the script finishes instead of serving
+	Port     int
+	JVMArgs  string
+	Tool     string // dh CLI version, recorded as a label
+}
+
+// DefaultTag is the tag an image gets when none is given.
+func DefaultTag(opts Options) string {
+	name := "dh-server"
+	if opts.Script != "" {
+		name = strings.TrimSuffix(filepath.Base(opts.Script), filepath.Ext(opts.Script))
+		name = "dh-" + strings.ToLower(strings.NewReplacer("_", "-", " ", "-").Replace(name))
+	}
+	return name + ":" + opts.Version
+}
+
+// Dockerfile returns the Dockerfile of the image.
+func Dockerfile(opts Options) string {
+	port := opts.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	jvmArgs := opts.JVMArgs
+	if jvmArgs == "" {
+		jvmArgs = DefaultJVMArgs
+	}
+
+	var b strings.Builder
+	b.WriteString(vm.ServerDockerfile(opts.Version, opts.Packages))
+	fmt.Fprintf(&b, `
+LABEL io.deephaven.version=%q \
+      dev.dh-cli.tool=%q
+
+ARG TARGETARCH
+ENV JAVA_HOME=/usr/lib/jvm/java-17-openjdk-${TARGETARCH} \
+    DH_PORT=%d \
+    DH_JVM_ARGS=%q
+
+COPY entrypoint.py /opt/dh/entrypoint.py
+`, opts.Version, opts.Tool, port, jvmArgs)
+
+	b.WriteString("\nWORKDIR /app\n")
+	if opts.Script != "" {
+		b.WriteString("COPY app/ /app/\n")
+		fmt.Fprintf(&b, "ENV DH_SCRIPT=/app/%s", filepath.Base(opts.Script))
+		if opts.Exit {
+			b.WriteString(" \\\n    DH_EXIT=1")
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, `
+EXPOSE %d
+ENTRYPOINT ["python3", "/opt/dh/entrypoint.py"]
+`, port)
+	return b.String()
+}
+
+// WriteContext writes the build context of the image to dir: the
+// Dockerfile, the entrypoint, and the script and its files under app/.
+func WriteContext(dir string, opts Options) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(Dockerfile(opts)), 0o644); err != nil {
+		return fmt.Errorf("writing Dockerfile: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "entrypoint.py"), []byte(entrypointScript), 0o644); err != nil {
+		return fmt.Errorf("writing entrypoint.py: %w", err)
+	}
+	if opts.Script == "" {
+		return nil
+	}
+
+	app := filepath.Join(dir, "app")
+	if err := copyPath(opts.Script, filepath.Join(app, filepath.Base(opts.Script))); err != nil {
+		return err
+	}
+	for _, f := range opts.Files {
+		rel, err := appPath(opts.Script, f)
+		if err != nil {
+			return err
+		}
+		if err := copyPath(f, filepath.Join(app, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appPath returns where f goes under app/: its path relative to the
+// script's directory, which must contain it so imports keep working.
+func appPath(script, f string) (string, error) {
+	base, err := filepath.Abs(filepath.Dir(script))
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(f)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(base, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not inside the script's directory %s", f, base)
+	}
+	return rel, nil
+}
+
+// copyPath copies a file, or a directory recursively, to dst.
+func copyPath(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			if d.Name() == "__pycache__" || (path != src && strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+}
+
+// Build writes the build context to a temporary directory and builds it
+// with docker build, tagged tag. Docker's output goes to stderr.
+func Build(opts Options, tag string, stderr io.Writer) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker not found on PATH")
+	}
+	dir, err := os.MkdirTemp("", "dh-image-*")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := WriteContext(dir, opts); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("docker", "build", "-t", tag, dir)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker build failed: %w", err)
+	}
+	return nil
+}
+
+// Push pushes tag to its registry with docker push.
+func Push(tag string, stderr io.Writer) error {
+	cmd := exec.Command("docker", "push", tag)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker push failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package java
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// checkTimeout bounds the dry-run JVM started by CheckFlags.
+const checkTimeout = 15 * time.Second
+
+// FlagError reports JVM arguments that the JVM refused to start with.
+type FlagError struct {
+	Flag    string // the offending argument, or "" if it could not be identified
+	Message string // the JVM's explanation
+}
+
+func (e *FlagError) Error() string {
+	if e.Flag == "" {
+		return fmt.Sprintf("invalid JVM arguments: %s", e.Message)
+	}
+	return fmt.Sprintf("invalid JVM flag %s: %s", e.Flag, e.Message)
+}
+
+// SplitFlags splits a --jvm-args string the way the runner does, on
+// whitespace.
+func SplitFlags(jvmArgs string) []string {
+	return strings.Fields(jvmArgs)
+}
+
+// CheckFlags starts a short-lived JVM from javaPath with args and -version
+// to catch typos and unsupported options before a server is started with
+// them. A rejected flag is reported as a *FlagError.
+func CheckFlags(javaPath string, args []string) error {
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			return &FlagError{Flag: a, Message: "not a JVM option (options start with -)"}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, javaPath, append(slices.Clone(args), "-version")...)
+	output.TraceCommand(cmd)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("checking JVM flags: java did not exit within %s", checkTimeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ParseFlagError(string(out), args)
+	}
+	if err != nil {
+		return fmt.Errorf("checking JVM flags: %w", err)
+	}
+	return nil
+}
+
+// flagErrorPatterns match HotSpot startup errors. The first group is the
+// flag, or the VM option name within it.
+var flagErrorPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`Unrecognized option: (\S+)`),
+	regexp.MustCompile(`Unrecognized VM option '([^']+)'`),
+	regexp.MustCompile(`Improperly specified VM option '([^']+)'`),
+	regexp.MustCompile(`Missing \+/- setting for VM option '([^']+)'`),
+	regexp.MustCompile(`VM option '([^']+)' is (?:experimental|diagnostic|develop)`),
+	regexp.MustCompile(`Invalid [a-z ]+ size: (-\S+)`),
+	regexp.MustCompile(`Invalid (?:value|argument) for [^:]*: (\S+)`),
+}
+
+// ParseFlagError turns the output of a JVM that failed to start into a
+// *FlagError, matching the reported option back to the argument in args.
+// Exported for testing.
+func ParseFlagError(out string, args []string) *FlagError {
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" ||
+			strings.HasPrefix(line, "Error: Could not create the Java Virtual Machine") ||
+			strings.HasPrefix(line, "Error: A fatal exception has occurred") ||
+			line == "Error occurred during initialization of VM" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return &FlagError{Message: "the JVM failed to start"}
+	}
+
+	for _, line := range lines {
+		for _, re := range flagErrorPatterns {
+			m := re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			return &FlagError{Flag: matchFlag(m[1], args), Message: line}
+		}
+	}
+	return &FlagError{Message: lines[0]}
+}
+
+// matchFlag finds the argument a reported option came from: the argument
+// itself, or the -XX: option whose name is name (as in "-XX:+UseFoo" or
+// "-XX:Foo=1" for 'UseFoo' and 'Foo=1').
+func matchFlag(name string, args []string) string {
+	if slices.Contains(args, name) {
+		return name
+	}
+	base, _, _ := strings.Cut(name, "=")
+	for _, a := range args {
+		opt, ok := strings.CutPrefix(a, "-XX:")
+		if !ok {
+			continue
+		}
+		opt = strings.TrimLeft(opt, "+-")
+		if opt == name {
+			return a
+		}
+		if n, _, _ := strings.Cut(opt, "="); n == base {
+			return a
+		}
+	}
+	return name
+}
+
+// ValidateFlags is CheckFlags with a cache: flags that passed once for the
+// same java binary are not checked again, so the dry run only costs JVM
+// startup time when the flags or the JVM change.
+func ValidateFlags(dhHome, javaPath string, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	key := flagsCacheKey(javaPath, args)
+	cache := filepath.Join(dhHome, "cache", "jvm-flags")
+	if data, err := os.ReadFile(cache); err == nil && slices.Contains(strings.Fields(string(data)), key) {
+		return nil
+	}
+	if err := CheckFlags(javaPath, args); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cache), 0o755); err == nil {
+		if f, err := os.OpenFile(cache, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644); err == nil {
+			fmt.Fprintln(f, key)
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// flagsCacheKey identifies args for a particular java binary. The binary's
+// size and modification time are included so an upgraded JVM is rechecked.
+func flagsCacheKey(javaPath string, args []string) string {
+	h := sha256.New()
+	fmt.Fprintln(h, javaPath)
+	if fi, err := os.Stat(javaPath); err == nil {
+		fmt.Fprintln(h, fi.Size(), fi.ModTime().UnixNano())
+	}
+	for _, a := range args {
+		fmt.Fprintln(h, a)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
@@ -12,7 +12,8 @@ import (
 	"strings"
 )
 
-// Install downloads and installs a Temurin JDK to <dhHome>/java/.
+// Install downloads and installs a Temurin JDK to <dhHome>/java/. A JDK
+// archive from an installed offline bundle is used instead of downloading.
 func Install(dhHome string, jdkVersion int, force bool) (*JavaInfo, error) {
 	javaDir := filepath.Join(dhHome, "java")
 
@@ -27,16 +28,6 @@ func Install(dhHome string, jdkVersion int, force bool) (*JavaInfo, error) {
 		}
 	}
 
-	osName, archName, err := adoptiumPlatform()
-	if err != nil {
-		return nil, err
-	}
-
-	url := fmt.Sprintf(
-		"https://api.adoptium.net/v3/binary/latest/%d/ga/%s/%s/jdk/hotspot/normal/eclipse",
-		jdkVersion, osName, archName,
-	)
-
 	// Remove existing managed Java if force
 	if force {
 		os.RemoveAll(javaDir)
@@ -46,20 +37,25 @@ func Install(dhHome string, jdkVersion int, force bool) (*JavaInfo, error) {
 		return nil, fmt.Errorf("failed to create java directory: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "Downloading Temurin JDK %d for %s/%s...\n", jdkVersion, osName, archName)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("download failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
-	}
-
-	if err := extractTarGz(resp.Body, javaDir); err != nil {
-		return nil, fmt.Errorf("extraction failed: %w", err)
+	if archive := BundledJDK(dhHome, jdkVersion); archive != "" {
+		fmt.Fprintf(os.Stderr, "Installing Temurin JDK %d from bundle %s...\n", jdkVersion, archive)
+		f, err := os.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := extractTarGz(f, javaDir); err != nil {
+			return nil, fmt.Errorf("extraction failed: %w", err)
+		}
+	} else {
+		body, err := openJDKDownload(jdkVersion)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		if err := extractTarGz(body, javaDir); err != nil {
+			return nil, fmt.Errorf("extraction failed: %w", err)
+		}
 	}
 
 	// Find the installed java binary
@@ -72,6 +68,61 @@ func Install(dhHome string, jdkVersion int, force bool) (*JavaInfo, error) {
 	return info, nil
 }
 
+// JDKArchiveName is the file name of a Temurin JDK archive in an offline
+// bundle's jdk/ directory.
+func JDKArchiveName(jdkVersion int) string {
+	return fmt.Sprintf("temurin-%d.tar.gz", jdkVersion)
+}
+
+// BundledJDK returns the path of the Temurin JDK archive for jdkVersion in
+// an installed offline bundle, or "" if no bundle has one.
+func BundledJDK(dhHome string, jdkVersion int) string {
+	matches, _ := filepath.Glob(filepath.Join(dhHome, "bundles", "*", "jdk", JDKArchiveName(jdkVersion)))
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1]
+}
+
+// DownloadJDK writes the Temurin JDK archive for jdkVersion and the current
+// platform to w without installing it.
+func DownloadJDK(jdkVersion int, w io.Writer) error {
+	body, err := openJDKDownload(jdkVersion)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if _, err := io.Copy(w, body); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	return nil
+}
+
+// openJDKDownload starts downloading the Temurin JDK archive from Adoptium.
+func openJDKDownload(jdkVersion int) (io.ReadCloser, error) {
+	osName, archName, err := adoptiumPlatform()
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf(
+		"https://api.adoptium.net/v3/binary/latest/%d/ga/%s/%s/jdk/hotspot/normal/eclipse",
+		jdkVersion, osName, archName,
+	)
+
+	fmt.Fprintf(os.Stderr, "Downloading Temurin JDK %d for %s/%s...\n", jdkVersion, osName, archName)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
 // adoptiumPlatform maps Go runtime OS/arch to Adoptium naming.
 func adoptiumPlatform() (string, string, error) {
 	osMap := map[string]string{
@@ -0,0 +1,109 @@
+// Package junit writes test results as JUnit XML, the report format CI
+// systems (GitHub Actions, GitLab, Jenkins) read test results from.
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Suites is the root element of a report.
+type Suites struct {
+	XMLName  xml.Name `xml:"testsuites"`
+	Name     string   `xml:"name,attr"`
+	Tests    int      `xml:"tests,attr"`
+	Failures int      `xml:"failures,attr"`
+	Errors   int      `xml:"errors,attr"`
+	Time     Seconds  `xml:"time,attr"`
+	Suites   []Suite  `xml:"testsuite"`
+}
+
+// Suite is a group of test cases.
+type Suite struct {
+	Name      string  `xml:"name,attr"`
+	Tests     int     `xml:"tests,attr"`
+	Failures  int     `xml:"failures,attr"`
+	Errors    int     `xml:"errors,attr"`
+	Skipped   int     `xml:"skipped,attr"`
+	Time      Seconds `xml:"time,attr"`
+	Timestamp string  `xml:"timestamp,attr,omitempty"`
+	Cases     []Case  `xml:"testcase"`
+}
+
+// Case is one test. A failure is a check the test made that did not
+// hold; an error is anything else that stopped it.
+type Case struct {
+	Name       string      `xml:"name,attr"`
+	Classname  string      `xml:"classname,attr"`
+	File       string      `xml:"file,attr,omitempty"`
+	Time       Seconds     `xml:"time,attr"`
+	Properties *Properties `xml:"properties,omitempty"`
+	Failure    *Problem    `xml:"failure,omitempty"`
+	Error      *Problem    `xml:"error,omitempty"`
+	SystemOut  string      `xml:"system-out,omitempty"`
+	SystemErr  string      `xml:"system-err,omitempty"`
+}
+
+// Properties are the names and values reported with a case.
+type Properties struct {
+	Items []Property `xml:"property"`
+}
+
+// Property is a name and value reported with a case.
+type Property struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// Problem is the failure or error of a case: a short message, its type,
+// and the details, such as a traceback.
+type Problem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Seconds is a duration in seconds, written with millisecond precision.
+type Seconds float64
+
+func (s Seconds) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: fmt.Sprintf("%.3f", float64(s))}, nil
+}
+
+// NewSuite returns the suite of cases, started at start, with its counts
+// and time.
+func NewSuite(name string, start time.Time, cases []Case) Suite {
+	s := Suite{Name: name, Tests: len(cases), Timestamp: start.UTC().Format("2006-01-02T15:04:05"), Cases: cases}
+	for _, c := range cases {
+		s.Time += c.Time
+		if c.Failure != nil {
+			s.Failures++
+		} else if c.Error != nil {
+			s.Errors++
+		}
+	}
+	return s
+}
+
+// Write writes the report of suites, named name, to w.
+func Write(w io.Writer, name string, suites ...Suite) error {
+	root := Suites{Name: name, Suites: suites}
+	for _, s := range suites {
+		root.Tests += s.Tests
+		root.Failures += s.Failures
+		root.Errors += s.Errors
+		root.Time += s.Time
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,470 @@
+// Package lint checks the Deephaven query strings in Python scripts
+// without running them: the formulas passed to update(), view() and
+// friends, the filters passed to where(), and the column names passed to
+// the agg functions.
+package lint
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+)
+
+// Severities of a diagnostic.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Rules reported by the linter.
+const (
+	RulePythonOperator  = "python-operator"     // and/or/not instead of &&/||/!
+	RulePythonLiteral   = "python-literal"      // True/False/None instead of true/false/null
+	RulePythonCast      = "python-cast"         // int(X) instead of (int)X
+	RuleMissingAssign   = "missing-assignment"  // formula without NAME =
+	RuleInvalidColumn   = "invalid-column-name" // NAME is not a valid column name
+	RuleUnbalanced      = "unbalanced"          // brackets or quotes do not match
+	RuleUnknownColumn   = "unknown-column"      // column not created earlier in the chain
+	RuleDeprecated      = "deprecated-function" // function renamed in the target version
+	RuleInvalidAggInput = "invalid-agg-column"  // agg column is not NAME or OUT = IN
+)
+
+// Diagnostic is one problem found in a query string.
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// String formats d the way compilers do, for editors to pick up.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s [%s]", d.File, d.Line, d.Column, d.Severity, d.Message, d.Rule)
+}
+
+// Options configure a lint run.
+type Options struct {
+	// Version is the Deephaven version the scripts target; deprecated
+	// functions are checked against it. Empty means unknown.
+	Version string
+}
+
+// deprecation is a query-language function that was renamed.
+type deprecation struct {
+	name, replacement, since string
+}
+
+// deprecations are the functions renamed by the DateTimeUtils overhaul.
+var deprecations = []deprecation{
+	{"convertDateTime", "parseInstant", "0.26.0"},
+	{"currentTime", "now", "0.26.0"},
+	{"nanosToTime", "epochNanosToInstant", "0.26.0"},
+	{"millisToTime", "epochMillisToInstant", "0.26.0"},
+	{"secondsToTime", "epochSecondsToInstant", "0.26.0"},
+}
+
+// Methods whose string arguments are formulas that create columns.
+var formulaMethods = map[string]bool{
+	"update": true, "update_view": true, "lazy_update": true, "view": true, "select": true,
+}
+
+// Methods whose string arguments are filters.
+var filterMethods = map[string]bool{
+	"where": true, "where_one_of": true,
+}
+
+// Methods that keep the columns of the table they are called on.
+var keepColumns = map[string]bool{
+	"where": true, "where_one_of": true, "head": true, "tail": true, "sort": true,
+	"sort_descending": true, "reverse": true, "snapshot": true, "flatten": true, "coalesce": true,
+}
+
+// Source tables whose columns are known: the chain of calls on them is
+// checked for unknown columns.
+var sourceColumns = map[string][]string{
+	"empty_table": {},
+	"time_table":  {"Timestamp"},
+}
+
+// File lints the Python source src, reporting positions in name.
+func File(name, src string, opts Options) []Diagnostic {
+	l := &linter{file: name, opts: opts, pyNames: map[string]bool{}}
+	if opts.Version != "" {
+		if v, err := versions.ParseVersion(opts.Version); err == nil {
+			l.version = &v
+		}
+	}
+	toks := scanPython(src)
+	for _, t := range toks {
+		if t.kind == tokIdent {
+			l.pyNames[t.text] = true
+		}
+	}
+	l.run(toks)
+	slices.SortStableFunc(l.diags, func(a, b Diagnostic) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	return l.diags
+}
+
+type linter struct {
+	file    string
+	opts    Options
+	version *versions.Version
+	pyNames map[string]bool // identifiers in the Python code, usable in query strings
+	diags   []Diagnostic
+}
+
+func (l *linter) report(t token, offset int, severity, rule, format string, args ...any) {
+	col := t.col
+	if t.exact {
+		col += offset
+	}
+	l.diags = append(l.diags, Diagnostic{
+		File:     l.file,
+		Line:     t.line,
+		Column:   col,
+		Severity: severity,
+		Rule:     rule,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// run finds the calls in toks and checks their query strings. The columns
+// of a table are tracked along a chain of calls on empty_table() or
+// time_table(): known maps the index of the ")" that ends a call in the
+// chain to the columns of its result.
+func (l *linter) run(toks []token) {
+	known := map[int]map[string]bool{}
+	for i := 0; i+1 < len(toks); i++ {
+		t := toks[i]
+		if t.kind != tokIdent || toks[i+1].kind != tokOp || toks[i+1].text != "(" {
+			continue
+		}
+		end := closing(toks, i+1)
+		method := i > 0 && toks[i-1].kind == tokOp && toks[i-1].text == "."
+
+		if cols, ok := sourceColumns[t.text]; ok {
+			set := map[string]bool{}
+			for _, c := range cols {
+				set[c] = true
+			}
+			known[end] = set
+			continue
+		}
+		if !method {
+			continue
+		}
+
+		var cols map[string]bool
+		if recv := i - 2; recv >= 0 {
+			if set, ok := known[recv]; ok {
+				cols = copySet(set)
+			}
+		}
+		args := stringArgs(toks, i+1, end)
+
+		switch {
+		case i >= 2 && toks[i-2].text == "agg" && t.text != "formula":
+			for _, a := range args {
+				l.checkAggColumns(a)
+			}
+		case formulaMethods[t.text]:
+			if t.text == "view" || t.text == "select" {
+				if cols != nil && len(args) > 0 {
+					// The result has only the selected columns.
+					prev := cols
+					cols = map[string]bool{}
+					for _, a := range args {
+						l.checkFormula(a, prev, cols)
+					}
+					known[end] = cols
+					continue
+				}
+			}
+			for _, a := range args {
+				l.checkFormula(a, cols, cols)
+			}
+		case filterMethods[t.text]:
+			for _, a := range args {
+				l.checkFilter(a, cols)
+			}
+		}
+		if cols != nil && (formulaMethods[t.text] || keepColumns[t.text]) {
+			known[end] = cols
+		}
+	}
+}
+
+func copySet(m map[string]bool) map[string]bool {
+	out := make(map[string]bool, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// closing returns the index of the bracket that closes toks[open].
+func closing(toks []token, open int) int {
+	depth := 0
+	for i := open; i < len(toks); i++ {
+		switch toks[i].text {
+		case "(", "[", "{":
+			if toks[i].kind == tokOp {
+				depth++
+			}
+		case ")", "]", "}":
+			if toks[i].kind == tokOp {
+				depth--
+				if depth == 0 {
+					return i
+				}
+			}
+		}
+	}
+	return len(toks) - 1
+}
+
+// stringArgs returns the string literals passed to the call whose
+// arguments are between toks[open] and toks[end], directly or in a list
+// or tuple, but not those passed to a nested call. F-strings are skipped:
+// their value is not known.
+func stringArgs(toks []token, open, end int) []token {
+	var out []token
+	var nested []bool // per open bracket in the arguments: whether it starts a call
+	for i := open + 1; i < end; i++ {
+		t := toks[i]
+		switch {
+		case t.kind == tokOp && strings.Contains("([{", t.text):
+			prev := toks[i-1]
+			nested = append(nested, t.text == "(" && (prev.kind == tokIdent || prev.text == ")" || prev.text == "]"))
+		case t.kind == tokOp && strings.Contains(")]}", t.text):
+			if len(nested) > 0 {
+				nested = nested[:len(nested)-1]
+			}
+		case t.kind == tokString && !t.fmt:
+			if len(nested) == 0 || (len(nested) == 1 && !nested[0]) {
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// checkFormula checks a formula such as "X = A * 2" or "A". Columns it
+// reads are checked against known (nil when unknown), and the column it
+// creates is added to out.
+func (l *linter) checkFormula(t token, known, out map[string]bool) {
+	f := t.text
+	lhs, rhs, offset, ok := splitAssign(f)
+	if !ok {
+		name := strings.TrimSpace(f)
+		if !isColumnName(name) {
+			l.report(t, 0, SeverityError, RuleMissingAssign, "formula %q must be NAME = EXPRESSION or an existing column name", f)
+			l.checkExpr(t, f, 0, nil)
+			return
+		}
+		if known != nil && !known[name] {
+			l.report(t, strings.Index(f, name), SeverityWarning, RuleUnknownColumn, "unknown column %q", name)
+		}
+		if out != nil {
+			out[name] = true
+		}
+		return
+	}
+	name := strings.TrimSpace(lhs)
+	if !isColumnName(name) {
+		l.report(t, 0, SeverityError, RuleInvalidColumn, "%q is not a valid column name", name)
+	}
+	l.checkExpr(t, rhs, offset, known)
+	if out != nil {
+		out[name] = true
+	}
+}
+
+// checkFilter checks a where() filter.
+func (l *linter) checkFilter(t token, known map[string]bool) {
+	l.checkExpr(t, t.text, 0, known)
+}
+
+// checkAggColumns checks an agg column spec: "NAME", "OUT = IN", or
+// several of those separated by commas.
+func (l *linter) checkAggColumns(t token) {
+	offset := 0
+	for _, part := range strings.Split(t.text, ",") {
+		names := strings.Split(part, "=")
+		valid := len(names) <= 2
+		for _, n := range names {
+			if !isColumnName(strings.TrimSpace(n)) {
+				valid = false
+			}
+		}
+		if !valid {
+			l.report(t, offset, SeverityError, RuleInvalidAggInput, "agg column %q must be NAME or OUT = IN", strings.TrimSpace(part))
+		}
+		offset += len(part) + 1
+	}
+}
+
+// splitAssign splits a formula at its top-level "=" (not ==, <=, >= or
+// !=). offset is the position of rhs in f.
+func splitAssign(f string) (lhs, rhs string, offset int, ok bool) {
+	inQuote := byte(0)
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		switch {
+		case inQuote != 0:
+			if c == inQuote {
+				inQuote = 0
+			}
+		case c == '`' || c == '\'' || c == '"':
+			inQuote = c
+		case c == '=':
+			if i+1 < len(f) && f[i+1] == '=' {
+				i++
+				continue
+			}
+			if i > 0 && strings.ContainsRune("<>!=", rune(f[i-1])) {
+				continue
+			}
+			return f[:i], f[i+1:], i + 1, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// Identifiers that are part of the query language rather than columns.
+var queryWords = map[string]bool{
+	"true": true, "false": true, "null": true, "new": true, "instanceof": true,
+	"int": true, "long": true, "double": true, "float": true, "short": true,
+	"byte": true, "char": true, "boolean": true,
+	"i": true, "ii": true, "k": true, "in": true, "icase": true,
+}
+
+// pythonOperators and pythonLiterals are Python spellings that are not
+// valid in query strings, with what to use instead.
+var pythonOperators = map[string]string{"and": "&&", "or": "||", "not": "!"}
+var pythonLiterals = map[string]string{"True": "true", "False": "false", "None": "null"}
+var pythonCasts = map[string]string{"int": "(int)X", "float": "(double)X", "str": "String.valueOf(X)", "bool": "(boolean)X"}
+
+// checkExpr checks the expression e, found at offset in the string t.
+func (l *linter) checkExpr(t token, e string, offset int, known map[string]bool) {
+	depth := []byte{}
+	pairs := map[byte]byte{')': '(', ']': '['}
+	i := 0
+	for i < len(e) {
+		c := e[i]
+		switch {
+		case c == '`' || c == '\'' || c == '"':
+			j := strings.IndexByte(e[i+1:], c)
+			if j < 0 {
+				l.report(t, offset+i, SeverityError, RuleUnbalanced, "unterminated %c quote", c)
+				return
+			}
+			i += j + 2
+			continue
+		case c == '(' || c == '[':
+			depth = append(depth, c)
+		case c == ')' || c == ']':
+			if len(depth) == 0 || depth[len(depth)-1] != pairs[c] {
+				l.report(t, offset+i, SeverityError, RuleUnbalanced, "unmatched %q", string(c))
+				return
+			}
+			depth = depth[:len(depth)-1]
+		case isIdentStart(c) || c == '$':
+			j := i
+			for j < len(e) && (isIdentPart(e[j]) || e[j] == '$') {
+				j++
+			}
+			l.checkWord(t, e, i, j, offset, known)
+			i = j
+			continue
+		case c >= '0' && c <= '9':
+			for i < len(e) && (isIdentPart(e[i]) || e[i] == '.') {
+				i++
+			}
+			continue
+		}
+		i++
+	}
+	if len(depth) > 0 {
+		l.report(t, offset+len(e), SeverityError, RuleUnbalanced, "unclosed %q", string(depth[len(depth)-1]))
+	}
+}
+
+// checkWord checks the identifier e[i:j].
+func (l *linter) checkWord(t token, e string, i, j, offset int, known map[string]bool) {
+	word := e[i:j]
+	pos := offset + i
+	rest := strings.TrimLeft(e[j:], " \t")
+	call := strings.HasPrefix(rest, "(")
+	member := strings.HasSuffix(strings.TrimRight(e[:i], " \t"), ".")
+
+	if r, ok := pythonOperators[word]; ok {
+		l.report(t, pos, SeverityError, RulePythonOperator, "use %s instead of Python's %q", r, word)
+		return
+	}
+	if r, ok := pythonLiterals[word]; ok {
+		l.report(t, pos, SeverityError, RulePythonLiteral, "use %s instead of Python's %s", r, word)
+		return
+	}
+	if call && !member {
+		if r, ok := pythonCasts[word]; ok {
+			l.report(t, pos, SeverityError, RulePythonCast, "%s(X) is Python; use %s", word, r)
+			return
+		}
+		l.checkDeprecated(t, word, pos)
+		return
+	}
+	if call || member || strings.HasPrefix(rest, ".") || queryWords[word] || l.pyNames[word] {
+		return
+	}
+	if known != nil && !known[word] && !isConstant(word) {
+		l.report(t, pos, SeverityWarning, RuleUnknownColumn, "unknown column %q", word)
+	}
+}
+
+func (l *linter) checkDeprecated(t token, word string, pos int) {
+	for _, d := range deprecations {
+		if d.name != word {
+			continue
+		}
+		since, _ := versions.ParseVersion(d.since)
+		switch {
+		case l.version == nil:
+			l.report(t, pos, SeverityWarning, RuleDeprecated, "%s was renamed to %s in %s", d.name, d.replacement, d.since)
+		case l.version.Compare(since) >= 0:
+			l.report(t, pos, SeverityError, RuleDeprecated, "%s was renamed to %s in %s and is not available in %s", d.name, d.replacement, d.since, l.opts.Version)
+		}
+	}
+}
+
+// isConstant reports whether word is one of the query language's
+// constants, such as NULL_INT or MAX_DOUBLE.
+func isConstant(word string) bool {
+	for _, p := range []string{"NULL_", "MIN_", "MAX_", "POS_INF_", "NEG_INF_", "NAN_"} {
+		if strings.HasPrefix(word, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isColumnName reports whether s is a valid column name: a Java
+// identifier.
+func isColumnName(s string) bool {
+	if s == "" || !(isIdentStart(s[0]) || s[0] == '$') {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentPart(s[i]) && s[i] != '$' {
+			return false
+		}
+	}
+	return !queryWords[s]
+}
@@ -0,0 +1,191 @@
+package lint
+
+import "strings"
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokNumber
+	tokOp
+	tokNewline // end of a logical line
+)
+
+// token is a Python token. For strings, text is the decoded value and
+// line/col locate its first character; col is 1-based, in bytes.
+type token struct {
+	kind  tokenKind
+	text  string
+	line  int
+	col   int
+	fmt   bool // an f-string, whose value is not known statically
+	exact bool // a single-line string without escapes: offsets into text map to columns
+}
+
+// scanPython splits Python source into the tokens the linter needs. It is
+// not a full tokenizer: it knows strings, comments, identifiers and
+// bracket nesting, which is enough to find calls and their string
+// arguments.
+func scanPython(src string) []token {
+	var toks []token
+	line, lineStart, depth := 1, 0, 0
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			if depth == 0 {
+				toks = append(toks, token{kind: tokNewline, line: line, col: i - lineStart + 1})
+			}
+			line++
+			i++
+			lineStart = i
+		case c == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			// explicit line continuation
+			line++
+			i += 2
+			lineStart = i
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			tok, n, lines, last := scanString(src, i, "", line, i-lineStart+1)
+			toks = append(toks, tok)
+			i += n
+			if lines > 0 {
+				line += lines
+				lineStart = last
+			}
+		case isIdentStart(c):
+			j := i
+			for j < len(src) && isIdentPart(src[j]) {
+				j++
+			}
+			word := src[i:j]
+			if j < len(src) && (src[j] == '"' || src[j] == '\'') && isStringPrefix(word) {
+				tok, n, lines, last := scanString(src, j, word, line, i-lineStart+1)
+				toks = append(toks, tok)
+				i = j + n
+				if lines > 0 {
+					line += lines
+					lineStart = last
+				}
+				continue
+			}
+			toks = append(toks, token{kind: tokIdent, text: word, line: line, col: i - lineStart + 1})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (isIdentPart(src[j]) || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[i:j], line: line, col: i - lineStart + 1})
+			i = j
+		default:
+			switch c {
+			case '(', '[', '{':
+				depth++
+			case ')', ']', '}':
+				if depth > 0 {
+					depth--
+				}
+			}
+			toks = append(toks, token{kind: tokOp, text: string(c), line: line, col: i - lineStart + 1})
+			i++
+		}
+	}
+	return toks
+}
+
+// scanString scans the string literal whose opening quote is at src[start].
+// It returns the token, the bytes consumed from start, the newlines inside
+// the literal and the offset just after the last of them.
+func scanString(src string, start int, prefix string, line, col int) (token, int, int, int) {
+	p := strings.ToLower(prefix)
+	raw := strings.Contains(p, "r")
+	quote := src[start : start+1]
+	if strings.HasPrefix(src[start:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+
+	var b strings.Builder
+	lines, last := 0, 0
+	escaped := false
+	i := start + len(quote)
+	for i < len(src) {
+		if strings.HasPrefix(src[i:], quote) {
+			i += len(quote)
+			break
+		}
+		c := src[i]
+		if c == '\n' {
+			if len(quote) == 1 {
+				break // unterminated
+			}
+			lines++
+			last = i + 1
+		}
+		if c == '\\' && i+1 < len(src) {
+			escaped = true
+			next := src[i+1]
+			if next == '\n' {
+				lines++
+				last = i + 2
+			}
+			if raw {
+				b.WriteByte(c)
+				b.WriteByte(next)
+			} else {
+				b.WriteString(unescape(next))
+			}
+			i += 2
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+
+	tok := token{
+		kind:  tokString,
+		text:  b.String(),
+		line:  line,
+		col:   col + len(prefix) + len(quote),
+		fmt:   strings.Contains(p, "f"),
+		exact: lines == 0 && !escaped,
+	}
+	return tok, i - start, lines, last
+}
+
+func unescape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case '\\', '\'', '"':
+		return string(c)
+	case '\n':
+		return ""
+	}
+	return "\\" + string(c)
+}
+
+func isStringPrefix(s string) bool {
+	switch strings.ToLower(s) {
+	case "r", "u", "b", "f", "br", "rb", "fr", "rf":
+		return true
+	}
+	return false
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,166 @@
+// Package notebook sets up Jupyter for a Deephaven version: a kernel that
+// runs in the version's venv and starts an embedded server (or connects to
+// a running one) when it starts, and JupyterLab to use it from.
+package notebook
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	dhexec "github.com/dsmmcken/dh-cli/src/internal/exec"
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+//go:embed startup.py
+var startupScript string
+
+// Kernel is the configuration of the kernel for one Deephaven version.
+// With Host set the kernel connects to that server instead of starting
+// an embedded one.
+type Kernel struct {
+	Version   string
+	PythonBin string
+	JavaHome  string
+	JVMArgs   string
+	Port      int
+
+	Host      string
+	AuthType  string
+	AuthToken string
+	TLS       bool
+}
+
+// Name is the kernel name. There is one kernel per version; installing it
+// again replaces its configuration, so notebooks that use it keep working.
+func (k *Kernel) Name() string {
+	return "deephaven-" + k.Version
+}
+
+// DisplayName is the name Jupyter shows in its kernel list.
+func (k *Kernel) DisplayName() string {
+	if k.Host != "" {
+		return fmt.Sprintf("Deephaven %s (%s:%d)", k.Version, k.Host, k.Port)
+	}
+	return fmt.Sprintf("Deephaven %s", k.Version)
+}
+
+// KernelSpec is the content of a Jupyter kernel.json.
+type KernelSpec struct {
+	Argv        []string          `json:"argv"`
+	DisplayName string            `json:"display_name"`
+	Language    string            `json:"language"`
+	Env         map[string]string `json:"env"`
+}
+
+// Spec returns the kernel.json for k, running the startup script at
+// startupPath when the kernel starts.
+func (k *Kernel) Spec(startupPath string) KernelSpec {
+	env := map[string]string{
+		"DH_NOTEBOOK_PORT": strconv.Itoa(k.Port),
+	}
+	if k.Host != "" {
+		env["DH_NOTEBOOK_MODE"] = "remote"
+		env["DH_NOTEBOOK_HOST"] = k.Host
+		if k.AuthType != "" {
+			env["DH_NOTEBOOK_AUTH_TYPE"] = k.AuthType
+		}
+		if k.AuthToken != "" {
+			env["DH_NOTEBOOK_AUTH_TOKEN"] = k.AuthToken
+		}
+		if k.TLS {
+			env["DH_NOTEBOOK_TLS"] = "1"
+		}
+	} else {
+		env["DH_NOTEBOOK_MODE"] = "embedded"
+		env["DH_NOTEBOOK_JVM_ARGS"] = k.JVMArgs
+		env["JAVA_HOME"] = k.JavaHome
+	}
+	return KernelSpec{
+		Argv: []string{
+			k.PythonBin, "-m", "ipykernel_launcher",
+			"-f", "{connection_file}",
+			"--IPKernelApp.exec_files=" + startupPath,
+		},
+		DisplayName: k.DisplayName(),
+		Language:    "python",
+		Env:         env,
+	}
+}
+
+// DataDir is the Jupyter data directory dh keeps its kernels in. Jupyter
+// finds them when it is on JUPYTER_PATH.
+func DataDir(dhHome string) string {
+	return filepath.Join(dhHome, "jupyter")
+}
+
+// Install writes the kernel for k (kernel.json and its startup script)
+// under DataDir and returns its directory.
+func Install(dhHome string, k *Kernel) (string, error) {
+	dir := filepath.Join(DataDir(dhHome), "kernels", k.Name())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", dir, err)
+	}
+	startup := filepath.Join(dir, "startup.py")
+	if err := os.WriteFile(startup, []byte(startupScript), 0o644); err != nil {
+		return "", fmt.Errorf("writing %s: %w", startup, err)
+	}
+	data, err := json.MarshalIndent(k.Spec(startup), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// 0600: the spec may hold an auth token
+	path := filepath.Join(dir, "kernel.json")
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return "", fmt.Errorf("writing %s: %w", path, err)
+	}
+	return dir, nil
+}
+
+// requirements are the modules the kernel and JupyterLab need, and the
+// packages that provide them.
+var requirements = []struct{ module, pkg string }{
+	{"ipykernel", "ipykernel"},
+	{"jupyterlab", "jupyterlab"},
+}
+
+// EnsureJupyter installs ipykernel and JupyterLab into the venv of
+// pythonBin if they are missing.
+func EnsureJupyter(pythonBin string, quiet bool, stderr io.Writer) error {
+	var missing []string
+	for _, r := range requirements {
+		check := dhexec.ExecCommand(pythonBin, "-c", "import "+r.module)
+		if err := check.Run(); err != nil {
+			missing = append(missing, r.pkg)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if !quiet && stderr != nil {
+		fmt.Fprintln(stderr, "Installing Jupyter...")
+	}
+	args := append([]string{"pip", "install", "--python", pythonBin}, missing...)
+	install := dhexec.ExecCommand("uv", args...)
+	install.Stderr = stderr
+	output.TraceCommand(install)
+	if err := install.Run(); err != nil {
+		return fmt.Errorf("installing Jupyter: %w", err)
+	}
+	return nil
+}
+
+// JupyterPathEnv returns the JUPYTER_PATH setting that puts dh's kernels
+// ahead of any already on the path.
+func JupyterPathEnv(dhHome string) string {
+	p := DataDir(dhHome)
+	if cur := os.Getenv("JUPYTER_PATH"); cur != "" {
+		p += string(os.PathListSeparator) + cur
+	}
+	return "JUPYTER_PATH=" + p
+}
@@ -0,0 +1,45 @@
+# Runs when a dh notebook kernel starts. Starts an embedded Deephaven server,
+# or connects to a running one, as configured by dh notebook through the
+# DH_NOTEBOOK_* variables in the kernel's environment.
+
+
+def __dh_notebook_start():
+    import os
+    import socket
+
+    env = os.environ.get
+    port = int(env("DH_NOTEBOOK_PORT", "10000"))
+
+    if env("DH_NOTEBOOK_MODE") == "remote":
+        from pydeephaven import Session
+
+        kwargs = {}
+        if env("DH_NOTEBOOK_AUTH_TYPE"):
+            kwargs["auth_type"] = env("DH_NOTEBOOK_AUTH_TYPE")
+        if env("DH_NOTEBOOK_AUTH_TOKEN"):
+            kwargs["auth_token"] = env("DH_NOTEBOOK_AUTH_TOKEN")
+        if env("DH_NOTEBOOK_TLS"):
+            kwargs["use_tls"] = True
+        host = env("DH_NOTEBOOK_HOST", "localhost")
+        session = Session(host=host, port=port, **kwargs)
+        print(f"Connected to Deephaven at {host}:{port}; use `session` to run queries")
+        return {"session": session}
+
+    # Each kernel starts its own server, so fall back to a free port
+    with socket.socket(socket.AF_INET, socket.SOCK_STREAM) as s:
+        try:
+            s.bind(("", port))
+        except OSError:
+            s.bind(("", 0))
+        port = s.getsockname()[1]
+
+    from deephaven_server import Server
+
+    server = Server(port=port, jvm_args=env("DH_NOTEBOOK_JVM_ARGS", "-Xmx4g").split())
+    server.start()
+    print(f"Deephaven server running at http://localhost:{port}")
+    return {"server": server}
+
+
+globals().update(__dh_notebook_start())
+del __dh_notebook_start
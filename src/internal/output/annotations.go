@@ -0,0 +1,90 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var flagAnnotate bool
+
+// SetAnnotate turns GitHub Actions annotations on or off. The root command
+// turns them on for --annotate, and by default when running in GitHub
+// Actions.
+func SetAnnotate(on bool) { flagAnnotate = on }
+
+// IsAnnotate returns true when failures should also be reported as GitHub
+// Actions annotations. It is never true in --json mode.
+func IsAnnotate() bool { return flagAnnotate && !flagJSON }
+
+// Annotation levels.
+const (
+	AnnotationError   = "error"
+	AnnotationWarning = "warning"
+	AnnotationNotice  = "notice"
+)
+
+// Annotation is a GitHub Actions workflow annotation. File, Line and Col
+// are optional; without File it shows on the run rather than in the diff.
+type Annotation struct {
+	Level   string // AnnotationError, AnnotationWarning or AnnotationNotice
+	File    string
+	Line    int
+	Col     int
+	Title   string
+	Message string
+}
+
+// Annotate writes a as a workflow command (::error file=...::message).
+func Annotate(w io.Writer, a Annotation) {
+	var props []string
+	if a.File != "" {
+		props = append(props, "file="+escapeProperty(AnnotationPath(a.File)))
+		if a.Line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", a.Line))
+		}
+		if a.Col > 0 {
+			props = append(props, fmt.Sprintf("col=%d", a.Col))
+		}
+	}
+	if a.Title != "" {
+		props = append(props, "title="+escapeProperty(a.Title))
+	}
+	cmd := "::" + a.Level
+	if len(props) > 0 {
+		cmd += " " + strings.Join(props, ",")
+	}
+	fmt.Fprintf(w, "%s::%s\n", cmd, escapeMessage(a.Message))
+}
+
+// AnnotationPath returns path relative to the repository checkout
+// ($GITHUB_WORKSPACE, or else the working directory), which is how
+// annotations name files.
+func AnnotationPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	root := os.Getenv("GITHUB_WORKSPACE")
+	if root == "" {
+		root, _ = os.Getwd()
+	}
+	if rel, err := filepath.Rel(root, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(abs)
+}
+
+// escapeMessage escapes the message of a workflow command.
+func escapeMessage(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command. The
+// runner splits properties on "," and ends them at "::", so a lone ":"
+// (as in "dh doctor: Java") can stay readable.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ",", "%2C", "::", "%3A%3A").Replace(s)
+}
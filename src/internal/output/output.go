@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
 )
 
 // Exit codes
@@ -13,6 +17,7 @@ const (
 	ExitNetwork     = 2
 	ExitTimeout     = 3
 	ExitNotFound    = 4
+	ExitAssertion   = 5 // dh exec: the script raised AssertionError
 	ExitInterrupted = 130
 )
 
@@ -20,6 +25,9 @@ var (
 	flagJSON    bool
 	flagQuiet   bool
 	flagVerbose bool
+	flagTrace   bool
+
+	traceWriter io.Writer = os.Stderr
 )
 
 // SetFlags is called by the root command's PersistentPreRun to propagate flag values.
@@ -38,6 +46,75 @@ func IsQuiet() bool { return flagQuiet }
 // IsVerbose returns true when --verbose mode is active.
 func IsVerbose() bool { return flagVerbose }
 
+// SetTrace turns trace output on or off. The root command turns it on for
+// -vv.
+func SetTrace(on bool) { flagTrace = on }
+
+// IsTrace returns true when trace output (-vv) is active. Check it before
+// building an expensive trace message.
+func IsTrace() bool { return flagTrace }
+
+// SetTraceWriter redirects trace output, which goes to stderr by default,
+// and returns the previous writer.
+func SetTraceWriter(w io.Writer) io.Writer {
+	old := traceWriter
+	traceWriter = w
+	return old
+}
+
+// Tracef writes a timestamped line of internals (page faults, wire frames,
+// subprocess command lines) when -vv is given. --verbose stays limited to
+// what a user needs to follow a run; Tracef is for debugging dh itself.
+func Tracef(format string, args ...any) {
+	if !flagTrace {
+		return
+	}
+	fmt.Fprintf(traceWriter, "[trace %s] %s\n", time.Now().Format("15:04:05.000"), fmt.Sprintf(format, args...))
+}
+
+// TraceCommand traces the command line cmd is about to run. Arguments
+// longer than a line, such as scripts passed with -c, are elided, and so
+// are the values of token and password flags.
+func TraceCommand(cmd *exec.Cmd) {
+	if !flagTrace {
+		return
+	}
+	args := make([]string, len(cmd.Args))
+	secret := false
+	for i, a := range cmd.Args {
+		name, _, hasValue := strings.Cut(a, "=")
+		isSecretFlag := strings.HasPrefix(a, "-") && (strings.Contains(name, "token") || strings.Contains(name, "password"))
+		switch {
+		case secret:
+			args[i] = "<redacted>"
+		case isSecretFlag && hasValue:
+			args[i] = name + "=<redacted>"
+		case strings.Contains(a, "\n") || len(a) > 200:
+			args[i] = fmt.Sprintf("<%d bytes>", len(a))
+		case a == "" || strings.ContainsAny(a, " \t'\"$\\|&;<>()*?"):
+			args[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		default:
+			args[i] = a
+		}
+		secret = isSecretFlag && !hasValue
+	}
+	Tracef("run: %s", strings.Join(args, " "))
+}
+
+// TraceFrame traces a message sent (dir "->") or received ("<-") over a
+// socket, cut to the first 2 KiB.
+func TraceFrame(channel, dir string, data []byte) {
+	if !flagTrace {
+		return
+	}
+	const max = 2048
+	text := strings.TrimRight(string(data), "\n")
+	if len(text) > max {
+		text = fmt.Sprintf("%s... (%d bytes)", text[:max], len(data))
+	}
+	Tracef("%s %s %s", channel, dir, text)
+}
+
 // PrintJSON marshals v as JSON and writes it to w.
 func PrintJSON(w io.Writer, v any) error {
 	data, err := json.MarshalIndent(v, "", "  ")
@@ -0,0 +1,298 @@
+package portable
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archive writes a portable export.
+type archive struct {
+	out  string
+	f    *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+	done bool
+}
+
+// createArchive creates out, gzipped if its name ends in .gz or .tgz.
+func createArchive(out string) (*archive, error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	a := &archive{out: out, f: f}
+	var w io.Writer = f
+	if strings.HasSuffix(out, ".gz") || strings.HasSuffix(out, ".tgz") {
+		a.gz = gzip.NewWriter(f)
+		w = a.gz
+	}
+	a.tw = tar.NewWriter(w)
+	return a, nil
+}
+
+func (a *archive) close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		if err := a.gz.Close(); err != nil {
+			return err
+		}
+	}
+	a.done = true
+	return a.f.Close()
+}
+
+// abort removes the archive unless close succeeded.
+func (a *archive) abort() {
+	if !a.done {
+		a.f.Close()
+		os.Remove(a.out)
+	}
+}
+
+func (a *archive) addBytes(name string, data []byte, mode int64, mtime time.Time) error {
+	if err := a.tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(data)), ModTime: mtime}); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+// addFile adds the file at src as name, following symlinks.
+func (a *archive) addFile(src, name string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return a.addRegular(src, name, info)
+}
+
+func (a *archive) addRegular(src, name string, info fs.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(a.tw, f)
+	return err
+}
+
+// addTree adds the directory src as name. Symlinks into src, or into a
+// directory in remap (source path to archive name), stay links, made
+// relative so they survive the move; other symlinks are replaced by what
+// they point to.
+func (a *archive) addTree(src, name string, remap map[string]string) error {
+	roots := map[string]string{src: name}
+	for k, v := range remap {
+		roots[k] = v
+	}
+	return a.walk(src, name, roots)
+}
+
+func (a *archive) walk(src, name string, roots map[string]string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		entry := path.Join(name, filepath.ToSlash(rel))
+
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = entry + "/"
+			return a.tw.WriteHeader(hdr)
+
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(p), target)
+			}
+			if mapped, ok := mapPath(filepath.Clean(target), roots); ok {
+				link, err := filepath.Rel(path.Dir(entry), mapped)
+				if err != nil {
+					return err
+				}
+				return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: entry, Linkname: filepath.ToSlash(link), Mode: 0o777})
+			}
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil // dangling link
+			}
+			if info.IsDir() {
+				return a.walk(target, entry, roots)
+			}
+			return a.addRegular(p, entry, info)
+
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return a.addRegular(p, entry, info)
+		}
+		return nil // sockets, devices and the like
+	})
+}
+
+// mapPath returns the archive name of target if it is in one of roots,
+// trying the longest root first.
+func mapPath(target string, roots map[string]string) (string, bool) {
+	keys := make([]string, 0, len(roots))
+	for k := range roots {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for _, k := range keys {
+		rel, err := filepath.Rel(k, target)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return path.Join(roots[k], filepath.ToSlash(rel)), true
+		}
+	}
+	return "", false
+}
+
+// extractArchive unpacks a portable export into dest and returns the name
+// of the portable directory in it. Entries outside that directory, and
+// links that point out of it, are refused. Entries are written through an
+// os.Root of the directory, so no chain of links can lead a later entry
+// out of it either.
+func extractArchive(archivePath, dest string) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(f, magic); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r = gz
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	notPortable := fmt.Errorf("%s is not a dh portable export: no %s", archivePath, ManifestName)
+	tr := tar.NewReader(r)
+	top := ""
+	var root *os.Root
+	defer func() {
+		if root != nil {
+			root.Close()
+		}
+	}()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			if top == "" {
+				return "", notPortable
+			}
+			return top, nil
+		}
+		if err != nil {
+			if top == "" {
+				return "", notPortable
+			}
+			return "", fmt.Errorf("unpacking %s: %w", archivePath, err)
+		}
+		name := path.Clean(hdr.Name)
+		if top == "" {
+			// The manifest comes first.
+			dir, file := path.Split(name)
+			dir = strings.TrimSuffix(dir, "/")
+			if file != ManifestName || dir == "" || strings.Contains(dir, "/") || dir == ".." {
+				return "", notPortable
+			}
+			top = dir
+			if _, err := os.Stat(filepath.Join(dest, top)); err == nil {
+				return "", fmt.Errorf("%s already exists", filepath.Join(dest, top))
+			}
+			if err := os.MkdirAll(filepath.Join(dest, top), 0o755); err != nil {
+				return "", err
+			}
+			if root, err = os.OpenRoot(filepath.Join(dest, top)); err != nil {
+				return "", err
+			}
+		}
+		rel, ok := strings.CutPrefix(name, top+"/")
+		if name == top {
+			rel, ok = ".", true
+		}
+		if !ok {
+			return "", fmt.Errorf("unpacking %s: %s is outside %s", archivePath, hdr.Name, top)
+		}
+		target := filepath.FromSlash(rel)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(target, 0o755); err != nil {
+				return "", fmt.Errorf("unpacking %s: %w", archivePath, err)
+			}
+		case tar.TypeReg:
+			if err := root.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return "", fmt.Errorf("unpacking %s: %w", archivePath, err)
+			}
+			out, err := root.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return "", fmt.Errorf("unpacking %s: %w", archivePath, err)
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return "", err
+			}
+			if err := out.Close(); err != nil {
+				return "", err
+			}
+		case tar.TypeSymlink:
+			linked := path.Join(path.Dir(name), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || (linked != top && !strings.HasPrefix(linked, top+"/")) {
+				return "", fmt.Errorf("unpacking %s: link %s points outside %s", archivePath, hdr.Name, top)
+			}
+			if err := root.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return "", fmt.Errorf("unpacking %s: %w", archivePath, err)
+			}
+			if err := root.Symlink(filepath.FromSlash(hdr.Linkname), target); err != nil {
+				return "", fmt.Errorf("unpacking %s: %w", archivePath, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Package portable exports an installed version as a self-contained,
+// relocatable directory: the dh binary, the version's venv and the Python
+// it was made from, a JDK, and a launcher script. Unpacked anywhere on a
+// machine of the same OS and architecture, it runs without network access,
+// package managers or a separate dh install.
+package portable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+)
+
+// FormatVersion is the layout version written to manifest.json.
+const FormatVersion = 1
+
+// ManifestName is the manifest file at the top of the portable directory.
+const ManifestName = "manifest.json"
+
+// LauncherName is the script that runs dh from the portable directory.
+const LauncherName = "dh"
+
+// locationFile records where the directory was last relocated to.
+const locationFile = ".location"
+
+// Manifest describes a portable directory.
+type Manifest struct {
+	Format  int       `json:"format"`
+	Version string    `json:"version"`
+	Python  string    `json:"python"`
+	Java    string    `json:"java,omitempty"` // "" if no JDK is included
+	OS      string    `json:"os"`
+	Arch    string    `json:"arch"`
+	Created time.Time `json:"created"`
+	Tool    string    `json:"tool"` // dh CLI version that exported it
+}
+
+// ExportOptions configures Export.
+type ExportOptions struct {
+	DHHome  string
+	Version string
+	Binary  string // dh binary to include
+	Tool    string
+	NoJava  bool // leave out the JDK, for hosts that have Java
+}
+
+// Layout of the portable directory.
+const (
+	binDir    = "bin"
+	pythonDir = "python"
+	homeDir   = "home" // DH_HOME of the launcher
+	jdkDir    = "home/java/jdk"
+)
+
+// Export writes version as a portable directory, named after out, into the
+// tar out (gzipped if out ends in .gz or .tgz).
+func Export(opts ExportOptions, out string, onProgress func(string)) (*Manifest, error) {
+	progress := func(msg string) {
+		if onProgress != nil {
+			onProgress(msg)
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("portable exports are not supported on Windows")
+	}
+
+	versionDir := filepath.Join(opts.DHHome, "versions", opts.Version)
+	venv := filepath.Join(versionDir, ".venv")
+	if _, err := os.Stat(venv); err != nil {
+		return nil, fmt.Errorf("version %s is not installed; run: dh install %s", opts.Version, opts.Version)
+	}
+	cfg, err := readPyvenvCfg(venv)
+	if err != nil {
+		return nil, err
+	}
+	pythonHome := filepath.Dir(cfg["home"])
+	if cfg["home"] == "" || !standalonePython(pythonHome) {
+		return nil, fmt.Errorf("the venv of %s uses the Python in %s, which can't be moved to another machine; reinstall it with a Python managed by uv (dh uninstall %s && dh install %s)",
+			opts.Version, pythonHome, opts.Version, opts.Version)
+	}
+
+	m := &Manifest{
+		Format:  FormatVersion,
+		Version: opts.Version,
+		Python:  cfg["version_info"],
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Created: time.Now().UTC(),
+		Tool:    opts.Tool,
+	}
+	if m.Python == "" {
+		m.Python = cfg["version"]
+	}
+
+	var jdk *java.JavaInfo
+	if !opts.NoJava {
+		jdk, _ = java.Detect(opts.DHHome)
+		if jdk == nil || !jdk.Found {
+			return nil, fmt.Errorf("Java not found; install it with dh java install, or pass --no-java if the target has Java")
+		}
+		m.Java = jdk.Version
+	}
+
+	top := DirName(out)
+	a, err := createArchive(out)
+	if err != nil {
+		return nil, err
+	}
+	defer a.abort()
+	join := func(parts ...string) string { return top + "/" + strings.Join(parts, "/") }
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := a.addBytes(join(ManifestName), manifest, 0o644, m.Created); err != nil {
+		return nil, err
+	}
+	if err := a.addBytes(join(LauncherName), []byte(launcher(jdk != nil)), 0o755, m.Created); err != nil {
+		return nil, err
+	}
+	config := fmt.Sprintf("default_version = %q\n", opts.Version)
+	if err := a.addBytes(join(homeDir, "config.toml"), []byte(config), 0o644, m.Created); err != nil {
+		return nil, err
+	}
+
+	progress("Adding dh...")
+	if err := a.addFile(opts.Binary, join(binDir, "dh")); err != nil {
+		return nil, fmt.Errorf("adding dh binary: %w", err)
+	}
+
+	progress(fmt.Sprintf("Adding Python from %s...", pythonHome))
+	if err := a.addTree(pythonHome, join(pythonDir), nil); err != nil {
+		return nil, fmt.Errorf("adding Python: %w", err)
+	}
+
+	// Links from the venv to its Python become relative links into python/.
+	progress(fmt.Sprintf("Adding Deephaven %s venv...", opts.Version))
+	remap := map[string]string{pythonHome: join(pythonDir)}
+	if err := a.addTree(versionDir, join(homeDir, "versions", opts.Version), remap); err != nil {
+		return nil, fmt.Errorf("adding venv: %w", err)
+	}
+
+	if jdk != nil {
+		progress(fmt.Sprintf("Adding Java %s from %s...", jdk.Version, jdk.Home))
+		if err := a.addTree(jdk.Home, join(jdkDir), nil); err != nil {
+			return nil, fmt.Errorf("adding JDK: %w", err)
+		}
+	}
+
+	progress(fmt.Sprintf("Writing %s...", out))
+	if err := a.close(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImportResult reports what Import did.
+type ImportResult struct {
+	Manifest *Manifest `json:"manifest"`
+	Dir      string    `json:"dir"` // the portable directory
+}
+
+// Import unpacks a portable export into dest and relocates it there. If
+// path is already an unpacked portable directory, it is only relocated.
+func Import(path, dest string) (*ImportResult, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		m, err := Relocate(path)
+		if err != nil {
+			return nil, err
+		}
+		return &ImportResult{Manifest: m, Dir: path}, nil
+	}
+
+	top, err := extractArchive(path, dest)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(dest, top)
+	m, err := Relocate(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &ImportResult{Manifest: m, Dir: dir}, nil
+}
+
+// ReadManifest reads the manifest of an unpacked portable directory.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s is not a dh portable directory: no %s", dir, ManifestName)
+		}
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", ManifestName, err)
+	}
+	if m.Format != FormatVersion {
+		return nil, fmt.Errorf("unsupported portable format %d (this dh reads format %d)", m.Format, FormatVersion)
+	}
+	return &m, nil
+}
+
+// Relocate points the venv of a portable directory at the Python in the
+// directory's current location. The launcher runs it whenever the
+// directory has moved.
+func Relocate(dir string) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m.OS != runtime.GOOS || m.Arch != runtime.GOARCH {
+		return nil, fmt.Errorf("portable directory is for %s/%s, this machine is %s/%s", m.OS, m.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = real
+	}
+
+	venv := filepath.Join(abs, homeDir, "versions", m.Version, ".venv")
+	if err := setPyvenvHome(venv, filepath.Join(abs, pythonDir, "bin")); err != nil {
+		return nil, err
+	}
+	if err := fixShebangs(filepath.Join(venv, "bin"), filepath.Join(venv, "bin", "python")); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(abs, locationFile), []byte(abs+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// launcher returns the launcher script. It runs the bundled dh with the
+// directory as DH_HOME, relocating first if the directory has moved.
+func launcher(withJava bool) string {
+	var b strings.Builder
+	b.WriteString(`#!/bin/sh
+# Runs dh from this portable directory (made by dh export-portable).
+here=$(cd "$(dirname "$0")" && pwd -P)
+export DH_HOME="$here/` + homeDir + `"
+`)
+	if withJava {
+		b.WriteString(`export JAVA_HOME="$here/` + jdkDir + `"
+`)
+	}
+	b.WriteString(`if [ "$(cat "$here/` + locationFile + `" 2>/dev/null)" != "$here" ]; then
+    "$here/` + binDir + `/dh" import-portable "$here" --quiet || exit 1
+fi
+exec "$here/` + binDir + `/dh" "$@"
+`)
+	return b.String()
+}
+
+// DirName returns the name of the portable directory in the archive out:
+// its file name without the extension.
+func DirName(out string) string {
+	name := filepath.Base(out)
+	for _, ext := range []string{".gz", ".tgz", ".tar"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "" || name == "." {
+		return "dh-portable"
+	}
+	return name
+}
+
+// standalonePython reports whether the Python installed under prefix can
+// be copied to another machine: it must not be the system's or a package
+// manager's, which depend on files outside prefix.
+func standalonePython(prefix string) bool {
+	switch prefix {
+	case "/", "/usr", "/usr/local", "/opt/homebrew", "/opt/local":
+		return false
+	}
+	for _, p := range []string{"/System/", "/Library/", "/opt/homebrew/", "/usr/local/Cellar/", "/nix/store/"} {
+		if strings.HasPrefix(prefix, p) {
+			return false
+		}
+	}
+	_, err := os.Stat(filepath.Join(prefix, "bin"))
+	return err == nil
+}
+
+// readPyvenvCfg reads the key = value lines of a venv's pyvenv.cfg.
+func readPyvenvCfg(venv string) (map[string]string, error) {
+	data, err := os.ReadFile(filepath.Join(venv, "pyvenv.cfg"))
+	if err != nil {
+		return nil, fmt.Errorf("reading venv config: %w", err)
+	}
+	cfg := map[string]string{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), "="); ok {
+			cfg[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return cfg, nil
+}
+
+// setPyvenvHome rewrites the home line of a venv's pyvenv.cfg.
+func setPyvenvHome(venv, home string) error {
+	path := filepath.Join(venv, "pyvenv.cfg")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading venv config: %w", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, l := range lines {
+		if k, _, ok := strings.Cut(l, "="); ok && strings.TrimSpace(k) == "home" {
+			lines[i] = "home = " + home
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644)
+}
+
+// venvPythonRE matches the venv's python in the shebang of a console
+// script, wherever the venv was.
+var venvPythonRE = regexp.MustCompile(`/[^\s'"]*/\.venv/bin/python[0-9.]*`)
+
+// fixShebangs points the console scripts in binDir at python.
+func fixShebangs(binDir, python string) error {
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(binDir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil || !bytes.HasPrefix(data, []byte("#!")) {
+			continue
+		}
+		// The interpreter is on the first line, or for long paths in the
+		// exec line of a /bin/sh trampoline just after it.
+		head, rest := data, []byte(nil)
+		if i := nthIndex(data, '\n', 3); i >= 0 {
+			head, rest = data[:i], data[i:]
+		}
+		fixed := venvPythonRE.ReplaceAll(head, []byte(python))
+		if bytes.Equal(fixed, head) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, append(fixed, rest...), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nthIndex returns the index of the nth c in data, or -1.
+func nthIndex(data []byte, c byte, n int) int {
+	for i, b := range data {
+		if b == c {
+			n--
+			if n == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -8,6 +8,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dsmmcken/dh-cli/src/internal/tui"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
 )
 
 // SessionStartedMsg is sent when the Python session is ready.
@@ -29,12 +30,21 @@ type TableUpdateMsg struct {
 	Response *Response
 }
 
+// SnapshotMsg is sent when a ticking table's viewport has been frozen into
+// a new static table.
+type SnapshotMsg struct {
+	Source string
+	Name   string
+	Err    error
+}
+
 // REPLModel is the top-level bubbletea model for the REPL.
 type REPLModel struct {
 	input      InputModel
 	tabbar     TabBarModel
 	logview    LogViewModel
 	tableviews map[string]*TableViewModel
+	cellDetail *CellDetailModel // open cell detail popup, or nil
 	sidebar    SidebarModel
 	history    *History
 
@@ -52,8 +62,12 @@ type REPLModel struct {
 // NewREPLModel creates a new REPL model with the given session config.
 func NewREPLModel(cfg SessionConfig) REPLModel {
 	history := NewHistory(cfg.DHHome)
+	input := NewInput(history)
+	if cfg.Language == "groovy" {
+		input.textarea.Placeholder = "Enter Groovy code..."
+	}
 	return REPLModel{
-		input:      NewInput(history),
+		input:      input,
 		tabbar:     NewTabBar(),
 		logview:    NewLogView(),
 		tableviews: make(map[string]*TableViewModel),
@@ -112,6 +126,46 @@ func (m REPLModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		}
 
+		// The cell detail popup takes all keys while open
+		if m.cellDetail != nil {
+			updated, cmd := m.cellDetail.Update(msg)
+			*m.cellDetail = updated
+			return m, cmd
+		}
+
+		// ctrl+s freezes the loaded rows of a ticking table into a new tab
+		if msg.String() == "ctrl+s" {
+			if tv, ok := m.tableviews[m.activeView]; ok && tv.isRefreshing && m.session != nil {
+				return m, m.snapshotTable(m.activeView, tv)
+			}
+			return m, nil
+		}
+
+		// With an empty prompt on a table tab, ←/→ pick a column, enter
+		// opens the selected cell in the detail popup and home/end jump to
+		// the first/last row of the whole table
+		if tv, ok := m.tableviews[m.activeView]; ok && m.input.mode == InputNormal && m.input.textarea.Value() == "" {
+			switch msg.String() {
+			case "left":
+				tv.MoveColumn(-1)
+				return m, nil
+			case "right":
+				tv.MoveColumn(1)
+				return m, nil
+			case "home":
+				return m, tv.JumpToStart()
+			case "end":
+				return m, tv.JumpToEnd()
+			case "enter":
+				if column, typ, row, value, ok := tv.SelectedCell(); ok {
+					detail := NewCellDetail(column, typ, row, value)
+					detail.SetSize(m.mainWidth(), m.contentHeight())
+					m.cellDetail = &detail
+				}
+				return m, nil
+			}
+		}
+
 		// While executing, allow scrolling in the active content area
 		if m.executing {
 			if m.activeView == "log" {
@@ -233,6 +287,9 @@ func (m REPLModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case TableDataMsg:
 		if msg.Err != nil {
+			if tv, ok := m.tableviews[msg.Name]; ok {
+				tv.FetchFailed(msg.Offset)
+			}
 			m.logview.AppendEntry(LogEntry{
 				Type: LogError,
 				Text: fmt.Sprintf("Failed to fetch table %s: %v", msg.Name, msg.Err),
@@ -260,10 +317,10 @@ func (m REPLModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			tv = &newTV
 		}
 
-		tv.SetData(resp)
 		if resp.IsRefreshing {
 			tv.isRefreshing = true
 		}
+		prefetchCmd := tv.SetData(resp)
 		m.tabbar.UpdateTableTab(msg.Name, resp.TotalRows, tv.isRefreshing)
 
 		if m.width > 0 && m.height > 0 {
@@ -285,13 +342,14 @@ func (m REPLModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			tv.SetSubscribed(true)
 			session := m.session
 			name := msg.Name
+			offset, _ := tv.Window()
 			return m, func() tea.Msg {
-				session.Subscribe(name, 0, 200)
+				session.Subscribe(name, offset, tablePageSize)
 				return nil
 			}
 		}
 
-		return m, nil
+		return m, prefetchCmd
 
 	case TableUpdateMsg:
 		if msg.Response == nil {
@@ -300,11 +358,50 @@ func (m REPLModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		resp := msg.Response
 		tv, exists := m.tableviews[msg.Name]
 		if exists {
-			tv.SetData(resp)
+			// Ignore updates for a page the view has already moved away from
+			if resp.Offset == tv.want {
+				tv.SetData(resp)
+			}
 			m.tabbar.UpdateTableTab(msg.Name, resp.TotalRows, true)
 		}
 		return m, m.listenForPush()
 
+	case TablePageRequestMsg:
+		cmds := []tea.Cmd{m.fetchTableData(msg.Name, msg.Offset)}
+		// A live table's subscription follows the page being viewed
+		if !msg.Prefetch && m.subscribedTable == msg.Name && m.session != nil {
+			session := m.session
+			name, offset := msg.Name, msg.Offset
+			cmds = append(cmds, func() tea.Msg {
+				session.Subscribe(name, offset, tablePageSize)
+				return nil
+			})
+		}
+		return m, tea.Batch(cmds...)
+
+	case SnapshotMsg:
+		if msg.Err != nil {
+			m.logview.AppendEntry(LogEntry{
+				Type: LogError,
+				Text: fmt.Sprintf("Failed to snapshot %s: %v", msg.Source, msg.Err),
+			})
+			return m, nil
+		}
+		m.logview.AppendEntry(LogEntry{
+			Type: LogInfo,
+			Text: fmt.Sprintf("Snapshot of %s saved as %s", msg.Source, msg.Name),
+		})
+		m.tabbar.AddTableTab(msg.Name, -1, false)
+		cmds := []tea.Cmd{m.fetchTableData(msg.Name, 0)}
+		if cmd := m.switchToView(msg.Name); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+		return m, tea.Batch(cmds...)
+
+	case CellDetailClosedMsg:
+		m.cellDetail = nil
+		return m, nil
+
 	case TabSelectedMsg:
 		cmd := m.switchToView(msg.Tab.Name)
 		return m, cmd
@@ -373,6 +470,8 @@ func (m REPLModel) listenForPush() tea.Cmd {
 }
 
 func (m *REPLModel) switchToView(name string) tea.Cmd {
+	m.cellDetail = nil
+
 	// Blur the old table view
 	if old, ok := m.tableviews[m.activeView]; ok {
 		old.Blur()
@@ -406,8 +505,9 @@ func (m *REPLModel) switchToView(name string) tea.Cmd {
 			m.subscribedTable = name
 			tv.SetSubscribed(true)
 			session := m.session
+			offset, _ := tv.Window()
 			cmds = append(cmds, func() tea.Msg {
-				session.Subscribe(name, 0, 200)
+				session.Subscribe(name, offset, tablePageSize)
 				return nil
 			})
 		}
@@ -419,11 +519,28 @@ func (m *REPLModel) switchToView(name string) tea.Cmd {
 	return nil
 }
 
+// snapshotTable freezes the rows currently loaded in tv into a new static
+// table on the server. The source table keeps ticking.
+func (m REPLModel) snapshotTable(name string, tv *TableViewModel) tea.Cmd {
+	session := m.session
+	offset, limit := tv.Window()
+	if limit == 0 {
+		limit = tablePageSize
+	}
+	return func() tea.Msg {
+		resp, err := session.Snapshot(name, offset, limit)
+		if err != nil {
+			return SnapshotMsg{Source: name, Err: err}
+		}
+		return SnapshotMsg{Source: name, Name: resp.Name}
+	}
+}
+
 func (m REPLModel) fetchTableData(name string, offset int) tea.Cmd {
 	session := m.session
 	return func() tea.Msg {
-		resp, err := session.FetchTable(name, offset, 200)
-		return TableDataMsg{Name: name, Response: resp, Err: err}
+		resp, err := session.FetchTable(name, offset, tablePageSize)
+		return TableDataMsg{Name: name, Offset: offset, Response: resp, Err: err}
 	}
 }
 
@@ -436,9 +553,28 @@ func (m *REPLModel) updateTabNames() {
 	m.input.SetTabNames(names)
 }
 
+// Layout breakpoints. The sidebar needs sidebarMinWidth columns; without it
+// the server info is stacked under the content as a status line. Below
+// narrowWidth the tab bar shows only the active tab, and below minWidth x
+// minHeight the REPL shows a resize message instead.
+const (
+	sidebarMinWidth = 60
+	narrowWidth     = 50
+	minWidth        = 30
+	minHeight       = 10
+)
+
+func (m REPLModel) showSidebar() bool {
+	return m.width >= sidebarMinWidth
+}
+
+func (m REPLModel) tooSmall() bool {
+	return m.width > 0 && (m.width < minWidth || m.height < minHeight)
+}
+
 // mainWidth returns the width available for the main content area.
 func (m REPLModel) mainWidth() int {
-	if m.width >= 60 {
+	if m.showSidebar() {
 		return m.width - m.sidebar.Width()
 	}
 	return m.width
@@ -447,8 +583,12 @@ func (m REPLModel) mainWidth() int {
 func (m REPLModel) contentHeight() int {
 	inputRenderedHeight := m.input.Height()
 	tabBarHeight := 1
+	statusHeight := 0
+	if !m.showSidebar() {
+		statusHeight = 1
+	}
 
-	h := m.height - inputRenderedHeight - tabBarHeight
+	h := m.height - inputRenderedHeight - tabBarHeight - statusHeight
 	if h < 1 {
 		h = 1
 	}
@@ -461,23 +601,32 @@ func (m *REPLModel) layout() {
 
 	m.input.SetWidth(mainWidth)
 	m.tabbar.SetWidth(mainWidth)
+	m.tabbar.SetCompact(mainWidth < narrowWidth)
 	m.logview.SetSize(mainWidth, contentHeight)
 	m.sidebar.SetHeight(m.height)
 
 	for _, tv := range m.tableviews {
 		tv.SetSize(mainWidth, contentHeight)
 	}
+	if m.cellDetail != nil {
+		m.cellDetail.SetSize(mainWidth, contentHeight)
+	}
 }
 
 // View renders the REPL layout with sidebar.
 func (m REPLModel) View() string {
+	if m.tooSmall() {
+		return components.TooSmall(m.width, m.height, minWidth, minHeight)
+	}
 	if m.err != nil && m.session == nil {
 		return fmt.Sprintf("\n  %s\n\n  Press Ctrl+C to exit.\n",
 			tui.StyleError.Render(fmt.Sprintf("Error: %v", m.err)))
 	}
 
 	var contentView string
-	if m.activeView == "log" {
+	if m.cellDetail != nil {
+		contentView = m.cellDetail.View()
+	} else if m.activeView == "log" {
 		contentView = m.logview.View()
 	} else if tv, ok := m.tableviews[m.activeView]; ok {
 		contentView = tv.View()
@@ -491,10 +640,11 @@ func (m REPLModel) View() string {
 		contentView,
 	}
 
-	mainArea := lipgloss.JoinVertical(lipgloss.Left, mainSections...)
-
-	if m.width >= 60 {
-		return lipgloss.JoinHorizontal(lipgloss.Top, mainArea, m.sidebar.View())
+	if !m.showSidebar() {
+		mainSections = append(mainSections, m.sidebar.StatusLine(m.width))
+		return lipgloss.JoinVertical(lipgloss.Left, mainSections...)
 	}
-	return mainArea
+
+	mainArea := lipgloss.JoinVertical(lipgloss.Left, mainSections...)
+	return lipgloss.JoinHorizontal(lipgloss.Top, mainArea, m.sidebar.View())
 }
@@ -0,0 +1,138 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dsmmcken/dh-cli/src/internal/tui"
+	"github.com/muesli/termenv"
+)
+
+// CellDetailClosedMsg is sent when the cell detail popup is dismissed.
+type CellDetailClosedMsg struct{}
+
+// CellDetailModel is a popup showing the full value of one table cell,
+// wrapped to the popup width and pretty-printed when it is JSON.
+type CellDetailModel struct {
+	column   string
+	typ      string
+	row      int
+	text     string
+	copied   string // status after a copy attempt
+	viewport viewport.Model
+	width    int
+	height   int
+}
+
+// NewCellDetail creates a popup for the value at row (0-based) of column.
+func NewCellDetail(column, typ string, row int, value any) CellDetailModel {
+	return CellDetailModel{
+		column: column,
+		typ:    typ,
+		row:    row,
+		text:   cellDetailText(value),
+	}
+}
+
+// cellDetailText returns the full display text for a cell. Nested values
+// and strings holding a JSON object or array are pretty-printed.
+func cellDetailText(val any) string {
+	switch v := val.(type) {
+	case map[string]any, []any:
+		if data, err := json.MarshalIndent(v, "", "  "); err == nil {
+			return string(data)
+		}
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var buf bytes.Buffer
+			if json.Indent(&buf, []byte(trimmed), "", "  ") == nil {
+				return buf.String()
+			}
+		}
+		return v
+	}
+	return formatCellValue(val)
+}
+
+// Text returns the full (unwrapped) cell text shown in the popup.
+func (m CellDetailModel) Text() string {
+	return m.text
+}
+
+// SetSize sets the popup's outer dimensions.
+func (m *CellDetailModel) SetSize(width, height int) {
+	m.width = width
+	m.height = height
+	// Border (2) + padding (2) horizontally; border, title, blank and footer lines vertically.
+	innerW := width - 4
+	if innerW < 10 {
+		innerW = 10
+	}
+	innerH := height - 5
+	if innerH < 1 {
+		innerH = 1
+	}
+	m.viewport = viewport.New(innerW, innerH)
+	m.viewport.SetContent(lipgloss.NewStyle().Width(innerW).Render(m.text))
+}
+
+// Update handles scrolling, copy and close keys.
+func (m CellDetailModel) Update(msg tea.Msg) (CellDetailModel, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "esc", "enter", "q":
+			return m, func() tea.Msg { return CellDetailClosedMsg{} }
+		case "c", "y":
+			m.copied = copyToClipboard(m.text)
+			return m, nil
+		}
+	}
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// copyToClipboard copies text to the system clipboard, falling back to an
+// OSC 52 escape sequence (which also works over SSH) when no clipboard
+// utility is available. Returns a status message for the footer.
+func copyToClipboard(text string) string {
+	if err := clipboard.WriteAll(text); err == nil {
+		return "copied"
+	}
+	termenv.Copy(text)
+	return "copied (OSC 52)"
+}
+
+// View renders the popup.
+func (m CellDetailModel) View() string {
+	title := tui.StyleTitle.Render(m.column)
+	if m.typ != "" {
+		title += tui.StyleDim.Render(fmt.Sprintf(" (%s)", m.typ))
+	}
+	title += tui.StyleDim.Render(fmt.Sprintf("  row %d", m.row+1))
+
+	footer := "esc close · c copy · ↑/↓ scroll"
+	if pct := m.viewport.ScrollPercent(); m.viewport.TotalLineCount() > m.viewport.Height {
+		footer += fmt.Sprintf(" · %d%%", int(pct*100))
+	}
+	if m.copied != "" {
+		footer = tui.StyleSuccess.Render(m.copied) + tui.StyleDim.Render(" · ") + tui.StyleDim.Render(footer)
+	} else {
+		footer = tui.StyleDim.Render(footer)
+	}
+
+	body := lipgloss.JoinVertical(lipgloss.Left, title, "", m.viewport.View(), footer)
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(tui.ColorPrimary).
+		Padding(0, 1).
+		Width(m.width - 2).
+		Render(body)
+}
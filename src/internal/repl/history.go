@@ -1,85 +1,65 @@
 package repl
 
 import (
-	"encoding/json"
 	"os"
-	"path/filepath"
 	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/history"
 )
 
-// History manages REPL command history with file persistence.
+// History provides REPL navigation and search over the shared history store.
 type History struct {
 	entries []string
 	cursor  int
 	draft   string
-	path    string
-	maxSize int
+	store   *history.Store
+	cwd     string
 }
 
-// NewHistory creates a history manager that loads from ~/.dh/repl_history.
+// NewHistory opens the history store configured under dhHome. Entries from
+// dh exec -c runs are included alongside REPL input. If the store cannot be
+// opened (e.g. a bad history.exclude pattern), history is kept in memory only.
 func NewHistory(dhHome string) *History {
-	path := filepath.Join(dhHome, "repl_history")
+	cwd, _ := os.Getwd()
 	h := &History{
 		entries: []string{},
 		cursor:  -1,
-		path:    path,
-		maxSize: 500,
+		cwd:     cwd,
+	}
+	if store, err := history.Open(dhHome, cwd); err == nil {
+		h.store = store
+		h.reload()
 	}
-	h.load()
 	return h
 }
 
-func (h *History) load() {
-	data, err := os.ReadFile(h.path)
-	if err != nil {
-		return
-	}
-	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+// reload rebuilds the navigable entries from the store.
+func (h *History) reload() {
+	h.entries = h.entries[:0]
+	for _, e := range h.store.Entries() {
+		if e.Code != "" {
+			h.entries = append(h.entries, e.Code)
 		}
-		var entry string
-		if err := json.Unmarshal([]byte(line), &entry); err != nil {
-			// Backwards compat: treat non-JSON lines as literal strings
-			entry = line
-		}
-		h.entries = append(h.entries, entry)
-	}
-	if len(h.entries) > h.maxSize {
-		h.entries = h.entries[len(h.entries)-h.maxSize:]
 	}
 }
 
-// Add appends a command to history, deduplicating consecutive entries.
+// Add records a command, subject to the store's dedup and exclusion rules.
 func (h *History) Add(cmd string) {
 	cmd = strings.TrimSpace(cmd)
 	if cmd == "" {
 		return
 	}
-	if len(h.entries) > 0 && h.entries[len(h.entries)-1] == cmd {
-		return
-	}
-	h.entries = append(h.entries, cmd)
-	if len(h.entries) > h.maxSize {
-		h.entries = h.entries[len(h.entries)-h.maxSize:]
-	}
 	h.cursor = -1
 	h.draft = ""
-	h.save()
-}
-
-func (h *History) save() {
-	dir := filepath.Dir(h.path)
-	os.MkdirAll(dir, 0o755)
-
-	var buf strings.Builder
-	for _, entry := range h.entries {
-		data, _ := json.Marshal(entry)
-		buf.Write(data)
-		buf.WriteByte('\n')
+	if h.store == nil {
+		if len(h.entries) == 0 || h.entries[len(h.entries)-1] != cmd {
+			h.entries = append(h.entries, cmd)
+		}
+		return
+	}
+	if added, err := h.store.Add(history.Entry{Source: history.SourceREPL, Code: cmd, Dir: h.cwd}); err == nil && added {
+		h.reload()
 	}
-	os.WriteFile(h.path, []byte(buf.String()), 0o644)
 }
 
 // Up moves to the previous (older) history entry.
@@ -36,6 +36,12 @@ func NewFetchTableCmd(name string, offset, limit int) Command {
 	return Command{Type: "fetch_table", ID: nextID(), Name: name, Offset: &offset, Limit: &limit}
 }
 
+// NewSnapshotCmd creates a snapshot command that freezes rows
+// [offset, offset+limit) of a table into a new static table.
+func NewSnapshotCmd(name string, offset, limit int) Command {
+	return Command{Type: "snapshot", ID: nextID(), Name: name, Offset: &offset, Limit: &limit}
+}
+
 // NewServerInfoCmd creates a server_info command.
 func NewServerInfoCmd() Command {
 	return Command{Type: "server_info", ID: nextID()}
@@ -79,7 +85,7 @@ type Response struct {
 	// "tables" fields
 	Tables []TableMeta `json:"tables,omitempty"`
 
-	// "table_data" / "table_update" fields
+	// "table_data" / "table_update" / "snapshot" fields
 	Name         string   `json:"name,omitempty"`
 	Columns      []string `json:"columns,omitempty"`
 	Types        []string `json:"types,omitempty"`
@@ -7,6 +7,7 @@ import base64
 import json
 import os
 import pickle
+import re
 import sys
 import textwrap
 import threading
@@ -127,6 +128,45 @@ def build_wrapper(code: str) -> str:
     return "\n".join(lines)
 
 
+# --- Groovy (from runner.py) ---
+
+GROOVY_CAPTURE_START = textwrap.dedent("""\
+    __dh_sw = new StringWriter()
+    out = new PrintWriter(__dh_sw, true)
+""")
+GROOVY_CAPTURE_END = textwrap.dedent("""\
+    out.flush()
+    __dh_result_table = emptyTable(1).update("data = `" + java.util.Base64.getEncoder().encodeToString(__dh_sw.toString().getBytes("UTF-8")) + "`")
+    binding.removeVariable("out")
+    binding.removeVariable("__dh_sw")
+""")
+
+_GROOVY_ASSIGNMENT = re.compile(r"^\s*(?:final\s+)?(?:[A-Z][\w.]*(?:<[^>]*>)?\s+)?([A-Za-z_$][\w$]*)\s*=(?!=)", re.MULTILINE)
+
+
+def get_groovy_assigned_names(code: str) -> set[str]:
+    """Return the names Groovy code assigns at the start of a line."""
+    return set(_GROOVY_ASSIGNMENT.findall(code))
+
+
+def run_groovy(session, code: str) -> dict:
+    """Run Groovy code, capturing what it prints."""
+    session.run_script(GROOVY_CAPTURE_START)
+    error = None
+    try:
+        session.run_script(code)
+    except Exception as e:
+        error = str(e)
+    try:
+        session.run_script(GROOVY_CAPTURE_END)
+        df = session.open_table("__dh_result_table").to_arrow().to_pandas()
+        stdout = base64.b64decode(df.iloc[0]["data"].encode("ascii")).decode("utf-8")
+        session.run_script('binding.removeVariable("__dh_result_table")')
+    except Exception as e:
+        return {"error": error or f"Failed to read results: {e}"}
+    return {"stdout": stdout, "stderr": "", "result_repr": None, "error": error}
+
+
 # --- Result reading (from runner.py) ---
 
 def read_result_table(session) -> dict:
@@ -173,11 +213,10 @@ def start_embedded(args):
     """Start an embedded DH server, return (session, port)."""
     from deephaven_server import Server
 
-    # Check port availability
-    port_to_use = args.port
-    if not _is_port_available(port_to_use):
-        print(f"Port {port_to_use} is in use, finding available port...", file=sys.stderr)
-        port_to_use = 0
+    # dh normally passes a free port; check again in case it was taken since
+    port_to_use = _free_port(args.port)
+    if port_to_use != args.port and args.port:
+        print(f"Port {args.port} is in use; using port {port_to_use}", file=sys.stderr)
 
     # Suppress JVM/server output
     original_stdout_fd = os.dup(1)
@@ -232,26 +271,34 @@ def connect_remote(args):
         with open(args.tls_client_key, "rb") as f:
             kwargs["client_private_key"] = f.read()
 
+    if args.language != "python":
+        kwargs["session_type"] = args.language
+
     session = Session(host=args.host, port=args.port, **kwargs)
     return session, args.port
 
 
-def _is_port_available(port: int) -> bool:
+def _free_port(port: int) -> int:
+    """Return port if it is free on all interfaces, else a free port picked by the OS."""
     import socket
-    if port == 0:
-        return True
     with socket.socket(socket.AF_INET, socket.SOCK_STREAM) as s:
-        try:
-            s.bind(('localhost', port))
-            return True
-        except OSError:
-            return False
+        if port:
+            try:
+                s.bind(('', port))
+                return port
+            except OSError:
+                pass
+        s.bind(('', 0))
+        return s.getsockname()[1]
 
 
 # --- Command handlers ---
 
-def handle_execute(session, cmd_id, code):
+def handle_execute(session, cmd_id, code, language="python"):
     start = time.monotonic()
+    if language == "groovy":
+        handle_execute_groovy(session, cmd_id, code, start)
+        return
     assigned_names = get_assigned_names(code)
 
     wrapper = build_wrapper(code)
@@ -294,6 +341,23 @@ def handle_execute(session, cmd_id, code):
     })
 
 
+def handle_execute_groovy(session, cmd_id, code, start):
+    assigned_names = get_groovy_assigned_names(code)
+    result = run_groovy(session, code)
+    server_tables = set(session.tables) - {"__dh_result_table"}
+    emit({
+        "type": "result",
+        "id": cmd_id,
+        "stdout": result.get("stdout", ""),
+        "stderr": result.get("stderr", ""),
+        "error": result.get("error"),
+        "result_repr": None,
+        "assigned_tables": [n for n in assigned_names if n in server_tables],
+        "all_tables": sorted(server_tables),
+        "elapsed_ms": int((time.monotonic() - start) * 1000),
+    })
+
+
 def handle_list_tables(session, cmd_id):
     tables = []
     for name in sorted(set(session.tables) - {"__dh_result_table"}):
@@ -437,6 +501,27 @@ def handle_unsubscribe(session, cmd_id, cmd):
     emit({"type": "unsubscribe_ack", "id": cmd_id, "name": name})
 
 
+def handle_snapshot(session, cmd_id, cmd):
+    """Freeze rows [offset, offset+limit) of a table into a new static table."""
+    name = cmd.get("name", "")
+    offset = cmd.get("offset", 0)
+    limit = cmd.get("limit", 200)
+
+    existing = set(session.tables)
+    base = f"{name}_snap_{time.strftime('%H%M%S')}"
+    new_name = base
+    n = 2
+    while new_name in existing:
+        new_name = f"{base}_{n}"
+        n += 1
+
+    try:
+        session.run_script(f"{new_name} = {name}.snapshot().slice({offset}, {offset + limit})")
+        emit({"type": "snapshot", "id": cmd_id, "name": new_name})
+    except Exception as e:
+        emit({"type": "error", "id": cmd_id, "message": f"Failed to snapshot table {name}: {e}"})
+
+
 def handle_server_info(session, cmd_id, args):
     tables = set(session.tables) - {"__dh_result_table"}
     emit({
@@ -467,7 +552,7 @@ def run_loop(session, args):
         cmd_id = cmd.get("id")
 
         if cmd_type == "execute":
-            handle_execute(session, cmd_id, cmd.get("code", ""))
+            handle_execute(session, cmd_id, cmd.get("code", ""), args.language)
         elif cmd_type == "list_tables":
             handle_list_tables(session, cmd_id)
         elif cmd_type == "fetch_table":
@@ -476,6 +561,8 @@ def run_loop(session, args):
             handle_subscribe(session, cmd_id, cmd)
         elif cmd_type == "unsubscribe":
             handle_unsubscribe(session, cmd_id, cmd)
+        elif cmd_type == "snapshot":
+            handle_snapshot(session, cmd_id, cmd)
         elif cmd_type == "server_info":
             handle_server_info(session, cmd_id, args)
         elif cmd_type == "shutdown":
@@ -504,6 +591,7 @@ def main():
     parser.add_argument("--tls-ca-cert", default=None)
     parser.add_argument("--tls-client-cert", default=None)
     parser.add_argument("--tls-client-key", default=None)
+    parser.add_argument("--language", choices=["python", "groovy"], default="python")
 
     args = parser.parse_args()
 
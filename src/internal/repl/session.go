@@ -8,8 +8,11 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
 )
 
 //go:embed repl_runner.py
@@ -21,6 +24,11 @@ type SessionConfig struct {
 	JVMArgs string
 	Version string
 	Host    string
+	Name    string // registers the embedded server under this name (--name)
+
+	// Language of the console: "python" (default) or "groovy", which
+	// only remote servers offer
+	Language string
 
 	// Remote auth
 	AuthType      string
@@ -47,6 +55,8 @@ type Session struct {
 	readDone chan struct{}
 	ready    *Response
 	pushCh   chan *Response // channel for unsolicited server-push messages (table_update)
+
+	unregister func() // removes the embedded server's discovery record
 }
 
 // NewSession starts the Python REPL subprocess and waits for the ready message.
@@ -86,6 +96,10 @@ func NewSession(cfg SessionConfig) (*Session, error) {
 		}
 	}
 
+	if cfg.Language != "" && cfg.Language != "python" {
+		runnerArgs = append(runnerArgs, "--language", cfg.Language)
+	}
+
 	// Build command: python -c "<runner script>" <args...>
 	cmdArgs := append([]string{"-c", replRunnerScript}, runnerArgs...)
 	cmd := exec.Command(cfg.PythonBin, cmdArgs...)
@@ -140,6 +154,26 @@ func NewSession(cfg SessionConfig) (*Session, error) {
 		return nil, fmt.Errorf("timed out waiting for python process to start (60s)")
 	}
 
+	s.unregister = func() {}
+	if cfg.Host == "" {
+		port := s.ready.Port
+		if port == 0 {
+			port = cfg.Port
+		}
+		reg := discovery.Registration{Server: discovery.Server{
+			Port:    port,
+			PID:     cmd.Process.Pid,
+			Source:  "dh repl",
+			Name:    cfg.Name,
+			Version: cfg.Version,
+		}}
+		reg.CWD, _ = os.Getwd()
+		reg.AuthType, reg.AuthToken = discovery.AuthFromJVMArgs(strings.Fields(cfg.JVMArgs))
+		if unregister, err := discovery.Register(cfg.DHHome, reg); err == nil {
+			s.unregister = unregister
+		}
+	}
+
 	return s, nil
 }
 
@@ -247,6 +281,12 @@ func (s *Session) FetchTable(name string, offset, limit int) (*Response, error)
 	return s.sendAndWait(NewFetchTableCmd(name, offset, limit))
 }
 
+// Snapshot freezes rows [offset, offset+limit) of a table into a new static
+// table on the server. The response Name is the new table's name.
+func (s *Session) Snapshot(name string, offset, limit int) (*Response, error) {
+	return s.sendAndWait(NewSnapshotCmd(name, offset, limit))
+}
+
 // ServerInfo returns server connection details.
 func (s *Session) ServerInfo() (*Response, error) {
 	return s.sendAndWait(NewServerInfoCmd())
@@ -279,6 +319,8 @@ func (s *Session) Ready() *Response {
 
 // Close gracefully shuts down the Python subprocess.
 func (s *Session) Close() {
+	defer s.unregister()
+
 	// Try graceful shutdown
 	shutdownCmd := NewShutdownCmd()
 	data, _ := json.Marshal(shutdownCmd)
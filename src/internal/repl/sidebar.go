@@ -29,11 +29,12 @@ type replKeyMap struct {
 	SearchTabs key.Binding
 	NextTab   key.Binding
 	PrevTab   key.Binding
+	Snapshot  key.Binding
 	Quit      key.Binding
 }
 
 func (k replKeyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Submit, k.Newline, k.History, k.SearchHist, k.SearchTabs, k.NextTab, k.PrevTab, k.Quit}
+	return []key.Binding{k.Submit, k.Newline, k.History, k.SearchHist, k.SearchTabs, k.NextTab, k.PrevTab, k.Snapshot, k.Quit}
 }
 
 func (k replKeyMap) FullHelp() [][]key.Binding {
@@ -48,6 +49,7 @@ var defaultREPLKeyMap = replKeyMap{
 	SearchTabs: key.NewBinding(key.WithKeys("ctrl+t"), key.WithHelp("ctrl+t", "search tabs")),
 	NextTab:    key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "next tab")),
 	PrevTab:    key.NewBinding(key.WithKeys("shift+tab"), key.WithHelp("shift+tab", "prev tab")),
+	Snapshot:   key.NewBinding(key.WithKeys("ctrl+s"), key.WithHelp("ctrl+s", "snapshot live table")),
 	Quit:       key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "quit")),
 }
 
@@ -150,3 +152,15 @@ func (m SidebarModel) renderHelp() string {
 
 	return strings.Join(lines, "\n")
 }
+
+// StatusLine renders the server info as a single line of at most width
+// columns, shown under the main area when there is no room for the sidebar.
+func (m SidebarModel) StatusLine(width int) string {
+	style := lipgloss.NewStyle().Foreground(tui.ColorDim).MaxWidth(width)
+	if m.serverInfo == nil {
+		return style.Render(" Connecting...")
+	}
+	info := m.serverInfo
+	return style.Render(fmt.Sprintf(" %s:%d · %s · %s · %d tables",
+		info.Host, info.Port, info.Version, info.Mode, info.TableCount))
+}
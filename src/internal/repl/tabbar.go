@@ -34,6 +34,7 @@ type TabBarModel struct {
 	tabs      []TabInfo
 	activeIdx int
 	width     int
+	compact   bool
 }
 
 // NewTabBar creates a tab bar with the permanent "log" tab.
@@ -51,6 +52,12 @@ func (m *TabBarModel) SetWidth(w int) {
 	m.width = w
 }
 
+// SetCompact switches to the narrow layout, which shows only the active tab
+// and its position instead of the full row of tabs.
+func (m *TabBarModel) SetCompact(compact bool) {
+	m.compact = compact
+}
+
 // ActiveTab returns the currently selected tab.
 func (m TabBarModel) ActiveTab() TabInfo {
 	if m.activeIdx < len(m.tabs) {
@@ -132,6 +139,10 @@ func (m TabBarModel) View() string {
 		return ""
 	}
 
+	if m.compact {
+		return m.compactView()
+	}
+
 	var tabs []string
 	usedWidth := 0
 
@@ -180,3 +191,25 @@ func (m TabBarModel) View() string {
 
 	return lipgloss.JoinHorizontal(lipgloss.Top, tabs...)
 }
+
+// compactView renders the active tab name followed by its position, e.g.
+// "trades 2/5", truncating the name to fit. Row counts are dropped.
+func (m TabBarModel) compactView() string {
+	t := m.ActiveTab()
+	pos := fmt.Sprintf(" %d/%d", m.activeIdx+1, len(m.tabs))
+	if t.Type == TabTable && t.IsRefreshing {
+		pos = " LIVE" + pos
+	}
+	name := t.Name
+	if avail := m.width - lipgloss.Width(pos) - 2; lipgloss.Width(name) > avail {
+		r := []rune(name)
+		name = string(r[:max(avail-1, 0)]) + "…"
+	}
+	active := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#FFFFFF")).
+		Background(tui.ColorPrimary).
+		Padding(0, 1).
+		Render(name)
+	return active + lipgloss.NewStyle().Foreground(tui.ColorDim).Render(pos)
+}
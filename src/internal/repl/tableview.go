@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/table"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -13,10 +14,27 @@ import (
 // TableDataMsg is sent when table data has been fetched from the server.
 type TableDataMsg struct {
 	Name     string
+	Offset   int
 	Response *Response
 	Err      error
 }
 
+// TablePageRequestMsg asks the REPL to fetch a page of a table. Prefetch
+// requests are background loads of pages adjacent to the one shown.
+type TablePageRequestMsg struct {
+	Name     string
+	Offset   int
+	Prefetch bool
+}
+
+const (
+	tablePageSize  = 200
+	maxCachedPages = 8
+
+	cursorKeep = -1 // keep the current cursor row when a page is shown
+	cursorLast = -2 // put the cursor on the page's last row
+)
+
 // TableViewModel displays paginated column data from a Deephaven table.
 type TableViewModel struct {
 	name         string
@@ -26,8 +44,15 @@ type TableViewModel struct {
 	isSubscribed bool
 	columns      []string
 	types        []string
+	rawRows      [][]any // unformatted values, for the cell detail popup
+	selCol       int     // selected column for the cell detail popup
 	dataOffset   int
 	dataLimit    int
+	pages        map[int][][]any // cached pages keyed by row offset
+	pending      map[int]bool    // page offsets being fetched
+	want         int             // offset of the page the view is waiting to show
+	wantCursor   int             // cursor placement once that page arrives
+	spinner      spinner.Model
 	loading      bool
 	width        int
 	height       int
@@ -72,7 +97,11 @@ func NewTableView(name string, meta TableMeta) TableViewModel {
 		columns:      colNames,
 		types:        colTypes,
 		dataOffset:   0,
-		dataLimit:    200,
+		dataLimit:    tablePageSize,
+		pages:        map[int][][]any{},
+		pending:      map[int]bool{},
+		wantCursor:   cursorKeep,
+		spinner:      spinner.New(spinner.WithSpinner(spinner.MiniDot), spinner.WithStyle(tui.StyleDim)),
 		loading:      false,
 	}
 }
@@ -123,7 +152,7 @@ func (m *TableViewModel) SetSize(width, height int) {
 		}
 		colMeta[i] = ColumnMeta{Name: name, Type: typ}
 	}
-	m.table.SetColumns(calculateColumns(colMeta, width))
+	m.table.SetColumns(m.markSelected(calculateColumns(colMeta, width)))
 
 	tableHeight := height - 1
 	if tableHeight < 3 {
@@ -132,22 +161,12 @@ func (m *TableViewModel) SetSize(width, height int) {
 	m.table.SetHeight(tableHeight)
 }
 
-// SetData populates the table with fetched data from a Response.
-func (m *TableViewModel) SetData(resp *Response) {
+// SetData stores a fetched page in the cache and shows it if it is the page
+// the view is waiting for. Refreshing tables only keep the latest page since
+// older pages go stale. Returns commands to prefetch adjacent pages.
+func (m *TableViewModel) SetData(resp *Response) tea.Cmd {
 	if resp == nil {
-		return
-	}
-
-	// Remember current cursor position for live updates
-	prevCursor := m.table.Cursor()
-
-	rows := make([]table.Row, len(resp.Rows))
-	for i, row := range resp.Rows {
-		strRow := make(table.Row, len(row))
-		for j, val := range row {
-			strRow[j] = formatCellValue(val)
-		}
-		rows[i] = strRow
+		return nil
 	}
 
 	if len(resp.Columns) > 0 {
@@ -161,21 +180,201 @@ func (m *TableViewModel) SetData(resp *Response) {
 			}
 			colMeta[i] = ColumnMeta{Name: name, Type: typ}
 		}
-		m.table.SetColumns(calculateColumns(colMeta, m.width))
+		if m.selCol >= len(resp.Columns) {
+			m.selCol = len(resp.Columns) - 1
+		}
+		m.table.SetColumns(m.markSelected(calculateColumns(colMeta, m.width)))
 	}
 
-	m.table.SetRows(rows)
 	m.totalRows = resp.TotalRows
-	m.dataOffset = resp.Offset
+	delete(m.pending, resp.Offset)
+	if m.isRefreshing || resp.IsRefreshing {
+		m.pages = map[int][][]any{}
+	}
+	m.pages[resp.Offset] = resp.Rows
+	m.evictPages()
+
+	if resp.Offset != m.want {
+		return nil
+	}
+	m.showPage(resp.Offset)
+	return m.prefetch()
+}
+
+// FetchFailed clears the pending state for a page whose fetch failed.
+func (m *TableViewModel) FetchFailed(offset int) {
+	delete(m.pending, offset)
+	if offset == m.want {
+		m.loading = false
+		m.want = m.dataOffset
+	}
+}
+
+// showPage displays the cached page at offset, placing the cursor as
+// requested by the navigation that asked for it.
+func (m *TableViewModel) showPage(offset int) {
+	page := m.pages[offset]
+	rows := make([]table.Row, len(page))
+	for i, row := range page {
+		strRow := make(table.Row, len(row))
+		for j, val := range row {
+			strRow[j] = formatCellValue(val)
+		}
+		rows[i] = strRow
+	}
+
+	// Live updates and refetches of the same page keep the cursor where it was
+	cursor := m.table.Cursor()
+	switch {
+	case m.wantCursor == cursorLast:
+		cursor = len(rows) - 1
+	case m.wantCursor >= 0:
+		cursor = m.wantCursor
+	}
+	m.wantCursor = cursorKeep
+
+	m.table.SetRows(rows)
+	m.rawRows = page
+	m.dataOffset = offset
 	m.loading = false
 
-	// Restore cursor position, clamped to new row count
-	if prevCursor > 0 && len(rows) > 0 {
-		if prevCursor >= len(rows) {
-			prevCursor = len(rows) - 1
+	if cursor >= len(rows) {
+		cursor = len(rows) - 1
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+	m.table.SetCursor(cursor)
+}
+
+// goToPage navigates to the page at offset, showing it immediately when
+// cached and otherwise requesting it and showing a loading indicator.
+func (m *TableViewModel) goToPage(offset, cursor int) tea.Cmd {
+	if offset > m.lastPageOffset() {
+		offset = m.lastPageOffset()
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	m.want, m.wantCursor = offset, cursor
+	if _, ok := m.pages[offset]; ok && !m.isRefreshing {
+		m.showPage(offset)
+		return m.prefetch()
+	}
+	m.loading = true
+	return tea.Batch(m.requestPage(offset, false), m.spinner.Tick)
+}
+
+// JumpToStart shows the first row of the table.
+func (m *TableViewModel) JumpToStart() tea.Cmd {
+	return m.goToPage(0, 0)
+}
+
+// JumpToEnd shows the last row of the table.
+func (m *TableViewModel) JumpToEnd() tea.Cmd {
+	return m.goToPage(m.lastPageOffset(), cursorLast)
+}
+
+func (m TableViewModel) lastPageOffset() int {
+	if m.totalRows <= 0 {
+		return 0
+	}
+	return (m.totalRows - 1) / tablePageSize * tablePageSize
+}
+
+// prefetch requests the pages either side of the current one in the
+// background. Refreshing tables are not prefetched since pages go stale.
+func (m *TableViewModel) prefetch() tea.Cmd {
+	if m.isRefreshing {
+		return nil
+	}
+	var cmds []tea.Cmd
+	for _, offset := range []int{m.dataOffset + tablePageSize, m.dataOffset - tablePageSize} {
+		if offset < 0 || offset >= m.totalRows {
+			continue
+		}
+		if _, ok := m.pages[offset]; ok {
+			continue
 		}
-		m.table.SetCursor(prevCursor)
+		cmds = append(cmds, m.requestPage(offset, true))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *TableViewModel) requestPage(offset int, prefetch bool) tea.Cmd {
+	if m.pending[offset] {
+		return nil
+	}
+	m.pending[offset] = true
+	name := m.name
+	return func() tea.Msg {
+		return TablePageRequestMsg{Name: name, Offset: offset, Prefetch: prefetch}
+	}
+}
+
+// evictPages drops the cached pages farthest from the wanted page once the
+// cache holds more than maxCachedPages.
+func (m *TableViewModel) evictPages() {
+	for len(m.pages) > maxCachedPages {
+		farthest, dist := -1, -1
+		for offset := range m.pages {
+			d := offset - m.want
+			if d < 0 {
+				d = -d
+			}
+			if d > dist {
+				farthest, dist = offset, d
+			}
+		}
+		delete(m.pages, farthest)
+	}
+}
+
+// markSelected prefixes the selected column's title with a marker.
+func (m TableViewModel) markSelected(cols []table.Column) []table.Column {
+	if m.selCol >= 0 && m.selCol < len(cols) {
+		cols[m.selCol].Title = "›" + cols[m.selCol].Title
 	}
+	return cols
+}
+
+// MoveColumn moves the selected column by delta, clamped to the table.
+func (m *TableViewModel) MoveColumn(delta int) {
+	if len(m.columns) == 0 {
+		return
+	}
+	m.selCol += delta
+	if m.selCol < 0 {
+		m.selCol = 0
+	}
+	if m.selCol >= len(m.columns) {
+		m.selCol = len(m.columns) - 1
+	}
+	if m.ready {
+		m.SetSize(m.width, m.height)
+	}
+}
+
+// Window returns the offset and row count of the rows currently loaded in
+// the view.
+func (m TableViewModel) Window() (offset, limit int) {
+	return m.dataOffset, len(m.rawRows)
+}
+
+// SelectedCell returns the column name, type, row index and raw value of
+// the cell under the row cursor and selected column.
+func (m TableViewModel) SelectedCell() (column, typ string, row int, value any, ok bool) {
+	row = m.table.Cursor()
+	if row < 0 || row >= len(m.rawRows) || m.selCol < 0 || m.selCol >= len(m.rawRows[row]) {
+		return "", "", 0, nil, false
+	}
+	if m.selCol < len(m.columns) {
+		column = m.columns[m.selCol]
+	}
+	if m.selCol < len(m.types) {
+		typ = m.types[m.selCol]
+	}
+	return column, typ, m.dataOffset + row, m.rawRows[row][m.selCol], true
 }
 
 func formatCellValue(val any) string {
@@ -206,6 +405,30 @@ func (m TableViewModel) Update(msg tea.Msg) (TableViewModel, tea.Cmd) {
 		return m, nil
 	}
 
+	switch msg := msg.(type) {
+	case spinner.TickMsg:
+		if !m.loading {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+	case tea.KeyMsg:
+		// Moving past either end of the loaded page turns to the next one
+		cursor := m.table.Cursor()
+		last := len(m.rawRows) - 1
+		switch msg.String() {
+		case "down", "j", "pgdown":
+			if cursor == last && m.dataOffset+len(m.rawRows) < m.totalRows {
+				return m, m.goToPage(m.dataOffset+tablePageSize, 0)
+			}
+		case "up", "k", "pgup":
+			if cursor == 0 && m.dataOffset > 0 {
+				return m, m.goToPage(m.dataOffset-tablePageSize, cursorLast)
+			}
+		}
+	}
+
 	var cmd tea.Cmd
 	m.table, cmd = m.table.Update(msg)
 	return m, cmd
@@ -229,6 +452,8 @@ func (m TableViewModel) statusBar() string {
 	rowInfo := fmt.Sprintf("%d rows", m.totalRows)
 	if m.totalRows == 0 {
 		rowInfo = "empty"
+	} else if m.totalRows > len(m.rawRows) && len(m.rawRows) > 0 {
+		rowInfo = fmt.Sprintf("rows %d–%d of %d", m.dataOffset+1, m.dataOffset+len(m.rawRows), m.totalRows)
 	}
 
 	var refreshInfo string
@@ -244,10 +469,20 @@ func (m TableViewModel) statusBar() string {
 	}
 
 	if m.loading {
-		return tui.StyleDim.Render(fmt.Sprintf("  %s | %s | loading...", m.name, rowInfo))
+		end := m.want + tablePageSize
+		if end > m.totalRows {
+			end = m.totalRows
+		}
+		return tui.StyleDim.Render(fmt.Sprintf("  %s | %s | ", m.name, rowInfo)) + m.spinner.View() +
+			tui.StyleDim.Render(fmt.Sprintf(" loading rows %d–%d of %d...", m.want+1, end, m.totalRows))
+	}
+
+	var colInfo string
+	if m.selCol >= 0 && m.selCol < len(m.columns) {
+		colInfo = fmt.Sprintf(" | col %s (←/→, enter: inspect)", m.columns[m.selCol])
 	}
 
-	return tui.StyleDim.Render(fmt.Sprintf("  %s | %s | ", m.name, rowInfo)) + refreshInfo
+	return tui.StyleDim.Render(fmt.Sprintf("  %s | %s | ", m.name, rowInfo)) + refreshInfo + tui.StyleDim.Render(colInfo)
 }
 
 // Focus enables keyboard navigation in the table.
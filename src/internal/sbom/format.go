@@ -0,0 +1,213 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formats lists the supported output formats.
+var Formats = []string{"cyclonedx", "spdx"}
+
+// Encode renders inv as an indented JSON document in format.
+func Encode(inv *Inventory, format string) ([]byte, error) {
+	var doc any
+	switch format {
+	case "cyclonedx":
+		doc = cycloneDX(inv)
+	case "spdx":
+		doc = spdx(inv)
+	default:
+		return nil, fmt.Errorf("unknown SBOM format %q (use cyclonedx or spdx)", format)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp  string                    `json:"timestamp"`
+	Tools      map[string][]cdxComponent `json:"tools"`
+	Component  cdxComponent              `json:"component"`
+	Properties []cdxProperty             `json:"properties,omitempty"`
+}
+
+type cdxComponent struct {
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Type       string        `json:"type"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Licenses   []cdxLicense  `json:"licenses,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func cycloneDX(inv *Inventory) cdxDocument {
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + inv.Serial,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: inv.Created.Format(time.RFC3339),
+			Tools: map[string][]cdxComponent{
+				"components": {{Type: "application", Name: "dh", Version: inv.Tool}},
+			},
+			Component: cdxComponent{
+				BOMRef:  "deephaven@" + inv.Version,
+				Type:    "application",
+				Name:    "deephaven",
+				Version: inv.Version,
+			},
+		},
+		Components: []cdxComponent{},
+	}
+	for _, n := range inv.Notes {
+		doc.Metadata.Properties = append(doc.Metadata.Properties, cdxProperty{Name: "dh:note", Value: n})
+	}
+	seen := map[string]bool{}
+	for _, c := range inv.Components {
+		ref := c.PURL
+		if ref == "" {
+			ref = c.Type + "/" + c.Name + "@" + c.Version
+		}
+		// bom-ref must be unique; the same package can be in both the venv
+		// and the VM rootfs.
+		if seen[ref] {
+			ref += "#" + c.Source
+		}
+		seen[ref] = true
+		cc := cdxComponent{
+			BOMRef:     ref,
+			Type:       c.Type,
+			Name:       c.Name,
+			Version:    c.Version,
+			PURL:       c.PURL,
+			Properties: []cdxProperty{{Name: "dh:source", Value: c.Source}},
+		}
+		if c.License != "" {
+			cc.Licenses = []cdxLicense{{Expression: c.License}}
+		}
+		doc.Components = append(doc.Components, cc)
+	}
+	return doc
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+	Comment  string   `json:"comment,omitempty"`
+}
+
+type spdxPackage struct {
+	Name             string       `json:"name"`
+	SPDXID           string       `json:"SPDXID"`
+	VersionInfo      string       `json:"versionInfo,omitempty"`
+	DownloadLocation string       `json:"downloadLocation"`
+	FilesAnalyzed    bool         `json:"filesAnalyzed"`
+	LicenseConcluded string       `json:"licenseConcluded"`
+	LicenseDeclared  string       `json:"licenseDeclared"`
+	Comment          string       `json:"comment,omitempty"`
+	ExternalRefs     []spdxExtRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExtRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func spdx(inv *Inventory) spdxDocument {
+	const rootID = "SPDXRef-Package-deephaven"
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              "deephaven-" + inv.Version,
+		DocumentNamespace: fmt.Sprintf("https://github.com/dsmmcken/dh-cli/sbom/deephaven-%s-%s", inv.Version, inv.Serial),
+		CreationInfo: spdxCreationInfo{
+			Created:  inv.Created.Format(time.RFC3339),
+			Creators: []string{"Tool: dh-" + inv.Tool},
+		},
+		Packages: []spdxPackage{{
+			Name:             "deephaven",
+			SPDXID:           rootID,
+			VersionInfo:      inv.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+		}},
+		Relationships: []spdxRelationship{{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: rootID,
+		}},
+	}
+	if len(inv.Notes) > 0 {
+		doc.CreationInfo.Comment = "Incomplete: " + strings.Join(inv.Notes, "; ")
+	}
+	for i, c := range inv.Components {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		license := c.License
+		if license == "" {
+			license = "NOASSERTION"
+		}
+		p := spdxPackage{
+			Name:             c.Name,
+			SPDXID:           id,
+			VersionInfo:      c.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  license,
+			Comment:          "source: " + c.Source,
+		}
+		if c.PURL != "" {
+			p.ExternalRefs = []spdxExtRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  c.PURL,
+			}}
+		}
+		doc.Packages = append(doc.Packages, p)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      rootID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return doc
+}
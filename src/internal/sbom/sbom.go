@@ -0,0 +1,189 @@
+// Package sbom builds software bills of materials for installed Deephaven
+// versions: the packages in the version's venv, the Java runtime and, when
+// a VM rootfs has been built, the OS and Python packages inside it.
+package sbom
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// Component sources, recorded on each component so scanners can tell the
+// venv apart from the VM image.
+const (
+	SourceVenv   = "venv"
+	SourceJava   = "java"
+	SourceRootfs = "vm-rootfs"
+)
+
+// Component is one piece of software in the inventory.
+type Component struct {
+	Type    string // CycloneDX component type: library, platform, operating-system
+	Name    string
+	Version string
+	PURL    string
+	License string // SPDX expression, or "" when unknown
+	Source  string
+}
+
+// Inventory is everything installed for one Deephaven version.
+type Inventory struct {
+	Version    string
+	Tool       string // dh CLI version that produced the inventory
+	Created    time.Time
+	Serial     string // random UUID identifying this document
+	Components []Component
+	Notes      []string // parts that could not be inventoried
+}
+
+// JavaDetector finds the Java runtime. Exported for test overrides.
+var JavaDetector = java.Detect
+
+// Collect inventories the venv of an installed version, the Java runtime,
+// and the VM rootfs for version if one was built with a package manifest.
+func Collect(dhHome, version, tool string) (*Inventory, error) {
+	venv := filepath.Join(dhHome, "versions", version, ".venv")
+	if _, err := os.Stat(venv); err != nil {
+		return nil, fmt.Errorf("version %s is not installed", version)
+	}
+
+	inv := &Inventory{
+		Version: version,
+		Tool:    tool,
+		Created: time.Now().UTC(),
+		Serial:  newUUID(),
+	}
+
+	pkgs, err := VenvPackages(venv)
+	if err != nil {
+		return nil, err
+	}
+	inv.Components = append(inv.Components, pkgs...)
+
+	if info, err := JavaDetector(dhHome); err == nil && info.Found {
+		inv.Components = append(inv.Components, Component{
+			Type:    "platform",
+			Name:    "java",
+			Version: info.Version,
+			PURL:    "pkg:generic/java@" + info.Version,
+			Source:  SourceJava,
+		})
+	} else {
+		inv.Notes = append(inv.Notes, "no Java runtime found")
+	}
+
+	m, err := vm.ReadRootfsManifest(vm.NewVMPaths(dhHome), version)
+	switch {
+	case err == nil:
+		inv.Components = append(inv.Components, rootfsComponents(m)...)
+	case !os.IsNotExist(err):
+		inv.Notes = append(inv.Notes, fmt.Sprintf("VM rootfs: %v", err))
+	}
+	return inv, nil
+}
+
+// VenvPackages lists the Python distributions installed in a venv, from
+// the METADATA of each *.dist-info directory in site-packages.
+func VenvPackages(venv string) ([]Component, error) {
+	dirs, _ := filepath.Glob(filepath.Join(venv, "lib", "python*", "site-packages", "*.dist-info"))
+	winDirs, _ := filepath.Glob(filepath.Join(venv, "Lib", "site-packages", "*.dist-info"))
+	dirs = append(dirs, winDirs...)
+
+	var comps []Component
+	for _, dir := range dirs {
+		meta, err := readMetadata(filepath.Join(dir, "METADATA"))
+		if err != nil || meta["Name"] == "" || meta["Version"] == "" {
+			continue
+		}
+		comps = append(comps, Component{
+			Type:    "library",
+			Name:    meta["Name"],
+			Version: meta["Version"],
+			PURL:    pypiPURL(meta["Name"], meta["Version"]),
+			License: meta["License-Expression"],
+			Source:  SourceVenv,
+		})
+	}
+	sort.Slice(comps, func(i, j int) bool {
+		return strings.ToLower(comps[i].Name) < strings.ToLower(comps[j].Name)
+	})
+	return comps, nil
+}
+
+// readMetadata reads the header fields of a core metadata file, stopping
+// at the blank line before the description.
+func readMetadata(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fields := map[string]string{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		if _, seen := fields[k]; !seen {
+			fields[k] = strings.TrimSpace(v)
+		}
+	}
+	return fields, sc.Err()
+}
+
+func rootfsComponents(m *vm.RootfsManifest) []Component {
+	var comps []Component
+	distro, distroVersion, _ := strings.Cut(m.OS, " ")
+	if distro != "" {
+		comps = append(comps, Component{
+			Type:    "operating-system",
+			Name:    distro,
+			Version: distroVersion,
+			Source:  SourceRootfs,
+		})
+	}
+	for _, p := range m.Packages {
+		c := Component{Type: "library", Name: p.Name, Version: p.Version, Source: SourceRootfs}
+		switch p.Type {
+		case "deb":
+			c.PURL = fmt.Sprintf("pkg:deb/%s/%s@%s", distro, p.Name, p.Version)
+		case "pypi":
+			c.PURL = pypiPURL(p.Name, p.Version)
+		}
+		comps = append(comps, c)
+	}
+	return comps
+}
+
+var pypiNameSep = regexp.MustCompile(`[-_.]+`)
+
+// pypiPURL returns the package URL for a PyPI distribution, with the name
+// normalized as PEP 503 requires.
+func pypiPURL(name, version string) string {
+	return fmt.Sprintf("pkg:pypi/%s@%s", pypiNameSep.ReplaceAllString(strings.ToLower(name), "-"), version)
+}
+
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
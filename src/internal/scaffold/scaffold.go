@@ -0,0 +1,133 @@
+// Package scaffold creates starter Deephaven projects for dh init.
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Options choose what Init creates.
+type Options struct {
+	Version string // pinned in .dhrc; empty for no pin
+	VSCode  bool   // add .vscode/tasks.json
+	Force   bool   // overwrite files that exist
+}
+
+// File statuses reported by Init.
+const (
+	Created     = "created"
+	Overwritten = "overwritten"
+	Skipped     = "skipped" // exists, and Force is off
+)
+
+// File is a file Init wrote or skipped. Path is relative to the project.
+type File struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// Init creates a starter project in dir, creating dir if needed. Files
+// that already exist are kept unless opts.Force is set.
+func Init(dir string, opts Options) ([]File, error) {
+	files := []struct{ path, content string }{
+		{".dh/config.toml", projectConfig},
+		{"example.py", exampleScript},
+		{".gitignore", gitignore},
+	}
+	if opts.Version != "" {
+		files = append(files, struct{ path, content string }{".dhrc", opts.Version + "\n"})
+	}
+	if opts.VSCode {
+		files = append(files, struct{ path, content string }{".vscode/tasks.json", vscodeTasks})
+	}
+
+	var out []File
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.path))
+		status := Created
+		if _, err := os.Stat(path); err == nil {
+			if !opts.Force {
+				out = append(out, File{Path: f.path, Status: Skipped})
+				continue
+			}
+			status = Overwritten
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return out, fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(f.content), 0o644); err != nil {
+			return out, fmt.Errorf("writing %s: %w", path, err)
+		}
+		out = append(out, File{Path: f.path, Status: status})
+	}
+	return out, nil
+}
+
+const projectConfig = `# Project settings for dh. They apply in this directory and below, over
+# ~/.dh/config.toml; any key of that file can be set here.
+
+[exec]
+# Scripts can import modules from the project root.
+pythonpath = ["."]
+`
+
+const exampleScript = `# An example Deephaven script. Run it with:
+#   dh exec example.py
+
+from deephaven import empty_table
+
+squares = empty_table(10).update(["X = i", "Square = X * X"])
+evens = squares.where("X % 2 == 0")
+`
+
+const gitignore = `__pycache__/
+*.py[cod]
+.venv/
+.ipynb_checkpoints/
+`
+
+// vscodeTasks runs dh on the open file. Lint problems show in the
+// Problems panel through the matcher, which reads dh lint's
+// file:line:column output.
+const vscodeTasks = `{
+  "version": "2.0.0",
+  "tasks": [
+    {
+      "label": "dh: run file",
+      "type": "shell",
+      "command": "dh",
+      "args": ["exec", "${file}"],
+      "group": { "kind": "build", "isDefault": true },
+      "problemMatcher": []
+    },
+    {
+      "label": "dh: check file against golden",
+      "detail": "Record the expected output first with: dh exec FILE --record golden",
+      "type": "shell",
+      "command": "dh",
+      "args": ["exec", "${file}", "--replay", "golden"],
+      "group": { "kind": "test", "isDefault": true },
+      "problemMatcher": []
+    },
+    {
+      "label": "dh: lint",
+      "type": "shell",
+      "command": "dh",
+      "args": ["lint", "${workspaceFolder}"],
+      "problemMatcher": {
+        "owner": "dh",
+        "fileLocation": "absolute",
+        "pattern": {
+          "regexp": "^(.*):(\\d+):(\\d+): (error|warning): (.*)$",
+          "file": 1,
+          "line": 2,
+          "column": 3,
+          "severity": 4,
+          "message": 5
+        }
+      }
+    }
+  ]
+}
+`
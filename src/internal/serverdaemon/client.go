@@ -0,0 +1,139 @@
+package serverdaemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// Request is a request to the daemon, one JSON line per connection:
+// "acquire" a version's server, or "status" and "stop".
+type Request struct {
+	Type    string `json:"type"`
+	Version string `json:"version,omitempty"`
+}
+
+// Response is the daemon's reply to a Request.
+type Response struct {
+	Type   string  `json:"type"` // "ok" or "error"
+	Error  string  `json:"error,omitempty"`
+	Server *Lease  `json:"server,omitempty"` // for acquire
+	Status *Status `json:"status,omitempty"` // for status
+}
+
+// Status describes the daemon and its servers.
+type Status struct {
+	PID         int            `json:"pid"`
+	IdleSeconds int            `json:"idle_seconds"`
+	IdleTimeout int            `json:"idle_timeout"` // seconds, 0 for none
+	Servers     []ServerStatus `json:"servers"`
+}
+
+// ServerStatus describes one of the daemon's servers.
+type ServerStatus struct {
+	Version string `json:"version"`
+	State   string `json:"state"` // "starting", "ready" or "busy"
+	Port    int    `json:"port,omitempty"`
+	PID     int    `json:"pid,omitempty"`
+	Runs    int    `json:"runs"`
+	Waiting int    `json:"waiting"` // runs queued for it
+}
+
+// Lease is a server lent to one run by Acquire. The server is the run's
+// until Close.
+type Lease struct {
+	Version   string `json:"version"`
+	Port      int    `json:"port"`
+	AuthType  string `json:"auth_type,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+
+	conn net.Conn
+}
+
+// Close gives the server back to the daemon.
+func (l *Lease) Close() error { return l.conn.Close() }
+
+// SocketPath returns the Unix socket path for the daemon, per user as
+// the VM pool's is, unless DH_SERVER_DAEMON_SOCKET names another.
+func SocketPath() string {
+	if p := os.Getenv("DH_SERVER_DAEMON_SOCKET"); p != "" {
+		return p
+	}
+	return fmt.Sprintf("/tmp/dh-server-%d.sock", os.Getuid())
+}
+
+// Probe reports whether the daemon is running.
+func Probe() bool {
+	conn, err := net.DialTimeout("unix", SocketPath(), 100*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Command sends a status or stop request to the daemon.
+func Command(req *Request) (*Response, error) {
+	conn, resp, err := send(req, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	return resp, nil
+}
+
+// Acquire asks the daemon for its server of version, starting it if it
+// is not up and waiting while another run has it.
+func Acquire(version string) (*Lease, error) {
+	conn, resp, err := send(&Request{Type: "acquire", Version: version}, ServerTimeout+5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Type == "error" || resp.Server == nil {
+		conn.Close()
+		return nil, fmt.Errorf("server daemon: %s", resp.Error)
+	}
+	conn.SetDeadline(time.Time{})
+	resp.Server.conn = conn
+	return resp.Server, nil
+}
+
+// send sends req and reads the response, leaving the connection open.
+func send(req *Request, timeout time.Duration) (net.Conn, *Response, error) {
+	conn, err := net.DialTimeout("unix", SocketPath(), 2*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to server daemon: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	reqBytes = append(reqBytes, '\n')
+	output.TraceFrame("server-daemon", "->", reqBytes)
+	if _, err := conn.Write(reqBytes); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	respLine, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("reading response: %w", err)
+	}
+	output.TraceFrame("server-daemon", "<-", respLine)
+
+	var resp Response
+	if err := json.Unmarshal(respLine, &resp); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("parsing response: %w", err)
+	}
+	return conn, &resp, nil
+}
@@ -0,0 +1,314 @@
+package serverdaemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config configures the daemon.
+type Config struct {
+	DHHome      string
+	ConfigDir   string
+	Versions    []string      // servers started right away; others start on first use
+	IdleTimeout time.Duration // shut down after this long without runs; 0 for never
+	JVMArgs     string        // for every server
+	Verbose     bool
+}
+
+// Daemon lends each version's warm server to one run at a time.
+type Daemon struct {
+	cfg Config
+	log io.Writer
+
+	mu       sync.Mutex
+	slots    map[string]*slot
+	active   int // runs holding or waiting for a server
+	lastUsed time.Time
+	cancel   context.CancelFunc
+}
+
+// slot is the server of one version.
+type slot struct {
+	version string
+	lock    chan struct{} // held while the server starts or is lent
+	server  *Server
+	state   string // "starting", "ready" or "busy"
+	runs    int
+	waiting int
+}
+
+// New returns a daemon for cfg.
+func New(cfg Config) *Daemon {
+	return &Daemon{cfg: cfg, slots: map[string]*slot{}}
+}
+
+// Start serves requests on SocketPath until ctx is done, a stop request
+// comes, or the daemon has been idle for the idle timeout, then stops its
+// servers.
+func (d *Daemon) Start(ctx context.Context, log io.Writer) error {
+	d.log = log
+	socketPath := SocketPath()
+	if Probe() {
+		return fmt.Errorf("the server daemon is already running (%s)", socketPath)
+	}
+	os.Remove(socketPath) // left by a daemon that didn't exit cleanly
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", socketPath, err)
+	}
+	os.Chmod(socketPath, 0o600)
+	defer os.Remove(socketPath)
+
+	ctx, d.cancel = context.WithCancel(ctx)
+	defer d.cancel()
+	d.lastUsed = time.Now()
+	fmt.Fprintf(log, "Server daemon listening on %s (pid %d)\n", socketPath, os.Getpid())
+
+	var wg sync.WaitGroup
+	for _, v := range d.cfg.Versions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := d.slot(v)
+			s.lock <- struct{}{}
+			if err := d.ensureServer(ctx, s); err != nil {
+				fmt.Fprintf(log, "%v\n", err)
+			}
+			<-s.lock
+		}()
+	}
+	go d.watchIdle(ctx)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.handle(ctx, conn)
+		}()
+	}
+	wg.Wait()
+
+	for _, s := range d.slots {
+		if s.server != nil {
+			fmt.Fprintf(log, "Stopping Deephaven %s\n", s.version)
+			s.server.Stop()
+		}
+	}
+	fmt.Fprintln(log, "Server daemon stopped")
+	return nil
+}
+
+// slot returns the slot of version, creating it on first use.
+func (d *Daemon) slot(version string) *slot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.slots[version]
+	if !ok {
+		s = &slot{version: version, lock: make(chan struct{}, 1), state: "starting"}
+		d.slots[version] = s
+	}
+	return s
+}
+
+// ensureServer starts the server of s unless it is up. The caller holds
+// s.lock.
+func (d *Daemon) ensureServer(ctx context.Context, s *slot) error {
+	if s.server != nil && !s.server.Exited() {
+		return nil
+	}
+	if s.server != nil {
+		fmt.Fprintf(d.log, "Deephaven %s exited; starting it again\n", s.version)
+	}
+	d.setState(s, "starting")
+	fmt.Fprintf(d.log, "Starting Deephaven %s\n", s.version)
+	server, err := StartServer(ctx, d.cfg.DHHome, ServerOptions{
+		Purpose:   "the server daemon",
+		Name:      "server-daemon-" + s.version,
+		Version:   s.version,
+		JVMArgs:   d.cfg.JVMArgs,
+		ConfigDir: d.cfg.ConfigDir,
+	})
+	if err != nil {
+		d.mu.Lock()
+		s.server = nil
+		d.mu.Unlock()
+		return fmt.Errorf("starting Deephaven %s: %w", s.version, err)
+	}
+	d.mu.Lock()
+	s.server = server
+	d.mu.Unlock()
+	d.setState(s, "ready")
+	fmt.Fprintf(d.log, "Deephaven %s ready on port %d (pid %d)\n", s.version, server.Reg.Port, server.PID())
+	return nil
+}
+
+func (d *Daemon) setState(s *slot, state string) {
+	d.mu.Lock()
+	s.state = state
+	d.mu.Unlock()
+}
+
+// handle serves the request on conn.
+func (d *Daemon) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		reply(conn, &Response{Type: "error", Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	switch req.Type {
+	case "acquire":
+		d.lend(ctx, conn, reader, req.Version)
+	case "status":
+		reply(conn, &Response{Type: "ok", Status: d.status()})
+	case "stop":
+		reply(conn, &Response{Type: "ok"})
+		d.cancel()
+	default:
+		reply(conn, &Response{Type: "error", Error: fmt.Sprintf("unknown request type %q", req.Type)})
+	}
+}
+
+// lend lends the server of version to the run on conn, starting it if
+// needed, until the run closes conn.
+func (d *Daemon) lend(ctx context.Context, conn net.Conn, reader *bufio.Reader, version string) {
+	if version == "" {
+		reply(conn, &Response{Type: "error", Error: "no version given"})
+		return
+	}
+	s := d.slot(version)
+	d.mu.Lock()
+	d.active++
+	s.waiting++
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.active--
+		d.lastUsed = time.Now()
+		d.mu.Unlock()
+	}()
+
+	select {
+	case s.lock <- struct{}{}:
+	case <-ctx.Done():
+		d.mu.Lock()
+		s.waiting--
+		d.mu.Unlock()
+		reply(conn, &Response{Type: "error", Error: "the server daemon is stopping"})
+		return
+	}
+	defer func() { <-s.lock }()
+	d.mu.Lock()
+	s.waiting--
+	d.mu.Unlock()
+
+	if err := d.ensureServer(ctx, s); err != nil {
+		reply(conn, &Response{Type: "error", Error: err.Error()})
+		return
+	}
+	reg := s.server.Reg
+	d.mu.Lock()
+	s.runs++
+	s.state = "busy"
+	d.mu.Unlock()
+	defer d.setState(s, "ready")
+	if d.cfg.Verbose {
+		fmt.Fprintf(d.log, "Lending Deephaven %s to a run\n", version)
+	}
+	if err := reply(conn, &Response{Type: "ok", Server: &Lease{
+		Version: version, Port: reg.Port, AuthType: reg.AuthType, AuthToken: reg.AuthToken,
+	}}); err != nil {
+		return
+	}
+
+	// The run has the server until it closes the connection, or the
+	// daemon stops.
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// status reports the daemon and its servers.
+func (d *Daemon) status() *Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := &Status{
+		PID:         os.Getpid(),
+		IdleTimeout: int(d.cfg.IdleTimeout.Seconds()),
+		Servers:     []ServerStatus{},
+	}
+	if d.active == 0 {
+		st.IdleSeconds = int(time.Since(d.lastUsed).Seconds())
+	}
+	for _, s := range d.slots {
+		ss := ServerStatus{Version: s.version, State: s.state, Runs: s.runs, Waiting: s.waiting}
+		if s.server != nil && s.state != "starting" {
+			ss.Port, ss.PID = s.server.Reg.Port, s.server.PID()
+		}
+		st.Servers = append(st.Servers, ss)
+	}
+	sort.Slice(st.Servers, func(i, j int) bool { return st.Servers[i].Version < st.Servers[j].Version })
+	return st
+}
+
+// watchIdle stops the daemon once it has had no runs for the idle
+// timeout.
+func (d *Daemon) watchIdle(ctx context.Context) {
+	if d.cfg.IdleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			idle := d.active == 0 && time.Since(d.lastUsed) >= d.cfg.IdleTimeout
+			d.mu.Unlock()
+			if idle {
+				fmt.Fprintf(d.log, "Idle for %s, shutting down\n", d.cfg.IdleTimeout)
+				d.cancel()
+				return
+			}
+		}
+	}
+}
+
+// reply writes resp to conn as one JSON line.
+func reply(conn net.Conn, resp *Response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,79 @@
+package serverdaemon
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startDaemon runs a daemon without servers on a socket of its own.
+func startDaemon(t *testing.T, idle time.Duration) <-chan error {
+	t.Helper()
+	t.Setenv("DH_SERVER_DAEMON_SOCKET", filepath.Join(t.TempDir(), "d.sock"))
+	done := make(chan error, 1)
+	go func() {
+		done <- New(Config{DHHome: t.TempDir(), IdleTimeout: idle}).Start(context.Background(), io.Discard)
+	}()
+	for i := 0; !Probe(); i++ {
+		if i == 50 {
+			t.Fatal("daemon did not start")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return done
+}
+
+func TestDaemon_StatusAndStop(t *testing.T) {
+	done := startDaemon(t, 0)
+
+	resp, err := Command(&Request{Type: "status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != "ok" || resp.Status == nil || len(resp.Status.Servers) != 0 || resp.Status.IdleTimeout != 0 {
+		t.Errorf("status = %+v", resp)
+	}
+
+	if _, err := Acquire(""); err == nil || !strings.Contains(err.Error(), "no version given") {
+		t.Errorf("Acquire(\"\") error = %v", err)
+	}
+	if resp, err := Command(&Request{Type: "scale"}); err != nil || resp.Type != "error" {
+		t.Errorf("unknown request: %+v, %v", resp, err)
+	}
+
+	if resp, err := Command(&Request{Type: "stop"}); err != nil || resp.Type != "ok" {
+		t.Fatalf("stop: %+v, %v", resp, err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not stop")
+	}
+	if Probe() {
+		t.Error("socket still accepts connections")
+	}
+}
+
+func TestDaemon_IdleTimeout(t *testing.T) {
+	done := startDaemon(t, time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not stop when idle")
+	}
+}
+
+func TestDaemon_AlreadyRunning(t *testing.T) {
+	startDaemon(t, 0)
+	err := New(Config{}).Start(context.Background(), io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("second Start = %v", err)
+	}
+	Command(&Request{Type: "stop"})
+}
@@ -0,0 +1,154 @@
+// Package serverdaemon keeps embedded Deephaven servers warm for dh exec:
+// a daemon, like the VM pool's, that runs one server per version and
+// lends it to one run at a time over a Unix socket. It also starts the
+// single server the runs of dh exec --watch share.
+package serverdaemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
+)
+
+// ServerTimeout bounds how long StartServer waits for a server to accept
+// connections.
+const ServerTimeout = 2 * time.Minute
+
+// ServerOptions says how to start a server with StartServer.
+type ServerOptions struct {
+	Purpose   string // what the server is for, in errors: "--watch"
+	Name      string // registered name, unique among running servers
+	Version   string
+	Port      int // 0 for any free port
+	JVMArgs   string
+	ConfigDir string
+}
+
+// Server is an embedded server started by StartServer: a dh serve of an
+// empty script, registered under a name of its own.
+type Server struct {
+	Reg *discovery.Registration // its port and auth, once ready
+
+	process *exec.Cmd
+	exited  chan struct{}
+	purpose string
+	script  string // the empty script
+	log     string // the server's output
+}
+
+// StartServer starts a server and waits for it to accept connections.
+func StartServer(ctx context.Context, dhHome string, opts ServerOptions) (*Server, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("getting executable path: %w", err)
+	}
+	script, err := os.CreateTemp("", "dh-serve-*.py")
+	if err != nil {
+		return nil, err
+	}
+	script.Close()
+	logFile, err := os.CreateTemp("", "dh-serve-*.log")
+	if err != nil {
+		os.Remove(script.Name())
+		return nil, err
+	}
+	defer logFile.Close()
+
+	args := []string{"serve", script.Name(), "--no-browser", "--name", opts.Name,
+		"--version", opts.Version,
+		"--port", strconv.Itoa(opts.Port),
+		"--jvm-args=" + opts.JVMArgs,
+	}
+	if opts.ConfigDir != "" {
+		args = append(args, "--config-dir", opts.ConfigDir)
+	}
+	s := &Server{
+		process: exec.Command(exePath, args...),
+		exited:  make(chan struct{}),
+		purpose: opts.Purpose,
+		script:  script.Name(),
+		log:     logFile.Name(),
+	}
+	s.process.Stdout = logFile
+	s.process.Stderr = logFile
+	// Its own process group: Ctrl+C stops the caller, which then stops
+	// the server.
+	s.process.SysProcAttr = processGroupAttr()
+	if err := s.process.Start(); err != nil {
+		os.Remove(s.script)
+		os.Remove(s.log)
+		return nil, fmt.Errorf("starting server: %w", err)
+	}
+	go func() {
+		s.process.Wait()
+		close(s.exited)
+	}()
+
+	if s.Reg, err = s.waitReady(ctx, dhHome, opts.Name); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// PID returns the process ID of the dh serve running the server.
+func (s *Server) PID() int { return s.process.Process.Pid }
+
+// Log returns the path of the file the server's output goes to.
+func (s *Server) Log() string { return s.log }
+
+// Exited reports whether the server has stopped.
+func (s *Server) Exited() bool {
+	select {
+	case <-s.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitReady waits for the server to register under name and accept
+// connections on its port.
+func (s *Server) waitReady(ctx context.Context, dhHome, name string) (*discovery.Registration, error) {
+	deadline := time.After(ServerTimeout)
+	for {
+		if reg, err := discovery.Lookup(dhHome, name); err == nil {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(reg.Port)), time.Second)
+			if err == nil {
+				conn.Close()
+				return reg, nil
+			}
+		}
+		select {
+		case <-s.exited:
+			log, _ := os.ReadFile(s.log)
+			return nil, fmt.Errorf("the server for %s exited:\n%s", s.purpose, log)
+		case <-deadline:
+			log, _ := os.ReadFile(s.log)
+			return nil, fmt.Errorf("the server for %s did not start within %s:\n%s", s.purpose, ServerTimeout, log)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// Stop shuts the server down: dh serve stops the server on the first
+// SIGTERM, and kills it on the second.
+func (s *Server) Stop() {
+	terminate(s.process.Process)
+	select {
+	case <-s.exited:
+	case <-time.After(10 * time.Second):
+		terminate(s.process.Process)
+		<-s.exited
+	}
+	os.Remove(s.script)
+	os.Remove(s.log)
+}
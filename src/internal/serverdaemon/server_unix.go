@@ -0,0 +1,18 @@
+//go:build !windows
+
+package serverdaemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// processGroupAttr returns SysProcAttr to create a new process group on unix.
+func processGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate asks p to stop with SIGTERM.
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package serverdaemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// processGroupAttr returns SysProcAttr to create a new process group on Windows.
+func processGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: 0x00000200} // CREATE_NEW_PROCESS_GROUP
+}
+
+// terminate kills p: Windows has no SIGTERM to send.
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
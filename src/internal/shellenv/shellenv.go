@@ -0,0 +1,145 @@
+// Package shellenv builds the shell commands that put a Deephaven
+// version's environment (its venv, Java and DH_VERSION) into the user's
+// shell, and take it out again.
+package shellenv
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Shells are the shells Format supports.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// activeVar marks a shell with a dh environment; it holds the version.
+const activeVar = "DH_ENV"
+
+// oldPrefix prefixes the variables that hold the values from before
+// activation, for deactivation to restore.
+const oldPrefix = "_DH_OLD_"
+
+// Changes are the variables to set and unset in the shell.
+type Changes struct {
+	Set   map[string]string `json:"set"`
+	Unset []string          `json:"unset"`
+}
+
+// Env is the environment of a version.
+type Env struct {
+	Version  string
+	VenvDir  string // the version's .venv
+	BinDir   string // the venv's bin (Scripts on Windows)
+	JavaHome string // empty when Java was not found; JAVA_HOME is then left alone
+}
+
+// managed are the variables Activate changes, besides the marker.
+var managed = []string{"PATH", "VIRTUAL_ENV", "DH_VERSION", "JAVA_HOME"}
+
+// Activate returns the changes that activate e in a shell whose
+// environment is looked up with lookup (os.LookupEnv). The values from
+// before activation are saved for Deactivate; when a dh environment is
+// already active it is replaced, keeping the values saved by the first.
+func Activate(e Env, lookup func(string) (string, bool)) Changes {
+	original := lookup
+	if _, active := lookup(activeVar); active {
+		original = func(name string) (string, bool) { return lookup(oldPrefix + name) }
+	}
+
+	c := Changes{Set: map[string]string{}}
+	for _, name := range managed {
+		if v, ok := original(name); ok {
+			c.Set[oldPrefix+name] = v
+		} else {
+			c.Unset = append(c.Unset, oldPrefix+name)
+		}
+	}
+
+	if path, _ := original("PATH"); path != "" {
+		c.Set["PATH"] = e.BinDir + string(os.PathListSeparator) + path
+	} else {
+		c.Set["PATH"] = e.BinDir
+	}
+	c.Set["VIRTUAL_ENV"] = e.VenvDir
+	c.Set["DH_VERSION"] = e.Version
+	if e.JavaHome != "" {
+		c.Set["JAVA_HOME"] = e.JavaHome
+	} else if v, ok := original("JAVA_HOME"); ok {
+		c.Set["JAVA_HOME"] = v
+	} else {
+		c.Unset = append(c.Unset, "JAVA_HOME")
+	}
+	c.Set[activeVar] = e.Version
+	sort.Strings(c.Unset)
+	return c
+}
+
+// Deactivate returns the changes that restore the environment from
+// before Activate. It reports false when no dh environment is active.
+func Deactivate(lookup func(string) (string, bool)) (Changes, bool) {
+	if _, active := lookup(activeVar); !active {
+		return Changes{}, false
+	}
+	c := Changes{Set: map[string]string{}, Unset: []string{activeVar}}
+	for _, name := range managed {
+		if v, ok := lookup(oldPrefix + name); ok {
+			c.Set[name] = v
+			c.Unset = append(c.Unset, oldPrefix+name)
+		} else {
+			c.Unset = append(c.Unset, name)
+		}
+	}
+	sort.Strings(c.Unset)
+	return c, true
+}
+
+// Format returns c as commands for shell, to be evaluated by it.
+func Format(c Changes, shell string) (string, error) {
+	var b strings.Builder
+	names := make([]string, 0, len(c.Set))
+	for name := range c.Set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch shell {
+	case "bash", "zsh":
+		for _, name := range c.Unset {
+			fmt.Fprintf(&b, "unset %s;\n", name)
+		}
+		for _, name := range names {
+			fmt.Fprintf(&b, "export %s=%s;\n", name, shQuote(c.Set[name]))
+		}
+		// Forget cached command locations, so python finds the venv's
+		b.WriteString("hash -r 2>/dev/null;\n")
+	case "fish":
+		for _, name := range c.Unset {
+			fmt.Fprintf(&b, "set -e %s;\n", name)
+		}
+		for _, name := range names {
+			v := c.Set[name]
+			if name == "PATH" {
+				// PATH is a list in fish
+				parts := strings.Split(v, string(os.PathListSeparator))
+				for i, p := range parts {
+					parts[i] = fishQuote(p)
+				}
+				fmt.Fprintf(&b, "set -gx PATH %s;\n", strings.Join(parts, " "))
+				continue
+			}
+			fmt.Fprintf(&b, "set -gx %s %s;\n", name, fishQuote(v))
+		}
+	default:
+		return "", fmt.Errorf("unsupported shell %q (supported: %s)", shell, strings.Join(Shells, ", "))
+	}
+	return b.String(), nil
+}
+
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
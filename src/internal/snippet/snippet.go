@@ -0,0 +1,296 @@
+package snippet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Snippet is a named piece of code saved for reuse with dh snippet run.
+type Snippet struct {
+	Name        string    `toml:"-" json:"name"`
+	Description string    `toml:"description,omitempty" json:"description"`
+	Code        string    `toml:"code" json:"code"`
+	CreatedAt   time.Time `toml:"created_at" json:"created_at"`
+}
+
+// file is the on-disk layout of snippets.toml (and of export files).
+type file struct {
+	Snippets map[string]Snippet `toml:"snippets"`
+}
+
+// ErrNotFound is returned (wrapped) when a named snippet does not exist.
+var ErrNotFound = errors.New("not found")
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// paramRegexp matches {{name}} and {{name:default}} placeholders, and
+// \{{, the escape of a literal {{ such as Python's "{{x}}".format(...) and
+// f-strings need.
+var paramRegexp = regexp.MustCompile(`\\\{\{|\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*(?::([^}]*))?\}\}`)
+
+// Path returns the snippets file location under dhHome.
+func Path(dhHome string) string {
+	return filepath.Join(dhHome, "snippets.toml")
+}
+
+// ValidateName checks that name is usable as a snippet name.
+func ValidateName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid snippet name %q: use letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+func load(dhHome string) (map[string]Snippet, error) {
+	data, err := os.ReadFile(Path(dhHome))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]Snippet{}, nil
+		}
+		return nil, fmt.Errorf("reading snippets: %w", err)
+	}
+	return decode(data)
+}
+
+func decode(data []byte) (map[string]Snippet, error) {
+	var f file
+	if err := toml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing snippets: %w", err)
+	}
+	if f.Snippets == nil {
+		f.Snippets = map[string]Snippet{}
+	}
+	for name, s := range f.Snippets {
+		s.Name = name
+		f.Snippets[name] = s
+	}
+	return f.Snippets, nil
+}
+
+func encode(w io.Writer, snippets map[string]Snippet) error {
+	data, err := toml.Marshal(file{Snippets: snippets})
+	if err != nil {
+		return fmt.Errorf("marshaling snippets: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func save(dhHome string, snippets map[string]Snippet) error {
+	if err := os.MkdirAll(dhHome, 0o755); err != nil {
+		return fmt.Errorf("creating config dir: %w", err)
+	}
+	f, err := os.Create(Path(dhHome))
+	if err != nil {
+		return fmt.Errorf("writing snippets: %w", err)
+	}
+	defer f.Close()
+	return encode(f, snippets)
+}
+
+// List returns all saved snippets sorted by name.
+func List(dhHome string) ([]Snippet, error) {
+	snippets, err := load(dhHome)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Snippet, 0, len(snippets))
+	for _, s := range snippets {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Get returns the snippet with the given name.
+func Get(dhHome, name string) (*Snippet, error) {
+	snippets, err := load(dhHome)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := snippets[name]
+	if !ok {
+		return nil, fmt.Errorf("snippet %q: %w", name, ErrNotFound)
+	}
+	return &s, nil
+}
+
+// Add saves s. An existing snippet with the same name is only replaced
+// when force is set.
+func Add(dhHome string, s Snippet, force bool) error {
+	if err := ValidateName(s.Name); err != nil {
+		return err
+	}
+	if strings.TrimSpace(s.Code) == "" {
+		return fmt.Errorf("snippet %q has no code", s.Name)
+	}
+	snippets, err := load(dhHome)
+	if err != nil {
+		return err
+	}
+	if _, exists := snippets[s.Name]; exists && !force {
+		return fmt.Errorf("snippet %q already exists (use --force to replace it)", s.Name)
+	}
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	snippets[s.Name] = s
+	return save(dhHome, snippets)
+}
+
+// Remove deletes the snippet with the given name.
+func Remove(dhHome, name string) error {
+	snippets, err := load(dhHome)
+	if err != nil {
+		return err
+	}
+	if _, ok := snippets[name]; !ok {
+		return fmt.Errorf("snippet %q: %w", name, ErrNotFound)
+	}
+	delete(snippets, name)
+	return save(dhHome, snippets)
+}
+
+// Export writes the named snippets (all snippets when names is empty) to w
+// in the same TOML layout used by snippets.toml.
+func Export(dhHome string, names []string, w io.Writer) error {
+	snippets, err := load(dhHome)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		selected := make(map[string]Snippet, len(names))
+		for _, name := range names {
+			s, ok := snippets[name]
+			if !ok {
+				return fmt.Errorf("snippet %q: %w", name, ErrNotFound)
+			}
+			selected[name] = s
+		}
+		snippets = selected
+	}
+	return encode(w, snippets)
+}
+
+// Import reads snippets exported by Export and saves them. Snippets whose
+// names already exist are skipped unless force is set. Returns the names
+// imported and skipped, each sorted.
+func Import(dhHome string, r io.Reader, force bool) (imported, skipped []string, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading import file: %w", err)
+	}
+	incoming, err := decode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	snippets, err := load(dhHome)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, s := range incoming {
+		if err := ValidateName(name); err != nil {
+			return nil, nil, err
+		}
+		if _, exists := snippets[name]; exists && !force {
+			skipped = append(skipped, name)
+			continue
+		}
+		snippets[name] = s
+		imported = append(imported, name)
+	}
+	sort.Strings(imported)
+	sort.Strings(skipped)
+	if len(imported) > 0 {
+		if err := save(dhHome, snippets); err != nil {
+			return nil, nil, err
+		}
+	}
+	return imported, skipped, nil
+}
+
+// Params returns the placeholder names used in code, in order of first use.
+func Params(code string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range paramRegexp.FindAllStringSubmatch(code, -1) {
+		if m[1] != "" && !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// Render substitutes {{name}} and {{name:default}} placeholders in code,
+// and turns \{{ into {{. Values in params take precedence over defaults; a
+// placeholder with neither is an error. Unused params are also an error,
+// to catch typos.
+func Render(code string, params map[string]string) (string, error) {
+	used := map[string]bool{}
+	var missing []string
+	out := paramRegexp.ReplaceAllStringFunc(code, func(match string) string {
+		m := paramRegexp.FindStringSubmatch(match)
+		name := m[1]
+		if name == "" {
+			return "{{"
+		}
+		used[name] = true
+		if v, ok := params[name]; ok {
+			return v
+		}
+		if strings.Contains(match, ":") {
+			return m[2]
+		}
+		missing = append(missing, name)
+		return match
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing value for parameter(s): %s (use -p NAME=VALUE)", strings.Join(dedup(missing), ", "))
+	}
+	var unknown []string
+	for name := range params {
+		if !used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("unknown parameter(s): %s", strings.Join(unknown, ", "))
+	}
+	return out, nil
+}
+
+// ParseParams parses NAME=VALUE pairs from -p flags.
+func ParseParams(pairs []string) (map[string]string, error) {
+	params := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid parameter %q: expected NAME=VALUE", p)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+func dedup(names []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
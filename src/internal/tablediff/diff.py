@@ -0,0 +1,112 @@
+# Server-side table comparison for dh diff. Runs inside the Deephaven server
+# after the Go side defines __dh_diff_opts, and prints a single line starting
+# with __DH_DIFF__ that holds the result as JSON.
+
+
+def __dh_diff(opts):
+    import json
+
+    from deephaven.pandas import to_pandas
+    from deephaven.table import Table
+
+    max_rows = opts["max_rows"]
+    keys = opts["key"]
+
+    def rows(t, n):
+        if n <= 0 or t.size == 0:
+            return []
+        df = to_pandas(t.head(n))
+        return json.loads(df.to_json(orient="records", date_format="iso", default_handler=str))
+
+    def schema(t):
+        return [{"name": c.name, "type": str(c.data_type)} for c in t.columns]
+
+    def diff_tables(name, left, right):
+        if left.is_refreshing:
+            left = left.snapshot()
+        if right.is_refreshing:
+            right = right.snapshot()
+        ls, rs = schema(left), schema(right)
+        d = {
+            "name": name,
+            "left_schema": ls,
+            "right_schema": rs,
+            "left_rows": left.size,
+            "right_rows": right.size,
+        }
+
+        right_types = {c["name"]: c["type"] for c in rs}
+        common = [c["name"] for c in ls if right_types.get(c["name"]) == c["type"]]
+        if not common:
+            d["error"] = "no columns with the same name and type in both tables"
+            return d
+        match = keys or common
+        missing = [k for k in match if k not in common]
+        if missing:
+            d["error"] = "key columns not in both tables with the same type: " + ", ".join(missing)
+            return d
+
+        removed = left.where_not_in(right, match)
+        added = right.where_not_in(left, match)
+        d["removed"] = removed.size
+        d["removed_sample"] = rows(removed, max_rows)
+        d["added"] = added.size
+        d["added_sample"] = rows(added, max_rows)
+
+        values = [c for c in common if c not in match]
+        if keys and values:
+            new = right.where_in(left, keys).where_not_in(left, common)
+            d["changed"] = new.size
+            new_rows = rows(new, max_rows)
+            old_rows = rows(left.where_in(new.head(max_rows), keys), max_rows * 2)
+            old_by_key = {tuple(r[k] for k in keys): r for r in old_rows}
+            changes = []
+            for r in new_rows:
+                key = tuple(r[k] for k in keys)
+                old = old_by_key.get(key, {})
+                diff_cols = [c for c in values if old.get(c) != r.get(c)]
+                changes.append({
+                    "key": {k: r[k] for k in keys},
+                    "old": {c: old.get(c) for c in diff_cols},
+                    "new": {c: r.get(c) for c in diff_cols},
+                })
+            d["changed_sample"] = changes
+        return d
+
+    def scope_tables(ns):
+        return {k: v for k, v in ns.items() if isinstance(v, Table) and not k.startswith("_")}
+
+    def run_script(path, code):
+        ns = {"__name__": "__main__", "__file__": path}
+        exec(compile(code, path, "exec"), ns)
+        return scope_tables(ns)
+
+    if opts["compare"]:
+        left_tables = run_script(opts["left"], opts["left_code"])
+        right_tables = run_script(opts["right"], opts["right_code"])
+        names = opts["tables"] or sorted(set(left_tables) | set(right_tables))
+        result = []
+        for name in names:
+            left, right = left_tables.get(name), right_tables.get(name)
+            if left is None and right is None:
+                result.append({"name": name, "error": "not assigned by either script"})
+            elif left is None:
+                result.append({"name": name, "only_in": "right"})
+            elif right is None:
+                result.append({"name": name, "only_in": "left"})
+            else:
+                result.append(diff_tables(name, left, right))
+    else:
+        scope = scope_tables(globals())
+        for name in (opts["left"], opts["right"]):
+            if name not in scope:
+                raise NameError(f"no table named {name!r} on the server")
+        result = [diff_tables(opts["left"] + " -> " + opts["right"], scope[opts["left"]], scope[opts["right"]])]
+
+    print("__DH_DIFF__" + json.dumps({"tables": result}, default=str))
+
+
+try:
+    __dh_diff(__dh_diff_opts)
+finally:
+    del __dh_diff, __dh_diff_opts
@@ -0,0 +1,335 @@
+// Package tablediff compares Deephaven tables. The comparison itself runs
+// on the server (see diff.py); this package builds the script, parses its
+// result and renders it as a diff or a list of patch operations.
+package tablediff
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+//go:embed diff.py
+var diffScript string
+
+// marker prefixes the result line printed by diff.py.
+const marker = "__DH_DIFF__"
+
+// Options selects what to compare.
+type Options struct {
+	// Left and Right are table names on the server, or with Compare the
+	// paths of two scripts whose tables are compared by name.
+	Left, Right string
+	Compare     bool
+	LeftCode    string   // script contents, with Compare
+	RightCode   string   // script contents, with Compare
+	Tables      []string // with Compare, only these tables (default: all)
+	Key         []string // columns that identify a row; default: all columns
+	MaxRows     int      // sample rows kept per kind of difference
+}
+
+// Script returns the Python code that compares the tables on the server.
+func Script(opts Options) (string, error) {
+	tables := opts.Tables
+	if tables == nil {
+		tables = []string{}
+	}
+	key := opts.Key
+	if key == nil {
+		key = []string{}
+	}
+	data, err := json.Marshal(map[string]any{
+		"left":       opts.Left,
+		"right":      opts.Right,
+		"compare":    opts.Compare,
+		"left_code":  opts.LeftCode,
+		"right_code": opts.RightCode,
+		"tables":     tables,
+		"key":        key,
+		"max_rows":   opts.MaxRows,
+	})
+	if err != nil {
+		return "", err
+	}
+	// A Go-quoted string is also a valid Python string literal.
+	return fmt.Sprintf("import json as __dh_json\n__dh_diff_opts = __dh_json.loads(%q)\ndel __dh_json\n%s", data, diffScript), nil
+}
+
+// Column is a column name and its Deephaven type.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Change is a row whose key is in both tables but whose other values
+// differ. Old and New hold only the differing columns.
+type Change struct {
+	Key map[string]any `json:"key"`
+	Old map[string]any `json:"old"`
+	New map[string]any `json:"new"`
+}
+
+// TableDiff is the comparison of one pair of tables. The samples hold up
+// to Options.MaxRows rows each; the counts cover every row.
+type TableDiff struct {
+	Name          string           `json:"name"`
+	OnlyIn        string           `json:"only_in,omitempty"` // "left" or "right" when one side has no such table
+	Error         string           `json:"error,omitempty"`
+	LeftSchema    []Column         `json:"left_schema,omitempty"`
+	RightSchema   []Column         `json:"right_schema,omitempty"`
+	LeftRows      int64            `json:"left_rows"`
+	RightRows     int64            `json:"right_rows"`
+	Added         int64            `json:"added"`
+	Removed       int64            `json:"removed"`
+	Changed       int64            `json:"changed"`
+	AddedSample   []map[string]any `json:"added_sample,omitempty"`
+	RemovedSample []map[string]any `json:"removed_sample,omitempty"`
+	ChangedSample []Change         `json:"changed_sample,omitempty"`
+}
+
+// Result is the comparison of every selected pair of tables.
+type Result struct {
+	Tables []TableDiff `json:"tables"`
+}
+
+// Parse extracts the result from the stdout of the diff script.
+func Parse(stdout string) (*Result, error) {
+	for _, line := range strings.Split(stdout, "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), marker)
+		if !ok {
+			continue
+		}
+		var res Result
+		if err := json.Unmarshal([]byte(data), &res); err != nil {
+			return nil, fmt.Errorf("parsing diff result: %w", err)
+		}
+		return &res, nil
+	}
+	return nil, fmt.Errorf("the server did not return a diff result")
+}
+
+// Equal reports whether the tables match.
+func (d *TableDiff) Equal() bool {
+	return d.OnlyIn == "" && d.Error == "" && len(d.SchemaChanges()) == 0 &&
+		d.Added == 0 && d.Removed == 0 && d.Changed == 0
+}
+
+// Equal reports whether every pair of tables matches.
+func (r *Result) Equal() bool {
+	for i := range r.Tables {
+		if !r.Tables[i].Equal() {
+			return false
+		}
+	}
+	return true
+}
+
+// SchemaChanges lists the columns removed ("- Name (type)") and added
+// ("+ Name (type)") from the left schema to the right one. A column whose
+// type changed appears as both.
+func (d *TableDiff) SchemaChanges() []string {
+	right := make(map[string]string, len(d.RightSchema))
+	for _, c := range d.RightSchema {
+		right[c.Name] = c.Type
+	}
+	left := make(map[string]string, len(d.LeftSchema))
+	var out []string
+	for _, c := range d.LeftSchema {
+		left[c.Name] = c.Type
+		if t, ok := right[c.Name]; !ok || t != c.Type {
+			out = append(out, fmt.Sprintf("- %s (%s)", c.Name, c.Type))
+		}
+	}
+	for _, c := range d.RightSchema {
+		if t, ok := left[c.Name]; !ok || t != c.Type {
+			out = append(out, fmt.Sprintf("+ %s (%s)", c.Name, c.Type))
+		}
+	}
+	return out
+}
+
+// Op is one patch operation that turns the left table into the right one,
+// in the style of RFC 6902: paths are /TABLE/columns/COLUMN for schema
+// changes, /TABLE/rows for whole rows and /TABLE/rows/KEY/COLUMN for
+// changed values, where KEY is "Col=value,...". Old carries the value
+// being removed or replaced. Only sampled rows are included.
+type Op struct {
+	Op    string `json:"op"` // add, remove or replace
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+	Old   any    `json:"old,omitempty"`
+}
+
+// Patch returns the patch operations for every difference in r.
+func (r *Result) Patch() []Op {
+	ops := []Op{}
+	for _, d := range r.Tables {
+		base := "/" + pointerEscape(d.Name)
+		switch d.OnlyIn {
+		case "left":
+			ops = append(ops, Op{Op: "remove", Path: base})
+			continue
+		case "right":
+			ops = append(ops, Op{Op: "add", Path: base})
+			continue
+		}
+		right := make(map[string]string, len(d.RightSchema))
+		for _, c := range d.RightSchema {
+			right[c.Name] = c.Type
+		}
+		left := make(map[string]string, len(d.LeftSchema))
+		for _, c := range d.LeftSchema {
+			left[c.Name] = c.Type
+			path := base + "/columns/" + pointerEscape(c.Name)
+			if t, ok := right[c.Name]; !ok {
+				ops = append(ops, Op{Op: "remove", Path: path, Old: c.Type})
+			} else if t != c.Type {
+				ops = append(ops, Op{Op: "replace", Path: path, Value: t, Old: c.Type})
+			}
+		}
+		for _, c := range d.RightSchema {
+			if _, ok := left[c.Name]; !ok {
+				ops = append(ops, Op{Op: "add", Path: base + "/columns/" + pointerEscape(c.Name), Value: c.Type})
+			}
+		}
+		for _, row := range d.RemovedSample {
+			ops = append(ops, Op{Op: "remove", Path: base + "/rows", Old: row})
+		}
+		for _, row := range d.AddedSample {
+			ops = append(ops, Op{Op: "add", Path: base + "/rows", Value: row})
+		}
+		for _, c := range d.ChangedSample {
+			key := pointerEscape(formatKey(c.Key))
+			for _, col := range slices.Sorted(maps.Keys(c.New)) {
+				ops = append(ops, Op{
+					Op:    "replace",
+					Path:  base + "/rows/" + key + "/" + pointerEscape(col),
+					Value: c.New[col],
+					Old:   c.Old[col],
+				})
+			}
+		}
+	}
+	return ops
+}
+
+// pointerEscape escapes a JSON Pointer reference token.
+func pointerEscape(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+func formatKey(key map[string]any) string {
+	parts := make([]string, 0, len(key))
+	for _, k := range slices.Sorted(maps.Keys(key)) {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, formatValue(key[k])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatValue(v any) string {
+	if v == nil {
+		return "null"
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func formatRow(row map[string]any) string {
+	b, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Sprint(row)
+	}
+	return string(b)
+}
+
+// ANSI colors for Render.
+const (
+	red    = "\x1b[31m"
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	bold   = "\x1b[1m"
+	reset  = "\x1b[0m"
+)
+
+// Render writes r as a diff: "-" for what only the left side has, "+" for
+// the right side and "~" for changed values, colored when color is set.
+func Render(w io.Writer, r *Result, color bool) {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + reset
+	}
+	line := func(s string) {
+		switch {
+		case strings.HasPrefix(s, "- "):
+			s = paint(red, s)
+		case strings.HasPrefix(s, "+ "):
+			s = paint(green, s)
+		case strings.HasPrefix(s, "~ "):
+			s = paint(yellow, s)
+		}
+		fmt.Fprintln(w, "  "+s)
+	}
+	more := func(shown int, total int64) {
+		if total > int64(shown) {
+			fmt.Fprintf(w, "  ... and %d more\n", total-int64(shown))
+		}
+	}
+
+	for i, d := range r.Tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, paint(bold, "Table "+d.Name))
+		switch {
+		case d.Error != "":
+			fmt.Fprintf(w, "  error: %s\n", d.Error)
+			continue
+		case d.OnlyIn == "left":
+			line("- only in the left script")
+			continue
+		case d.OnlyIn == "right":
+			line("+ only in the right script")
+			continue
+		case d.Equal():
+			fmt.Fprintf(w, "  identical (%d rows)\n", d.LeftRows)
+			continue
+		}
+
+		if changes := d.SchemaChanges(); len(changes) > 0 {
+			fmt.Fprintln(w, "  schema:")
+			for _, c := range changes {
+				line(c)
+			}
+		}
+		fmt.Fprintf(w, "  rows: %d -> %d (%d added, %d removed, %d changed)\n",
+			d.LeftRows, d.RightRows, d.Added, d.Removed, d.Changed)
+		for _, row := range d.RemovedSample {
+			line("- " + formatRow(row))
+		}
+		more(len(d.RemovedSample), d.Removed)
+		for _, row := range d.AddedSample {
+			line("+ " + formatRow(row))
+		}
+		more(len(d.AddedSample), d.Added)
+		for _, c := range d.ChangedSample {
+			var vals []string
+			for _, col := range slices.Sorted(maps.Keys(c.New)) {
+				vals = append(vals, fmt.Sprintf("%s %s -> %s", col, formatValue(c.Old[col]), formatValue(c.New[col])))
+			}
+			line(fmt.Sprintf("~ %s: %s", formatKey(c.Key), strings.Join(vals, ", ")))
+		}
+		more(len(d.ChangedSample), d.Changed)
+	}
+}
@@ -2,7 +2,9 @@ package tui
 
 import (
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
 	"github.com/dsmmcken/dh-cli/src/internal/tui/screens"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/tasks"
 )
 
 // AppMode determines which screen to show first.
@@ -36,10 +38,11 @@ func NewApp(mode AppMode, dhHome string) App {
 }
 
 func (a App) Init() tea.Cmd {
+	waitTasks := tasks.Default().WaitForUpdate()
 	if len(a.stack) > 0 {
-		return a.stack[len(a.stack)-1].Init()
+		return tea.Batch(a.stack[len(a.stack)-1].Init(), waitTasks)
 	}
-	return nil
+	return waitTasks
 }
 
 func (a App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -69,7 +72,27 @@ func (a App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.stack = a.stack[:len(a.stack)-1]
 		return a, nil
 
+	case tasks.UpdatedMsg:
+		// Every screen on the stack may be tracking a task, not just the
+		// active one.
+		cmds := []tea.Cmd{tasks.Default().WaitForUpdate()}
+		for i, s := range a.stack {
+			updated, cmd := s.Update(msg)
+			a.stack[i] = updated
+			cmds = append(cmds, cmd)
+		}
+		return a, tea.Batch(cmds...)
+
 	case tea.KeyMsg:
+		// ctrl+t opens the background task list from any screen
+		if msg.String() == "ctrl+t" {
+			if _, ok := a.stack[len(a.stack)-1].(screens.TasksScreen); !ok {
+				return a, func() tea.Msg {
+					return screens.PushScreenMsg{Screen: screens.NewTasksScreen(tasks.Default())}
+				}
+			}
+			return a, nil
+		}
 		// At root screen, ctrl+c always quits
 		if len(a.stack) == 1 {
 			switch msg.String() {
@@ -91,10 +114,19 @@ func (a App) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (a App) View() string {
-	if len(a.stack) > 0 {
-		return a.stack[len(a.stack)-1].View()
+	if len(a.stack) == 0 {
+		return ""
+	}
+	if a.width > 0 && (a.width < screens.MinWidth || a.height < screens.MinHeight) {
+		return components.TooSmall(a.width, a.height, screens.MinWidth, screens.MinHeight)
+	}
+	view := a.stack[len(a.stack)-1].View()
+	if _, ok := a.stack[len(a.stack)-1].(screens.TasksScreen); !ok {
+		if n := tasks.Default().Running(); n > 0 {
+			view += "\n" + screens.TaskIndicator(n)
+		}
 	}
-	return ""
+	return view
 }
 
 // StackLen returns the number of screens on the stack (for testing).
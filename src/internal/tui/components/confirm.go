@@ -0,0 +1,118 @@
+package components
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	confirmAccent = lipgloss.AdaptiveColor{Light: "#FF4672", Dark: "#FF4672"}
+	confirmDim    = lipgloss.AdaptiveColor{Light: "#999999", Dark: "#666666"}
+)
+
+// ConfirmResultMsg is sent when a Confirm dialog is answered.
+type ConfirmResultMsg struct {
+	Action       string
+	Confirmed    bool
+	DontAskAgain bool
+}
+
+// Confirm is a modal yes/no dialog for destructive actions, with a
+// "don't ask again" checkbox. Focus starts on No so a stray enter is safe.
+type Confirm struct {
+	action  string
+	title   string
+	body    string
+	yes     bool
+	dontAsk bool
+	width   int
+}
+
+// NewConfirm creates a dialog for action (e.g. "uninstall"), reported back
+// in ConfirmResultMsg.
+func NewConfirm(action, title, body string) Confirm {
+	return Confirm{action: action, title: title, body: body}
+}
+
+// Action returns the action being confirmed.
+func (c Confirm) Action() string {
+	return c.action
+}
+
+// DontAskAgain reports whether the checkbox is ticked (for testing).
+func (c Confirm) DontAskAgain() bool {
+	return c.dontAsk
+}
+
+// SetWidth limits the dialog to w columns, wrapping the title and body.
+// Zero leaves it unconstrained.
+func (c *Confirm) SetWidth(w int) {
+	c.width = w
+}
+
+// Update handles y/n, enter on the focused button, tab/arrows to move focus
+// and space to toggle "don't ask again".
+func (c Confirm) Update(msg tea.Msg) (Confirm, tea.Cmd) {
+	k, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return c, nil
+	}
+	switch k.String() {
+	case "y", "Y":
+		return c, c.answer(true)
+	case "n", "N", "esc":
+		return c, c.answer(false)
+	case "enter":
+		return c, c.answer(c.yes)
+	case "left", "right", "h", "l", "tab", "shift+tab":
+		c.yes = !c.yes
+	case " ", "d":
+		c.dontAsk = !c.dontAsk
+	}
+	return c, nil
+}
+
+func (c Confirm) answer(confirmed bool) tea.Cmd {
+	res := ConfirmResultMsg{Action: c.action, Confirmed: confirmed, DontAskAgain: confirmed && c.dontAsk}
+	return func() tea.Msg { return res }
+}
+
+// View renders the dialog box.
+func (c Confirm) View() string {
+	button := func(label string, focused bool) string {
+		st := lipgloss.NewStyle().Padding(0, 2)
+		if focused {
+			st = st.Background(confirmAccent).Foreground(lipgloss.Color("#FFFFFF")).Bold(true)
+		} else {
+			st = st.Foreground(confirmDim)
+		}
+		return st.Render(label)
+	}
+	check := "[ ]"
+	if c.dontAsk {
+		check = "[x]"
+	}
+
+	// Margin, border and padding take 8 columns.
+	text := lipgloss.NewStyle()
+	if c.width > 0 {
+		text = text.Width(max(c.width-8, 10))
+	}
+
+	var b strings.Builder
+	b.WriteString(text.Bold(true).Render(c.title))
+	if c.body != "" {
+		b.WriteString("\n\n" + text.Render(c.body))
+	}
+	b.WriteString("\n\n" + button("Yes", c.yes) + "  " + button("No", !c.yes))
+	b.WriteString("\n\n" + lipgloss.NewStyle().Foreground(confirmDim).Render(check+" Don't ask again (space)"))
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(confirmAccent).
+		Padding(0, 2).
+		MarginLeft(2).
+		Render(b.String())
+}
@@ -0,0 +1,18 @@
+package components
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// TooSmall renders the message shown in place of a screen when the terminal
+// is smaller than minWidth x minHeight, centred in the space available.
+func TooSmall(width, height, minWidth, minHeight int) string {
+	msg := lipgloss.JoinVertical(lipgloss.Center,
+		lipgloss.NewStyle().Bold(true).Render("Terminal too small"),
+		lipgloss.NewStyle().Foreground(confirmDim).Render(
+			fmt.Sprintf("%dx%d, need %dx%d", width, height, minWidth, minHeight)),
+	)
+	return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, msg)
+}
@@ -0,0 +1,32 @@
+package screens
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
+)
+
+// askConfirm returns a confirmation dialog for action sized to width, or nil
+// when the user chose "don't ask again" for it (tui.skip_confirm).
+func askConfirm(width int, action, title, body string) *components.Confirm {
+	if cfg, err := config.Load(); err == nil && slices.Contains(cfg.TUI.SkipConfirm, action) {
+		return nil
+	}
+	c := components.NewConfirm(action, title, body)
+	c.SetWidth(width)
+	return &c
+}
+
+// rememberSkipConfirm adds action to tui.skip_confirm.
+func rememberSkipConfirm(action string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(cfg.TUI.SkipConfirm, action) {
+		return nil
+	}
+	return config.Set("tui.skip_confirm", strings.Join(append(cfg.TUI.SkipConfirm, action), ","))
+}
@@ -0,0 +1,16 @@
+package screens
+
+// Terminal size thresholds. Below MinWidth x MinHeight the app shows a
+// resize message instead of the active screen; below NarrowWidth screens
+// switch to a compact layout that drops secondary columns.
+const (
+	MinWidth    = 30
+	MinHeight   = 10
+	NarrowWidth = 50
+)
+
+// narrow reports whether width calls for the compact layout. A zero width
+// means no WindowSizeMsg has arrived yet and keeps the normal layout.
+func narrow(width int) bool {
+	return width > 0 && width < NarrowWidth
+}
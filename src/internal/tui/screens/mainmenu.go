@@ -10,6 +10,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dsmmcken/dh-cli/src/internal/config"
 	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
 )
 
 type menuItem struct {
@@ -77,6 +78,7 @@ func NewMainMenu(dhHome string) MainMenu {
 		{title: "Running servers", desc: "View and manage active Deephaven processes"},
 		{title: "Java status", desc: "Check or install Java runtime"},
 		{title: "Environment doctor", desc: "Diagnose and fix setup issues"},
+		{title: "VM snapshots", desc: "Prepare, verify, and delete Firecracker snapshots"},
 		{title: "Configuration", desc: "View and edit settings"},
 	}
 
@@ -98,7 +100,11 @@ func buildStatusLine(dhHome string) string {
 
 	cfg, err := config.Load()
 	if err == nil && cfg.DefaultVersion != "" {
-		parts = append(parts, fmt.Sprintf("Active: v%s", cfg.DefaultVersion))
+		active := cfg.DefaultVersion
+		if !versions.IsConstraint(active) {
+			active = "v" + active
+		}
+		parts = append(parts, fmt.Sprintf("Active: %s", active))
 	} else {
 		parts = append(parts, "No version set")
 	}
@@ -152,6 +158,8 @@ func (m MainMenu) selectItem() tea.Cmd {
 	case 3:
 		return pushScreen(NewDoctorScreen(m.dhHome))
 	case 4:
+		return pushScreen(NewSnapshotsScreen(m.dhHome))
+	case 5:
 		return pushScreen(NewConfigScreen(m.dhHome))
 	}
 	return nil
@@ -160,8 +168,8 @@ func (m MainMenu) selectItem() tea.Cmd {
 func (m MainMenu) View() string {
 	var b strings.Builder
 
-	showLogo := m.height >= 20
-	showDesc := m.height >= 15
+	showLogo := m.height >= 20 && (m.width == 0 || m.width >= lipgloss.Width(components.Logo)+2)
+	showDesc := m.height >= 15 && !narrow(m.width)
 
 	if showLogo {
 		logo := lipgloss.NewStyle().
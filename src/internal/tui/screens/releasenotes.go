@@ -0,0 +1,230 @@
+package screens
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+)
+
+// ReleaseNotesLoadedMsg is sent when release notes finish loading.
+// Exported for testing.
+type ReleaseNotesLoadedMsg struct {
+	Notes *versions.ReleaseNotes
+	Err   error
+}
+
+type releaseNotesKeyMap struct {
+	Up      key.Binding
+	Down    key.Binding
+	Install key.Binding
+	Help    key.Binding
+	Back    key.Binding
+	Quit    key.Binding
+}
+
+func (k releaseNotesKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.Install, k.Back}
+}
+
+func (k releaseNotesKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down},
+		{k.Install},
+		{k.Help, k.Back, k.Quit},
+	}
+}
+
+// ReleaseNotesScreen shows the changelog for one version, scrollable, with
+// a shortcut to install it.
+type ReleaseNotesScreen struct {
+	keys      releaseNotesKeyMap
+	help      help.Model
+	spinner   spinner.Model
+	viewport  viewport.Model
+	dhHome    string
+	version   string
+	installed bool
+	notes     *versions.ReleaseNotes
+	loading   bool
+	err       error
+	width     int
+	height    int
+}
+
+func NewReleaseNotesScreen(dhHome, version string, installed bool) ReleaseNotesScreen {
+	s := spinner.New()
+	s.Spinner = spinner.Dot
+	return ReleaseNotesScreen{
+		keys: releaseNotesKeyMap{
+			Up:      key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "scroll up")),
+			Down:    key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "scroll down")),
+			Install: key.NewBinding(key.WithKeys("i"), key.WithHelp("i", "install")),
+			Help:    key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "more")),
+			Back:    key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
+			Quit:    key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
+		},
+		help:      help.New(),
+		spinner:   s,
+		viewport:  viewport.New(0, 0),
+		dhHome:    dhHome,
+		version:   version,
+		installed: installed,
+		loading:   true,
+	}
+}
+
+func (m ReleaseNotesScreen) Init() tea.Cmd {
+	return tea.Batch(m.spinner.Tick, m.loadNotes())
+}
+
+func (m ReleaseNotesScreen) loadNotes() tea.Cmd {
+	dhHome, version := m.dhHome, m.version
+	return func() tea.Msg {
+		notes, err := versions.FetchReleaseNotes(dhHome, version)
+		return ReleaseNotesLoadedMsg{Notes: notes, Err: err}
+	}
+}
+
+func (m *ReleaseNotesScreen) resize() {
+	// Title, blank, meta line and blank above; blank and help bar below.
+	m.viewport.Width = m.width
+	m.viewport.Height = max(m.height-6, 1)
+	if m.notes != nil {
+		m.viewport.SetContent(renderMarkdown(m.notes.Body, max(m.width-4, 20)))
+	}
+}
+
+func (m ReleaseNotesScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.help.Width = msg.Width
+		m.resize()
+		return m, nil
+
+	case ReleaseNotesLoadedMsg:
+		m.loading = false
+		m.notes = msg.Notes
+		m.err = msg.Err
+		m.resize()
+		return m, nil
+
+	case spinner.TickMsg:
+		if !m.loading {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Install):
+			if !m.installed && !m.loading {
+				return m, tea.Batch(installTask(m.dhHome, m.version, false), popScreen())
+			}
+			return m, nil
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+			return m, nil
+		case key.Matches(msg, m.keys.Back):
+			return m, popScreen()
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		}
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m ReleaseNotesScreen) View() string {
+	var b strings.Builder
+	dim := lipgloss.NewStyle().Foreground(colorDim)
+
+	b.WriteString(fmt.Sprintf("  Release notes: %s\n\n", m.version))
+
+	if m.loading {
+		b.WriteString("  " + m.spinner.View() + " Fetching release notes...\n")
+		return b.String()
+	}
+
+	if m.err != nil {
+		b.WriteString(fmt.Sprintf("  Error: %s\n\n", m.err))
+		b.WriteString(m.help.View(m.keys))
+		return b.String()
+	}
+
+	meta := []string{m.notes.Title}
+	if m.notes.Date != "" {
+		meta = append(meta, m.notes.Date)
+	}
+	if m.notes.Cached {
+		meta = append(meta, "cached")
+	}
+	b.WriteString(dim.Render("  " + strings.Join(meta, " · ")))
+	b.WriteString("\n\n")
+
+	if strings.TrimSpace(m.notes.Body) == "" {
+		b.WriteString("  No release notes for this version.\n")
+		if m.notes.URL != "" {
+			b.WriteString(dim.Render("  " + m.notes.URL))
+			b.WriteString("\n")
+		}
+	} else {
+		b.WriteString(m.viewport.View())
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(m.help.View(m.keys))
+	return b.String()
+}
+
+// renderMarkdown does a light rendering of GitHub release Markdown:
+// headings are styled, list markers become bullets, emphasis markers are
+// dropped and long lines are wrapped to width.
+func renderMarkdown(md string, width int) string {
+	heading := lipgloss.NewStyle().Foreground(colorPrimary).Bold(true)
+	wrap := lipgloss.NewStyle().Width(width)
+	emphasis := strings.NewReplacer("**", "", "__", "", "`", "")
+
+	var out []string
+	for _, line := range strings.Split(md, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#"):
+			text := strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			out = append(out, "  "+heading.Render(emphasis.Replace(text)))
+		case strings.HasPrefix(trimmed, "* "), strings.HasPrefix(trimmed, "- "):
+			indent := strings.Repeat(" ", len(line)-len(strings.TrimLeft(line, " ")))
+			text := emphasis.Replace(trimmed[2:])
+			body := lipgloss.NewStyle().Width(max(width-len(indent)-2, 10)).Render(text)
+			prefix := "  " + indent + "• "
+			pad := "  " + indent + "  "
+			for i, l := range strings.Split(body, "\n") {
+				if i == 0 {
+					out = append(out, prefix+l)
+				} else {
+					out = append(out, pad+l)
+				}
+			}
+		case trimmed == "":
+			out = append(out, "")
+		default:
+			for _, l := range strings.Split(wrap.Render(emphasis.Replace(trimmed)), "\n") {
+				out = append(out, "  "+l)
+			}
+		}
+	}
+	return strings.Join(out, "\n")
+}
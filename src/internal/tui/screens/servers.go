@@ -13,6 +13,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dsmmcken/dh-cli/src/internal/discovery"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
 )
 
 const serverPollInterval = 3 * time.Second
@@ -56,6 +57,8 @@ type ServersScreen struct {
 	cursor  int
 	loading bool
 	status  string // transient status message (e.g. "Killed ...", "Opened ...")
+	confirm *components.Confirm
+	pending int // port awaiting kill confirmation
 	err     error
 	width   int
 	height  int
@@ -110,6 +113,9 @@ func (m ServersScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = msg.Width
 		m.height = msg.Height
 		m.help.Width = msg.Width
+		if m.confirm != nil {
+			m.confirm.SetWidth(msg.Width)
+		}
 		return m, nil
 
 	case ServersLoadedMsg:
@@ -125,6 +131,16 @@ func (m ServersScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case ServersPollTickMsg:
 		return m, tea.Batch(discoverServers(), pollServersTick())
 
+	case components.ConfirmResultMsg:
+		m.confirm = nil
+		if msg.Confirmed {
+			if msg.DontAskAgain {
+				_ = rememberSkipConfirm(msg.Action)
+			}
+			return m, m.kill(m.pending)
+		}
+		return m, nil
+
 	case tea.KeyMsg:
 		if m.loading {
 			if key.Matches(msg, m.keys.Quit) {
@@ -133,6 +149,12 @@ func (m ServersScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		if m.confirm != nil {
+			c, cmd := m.confirm.Update(msg)
+			m.confirm = &c
+			return m, cmd
+		}
+
 		switch {
 		case key.Matches(msg, m.keys.Up):
 			if m.cursor > 0 {
@@ -145,13 +167,12 @@ func (m ServersScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case key.Matches(msg, m.keys.Kill):
 			if len(m.servers) > 0 {
 				s := m.servers[m.cursor]
-				if err := discovery.Kill(s.Port); err != nil {
-					m.status = fmt.Sprintf("Error: %s", err)
-				} else {
-					m.status = fmt.Sprintf("Killed server on port %d", s.Port)
+				m.confirm = askConfirm(m.width, "kill", fmt.Sprintf("Kill the server on port %d?", s.Port),
+					"Any unsaved state in the server is lost.")
+				if m.confirm == nil {
+					return m, m.kill(s.Port)
 				}
-				// Refresh immediately after kill
-				return m, discoverServers()
+				m.pending = s.Port
 			}
 		case key.Matches(msg, m.keys.Open):
 			if len(m.servers) > 0 {
@@ -171,6 +192,16 @@ func (m ServersScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+func (m *ServersScreen) kill(port int) tea.Cmd {
+	if err := discovery.Kill(port); err != nil {
+		m.status = fmt.Sprintf("Error: %s", err)
+	} else {
+		m.status = fmt.Sprintf("Killed server on port %d", port)
+	}
+	// Refresh immediately after kill
+	return discoverServers()
+}
+
 func (m ServersScreen) View() string {
 	var b strings.Builder
 
@@ -192,17 +223,33 @@ func (m ServersScreen) View() string {
 		b.WriteString(lipgloss.NewStyle().Foreground(colorDim).Render("  No servers found."))
 		b.WriteString("\n")
 	} else {
+		compact := narrow(m.width)
 		for i, s := range m.servers {
 			detail := fmt.Sprintf(":%d", s.Port)
-			if s.PID > 0 {
-				detail += fmt.Sprintf("  pid %d", s.PID)
+			var extra []string
+			if s.Name != "" {
+				extra = append(extra, s.Name)
 			}
-			detail += "  " + s.Source
 			if s.Script != "" {
-				detail += "   " + s.Script
+				extra = append(extra, s.Script)
 			}
 			if s.ContainerID != "" {
-				detail += "   " + s.ContainerID
+				extra = append(extra, s.ContainerID)
+			}
+			if compact {
+				// Stack the pid, script and container on a second line.
+				detail += "  " + s.Source
+				if s.PID > 0 {
+					extra = append([]string{fmt.Sprintf("pid %d", s.PID)}, extra...)
+				}
+			} else {
+				if s.PID > 0 {
+					detail += fmt.Sprintf("  pid %d", s.PID)
+				}
+				detail += "  " + s.Source
+				for _, e := range extra {
+					detail += "   " + e
+				}
 			}
 
 			if i == m.cursor {
@@ -211,6 +258,11 @@ func (m ServersScreen) View() string {
 				b.WriteString("    " + detail)
 			}
 			b.WriteString("\n")
+			if compact && len(extra) > 0 {
+				b.WriteString(lipgloss.NewStyle().Foreground(colorDim).Render(
+					"      " + truncate(strings.Join(extra, "  "), max(m.width-6, 1))))
+				b.WriteString("\n")
+			}
 		}
 	}
 
@@ -221,6 +273,10 @@ func (m ServersScreen) View() string {
 	}
 
 	b.WriteString("\n")
+	if m.confirm != nil {
+		b.WriteString(m.confirm.View())
+		return b.String()
+	}
 	b.WriteString(m.help.View(m.keys))
 
 	return b.String()
@@ -0,0 +1,449 @@
+package screens
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/tasks"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+// SnapshotsLoadedMsg is sent when the snapshot list finishes loading.
+// Exported for testing.
+type SnapshotsLoadedMsg struct {
+	Snapshots []vm.SnapshotInfo
+	Err       error
+}
+
+// SnapshotVerifiedMsg carries the result of verifying one snapshot.
+// Exported for testing.
+type SnapshotVerifiedMsg struct {
+	Version  string
+	Problems []string
+}
+
+type snapshotsKeyMap struct {
+	Up      key.Binding
+	Down    key.Binding
+	New     key.Binding
+	Prepare key.Binding
+	Verify  key.Binding
+	Delete  key.Binding
+	Pool    key.Binding
+	Stop    key.Binding
+	Refresh key.Binding
+	Help    key.Binding
+	Back    key.Binding
+	Quit    key.Binding
+}
+
+func (k snapshotsKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.New, k.Verify, k.Delete, k.Help, k.Back}
+}
+
+func (k snapshotsKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down},
+		{k.New, k.Prepare, k.Verify, k.Delete, k.Pool, k.Stop, k.Refresh},
+		{k.Help, k.Back, k.Quit},
+	}
+}
+
+// SnapshotsScreen lists VM snapshots per version with their size, creation
+// date and toolchain fingerprints, and can prepare, verify and delete them.
+type SnapshotsScreen struct {
+	keys      snapshotsKeyMap
+	help      help.Model
+	dhHome    string
+	snapshots []vm.SnapshotInfo
+	verified  map[string][]string // version -> problems, for verified snapshots
+	verifying string
+	confirm   *components.Confirm
+	pending   string // version awaiting delete confirmation
+	preparing map[string]bool
+	cursor    int
+	loading   bool
+	status    string
+	err       error
+	width     int
+	height    int
+}
+
+func NewSnapshotsScreen(dhHome string) SnapshotsScreen {
+	return SnapshotsScreen{
+		keys: snapshotsKeyMap{
+			Up:      key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "up")),
+			Down:    key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "down")),
+			New:     key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "prepare default version")),
+			Prepare: key.NewBinding(key.WithKeys("p"), key.WithHelp("p", "re-prepare")),
+			Verify:  key.NewBinding(key.WithKeys("v"), key.WithHelp("v", "verify")),
+			Delete:  key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "delete")),
+			Pool:    key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "start pool")),
+			Stop:    key.NewBinding(key.WithKeys("S"), key.WithHelp("S", "stop pool")),
+			Refresh: key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "refresh")),
+			Help:    key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "more")),
+			Back:    key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
+			Quit:    key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
+		},
+		help:      help.New(),
+		dhHome:    dhHome,
+		verified:  map[string][]string{},
+		preparing: map[string]bool{},
+		loading:   true,
+	}
+}
+
+func (m SnapshotsScreen) Init() tea.Cmd {
+	return m.loadSnapshots()
+}
+
+// Snapshots returns the loaded snapshots (for testing).
+func (m SnapshotsScreen) Snapshots() []vm.SnapshotInfo {
+	return m.snapshots
+}
+
+// Status returns the current status message (for testing).
+func (m SnapshotsScreen) Status() string {
+	return m.status
+}
+
+func (m SnapshotsScreen) loadSnapshots() tea.Cmd {
+	paths := vm.NewVMPaths(m.dhHome)
+	return func() tea.Msg {
+		snaps, err := vm.ListSnapshots(paths)
+		return SnapshotsLoadedMsg{Snapshots: snaps, Err: err}
+	}
+}
+
+func (m SnapshotsScreen) verifySnapshot(version string) tea.Cmd {
+	paths := vm.NewVMPaths(m.dhHome)
+	return func() tea.Msg {
+		return SnapshotVerifiedMsg{Version: version, Problems: vm.VerifySnapshot(paths, version)}
+	}
+}
+
+func (m *SnapshotsScreen) prepare(version string) tea.Cmd {
+	m.preparing[version] = true
+	m.status = fmt.Sprintf("Preparing snapshot %s in the background (ctrl+t: tasks)", version)
+	return prepareSnapshot(version)
+}
+
+// prepareSnapshot runs `dh vm prepare` for version as a background task.
+func prepareSnapshot(version string) tea.Cmd {
+	return dhTask("vm-prepare:"+version, "Prepare VM snapshot "+version, "vm", "prepare", "--version", version)
+}
+
+// startPool starts the VM pool daemon for version as a background task.
+func startPool(version string) tea.Cmd {
+	return dhTask("pool-start", "Start VM pool "+version, "vm", "pool", "start", "--background", "--version", version)
+}
+
+func (m SnapshotsScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.help.Width = msg.Width
+		if m.confirm != nil {
+			m.confirm.SetWidth(msg.Width)
+		}
+		return m, nil
+
+	case SnapshotsLoadedMsg:
+		m.loading = false
+		m.snapshots = msg.Snapshots
+		m.err = msg.Err
+		if m.cursor >= len(m.snapshots) {
+			m.cursor = max(len(m.snapshots)-1, 0)
+		}
+		return m, nil
+
+	case SnapshotVerifiedMsg:
+		m.verifying = ""
+		m.verified[msg.Version] = msg.Problems
+		if len(msg.Problems) == 0 {
+			m.status = fmt.Sprintf("Snapshot %s verified", msg.Version)
+		} else {
+			m.status = fmt.Sprintf("Snapshot %s has %d problem(s)", msg.Version, len(msg.Problems))
+		}
+		return m, nil
+
+	case tasks.UpdatedMsg:
+		// Reload once a prepare started from this screen finishes.
+		done := false
+		for version := range m.preparing {
+			t, ok := tasks.Default().Find("vm-prepare:" + version)
+			if ok && t.State == tasks.Running {
+				continue
+			}
+			delete(m.preparing, version)
+			delete(m.verified, version)
+			done = true
+			switch {
+			case !ok || t.State == tasks.Succeeded:
+				m.status = fmt.Sprintf("Snapshot %s prepared", version)
+			case t.State == tasks.Canceled:
+				m.status = fmt.Sprintf("Preparing %s canceled", version)
+			default:
+				m.status = fmt.Sprintf("Preparing %s failed: %v", version, t.Err)
+			}
+		}
+		if done && !m.loading {
+			m.loading = true
+			return m, m.loadSnapshots()
+		}
+		return m, nil
+
+	case components.ConfirmResultMsg:
+		m.confirm = nil
+		if !msg.Confirmed {
+			return m, nil
+		}
+		if msg.DontAskAgain {
+			_ = rememberSkipConfirm(msg.Action)
+		}
+		if msg.Action == "pool-stop" {
+			m.stopPool()
+			return m, nil
+		}
+		return m, m.delete(m.pending)
+
+	case tea.KeyMsg:
+		if m.loading {
+			if key.Matches(msg, m.keys.Quit) {
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+
+		if m.confirm != nil {
+			c, cmd := m.confirm.Update(msg)
+			m.confirm = &c
+			return m, cmd
+		}
+
+		switch {
+		case key.Matches(msg, m.keys.Up):
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case key.Matches(msg, m.keys.Down):
+			if m.cursor < len(m.snapshots)-1 {
+				m.cursor++
+			}
+		case key.Matches(msg, m.keys.New):
+			cfg, _ := config.Load()
+			if cfg == nil || cfg.DefaultVersion == "" {
+				m.status = "No default version set"
+				return m, nil
+			}
+			v, ok := versions.Select(cfg.DefaultVersion, versions.InstalledNames(m.dhHome))
+			if !ok {
+				m.status = fmt.Sprintf("Default version %s is not installed", cfg.DefaultVersion)
+				return m, nil
+			}
+			return m, m.prepare(v)
+		case key.Matches(msg, m.keys.Prepare):
+			if len(m.snapshots) > 0 {
+				return m, m.prepare(m.snapshots[m.cursor].Version)
+			}
+		case key.Matches(msg, m.keys.Pool):
+			if len(m.snapshots) > 0 && m.snapshots[m.cursor].Complete {
+				version := m.snapshots[m.cursor].Version
+				m.status = fmt.Sprintf("Starting VM pool for %s in the background (ctrl+t: tasks)", version)
+				return m, startPool(version)
+			}
+		case key.Matches(msg, m.keys.Verify):
+			if len(m.snapshots) > 0 && m.verifying == "" {
+				m.verifying = m.snapshots[m.cursor].Version
+				m.status = fmt.Sprintf("Verifying %s...", m.verifying)
+				return m, m.verifySnapshot(m.verifying)
+			}
+		case key.Matches(msg, m.keys.Delete):
+			if len(m.snapshots) > 0 {
+				v := m.snapshots[m.cursor].Version
+				m.confirm = askConfirm(m.width, "vm-clean", fmt.Sprintf("Delete the VM snapshot for %s?", v),
+					"The rootfs image is kept, but preparing a new snapshot takes a few minutes.")
+				if m.confirm == nil {
+					return m, m.delete(v)
+				}
+				m.pending = v
+			}
+		case key.Matches(msg, m.keys.Stop):
+			if !vm.PoolProbe() {
+				m.status = "VM pool is not running"
+				return m, nil
+			}
+			m.confirm = askConfirm(m.width, "pool-stop", "Stop the VM pool daemon?",
+				"Warm VMs are destroyed; dh exec --vm falls back to a cold restore.")
+			if m.confirm == nil {
+				m.stopPool()
+			}
+		case key.Matches(msg, m.keys.Refresh):
+			m.loading = true
+			return m, m.loadSnapshots()
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Back):
+			return m, popScreen()
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m *SnapshotsScreen) delete(version string) tea.Cmd {
+	if err := vm.DeleteSnapshot(vm.NewVMPaths(m.dhHome), version); err != nil {
+		m.status = err.Error()
+		return nil
+	}
+	delete(m.verified, version)
+	m.status = fmt.Sprintf("Deleted snapshot %s", version)
+	m.loading = true
+	return m.loadSnapshots()
+}
+
+func (m *SnapshotsScreen) stopPool() {
+	if _, err := vm.PoolCommand(&vm.PoolRequest{Type: "stop"}); err != nil {
+		m.status = fmt.Sprintf("Error stopping pool: %s", err)
+		return
+	}
+	m.status = "VM pool stopped"
+}
+
+func (m SnapshotsScreen) View() string {
+	var b strings.Builder
+
+	b.WriteString("  VM Snapshots\n\n")
+
+	if m.loading {
+		b.WriteString("  Loading...\n")
+		return b.String()
+	}
+
+	if m.err != nil {
+		b.WriteString(fmt.Sprintf("  Error: %s\n", m.err))
+		return b.String()
+	}
+
+	dim := lipgloss.NewStyle().Foreground(colorDim)
+	if len(m.snapshots) == 0 {
+		b.WriteString("  No snapshots found.\n")
+		b.WriteString(dim.Render("  Press n to prepare one for the default version."))
+		b.WriteString("\n")
+	} else {
+		maxLen := 0
+		for _, s := range m.snapshots {
+			if len(s.Version) > maxLen {
+				maxLen = len(s.Version)
+			}
+		}
+
+		// Narrow terminals move the creation time onto the detail line.
+		compact := narrow(m.width)
+		for i, s := range m.snapshots {
+			label := fmt.Sprintf("%-*s  %9s  %-16s  %s", maxLen, s.Version,
+				formatSize(s.SizeBytes), snapshotCreated(s), m.snapshotState(s))
+			if compact {
+				label = fmt.Sprintf("%-*s  %9s  %s", maxLen, s.Version,
+					formatSize(s.SizeBytes), m.snapshotState(s))
+			}
+			if i == m.cursor {
+				b.WriteString(lipgloss.NewStyle().Foreground(colorPrimary).Bold(true).Render("  > " + label))
+			} else {
+				b.WriteString("    " + label)
+			}
+			b.WriteString("\n")
+			if i == m.cursor {
+				if compact {
+					b.WriteString(dim.Render("      " + snapshotCreated(s)))
+					b.WriteString("\n")
+				}
+				fingerprints := snapshotFingerprints(s)
+				if compact {
+					fingerprints = truncate(fingerprints, max(m.width-6, 1))
+				}
+				b.WriteString(dim.Render("      " + fingerprints))
+				b.WriteString("\n")
+				for _, p := range m.verified[s.Version] {
+					b.WriteString(lipgloss.NewStyle().Foreground(colorError).Render("      ✗ " + p))
+					b.WriteString("\n")
+				}
+			}
+		}
+	}
+
+	if m.status != "" {
+		b.WriteString("\n  " + m.status + "\n")
+	}
+
+	b.WriteString("\n")
+	if m.confirm != nil {
+		b.WriteString(m.confirm.View())
+		return b.String()
+	}
+	b.WriteString(m.help.View(m.keys))
+
+	return b.String()
+}
+
+func (m SnapshotsScreen) snapshotState(s vm.SnapshotInfo) string {
+	if m.preparing[s.Version] {
+		return lipgloss.NewStyle().Foreground(colorWarning).Render("preparing...")
+	}
+	if m.verifying == s.Version {
+		return lipgloss.NewStyle().Foreground(colorDim).Render("verifying...")
+	}
+	if problems, ok := m.verified[s.Version]; ok {
+		if len(problems) == 0 {
+			return lipgloss.NewStyle().Foreground(colorSuccess).Render("verified")
+		}
+		return lipgloss.NewStyle().Foreground(colorError).Render("corrupt")
+	}
+	if !s.Complete {
+		return lipgloss.NewStyle().Foreground(colorWarning).Render("incomplete")
+	}
+	return lipgloss.NewStyle().Foreground(colorSuccess).Render("ready")
+}
+
+func snapshotCreated(s vm.SnapshotInfo) string {
+	if s.Meta == nil || s.Meta.CreatedAt.IsZero() {
+		return "unknown"
+	}
+	return s.Meta.CreatedAt.Local().Format("2006-01-02 15:04")
+}
+
+func snapshotFingerprints(s vm.SnapshotInfo) string {
+	fc, kernel := "unknown", "unknown"
+	if s.Meta != nil {
+		if s.Meta.Firecracker != "" {
+			fc = s.Meta.Firecracker
+		}
+		if len(s.Meta.KernelSHA256) >= 12 {
+			kernel = s.Meta.KernelSHA256[:12]
+		}
+	}
+	return fmt.Sprintf("firecracker %s · kernel %s", fc, kernel)
+}
+
+// formatSize renders a byte count in binary units.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
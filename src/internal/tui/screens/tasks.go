@@ -0,0 +1,294 @@
+package screens
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/tasks"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+)
+
+type tasksKeyMap struct {
+	Up     key.Binding
+	Down   key.Binding
+	Logs   key.Binding
+	Cancel key.Binding
+	Clear  key.Binding
+	Help   key.Binding
+	Back   key.Binding
+	Quit   key.Binding
+}
+
+func (k tasksKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.Logs, k.Cancel, k.Clear, k.Help, k.Back}
+}
+
+func (k tasksKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down},
+		{k.Logs, k.Cancel, k.Clear},
+		{k.Help, k.Back, k.Quit},
+	}
+}
+
+// TasksScreen lists background tasks with their progress, and shows the
+// log of the selected task.
+type TasksScreen struct {
+	keys     tasksKeyMap
+	help     help.Model
+	manager  *tasks.Manager
+	tasks    []tasks.Info
+	cursor   int
+	showLogs bool
+	logs     viewport.Model
+	width    int
+	height   int
+}
+
+func NewTasksScreen(manager *tasks.Manager) TasksScreen {
+	return TasksScreen{
+		keys: tasksKeyMap{
+			Up:     key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "up")),
+			Down:   key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "down")),
+			Logs:   key.NewBinding(key.WithKeys("enter", "l"), key.WithHelp("enter", "logs")),
+			Cancel: key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "cancel")),
+			Clear:  key.NewBinding(key.WithKeys("c"), key.WithHelp("c", "clear finished")),
+			Help:   key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "more")),
+			Back:   key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
+			Quit:   key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
+		},
+		help:    help.New(),
+		manager: manager,
+		tasks:   manager.List(),
+		logs:    viewport.New(0, 0),
+	}
+}
+
+func (m TasksScreen) Init() tea.Cmd {
+	return nil
+}
+
+// Tasks returns the tasks shown (for testing).
+func (m TasksScreen) Tasks() []tasks.Info {
+	return m.tasks
+}
+
+func (m *TasksScreen) refresh() {
+	m.tasks = m.manager.List()
+	if m.cursor >= len(m.tasks) {
+		m.cursor = max(len(m.tasks)-1, 0)
+	}
+	if len(m.tasks) == 0 {
+		m.showLogs = false
+	}
+	m.syncLogs()
+}
+
+func (m *TasksScreen) syncLogs() {
+	// Title, blank, the task list and a blank line above; blank and help below.
+	m.logs.Width = m.width - 4
+	m.logs.Height = max(m.height-len(m.tasks)-6, 3)
+	if !m.showLogs || len(m.tasks) == 0 {
+		return
+	}
+	atBottom := m.logs.AtBottom()
+	m.logs.SetContent(strings.Join(m.tasks[m.cursor].Log, "\n"))
+	if atBottom {
+		m.logs.GotoBottom()
+	}
+}
+
+func (m TasksScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.help.Width = msg.Width
+		m.syncLogs()
+		return m, nil
+
+	case tasks.UpdatedMsg:
+		m.refresh()
+		return m, nil
+
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.keys.Up):
+			if m.cursor > 0 {
+				m.cursor--
+				m.logs.GotoBottom()
+				m.syncLogs()
+			}
+		case key.Matches(msg, m.keys.Down):
+			if m.cursor < len(m.tasks)-1 {
+				m.cursor++
+				m.logs.GotoBottom()
+				m.syncLogs()
+			}
+		case key.Matches(msg, m.keys.Logs):
+			if len(m.tasks) > 0 {
+				m.showLogs = !m.showLogs
+				m.logs.GotoBottom()
+				m.syncLogs()
+			}
+		case key.Matches(msg, m.keys.Cancel):
+			if len(m.tasks) > 0 {
+				m.manager.Cancel(m.tasks[m.cursor].ID)
+			}
+		case key.Matches(msg, m.keys.Clear):
+			m.manager.ClearFinished()
+			m.refresh()
+		case key.Matches(msg, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
+		case key.Matches(msg, m.keys.Back):
+			return m, popScreen()
+		case key.Matches(msg, m.keys.Quit):
+			return m, tea.Quit
+		default:
+			if m.showLogs {
+				var cmd tea.Cmd
+				m.logs, cmd = m.logs.Update(msg)
+				return m, cmd
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m TasksScreen) View() string {
+	var b strings.Builder
+	dim := lipgloss.NewStyle().Foreground(colorDim)
+
+	b.WriteString("  Background Tasks\n\n")
+
+	if len(m.tasks) == 0 {
+		b.WriteString("  No tasks.\n")
+		b.WriteString(dim.Render("  Installs and VM operations started from other screens run here."))
+		b.WriteString("\n\n")
+		b.WriteString(m.help.View(m.keys))
+		return b.String()
+	}
+
+	for i, t := range m.tasks {
+		line := fmt.Sprintf("%s %s  %s", taskStateIcon(t.State), t.Title,
+			dim.Render(t.Elapsed().Round(time.Second).String()))
+		switch {
+		case t.State == tasks.Failed && t.Err != nil:
+			line += "  " + lipgloss.NewStyle().Foreground(colorError).Render(t.Err.Error())
+		case t.State == tasks.Running && t.LastLine() != "":
+			line += "  " + dim.Render(truncate(t.LastLine(), max(m.width-len(t.Title)-20, 10)))
+		}
+		if i == m.cursor {
+			b.WriteString(lipgloss.NewStyle().Foreground(colorPrimary).Bold(true).Render("  > ") + line)
+		} else {
+			b.WriteString("    " + line)
+		}
+		b.WriteString("\n")
+	}
+
+	if m.showLogs {
+		b.WriteString("\n")
+		if len(m.tasks[m.cursor].Log) == 0 {
+			b.WriteString(dim.Render("  No output yet."))
+		} else {
+			b.WriteString(lipgloss.NewStyle().PaddingLeft(4).Render(m.logs.View()))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(m.help.View(m.keys))
+	return b.String()
+}
+
+func taskStateIcon(s tasks.State) string {
+	switch s {
+	case tasks.Running:
+		return lipgloss.NewStyle().Foreground(colorPrimary).Render("⟳")
+	case tasks.Succeeded:
+		return lipgloss.NewStyle().Foreground(colorSuccess).Render("✓")
+	case tasks.Canceled:
+		return lipgloss.NewStyle().Foreground(colorWarning).Render("⊘")
+	default:
+		return lipgloss.NewStyle().Foreground(colorError).Render("✗")
+	}
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// startTask returns a command that starts a background task on the shared
+// manager. Starting happens when the command runs, not when it is built.
+func startTask(key, title string, fn tasks.Func) tea.Cmd {
+	return func() tea.Msg {
+		tasks.Default().Start(key, title, fn)
+		return tasks.UpdatedMsg{}
+	}
+}
+
+// installTask installs version with the configured Python version and
+// plugins, optionally making it the default once installed.
+func installTask(dhHome, version string, setDefault bool) tea.Cmd {
+	return startTask("install:"+version, "Install Deephaven "+version, func(ctx context.Context, log func(string)) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		plugins := cfg.Install.Plugins
+		if len(plugins) == 0 {
+			plugins = []string{
+				"deephaven-plugin-ui",
+				"deephaven-plugin-plotly-express",
+			}
+		}
+		pythonVer := cfg.Install.PythonVersion
+		if pythonVer == "" {
+			pythonVer = "3.13"
+		}
+		w := tasks.NewLogWriter(log)
+		defer w.Flush()
+		if err := versions.InstallContext(ctx, dhHome, version, pythonVer, plugins, log, w); err != nil {
+			return err
+		}
+		if setDefault {
+			return config.Set("default_version", version)
+		}
+		return nil
+	})
+}
+
+// dhTask runs this dh binary with args as a background task.
+func dhTask(key, title string, args ...string) tea.Cmd {
+	return startTask(key, title, func(ctx context.Context, log func(string)) error {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		return tasks.Command(exe, args...)(ctx, log)
+	})
+}
+
+// TaskIndicator is the footer the app shows on other screens while tasks
+// are running.
+func TaskIndicator(running int) string {
+	noun := "task"
+	if running != 1 {
+		noun = "tasks"
+	}
+	return lipgloss.NewStyle().Foreground(colorDim).Render(
+		fmt.Sprintf("  ⟳ %d background %s running · ctrl+t to view", running, noun))
+}
@@ -9,6 +9,8 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/tasks"
 	"github.com/dsmmcken/dh-cli/src/internal/versions"
 )
 
@@ -34,19 +36,20 @@ type versionsKeyMap struct {
 	Enter     key.Binding
 	Install   key.Binding
 	Uninstall key.Binding
+	Notes     key.Binding
 	Help      key.Binding
 	Back      key.Binding
 	Quit      key.Binding
 }
 
 func (k versionsKeyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Up, k.Down, k.Enter, k.Install, k.Uninstall, k.Help, k.Back}
+	return []key.Binding{k.Up, k.Down, k.Enter, k.Install, k.Uninstall, k.Notes, k.Help, k.Back}
 }
 
 func (k versionsKeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down},
-		{k.Enter, k.Install, k.Uninstall},
+		{k.Enter, k.Install, k.Uninstall, k.Notes},
 		{k.Help, k.Back, k.Quit},
 	}
 }
@@ -58,6 +61,9 @@ type VersionsScreen struct {
 	dflt    string
 	cursor  int
 	loading bool
+	status  string
+	confirm *components.Confirm
+	pending string // version awaiting uninstall confirmation
 	err     error
 	dhHome string
 	width   int
@@ -72,6 +78,7 @@ func NewVersionsScreen(dhHome string) VersionsScreen {
 			Enter:     key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "set default")),
 			Install:   key.NewBinding(key.WithKeys("i"), key.WithHelp("i", "install")),
 			Uninstall: key.NewBinding(key.WithKeys("u"), key.WithHelp("u", "uninstall")),
+			Notes:     key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "release notes")),
 			Help:      key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "more")),
 			Back:      key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
 			Quit:      key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
@@ -109,6 +116,9 @@ func (m VersionsScreen) loadVersions() tea.Cmd {
 		if err != nil {
 			return VersionsListLoadedMsg{Err: err}
 		}
+		if versions.IsConstraint(dflt) {
+			dflt, _ = versions.Select(dflt, versions.InstalledNames(dhHome))
+		}
 
 		remote, remoteErr := versions.FetchRemoteVersionsWithDates(20)
 		if remoteErr != nil {
@@ -164,6 +174,9 @@ func (m VersionsScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.width = msg.Width
 		m.height = msg.Height
 		m.help.Width = msg.Width
+		if m.confirm != nil {
+			m.confirm.SetWidth(msg.Width)
+		}
 		return m, nil
 
 	case VersionsListLoadedMsg:
@@ -173,6 +186,21 @@ func (m VersionsScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.err = msg.Err
 		return m, nil
 
+	case tasks.UpdatedMsg:
+		m.syncInstalls()
+		return m, nil
+
+	case components.ConfirmResultMsg:
+		m.confirm = nil
+		if msg.Confirmed {
+			if msg.DontAskAgain {
+				_ = rememberSkipConfirm(msg.Action)
+			}
+			m.uninstall(m.pending)
+		}
+		m.pending = ""
+		return m, nil
+
 	case tea.KeyMsg:
 		if m.loading {
 			if key.Matches(msg, m.keys.Quit) {
@@ -181,6 +209,12 @@ func (m VersionsScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		if m.confirm != nil {
+			c, cmd := m.confirm.Update(msg)
+			m.confirm = &c
+			return m, cmd
+		}
+
 		switch {
 		case key.Matches(msg, m.keys.Up):
 			if m.cursor > 0 {
@@ -201,26 +235,33 @@ func (m VersionsScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						m.entries[i].IsDefault = m.entries[i].Version == e.Version
 					}
 				} else {
-					// Not installed: install then set as default
-					return m, pushScreen(NewInstallProgressScreen(m.dhHome, e.Version))
+					// Not installed: install in the background, then set as default
+					m.status = fmt.Sprintf("Installing %s in the background (ctrl+t: tasks)", e.Version)
+					return m, installTask(m.dhHome, e.Version, true)
 				}
 			}
 		case key.Matches(msg, m.keys.Install):
 			if len(m.entries) > 0 && !m.entries[m.cursor].Installed {
-				return m, pushScreen(NewInstallProgressScreen(m.dhHome, m.entries[m.cursor].Version))
+				v := m.entries[m.cursor].Version
+				m.status = fmt.Sprintf("Installing %s in the background (ctrl+t: tasks)", v)
+				return m, installTask(m.dhHome, v, false)
 			}
 		case key.Matches(msg, m.keys.Uninstall):
 			if len(m.entries) > 0 && m.entries[m.cursor].Installed {
 				v := m.entries[m.cursor].Version
-				_ = versions.Uninstall(m.dhHome, v)
-				m.entries[m.cursor].Installed = false
-				m.entries[m.cursor].DateStr = ""
-				if m.entries[m.cursor].IsDefault {
-					m.entries[m.cursor].IsDefault = false
-					m.dflt = ""
-					_ = config.Set("default_version", "")
+				m.confirm = askConfirm(m.width, "uninstall", fmt.Sprintf("Uninstall Deephaven %s?", v),
+					"This removes its virtual environment and installed packages.")
+				if m.confirm == nil {
+					m.uninstall(v)
+				} else {
+					m.pending = v
 				}
 			}
+		case key.Matches(msg, m.keys.Notes):
+			if len(m.entries) > 0 {
+				e := m.entries[m.cursor]
+				return m, pushScreen(NewReleaseNotesScreen(m.dhHome, e.Version, e.Installed))
+			}
 		case key.Matches(msg, m.keys.Help):
 			m.help.ShowAll = !m.help.ShowAll
 		case key.Matches(msg, m.keys.Back):
@@ -232,6 +273,46 @@ func (m VersionsScreen) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// uninstall removes version and clears it as the default if needed.
+func (m *VersionsScreen) uninstall(version string) {
+	_ = versions.Uninstall(m.dhHome, version)
+	for i := range m.entries {
+		if m.entries[i].Version != version {
+			continue
+		}
+		m.entries[i].Installed = false
+		m.entries[i].DateStr = ""
+		if m.entries[i].IsDefault {
+			m.entries[i].IsDefault = false
+			m.dflt = ""
+			_ = config.Set("default_version", "")
+		}
+	}
+}
+
+// syncInstalls marks versions whose background install has finished.
+func (m *VersionsScreen) syncInstalls() {
+	changed := false
+	for i, e := range m.entries {
+		if e.Installed {
+			continue
+		}
+		if t, ok := tasks.Default().Find("install:" + e.Version); ok && t.State == tasks.Succeeded {
+			m.entries[i].Installed = true
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if cfg, err := config.Load(); err == nil && cfg.DefaultVersion != m.dflt {
+		m.dflt, _ = versions.Select(cfg.DefaultVersion, versions.InstalledNames(m.dhHome))
+		for i := range m.entries {
+			m.entries[i].IsDefault = m.entries[i].Version == m.dflt
+		}
+	}
+}
+
 func (m VersionsScreen) View() string {
 	var b strings.Builder
 
@@ -258,23 +339,30 @@ func (m VersionsScreen) View() string {
 			}
 		}
 
+		// Narrow terminals keep the ★ marker but drop the "default" column
+		// and the release date.
+		compact := narrow(m.width)
 		for i, e := range m.entries {
 			marker := "  "
 			if e.IsDefault {
 				marker = "★ "
 			}
 			label := fmt.Sprintf("%s%-*s", marker, maxLen, e.Version)
-			if e.IsDefault {
+			switch {
+			case compact:
+			case e.IsDefault:
 				label += "  " + lipgloss.NewStyle().Foreground(colorPrimary).Render("default")
-			} else {
+			default:
 				label += "  " + strings.Repeat(" ", len("default"))
 			}
 			if e.Installed {
 				label += "  " + lipgloss.NewStyle().Foreground(colorSuccess).Render("installed")
+			} else if t, ok := tasks.Default().Find("install:" + e.Version); ok && t.State == tasks.Running {
+				label += "  " + lipgloss.NewStyle().Foreground(colorWarning).Render("installing")
 			} else {
 				label += "  " + strings.Repeat(" ", len("installed"))
 			}
-			if e.DateStr != "" {
+			if e.DateStr != "" && !compact {
 				label += "  " + lipgloss.NewStyle().Foreground(colorDim).Render(e.DateStr)
 			}
 
@@ -287,7 +375,15 @@ func (m VersionsScreen) View() string {
 		}
 	}
 
+	if m.status != "" {
+		b.WriteString("\n  " + m.status + "\n")
+	}
+
 	b.WriteString("\n")
+	if m.confirm != nil {
+		b.WriteString(m.confirm.View())
+		return b.String()
+	}
 	b.WriteString(m.help.View(m.keys))
 
 	return b.String()
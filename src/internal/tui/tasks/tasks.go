@@ -0,0 +1,284 @@
+// Package tasks runs long TUI operations (installs, VM snapshot prepares,
+// pool starts) in the background so screens stay responsive.
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxLogLines caps the output kept per task.
+const maxLogLines = 500
+
+// State is the lifecycle state of a task.
+type State string
+
+const (
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+	Canceled  State = "canceled"
+)
+
+// Func is the body of a task. It should return promptly once ctx is
+// cancelled and report progress through log.
+type Func func(ctx context.Context, log func(string)) error
+
+// UpdatedMsg is delivered to the TUI whenever a task starts, logs output
+// or finishes.
+type UpdatedMsg struct{}
+
+// Info is a snapshot of a task's state.
+type Info struct {
+	ID       int
+	Key      string // identifies the operation, e.g. "install:0.36.0"
+	Title    string
+	State    State
+	Started  time.Time
+	Finished time.Time
+	Err      error
+	Log      []string
+}
+
+// LastLine returns the most recent log line, or "".
+func (i Info) LastLine() string {
+	if len(i.Log) == 0 {
+		return ""
+	}
+	return i.Log[len(i.Log)-1]
+}
+
+// Elapsed returns how long the task ran, or has been running.
+func (i Info) Elapsed() time.Duration {
+	if i.Finished.IsZero() {
+		return time.Since(i.Started)
+	}
+	return i.Finished.Sub(i.Started)
+}
+
+type task struct {
+	Info
+	cancel context.CancelFunc
+}
+
+// Manager tracks background tasks. It is safe for concurrent use.
+type Manager struct {
+	mu      sync.Mutex
+	tasks   []*task
+	nextID  int
+	updates chan struct{}
+}
+
+// NewManager returns an empty manager.
+func NewManager() *Manager {
+	return &Manager{updates: make(chan struct{}, 1)}
+}
+
+var defaultManager = NewManager()
+
+// Default returns the manager shared by the TUI screens.
+func Default() *Manager {
+	return defaultManager
+}
+
+// Start runs fn in the background and returns the task ID. If a task with
+// the same key is already running, its ID is returned and fn is not run.
+func (m *Manager) Start(key, title string, fn Func) int {
+	m.mu.Lock()
+	for _, t := range m.tasks {
+		if key != "" && t.Key == key && t.State == Running {
+			m.mu.Unlock()
+			return t.ID
+		}
+	}
+	m.nextID++
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &task{
+		Info:   Info{ID: m.nextID, Key: key, Title: title, State: Running, Started: time.Now()},
+		cancel: cancel,
+	}
+	m.tasks = append(m.tasks, t)
+	m.mu.Unlock()
+	m.notify()
+
+	go func() {
+		err := fn(ctx, func(line string) { m.appendLog(t, line) })
+		m.mu.Lock()
+		t.Finished = time.Now()
+		switch {
+		case ctx.Err() != nil:
+			t.State = Canceled
+		case err != nil:
+			t.State = Failed
+			t.Err = err
+		default:
+			t.State = Succeeded
+		}
+		m.mu.Unlock()
+		cancel()
+		m.notify()
+	}()
+	return t.ID
+}
+
+// Command returns a Func that runs an external command, logging its
+// stdout and stderr line by line. Cancelling the task kills the process.
+func Command(name string, args ...string) Func {
+	return func(ctx context.Context, log func(string)) error {
+		cmd := exec.CommandContext(ctx, name, args...)
+		w := NewLogWriter(log)
+		cmd.Stdout = w
+		cmd.Stderr = w
+		err := cmd.Run()
+		w.Flush()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return errors.New(exitErr.String())
+		}
+		return err
+	}
+}
+
+// Cancel stops a running task. It reports whether the task was running.
+func (m *Manager) Cancel(id int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tasks {
+		if t.ID == id && t.State == Running {
+			t.cancel()
+			return true
+		}
+	}
+	return false
+}
+
+// List returns all tasks, oldest first.
+func (m *Manager) List() []Info {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	infos := make([]Info, len(m.tasks))
+	for i, t := range m.tasks {
+		infos[i] = t.snapshot()
+	}
+	return infos
+}
+
+// Find returns the most recent task with key.
+func (m *Manager) Find(key string) (Info, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.tasks) - 1; i >= 0; i-- {
+		if m.tasks[i].Key == key {
+			return m.tasks[i].snapshot(), true
+		}
+	}
+	return Info{}, false
+}
+
+// Running returns the number of running tasks.
+func (m *Manager) Running() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, t := range m.tasks {
+		if t.State == Running {
+			n++
+		}
+	}
+	return n
+}
+
+// ClearFinished forgets every task that is no longer running.
+func (m *Manager) ClearFinished() {
+	m.mu.Lock()
+	kept := m.tasks[:0]
+	for _, t := range m.tasks {
+		if t.State == Running {
+			kept = append(kept, t)
+		}
+	}
+	m.tasks = kept
+	m.mu.Unlock()
+	m.notify()
+}
+
+// WaitForUpdate returns a command that waits for the next task change and
+// delivers UpdatedMsg. The app re-issues it after each update.
+func (m *Manager) WaitForUpdate() tea.Cmd {
+	return func() tea.Msg {
+		<-m.updates
+		return UpdatedMsg{}
+	}
+}
+
+func (m *Manager) notify() {
+	select {
+	case m.updates <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Manager) appendLog(t *task, line string) {
+	m.mu.Lock()
+	t.Log = append(t.Log, line)
+	if len(t.Log) > maxLogLines {
+		t.Log = t.Log[len(t.Log)-maxLogLines:]
+	}
+	m.mu.Unlock()
+	m.notify()
+}
+
+func (t *task) snapshot() Info {
+	info := t.Info
+	info.Log = append([]string(nil), t.Log...)
+	return info
+}
+
+// LogWriter is an io.Writer that splits output into lines for a task log.
+// Carriage returns (used by progress bars) also end a line.
+type LogWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	log func(string)
+}
+
+// NewLogWriter returns a writer that sends each line to log.
+func NewLogWriter(log func(string)) *LogWriter {
+	return &LogWriter{log: log}
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		data := w.buf.Bytes()
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(data[:i]), " ")
+		w.buf.Next(i + 1)
+		if line != "" {
+			w.log(line)
+		}
+	}
+	return len(p), nil
+}
+
+// Flush logs any trailing partial line.
+func (w *LogWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if line := strings.TrimSpace(w.buf.String()); line != "" {
+		w.log(line)
+	}
+	w.buf.Reset()
+}
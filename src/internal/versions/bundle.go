@@ -0,0 +1,78 @@
+package versions
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// BundleDir is where `dh bundle install` unpacks the offline bundle for a
+// version.
+func BundleDir(dhHome, version string) string {
+	return filepath.Join(dhHome, "bundles", version)
+}
+
+// BundledWheels returns the wheel directory of the installed bundle for
+// version, or "" if there is none. Installs use it instead of PyPI.
+func BundledWheels(dhHome, version string) string {
+	dir := filepath.Join(BundleDir(dhHome, version), "wheels")
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return dir
+	}
+	return ""
+}
+
+// UVCommand returns the uv executable to run: uv from PATH, or else the
+// copy a bundle installed into <dhHome>/bin.
+func UVCommand(dhHome string) string {
+	if _, err := exec.LookPath("uv"); err == nil {
+		return "uv"
+	}
+	bundled := filepath.Join(dhHome, "bin", "uv")
+	if runtime.GOOS == "windows" {
+		bundled += ".exe"
+	}
+	if _, err := os.Stat(bundled); err == nil {
+		return bundled
+	}
+	return "uv"
+}
+
+// DownloadWheels downloads wheels for version, its plugins and all their
+// dependencies into dest, for installing later without network access.
+// pip runs from a scratch venv so the wheels match pythonVer.
+func DownloadWheels(ctx context.Context, dhHome, version, pythonVer string, plugins []string, dest string, stderr io.Writer) error {
+	scratch, err := os.MkdirTemp("", "dh-wheels-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	venvDir := filepath.Join(scratch, ".venv")
+	cmd := ExecCommand(UVCommand(dhHome), "venv", "--seed", venvDir, "--python", pythonVer)
+	cmd.Stderr = stderr
+	if err := runContext(ctx, cmd); err != nil {
+		return fmt.Errorf("creating venv: %w", err)
+	}
+
+	pythonBin := filepath.Join(venvDir, "bin", "python")
+	if runtime.GOOS == "windows" {
+		pythonBin = filepath.Join(venvDir, "Scripts", "python.exe")
+	}
+	args := []string{"-m", "pip", "download", "--dest", dest,
+		fmt.Sprintf("deephaven-server==%s", version),
+		fmt.Sprintf("pydeephaven==%s", version),
+	}
+	args = append(args, plugins...)
+	cmd = ExecCommand(pythonBin, args...)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	if err := runContext(ctx, cmd); err != nil {
+		return fmt.Errorf("downloading wheels: %w", err)
+	}
+	return nil
+}
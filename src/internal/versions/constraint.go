@@ -0,0 +1,229 @@
+package versions
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed Deephaven release number. Releases up to 0.x use
+// three components (0.36.1); from 41 onwards they use two (41.1), which
+// compare as if the patch were 0.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses "MAJOR.MINOR" or "MAJOR.MINOR.PATCH".
+func ParseVersion(s string) (Version, error) {
+	if !semverRegexp.MatchString(s) {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	p := parseSemverParts(s)
+	return Version{Major: p[0], Minor: p[1], Patch: p[2]}, nil
+}
+
+// Compare returns -1, 0 or +1 as v is older than, equal to or newer than o.
+func (v Version) Compare(o Version) int {
+	if c := cmp.Compare(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	return cmp.Compare(v.Patch, o.Patch)
+}
+
+// clause is a single comparison against a version.
+type clause struct {
+	op string // one of ==, !=, >=, >, <=, <
+	v  Version
+}
+
+func (c clause) matches(v Version) bool {
+	d := v.Compare(c.v)
+	switch c.op {
+	case "==":
+		return d == 0
+	case "!=":
+		return d != 0
+	case ">=":
+		return d >= 0
+	case ">":
+		return d > 0
+	case "<=":
+		return d <= 0
+	default:
+		return d < 0
+	}
+}
+
+// Constraint is a comma-separated list of version requirements that must
+// all hold, such as ">=0.39,<41" or "~41.0". Supported forms:
+//
+//	0.36.1, =0.36.1, ==0.36.1   exactly that version
+//	41.*, 0.39.*                any release with that prefix
+//	>=, >, <=, <, !=            comparisons
+//	~41.0, ~0.39.2              same minor: >=41.0,<41.1
+//	^0.39, ^41.1                same major (same minor for 0.x)
+//	~=41.1, ~=0.39.2            compatible release, as in pip
+type Constraint struct {
+	raw     string
+	clauses []clause
+}
+
+// IsConstraint reports whether s is a version constraint rather than a
+// plain version number.
+func IsConstraint(s string) bool {
+	s = strings.TrimSpace(s)
+	return s != "" && !semverRegexp.MatchString(s)
+}
+
+// ParseConstraint parses a constraint. A plain version is a valid
+// constraint matching only itself.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: strings.TrimSpace(s)}
+	if c.raw == "" {
+		return c, fmt.Errorf("empty version constraint")
+	}
+	for _, part := range strings.Split(c.raw, ",") {
+		clauses, err := parseClause(strings.TrimSpace(part))
+		if err != nil {
+			return c, fmt.Errorf("invalid version constraint %q: %w", c.raw, err)
+		}
+		c.clauses = append(c.clauses, clauses...)
+	}
+	return c, nil
+}
+
+// String returns the constraint as written.
+func (c Constraint) String() string {
+	return c.raw
+}
+
+// Matches reports whether version satisfies every requirement.
+func (c Constraint) Matches(version string) bool {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false
+	}
+	for _, cl := range c.clauses {
+		if !cl.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Newest returns the newest of candidates that satisfies c.
+func (c Constraint) Newest(candidates []string) (string, bool) {
+	sorted := append([]string(nil), candidates...)
+	SortVersionsDesc(sorted)
+	for _, v := range sorted {
+		if c.Matches(v) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Select returns the version that spec picks from candidates: spec itself
+// if it is a plain version in candidates, or the newest match if it is a
+// constraint.
+func Select(spec string, candidates []string) (string, bool) {
+	if !IsConstraint(spec) {
+		return spec, slices.Contains(candidates, spec)
+	}
+	c, err := ParseConstraint(spec)
+	if err != nil {
+		return "", false
+	}
+	return c.Newest(candidates)
+}
+
+// operators is ordered so that two-character operators are tried first.
+var operators = []string{"~=", ">=", "<=", "==", "!=", ">", "<", "=", "~", "^"}
+
+func parseClause(s string) ([]clause, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty requirement")
+	}
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(s, o) {
+			op = o
+			s = strings.TrimSpace(s[len(o):])
+			break
+		}
+	}
+
+	wildcard := strings.HasSuffix(s, ".*")
+	s = strings.TrimSuffix(s, ".*")
+	v, n, err := parsePartial(s)
+	if err != nil {
+		return nil, err
+	}
+	if wildcard && op != "" && op != "=" && op != "==" {
+		return nil, fmt.Errorf("wildcard %q can only be used on its own or with ==", s+".*")
+	}
+
+	switch op {
+	case "", "=", "==":
+		if wildcard || n == 1 {
+			return prefixRange(v, n), nil
+		}
+		return []clause{{"==", v}}, nil
+	case "~":
+		if n == 1 {
+			return prefixRange(v, 1), nil
+		}
+		return []clause{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	case "^":
+		if v.Major == 0 && n > 1 {
+			return []clause{{">=", v}, {"<", Version{Minor: v.Minor + 1}}}, nil
+		}
+		return []clause{{">=", v}, {"<", Version{Major: v.Major + 1}}}, nil
+	case "~=":
+		switch n {
+		case 1:
+			return nil, fmt.Errorf("~= needs at least two components, e.g. ~=41.1")
+		case 2:
+			return []clause{{">=", v}, {"<", Version{Major: v.Major + 1}}}, nil
+		default:
+			return []clause{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+		}
+	default:
+		return []clause{{op, v}}, nil
+	}
+}
+
+// prefixRange matches every version whose first n components equal v's.
+func prefixRange(v Version, n int) []clause {
+	upper := Version{Major: v.Major + 1}
+	switch n {
+	case 2:
+		upper = Version{Major: v.Major, Minor: v.Minor + 1}
+	case 3:
+		upper = Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+	return []clause{{">=", v}, {"<", upper}}
+}
+
+// parsePartial parses a version with one to three components and returns
+// how many were given.
+func parsePartial(s string) (Version, int, error) {
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || strings.HasPrefix(p, "+") {
+			return Version{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, len(parts), nil
+}
@@ -1,19 +1,32 @@
 package versions
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
 )
 
 // ExecCommand is a wrapper around exec.Command for testability.
 var ExecCommand = exec.Command
 
 // Install installs a Deephaven version into <dhHome>/versions/<version>/.
+// If an offline bundle for the version is installed, packages come from its
+// wheels instead of PyPI.
 func Install(dhHome, version, pythonVer string, plugins []string, onProgress func(string)) error {
+	return InstallContext(context.Background(), dhHome, version, pythonVer, plugins, onProgress, os.Stderr)
+}
+
+// InstallContext is Install with cancellation and a writer for the output of
+// uv. Cancelling ctx kills the running uv process and removes the partial
+// install.
+func InstallContext(ctx context.Context, dhHome, version, pythonVer string, plugins []string, onProgress func(string), stderr io.Writer) error {
 	versionDir := filepath.Join(dhHome, "versions", version)
 
 	// Check if already installed
@@ -37,10 +50,16 @@ func Install(dhHome, version, pythonVer string, plugins []string, onProgress fun
 	if onProgress != nil {
 		onProgress(fmt.Sprintf("Creating Python %s virtual environment...", pythonVer))
 	}
+	uv := UVCommand(dhHome)
+	wheels := BundledWheels(dhHome, version)
 	venvDir := filepath.Join(versionDir, ".venv")
-	cmd := ExecCommand("uv", "venv", venvDir, "--python", pythonVer)
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	venvArgs := []string{"venv", venvDir, "--python", pythonVer}
+	if wheels != "" {
+		venvArgs = append(venvArgs, "--offline")
+	}
+	cmd := ExecCommand(uv, venvArgs...)
+	cmd.Stderr = stderr
+	if err := runContext(ctx, cmd); err != nil {
 		return fmt.Errorf("creating venv: %w", err)
 	}
 
@@ -54,16 +73,23 @@ func Install(dhHome, version, pythonVer string, plugins []string, onProgress fun
 		pythonBin = filepath.Join(venvDir, "Scripts", "python.exe")
 	}
 
-	pipArgs := []string{"pip", "install", "--python", pythonBin,
+	pipArgs := []string{"pip", "install", "--python", pythonBin}
+	if wheels != "" {
+		if onProgress != nil {
+			onProgress(fmt.Sprintf("Using offline bundle wheels from %s", wheels))
+		}
+		pipArgs = append(pipArgs, "--offline", "--no-index", "--find-links", wheels)
+	}
+	pipArgs = append(pipArgs,
 		fmt.Sprintf("deephaven-server==%s", version),
 		fmt.Sprintf("pydeephaven==%s", version),
-	}
+	)
 	for _, p := range plugins {
 		pipArgs = append(pipArgs, p)
 	}
-	cmd = ExecCommand("uv", pipArgs...)
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	cmd = ExecCommand(uv, pipArgs...)
+	cmd.Stderr = stderr
+	if err := runContext(ctx, cmd); err != nil {
 		return fmt.Errorf("installing packages: %w", err)
 	}
 
@@ -85,3 +111,28 @@ func Install(dhHome, version, pythonVer string, plugins []string, onProgress fun
 	}
 	return nil
 }
+
+// runContext runs cmd, killing it if ctx is cancelled first.
+func runContext(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	output.TraceCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -13,6 +13,16 @@ type InstalledVersion struct {
 	InstalledAt time.Time `json:"installed_at"`
 }
 
+// InstalledNames returns the installed version strings, newest first.
+func InstalledNames(dhHome string) []string {
+	installed, _ := ListInstalled(dhHome)
+	names := make([]string, len(installed))
+	for i, v := range installed {
+		names[i] = v.Version
+	}
+	return names
+}
+
 // ListInstalled scans <dhHome>/versions/ and returns installed versions sorted descending.
 func ListInstalled(dhHome string) ([]InstalledVersion, error) {
 	versionsDir := filepath.Join(dhHome, "versions")
@@ -146,6 +146,24 @@ func FetchLatestVersion() (string, error) {
 	return versions[0], nil
 }
 
+// FetchMatchingVersion returns the newest release on PyPI that satisfies
+// constraint (see ParseConstraint).
+func FetchMatchingVersion(constraint string) (string, error) {
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	versions, err := FetchRemoteVersions(0)
+	if err != nil {
+		return "", err
+	}
+	v, ok := c.Newest(versions)
+	if !ok {
+		return "", fmt.Errorf("no release on PyPI matches %q", constraint)
+	}
+	return v, nil
+}
+
 // SortVersionsDesc sorts version strings in descending semver order.
 func SortVersionsDesc(versions []string) {
 	sort.Slice(versions, func(i, j int) bool {
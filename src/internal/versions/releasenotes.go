@@ -0,0 +1,102 @@
+package versions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReleaseNotesURL is the GitHub API URL for a release, formatted with the
+// version. Exported for test overrides.
+var ReleaseNotesURL = "https://api.github.com/repos/deephaven/deephaven-core/releases/tags/v%s"
+
+// ReleaseNotes is the changelog for one Deephaven release.
+type ReleaseNotes struct {
+	Version string `json:"version"`
+	Title   string `json:"title"`
+	Date    string `json:"date"` // "YYYY-MM-DD" or empty
+	URL     string `json:"url"`
+	Body    string `json:"body"` // Markdown
+	Cached  bool   `json:"-"`    // served from the offline cache
+}
+
+type githubRelease struct {
+	Name        string `json:"name"`
+	Body        string `json:"body"`
+	HTMLURL     string `json:"html_url"`
+	PublishedAt string `json:"published_at"`
+}
+
+// ReleaseNotesCachePath returns where the notes for version are cached.
+func ReleaseNotesCachePath(dhHome, version string) string {
+	return filepath.Join(dhHome, "cache", "release-notes", version+".json")
+}
+
+// FetchReleaseNotes returns the release notes for version. Published notes
+// do not change, so a cached copy is returned without a network request;
+// otherwise the notes are fetched from GitHub and cached for offline use.
+func FetchReleaseNotes(dhHome, version string) (*ReleaseNotes, error) {
+	cachePath := ReleaseNotesCachePath(dhHome, version)
+	if data, err := os.ReadFile(cachePath); err == nil {
+		var notes ReleaseNotes
+		if json.Unmarshal(data, &notes) == nil {
+			notes.Cached = true
+			return &notes, nil
+		}
+	}
+
+	resp, err := HTTPClient.Get(fmt.Sprintf(ReleaseNotesURL, version))
+	if err != nil {
+		return nil, fmt.Errorf("fetching release notes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("no release notes published for %s", version)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading release notes: %w", err)
+	}
+	notes, err := ParseReleaseNotes(body, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Caching is best effort; the notes are still shown if it fails.
+	if data, err := json.MarshalIndent(notes, "", "  "); err == nil {
+		if os.MkdirAll(filepath.Dir(cachePath), 0o755) == nil {
+			_ = os.WriteFile(cachePath, data, 0o644)
+		}
+	}
+	return notes, nil
+}
+
+// ParseReleaseNotes parses a GitHub release API response.
+func ParseReleaseNotes(data []byte, version string) (*ReleaseNotes, error) {
+	var rel githubRelease
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return nil, fmt.Errorf("parsing release notes: %w", err)
+	}
+	notes := &ReleaseNotes{
+		Version: version,
+		Title:   rel.Name,
+		URL:     rel.HTMLURL,
+		Body:    strings.ReplaceAll(rel.Body, "\r\n", "\n"),
+	}
+	if notes.Title == "" {
+		notes.Title = "v" + version
+	}
+	if len(rel.PublishedAt) >= 10 {
+		notes.Date = rel.PublishedAt[:10]
+	}
+	return notes, nil
+}
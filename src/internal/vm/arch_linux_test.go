@@ -0,0 +1,196 @@
+//go:build linux
+
+package vm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUffdIoctlNumbers(t *testing.T) {
+	// Values from linux/userfaultfd.h, the same on amd64 and arm64.
+	if _UFFDIO_COPY != 0xc028aa03 {
+		t.Errorf("_UFFDIO_COPY = %#x, want 0xc028aa03", _UFFDIO_COPY)
+	}
+	if _UFFDIO_ZEROPAGE != 0xc020aa04 {
+		t.Errorf("_UFFDIO_ZEROPAGE = %#x, want 0xc020aa04", _UFFDIO_ZEROPAGE)
+	}
+	if hostPageSize < 4096 || hostPageSize&(hostPageSize-1) != 0 {
+		t.Errorf("hostPageSize = %d, want a power of two >= 4096", hostPageSize)
+	}
+}
+
+func TestFirecrackerArch(t *testing.T) {
+	for goarch, want := range map[string]string{"amd64": "x86_64", "arm64": "aarch64"} {
+		got, err := firecrackerArch(goarch)
+		if err != nil || got != want {
+			t.Errorf("firecrackerArch(%q) = %q, %v; want %q", goarch, got, err, want)
+		}
+	}
+	if _, err := firecrackerArch("riscv64"); err == nil {
+		t.Error("firecrackerArch(riscv64) should fail")
+	}
+}
+
+func TestKernelArgs(t *testing.T) {
+	if args := kernelArgs("amd64"); strings.Contains(args, "keep_bootcon") || !strings.Contains(args, "init=/sbin/init.sh") {
+		t.Errorf("kernelArgs(amd64) = %q", args)
+	}
+	if args := kernelArgs("arm64"); !strings.HasPrefix(args, "keep_bootcon console=ttyS0") {
+		t.Errorf("kernelArgs(arm64) = %q", args)
+	}
+}
+
+func TestFindContainerEngine(t *testing.T) {
+	dir := t.TempDir()
+	fake := func(name, script string) {
+		os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755)
+	}
+	t.Setenv("PATH", dir)
+	t.Setenv("DH_VM_CONTAINER_ENGINE", "")
+
+	if _, err := findContainerEngine(); err == nil {
+		t.Error("expected an error with no engine installed")
+	}
+
+	// A docker CLI whose daemon is down is still used when nothing else is.
+	fake("docker", "exit 1")
+	if got, err := findContainerEngine(); err != nil || filepath.Base(got) != "docker" {
+		t.Errorf("findContainerEngine() = %q, %v; want docker", got, err)
+	}
+
+	// but podman wins when it works.
+	fake("podman", "exit 0")
+	if got, err := findContainerEngine(); err != nil || filepath.Base(got) != "podman" {
+		t.Errorf("findContainerEngine() = %q, %v; want podman", got, err)
+	}
+
+	t.Setenv("DH_VM_CONTAINER_ENGINE", "docker")
+	if got, err := findContainerEngine(); err != nil || filepath.Base(got) != "docker" {
+		t.Errorf("DH_VM_CONTAINER_ENGINE=docker: got %q, %v", got, err)
+	}
+	t.Setenv("DH_VM_CONTAINER_ENGINE", "none")
+	if _, err := findContainerEngine(); err == nil {
+		t.Error("DH_VM_CONTAINER_ENGINE=none should select no engine")
+	}
+	t.Setenv("DH_VM_CONTAINER_ENGINE", "nerdctl")
+	if _, err := findContainerEngine(); err == nil || !strings.Contains(err.Error(), "DH_VM_CONTAINER_ENGINE") {
+		t.Errorf("expected an error for a missing engine, got %v", err)
+	}
+}
+
+func TestDownloadKernel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("kernel"))
+	}))
+	defer srv.Close()
+	sum := "6923dd1bc0460082c5d55a831908c24a282860b7f1cd6c2b79cf1bc8857c639c"
+	dest := filepath.Join(t.TempDir(), "vmlinux")
+
+	bad := PinnedKernel{Name: "vmlinux-6.1.1", URL: srv.URL, SHA256: strings.Repeat("0", 64)}
+	if err := downloadKernel(bad, dest); err == nil || !strings.Contains(err.Error(), "not installing it") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Error("a kernel that doesn't match its checksum must not be installed")
+	}
+
+	good := PinnedKernel{Name: "vmlinux-6.1.1", URL: srv.URL, SHA256: sum}
+	if err := downloadKernel(good, dest); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "kernel" {
+		t.Errorf("installed kernel = %q", data)
+	}
+}
+
+func TestEnsureKernelUnpinned(t *testing.T) {
+	arch, err := firecrackerArch(runtime.GOARCH)
+	if err != nil {
+		t.Skip(err)
+	}
+	if kernels, _ := PinnedKernels(); kernels[arch].URL != "" {
+		t.Skipf("a kernel is pinned for %s", arch)
+	}
+	t.Setenv("DH_VM_UNVERIFIED_KERNEL", "")
+	paths := NewVMPaths(t.TempDir())
+
+	var out strings.Builder
+	if err := EnsureKernel(paths, &out); err == nil || !strings.Contains(err.Error(), "DH_VM_UNVERIFIED_KERNEL=1") {
+		t.Fatalf("expected an unpinned kernel to be refused, got %v", err)
+	}
+	if _, err := os.Stat(paths.Kernel); !os.IsNotExist(err) {
+		t.Error("no kernel should be installed")
+	}
+}
+
+func TestInstallKernel(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	src := filepath.Join(t.TempDir(), "vmlinux")
+	os.WriteFile(src, []byte("custom kernel"), 0o644)
+
+	var out strings.Builder
+	if err := InstallKernel(paths, src, &out); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(paths.Kernel); string(data) != "custom kernel" {
+		t.Errorf("installed kernel = %q", data)
+	}
+	out.Reset()
+	if err := InstallKernel(paths, src, &out); err != nil || out.Len() != 0 {
+		t.Errorf("installing the same kernel again should do nothing, got %q (err %v)", out.String(), err)
+	}
+	if err := InstallKernel(paths, filepath.Join(t.TempDir(), "missing"), &out); err == nil {
+		t.Error("expected an error for a missing kernel")
+	}
+}
+
+func TestUffdStats(t *testing.T) {
+	h := &uffdHandler{}
+	h.eagerBytes.Add(4 << 20)
+	h.lazyBytes.Add(lazyChunkSize)
+	read := time.Now()
+	h.recordFault(read)
+	h.recordFault(read)
+
+	st := UffdStatsOf(h)
+	if st == nil {
+		t.Fatal("expected stats from a UFFD handler")
+	}
+	if st.Faults != 2 || st.EagerBytes != 4<<20 || st.LazyBytes != lazyChunkSize || st.FaultP99Us <= 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestHotChunkJobs(t *testing.T) {
+	dir := t.TempDir()
+	h := &uffdHandler{memFile: filepath.Join(dir, "snapshot_mem"), populatedChunks: map[uint64]struct{}{}}
+	regions := []regionInfo{
+		{region: memRegion{BaseHostVirtAddr: 0x10000000, Size: 4 << 20, Offset: 0}},
+		{region: memRegion{BaseHostVirtAddr: 0x40000000, Size: 3 << 20, Offset: 4 << 20}},
+	}
+	if jobs := h.hotChunkJobs(3, regions); len(jobs) != 0 {
+		t.Fatalf("expected no jobs without hot chunks, got %v", jobs)
+	}
+
+	// The last chunk of the second region is short; 64 MiB is past the end.
+	addHotChunks(dir, lazyChunkSize, []uint64{2 << 20, 6 << 20, 64 << 20})
+	jobs := h.hotChunkJobs(3, regions)
+	want := []copyJob{
+		{uffdFd: 3, dst: 0x10000000 + 2<<20, off: 2 << 20, length: lazyChunkSize},
+		{uffdFd: 3, dst: 0x40000000 + 2<<20, off: 6 << 20, length: 1 << 20},
+	}
+	if !slices.Equal(jobs, want) {
+		t.Errorf("hotChunkJobs = %+v, want %+v", jobs, want)
+	}
+	if _, ok := h.populatedChunks[0x40000000+2<<20]; !ok {
+		t.Error("hot chunks should be marked populated")
+	}
+}
@@ -4,52 +4,77 @@ package vm
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
+	"time"
 )
 
-// CleanupStaleInstances scans the run directory for orphaned instances
-// and removes them (kill process, remove files).
-func CleanupStaleInstances(paths *VMPaths) {
-	runDir := paths.RunDir
-	entries, err := os.ReadDir(runDir)
+// instanceStartupGrace is how long an instance directory may go without
+// instance.json before it counts as stale. The file is written only once
+// the VM is up, and snapshot creation never writes it.
+const instanceStartupGrace = 10 * time.Minute
+
+// StaleInstance is an instance directory whose VM is gone.
+type StaleInstance struct {
+	Dir      string
+	Reason   string
+	NetIndex int // the VM network left behind, if any
+}
+
+// StaleInstances returns the instance directories in the run directory
+// whose Firecracker process has exited.
+func StaleInstances(paths *VMPaths) []StaleInstance {
+	entries, err := os.ReadDir(paths.RunDir)
 	if err != nil {
-		return
+		return nil
 	}
 
+	var stale []StaleInstance
 	for _, e := range entries {
 		if !e.IsDir() {
 			continue
 		}
 
-		instanceDir := filepath.Join(runDir, e.Name())
-		infoPath := filepath.Join(instanceDir, "instance.json")
-
-		data, err := os.ReadFile(infoPath)
+		instanceDir := filepath.Join(paths.RunDir, e.Name())
+		data, err := os.ReadFile(filepath.Join(instanceDir, "instance.json"))
+		var info InstanceInfo
+		if err == nil {
+			err = json.Unmarshal(data, &info)
+		}
 		if err != nil {
-			os.RemoveAll(instanceDir)
+			if fi, statErr := e.Info(); statErr == nil && time.Since(fi.ModTime()) < instanceStartupGrace {
+				continue // still starting up
+			}
+			stale = append(stale, StaleInstance{Dir: instanceDir, Reason: "no instance.json"})
 			continue
 		}
 
-		var info InstanceInfo
-		if err := json.Unmarshal(data, &info); err != nil {
-			os.RemoveAll(instanceDir)
+		// Signal 0 checks the process exists
+		if info.PID > 0 && syscall.Kill(info.PID, 0) != syscall.ESRCH {
 			continue
 		}
+		stale = append(stale, StaleInstance{Dir: instanceDir, Reason: fmt.Sprintf("VMM pid %d is not running", info.PID), NetIndex: info.NetIndex})
+	}
+	return stale
+}
 
-		// Check if process is still alive (signal 0 = existence check)
-		if info.PID > 0 {
-			process, err := os.FindProcess(info.PID)
-			if err == nil {
-				if err := process.Signal(syscall.Signal(0)); err == nil {
-					// Process is alive — skip
-					continue
-				}
-			}
-		}
-
-		// Process is dead — cleanup
-		os.RemoveAll(instanceDir)
+// CleanupStaleInstances scans the run directory for orphaned instances
+// and removes them, along with their jails and networks. Their console
+// logs are kept.
+func CleanupStaleInstances(paths *VMPaths) {
+	for _, s := range StaleInstances(paths) {
+		RemoveStaleInstance(paths, s)
 	}
 }
+
+// RemoveStaleInstance tears down what a VM that is gone left behind: its
+// jail, its network and its instance directory, whose console log is
+// kept. The directory goes last, as its instance.json is what says which
+// network the VM had.
+func RemoveStaleInstance(paths *VMPaths, s StaleInstance) error {
+	removeJail(paths, filepath.Base(s.Dir))
+	removeVMNet(s.NetIndex)
+	return RemoveInstanceDir(s.Dir)
+}
@@ -2,4 +2,14 @@
 
 package vm
 
+// StaleInstance is an instance directory whose VM is gone.
+type StaleInstance struct {
+	Dir    string
+	Reason string
+}
+
+func StaleInstances(_ *VMPaths) []StaleInstance { return nil }
+
 func CleanupStaleInstances(_ *VMPaths) {}
+
+func RemoveStaleInstance(_ *VMPaths, s StaleInstance) error { return RemoveInstanceDir(s.Dir) }
@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultPlugins are the Deephaven plugins installed into the VM rootfs.
+var DefaultPlugins = []string{"deephaven-plugin-ui", "deephaven-plugin-plotly-express"}
+
+// DefaultBaseImage is the FROM image of ServerDockerfile.
+const DefaultBaseImage = "ubuntu:22.04"
+
+// ServerDockerfile returns the start of a Dockerfile that installs Java,
+// Python and Deephaven version with pip on Ubuntu, plus packages. It is the
+// base of the VM rootfs and of dh docker build images.
+func ServerDockerfile(version string, packages []string) string {
+	return ServerDockerfileWith(RootfsOptions{}, version, packages)
+}
+
+// ServerDockerfileWith is ServerDockerfile on opts.BaseImage, a Debian or
+// Ubuntu image with OpenJDK 17 packages, and with opts.Python. A chosen
+// Python is installed from the image's packages and gets a virtualenv in
+// /opt/python, first on PATH, for the pip packages.
+func ServerDockerfileWith(opts RootfsOptions, version string, packages []string) string {
+	base := opts.BaseImage
+	if base == "" {
+		base = DefaultBaseImage
+	}
+	python := "python3 python3-pip python3-venv python3-dev"
+	if opts.Python != "" {
+		py := "python" + opts.Python
+		python = py + " " + py + "-venv " + py + "-dev"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n\nENV DEBIAN_FRONTEND=noninteractive\n", base)
+	if base != DefaultBaseImage || opts.Python != "" {
+		// Newer releases mark the system Python as externally managed.
+		b.WriteString("ENV PIP_BREAK_SYSTEM_PACKAGES=1\n")
+	}
+	fmt.Fprintf(&b, `
+RUN apt-get update && apt-get install -y --no-install-recommends \
+    %s \
+    openjdk-17-jre-headless \
+    iproute2 \
+    && rm -rf /var/lib/apt/lists/*
+`, python)
+	if opts.Python != "" {
+		fmt.Fprintf(&b, "\nRUN python%s -m venv /opt/python\nENV PATH=/opt/python/bin:$PATH\n", opts.Python)
+	}
+	b.WriteString(`
+RUN python3 -m pip install --no-cache-dir --upgrade setuptools wheel
+RUN python3 -m pip install --no-cache-dir \
+`)
+	fmt.Fprintf(&b, "    deephaven-server==%s \\\n    pydeephaven==%s", version, version)
+	for _, p := range packages {
+		fmt.Fprintf(&b, " \\\n    %s", p)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// DockerfileScript turns a Dockerfile from ServerDockerfile and the VM
+// rootfs into a shell script doing the same inside a root filesystem
+// already at its FROM image, for builds without a container engine. COPY
+// sources are read from dir. Only FROM, ENV, COPY and RUN are supported.
+func DockerfileScript(dockerfile, dir string) (string, error) {
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	var inst string
+	for _, line := range strings.Split(dockerfile, "\n") {
+		inst += line
+		if strings.HasSuffix(line, "\\") {
+			inst += "\n"
+			continue
+		}
+		cmd, args, _ := strings.Cut(strings.TrimSpace(inst), " ")
+		inst = ""
+		args = strings.TrimSpace(args)
+		switch cmd {
+		case "", "FROM":
+		case "ENV":
+			name, value, ok := strings.Cut(args, "=")
+			if !ok {
+				name, value, _ = strings.Cut(args, " ")
+			}
+			fmt.Fprintf(&b, "export %s=%s\n", name, strings.TrimSpace(value))
+		case "COPY":
+			src, dst, ok := strings.Cut(args, " ")
+			if !ok || strings.ContainsAny(src, "*?[") || strings.HasPrefix(src, "--") {
+				return "", fmt.Errorf("unsupported COPY %s", args)
+			}
+			fmt.Fprintf(&b, "cp %s/%s %s\n", dir, src, strings.TrimSpace(dst))
+		case "RUN":
+			b.WriteString(args + "\n")
+		default:
+			if strings.HasPrefix(cmd, "#") {
+				continue
+			}
+			return "", fmt.Errorf("unsupported Dockerfile instruction %s", cmd)
+		}
+	}
+	return b.String(), nil
+}
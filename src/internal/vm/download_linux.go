@@ -5,15 +5,31 @@ package vm
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 )
 
+// firecrackerArch returns the name Firecracker releases and CI kernels use
+// for a Go architecture. Firecracker only runs on x86_64 and aarch64.
+func firecrackerArch(goarch string) (string, error) {
+	switch goarch {
+	case "amd64":
+		return "x86_64", nil
+	case "arm64":
+		return "aarch64", nil
+	}
+	return "", fmt.Errorf("Firecracker does not support %s; VM mode needs an x86_64 or aarch64 host", goarch)
+}
+
 // EnsureFirecracker downloads the Firecracker binary if not present.
 func EnsureFirecracker(paths *VMPaths, stderr io.Writer) error {
 	if _, err := os.Stat(paths.Firecracker); err == nil {
@@ -24,11 +40,37 @@ func EnsureFirecracker(paths *VMPaths, stderr io.Writer) error {
 		return fmt.Errorf("creating vm dir: %w", err)
 	}
 
-	arch := runtime.GOARCH
-	if arch == "amd64" {
-		arch = "x86_64"
-	} else if arch == "arm64" {
-		arch = "aarch64"
+	if src := paths.BundledFile("firecracker"); src != "" {
+		return installBundled(src, paths.Firecracker, stderr)
+	}
+
+	return fetchReleaseBinary("firecracker", paths.Firecracker, stderr)
+}
+
+// EnsureJailer downloads the jailer binary, from the same Firecracker
+// release, if not present. Only jailed VMs need it.
+func EnsureJailer(paths *VMPaths, stderr io.Writer) error {
+	if _, err := os.Stat(paths.Jailer); err == nil {
+		return nil // already exists
+	}
+
+	if err := os.MkdirAll(paths.Base, 0o755); err != nil {
+		return fmt.Errorf("creating vm dir: %w", err)
+	}
+
+	if src := paths.BundledFile("jailer"); src != "" {
+		return installBundled(src, paths.Jailer, stderr)
+	}
+
+	return fetchReleaseBinary("jailer", paths.Jailer, stderr)
+}
+
+// fetchReleaseBinary downloads the Firecracker release tarball for this
+// host and extracts the binary name ("firecracker" or "jailer") to dest.
+func fetchReleaseBinary(name, dest string, stderr io.Writer) error {
+	arch, err := firecrackerArch(runtime.GOARCH)
+	if err != nil {
+		return err
 	}
 
 	url := fmt.Sprintf(
@@ -36,19 +78,20 @@ func EnsureFirecracker(paths *VMPaths, stderr io.Writer) error {
 		FirecrackerVersion, FirecrackerVersion, arch,
 	)
 
-	fmt.Fprintf(stderr, "Downloading Firecracker %s for %s...\n", FirecrackerVersion, arch)
+	title := strings.ToUpper(name[:1]) + name[1:]
+	fmt.Fprintf(stderr, "Downloading %s %s for %s...\n", title, FirecrackerVersion, arch)
 
 	resp, err := http.Get(url)
 	if err != nil {
-		return fmt.Errorf("downloading firecracker: %w", err)
+		return fmt.Errorf("downloading %s: %w", name, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("downloading firecracker: HTTP %d from %s", resp.StatusCode, url)
+		return fmt.Errorf("downloading %s: HTTP %d from %s", name, resp.StatusCode, url)
 	}
 
-	// Extract the firecracker binary from the tarball
+	// Extract the binary from the tarball
 	gz, err := gzip.NewReader(resp.Body)
 	if err != nil {
 		return fmt.Errorf("decompressing firecracker archive: %w", err)
@@ -56,37 +99,42 @@ func EnsureFirecracker(paths *VMPaths, stderr io.Writer) error {
 	defer gz.Close()
 
 	tr := tar.NewReader(gz)
-	binaryName := fmt.Sprintf("release-%s-%s/firecracker-%s-%s", FirecrackerVersion, arch, FirecrackerVersion, arch)
+	binaryName := fmt.Sprintf("release-%s-%s/%s-%s-%s", FirecrackerVersion, arch, name, FirecrackerVersion, arch)
 
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
-			return fmt.Errorf("firecracker binary not found in archive (expected %s)", binaryName)
+			return fmt.Errorf("%s binary not found in archive (expected %s)", name, binaryName)
 		}
 		if err != nil {
 			return fmt.Errorf("reading firecracker archive: %w", err)
 		}
 
-		if strings.HasSuffix(hdr.Name, "firecracker-"+FirecrackerVersion+"-"+arch) {
-			f, err := os.OpenFile(paths.Firecracker, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+		if strings.HasSuffix(hdr.Name, "/"+name+"-"+FirecrackerVersion+"-"+arch) {
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
 			if err != nil {
-				return fmt.Errorf("creating firecracker binary: %w", err)
+				return fmt.Errorf("creating %s binary: %w", name, err)
 			}
 			if _, err := io.Copy(f, tr); err != nil {
 				f.Close()
-				os.Remove(paths.Firecracker)
-				return fmt.Errorf("writing firecracker binary: %w", err)
+				os.Remove(dest)
+				return fmt.Errorf("writing %s binary: %w", name, err)
 			}
 			f.Close()
-			fmt.Fprintf(stderr, "Firecracker installed at %s\n", paths.Firecracker)
+			fmt.Fprintf(stderr, "%s installed at %s\n", title, dest)
 			return nil
 		}
 	}
 }
 
-// EnsureKernel downloads a pre-built vmlinux kernel from Firecracker's CI
-// artifacts bucket if not present. It queries the S3 bucket to find the latest
-// available kernel build.
+// ciKernelBucket is Firecracker's CI artifacts bucket, where the guest
+// kernels come from.
+const ciKernelBucket = "https://s3.amazonaws.com/spec.ccfc.min"
+
+// EnsureKernel installs the guest kernel if not present: from an offline
+// bundle, or else the build pinned for this architecture in kernels.json,
+// which is checked against its SHA-256 before it is installed. Without a
+// pinned build it fails unless DH_VM_UNVERIFIED_KERNEL=1 is set.
 func EnsureKernel(paths *VMPaths, stderr io.Writer) error {
 	if _, err := os.Stat(paths.Kernel); err == nil {
 		return nil // already exists
@@ -96,61 +144,140 @@ func EnsureKernel(paths *VMPaths, stderr io.Writer) error {
 		return fmt.Errorf("creating vm dir: %w", err)
 	}
 
-	arch := runtime.GOARCH
-	if arch == "amd64" {
-		arch = "x86_64"
-	} else if arch == "arm64" {
-		arch = "aarch64"
+	if src := paths.BundledFile("vmlinux"); src != "" {
+		return installBundled(src, paths.Kernel, stderr)
 	}
 
-	// Find the latest CI build that has a kernel for this arch
-	kernelKey, err := findLatestKernel(arch)
+	arch, err := firecrackerArch(runtime.GOARCH)
 	if err != nil {
-		return fmt.Errorf("finding kernel: %w", err)
+		return err
 	}
 
-	url := fmt.Sprintf("https://s3.amazonaws.com/spec.ccfc.min/%s", kernelKey)
-	kernelName := kernelKey[strings.LastIndex(kernelKey, "/")+1:]
+	kernels, err := PinnedKernels()
+	if err != nil {
+		return err
+	}
+	k, ok := kernels[arch]
+	if ok {
+		if err := k.Validate(); err != nil {
+			return err
+		}
+	} else {
+		// A dh built without a pinned kernel for this architecture takes
+		// the newest 6.1 CI kernel only when DH_VM_UNVERIFIED_KERNEL=1
+		// says to, since nothing checks what it downloads.
+		if os.Getenv("DH_VM_UNVERIFIED_KERNEL") != "1" {
+			return fmt.Errorf("no kernel is pinned for %s in this dh; install one with dh vm prepare --kernel PATH, or set DH_VM_UNVERIFIED_KERNEL=1 to download the newest CI kernel without verifying it", arch)
+		}
+		key, err := LatestCIKernel(arch)
+		if err != nil {
+			return fmt.Errorf("finding kernel: %w", err)
+		}
+		k = PinnedKernel{Name: path.Base(key), URL: ciKernelBucket + "/" + key}
+		fmt.Fprintf(stderr, "Warning: no kernel is pinned for %s in this dh; downloading the newest CI kernel without verifying it (DH_VM_UNVERIFIED_KERNEL=1)\n", arch)
+	}
 
-	fmt.Fprintf(stderr, "Downloading kernel %s...\n", kernelName)
+	fmt.Fprintf(stderr, "Downloading kernel %s...\n", k.Name)
+	if err := downloadKernel(k, paths.Kernel); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Kernel installed at %s\n", paths.Kernel)
+	return nil
+}
 
-	resp, err := http.Get(url)
+// downloadKernel downloads k to dest. When k has a SHA-256 the download
+// must match it, or nothing is installed.
+func downloadKernel(k PinnedKernel, dest string) error {
+	resp, err := http.Get(k.URL)
 	if err != nil {
 		return fmt.Errorf("downloading kernel: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("downloading kernel: HTTP %d from %s", resp.StatusCode, url)
+		return fmt.Errorf("downloading kernel: HTTP %d from %s", resp.StatusCode, k.URL)
 	}
 
-	tmpPath := paths.Kernel + ".tmp"
+	tmpPath := dest + ".tmp"
 	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 	if err != nil {
 		return fmt.Errorf("creating kernel file: %w", err)
 	}
 
-	if _, err := io.Copy(f, resp.Body); err != nil {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
 		f.Close()
 		os.Remove(tmpPath)
 		return fmt.Errorf("writing kernel: %w", err)
 	}
 	f.Close()
 
+	if sum := hex.EncodeToString(h.Sum(nil)); k.SHA256 != "" && sum != k.SHA256 {
+		os.Remove(tmpPath)
+		return fmt.Errorf("kernel %s from %s has SHA-256 %s, expected %s; not installing it", k.Name, k.URL, sum, k.SHA256)
+	}
+
+	if err := os.Rename(tmpPath, dest); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("moving kernel into place: %w", err)
+	}
+	return nil
+}
+
+// InstallKernel installs the vmlinux at src as the guest kernel, for
+// dh vm prepare --kernel. Snapshots taken with the kernel it replaces
+// have to be prepared again.
+func InstallKernel(paths *VMPaths, src string, stderr io.Writer) error {
+	sum, err := sha256File(src)
+	if err != nil {
+		return fmt.Errorf("reading kernel: %w", err)
+	}
+	if cur, err := KernelFingerprint(paths); err == nil && cur == sum {
+		return nil
+	}
+	if err := os.MkdirAll(paths.Base, 0o755); err != nil {
+		return fmt.Errorf("creating vm dir: %w", err)
+	}
+
+	tmpPath := paths.Kernel + ".tmp"
+	if err := copyFile(src, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("copying kernel: %w", err)
+	}
 	if err := os.Rename(tmpPath, paths.Kernel); err != nil {
 		os.Remove(tmpPath)
 		return fmt.Errorf("moving kernel into place: %w", err)
 	}
+	fmt.Fprintf(stderr, "Kernel installed at %s from %s (SHA-256 %s)\n", paths.Kernel, src, sum)
+	return nil
+}
 
-	fmt.Fprintf(stderr, "Kernel installed at %s\n", paths.Kernel)
+// installBundled copies a file from an offline bundle into place instead of
+// downloading it.
+func installBundled(src, dst string, stderr io.Writer) error {
+	tmpPath := dst + ".tmp"
+	if err := copyFile(src, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("copying %s from bundle: %w", filepath.Base(dst), err)
+	}
+	if err := os.Chmod(tmpPath, 0o755); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("moving %s into place: %w", filepath.Base(dst), err)
+	}
+	fmt.Fprintf(stderr, "Installed %s from bundle %s\n", filepath.Base(dst), src)
 	return nil
 }
 
-// findLatestKernel queries the Firecracker CI S3 bucket to find the latest
-// vmlinux-6.1.x kernel for the given architecture.
-func findLatestKernel(arch string) (string, error) {
+// LatestCIKernel queries the Firecracker CI S3 bucket for the key of the
+// newest vmlinux-6.1.x kernel for the given architecture. go generate
+// uses it to pin a kernel in kernels.json.
+func LatestCIKernel(arch string) (string, error) {
 	// List CI builds (sorted lexically, newest last)
-	listURL := "https://s3.amazonaws.com/spec.ccfc.min?prefix=firecracker-ci/&delimiter=/"
+	listURL := ciKernelBucket + "?prefix=firecracker-ci/&delimiter=/"
 	resp, err := http.Get(listURL)
 	if err != nil {
 		return "", fmt.Errorf("listing CI builds: %w", err)
@@ -198,8 +325,8 @@ func findLatestKernel(arch string) (string, error) {
 // findKernelInBuild looks for a vmlinux-6.1.x kernel in a specific CI build.
 func findKernelInBuild(buildPrefix, arch string) (string, error) {
 	listURL := fmt.Sprintf(
-		"https://s3.amazonaws.com/spec.ccfc.min?prefix=%s%s/vmlinux-6.1&delimiter=/",
-		buildPrefix, arch,
+		"%s?prefix=%s%s/vmlinux-6.1&delimiter=/",
+		ciKernelBucket, buildPrefix, arch,
 	)
 	resp, err := http.Get(listURL)
 	if err != nil {
@@ -244,24 +371,68 @@ func findKernelInBuild(buildPrefix, arch string) (string, error) {
 }
 
 // EnsureRootfs downloads or retrieves the rootfs quickstart image if no rootfs exists.
-func EnsureRootfs(paths *VMPaths, version string, stderr io.Writer) error {
+// It is rebuilt when it was built with other options (see RootfsCurrent).
+// The version may be a SnapshotKey, in which case opts.Requirements is the
+// requirements file to install in the profile's rootfs.
+func EnsureRootfs(paths *VMPaths, version string, opts RootfsOptions, stderr io.Writer) error {
 	rootfsPath := paths.RootfsForVersion(version)
-	if _, err := os.Stat(rootfsPath); err == nil {
+	if RootfsCurrent(paths, version, opts) {
 		return nil
 	}
+	if _, profile := SplitSnapshotKey(version); profile != "" && opts.Requirements == "" {
+		return fmt.Errorf("profile %s needs --requirements to build its rootfs", profile)
+	}
+	os.Remove(rootfsPath)
 
 	if err := os.MkdirAll(paths.RootfsDir, 0o755); err != nil {
 		return fmt.Errorf("creating rootfs dir: %w", err)
 	}
 
-	// Try Docker-based build first
-	if _, err := findDocker(); err == nil {
-		return buildRootfsDocker(paths, version, stderr)
+	engine, err := findContainerEngine()
+	if err == nil {
+		return buildRootfsDocker(paths, engine, version, opts, stderr)
 	}
-
-	return fmt.Errorf("Docker is required to build the VM rootfs. Install Docker and retry")
+	// Without a container engine, mmdebstrap can build the same rootfs.
+	if mmdebstrap, mmErr := findMmdebstrap(); mmErr == nil {
+		fmt.Fprintf(stderr, "%v; building the rootfs with mmdebstrap\n", err)
+		return buildRootfsMmdebstrap(paths, mmdebstrap, version, opts, stderr)
+	}
+	return err
 }
 
-func findDocker() (string, error) {
-	return exec.LookPath("docker")
+// containerEngines are the container CLIs that can build the rootfs, in
+// order of preference. They all take the build, create, export, run, rm
+// and rmi arguments buildRootfsDocker uses.
+var containerEngines = []string{"docker", "podman", "nerdctl"}
+
+// findContainerEngine returns the container CLI to build the rootfs with:
+// DH_VM_CONTAINER_ENGINE if set, otherwise the first of containerEngines
+// whose 'info' works (so a docker CLI without a reachable daemon loses to
+// podman), or else the first one installed. DH_VM_CONTAINER_ENGINE=none
+// selects none, so the rootfs is built with mmdebstrap.
+func findContainerEngine() (string, error) {
+	if name := os.Getenv("DH_VM_CONTAINER_ENGINE"); name == "none" {
+		return "", fmt.Errorf("DH_VM_CONTAINER_ENGINE is none")
+	} else if name != "" {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			return "", fmt.Errorf("DH_VM_CONTAINER_ENGINE: %w", err)
+		}
+		return path, nil
+	}
+	var installed []string
+	for _, name := range containerEngines {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		if exec.Command(path, "info").Run() == nil {
+			return path, nil
+		}
+		installed = append(installed, path)
+	}
+	if len(installed) == 0 {
+		return "", fmt.Errorf("Docker, Podman, nerdctl or mmdebstrap is required to build the VM rootfs. Install one and retry")
+	}
+	return installed[0], nil
 }
@@ -11,10 +11,18 @@ func EnsureFirecracker(_ *VMPaths, _ io.Writer) error {
 	return fmt.Errorf("VM mode requires Linux")
 }
 
+func EnsureJailer(_ *VMPaths, _ io.Writer) error {
+	return fmt.Errorf("VM mode requires Linux")
+}
+
 func EnsureKernel(_ *VMPaths, _ io.Writer) error {
 	return fmt.Errorf("VM mode requires Linux")
 }
 
-func EnsureRootfs(_ *VMPaths, _ string, _ io.Writer) error {
+func InstallKernel(_ *VMPaths, _ string, _ io.Writer) error {
+	return fmt.Errorf("VM mode requires Linux")
+}
+
+func EnsureRootfs(_ *VMPaths, _ string, _ RootfsOptions, _ io.Writer) error {
 	return fmt.Errorf("VM mode requires Linux")
 }
@@ -5,6 +5,13 @@
  * requests for /workspace/* paths to a host file server over vsock. Files are
  * cached locally in /tmp/.wscache/ for subsequent access.
  *
+ * Where the host allows writes, a file opened for writing is written in the
+ * cache and uploaded to the host when it is closed; mkdir creates the
+ * directory on the host.
+ *
+ * With DH_WORKSPACE_FUSE set, /workspace is a FUSE mount served by
+ * workspace_fuse.py and every hook passes straight through.
+ *
  * Compile: gcc -shared -fPIC -O2 -o libworkspace.so libworkspace.c -ldl -lpthread
  */
 
@@ -50,8 +57,13 @@ struct sockaddr_vm {
 #define OP_STAT    1
 #define OP_READ    2
 #define OP_READDIR 3
+#define OP_WRITE   4
+#define OP_MKDIR   5
+#define OP_TRUNCATE 6
 #define STATUS_OK    0
 #define STATUS_NOENT 1
+#define STATUS_EXIST 3
+#define STATUS_READONLY 4
 
 #define WORKSPACE_PREFIX "/workspace/"
 #define WORKSPACE_PREFIX_LEN 11
@@ -68,14 +80,25 @@ static __thread int in_hook = 0;
 static pthread_mutex_t vsock_mu = PTHREAD_MUTEX_INITIALIZER;
 static int vsock_fd = -1;
 
+/* Workspace paths of the fds open for writing, uploaded on close. */
+#define MAX_DIRTY_FDS 4096
+static pthread_mutex_t dirty_mu = PTHREAD_MUTEX_INITIALIZER;
+static char *dirty_rel[MAX_DIRTY_FDS];
+
 /* Real libc function pointers. */
 typedef int (*real_openat_t)(int, const char *, int, ...);
 typedef int (*real_fstatat_t)(int, const char *, struct stat *, int);
 typedef int (*real_faccessat_t)(int, const char *, int, int);
+typedef int (*real_close_t)(int);
+typedef int (*real_fclose_t)(FILE *);
+typedef int (*real_mkdirat_t)(int, const char *, mode_t);
 
 static real_openat_t    real_openat    = NULL;
 static real_fstatat_t   real_fstatat   = NULL;
 static real_faccessat_t real_faccessat = NULL;
+static real_close_t     real_close     = NULL;
+static real_fclose_t    real_fclose    = NULL;
+static real_mkdirat_t   real_mkdirat   = NULL;
 
 /* ---- Initialization ---- */
 
@@ -89,6 +112,15 @@ static void init_real_funcs(void) {
     if (!real_faccessat) {
         real_faccessat = (real_faccessat_t)dlsym(RTLD_NEXT, "faccessat");
     }
+    if (!real_close) {
+        real_close = (real_close_t)dlsym(RTLD_NEXT, "close");
+    }
+    if (!real_fclose) {
+        real_fclose = (real_fclose_t)dlsym(RTLD_NEXT, "fclose");
+    }
+    if (!real_mkdirat) {
+        real_mkdirat = (real_mkdirat_t)dlsym(RTLD_NEXT, "mkdirat");
+    }
 }
 
 __attribute__((constructor))
@@ -140,8 +172,12 @@ static int resolve_path(int dirfd, const char *pathname, char *resolved, size_t
  * is_workspace_path: check if resolved path starts with /workspace/.
  * If so, set *rel to point past the prefix.
  * Also matches /workspace exactly (the directory itself).
+ * Never matches when DH_WORKSPACE_FUSE is set: /workspace is then a FUSE
+ * mount (dh exec --mount-mode=fuse) and needs no interception.
  */
 static int is_workspace_path(const char *resolved, const char **rel) {
+    if (getenv("DH_WORKSPACE_FUSE"))
+        return 0;
     if (strncmp(resolved, WORKSPACE_PREFIX, WORKSPACE_PREFIX_LEN) == 0) {
         *rel = resolved + WORKSPACE_PREFIX_LEN;
         return 1;
@@ -401,6 +437,146 @@ static ssize_t remote_read_chunk(const char *rel, uint64_t offset, void *buf, ui
     return (ssize_t)bytes_read;
 }
 
+/* Write big-endian integers into a request. */
+static void put_u16(uint8_t *p, uint16_t v) {
+    p[0] = (v >> 8) & 0xFF;
+    p[1] = v & 0xFF;
+}
+
+static void put_u32(uint8_t *p, uint32_t v) {
+    for (int i = 0; i < 4; i++)
+        p[i] = (v >> (24 - 8 * i)) & 0xFF;
+}
+
+static void put_u64(uint8_t *p, uint64_t v) {
+    for (int i = 0; i < 8; i++)
+        p[i] = (v >> (56 - 8 * i)) & 0xFF;
+}
+
+/* status_errno: the errno for a failed write request's status. */
+static int status_errno(uint8_t status) {
+    switch (status) {
+    case STATUS_NOENT:    return ENOENT;
+    case STATUS_EXIST:    return EEXIST;
+    case STATUS_READONLY: return EROFS;
+    default:              return EIO;
+    }
+}
+
+/*
+ * remote_call: send a request on the shared connection and return the
+ * response, or NULL on error. Caller frees the response.
+ */
+static uint8_t *remote_call(const uint8_t *msg, uint32_t msg_len, uint32_t *resp_len) {
+    pthread_mutex_lock(&vsock_mu);
+    if (vsock_connect() < 0) {
+        pthread_mutex_unlock(&vsock_mu);
+        return NULL;
+    }
+    uint8_t *resp = send_request(msg, msg_len, resp_len);
+    if (!resp)
+        vsock_disconnect();
+    pthread_mutex_unlock(&vsock_mu);
+    return resp;
+}
+
+/*
+ * remote_status_call: send a request answered by a bare status.
+ * Returns 0 on success, or -1 with errno set.
+ */
+static int remote_status_call(const uint8_t *msg, uint32_t msg_len) {
+    uint32_t resp_len;
+    uint8_t *resp = remote_call(msg, msg_len, &resp_len);
+    if (!resp) {
+        errno = EIO;
+        return -1;
+    }
+    uint8_t status = resp[0];
+    free(resp);
+    if (status != STATUS_OK) {
+        errno = status_errno(status);
+        return -1;
+    }
+    return 0;
+}
+
+/*
+ * remote_truncate: set the size of a host file, creating it if needed.
+ * Returns 0, or -1 with errno set.
+ */
+static int remote_truncate(const char *rel, uint64_t size) {
+    uint16_t path_len = (uint16_t)strlen(rel);
+
+    /* Build request: [op=6][2-byte path_len][path][8-byte size] */
+    uint32_t msg_len = 1 + 2 + path_len + 8;
+    uint8_t *msg = (uint8_t *)alloca(msg_len);
+    msg[0] = OP_TRUNCATE;
+    put_u16(msg + 1, path_len);
+    memcpy(msg + 3, rel, path_len);
+    put_u64(msg + 3 + path_len, size);
+    return remote_status_call(msg, msg_len);
+}
+
+/*
+ * remote_mkdir: create a directory on the host.
+ * Returns 0, or -1 with errno set.
+ */
+static int remote_mkdir(const char *rel, mode_t mode) {
+    uint16_t path_len = (uint16_t)strlen(rel);
+
+    /* Build request: [op=5][2-byte path_len][path][4-byte mode] */
+    uint32_t msg_len = 1 + 2 + path_len + 4;
+    uint8_t *msg = (uint8_t *)alloca(msg_len);
+    msg[0] = OP_MKDIR;
+    put_u16(msg + 1, path_len);
+    memcpy(msg + 3, rel, path_len);
+    put_u32(msg + 3 + path_len, (uint32_t)mode);
+    return remote_status_call(msg, msg_len);
+}
+
+/*
+ * remote_write_chunk: write len bytes at offset in a host file.
+ * Returns 0, or -1 with errno set.
+ */
+static int remote_write_chunk(const char *rel, uint64_t offset, const void *buf, uint32_t len) {
+    uint16_t path_len = (uint16_t)strlen(rel);
+
+    /* Build request: [op=4][2-byte path_len][path][8-byte offset][bytes] */
+    uint32_t msg_len = 1 + 2 + path_len + 8 + len;
+    uint8_t *msg = (uint8_t *)malloc(msg_len);
+    if (!msg) {
+        errno = ENOMEM;
+        return -1;
+    }
+    msg[0] = OP_WRITE;
+    put_u16(msg + 1, path_len);
+    memcpy(msg + 3, rel, path_len);
+    put_u64(msg + 3 + path_len, offset);
+    memcpy(msg + 3 + path_len + 8, buf, len);
+
+    uint32_t resp_len;
+    uint8_t *resp = remote_call(msg, msg_len, &resp_len);
+    free(msg);
+    if (!resp) {
+        errno = EIO;
+        return -1;
+    }
+
+    /* Parse: [status=0][4-byte bytes_written] */
+    int rc = 0;
+    if (resp[0] != STATUS_OK) {
+        errno = status_errno(resp[0]);
+        rc = -1;
+    } else if (resp_len < 5 ||
+               (((uint32_t)resp[1] << 24) | ((uint32_t)resp[2] << 16) |
+                ((uint32_t)resp[3] << 8) | resp[4]) != len) {
+        errno = EIO;
+        rc = -1;
+    }
+    free(resp);
+    return rc;
+}
+
 /* ---- Cache management ---- */
 
 /* Create parent directories for a cache path (including cache root). */
@@ -525,6 +701,154 @@ static int ensure_cached_stat(const char *rel, struct stat *st) {
     return remote_stat(rel, st);
 }
 
+/* ---- Writes ---- */
+
+/*
+ * open_for_write: open the cached copy of a workspace file for writing and
+ * remember the fd so close() uploads it. Creating or truncating the file
+ * happens on the host right away, so a read-only path or a missing
+ * directory fails the open rather than the close.
+ * Returns the fd, or -1 with errno set.
+ */
+static int open_for_write(const char *rel, int flags, mode_t mode) {
+    char cache_path[PATH_MAX];
+    if (cache_path_for(rel, cache_path, sizeof(cache_path)) < 0) {
+        errno = ENAMETOOLONG;
+        return -1;
+    }
+
+    struct stat remote_st;
+    int exists = remote_stat(rel, &remote_st) == 0;
+    if (exists && (flags & O_CREAT) && (flags & O_EXCL)) {
+        errno = EEXIST;
+        return -1;
+    }
+    if (exists && S_ISDIR(remote_st.st_mode)) {
+        errno = EISDIR;
+        return -1;
+    }
+    /* The host clears the write bits of paths the guest may not write. */
+    if (exists && !(remote_st.st_mode & S_IWUSR)) {
+        errno = EROFS;
+        return -1;
+    }
+    if (!exists && !(flags & O_CREAT)) {
+        errno = ENOENT;
+        return -1;
+    }
+
+    if (exists && !(flags & O_TRUNC)) {
+        /* Appending or rewriting in place starts from the host's copy. */
+        if (ensure_cached_file(rel) < 0) {
+            errno = EIO;
+            return -1;
+        }
+    } else {
+        if (remote_truncate(rel, 0) < 0)
+            return -1;
+        mkdirs(cache_path);
+        int cfd = real_openat(AT_FDCWD, cache_path, O_WRONLY | O_CREAT | O_TRUNC, 0644);
+        if (cfd < 0)
+            return -1;
+        real_close(cfd);
+    }
+
+    int fd = real_openat(AT_FDCWD, cache_path, flags & ~(O_CREAT | O_EXCL), mode);
+    if (fd < 0)
+        return -1;
+    if (fd >= MAX_DIRTY_FDS) {
+        real_close(fd);
+        errno = EMFILE;
+        return -1;
+    }
+    char *saved = strdup(rel);
+    if (!saved) {
+        real_close(fd);
+        errno = ENOMEM;
+        return -1;
+    }
+    pthread_mutex_lock(&dirty_mu);
+    free(dirty_rel[fd]);
+    dirty_rel[fd] = saved;
+    pthread_mutex_unlock(&dirty_mu);
+    return fd;
+}
+
+/* take_dirty: forget fd's workspace path and return it, or NULL. */
+static char *take_dirty(int fd) {
+    if (fd < 0 || fd >= MAX_DIRTY_FDS)
+        return NULL;
+    pthread_mutex_lock(&dirty_mu);
+    char *rel = dirty_rel[fd];
+    dirty_rel[fd] = NULL;
+    pthread_mutex_unlock(&dirty_mu);
+    return rel;
+}
+
+/*
+ * upload_file: replace a host file with its cached copy.
+ * Returns 0, or -1 with errno set.
+ */
+static int upload_file(const char *rel) {
+    char cache_path[PATH_MAX];
+    if (cache_path_for(rel, cache_path, sizeof(cache_path)) < 0) {
+        errno = ENAMETOOLONG;
+        return -1;
+    }
+    int fd = real_openat(AT_FDCWD, cache_path, O_RDONLY, 0);
+    if (fd < 0)
+        return -1;
+
+    struct stat st;
+    uint8_t *chunk_buf = (uint8_t *)malloc(READ_CHUNK_SIZE);
+    if (!chunk_buf || fstat(fd, &st) < 0 || remote_truncate(rel, (uint64_t)st.st_size) < 0) {
+        int saved = chunk_buf ? errno : ENOMEM;
+        free(chunk_buf);
+        real_close(fd);
+        errno = saved;
+        return -1;
+    }
+
+    int rc = 0;
+    uint64_t offset = 0;
+    for (;;) {
+        ssize_t got = pread(fd, chunk_buf, READ_CHUNK_SIZE, (off_t)offset);
+        if (got < 0) {
+            rc = -1;
+            break;
+        }
+        if (got == 0)
+            break;
+        if (remote_write_chunk(rel, offset, chunk_buf, (uint32_t)got) < 0) {
+            rc = -1;
+            break;
+        }
+        offset += got;
+    }
+
+    int saved = errno;
+    free(chunk_buf);
+    real_close(fd);
+    errno = saved;
+    return rc;
+}
+
+/*
+ * finish_write: upload a file once its fd is closed. Returns rc, the result
+ * of the close, or -1 with errno set if the upload failed.
+ */
+static int finish_write(char *rel, int rc) {
+    int saved = errno;
+    in_hook = 1;
+    int up = upload_file(rel);
+    in_hook = 0;
+    free(rel);
+    if (up < 0)
+        return -1;
+    errno = saved;
+    return rc;
+}
+
 /* ---- Intercepted functions ---- */
 
 int openat(int dirfd, const char *pathname, int flags, ...) {
@@ -549,10 +873,15 @@ int openat(int dirfd, const char *pathname, int flags, ...) {
     if (!is_workspace_path(resolved, &rel))
         return real_openat(dirfd, pathname, flags, mode);
 
-    /* Read-only workspace */
-    if (flags & (O_WRONLY | O_RDWR)) {
-        errno = EROFS;
-        return -1;
+    if (flags & (O_WRONLY | O_RDWR | O_CREAT | O_TRUNC)) {
+        if (*rel == '\0') {
+            errno = EISDIR;
+            return -1;
+        }
+        in_hook = 1;
+        int fd = open_for_write(rel, flags, mode);
+        in_hook = 0;
+        return fd;
     }
 
     in_hook = 1;
@@ -688,21 +1017,23 @@ int faccessat(int dirfd, const char *pathname, int mode, int flags) {
     if (!is_workspace_path(resolved, &rel))
         return real_faccessat(dirfd, pathname, mode, flags);
 
-    /* Write access to workspace is denied */
-    if (mode & W_OK) {
-        errno = EROFS;
-        return -1;
-    }
-
     in_hook = 1;
 
     int rc;
-    if (*rel == '\0') {
+    struct stat st;
+    if (mode & W_OK) {
+        /* Only the host knows: it clears the write bits of read-only paths */
+        rc = remote_stat(rel, &st);
+        if (rc == 0 && !(st.st_mode & S_IWUSR)) {
+            in_hook = 0;
+            errno = EROFS;
+            return -1;
+        }
+    } else if (*rel == '\0') {
         /* /workspace itself always exists */
         rc = 0;
     } else {
         /* Check if file exists via remote stat */
-        struct stat st;
         rc = ensure_cached_stat(rel, &st);
     }
 
@@ -719,52 +1050,124 @@ int access(const char *pathname, int mode) {
     return faccessat(AT_FDCWD, pathname, mode, 0);
 }
 
+/* fopen_flags: open(2) flags for an fopen mode, or -1 if it is invalid. */
+static int fopen_flags(const char *mode) {
+    int rw = strchr(mode, '+') != NULL;
+    int flags;
+    switch (mode[0]) {
+    case 'r':
+        flags = rw ? O_RDWR : O_RDONLY;
+        break;
+    case 'w':
+        flags = (rw ? O_RDWR : O_WRONLY) | O_CREAT | O_TRUNC;
+        break;
+    case 'a':
+        flags = (rw ? O_RDWR : O_WRONLY) | O_CREAT | O_APPEND;
+        break;
+    default:
+        return -1;
+    }
+    if (strchr(mode, 'x'))
+        flags |= O_EXCL;
+    return flags;
+}
+
 /* FILE* wrappers — fopen routes through open, so these are covered by openat.
  * But just in case some implementations call fopen directly: */
 FILE *fopen(const char *pathname, const char *mode) {
+    typedef FILE *(*real_fopen_t)(const char *, const char *);
+    static real_fopen_t real_fopen = NULL;
+    if (!real_fopen) real_fopen = (real_fopen_t)dlsym(RTLD_NEXT, "fopen");
+
     init_real_funcs();
 
-    if (in_hook || !pathname) {
-        typedef FILE *(*real_fopen_t)(const char *, const char *);
-        static real_fopen_t real_fopen = NULL;
-        if (!real_fopen) real_fopen = (real_fopen_t)dlsym(RTLD_NEXT, "fopen");
+    if (in_hook || !pathname)
         return real_fopen(pathname, mode);
-    }
 
     char resolved[PATH_MAX];
-    if (resolve_path(AT_FDCWD, pathname, resolved, sizeof(resolved)) < 0) {
-        typedef FILE *(*real_fopen_t)(const char *, const char *);
-        static real_fopen_t real_fopen = NULL;
-        if (!real_fopen) real_fopen = (real_fopen_t)dlsym(RTLD_NEXT, "fopen");
+    if (resolve_path(AT_FDCWD, pathname, resolved, sizeof(resolved)) < 0)
         return real_fopen(pathname, mode);
-    }
 
     const char *rel;
-    if (!is_workspace_path(resolved, &rel)) {
-        typedef FILE *(*real_fopen_t)(const char *, const char *);
-        static real_fopen_t real_fopen = NULL;
-        if (!real_fopen) real_fopen = (real_fopen_t)dlsym(RTLD_NEXT, "fopen");
+    if (!is_workspace_path(resolved, &rel))
         return real_fopen(pathname, mode);
-    }
 
-    /* Reject write modes */
-    if (strchr(mode, 'w') || strchr(mode, 'a') || strchr(mode, '+')) {
-        errno = EROFS;
+    int flags = fopen_flags(mode);
+    if (flags < 0) {
+        errno = EINVAL;
         return NULL;
     }
 
     /* Use our openat to get a cached fd, then fdopen */
-    int fd = openat(AT_FDCWD, pathname, O_RDONLY, 0);
+    int fd = openat(AT_FDCWD, pathname, flags, 0666);
     if (fd < 0)
         return NULL;
 
     typedef FILE *(*real_fdopen_t)(int, const char *);
     static real_fdopen_t real_fdopen = NULL;
     if (!real_fdopen) real_fdopen = (real_fdopen_t)dlsym(RTLD_NEXT, "fdopen");
-    return real_fdopen(fd, "r");
+    FILE *f = real_fdopen(fd, mode);
+    if (!f) {
+        int saved = errno;
+        close(fd);
+        errno = saved;
+    }
+    return f;
 }
 
 /* fopen64 — same as fopen on modern glibc */
 FILE *fopen64(const char *pathname, const char *mode) {
     return fopen(pathname, mode);
 }
+
+/* close and fclose upload workspace files that were open for writing. */
+int close(int fd) {
+    init_real_funcs();
+    char *rel = take_dirty(fd);
+    int rc = real_close(fd);
+    return rel ? finish_write(rel, rc) : rc;
+}
+
+int fclose(FILE *stream) {
+    init_real_funcs();
+    char *rel = stream ? take_dirty(fileno(stream)) : NULL;
+    int rc = real_fclose(stream);
+    return rel ? finish_write(rel, rc) : rc;
+}
+
+int mkdirat(int dirfd, const char *pathname, mode_t mode) {
+    init_real_funcs();
+
+    if (in_hook || !pathname)
+        return real_mkdirat(dirfd, pathname, mode);
+
+    char resolved[PATH_MAX];
+    if (resolve_path(dirfd, pathname, resolved, sizeof(resolved)) < 0)
+        return real_mkdirat(dirfd, pathname, mode);
+
+    const char *rel;
+    if (!is_workspace_path(resolved, &rel))
+        return real_mkdirat(dirfd, pathname, mode);
+
+    if (*rel == '\0') {
+        errno = EEXIST;
+        return -1;
+    }
+
+    in_hook = 1;
+    int rc = remote_mkdir(rel, mode);
+    if (rc == 0) {
+        /* Mirror it in the cache so the directory can be listed. */
+        char cache_path[PATH_MAX];
+        if (cache_path_for(rel, cache_path, sizeof(cache_path)) == 0) {
+            mkdirs(cache_path);
+            real_mkdirat(AT_FDCWD, cache_path, 0755);
+        }
+    }
+    in_hook = 0;
+    return rc;
+}
+
+int mkdir(const char *pathname, mode_t mode) {
+    return mkdirat(AT_FDCWD, pathname, mode);
+}
@@ -0,0 +1,403 @@
+"""workspace_fuse.py — FUSE daemon for dh exec --vm --mount-mode=fuse.
+
+Mounts /workspace in the guest and serves it from the host file server over
+vsock, the same server libworkspace.so talks to. Unlike the LD_PRELOAD shim,
+a real mount covers every way a process reaches a file: mmap, directory
+listings, statically linked tools and subprocesses alike.
+
+The guest has no libfuse, so this speaks the kernel protocol on /dev/fuse
+directly. It is single-threaded: requests are served one at a time, each
+with one round trip to the host.
+
+Run by vm_runner.py after snapshot restore; it mounts /workspace and serves
+it until the VM stops.
+"""
+import ctypes
+import errno
+import os
+import socket
+import stat
+import struct
+import sys
+
+MOUNT_POINT = "/workspace"
+
+# Protocol constants — must match fileserver_linux.go
+FILE_SERVER_PORT = 10001
+VMADDR_CID_HOST = 2
+OP_STAT = 1
+OP_READ = 2
+OP_READDIR = 3
+OP_WRITE = 4
+OP_MKDIR = 5
+OP_TRUNCATE = 6
+STATUS_OK = 0
+STATUS_NOENT = 1
+STATUS_EXIST = 3
+STATUS_READONLY = 4
+
+# FUSE opcodes (linux/fuse.h)
+FUSE_LOOKUP = 1
+FUSE_FORGET = 2
+FUSE_GETATTR = 3
+FUSE_SETATTR = 4
+FUSE_MKNOD = 8
+FUSE_MKDIR = 9
+FUSE_UNLINK = 10
+FUSE_RMDIR = 11
+FUSE_RENAME = 12
+FUSE_OPEN = 14
+FUSE_READ = 15
+FUSE_WRITE = 16
+FUSE_STATFS = 17
+FUSE_RELEASE = 18
+FUSE_FSYNC = 20
+FUSE_FLUSH = 25
+FUSE_INIT = 26
+FUSE_OPENDIR = 27
+FUSE_READDIR = 28
+FUSE_RELEASEDIR = 29
+FUSE_FSYNCDIR = 30
+FUSE_CREATE = 35
+FUSE_INTERRUPT = 36
+FUSE_DESTROY = 38
+FUSE_BATCH_FORGET = 42
+FUSE_RENAME2 = 45
+
+FUSE_BIG_WRITES = 1 << 5
+FATTR_SIZE = 1 << 3
+MAX_WRITE = 128 * 1024
+ROOT_ID = 1
+
+IN_HEADER = struct.Struct("<IIQQIIII")
+OUT_HEADER = struct.Struct("<IiQ")
+ATTR = struct.Struct("<QQQQQQIIIIIIIIII")
+ENTRY_OUT = struct.Struct("<QQQQII")
+ATTR_OUT = struct.Struct("<QII")
+OPEN_OUT = struct.Struct("<QII")
+
+# How long the kernel may cache names and attributes, in seconds. The guest
+# is the only writer while a script runs.
+CACHE_SECS = 1
+
+
+class HostError(Exception):
+    """A file server request failed; errno is what the caller sees."""
+
+    def __init__(self, status):
+        super().__init__(status)
+        self.errno = {
+            STATUS_NOENT: errno.ENOENT,
+            STATUS_EXIST: errno.EEXIST,
+            STATUS_READONLY: errno.EROFS,
+        }.get(status, errno.EIO)
+
+
+class FileServer:
+    """Client of the host file server (see fileserver_linux.go)."""
+
+    def __init__(self):
+        self.sock = socket.socket(socket.AF_VSOCK, socket.SOCK_STREAM)
+        self.sock.connect((VMADDR_CID_HOST, FILE_SERVER_PORT))
+
+    def call(self, op, path, extra=b""):
+        p = path.encode("utf-8")
+        msg = bytes([op]) + struct.pack(">H", len(p)) + p + extra
+        self.sock.sendall(struct.pack(">I", len(msg)) + msg)
+        (n,) = struct.unpack(">I", self._recv(4))
+        resp = self._recv(n)
+        if resp[0] != STATUS_OK:
+            raise HostError(resp[0])
+        return resp[1:]
+
+    def _recv(self, n):
+        buf = b""
+        while len(buf) < n:
+            chunk = self.sock.recv(n - len(buf))
+            if not chunk:
+                raise HostError(None)
+            buf += chunk
+        return buf
+
+    def stat(self, path):
+        """Return (mode, size, mtime) with a POSIX mode."""
+        mode, size, mtime, is_dir = struct.unpack(">IQQB", self.call(OP_STAT, path))
+        kind = stat.S_IFDIR if is_dir else stat.S_IFREG
+        return kind | (mode & 0o777), size, mtime
+
+    def read(self, path, offset, size):
+        data = self.call(OP_READ, path, struct.pack(">QI", offset, size))
+        (n,) = struct.unpack(">I", data[:4])
+        return data[4:4 + n]
+
+    def readdir(self, path):
+        """Return [(name, is_dir)]."""
+        data = self.call(OP_READDIR, path)
+        (count,) = struct.unpack(">H", data[:2])
+        entries, pos = [], 2
+        for _ in range(count):
+            (n,) = struct.unpack(">H", data[pos:pos + 2])
+            name = data[pos + 2:pos + 2 + n].decode("utf-8", "surrogateescape")
+            entries.append((name, data[pos + 2 + n] == 1))
+            pos += 3 + n
+        return entries
+
+    def write(self, path, offset, data):
+        (n,) = struct.unpack(">I", self.call(OP_WRITE, path, struct.pack(">Q", offset) + data))
+        return n
+
+    def mkdir(self, path, mode):
+        self.call(OP_MKDIR, path, struct.pack(">I", mode & 0o7777))
+
+    def truncate(self, path, size):
+        self.call(OP_TRUNCATE, path, struct.pack(">Q", size))
+
+
+class FuseError(Exception):
+    def __init__(self, err):
+        super().__init__(err)
+        self.errno = err
+
+
+class Workspace:
+    """Serves FUSE requests for /workspace from the host file server.
+
+    Node IDs map to paths relative to the workspace root; they are never
+    reused, so FORGET needs no bookkeeping.
+    """
+
+    def __init__(self, fd, host):
+        self.fd = fd
+        self.host = host
+        self.paths = {ROOT_ID: ""}
+        self.ids = {"": ROOT_ID}
+        self.dirs = {}  # open directory handle -> listing
+        self.next_fh = 1
+
+    def node(self, path):
+        nid = self.ids.get(path)
+        if nid is None:
+            nid = len(self.paths) + 1
+            self.paths[nid] = path
+            self.ids[path] = nid
+        return nid
+
+    def path(self, nid):
+        try:
+            return self.paths[nid]
+        except KeyError:
+            raise FuseError(errno.ENOENT)
+
+    def child(self, nid, name):
+        parent = self.path(nid)
+        return f"{parent}/{name}" if parent else name
+
+    def host_call(self, fn, *args):
+        try:
+            return fn(*args)
+        except HostError as e:
+            raise FuseError(e.errno)
+
+    def attr(self, path):
+        mode, size, mtime = self.host_call(self.host.stat, path)
+        nlink = 2 if stat.S_ISDIR(mode) else 1
+        return ATTR.pack(self.node(path), size, (size + 511) // 512,
+                         mtime, mtime, mtime, 0, 0, 0,
+                         mode, nlink, 0, 0, 0, 4096, 0)
+
+    def entry(self, path):
+        return ENTRY_OUT.pack(self.node(path), 0, CACHE_SECS, CACHE_SECS, 0, 0) + self.attr(path)
+
+    def attr_out(self, path):
+        return ATTR_OUT.pack(CACHE_SECS, 0, 0) + self.attr(path)
+
+    # --- Request handlers: each returns the reply body or raises FuseError ---
+
+    def init(self, nid, body):
+        major, minor, max_readahead, _ = struct.unpack("<IIII", body[:16])
+        if major != 7:
+            raise FuseError(errno.EPROTO)
+        return struct.pack("<IIIIHHIIHHI28x", 7, min(minor, 31), max_readahead,
+                           FUSE_BIG_WRITES, 16, 12, MAX_WRITE, 1, 0, 0, 0)
+
+    def lookup(self, nid, body):
+        return self.entry(self.child(nid, name_of(body)))
+
+    def getattr(self, nid, body):
+        return self.attr_out(self.path(nid))
+
+    def setattr(self, nid, body):
+        path = self.path(nid)
+        valid, _, _, size = struct.unpack("<IIQQ", body[:24])
+        # Modes, owners and times aren't kept on the host; only the size
+        # is applied.
+        if valid & FATTR_SIZE:
+            self.host_call(self.host.truncate, path, size)
+        return self.attr_out(path)
+
+    def open(self, nid, body):
+        (flags,) = struct.unpack("<I", body[:4])
+        path = self.path(nid)
+        if flags & os.O_ACCMODE != os.O_RDONLY:
+            mode, _, _ = self.host_call(self.host.stat, path)
+            if not mode & 0o200:
+                raise FuseError(errno.EROFS)
+        return OPEN_OUT.pack(0, 0, 0)
+
+    def read(self, nid, body):
+        _, offset, size = struct.unpack("<QQI", body[:20])
+        path = self.path(nid)
+        data = b""
+        while len(data) < size:
+            chunk = self.host_call(self.host.read, path, offset + len(data), size - len(data))
+            if not chunk:
+                break
+            data += chunk
+        return data
+
+    def write(self, nid, body):
+        _, offset, size = struct.unpack("<QQI", body[:20])
+        n = self.host_call(self.host.write, self.path(nid), offset, body[40:40 + size])
+        return struct.pack("<II", n, 0)
+
+    def create(self, nid, body):
+        flags, mode = struct.unpack("<II", body[:8])
+        path = self.child(nid, name_of(body[16:]))
+        try:
+            self.host.stat(path)
+        except HostError:
+            self.host_call(self.host.truncate, path, 0)
+        else:
+            # The kernel's view was stale: the file exists on the host.
+            if flags & os.O_EXCL:
+                raise FuseError(errno.EEXIST)
+            if flags & os.O_TRUNC:
+                self.host_call(self.host.truncate, path, 0)
+        return self.entry(path) + OPEN_OUT.pack(0, 0, 0)
+
+    def mkdir(self, nid, body):
+        (mode,) = struct.unpack("<I", body[:4])
+        path = self.child(nid, name_of(body[8:]))
+        self.host_call(self.host.mkdir, path, mode)
+        return self.entry(path)
+
+    def opendir(self, nid, body):
+        path = self.path(nid)
+        listing = [(".", True, nid), ("..", True, nid)]
+        for name, is_dir in self.host_call(self.host.readdir, path):
+            listing.append((name, is_dir, self.node(self.child(nid, name))))
+        fh = self.next_fh
+        self.next_fh += 1
+        self.dirs[fh] = listing
+        return OPEN_OUT.pack(fh, 0, 0)
+
+    def readdir(self, nid, body):
+        fh, offset, size = struct.unpack("<QQI", body[:20])
+        out = b""
+        for i, (name, is_dir, ino) in enumerate(self.dirs.get(fh, [])[offset:], start=offset):
+            n = name.encode("utf-8", "surrogateescape")
+            dirent = struct.pack("<QQII", ino, i + 1, len(n), 4 if is_dir else 8) + n
+            dirent += b"\0" * (-len(dirent) % 8)
+            if len(out) + len(dirent) > size:
+                break
+            out += dirent
+        return out
+
+    def releasedir(self, nid, body):
+        (fh,) = struct.unpack("<Q", body[:8])
+        self.dirs.pop(fh, None)
+        return b""
+
+    def statfs(self, nid, body):
+        return struct.pack("<QQQQQIII28x", 0, 0, 0, 0, 0, 4096, 255, 4096)
+
+    def ok(self, nid, body):
+        return b""
+
+    def readonly(self, nid, body):
+        raise FuseError(errno.EROFS)
+
+    def serve(self):
+        handlers = {
+            FUSE_INIT: self.init,
+            FUSE_LOOKUP: self.lookup,
+            FUSE_GETATTR: self.getattr,
+            FUSE_SETATTR: self.setattr,
+            FUSE_OPEN: self.open,
+            FUSE_READ: self.read,
+            FUSE_WRITE: self.write,
+            FUSE_CREATE: self.create,
+            FUSE_MKDIR: self.mkdir,
+            FUSE_OPENDIR: self.opendir,
+            FUSE_READDIR: self.readdir,
+            FUSE_RELEASEDIR: self.releasedir,
+            FUSE_STATFS: self.statfs,
+            FUSE_RELEASE: self.ok,
+            FUSE_FLUSH: self.ok,
+            FUSE_FSYNC: self.ok,
+            FUSE_FSYNCDIR: self.ok,
+            # The file server has no way to remove or rename files.
+            FUSE_MKNOD: self.readonly,
+            FUSE_UNLINK: self.readonly,
+            FUSE_RMDIR: self.readonly,
+            FUSE_RENAME: self.readonly,
+            FUSE_RENAME2: self.readonly,
+        }
+        while True:
+            try:
+                req = os.read(self.fd, MAX_WRITE + 4096)
+            except OSError as e:
+                if e.errno in (errno.EINTR, errno.EAGAIN):
+                    continue
+                if e.errno == errno.ENODEV:
+                    return  # unmounted
+                raise
+            _, opcode, unique, nid, _, _, _, _ = IN_HEADER.unpack_from(req)
+            if opcode in (FUSE_FORGET, FUSE_BATCH_FORGET, FUSE_INTERRUPT):
+                continue  # no reply
+            if opcode == FUSE_DESTROY:
+                self.reply(unique, 0, b"")
+                return
+            handler = handlers.get(opcode)
+            try:
+                if handler is None:
+                    raise FuseError(errno.ENOSYS)
+                body, err = handler(nid, req[IN_HEADER.size:]), 0
+            except FuseError as e:
+                body, err = b"", -e.errno
+            except Exception as e:
+                print(f"workspace_fuse: opcode {opcode}: {e!r}", file=sys.stderr, flush=True)
+                body, err = b"", -errno.EIO
+            self.reply(unique, err, body)
+
+    def reply(self, unique, err, body):
+        try:
+            os.write(self.fd, OUT_HEADER.pack(OUT_HEADER.size + len(body), err, unique) + body)
+        except OSError as e:
+            # ENOENT: the request was interrupted and the kernel dropped it.
+            if e.errno != errno.ENOENT:
+                raise
+
+
+def name_of(body):
+    return body.split(b"\0", 1)[0].decode("utf-8", "surrogateescape")
+
+
+def mount(fd):
+    libc = ctypes.CDLL(None, use_errno=True)
+    opts = f"fd={fd},rootmode=40000,user_id=0,group_id=0,allow_other".encode()
+    if libc.mount(b"dh-workspace", MOUNT_POINT.encode(), b"fuse", 0, opts) != 0:
+        err = ctypes.get_errno()
+        raise OSError(err, f"mounting {MOUNT_POINT}: {os.strerror(err)}")
+
+
+def main():
+    host = FileServer()
+    os.makedirs(MOUNT_POINT, exist_ok=True)
+    fd = os.open("/dev/fuse", os.O_RDWR)
+    mount(fd)
+    Workspace(fd, host).serve()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,341 @@
+package vm
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SnapshotArchiveFormat is the layout version written to the manifest of
+// an exported snapshot.
+const SnapshotArchiveFormat = 1
+
+// Entries of a snapshot archive besides the snapshot files: the manifest
+// comes first and the checksums of every file last.
+const (
+	snapshotArchiveManifest = "manifest.json"
+	snapshotArchiveSums     = "SHA256SUMS"
+)
+
+// SnapshotArchive is the manifest of an exported snapshot, a zstd-compressed
+// tar holding the snapshot files and the kernel it was taken with.
+type SnapshotArchive struct {
+	Format      int       `json:"format"`
+	Version     string    `json:"version"`
+	Arch        string    `json:"arch"`
+	Firecracker string    `json:"firecracker"`
+	Created     time.Time `json:"created"`
+	Files       []string  `json:"files"` // in archive order, each listed in SHA256SUMS
+}
+
+// ExportSnapshot writes the snapshot for version, with the kernel, to the
+// archive out. The snapshot must pass VerifySnapshot.
+func ExportSnapshot(paths *VMPaths, version, out string) (*SnapshotArchive, error) {
+	if problems := VerifySnapshot(paths, version); len(problems) > 0 {
+		return nil, fmt.Errorf("snapshot for version %s is not usable: %s", version, strings.Join(problems, "; "))
+	}
+	snapDir := paths.SnapshotDirForVersion(version)
+	sources := map[string]string{"vmlinux": paths.Kernel}
+	m := &SnapshotArchive{
+		Format:      SnapshotArchiveFormat,
+		Version:     version,
+		Arch:        runtime.GOARCH,
+		Firecracker: currentFirecracker(paths),
+		Created:     time.Now().UTC(),
+	}
+	for _, name := range snapshotFiles {
+		if name == "snapshot_mem" {
+			name = filepath.Base(snapshotMemPath(snapDir))
+		}
+		sources[name] = filepath.Join(snapDir, name)
+		m.Files = append(m.Files, name)
+	}
+	m.Files = append(m.Files, "vmlinux")
+
+	if err := writeSnapshotArchive(out, m, sources); err != nil {
+		os.Remove(out)
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeSnapshotArchive(out string, m *SnapshotArchive, sources map[string]string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		return err
+	}
+	defer zw.Close()
+	tw := tar.NewWriter(zw)
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarBytes(tw, snapshotArchiveManifest, data, m.Created); err != nil {
+		return err
+	}
+
+	var sums strings.Builder
+	for _, name := range m.Files {
+		sum, err := writeTarFile(tw, name, sources[name])
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", name, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, name)
+	}
+	if err := writeTarBytes(tw, snapshotArchiveSums, []byte(sums.String()), m.Created); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeTarBytes(tw *tar.Writer, name string, data []byte, mtime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: mtime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// writeTarFile adds the file at src as name and returns its SHA-256.
+func writeTarFile(tw *tar.Writer, name, src string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ImportSnapshot unpacks an archive written by ExportSnapshot into the
+// snapshot directory of its version, after checking every file against
+// its checksum. The kernel is installed when there is none; a different
+// kernel already installed is left alone and the import refused, since
+// other snapshots were taken with it. An existing snapshot for the version
+// is only replaced when force is set.
+func ImportSnapshot(paths *VMPaths, archive string, force bool) (*SnapshotArchive, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	m, err := readSnapshotArchiveManifest(tr, archive)
+	if err != nil {
+		return nil, err
+	}
+	if m.Arch != runtime.GOARCH {
+		return nil, fmt.Errorf("snapshot is for %s, this machine is %s", m.Arch, runtime.GOARCH)
+	}
+	if fc := currentFirecracker(paths); m.Firecracker != fc {
+		return nil, fmt.Errorf("snapshot was taken with Firecracker %s, installed is %s", m.Firecracker, fc)
+	}
+	snapDir := paths.SnapshotDirForVersion(m.Version)
+	if _, err := os.Stat(snapDir); err == nil && !force {
+		return nil, fmt.Errorf("a snapshot for version %s already exists; pass --force to replace it", m.Version)
+	}
+
+	if err := os.MkdirAll(paths.SnapshotDir, 0o755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(paths.SnapshotDir, ".import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	wanted := make(map[string]bool, len(m.Files))
+	for _, name := range m.Files {
+		wanted[name] = true
+	}
+	got := map[string]string{}
+	var sums map[string]string
+	for sums == nil {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s is incomplete: no %s", archive, snapshotArchiveSums)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unpacking %s: %w", archive, err)
+		}
+		switch {
+		case hdr.Name == snapshotArchiveSums:
+			if sums, err = parseSHA256Sums(tr); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", snapshotArchiveSums, err)
+			}
+		case wanted[hdr.Name] && got[hdr.Name] == "":
+			sum, err := writeSparseFile(filepath.Join(staging, hdr.Name), tr)
+			if err != nil {
+				return nil, fmt.Errorf("unpacking %s: %w", hdr.Name, err)
+			}
+			got[hdr.Name] = sum
+		default:
+			return nil, fmt.Errorf("unpacking %s: unexpected entry %s", archive, hdr.Name)
+		}
+	}
+	for _, name := range m.Files {
+		switch {
+		case got[name] == "":
+			return nil, fmt.Errorf("%s is incomplete: no %s", archive, name)
+		case sums[name] != got[name]:
+			return nil, fmt.Errorf("%s is corrupt: checksum mismatch for %s", archive, name)
+		}
+	}
+
+	meta, err := ReadSnapshotMetadata(staging)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Version != m.Version {
+		return nil, fmt.Errorf("%s is corrupt: metadata is for version %s", archive, meta.Version)
+	}
+
+	kernel := filepath.Join(staging, "vmlinux")
+	if local, err := KernelFingerprint(paths); err != nil {
+		if err := os.MkdirAll(filepath.Dir(paths.Kernel), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(kernel, paths.Kernel); err != nil {
+			return nil, fmt.Errorf("installing kernel: %w", err)
+		}
+	} else if local != got["vmlinux"] {
+		return nil, fmt.Errorf("snapshot was taken with a different kernel than %s, which other snapshots may use; run 'dh vm clean' first to import it", paths.Kernel)
+	} else {
+		os.Remove(kernel)
+	}
+
+	unlock, err := lockSnapshot(paths, m.Version, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := os.RemoveAll(snapDir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(staging, snapDir); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func readSnapshotArchiveManifest(tr *tar.Reader, archive string) (*SnapshotArchive, error) {
+	notSnapshot := fmt.Errorf("%s is not an exported dh snapshot", archive)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != snapshotArchiveManifest {
+		return nil, notSnapshot
+	}
+	var m SnapshotArchive
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, notSnapshot
+	}
+	if m.Format != SnapshotArchiveFormat {
+		return nil, fmt.Errorf("unsupported snapshot archive format %d (this dh reads format %d)", m.Format, SnapshotArchiveFormat)
+	}
+	// The version and file names become paths under ~/.dh/vm/snapshots.
+	if m.Version == "" || m.Version != filepath.Base(m.Version) || m.Version == ".." {
+		return nil, fmt.Errorf("%s has an invalid version %q", archive, m.Version)
+	}
+	for _, name := range m.Files {
+		if name == "" || name != filepath.Base(name) || name == ".." || name == "." {
+			return nil, fmt.Errorf("%s has an invalid file name %q", archive, name)
+		}
+	}
+	return &m, nil
+}
+
+// parseSHA256Sums reads sha256sum(1) output into a map of file name to sum.
+func parseSHA256Sums(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		sum, name, ok := strings.Cut(sc.Text(), "  ")
+		if !ok {
+			return nil, fmt.Errorf("malformed line %q", sc.Text())
+		}
+		sums[name] = sum
+	}
+	return sums, sc.Err()
+}
+
+// writeSparseFile writes r to path, leaving holes where it has whole zero
+// chunks so the snapshot memory and disk stay sparse, and returns the
+// SHA-256 of the data.
+func writeSparseFile(path string, r io.Reader) (string, error) {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	const chunkSize = 1024 * 1024
+	buf := make([]byte, chunkSize)
+	h := sha256.New()
+	var size int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			if isZero(buf[:n]) {
+				_, err = out.Seek(int64(n), io.SeekCurrent)
+			} else {
+				_, err = out.Write(buf[:n])
+			}
+			if err != nil {
+				return "", err
+			}
+			size += int64(n)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	// A trailing hole needs the size set explicitly.
+	if err := out.Truncate(size); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), out.Close()
+}
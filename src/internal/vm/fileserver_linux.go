@@ -8,41 +8,70 @@ import (
 	"io"
 	"net"
 	"os"
+	"os/user"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // File server operation codes (guest → host).
 const (
-	opStat    = 1
-	opRead    = 2
-	opReaddir = 3
+	opStat     = 1
+	opRead     = 2
+	opReaddir  = 3
+	opWrite    = 4
+	opMkdir    = 5
+	opTruncate = 6
 )
 
 // File server response status codes (host → guest).
 const (
-	statusOK     = 0
-	statusNoent  = 1
-	statusIO     = 2
+	statusOK       = 0
+	statusNoent    = 1
+	statusIO       = 2
+	statusExist    = 3
+	statusReadOnly = 4
 )
 
 // fileServer serves host files over a Firecracker vsock connection.
 // It listens on {vsockPath}_{FileServerPort} for guest connections.
 type fileServer struct {
-	rootDir  string
-	listener net.Listener
-	done     chan struct{}
-	wg       sync.WaitGroup
+	rootDir    string
+	mounts     map[string]Mount // extra roots keyed by guest alias
+	writeRoots []string         // host paths the guest may write under
+	peer       *peerAccess      // the user served, when not the server's own
+	listener   net.Listener
+	done       chan struct{}
+	wg         sync.WaitGroup
 }
 
 // StartFileServer starts a goroutine-based file server that serves files from
 // rootDir over the Firecracker guest→host vsock mechanism. The listener socket
 // is at vsockPath_10001 (Firecracker convention: guest CID=2:port → host UDS).
-func StartFileServer(vsockPath string, rootDir string) (io.Closer, error) {
+// Extra mounts are served under their alias at the top of the workspace and
+// shadow any rootDir entry with the same name.
+//
+// The guest may only write under writePaths, host paths within rootDir,
+// and in read-write mounts; everything else is read-only.
+func StartFileServer(vsockPath string, rootDir string, writePaths []string, mounts ...Mount) (io.Closer, error) {
+	return startFileServerAs(nil, vsockPath, rootDir, writePaths, mounts...)
+}
+
+// startFileServerAs is StartFileServer serving another user, peer, only the
+// files that user could read itself. A nil peer is the server's own user.
+func startFileServerAs(peer *peerAccess, vsockPath string, rootDir string, writePaths []string, mounts ...Mount) (io.Closer, error) {
 	listenPath := fmt.Sprintf("%s_%d", vsockPath, FileServerPort)
 
-	// Remove stale socket from previous runs.
+	// Remove stale socket from previous runs. A live one is another VM's:
+	// those restored from a snapshot without VsockRelative share the path.
+	if conn, err := net.DialTimeout("unix", listenPath, 100*time.Millisecond); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another VM restored from the same snapshot; retake the snapshot with 'dh vm prepare' to run VMs from it concurrently", listenPath)
+	}
 	os.Remove(listenPath)
 
 	listener, err := net.Listen("unix", listenPath)
@@ -50,11 +79,34 @@ func StartFileServer(vsockPath string, rootDir string) (io.Closer, error) {
 		return nil, fmt.Errorf("listening on %s: %w", listenPath, err)
 	}
 
+	// A jailed Firecracker runs as the owner of its vsock socket and must
+	// be able to connect to ours.
+	if fi, err := os.Stat(vsockPath); err == nil {
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Geteuid() {
+			os.Chown(listenPath, int(st.Uid), int(st.Gid))
+		}
+	}
+
 	fs := &fileServer{
 		rootDir:  rootDir,
+		mounts:   make(map[string]Mount, len(mounts)),
+		peer:     peer,
 		listener: listener,
 		done:     make(chan struct{}),
 	}
+	for _, m := range mounts {
+		fs.mounts[m.Alias] = m
+		if !m.ReadOnly {
+			writePaths = append(writePaths, m.HostPath)
+		}
+	}
+	for _, p := range writePaths {
+		// Writes are checked against resolved paths.
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			p = resolved
+		}
+		fs.writeRoots = append(fs.writeRoots, p)
+	}
 
 	fs.wg.Add(1)
 	go fs.acceptLoop()
@@ -137,6 +189,12 @@ func (fs *fileServer) handleMessage(conn net.Conn, payload []byte) {
 		fs.handleRead(conn, rest)
 	case opReaddir:
 		fs.handleReaddir(conn, rest)
+	case opWrite:
+		fs.handleWrite(conn, rest)
+	case opMkdir:
+		fs.handleMkdir(conn, rest)
+	case opTruncate:
+		fs.handleTruncate(conn, rest)
 	default:
 		writeError(conn, statusIO)
 	}
@@ -144,6 +202,7 @@ func (fs *fileServer) handleMessage(conn net.Conn, payload []byte) {
 
 // handleStat: [2-byte path_len][path_bytes]
 // Response:   [status=0][4-byte mode][8-byte size][8-byte mtime_sec][1-byte is_dir]
+// The write bits of the mode are cleared for paths the guest may not write.
 func (fs *fileServer) handleStat(conn net.Conn, data []byte) {
 	relPath, ok := readPath(data)
 	if !ok {
@@ -167,12 +226,16 @@ func (fs *fileServer) handleStat(conn net.Conn, data []byte) {
 	if fi.IsDir() {
 		isDir = 1
 	}
+	mode := fi.Mode()
+	if resolved, err := filepath.EvalSymlinks(absPath); err != nil || !fs.canWrite(resolved) {
+		mode &^= 0o222
+	}
 
 	// [4-byte length][status=0][4-byte mode][8-byte size][8-byte mtime][1-byte is_dir]
 	resp := make([]byte, 4+1+4+8+8+1)
 	binary.BigEndian.PutUint32(resp[0:4], uint32(1+4+8+8+1))
 	resp[4] = statusOK
-	binary.BigEndian.PutUint32(resp[5:9], uint32(fi.Mode()))
+	binary.BigEndian.PutUint32(resp[5:9], uint32(mode))
 	binary.BigEndian.PutUint64(resp[9:17], uint64(fi.Size()))
 	binary.BigEndian.PutUint64(resp[17:25], uint64(fi.ModTime().Unix()))
 	resp[25] = isDir
@@ -254,16 +317,36 @@ func (fs *fileServer) handleReaddir(conn net.Conn, data []byte) {
 		return
 	}
 
+	type dirEntry struct {
+		name  string
+		isDir bool
+	}
+	var list []dirEntry
+	isRoot := absPath == fs.rootDir
+	for _, e := range entries {
+		if isRoot {
+			if _, shadowed := fs.mounts[e.Name()]; shadowed {
+				continue
+			}
+		}
+		list = append(list, dirEntry{e.Name(), e.IsDir()})
+	}
+	if isRoot {
+		for alias := range fs.mounts {
+			list = append(list, dirEntry{alias, true})
+		}
+	}
+
 	// Build entry list
 	var entryBuf []byte
 	count := 0
-	for _, e := range entries {
-		name := e.Name()
+	for _, e := range list {
+		name := e.name
 		if len(name) > 65535 {
 			continue
 		}
 		var isDir uint8
-		if e.IsDir() {
+		if e.isDir {
 			isDir = 1
 		}
 		nameLenBytes := make([]byte, 2)
@@ -286,28 +369,240 @@ func (fs *fileServer) handleReaddir(conn net.Conn, data []byte) {
 	conn.Write(entryBuf)
 }
 
-// safePath validates and resolves a relative path against rootDir.
-// Returns error if the path escapes rootDir via directory traversal.
+// handleWrite: [2-byte path_len][path_bytes][8-byte offset][raw bytes]
+// Response:    [status=0][4-byte bytes_written]
+// The file is created if it doesn't exist.
+func (fs *fileServer) handleWrite(conn net.Conn, data []byte) {
+	relPath, ok := readPath(data)
+	if !ok || len(data) < 2+len(relPath)+8 {
+		writeError(conn, statusIO)
+		return
+	}
+	rest := data[2+len(relPath):]
+	offset := binary.BigEndian.Uint64(rest[0:8])
+
+	absPath, status := fs.writablePath(relPath)
+	if status != statusOK {
+		writeError(conn, status)
+		return
+	}
+
+	f, err := os.OpenFile(absPath, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		writeError(conn, errStatus(err))
+		return
+	}
+	defer f.Close()
+	n, err := f.WriteAt(rest[8:], int64(offset))
+	if err != nil {
+		writeError(conn, statusIO)
+		return
+	}
+
+	// [4-byte length][status=0][4-byte bytes_written]
+	resp := make([]byte, 4+1+4)
+	binary.BigEndian.PutUint32(resp[0:4], 1+4)
+	resp[4] = statusOK
+	binary.BigEndian.PutUint32(resp[5:9], uint32(n))
+	conn.Write(resp)
+}
+
+// handleMkdir: [2-byte path_len][path_bytes][4-byte mode]
+// Response:    [status=0]
+func (fs *fileServer) handleMkdir(conn net.Conn, data []byte) {
+	relPath, ok := readPath(data)
+	if !ok || len(data) < 2+len(relPath)+4 {
+		writeError(conn, statusIO)
+		return
+	}
+	mode := binary.BigEndian.Uint32(data[2+len(relPath):])
+
+	absPath, status := fs.writablePath(relPath)
+	if status != statusOK {
+		writeError(conn, status)
+		return
+	}
+	if err := os.Mkdir(absPath, os.FileMode(mode)&os.ModePerm); err != nil {
+		writeError(conn, errStatus(err))
+		return
+	}
+	writeStatus(conn, statusOK)
+}
+
+// handleTruncate: [2-byte path_len][path_bytes][8-byte size]
+// Response:       [status=0]
+// The file is created if it doesn't exist.
+func (fs *fileServer) handleTruncate(conn net.Conn, data []byte) {
+	relPath, ok := readPath(data)
+	if !ok || len(data) < 2+len(relPath)+8 {
+		writeError(conn, statusIO)
+		return
+	}
+	size := binary.BigEndian.Uint64(data[2+len(relPath):])
+
+	absPath, status := fs.writablePath(relPath)
+	if status != statusOK {
+		writeError(conn, status)
+		return
+	}
+	f, err := os.OpenFile(absPath, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		writeError(conn, errStatus(err))
+		return
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(size)); err != nil {
+		writeError(conn, statusIO)
+		return
+	}
+	writeStatus(conn, statusOK)
+}
+
+// writablePath resolves relPath like safePath for a write, which must land
+// under one of the write roots. The parent directory must exist and is
+// resolved, so that neither a symlinked directory nor a symlinked file
+// leads the write elsewhere.
+func (fs *fileServer) writablePath(relPath string) (string, byte) {
+	absPath, err := fs.safePath(relPath)
+	if err != nil {
+		return "", statusNoent
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(absPath))
+	if err != nil {
+		return "", statusNoent
+	}
+	target := filepath.Join(dir, filepath.Base(absPath))
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		resolved, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			return "", statusReadOnly // dangling: writing would create its target
+		}
+		target = resolved
+	}
+	if !fs.canWrite(target) {
+		return "", statusReadOnly
+	}
+	return target, statusOK
+}
+
+// canWrite reports whether the resolved host path is under a write root.
+func (fs *fileServer) canWrite(path string) bool {
+	for _, root := range fs.writeRoots {
+		if isSubPath(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// errStatus maps a host error to a response status.
+func errStatus(err error) byte {
+	switch {
+	case os.IsNotExist(err):
+		return statusNoent
+	case os.IsExist(err):
+		return statusExist
+	case os.IsPermission(err):
+		return statusReadOnly
+	default:
+		return statusIO
+	}
+}
+
+// safePath validates and resolves a relative path against rootDir, or against
+// a mount's host path when the first component names a mount alias.
+// Returns error if the path escapes its root via directory traversal.
 func (fs *fileServer) safePath(relPath string) (string, error) {
 	cleaned := filepath.Clean(relPath)
 	if filepath.IsAbs(cleaned) {
 		// Strip leading slash to make it relative.
 		cleaned = cleaned[1:]
 	}
-	absPath := filepath.Join(fs.rootDir, cleaned)
 
-	// Verify the resolved path is still under rootDir.
+	root := fs.rootDir
+	first, rest, _ := strings.Cut(cleaned, string(filepath.Separator))
+	if m, ok := fs.mounts[first]; ok {
+		root = m.HostPath
+		cleaned = rest
+	}
+	absPath := filepath.Join(root, cleaned)
+
+	// Verify the resolved path is still under its root.
 	resolved, err := filepath.EvalSymlinks(absPath)
 	if err != nil {
 		// File may not exist yet for stat — try parent dir.
 		resolved = absPath
 	}
-	if !isSubPath(fs.rootDir, resolved) {
+	if !isSubPath(root, resolved) {
 		return "", fmt.Errorf("path escapes root: %s", relPath)
 	}
+	if fs.peer != nil && !fs.peer.allowed(absPath) {
+		return "", fmt.Errorf("user %d can't read %s", fs.peer.uid, relPath)
+	}
 	return absPath, nil
 }
 
+// peerAccess is the file access of a user other than the file server's
+// own, going by the permission bits of the files. The pool daemon serves
+// the users it allows with its own privileges, and must not show them
+// files they couldn't read themselves.
+type peerAccess struct {
+	uid  int
+	gids []int
+}
+
+// newPeerAccess returns the access of user uid, with the groups it is in.
+func newPeerAccess(uid int) *peerAccess {
+	a := &peerAccess{uid: uid}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return a
+	}
+	ids, _ := u.GroupIds()
+	for _, id := range append(ids, u.Gid) {
+		if gid, err := strconv.Atoi(id); err == nil {
+			a.gids = append(a.gids, gid)
+		}
+	}
+	return a
+}
+
+// allowed reports whether the user may read path, after symlinks: it needs
+// read permission on the file and search permission on every directory
+// above it.
+func (a *peerAccess) allowed(path string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	want := uint32(4)
+	for p := resolved; ; p = filepath.Dir(p) {
+		var st syscall.Stat_t
+		if syscall.Stat(p, &st) != nil || !a.may(&st, want) {
+			return false
+		}
+		if p == "/" {
+			return true
+		}
+		want = 1
+	}
+}
+
+// may reports whether the user has the permissions in want (4 read, 2
+// write, 1 search) on a file with stat st.
+func (a *peerAccess) may(st *syscall.Stat_t, want uint32) bool {
+	perm := st.Mode & 0o777
+	switch {
+	case a.uid == 0:
+		return true
+	case int(st.Uid) == a.uid:
+		perm >>= 6
+	case slices.Contains(a.gids, int(st.Gid)):
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
 // isSubPath checks whether child is under (or equal to) parent.
 func isSubPath(parent, child string) bool {
 	rel, err := filepath.Rel(parent, child)
@@ -332,6 +627,11 @@ func readPath(data []byte) (string, bool) {
 
 // writeError sends a length-prefixed error response.
 func writeError(conn net.Conn, status byte) {
+	writeStatus(conn, status)
+}
+
+// writeStatus sends a length-prefixed response holding only status.
+func writeStatus(conn net.Conn, status byte) {
 	resp := make([]byte, 5)
 	binary.BigEndian.PutUint32(resp[0:4], 1)
 	resp[4] = status
@@ -0,0 +1,186 @@
+//go:build linux
+
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fsRequest sends one file server request and returns the response payload.
+func fsRequest(t *testing.T, conn net.Conn, op byte, path string, extra ...[]byte) []byte {
+	t.Helper()
+	msg := []byte{op, byte(len(path) >> 8), byte(len(path))}
+	msg = append(msg, path...)
+	for _, e := range extra {
+		msg = append(msg, e...)
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(len(msg))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	var n uint32
+	if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func be64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func startTestFileServer(t *testing.T, root string, writePaths []string, mounts ...Mount) net.Conn {
+	t.Helper()
+	return startTestFileServerAs(t, nil, root, writePaths, mounts...)
+}
+
+func startTestFileServerAs(t *testing.T, peer *peerAccess, root string, writePaths []string, mounts ...Mount) net.Conn {
+	t.Helper()
+	vsockPath := filepath.Join(t.TempDir(), "v.sock")
+	fs, err := startFileServerAs(peer, vsockPath, root, writePaths, mounts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	conn, err := net.Dial("unix", fmt.Sprintf("%s_%d", vsockPath, FileServerPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestFileServerWrite(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "out"), 0o755)
+	os.WriteFile(filepath.Join(root, "in.csv"), []byte("a,b\n"), 0o644)
+	conn := startTestFileServer(t, root, []string{filepath.Join(root, "out")})
+
+	if resp := fsRequest(t, conn, opMkdir, "out/sub", binary.BigEndian.AppendUint32(nil, 0o755)); resp[0] != statusOK {
+		t.Fatalf("mkdir: status %d", resp[0])
+	}
+	if resp := fsRequest(t, conn, opMkdir, "out/sub", binary.BigEndian.AppendUint32(nil, 0o755)); resp[0] != statusExist {
+		t.Errorf("mkdir of an existing dir: status %d", resp[0])
+	}
+	if resp := fsRequest(t, conn, opTruncate, "out/sub/r.csv", be64(0)); resp[0] != statusOK {
+		t.Fatalf("truncate: status %d", resp[0])
+	}
+	resp := fsRequest(t, conn, opWrite, "out/sub/r.csv", be64(0), []byte("x,y\n"))
+	if resp[0] != statusOK || binary.BigEndian.Uint32(resp[1:5]) != 4 {
+		t.Fatalf("write: %v", resp)
+	}
+	fsRequest(t, conn, opWrite, "out/sub/r.csv", be64(4), []byte("1,2\n"))
+	if got, _ := os.ReadFile(filepath.Join(root, "out/sub/r.csv")); string(got) != "x,y\n1,2\n" {
+		t.Errorf("file = %q", got)
+	}
+
+	// Outside the write paths: refused, and stat shows it read-only.
+	for _, path := range []string{"in.csv", "new.csv", "../escape.csv"} {
+		if resp := fsRequest(t, conn, opWrite, path, be64(0), []byte("x")); resp[0] == statusOK {
+			t.Errorf("wrote %s", path)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "in.csv")); string(got) != "a,b\n" {
+		t.Errorf("in.csv = %q", got)
+	}
+	if resp := fsRequest(t, conn, opStat, "in.csv"); binary.BigEndian.Uint32(resp[1:5])&0o222 != 0 {
+		t.Errorf("read-only file has write bits: %o", binary.BigEndian.Uint32(resp[1:5]))
+	}
+	if resp := fsRequest(t, conn, opStat, "out/sub/r.csv"); binary.BigEndian.Uint32(resp[1:5])&0o200 == 0 {
+		t.Errorf("writable file lacks write bits: %o", binary.BigEndian.Uint32(resp[1:5]))
+	}
+}
+
+func TestFileServerWrite_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.Symlink(outside, filepath.Join(root, "link"))
+	os.Symlink(filepath.Join(outside, "f"), filepath.Join(root, "dangling"))
+	conn := startTestFileServer(t, root, []string{root})
+
+	for _, path := range []string{"link/f", "dangling"} {
+		if resp := fsRequest(t, conn, opTruncate, path, be64(0)); resp[0] == statusOK {
+			t.Errorf("wrote through %s", path)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("files created outside the root: %v", entries)
+	}
+}
+
+func TestFileServerWrite_Mounts(t *testing.T) {
+	ro, rw := t.TempDir(), t.TempDir()
+	conn := startTestFileServer(t, t.TempDir(), nil,
+		Mount{HostPath: ro, Alias: "ro", ReadOnly: true},
+		Mount{HostPath: rw, Alias: "rw"})
+
+	if resp := fsRequest(t, conn, opTruncate, "ro/f", be64(0)); resp[0] != statusReadOnly {
+		t.Errorf("read-only mount: status %d", resp[0])
+	}
+	if resp := fsRequest(t, conn, opTruncate, "rw/f", be64(3)); resp[0] != statusOK {
+		t.Errorf("read-write mount: status %d", resp[0])
+	}
+	if fi, err := os.Stat(filepath.Join(rw, "f")); err != nil || fi.Size() != 3 {
+		t.Errorf("rw/f: %v", err)
+	}
+}
+
+func TestFileServer_Peer(t *testing.T) {
+	root := sharedTempDir(t)
+	os.WriteFile(filepath.Join(root, "public.txt"), []byte("hello"), 0o644)
+	os.WriteFile(filepath.Join(root, "secret.txt"), []byte("key"), 0o600)
+	os.Mkdir(filepath.Join(root, "private"), 0o700)
+	os.WriteFile(filepath.Join(root, "private", "notes.txt"), []byte("notes"), 0o644)
+
+	// Another user than the server's own gets only what it could read.
+	conn := startTestFileServerAs(t, newPeerAccess(os.Geteuid()+1), root, nil)
+	for path, want := range map[string]byte{
+		"public.txt":        statusOK,
+		"secret.txt":        statusNoent,
+		"private/notes.txt": statusNoent,
+	} {
+		if resp := fsRequest(t, conn, opStat, path); resp[0] != want {
+			t.Errorf("stat %s = status %d, want %d", path, resp[0], want)
+		}
+	}
+	if resp := fsRequest(t, conn, opRead, "secret.txt", be64(0), []byte{0, 0, 0x10, 0}); resp[0] != statusNoent {
+		t.Errorf("read secret.txt = status %d", resp[0])
+	}
+
+	// The server's own user gets everything.
+	own := startTestFileServer(t, root, nil)
+	if resp := fsRequest(t, own, opStat, "secret.txt"); resp[0] != statusOK {
+		t.Errorf("own user: stat secret.txt = status %d", resp[0])
+	}
+}
+
+func TestStartFileServer_SocketInUse(t *testing.T) {
+	vsockPath := filepath.Join(t.TempDir(), "vsock.sock")
+	fs, err := StartFileServer(vsockPath, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StartFileServer(vsockPath, t.TempDir(), nil); err == nil || !strings.Contains(err.Error(), "in use by another VM") {
+		t.Errorf("expected an in use error, got %v", err)
+	}
+
+	// A stale socket, with nothing listening, is replaced.
+	fs.Close()
+	os.WriteFile(fmt.Sprintf("%s_%d", vsockPath, FileServerPort), nil, 0o644)
+	fs, err = StartFileServer(vsockPath, t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+	fs.Close()
+}
@@ -0,0 +1,197 @@
+package vm
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+)
+
+// gcGrace is how long anything dh vm gc could remove is left alone after
+// it last changed, so a dh vm prepare in progress keeps its rootfs and
+// half-written snapshot.
+const gcGrace = time.Hour
+
+// Kinds of VM garbage.
+const (
+	GarbageInstance   = "instance-dir"        // run directory of a VM that is gone
+	GarbageIncomplete = "incomplete-snapshot" // snapshot missing some of its files
+	GarbageSnapshot   = "snapshot"            // complete snapshot outside the policy
+	GarbageRootfs     = "rootfs"              // rootfs image without a snapshot
+)
+
+// GCPolicy chooses which complete snapshots FindGarbage returns. With
+// neither KeepLatest nor OlderThan set it removes none; incomplete
+// snapshots, rootfs images without a snapshot and orphaned instance
+// directories are removed either way.
+type GCPolicy struct {
+	KeepLatest int           // keep the snapshots of the newest KeepLatest versions; 0 keeps every version
+	OlderThan  time.Duration // only remove what has not changed for this long
+	Keep       []string      // versions whose snapshots and rootfs images are always kept
+}
+
+// Garbage is one thing dh vm gc removes.
+type Garbage struct {
+	Kind      string    `json:"kind"`
+	Version   string    `json:"version,omitempty"` // snapshot key, for snapshots and rootfs images
+	Paths     []string  `json:"paths"`
+	SizeBytes int64     `json:"size_bytes"`
+	Modified  time.Time `json:"modified"`
+	Reason    string    `json:"reason"`
+}
+
+// FindGarbage returns what policy lets dh vm gc remove under paths, as of
+// now: orphaned instance directories, then snapshots, then rootfs images.
+func FindGarbage(paths *VMPaths, policy GCPolicy, now time.Time) []Garbage {
+	var garbage []Garbage
+	for _, s := range StaleInstances(paths) {
+		garbage = append(garbage, Garbage{
+			Kind:      GarbageInstance,
+			Paths:     []string{s.Dir},
+			SizeBytes: DiskUsage(s.Dir),
+			Modified:  newestModTime(s.Dir),
+			Reason:    s.Reason,
+		})
+	}
+
+	minAge := max(policy.OlderThan, gcGrace)
+	old := func(t time.Time) bool { return now.Sub(t) >= minAge }
+	kept := func(key string) bool {
+		version, _ := SplitSnapshotKey(key)
+		return slices.Contains(policy.Keep, version)
+	}
+
+	snaps, _ := ListSnapshots(paths)
+	latest := latestVersions(snaps, policy.KeepLatest)
+	current := map[string]bool{} // keys whose snapshot stays, complete or not
+	for _, s := range snaps {
+		modified := snapshotModTime(s.Dir)
+		g := Garbage{Version: s.Version, Paths: []string{s.Dir}, SizeBytes: s.SizeBytes, Modified: modified}
+		version, _ := SplitSnapshotKey(s.Version)
+		switch {
+		case kept(s.Version) || !old(modified):
+		case !s.Complete:
+			g.Kind, g.Reason = GarbageIncomplete, "snapshot is incomplete"
+		case policy.KeepLatest > 0 && !latest[version]:
+			g.Kind, g.Reason = GarbageSnapshot, fmt.Sprintf("not one of the %d newest versions", policy.KeepLatest)
+		case policy.KeepLatest == 0 && policy.OlderThan > 0:
+			g.Kind, g.Reason = GarbageSnapshot, fmt.Sprintf("unused since %s", modified.Format(time.DateOnly))
+		}
+		if g.Kind == "" {
+			current[s.Version] = true
+			continue
+		}
+		garbage = append(garbage, g)
+	}
+
+	images := rootfsImages(paths)
+	for _, key := range slices.Sorted(maps.Keys(images)) {
+		files := images[key]
+		g := Garbage{Kind: GarbageRootfs, Version: key, Paths: files}
+		for _, f := range files {
+			g.SizeBytes += DiskUsage(f)
+			if t := newestModTime(f); t.After(g.Modified) {
+				g.Modified = t
+			}
+		}
+		if current[key] || kept(key) || !old(g.Modified) {
+			continue
+		}
+		g.Reason = "no snapshot uses it"
+		garbage = append(garbage, g)
+	}
+	return garbage
+}
+
+// RemoveGarbage removes g. The snapshot of a version keeps the snapshots
+// of its profiles.
+func RemoveGarbage(paths *VMPaths, g Garbage) error {
+	switch g.Kind {
+	case GarbageSnapshot, GarbageIncomplete:
+		return DeleteSnapshot(paths, g.Version)
+	case GarbageInstance:
+		return RemoveInstanceDir(g.Paths[0])
+	default:
+		for _, p := range g.Paths {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// latestVersions returns the n newest versions with a complete snapshot.
+func latestVersions(snaps []SnapshotInfo, n int) map[string]bool {
+	var all []string
+	for _, s := range snaps {
+		version, _ := SplitSnapshotKey(s.Version)
+		if s.Complete && !slices.Contains(all, version) {
+			all = append(all, version)
+		}
+	}
+	versions.SortVersionsDesc(all)
+	latest := map[string]bool{}
+	for _, v := range all[:min(n, len(all))] {
+		latest[v] = true
+	}
+	return latest
+}
+
+// rootfsImages returns the files of each rootfs image in the rootfs
+// directory (the image, its package manifest and requirements), by
+// snapshot key.
+func rootfsImages(paths *VMPaths) map[string][]string {
+	images, _ := filepath.Glob(filepath.Join(paths.RootfsDir, "deephaven-*.ext4"))
+	out := map[string][]string{}
+	for _, img := range images {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(img), "deephaven-"), ".ext4")
+		key := strings.Replace(name, "+", "/", 1)
+		files := []string{img}
+		for _, f := range []string{paths.ManifestForVersion(key), paths.RequirementsForVersion(key)} {
+			if _, err := os.Stat(f); err == nil {
+				files = append(files, f)
+			}
+		}
+		out[key] = files
+	}
+	return out
+}
+
+// snapshotModTime returns when a snapshot directory last changed: the
+// newest of the directory and its files, not counting profile
+// directories. Restoring a snapshot touches the directory, so this is
+// also when it was last used.
+func snapshotModTime(dir string) time.Time {
+	var t time.Time
+	if fi, err := os.Stat(dir); err == nil {
+		t = fi.ModTime()
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if fi, err := e.Info(); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t
+}
+
+// newestModTime returns the newest modification time in the tree at path.
+func newestModTime(path string) time.Time {
+	var t time.Time
+	filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+		return nil
+	})
+	return t
+}
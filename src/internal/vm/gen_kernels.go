@@ -0,0 +1,60 @@
+//go:build ignore
+
+// gen_kernels pins the newest 6.1 CI kernel of each architecture in
+// kernels.json, with the SHA-256 of the file downloaded now. It needs
+// Linux and network access: go generate ./internal/vm
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+)
+
+func main() {
+	kernels := map[string]vm.PinnedKernel{}
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		key, err := vm.LatestCIKernel(arch)
+		if err != nil {
+			log.Fatalf("%s: %v", arch, err)
+		}
+		url := "https://s3.amazonaws.com/spec.ccfc.min/" + key
+		sum, err := sha256URL(url)
+		if err != nil {
+			log.Fatalf("%s: %v", arch, err)
+		}
+		kernels[arch] = vm.PinnedKernel{Name: path.Base(key), URL: url, SHA256: sum}
+		fmt.Printf("%s: %s %s\n", arch, url, sum)
+	}
+	data, err := json.MarshalIndent(kernels, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("kernels.json", append(data, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func sha256URL(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
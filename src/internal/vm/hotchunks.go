@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// hotChunksFile, in a snapshot directory, lists the chunks of snapshot
+// memory that restored VMs faulted in. The next restore copies exactly
+// those before the VM resumes, instead of the first EagerMB MiB. Each
+// VM adds the chunks it faulted in when its UFFD handler closes.
+const hotChunksFile = "hot_chunks.json"
+
+// maxHotChunks bounds the hot set, so a VM that touched most of its
+// memory doesn't make every restore copy all of it.
+const maxHotChunks = 1024
+
+// hotChunks is the content of hotChunksFile.
+type hotChunks struct {
+	ChunkSize uint64   `json:"chunk_size"`
+	Offsets   []uint64 `json:"offsets"` // snapshot memory file offsets, sorted
+}
+
+// readHotChunks returns the hot chunk offsets of the snapshot in snapDir,
+// or nil when none were recorded with chunkSize.
+func readHotChunks(snapDir string, chunkSize uint64) []uint64 {
+	data, err := os.ReadFile(filepath.Join(snapDir, hotChunksFile))
+	if err != nil {
+		return nil
+	}
+	var hot hotChunks
+	if json.Unmarshal(data, &hot) != nil || hot.ChunkSize != chunkSize {
+		return nil
+	}
+	return hot.Offsets
+}
+
+// addHotChunks adds offsets to the hot set of the snapshot in snapDir,
+// keeping the lowest maxHotChunks.
+func addHotChunks(snapDir string, chunkSize uint64, offsets []uint64) error {
+	all := append(readHotChunks(snapDir, chunkSize), offsets...)
+	slices.Sort(all)
+	all = slices.Compact(all)
+	if len(all) > maxHotChunks {
+		all = all[:maxHotChunks]
+	}
+	data, err := json.Marshal(hotChunks{ChunkSize: chunkSize, Offsets: all})
+	if err != nil {
+		return err
+	}
+	// Concurrent VMs, also of one pool daemon, each write a whole file and
+	// rename it into place; a lost update is only learned again.
+	path := filepath.Join(snapDir, hotChunksFile)
+	tmp, err := os.CreateTemp(snapDir, hotChunksFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// extentsOverlap reports whether [off, off+length) holds any snapshot
+// data, given its sorted data extents.
+func extentsOverlap(extents []dataExtent, off, length uint64) bool {
+	for _, ext := range extents {
+		if ext.offset >= off+length {
+			return false
+		}
+		if ext.offset+ext.length > off {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// hugePagesMachineHandler configures the machine with its guest memory
+// backed by 2 MiB huge pages. It replaces the SDK's handler, whose machine
+// configuration has no huge_pages.
+func hugePagesMachineHandler() firecracker.Handler {
+	return firecracker.Handler{
+		Name: firecracker.CreateMachineHandlerName,
+		Fn: func(ctx context.Context, m *firecracker.Machine) error {
+			mc := m.Cfg.MachineCfg
+			body, err := json.Marshal(map[string]any{
+				"vcpu_count":   mc.VcpuCount,
+				"mem_size_mib": mc.MemSizeMib,
+				"huge_pages":   fmt.Sprintf("%dM", hugePageMiB),
+			})
+			if err != nil {
+				return err
+			}
+			return putFirecrackerAPI(ctx, m.Cfg.SocketPath, "/machine-config", body)
+		},
+	}
+}
+
+// putFirecrackerAPI sends a PUT request with a JSON body to the
+// Firecracker API at socketPath.
+func putFirecrackerAPI(ctx context.Context, socketPath, path string, body []byte) error {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://localhost"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("PUT %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("PUT %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+)
+
+func TestHugePagesMachineHandler(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "firecracker.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]any{}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/machine-config" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	m := &firecracker.Machine{Cfg: firecracker.Config{
+		SocketPath: socketPath,
+		MachineCfg: models.MachineConfiguration{VcpuCount: firecracker.Int64(2), MemSizeMib: firecracker.Int64(4608)},
+	}}
+	h := hugePagesMachineHandler()
+	if h.Name != firecracker.CreateMachineHandlerName {
+		t.Errorf("handler name = %q", h.Name)
+	}
+	if err := h.Fn(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if got["huge_pages"] != "2M" || got["mem_size_mib"] != 4608.0 || got["vcpu_count"] != 2.0 {
+		t.Errorf("machine config = %v", got)
+	}
+
+	if err := putFirecrackerAPI(context.Background(), socketPath, "/balloon", []byte("{}")); err == nil {
+		t.Error("expected an error for a rejected request")
+	}
+}
+
+func TestMemRegionPageSize(t *testing.T) {
+	for _, tc := range []struct {
+		region memRegion
+		want   uint64
+	}{
+		{memRegion{PageSize: 2 << 20}, 2 << 20},
+		{memRegion{PageSizeKiB: 4096}, 4096},
+		{memRegion{}, hostPageSize},
+	} {
+		if got := tc.region.pageSize(); got != tc.want {
+			t.Errorf("%+v.pageSize() = %d, want %d", tc.region, got, tc.want)
+		}
+	}
+}
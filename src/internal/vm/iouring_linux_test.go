@@ -0,0 +1,53 @@
+//go:build linux
+
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarmWithIoURing(t *testing.T) {
+	r, err := newIoURing(ioURingDepth)
+	if err != nil {
+		t.Skipf("io_uring not available: %v", err)
+	}
+	r.Close()
+
+	path := filepath.Join(t.TempDir(), "snapshot_mem")
+	data := make([]byte, 10*ioURingReadSize+4096)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// More reads than buffers, and extents that end mid-read.
+	extents := []dataExtent{
+		{offset: 0, length: 4096},
+		{offset: 8192, length: 6*ioURingReadSize + 100},
+		{offset: 7 * ioURingReadSize, length: 3*ioURingReadSize + 4096},
+	}
+	for range 10 {
+		extents = append(extents, dataExtent{offset: 4096, length: 4096})
+	}
+	if err := warmWithIoURing(f, extents); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := warmWithIoURing(w, extents); err == nil {
+		t.Error("expected an error reading a write-only file")
+	}
+}
@@ -0,0 +1,147 @@
+//go:build linux
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// defaultJailID is the user and group jailed VMMs run as unless
+// DH_VM_JAIL_UID and DH_VM_JAIL_GID say otherwise: nobody/nogroup.
+const defaultJailID = 65534
+
+// vmJail is the jailer setup of one VM. The jailer chroots Firecracker
+// into root, so every path Firecracker is given (API socket, drives,
+// vsock, snapshot files) is inside it; on the host they are under root.
+type vmJail struct {
+	id   string
+	root string // the chroot, on the host
+	uid  int
+	gid  int
+}
+
+// newJail checks that a jailed VM can be started and creates its chroot,
+// owned by the jail user so Firecracker can create its sockets there.
+func newJail(paths *VMPaths, id string) (*vmJail, error) {
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("--jailed needs root: the jailer chroots Firecracker, sets up its cgroup and drops to DH_VM_JAIL_UID")
+	}
+	if _, err := os.Stat(paths.Jailer); err != nil {
+		return nil, fmt.Errorf("jailer not found at %s; run: dh vm prepare --jailed", paths.Jailer)
+	}
+	uid, err := jailIDFromEnv("DH_VM_JAIL_UID")
+	if err != nil {
+		return nil, err
+	}
+	gid, err := jailIDFromEnv("DH_VM_JAIL_GID")
+	if err != nil {
+		return nil, err
+	}
+
+	j := &vmJail{id: id, root: jailRoot(paths, id), uid: uid, gid: gid}
+	if err := os.MkdirAll(j.root, 0o755); err != nil {
+		return nil, fmt.Errorf("creating jail: %w", err)
+	}
+	if err := os.Chown(j.root, uid, gid); err != nil {
+		os.RemoveAll(filepath.Dir(j.root))
+		return nil, fmt.Errorf("creating jail: %w", err)
+	}
+	return j, nil
+}
+
+func jailIDFromEnv(name string) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultJailID, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-root numeric ID", name, v)
+	}
+	return id, nil
+}
+
+// jailRoot returns the chroot of jailed VM id on the host, as the jailer
+// lays it out: <base>/<exec file name>/<id>/root.
+func jailRoot(paths *VMPaths, id string) string {
+	return filepath.Join(paths.JailDir, "firecracker", id, "root")
+}
+
+// hostPath returns where the in-jail path p is on the host.
+func (j *vmJail) hostPath(p string) string {
+	return filepath.Join(j.root, p)
+}
+
+// link hard-links src into the jail as name, owned by the jail user when
+// Firecracker has to write it. The jail and ~/.dh/vm share a filesystem.
+func (j *vmJail) link(src, name string, writable bool) error {
+	dst := j.hostPath(name)
+	if err := os.Link(src, dst); err != nil {
+		return fmt.Errorf("linking %s into the jail: %w", filepath.Base(src), err)
+	}
+	if writable {
+		return os.Chown(dst, j.uid, j.gid)
+	}
+	return nil
+}
+
+// share lets the jailed Firecracker connect to the host socket at path.
+func (j *vmJail) share(path string) error {
+	return os.Chown(path, j.uid, j.gid)
+}
+
+// config returns the SDK jailer config. Files are put in the chroot before
+// the VM starts, so the chroot strategy has nothing left to do.
+func (j *vmJail) config(paths *VMPaths, stderr io.Writer) *firecracker.JailerConfig {
+	cgroupVersion := "1"
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		cgroupVersion = "2"
+	}
+	return &firecracker.JailerConfig{
+		UID:            firecracker.Int(j.uid),
+		GID:            firecracker.Int(j.gid),
+		ID:             j.id,
+		NumaNode:       firecracker.Int(0),
+		ExecFile:       paths.Firecracker,
+		JailerBinary:   paths.Jailer,
+		ChrootBaseDir:  paths.JailDir,
+		ChrootStrategy: prepopulatedJail{},
+		CgroupVersion:  cgroupVersion,
+		Stdout:         stderr,
+		Stderr:         stderr,
+	}
+}
+
+// prepopulatedJail is a chroot strategy for jails whose files are already
+// in place.
+type prepopulatedJail struct{}
+
+func (prepopulatedJail) AdaptHandlers(*firecracker.Handlers) error { return nil }
+
+// removeJail deletes the chroot and cgroup of jailed VM id once its
+// Firecracker process is gone. It is a no-op for VMs that weren't jailed.
+func removeJail(paths *VMPaths, id string) {
+	dir := filepath.Dir(jailRoot(paths, id))
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+	os.RemoveAll(dir)
+	// The cgroup can only be removed once its last process has exited,
+	// which may be shortly after StopVMM returns.
+	v1, _ := filepath.Glob(filepath.Join("/sys/fs/cgroup/*/firecracker", id))
+	for _, cg := range append(v1, filepath.Join("/sys/fs/cgroup/firecracker", id)) {
+		for i := 0; i < 10; i++ {
+			if err := os.Remove(cg); err == nil || os.IsNotExist(err) {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build linux
+
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJailIDFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		ok    bool
+	}{
+		{"", defaultJailID, true},
+		{"1000", 1000, true},
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"nobody", 0, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("DH_VM_JAIL_UID", tt.value)
+		got, err := jailIDFromEnv("DH_VM_JAIL_UID")
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("jailIDFromEnv(%q) = %d, %v; want %d, ok=%v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestJailRoot(t *testing.T) {
+	paths := NewVMPaths("/home/u/.dh")
+	want := "/home/u/.dh/vm/jail/firecracker/exec-1/root"
+	if got := jailRoot(paths, "exec-1"); got != want {
+		t.Errorf("jailRoot = %q, want %q", got, want)
+	}
+	j := &vmJail{root: want}
+	if got := j.hostPath("/vsock.sock"); got != want+"/vsock.sock" {
+		t.Errorf("hostPath = %q", got)
+	}
+}
+
+func TestNewJail_NeedsRoot(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("running as root")
+	}
+	paths := NewVMPaths(t.TempDir())
+	if _, err := newJail(paths, "exec-1"); err == nil {
+		t.Fatal("expected an error when not root")
+	}
+	if _, err := os.Stat(filepath.Dir(jailRoot(paths, "exec-1"))); !os.IsNotExist(err) {
+		t.Error("failed newJail left a jail behind")
+	}
+}
+
+func TestRemoveJail(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	root := jailRoot(paths, "exec-1")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "disk.ext4"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	removeJail(paths, "exec-1")
+	if _, err := os.Stat(filepath.Dir(root)); !os.IsNotExist(err) {
+		t.Errorf("jail still exists: %v", err)
+	}
+	removeJail(paths, "exec-2") // no jail: no-op
+}
@@ -0,0 +1,47 @@
+package vm
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+//go:generate go run gen_kernels.go
+
+// kernelsJSON pins the guest kernel dh vm prepare downloads for each
+// architecture (as Firecracker names it), with its SHA-256. Regenerate it
+// with go generate on a Linux host when moving to a new kernel.
+//
+//go:embed kernels.json
+var kernelsJSON []byte
+
+// PinnedKernel is a guest kernel build and where to download it.
+type PinnedKernel struct {
+	Name   string `json:"name"` // e.g. vmlinux-6.1.141
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Validate checks that k can be downloaded and verified.
+func (k PinnedKernel) Validate() error {
+	if k.URL == "" {
+		return fmt.Errorf("pinned kernel %s has no URL", k.Name)
+	}
+	if !sha256Hex.MatchString(k.SHA256) {
+		return fmt.Errorf("pinned kernel %s has an invalid SHA-256 %q", k.Name, k.SHA256)
+	}
+	return nil
+}
+
+// PinnedKernels returns the kernels pinned in this dh build, by
+// architecture.
+func PinnedKernels() (map[string]PinnedKernel, error) {
+	kernels := map[string]PinnedKernel{}
+	if err := json.Unmarshal(kernelsJSON, &kernels); err != nil {
+		return nil, fmt.Errorf("parsing kernel manifest: %w", err)
+	}
+	return kernels, nil
+}
@@ -0,0 +1,216 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every VM's serial console (the kernel, init.sh, the Deephaven server and
+// the runner daemon) is written to serial.log in its instance directory.
+// When the instance is torn down the log moves to the logs directory as
+// ID.log, where the newest maxInstanceLogs are kept.
+
+// maxInstanceLogs is how many logs of finished instances are kept.
+const maxInstanceLogs = 50
+
+// serialLogName is the console log in an instance directory.
+const serialLogName = "serial.log"
+
+// runnerLogPrefix starts the lines vm_runner.py writes to the console.
+const runnerLogPrefix = "RUNNER: "
+
+// InstanceLog is the console log of a VM instance.
+type InstanceLog struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Running   bool      `json:"running"` // still in the run directory
+	Modified  time.Time `json:"modified"`
+	SizeBytes int64     `json:"size_bytes"`
+}
+
+// serialLog returns the console log of a running instance.
+func (p *VMPaths) serialLog(instanceID string) string {
+	return filepath.Join(p.InstanceDir(instanceID), serialLogName)
+}
+
+// openSerialLog creates the console log of an instance.
+func openSerialLog(paths *VMPaths, instanceID string) (*os.File, error) {
+	f, err := os.OpenFile(paths.serialLog(instanceID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("creating serial log: %w", err)
+	}
+	return f, nil
+}
+
+// ListInstanceLogs returns the console logs of running and finished
+// instances, newest first.
+func ListInstanceLogs(paths *VMPaths) []InstanceLog {
+	var logs []InstanceLog
+	running, _ := filepath.Glob(filepath.Join(paths.RunDir, "*", serialLogName))
+	for _, path := range running {
+		logs = appendInstanceLog(logs, filepath.Base(filepath.Dir(path)), path, true)
+	}
+	finished, _ := filepath.Glob(filepath.Join(paths.LogDir, "*.log"))
+	for _, path := range finished {
+		logs = appendInstanceLog(logs, strings.TrimSuffix(filepath.Base(path), ".log"), path, false)
+	}
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Modified.After(logs[j].Modified) })
+	return logs
+}
+
+func appendInstanceLog(logs []InstanceLog, id, path string, running bool) []InstanceLog {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return logs
+	}
+	return append(logs, InstanceLog{ID: id, Path: path, Running: running, Modified: fi.ModTime(), SizeBytes: fi.Size()})
+}
+
+// FindInstanceLog returns the console log of instance id, or of the
+// newest instance when id is "".
+func FindInstanceLog(paths *VMPaths, id string) (InstanceLog, error) {
+	logs := ListInstanceLogs(paths)
+	if id == "" {
+		if len(logs) == 0 {
+			return InstanceLog{}, fmt.Errorf("no VM logs in %s", paths.LogDir)
+		}
+		return logs[0], nil
+	}
+	for _, l := range logs {
+		if l.ID == id {
+			return l, nil
+		}
+	}
+	return InstanceLog{}, fmt.Errorf("no log for VM instance %s (see dh vm logs)", id)
+}
+
+// RemoveInstanceDir deletes an instance directory of the run directory,
+// keeping its console log in the logs directory.
+func RemoveInstanceDir(dir string) error {
+	archiveSerialLog(dir)
+	return os.RemoveAll(dir)
+}
+
+// archiveSerialLog moves the console log of an instance directory to the
+// logs directory (next to the run directory) and drops the oldest logs
+// there beyond maxInstanceLogs.
+func archiveSerialLog(dir string) {
+	logDir := filepath.Join(filepath.Dir(filepath.Dir(dir)), "logs")
+	src := filepath.Join(dir, serialLogName)
+	if fi, err := os.Stat(src); err != nil || fi.Size() == 0 {
+		return
+	}
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		return
+	}
+	if os.Rename(src, filepath.Join(logDir, filepath.Base(dir)+".log")) != nil {
+		return
+	}
+
+	old, _ := filepath.Glob(filepath.Join(logDir, "*.log"))
+	if len(old) <= maxInstanceLogs {
+		return
+	}
+	modified := map[string]time.Time{}
+	for _, path := range old {
+		if fi, err := os.Stat(path); err == nil {
+			modified[path] = fi.ModTime()
+		}
+	}
+	sort.Slice(old, func(i, j int) bool { return modified[old[i]].After(modified[old[j]]) })
+	for _, path := range old[maxInstanceLogs:] {
+		os.Remove(path)
+	}
+}
+
+// runnerLines writes to w only the lines of the runner daemon, without
+// their prefix. Call Flush at the end for a last unterminated line.
+type runnerLines struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (r *runnerLines) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	for {
+		i := bytes.IndexByte(r.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := r.writeLine(r.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		r.buf = r.buf[i+1:]
+	}
+}
+
+func (r *runnerLines) writeLine(line []byte) error {
+	line = bytes.TrimLeft(line, "\r")
+	if rest, ok := bytes.CutPrefix(line, []byte(runnerLogPrefix)); ok {
+		_, err := r.w.Write(bytes.ReplaceAll(rest, []byte("\r\n"), []byte("\n")))
+		return err
+	}
+	return nil
+}
+
+// Flush writes a last line that has no newline.
+func (r *runnerLines) Flush() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	err := r.writeLine(append(r.buf, '\n'))
+	r.buf = nil
+	return err
+}
+
+// WriteInstanceLog copies a console log to w, only the runner daemon's
+// lines if runnerOnly. With follow it then keeps copying what is added
+// until the instance is torn down or ctx is done.
+func WriteInstanceLog(ctx context.Context, log InstanceLog, w io.Writer, runnerOnly, follow bool) error {
+	out := w
+	var runner *runnerLines
+	if runnerOnly {
+		runner = &runnerLines{w: w}
+		out = runner
+	}
+
+	f, err := os.Open(log.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		if _, err := io.Copy(out, r); err != nil {
+			return err
+		}
+		if !follow || !log.Running {
+			break
+		}
+		// The log moves to the logs directory when the instance is torn
+		// down; the open file still has its last writes.
+		if _, err := os.Stat(log.Path); err != nil {
+			if _, err := io.Copy(out, r); err != nil {
+				return err
+			}
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	if runner != nil {
+		return runner.Flush()
+	}
+	return nil
+}
@@ -4,17 +4,22 @@ package vm
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/dsmmcken/dh-cli/src/internal/output"
 	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
 	log "github.com/sirupsen/logrus"
@@ -28,22 +33,100 @@ const (
 
 	// VsockPort is the port inside the VM where the runner daemon listens.
 	VsockPort = 10000
+
+	// vsockInterruptGrace is how long an interrupted request waits for the
+	// runner's response before giving up on it.
+	vsockInterruptGrace = 5 * time.Second
+
+	// VsockProtocol is the version of the host-runner protocol this dh
+	// speaks, sent in the hello ahead of each request.
+	VsockProtocol = 1
+
+	// vsockHelloTimeout bounds the wait for the runner's answer to a hello.
+	vsockHelloTimeout = 10 * time.Second
+)
+
+// Optional runner features, listed in its hello. Requests that use one a
+// runner lacks are sent without it, so the run goes ahead without the
+// feature, as it does with runners from before the hello.
+const (
+	FeatureStream         = "stream"          // VsockRequest.Stream
+	FeatureCancel         = "cancel"          // cancel frames
+	FeatureCollectOutputs = "collect_outputs" // VsockRequest.CollectOutputs
+	FeatureFUSE           = "fuse"            // VsockRequest.MountMode "fuse"
+	FeatureTableFormat    = "table_format"    // VsockRequest.TableFormat
+	FeatureShell          = "shell"           // shell requests
+	FeatureTableOutput    = "table_output"    // VsockRequest.TableOutput, MaxRows and MaxCols
+
+	// FeatureImportTables is VsockRequest.ImportTables. A request with
+	// tables to import fails on a runner without it, rather than running
+	// code that expects them.
+	FeatureImportTables = "import_tables"
+
+	// FeatureExportTables is VsockRequest.ExportTables. Like imports, a
+	// request with tables to export fails on a runner without it.
+	FeatureExportTables = "export_tables"
+
+	// FeatureReadOnlyRoot is not a request feature: the runner lists it
+	// when the guest runs on an overlay of the read-only disk.
+	FeatureReadOnlyRoot = "read_only_root"
 )
 
+// kernelArgs returns the guest kernel command line for a Go architecture.
+// On aarch64 the early console is kept, as Firecracker recommends, so boot
+// messages still reach the serial port.
+func kernelArgs(goarch string) string {
+	args := "console=ttyS0 reboot=k panic=1 pci=off init=/sbin/init.sh"
+	if goarch == "arm64" {
+		args = "keep_bootcon " + args
+	}
+	return args
+}
+
 // BootAndSnapshot boots a fresh VM, waits for Deephaven readiness,
 // then pauses and creates a snapshot. Used by `dh vm prepare`.
 func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr io.Writer) error {
 	version := cfg.Version
-	rootfsPath := paths.RootfsForVersion(version)
-	snapDir := paths.SnapshotDirForVersion(version)
+	key := SnapshotKey(version, cfg.Profile)
+	rootfsPath := paths.RootfsForVersion(key)
+	snapDir := paths.SnapshotDirForVersion(key)
+
+	if cfg.HugePages {
+		if !ProbeUffd() {
+			return fmt.Errorf("a --hugepages snapshot only restores with userfaultfd (sudo sysctl -w vm.unprivileged_userfaultfd=1)")
+		}
+		_, memMiB := cfg.machineSize()
+		if err := checkHugePages(memMiB); err != nil {
+			return err
+		}
+	}
+
+	// VMs being restored from the snapshot being replaced finish first, and
+	// others wait for the new one.
+	unlock, err := lockSnapshot(paths, key, true, stderr)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	if err := os.MkdirAll(snapDir, 0o755); err != nil {
 		return fmt.Errorf("creating snapshot dir: %w", err)
 	}
-
-	// Copy rootfs as the backing disk for the snapshot
+	// A memory file from an earlier prepare with the other --compress
+	// setting would shadow (or be shadowed by) the new one.
+	os.Remove(filepath.Join(snapDir, "snapshot_mem"))
+	os.Remove(filepath.Join(snapDir, CompressedMemFile))
+	// Chunks the old snapshot's VMs faulted in say nothing about the new one.
+	os.Remove(filepath.Join(snapDir, hotChunksFile))
+
+	// Copy rootfs as the backing disk for the snapshot. The copy is renamed
+	// into place: VMs of the old snapshot may still have its disk attached.
 	diskPath := filepath.Join(snapDir, "disk.ext4")
-	if err := copyFile(rootfsPath, diskPath); err != nil {
+	if err := copyFile(rootfsPath, diskPath+".tmp"); err != nil {
+		os.Remove(diskPath + ".tmp")
+		return fmt.Errorf("copying rootfs for snapshot: %w", err)
+	}
+	if err := os.Rename(diskPath+".tmp", diskPath); err != nil {
 		return fmt.Errorf("copying rootfs for snapshot: %w", err)
 	}
 
@@ -53,33 +136,84 @@ func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr
 	if err := os.MkdirAll(instanceDir, 0o755); err != nil {
 		return fmt.Errorf("creating instance dir: %w", err)
 	}
-	defer os.RemoveAll(instanceDir)
+	defer RemoveInstanceDir(instanceDir)
+	serialLog, err := openSerialLog(paths, instanceID)
+	if err != nil {
+		return err
+	}
+	defer serialLog.Close()
+	console := io.MultiWriter(stderr, serialLog)
 
 	socketPath := filepath.Join(instanceDir, "firecracker.sock")
-	// Vsock UDS path goes in the snapshot directory because Firecracker embeds
-	// the absolute path in the snapshot binary state. On restore, it re-binds
-	// at that same path, so it must still be valid (not in a temp instance dir).
-	vsockPath := filepath.Join(snapDir, "vsock.sock")
-
-	// Configure Firecracker — uses vsock for host-VM communication (no TAP needed)
-	vcpuCount := int64(DefaultVCPUCount)
-	memSize := int64(DefaultMemSizeMiB)
+	// Firecracker embeds the vsock UDS path in the snapshot state and re-binds
+	// at that same path on restore, where guest connections also go to
+	// {path}_{port}. The path is relative, and Firecracker runs in the
+	// instance directory, so every restored VM has sockets of its own.
+	vsockPath := filepath.Join(instanceDir, "vsock.sock")
+	kernelPath, diskArg, vsockArg := paths.Kernel, diskPath, "vsock.sock"
+
+	// Under the jailer Firecracker only sees its chroot, so it is given
+	// in-jail paths; those embedded in the snapshot stay valid in the chroot
+	// of every jailed restore. The host reaches the sockets through the jail.
+	var jail *vmJail
+	if cfg.Jailed {
+		if jail, err = newJail(paths, instanceID); err != nil {
+			return err
+		}
+		defer removeJail(paths, instanceID)
+		if err := jail.link(paths.Kernel, "vmlinux", false); err != nil {
+			return err
+		}
+		if err := jail.link(diskPath, "disk.ext4", true); err != nil {
+			return err
+		}
+		socketPath = "/firecracker.sock"
+		kernelPath, diskArg, vsockArg = "/vmlinux", "/disk.ext4", "/vsock.sock"
+		vsockPath = jail.hostPath(vsockArg)
+	}
+
+	// With --net=nat Firecracker runs in a network namespace of its own,
+	// where the VM's tap device is, and init.sh configures the guest's end.
+	netIndex, netArgs := 0, ""
+	if cfg.Net == NetNAT {
+		if netIndex, err = newVMNet(); err != nil {
+			return err
+		}
+		defer removeVMNet(netIndex)
+		netArgs = netKernelArgs(cfg.Net, guestNameserver())
+	}
+
+	// Unless the root is writable, init.sh puts the guest on an overlay of
+	// the disk, which Firecracker attaches read-only.
+	rootArgs := ""
+	if !cfg.WritableRoot {
+		rootArgs = " dh_ro_root=1"
+	}
+
+	// Configure Firecracker — uses vsock for host-VM communication, and a
+	// TAP device only with --net=nat
+	vcpus, memMiB := cfg.machineSize()
+	vcpuCount := int64(vcpus)
+	memSize := int64(memMiB)
 	fcCfg := firecracker.Config{
 		SocketPath:      socketPath,
-		KernelImagePath: paths.Kernel,
-		KernelArgs:      "console=ttyS0 reboot=k panic=1 pci=off init=/sbin/init.sh",
+		KernelImagePath: kernelPath,
+		// The kernel passes dh_heap_mib to init.sh as an environment
+		// variable; it sizes the JVM heap to the VM. dh_jvm_args adds
+		// --guest-jvm-args.
+		KernelArgs: fmt.Sprintf("%s dh_heap_mib=%d%s%s%s", kernelArgs(runtime.GOARCH), heapSizeMiB(memMiB), guestJVMArgsParam(cfg.GuestJVMArgs), netArgs, rootArgs),
 		Drives: []models.Drive{
 			{
 				DriveID:      firecracker.String("rootfs"),
-				PathOnHost:   firecracker.String(diskPath),
+				PathOnHost:   firecracker.String(diskArg),
 				IsRootDevice: firecracker.Bool(true),
-				IsReadOnly:   firecracker.Bool(false),
+				IsReadOnly:   firecracker.Bool(!cfg.WritableRoot),
 			},
 		},
 		VsockDevices: []firecracker.VsockDevice{
 			{
 				ID:   "vsock0",
-				Path: vsockPath,
+				Path: vsockArg,
 				CID:  VsockCID,
 			},
 		},
@@ -89,35 +223,58 @@ func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr
 		},
 	}
 
-	if cfg.Verbose {
-		fmt.Fprintf(stderr, "Booting VM (kernel=%s, rootfs=%s)...\n", paths.Kernel, diskPath)
+	if netIndex > 0 {
+		fcCfg.NetNS = netNamespacePath(netIndex)
+		fcCfg.NetworkInterfaces = firecracker.NetworkInterfaces{{
+			StaticConfiguration: &firecracker.StaticNetworkConfiguration{
+				MacAddress:  netGuestMAC,
+				HostDevName: netTapName,
+			},
+		}}
 	}
 
-	// Build command — capture serial console output to stderr
-	fcCmd := firecracker.VMCommandBuilder{}.
-		WithBin(paths.Firecracker).
-		WithSocketPath(socketPath).
-		WithStdout(stderr).
-		WithStderr(stderr).
-		Build(ctx)
+	if cfg.Verbose {
+		fmt.Fprintf(stderr, "Booting VM (kernel=%s, rootfs=%s, vcpus=%d, memory=%dMiB)...\n", paths.Kernel, diskPath, vcpus, memMiB)
+	}
 
 	logger := log.New()
 	logger.SetLevel(log.WarnLevel)
+	opts := []firecracker.Opt{firecracker.WithLogger(log.NewEntry(logger))}
 
-	machine, err := firecracker.NewMachine(ctx, fcCfg,
-		firecracker.WithProcessRunner(fcCmd),
-		firecracker.WithLogger(log.NewEntry(logger)),
-	)
+	if jail != nil {
+		// The SDK runs Firecracker through the jailer, which passes the
+		// serial console output on to stderr and the log.
+		fcCfg.JailerCfg = jail.config(paths, console)
+		fcCfg.Seccomp.Enabled = true
+	} else {
+		// Build command — capture serial console output to stderr and the log
+		fcCmd := firecracker.VMCommandBuilder{}.
+			WithBin(paths.Firecracker).
+			WithSocketPath(socketPath).
+			WithStdout(console).
+			WithStderr(console).
+			Build(ctx)
+		fcCmd.Dir = instanceDir
+		opts = append(opts, firecracker.WithProcessRunner(fcCmd))
+	}
+
+	machine, err := firecracker.NewMachine(ctx, fcCfg, opts...)
 	if err != nil {
 		return fmt.Errorf("creating firecracker machine: %w", err)
 	}
 
-	// Add balloon device handler before Start() — Firecracker requires balloon
-	// to be configured before InstanceStart. Start with amount=0 (don't reclaim
-	// anything yet), deflateOnOom=true so the guest can reclaim memory later.
-	machine.Handlers.FcInit = machine.Handlers.FcInit.Append(
-		firecracker.NewCreateBalloonHandler(0, true, 0),
-	)
+	if cfg.HugePages {
+		// Firecracker has no balloon device for huge page memory, so the
+		// snapshot is not made sparse by inflating one.
+		machine.Handlers.FcInit = machine.Handlers.FcInit.Swap(hugePagesMachineHandler())
+	} else {
+		// Add balloon device handler before Start() — Firecracker requires balloon
+		// to be configured before InstanceStart. Start with amount=0 (don't reclaim
+		// anything yet), deflateOnOom=true so the guest can reclaim memory later.
+		machine.Handlers.FcInit = machine.Handlers.FcInit.Append(
+			firecracker.NewCreateBalloonHandler(0, true, 0),
+		)
+	}
 
 	if err := machine.Start(ctx); err != nil {
 		return fmt.Errorf("starting VM: %w", err)
@@ -130,12 +287,18 @@ func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr
 	// pydeephaven Session to Deephaven, so a successful vsock connection means
 	// both the server and the warm session are ready.
 	if err := waitForVsock(ctx, vsockPath, VsockPort, 120*time.Second); err != nil {
-		return fmt.Errorf("runner daemon not reachable via vsock within 120s: %w", err)
+		return fmt.Errorf("runner daemon not reachable via vsock within 120s: %w (see the console with: dh vm logs --last)", err)
 	}
 
 	// Give the runner daemon a moment to fully enter its accept loop
 	time.Sleep(500 * time.Millisecond)
 
+	if !cfg.WritableRoot {
+		if err := checkReadOnlyRoot(vsockPath); err != nil {
+			return err
+		}
+	}
+
 	// Warm up the JVM by running progressively complex scripts through the
 	// full execution pipeline. This triggers C2 JIT compilation of Deephaven's
 	// run_script, table creation, Arrow serialization, and pickle/base64 code
@@ -176,28 +339,31 @@ func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr
 		fmt.Fprintf(stderr, "  warmup %d/20%s\n", i+1, runMs)
 	}
 
-	// Inflate balloon to reclaim unused guest memory before snapshotting.
-	// JVM starts with -Xms32m -XX:-AlwaysPreTouch so G1 only commits
-	// heap regions on demand. After warmup, committed memory is much
-	// lower (~200-400MB). Leave 512 MiB headroom for kernel + JVM +
-	// Python residual. deflateOnOom=true reclaims on demand after restore.
-	balloonMiB := int64(DefaultMemSizeMiB - 512)
-	fmt.Fprintf(stderr, "Inflating balloon to %d MiB to reclaim unused pages...\n", balloonMiB)
-	if err := machine.UpdateBalloon(ctx, balloonMiB); err != nil {
-		return fmt.Errorf("inflating balloon: %w", err)
-	}
-	// Wait for guest balloon driver to reclaim pages
-	time.Sleep(3 * time.Second)
-
-	// Deflate balloon back to 0 before snapshotting. The inflation already
-	// caused MADV_DONTNEED on reclaimed pages (they're now zeros). Deflating
-	// returns those page ranges to the guest so the restored VM has full
-	// memory available without needing deflateOnOom to kick in.
-	fmt.Fprintf(stderr, "Deflating balloon before snapshot...\n")
-	if err := machine.UpdateBalloon(ctx, 0); err != nil {
-		return fmt.Errorf("deflating balloon: %w", err)
+	var balloonMiB int64
+	if !cfg.HugePages {
+		// Inflate balloon to reclaim unused guest memory before snapshotting.
+		// JVM starts with -Xms32m -XX:-AlwaysPreTouch so G1 only commits
+		// heap regions on demand. After warmup, committed memory is much
+		// lower (~200-400MB). Leave 512 MiB headroom for kernel + JVM +
+		// Python residual. deflateOnOom=true reclaims on demand after restore.
+		balloonMiB = int64(balloonTargetMiB(memMiB))
+		fmt.Fprintf(stderr, "Inflating balloon to %d MiB to reclaim unused pages...\n", balloonMiB)
+		if err := machine.UpdateBalloon(ctx, balloonMiB); err != nil {
+			return fmt.Errorf("inflating balloon: %w", err)
+		}
+		// Wait for guest balloon driver to reclaim pages
+		time.Sleep(3 * time.Second)
+
+		// Deflate balloon back to 0 before snapshotting. The inflation already
+		// caused MADV_DONTNEED on reclaimed pages (they're now zeros). Deflating
+		// returns those page ranges to the guest so the restored VM has full
+		// memory available without needing deflateOnOom to kick in.
+		fmt.Fprintf(stderr, "Deflating balloon before snapshot...\n")
+		if err := machine.UpdateBalloon(ctx, 0); err != nil {
+			return fmt.Errorf("deflating balloon: %w", err)
+		}
+		time.Sleep(500 * time.Millisecond)
 	}
-	time.Sleep(500 * time.Millisecond)
 
 	if cfg.Verbose {
 		fmt.Fprintf(stderr, "Deephaven ready, pausing VM for snapshot...\n")
@@ -212,15 +378,37 @@ func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr
 	memPath := filepath.Join(snapDir, "snapshot_mem")
 	statePath := filepath.Join(snapDir, "snapshot_vmstate")
 
-	if err := machine.CreateSnapshot(ctx, memPath, statePath); err != nil {
-		return fmt.Errorf("creating snapshot: %w", err)
+	if jail == nil {
+		if err := machine.CreateSnapshot(ctx, memPath, statePath); err != nil {
+			return fmt.Errorf("creating snapshot: %w", err)
+		}
+	} else {
+		// Firecracker writes the snapshot in the jail, which is deleted on
+		// return; move it to the snapshot directory. The files, and the
+		// disk it shared, go back to us from the jail user.
+		if err := machine.CreateSnapshot(ctx, "/snapshot_mem", "/snapshot_vmstate"); err != nil {
+			return fmt.Errorf("creating snapshot: %w", err)
+		}
+		for name, dst := range map[string]string{"snapshot_mem": memPath, "snapshot_vmstate": statePath} {
+			if err := os.Rename(jail.hostPath(name), dst); err != nil {
+				return fmt.Errorf("moving snapshot out of the jail: %w", err)
+			}
+		}
+		for _, p := range []string{memPath, statePath, diskPath} {
+			os.Chown(p, os.Geteuid(), os.Getegid())
+		}
 	}
 
 	// Post-process the snapshot memory file: punch holes in zero-filled
 	// regions to make it sparse. Firecracker writes all pages sequentially
 	// (including balloon-freed zeros), so we need to retroactively convert
-	// zero regions into filesystem holes.
-	if err := punchHoles(memPath, stderr); err != nil {
+	// zero regions into filesystem holes. A compressed snapshot replaces the
+	// file with snapshot_mem.zst, which leaves zero blocks out instead.
+	if cfg.Compress {
+		if err := compressSnapshotMem(memPath, stderr); err != nil {
+			return err
+		}
+	} else if err := punchHoles(memPath, stderr); err != nil {
 		// Non-fatal: restore still works, just slower without sparse optimization
 		fmt.Fprintf(stderr, "Warning: could not make snapshot sparse: %v\n", err)
 	}
@@ -231,11 +419,29 @@ func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr
 
 	// Write metadata
 	meta := &SnapshotMetadata{
-		Version:    version,
-		CreatedAt:  time.Now(),
-		DHPort:     DefaultDHPort,
-		MemSizeMiB: DefaultMemSizeMiB,
-		BalloonMiB: int(balloonMiB),
+		Version:       version,
+		Profile:       cfg.Profile,
+		CreatedAt:     time.Now(),
+		DHPort:        DefaultDHPort,
+		MemSizeMiB:    memMiB,
+		VCPUCount:     vcpus,
+		BalloonMiB:    int(balloonMiB),
+		Firecracker:   currentFirecracker(paths),
+		Compressed:    cfg.Compress,
+		Jailed:        jail != nil,
+		HugePages:     cfg.HugePages,
+		Net:           cfg.Net,
+		VsockRelative: jail == nil,
+		ReadOnlyRoot:  !cfg.WritableRoot,
+
+		GuestJVMArgs: strings.Join(strings.Fields(cfg.GuestJVMArgs), " "),
+		Generation:   newGeneration(),
+	}
+	if sum, err := KernelFingerprint(paths); err == nil {
+		meta.KernelSHA256 = sum
+	}
+	if m, err := ReadRootfsManifest(paths, key); err == nil {
+		meta.BaseImage, meta.Python = m.BaseImage, m.Python
 	}
 	metaBytes, err := json.MarshalIndent(meta, "", "  ")
 	if err != nil {
@@ -257,12 +463,22 @@ func BootAndSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr
 // using the File backend). The caller must Close the UFFD handler after
 // stopping the VM.
 func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr io.Writer) (*InstanceInfo, *firecracker.Machine, io.Closer, error) {
-	version := cfg.Version
+	version := SnapshotKey(cfg.Version, cfg.Profile)
 	snapDir := paths.SnapshotDirForVersion(version)
 
+	// Held until the VM runs: the snapshot is not replaced mid-restore.
+	unlock, err := lockSnapshot(paths, version, false, stderr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer unlock()
+
 	if err := CheckSnapshot(paths, version); err != nil {
 		return nil, nil, nil, err
 	}
+	// dh vm gc tells when a snapshot was last used from its directory.
+	now := time.Now()
+	os.Chtimes(snapDir, now, now)
 
 	// Create instance directory
 	instanceID := fmt.Sprintf("exec-%d", time.Now().UnixNano())
@@ -274,22 +490,125 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 	socketPath := filepath.Join(instanceDir, "firecracker.sock")
 
 	// Firecracker embeds the vsock UDS path in the snapshot state and always
-	// re-binds at that same absolute path on restore. This is always
-	// {snapDir}/vsock.sock regardless of VsockUDSPath.
+	// re-binds at that same path on restore, regardless of VsockUDSPath.
+	// Snapshots without VsockRelative have it at {snapDir}/vsock.sock.
 	snapVsockPath := filepath.Join(snapDir, "vsock.sock")
 
-	// Use snapshot disk directly — the VM is ephemeral and destroyed after exec.
-	// This avoids copying a multi-GB disk image on every invocation.
+	// Firecracker restores the drive at the path recorded in the snapshot,
+	// the shared disk.ext4, which the SDK also validates.
 	diskPath := filepath.Join(snapDir, "disk.ext4")
 
-	memPath := filepath.Join(snapDir, "snapshot_mem")
+	memPath := snapshotMemPath(snapDir)
 	statePath := filepath.Join(snapDir, "snapshot_vmstate")
 
 	// Configure Firecracker for snapshot restore — vsock for communication.
-	// KernelImagePath and MachineCfg are required by SDK validation even for restore.
-	vcpuCount := int64(DefaultVCPUCount)
-	memSize := int64(DefaultMemSizeMiB)
-	diskReadOnly := cfg.ReadOnlyDisk
+	// KernelImagePath and MachineCfg are required by SDK validation even for
+	// restore; the machine must have the size the snapshot was taken with.
+	meta, err := ReadSnapshotMetadata(snapDir)
+	if err != nil {
+		meta = &SnapshotMetadata{}
+	}
+	if meta.VsockRelative {
+		snapVsockPath = filepath.Join(instanceDir, "vsock.sock")
+	}
+	vcpus, memMiB := meta.MachineSize()
+	vcpuCount := int64(vcpus)
+	memSize := int64(memMiB)
+	// A read-only root never writes to the disk, so VMs share it as is.
+	// Restoring keeps the drive as the snapshot has it, so no other VM can
+	// be made to share it.
+	diskReadOnly := meta.ReadOnlyRoot
+
+	// The paths in a snapshot are those Firecracker saw when taking it, so
+	// a jailed snapshot only restores in a jail and an unjailed one only
+	// outside of one.
+	if meta.Jailed != cfg.Jailed {
+		RemoveInstanceDir(instanceDir)
+		if meta.Jailed {
+			return nil, nil, nil, fmt.Errorf("the snapshot for version %s was taken under the jailer; pass --jailed, or run: %s", version, PrepareCommand(version))
+		}
+		return nil, nil, nil, fmt.Errorf("the snapshot for version %s was not taken under the jailer; run: %s --jailed", version, PrepareCommand(version))
+	}
+	// The network device is in the snapshot.
+	if meta.Net != cfg.Net {
+		RemoveInstanceDir(instanceDir)
+		if meta.Net != "" {
+			return nil, nil, nil, fmt.Errorf("the snapshot for version %s was taken with --net=%s; pass --net=%s, or run: %s", version, meta.Net, meta.Net, PrepareCommand(version))
+		}
+		return nil, nil, nil, fmt.Errorf("the snapshot for version %s has no network; run: %s --net=%s", version, PrepareCommand(version), cfg.Net)
+	}
+
+	// A jailed Firecracker finds the snapshot's files at the in-jail paths
+	// it was taken with: the files are put in the instance's chroot, where
+	// it also binds the vsock socket, so jailed restores don't collide.
+	var jail *vmJail
+	restored := false
+	if cfg.Jailed {
+		if jail, err = newJail(paths, instanceID); err != nil {
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, err
+		}
+		defer func() {
+			if !restored {
+				removeJail(paths, instanceID)
+			}
+		}()
+		// Firecracker runs as the jail user, so its disk must be its own
+		// clone; the shared disk is never handed to it.
+		err := cloneFile(diskPath, jail.hostPath("disk.ext4"))
+		if err == nil {
+			err = jail.share(jail.hostPath("disk.ext4"))
+		}
+		if err == nil {
+			err = jail.link(statePath, "snapshot_vmstate", false)
+		}
+		if err == nil && !strings.HasSuffix(memPath, ".zst") {
+			err = jail.link(memPath, "snapshot_mem", false)
+		}
+		if err != nil {
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, fmt.Errorf("preparing jail: %w", err)
+		}
+		socketPath = "/firecracker.sock"
+		snapVsockPath = jail.hostPath("vsock.sock")
+		diskReadOnly = true // not swapped: the jail's disk is already a clone
+	}
+
+	// A VM with a network gets a namespace of its own, holding a tap device
+	// like the one the snapshot was taken with.
+	netIndex := 0
+	if cfg.Net == NetNAT {
+		if netIndex, err = newVMNet(); err != nil {
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, err
+		}
+		defer func() {
+			if !restored {
+				removeVMNet(netIndex)
+			}
+		}()
+	}
+
+	// Concurrent VMs must not write to the shared disk. A writable VM gets
+	// a disk of its own, swapped in after the snapshot loads and before the
+	// VM resumes: a reflink clone, which copies no data, or else a full
+	// copy of the multi-GB image.
+	var overlayPath string
+	if !diskReadOnly && jail == nil {
+		p, cloned, err := instanceDisk(diskPath, instanceDir)
+		if err != nil {
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, err
+		}
+		overlayPath = p
+		if cfg.Verbose {
+			if cloned {
+				fmt.Fprintf(stderr, "Using copy-on-write disk %s\n", p)
+			} else {
+				fmt.Fprintf(stderr, "No reflinks under %s; using a full copy of the snapshot disk, %s\n", instanceDir, p)
+			}
+		}
+	}
 	fcCfg := firecracker.Config{
 		SocketPath:      socketPath,
 		KernelImagePath: paths.Kernel,
@@ -313,11 +632,9 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 			MemSizeMib: &memSize,
 		},
 	}
-
-	fcCmd := firecracker.VMCommandBuilder{}.
-		WithBin(paths.Firecracker).
-		WithSocketPath(socketPath).
-		Build(ctx)
+	if netIndex > 0 {
+		fcCfg.NetNS = netNamespacePath(netIndex)
+	}
 
 	logger := log.New()
 	logger.SetLevel(log.ErrorLevel)
@@ -330,27 +647,57 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 		}
 		useUffd = false
 	}
+	if !useUffd && meta.HugePages {
+		// Firecracker restores huge page memory only through UFFD.
+		RemoveInstanceDir(instanceDir)
+		return nil, nil, nil, fmt.Errorf("the snapshot for version %s has huge page memory and needs userfaultfd (sudo sysctl -w vm.unprivileged_userfaultfd=1, and unset DH_VM_NO_UFFD), or run: %s", version, PrepareCommand(version))
+	}
+	if meta.HugePages {
+		if err := checkHugePages(memMiB); err != nil {
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, err
+		}
+	}
+	if err := checkHostResources(paths, meta, 1); err != nil {
+		RemoveInstanceDir(instanceDir)
+		return nil, nil, nil, err
+	}
+	if !useUffd && strings.HasSuffix(memPath, ".zst") {
+		// Firecracker's File backend maps the memory file as is; only the
+		// UFFD handler can decompress it.
+		RemoveInstanceDir(instanceDir)
+		return nil, nil, nil, fmt.Errorf("the snapshot for version %s is compressed and needs userfaultfd (sudo sysctl -w vm.unprivileged_userfaultfd=1), or run: %s", version, PrepareCommand(version))
+	}
 
 	// Start UFFD handler before creating Machine (socket must exist for SDK validation).
 	var uffd *uffdHandler
+	uffdSocketPath, uffdSocketArg := filepath.Join(instanceDir, "uffd.sock"), filepath.Join(instanceDir, "uffd.sock")
+	if jail != nil {
+		uffdSocketPath, uffdSocketArg = jail.hostPath("uffd.sock"), "/uffd.sock"
+	}
 	if useUffd {
-		uffdSocketPath := filepath.Join(instanceDir, "uffd.sock")
 		var err error
-		uffd, err = startUffdHandler(ctx, uffdSocketPath, memPath, stderr)
+		uffd, err = startUffdHandler(ctx, uffdSocketPath, memPath, vcpus, cfg.EagerMB, stderr)
+		if err == nil && jail != nil {
+			if err = jail.share(uffdSocketPath); err != nil {
+				uffd.Close()
+			}
+		}
 		if err != nil {
-			os.RemoveAll(instanceDir)
+			RemoveInstanceDir(instanceDir)
 			return nil, nil, nil, fmt.Errorf("starting UFFD handler: %w", err)
 		}
 	}
 
 	// Build snapshot options. UFFD mode loads without auto-resume so we can
-	// populate all pages first; File mode auto-resumes immediately.
+	// populate all pages first; File mode auto-resumes immediately unless
+	// the disk has to be swapped for the instance's clone first.
 	var snapshotOpts []firecracker.WithSnapshotOpt
 	if useUffd {
 		snapshotOpts = append(snapshotOpts,
 			firecracker.WithMemoryBackend(
 				models.MemoryBackendBackendTypeUffd,
-				filepath.Join(instanceDir, "uffd.sock"),
+				uffdSocketArg,
 			),
 			func(sc *firecracker.SnapshotConfig) {
 				sc.ResumeVM = false
@@ -359,7 +706,7 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 	} else {
 		snapshotOpts = append(snapshotOpts,
 			func(sc *firecracker.SnapshotConfig) {
-				sc.ResumeVM = true
+				sc.ResumeVM = overlayPath == ""
 			},
 		)
 	}
@@ -369,20 +716,51 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 	// does NOT do this swap.
 	// UFFD mode: memFilePath must be empty (Firecracker rejects both mem_file_path
 	// and mem_backend in the same request).
-	snapshotMemArg := memPath
+	snapshotMemArg, snapshotStateArg := memPath, statePath
+	if jail != nil {
+		snapshotMemArg, snapshotStateArg = "/snapshot_mem", "/snapshot_vmstate"
+	}
 	if useUffd {
 		snapshotMemArg = ""
 	}
-	machine, err := firecracker.NewMachine(ctx, fcCfg,
-		firecracker.WithProcessRunner(fcCmd),
+	opts := []firecracker.Opt{
 		firecracker.WithLogger(log.NewEntry(logger)),
-		firecracker.WithSnapshot(snapshotMemArg, statePath, snapshotOpts...),
-	)
+		firecracker.WithSnapshot(snapshotMemArg, snapshotStateArg, snapshotOpts...),
+	}
+	// The serial console goes to the instance's log. Firecracker gets its
+	// own copy of the file; a jailed one's output also goes to stderr,
+	// copied through a pipe that keeps the file open until it exits.
+	serialLog, err := openSerialLog(paths, instanceID)
 	if err != nil {
 		if uffd != nil {
 			uffd.Close()
 		}
-		os.RemoveAll(instanceDir)
+		RemoveInstanceDir(instanceDir)
+		return nil, nil, nil, err
+	}
+	if jail != nil {
+		// The snapshot's paths exist only in the chroot, where the SDK
+		// can't check them.
+		fcCfg.JailerCfg = jail.config(paths, io.MultiWriter(stderr, serialLog))
+		fcCfg.Seccomp.Enabled = true
+		fcCfg.DisableValidation = true
+	} else {
+		fcCmd := firecracker.VMCommandBuilder{}.
+			WithBin(paths.Firecracker).
+			WithSocketPath(socketPath).
+			WithStdout(serialLog).
+			WithStderr(serialLog).
+			Build(ctx)
+		fcCmd.Dir = instanceDir
+		opts = append(opts, firecracker.WithProcessRunner(fcCmd))
+		defer serialLog.Close()
+	}
+	machine, err := firecracker.NewMachine(ctx, fcCfg, opts...)
+	if err != nil {
+		if uffd != nil {
+			uffd.Close()
+		}
+		RemoveInstanceDir(instanceDir)
 		return nil, nil, nil, fmt.Errorf("creating firecracker machine: %w", err)
 	}
 
@@ -394,63 +772,85 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 	machine.Handlers.FcInit = machine.Handlers.FcInit.Remove(firecracker.CreateLogFilesHandlerName)
 	machine.Handlers.FcInit = machine.Handlers.FcInit.Remove(firecracker.BootstrapLoggingHandlerName)
 
-	// Serialize snapshot restores with a file lock. Firecracker always binds
-	// the vsock UDS at snapVsockPath (embedded in the snapshot state), so
+	// Serialize restores of older snapshots with a file lock. Firecracker
+	// binds their vsock UDS at snapVsockPath in the snapshot directory, so
 	// concurrent restores from the same snapshot race on bind(). The lock
 	// covers: remove stale socket → restore (bind) → rename to per-instance
 	// path.
-	lockPath := filepath.Join(snapDir, "restore.lock")
-	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
-	if err != nil {
-		if uffd != nil {
-			uffd.Close()
+	unlockRestore := func() {}
+	if !meta.VsockRelative && jail == nil {
+		lockPath := filepath.Join(snapDir, "restore.lock")
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			if uffd != nil {
+				uffd.Close()
+			}
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, fmt.Errorf("opening restore lock: %w", err)
 		}
-		os.RemoveAll(instanceDir)
-		return nil, nil, nil, fmt.Errorf("opening restore lock: %w", err)
-	}
-	defer lockFile.Close()
+		defer lockFile.Close()
 
-	if err := unix.Flock(int(lockFile.Fd()), unix.LOCK_EX); err != nil {
-		if uffd != nil {
-			uffd.Close()
+		if err := unix.Flock(int(lockFile.Fd()), unix.LOCK_EX); err != nil {
+			if uffd != nil {
+				uffd.Close()
+			}
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, fmt.Errorf("acquiring restore lock: %w", err)
 		}
-		os.RemoveAll(instanceDir)
-		return nil, nil, nil, fmt.Errorf("acquiring restore lock: %w", err)
-	}
+		unlockRestore = func() { unix.Flock(int(lockFile.Fd()), unix.LOCK_UN) }
 
-	// Remove stale vsock socket from previous runs — Firecracker will re-bind
-	// this path during snapshot restore and fails with EADDRINUSE if it exists.
-	os.Remove(snapVsockPath)
+		// Remove stale vsock socket from previous runs — Firecracker will
+		// re-bind this path during snapshot restore and fails with
+		// EADDRINUSE if it exists.
+		os.Remove(snapVsockPath)
+	}
 
 	// Start loads the snapshot. In UFFD mode, Firecracker connects to the
 	// handler which eagerly populates all pages. In File mode, this also
 	// resumes the VM (~10ms restore + demand paging during execution).
 	if err := machine.Start(ctx); err != nil {
-		unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+		unlockRestore()
 		if uffd != nil {
 			uffd.Close()
 		}
-		os.RemoveAll(instanceDir)
+		RemoveInstanceDir(instanceDir)
 		return nil, nil, nil, fmt.Errorf("restoring from snapshot: %w", err)
 	}
 
 	if useUffd {
 		// Wait for UFFD handler to finish populating all pages.
 		if err := uffd.Wait(ctx); err != nil {
-			unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+			unlockRestore()
 			machine.StopVMM()
 			uffd.Close()
-			os.RemoveAll(instanceDir)
+			RemoveInstanceDir(instanceDir)
 			return nil, nil, nil, fmt.Errorf("UFFD page population: %w", err)
 		}
+	}
+
+	// Point the paused VM's root drive at the instance's own clone.
+	if overlayPath != "" {
+		if err := machine.UpdateGuestDrive(ctx, "rootfs", overlayPath); err != nil {
+			unlockRestore()
+			machine.StopVMM()
+			if uffd != nil {
+				uffd.Close()
+			}
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, fmt.Errorf("attaching instance disk: %w", err)
+		}
+	}
 
+	if useUffd || overlayPath != "" {
 		// All pages populated — resume VM with zero pending page faults.
 		if err := machine.ResumeVM(ctx); err != nil {
-			unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+			unlockRestore()
 			machine.StopVMM()
-			uffd.Close()
-			os.RemoveAll(instanceDir)
-			return nil, nil, nil, fmt.Errorf("resuming VM after UFFD population: %w", err)
+			if uffd != nil {
+				uffd.Close()
+			}
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, fmt.Errorf("resuming VM: %w", err)
 		}
 	}
 
@@ -462,22 +862,28 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 	// Rename vsock socket to a per-instance path so concurrent VMs from the
 	// same snapshot don't collide. This must happen while we hold the lock
 	// so the next restore's os.Remove doesn't race with our bind().
+	// A jailed VM's socket is already its own. Guest connections still go
+	// to the path it was bound at, GuestVsockPath.
 	effectiveVsockPath := filepath.Join(instanceDir, "vsock.sock")
 	if cfg.VsockUDSPath != "" {
 		effectiveVsockPath = cfg.VsockUDSPath
 	}
-	if err := os.Rename(snapVsockPath, effectiveVsockPath); err != nil {
-		unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
-		machine.StopVMM()
-		if uffd != nil {
-			uffd.Close()
+	if jail != nil {
+		effectiveVsockPath = snapVsockPath
+	} else if effectiveVsockPath != snapVsockPath {
+		if err := os.Rename(snapVsockPath, effectiveVsockPath); err != nil {
+			unlockRestore()
+			machine.StopVMM()
+			if uffd != nil {
+				uffd.Close()
+			}
+			RemoveInstanceDir(instanceDir)
+			return nil, nil, nil, fmt.Errorf("renaming vsock socket to %s: %w", effectiveVsockPath, err)
 		}
-		os.RemoveAll(instanceDir)
-		return nil, nil, nil, fmt.Errorf("renaming vsock socket to %s: %w", effectiveVsockPath, err)
 	}
 
 	// Release lock — snapVsockPath is now free for the next restore.
-	unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+	unlockRestore()
 
 	pid, _ := machine.PID()
 	info := &InstanceInfo{
@@ -485,6 +891,10 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 		PID:       pid,
 		Version:   version,
 		VsockPath: effectiveVsockPath,
+
+		GuestVsockPath: snapVsockPath,
+		NetIndex:       netIndex,
+		Generation:     meta.GenerationID(),
 	}
 
 	// Write instance info for crash recovery
@@ -495,6 +905,7 @@ func RestoreFromSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, std
 	if uffd != nil {
 		closer = uffd
 	}
+	restored = true
 	return info, machine, closer, nil
 }
 
@@ -504,7 +915,9 @@ func DestroyInstance(machine *firecracker.Machine, info *InstanceInfo, paths *VM
 		machine.StopVMM()
 	}
 	if info != nil {
-		os.RemoveAll(paths.InstanceDir(info.ID))
+		RemoveInstanceDir(paths.InstanceDir(info.ID))
+		removeJail(paths, info.ID)
+		removeVMNet(info.NetIndex)
 	}
 }
 
@@ -557,11 +970,138 @@ func connectVsock(udsPath string, port uint32) (net.Conn, error) {
 	return conn, nil
 }
 
+// RunnerHello is the runner's answer to a hello: the protocol version it
+// speaks and the optional features it has. Protocol is 0 for runners from
+// before the hello, whose features are unknown.
+type RunnerHello struct {
+	Protocol int      `json:"protocol"`
+	Features []string `json:"features,omitempty"`
+}
+
+// Supports reports whether the runner has feature. Runners from before
+// the hello are assumed to, as requests to them were always sent whole.
+func (h *RunnerHello) Supports(feature string) bool {
+	return h.Protocol == 0 || slices.Contains(h.Features, feature)
+}
+
+// restrict clears the fields of req that use features the runner lacks.
+func (h *RunnerHello) restrict(req *VsockRequest) {
+	if !h.Supports(FeatureStream) {
+		req.Stream = false
+	}
+	if !h.Supports(FeatureCollectOutputs) {
+		req.CollectOutputs = false
+	}
+	if !h.Supports(FeatureFUSE) {
+		req.MountMode = ""
+	}
+	if !h.Supports(FeatureTableFormat) {
+		req.TableFormat = ""
+	}
+	if !h.Supports(FeatureTableOutput) {
+		req.TableOutput, req.MaxRows, req.MaxCols = "", nil, 0
+	}
+}
+
+// dialRunner connects to the runner on port and exchanges hellos. A runner
+// from before the hello takes it for a request without code, answers and
+// hangs up; dialRunner then connects again, and returns a hello with
+// Protocol 0.
+func dialRunner(vsockPath string, port uint32) (net.Conn, *bufio.Reader, *RunnerHello, error) {
+	conn, err := connectVsock(vsockPath, port)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hello := []byte(fmt.Sprintf(`{"type":"hello","protocol":%d}`, VsockProtocol) + "\n")
+	output.TraceFrame(fmt.Sprintf("vsock:%d", port), "->", hello)
+	if _, err := conn.Write(hello); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("sending hello: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(vsockHelloTimeout))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("reading hello: %w", err)
+	}
+	output.TraceFrame(fmt.Sprintf("vsock:%d", port), "<-", line)
+	conn.SetDeadline(time.Time{})
+
+	var reply struct {
+		Type string `json:"type"`
+		RunnerHello
+	}
+	if err := json.Unmarshal(line, &reply); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("parsing hello: %w", err)
+	}
+	if reply.Type == "hello" {
+		return conn, reader, &reply.RunnerHello, nil
+	}
+
+	conn.Close()
+	if conn, err = connectVsock(vsockPath, port); err != nil {
+		return nil, nil, nil, err
+	}
+	return conn, bufio.NewReader(conn), &RunnerHello{}, nil
+}
+
+// checkReadOnlyRoot asks the runner whether the guest booted on an overlay
+// of the read-only disk. A rootfs built before read-only roots, or a guest
+// kernel without overlayfs, leaves it on the bare read-only disk.
+func checkReadOnlyRoot(vsockPath string) error {
+	conn, _, hello, err := dialRunner(vsockPath, VsockPort)
+	if err != nil {
+		return fmt.Errorf("asking the runner about the root: %w", err)
+	}
+	conn.Close()
+	if hello.Protocol == 0 || !slices.Contains(hello.Features, FeatureReadOnlyRoot) {
+		return fmt.Errorf("the guest did not get a read-only root: the rootfs predates it or the kernel lacks overlayfs (see dh vm logs --last); prepare with --writable-root, or rebuild the rootfs with 'dh vm clean'")
+	}
+	return nil
+}
+
 // VsockRequest is the JSON request sent from the host to the VM runner daemon.
 type VsockRequest struct {
-	Code          string `json:"code"`
-	ShowTables    bool   `json:"show_tables"`
-	ShowTableMeta bool   `json:"show_table_meta"`
+	Code          string   `json:"code"`
+	ShowTables    bool     `json:"show_tables"`
+	ShowTableMeta bool     `json:"show_table_meta"`
+	PythonPath    []string `json:"python_path,omitempty"` // guest dirs prepended to sys.path
+	HashTables    bool     `json:"hash_tables,omitempty"` // include content_hash in table info
+	Stream        bool     `json:"stream,omitempty"`      // send stdout/stderr frames as they are written
+	MountMode     string   `json:"mount_mode,omitempty"`  // "fuse" mounts /workspace; default: the LD_PRELOAD library
+
+	// CollectOutputs asks the runner to send back the files the script
+	// left in GuestOutputDir. ExecuteViaVsockStream sets it when Outputs
+	// is given and saves them there.
+	CollectOutputs bool       `json:"collect_outputs,omitempty"`
+	Outputs        *OutputDir `json:"-"`
+
+	// TableFormat "arrow" asks the runner to send each table the code
+	// assigns as an Arrow IPC stream, which ExecuteViaVsockStream puts in
+	// resp.ArrowTables.
+	TableFormat string `json:"table_format,omitempty"`
+
+	// ImportTables are loaded into the server, at their guest paths,
+	// before the code runs.
+	ImportTables []TableImport `json:"import_tables,omitempty"`
+
+	// ExportTables asks the runner to send each table, in its format,
+	// after the code runs; ExecuteViaVsockStream puts them in
+	// resp.ExportedTables. A table that is not in scope fails the run.
+	ExportTables []TableExport `json:"export_tables,omitempty"`
+
+	// TableOutput is the format of table previews: "pretty" (the
+	// default), "csv", "markdown", "html" or "json". MaxRows bounds their
+	// rows, 10 when nil and all when 0; MaxCols their columns, all when 0.
+	TableOutput string `json:"table_output,omitempty"`
+	MaxRows     *int   `json:"max_rows,omitempty"`
+	MaxCols     int    `json:"max_cols,omitempty"`
+
+	// InterruptGrace is how long ExecuteViaVsockStream waits for the
+	// runner to stop an interrupted script; 0 for a few seconds.
+	InterruptGrace time.Duration `json:"-"`
 }
 
 // VsockResponse is the JSON response from the VM runner daemon.
@@ -571,19 +1111,118 @@ type VsockResponse struct {
 	Stderr     string         `json:"stderr"`
 	ResultRepr *string        `json:"result_repr"`
 	Error      *string        `json:"error"`
+	Warnings   []any          `json:"warnings,omitempty"`
 	Tables     []any          `json:"tables"`
 	Timing     map[string]any `json:"_timing,omitempty"`
+
+	// Interrupted is set when the script was stopped by a cancel frame.
+	// ExitCode is then 130 and Error holds the KeyboardInterrupt traceback.
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	// Streamed is set when stdout and stderr were sent as frames before
+	// the response. ExecuteViaVsockStream collects them into Stdout and
+	// Stderr, so they are complete either way.
+	Streamed bool `json:"streamed,omitempty"`
+
+	// MountMode is "fuse" when /workspace was a FUSE mount. Runners in
+	// snapshots that predate --mount-mode=fuse leave it empty.
+	MountMode string `json:"mount_mode,omitempty"`
+
+	// Outputs lists the files sent back from GuestOutputDir. It is nil
+	// when the runner predates CollectOutputs or it wasn't asked for.
+	Outputs []OutputFile `json:"outputs,omitempty"`
+
+	// ArrowTables holds the tables sent for TableFormat "arrow". It is nil
+	// when the runner predates TableFormat or it wasn't asked for.
+	ArrowTables []ArrowTable `json:"arrow_tables,omitempty"`
+
+	// ExportedTables holds the tables sent for ExportTables, each as a
+	// file in the format asked for. It is empty when the script failed.
+	ExportedTables []ExportedTable `json:"exported_tables,omitempty"`
+
+	// Runner is the runner's hello, set by ExecuteViaVsockStream.
+	Runner *RunnerHello `json:"runner,omitempty"`
+}
+
+// ArrowTable is a table sent back as an Arrow IPC stream.
+type ArrowTable struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Data []byte `json:"data,omitempty"` // the stream; filled from "table" frames
+}
+
+// ExportedTable is a table of VsockRequest.ExportTables, sent back as the
+// contents of its file.
+type ExportedTable struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Data []byte `json:"data,omitempty"` // filled from "export" frames
+}
+
+// vsockFrame is one line of a streamed response: output written by the
+// script ("stdout" or "stderr"), a base64 chunk of an output file
+// ("file"), of an Arrow table ("table") or of an exported table
+// ("export"), or, with any other type, the response.
+type vsockFrame struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Path string `json:"path,omitempty"` // for "file"
+	Name string `json:"name,omitempty"` // for "table" and "export"
 }
 
 // ExecuteViaVsock sends a code execution request to the VM runner daemon over
 // vsock and returns the response. The daemon inside the VM has a pre-connected
 // pydeephaven Session, so this avoids all host-side Python overhead.
 func ExecuteViaVsock(vsockPath string, port uint32, req *VsockRequest) (*VsockResponse, error) {
-	conn, err := connectVsock(vsockPath, port)
+	return ExecuteViaVsockStream(context.Background(), vsockPath, port, req, nil)
+}
+
+// ExecuteViaVsockStream is ExecuteViaVsock that passes the script's stdout
+// and stderr to onOutput as they are written, with stream "stdout" or
+// "stderr", as NDJSON frames ahead of the response. Runners in snapshots
+// taken before streaming existed ignore the request and send only the
+// response; onOutput is then not called and resp.Streamed is false.
+//
+// With req.Outputs set, the files the script leaves in GuestOutputDir are
+// saved there; they arrive as "file" frames after the script finishes.
+// Runners that predate this send none and leave resp.Outputs nil.
+//
+// With req.TableFormat "arrow", the tables the code assigns arrive the same
+// way as "table" frames and are returned, whole, in resp.ArrowTables.
+// The tables of req.ExportTables arrive as "export" frames, and are
+// returned in resp.ExportedTables.
+//
+// Each request is preceded by a hello, in which the runner tells its
+// protocol version and features; fields of req that use features it lacks
+// are not sent. The hello is returned in resp.Runner.
+//
+// Cancelling ctx interrupts the script: a cancel frame asks the runner to
+// raise KeyboardInterrupt in it, and the response it then sends, with
+// Interrupted set, is returned as usual. If none arrives within a few
+// seconds (runners in older snapshots ignore the frame), the call fails
+// with an error wrapping ctx.Err().
+func ExecuteViaVsockStream(ctx context.Context, vsockPath string, port uint32, req *VsockRequest, onOutput func(stream, data string)) (*VsockResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r := *req
+	if onOutput != nil {
+		r.Stream = true
+	}
+	r.CollectOutputs = req.Outputs != nil
+	req = &r
+	conn, reader, hello, err := dialRunner(vsockPath, port)
 	if err != nil {
 		return nil, fmt.Errorf("connecting to VM runner: %w", err)
 	}
 	defer conn.Close()
+	hello.restrict(req)
+	if len(req.ImportTables) > 0 && !slices.Contains(hello.Features, FeatureImportTables) {
+		return nil, fmt.Errorf("the runner in this snapshot can't import tables; rebuild it with 'dh vm clean --version VERSION' and 'dh vm prepare --version VERSION'")
+	}
+	if len(req.ExportTables) > 0 && !slices.Contains(hello.Features, FeatureExportTables) {
+		return nil, fmt.Errorf("the runner in this snapshot can't export tables; rebuild it with 'dh vm clean --version VERSION' and 'dh vm prepare --version VERSION'")
+	}
 
 	// Set deadline for the entire operation
 	conn.SetDeadline(time.Now().Add(5 * time.Minute))
@@ -594,23 +1233,143 @@ func ExecuteViaVsock(vsockPath string, port uint32, req *VsockRequest) (*VsockRe
 		return nil, fmt.Errorf("marshaling request: %w", err)
 	}
 	reqBytes = append(reqBytes, '\n')
+	output.TraceFrame(fmt.Sprintf("vsock:%d", port), "->", reqBytes)
 	if _, err := conn.Write(reqBytes); err != nil {
 		return nil, fmt.Errorf("sending request: %w", err)
 	}
 
-	// Read response (single JSON line terminated by newline)
-	reader := bufio.NewReader(conn)
-	respLine, err := reader.ReadBytes('\n')
-	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
-	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel := []byte(`{"type":"cancel"}` + "\n")
+			output.TraceFrame(fmt.Sprintf("vsock:%d", port), "->", cancel)
+			conn.Write(cancel)
+			grace := req.InterruptGrace
+			if grace <= 0 {
+				grace = vsockInterruptGrace
+			}
+			conn.SetDeadline(time.Now().Add(grace))
+		case <-stop:
+		}
+	}()
+
+	// Read output frames, if streaming, then the response; each is a JSON
+	// line terminated by newline.
+	var stdout, stderr strings.Builder
+	arrow := map[string]*bytes.Buffer{}
+	exported := map[string]*bytes.Buffer{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("runner did not stop the script: %w", ctx.Err())
+			}
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+		output.TraceFrame(fmt.Sprintf("vsock:%d", port), "<-", line)
+
+		var frame vsockFrame
+		if err := json.Unmarshal(line, &frame); err != nil {
+			return nil, fmt.Errorf("parsing response: %w", err)
+		}
+		switch frame.Type {
+		case "stdout":
+			stdout.WriteString(frame.Data)
+			onOutput(frame.Type, frame.Data)
+			continue
+		case "stderr":
+			stderr.WriteString(frame.Data)
+			onOutput(frame.Type, frame.Data)
+			continue
+		case "file":
+			if req.Outputs == nil {
+				return nil, fmt.Errorf("unexpected output file %s", frame.Path)
+			}
+			if err := req.Outputs.write(frame.Path, frame.Data); err != nil {
+				return nil, fmt.Errorf("saving output: %w", err)
+			}
+			continue
+		case "table":
+			if req.TableFormat != "arrow" {
+				return nil, fmt.Errorf("unexpected table %s", frame.Name)
+			}
+			data, err := base64.StdEncoding.DecodeString(frame.Data)
+			if err != nil {
+				return nil, fmt.Errorf("decoding table %s: %w", frame.Name, err)
+			}
+			if arrow[frame.Name] == nil {
+				arrow[frame.Name] = &bytes.Buffer{}
+			}
+			arrow[frame.Name].Write(data)
+			continue
+		case "export":
+			if !slices.ContainsFunc(req.ExportTables, func(e TableExport) bool { return e.Name == frame.Name }) {
+				return nil, fmt.Errorf("unexpected exported table %s", frame.Name)
+			}
+			data, err := base64.StdEncoding.DecodeString(frame.Data)
+			if err != nil {
+				return nil, fmt.Errorf("decoding table %s: %w", frame.Name, err)
+			}
+			if exported[frame.Name] == nil {
+				exported[frame.Name] = &bytes.Buffer{}
+			}
+			exported[frame.Name].Write(data)
+			continue
+		}
 
-	var resp VsockResponse
-	if err := json.Unmarshal(respLine, &resp); err != nil {
-		return nil, fmt.Errorf("parsing response: %w", err)
+		var resp VsockResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return nil, fmt.Errorf("parsing response: %w", err)
+		}
+		if req.Outputs != nil {
+			if err := req.Outputs.check(resp.Outputs); err != nil {
+				return nil, err
+			}
+		}
+		for i, t := range resp.ArrowTables {
+			got := arrow[t.Name]
+			if got == nil || int64(got.Len()) != t.Size {
+				return nil, fmt.Errorf("table %s: runner sent an incomplete Arrow stream", t.Name)
+			}
+			resp.ArrowTables[i].Data = got.Bytes()
+		}
+		for i, t := range resp.ExportedTables {
+			got := exported[t.Name]
+			if got == nil || int64(got.Len()) != t.Size {
+				return nil, fmt.Errorf("table %s: runner sent an incomplete export", t.Name)
+			}
+			resp.ExportedTables[i].Data = got.Bytes()
+		}
+		resp.Runner = hello
+		if resp.Streamed {
+			resp.Stdout = stdout.String() + resp.Stdout
+			resp.Stderr = stderr.String() + resp.Stderr
+		}
+		return &resp, nil
 	}
+}
 
-	return &resp, nil
+// compressSnapshotMem replaces the memory file at path with a compressed
+// copy, snapshot_mem.zst, next to it.
+func compressSnapshotMem(path string, stderr io.Writer) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(filepath.Dir(path), CompressedMemFile)
+	size, err := CompressSnapshotMem(path, dst)
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("compressing snapshot: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Compressed snapshot memory: %d MiB to %d MiB\n",
+		fi.Size()/(1024*1024), size/(1024*1024))
+	return nil
 }
 
 // copyFile copies src to dst.
@@ -662,21 +1421,11 @@ func punchHoles(path string, stderr io.Writer) error {
 	return nil
 }
 
-// isZero checks if a byte slice is entirely zeros.
-func isZero(b []byte) bool {
-	for _, v := range b {
-		if v != 0 {
-			return false
-		}
-	}
-	return true
-}
-
 // WarmSnapshotPageCacheAsync starts a background goroutine that reads the
 // snapshot memory file's data extents into the kernel page cache.
 // Called from exec as early as possible to maximize overlap with other work.
 func WarmSnapshotPageCacheAsync(paths *VMPaths, version string) {
-	memPath := filepath.Join(paths.SnapshotDirForVersion(version), "snapshot_mem")
+	memPath := snapshotMemPath(paths.SnapshotDirForVersion(version))
 	go warmSnapshotPageCache(memPath)
 }
 
@@ -711,13 +1460,29 @@ func warmSnapshotPageCache(memPath string) {
 		unix.Fadvise(fd, int64(ext.offset), int64(ext.length), unix.FADV_WILLNEED)
 	}
 
-	// Parallel explicit reads to guarantee page cache population.
-	// 4 readers matches typical SSD queue depth for optimal throughput.
-	const numReaders = 4
 	if len(extents) == 0 {
 		return
 	}
 
+	// Explicit reads to guarantee page cache population: through an
+	// io_uring when DH_VM_IO_URING=1 and the kernel allows it, else
+	// through a pool of readers.
+	if os.Getenv("DH_VM_IO_URING") == "1" {
+		err := warmWithIoURing(f, extents)
+		if err == nil {
+			return
+		}
+		output.Tracef("page cache warming: %v, falling back to parallel reads", err)
+	}
+	warmWithReaders(memPath, extents)
+}
+
+// warmWithReaders reads data extents of a snapshot memory file in
+// parallel. 4 readers matches typical SSD queue depth for optimal
+// throughput.
+func warmWithReaders(memPath string, extents []dataExtent) {
+	const numReaders = 4
+
 	// Split extents across readers by total bytes (not count) for balance
 	var totalBytes uint64
 	for _, ext := range extents {
@@ -0,0 +1,305 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressedMemFile is the name of the memory file of a snapshot prepared
+// with --compress, in place of snapshot_mem.
+const CompressedMemFile = "snapshot_mem.zst"
+
+// The compressed memory file is the guest memory cut into memBlockSize
+// blocks, each compressed on its own as a zstd frame so that any block can
+// be restored without decompressing the ones before it:
+//
+//	header  magic, block size, memory size, block count, index offset
+//	blocks  zstd frames, in order; all-zero blocks are left out
+//	index   per block: offset of its frame and frame length (0 = zero block)
+//
+// All integers are little-endian uint64.
+const (
+	memZstMagic      = "DHMEMZ1\n"
+	memZstHeaderSize = 8 + 4*8
+	memZstEntrySize  = 2 * 8
+
+	// memBlockSize matches lazyChunkSize so a lazy fault decompresses
+	// exactly one block.
+	memBlockSize = 2 * 1024 * 1024
+)
+
+// dataExtent describes a contiguous range of non-hole data in the snapshot file.
+type dataExtent struct {
+	offset uint64 // offset in file
+	length uint64 // length of data region
+}
+
+// snapshotMemPath returns the memory file of the snapshot in snapDir:
+// snapshot_mem.zst if the snapshot is compressed, otherwise snapshot_mem.
+func snapshotMemPath(snapDir string) string {
+	zst := filepath.Join(snapDir, CompressedMemFile)
+	if _, err := os.Stat(zst); err == nil {
+		return zst
+	}
+	return filepath.Join(snapDir, "snapshot_mem")
+}
+
+// CompressSnapshotMem writes a compressed copy of the memory file src to
+// dst and returns the size of dst.
+func CompressSnapshotMem(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(fi.Size())
+	blocks := (size + memBlockSize - 1) / memBlockSize
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	workers := runtime.GOMAXPROCS(0)
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(workers))
+	if err != nil {
+		return 0, err
+	}
+	defer enc.Close()
+
+	// Compress a batch of blocks in parallel, then write the batch in order.
+	index := make([]uint64, 0, 2*blocks)
+	pos := uint64(memZstHeaderSize)
+	if _, err := out.Seek(int64(pos), io.SeekStart); err != nil {
+		return 0, err
+	}
+	raw := make([][]byte, workers)
+	frames := make([][]byte, workers)
+	for i := range raw {
+		raw[i] = make([]byte, memBlockSize)
+	}
+	for first := uint64(0); first < blocks; first += uint64(workers) {
+		n := min(uint64(workers), blocks-first)
+		var wg sync.WaitGroup
+		errs := make([]error, n)
+		for i := uint64(0); i < n; i++ {
+			wg.Add(1)
+			go func(i uint64) {
+				defer wg.Done()
+				off := (first + i) * memBlockSize
+				buf := raw[i][:min(memBlockSize, size-off)]
+				if _, err := in.ReadAt(buf, int64(off)); err != nil && err != io.EOF {
+					errs[i] = fmt.Errorf("reading at offset %d: %w", off, err)
+					return
+				}
+				frames[i] = nil
+				if !isZero(buf) {
+					frames[i] = enc.EncodeAll(buf, frames[i][:0])
+				}
+			}(i)
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return 0, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if frames[i] == nil {
+				index = append(index, 0, 0)
+				continue
+			}
+			if _, err := out.Write(frames[i]); err != nil {
+				return 0, err
+			}
+			index = append(index, pos, uint64(len(frames[i])))
+			pos += uint64(len(frames[i]))
+		}
+	}
+
+	if err := binary.Write(out, binary.LittleEndian, index); err != nil {
+		return 0, err
+	}
+	header := make([]byte, memZstHeaderSize)
+	copy(header, memZstMagic)
+	binary.LittleEndian.PutUint64(header[8:], memBlockSize)
+	binary.LittleEndian.PutUint64(header[16:], size)
+	binary.LittleEndian.PutUint64(header[24:], blocks)
+	binary.LittleEndian.PutUint64(header[32:], pos)
+	if _, err := out.WriteAt(header, 0); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	return int64(pos + uint64(len(index))*8), nil
+}
+
+// CompressedMem reads a compressed snapshot memory file as if it were the
+// uncompressed one. It is safe for concurrent use.
+type CompressedMem struct {
+	f         *os.File
+	size      uint64
+	blockSize uint64
+	index     []uint64 // offset, length pairs
+	dec       *zstd.Decoder
+	bufs      sync.Pool // *[]byte scratch blocks
+}
+
+// OpenCompressedMem opens a file written by CompressSnapshotMem.
+func OpenCompressedMem(path string) (*CompressedMem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := readCompressedMem(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return c, nil
+}
+
+func readCompressedMem(f *os.File) (*CompressedMem, error) {
+	header := make([]byte, memZstHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil || string(header[:8]) != memZstMagic {
+		return nil, fmt.Errorf("not a compressed snapshot memory file")
+	}
+	c := &CompressedMem{
+		f:         f,
+		blockSize: binary.LittleEndian.Uint64(header[8:]),
+		size:      binary.LittleEndian.Uint64(header[16:]),
+	}
+	blocks := binary.LittleEndian.Uint64(header[24:])
+	indexOff := binary.LittleEndian.Uint64(header[32:])
+	if c.blockSize == 0 || blocks != (c.size+c.blockSize-1)/c.blockSize {
+		return nil, fmt.Errorf("corrupt header")
+	}
+	c.index = make([]uint64, 2*blocks)
+	r := io.NewSectionReader(f, int64(indexOff), int64(blocks*memZstEntrySize))
+	if err := binary.Read(r, binary.LittleEndian, c.index); err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		return nil, err
+	}
+	c.dec = dec
+	c.bufs.New = func() any {
+		b := make([]byte, c.blockSize)
+		return &b
+	}
+	return c, nil
+}
+
+// Size returns the size of the uncompressed memory file.
+func (c *CompressedMem) Size() uint64 { return c.size }
+
+// ReadAt reads len(p) bytes of uncompressed memory at off, decompressing
+// the blocks it overlaps.
+func (c *CompressedMem) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || uint64(off) >= c.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if rest := c.size - uint64(off); uint64(want) > rest {
+		p = p[:rest]
+	}
+	n := 0
+	for n < len(p) {
+		pos := uint64(off) + uint64(n)
+		block := pos / c.blockSize
+		start := pos - block*c.blockSize
+		blockLen := min(c.blockSize, c.size-block*c.blockSize)
+		chunk := p[n:min(len(p), n+int(blockLen-start))]
+
+		if start == 0 && uint64(len(chunk)) == blockLen {
+			// Whole block: decompress straight into p.
+			if err := c.readBlock(block, chunk); err != nil {
+				return n, err
+			}
+		} else {
+			bp := c.bufs.Get().(*[]byte)
+			err := c.readBlock(block, (*bp)[:blockLen])
+			if err == nil {
+				copy(chunk, (*bp)[start:])
+			}
+			c.bufs.Put(bp)
+			if err != nil {
+				return n, err
+			}
+		}
+		n += len(chunk)
+	}
+	if n < want {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readBlock decompresses a whole block into dst, which is the block's length.
+func (c *CompressedMem) readBlock(block uint64, dst []byte) error {
+	frameOff, frameLen := c.index[2*block], c.index[2*block+1]
+	if frameLen == 0 {
+		clear(dst)
+		return nil
+	}
+	frame := make([]byte, frameLen)
+	if _, err := c.f.ReadAt(frame, int64(frameOff)); err != nil {
+		return fmt.Errorf("reading block %d: %w", block, err)
+	}
+	out, err := c.dec.DecodeAll(frame, dst[:0])
+	if err != nil {
+		return fmt.Errorf("decompressing block %d: %w", block, err)
+	}
+	if len(out) != len(dst) || &out[0] != &dst[0] {
+		return fmt.Errorf("block %d decompressed to %d bytes, expected %d", block, len(out), len(dst))
+	}
+	return nil
+}
+
+// dataExtents returns the ranges of the uncompressed memory that are not
+// all zeros, merging adjacent blocks.
+func (c *CompressedMem) dataExtents() []dataExtent {
+	var extents []dataExtent
+	for block := uint64(0); 2*block < uint64(len(c.index)); block++ {
+		if c.index[2*block+1] == 0 {
+			continue
+		}
+		off := block * c.blockSize
+		length := min(c.blockSize, c.size-off)
+		if n := len(extents); n > 0 && extents[n-1].offset+extents[n-1].length == off {
+			extents[n-1].length += length
+			continue
+		}
+		extents = append(extents, dataExtent{offset: off, length: length})
+	}
+	return extents
+}
+
+// Close closes the file.
+func (c *CompressedMem) Close() error {
+	c.dec.Close()
+	return c.f.Close()
+}
+
+// isZero checks if a byte slice is entirely zeros.
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
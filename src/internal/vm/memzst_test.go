@@ -0,0 +1,143 @@
+package vm
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestMem writes a memory file of 5.5 blocks: data, zeros, data,
+// zeros, data, and a half block of data.
+func writeTestMem(t *testing.T, path string) []byte {
+	t.Helper()
+	mem := make([]byte, 5*memBlockSize+memBlockSize/2)
+	rng := rand.New(rand.NewSource(1))
+	for _, block := range []int{0, 2, 4, 5} {
+		end := min((block+1)*memBlockSize, len(mem))
+		// Half random, half repeated, so blocks compress but not to nothing.
+		rng.Read(mem[block*memBlockSize : block*memBlockSize+4096])
+		for i := block*memBlockSize + 4096; i < end; i++ {
+			mem[i] = byte(i % 251)
+		}
+	}
+	if err := os.WriteFile(path, mem, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return mem
+}
+
+func TestCompressSnapshotMem_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "snapshot_mem")
+	dst := filepath.Join(dir, CompressedMemFile)
+	mem := writeTestMem(t, src)
+
+	size, err := CompressSnapshotMem(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dst); err != nil || fi.Size() != size {
+		t.Fatalf("compressed size = %d, file is %v (%v)", size, fi, err)
+	}
+	if size >= int64(len(mem))/10 {
+		t.Errorf("compressed to %d bytes, want well under %d", size, len(mem))
+	}
+
+	c, err := OpenCompressedMem(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Size() != uint64(len(mem)) {
+		t.Fatalf("Size = %d, want %d", c.Size(), len(mem))
+	}
+
+	got, err := io.ReadAll(io.NewSectionReader(c, 0, int64(c.Size())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, mem) {
+		t.Fatal("decompressed memory differs from the original")
+	}
+
+	// Reads that straddle blocks, start mid-block, or run past the end.
+	for _, r := range []struct{ off, n int }{
+		{memBlockSize - 100, 200},
+		{3*memBlockSize + 7, memBlockSize},
+		{5*memBlockSize + 10, 100},
+		{len(mem) - 50, 100},
+	} {
+		buf := make([]byte, r.n)
+		n, err := c.ReadAt(buf, int64(r.off))
+		want := mem[r.off:min(r.off+r.n, len(mem))]
+		if n != len(want) || !bytes.Equal(buf[:n], want) {
+			t.Errorf("ReadAt(%d, %d) = %d bytes, want %d matching", r.off, r.n, n, len(want))
+		}
+		if n < r.n && err != io.EOF {
+			t.Errorf("short ReadAt(%d, %d) error = %v, want EOF", r.off, r.n, err)
+		}
+	}
+}
+
+func TestCompressedMem_DataExtents(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "snapshot_mem")
+	dst := filepath.Join(dir, CompressedMemFile)
+	writeTestMem(t, src)
+	if _, err := CompressSnapshotMem(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	c, err := OpenCompressedMem(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	want := []dataExtent{
+		{offset: 0, length: memBlockSize},
+		{offset: 2 * memBlockSize, length: memBlockSize},
+		{offset: 4 * memBlockSize, length: memBlockSize + memBlockSize/2},
+	}
+	got := c.dataExtents()
+	if len(got) != len(want) {
+		t.Fatalf("dataExtents = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("extent %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestOpenCompressedMem_NotCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot_mem")
+	if err := os.WriteFile(path, make([]byte, 4096), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCompressedMem(path); err == nil {
+		t.Error("expected error opening an uncompressed file")
+	}
+}
+
+func TestCheckSnapshot_Compressed(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	snapDir := paths.SnapshotDirForVersion("0.36.0")
+	if err := os.MkdirAll(snapDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"metadata.json", CompressedMemFile, "snapshot_vmstate", "disk.ext4"} {
+		if err := os.WriteFile(filepath.Join(snapDir, name), []byte("test"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CheckSnapshot(paths, "0.36.0"); err != nil {
+		t.Errorf("expected no error for compressed snapshot, got: %v", err)
+	}
+	if got := snapshotMemPath(snapDir); filepath.Base(got) != CompressedMemFile {
+		t.Errorf("snapshotMemPath = %q, want %s", got, CompressedMemFile)
+	}
+}
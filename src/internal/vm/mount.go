@@ -0,0 +1,135 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount describes an extra host directory exposed to the guest through the
+// file server. It appears in the guest at /workspace/<Alias>.
+type Mount struct {
+	HostPath string `json:"host_path"`
+	Alias    string `json:"alias"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// ParseMount parses a --mount spec of the form host_path[:guest_alias][:ro|rw].
+// The alias defaults to the base name of host_path and access defaults to ro.
+// The host path is resolved to an absolute path and must be an existing directory.
+func ParseMount(spec string) (Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) == 0 || parts[0] == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q: host path is required", spec)
+	}
+	if len(parts) > 3 {
+		return Mount{}, fmt.Errorf("invalid mount %q: expected host_path[:guest_alias][:ro|rw]", spec)
+	}
+
+	m := Mount{HostPath: parts[0], ReadOnly: true}
+	rest := parts[1:]
+
+	// A trailing ro/rw is the access mode; anything before it is the alias.
+	if n := len(rest); n > 0 && (rest[n-1] == "ro" || rest[n-1] == "rw") {
+		m.ReadOnly = rest[n-1] == "ro"
+		rest = rest[:n-1]
+	}
+	if len(rest) > 1 {
+		return Mount{}, fmt.Errorf("invalid mount %q: unknown access mode %q (use ro or rw)", spec, rest[1])
+	}
+	if len(rest) == 1 {
+		m.Alias = rest[0]
+	}
+
+	abs, err := filepath.Abs(m.HostPath)
+	if err != nil {
+		return Mount{}, fmt.Errorf("invalid mount %q: %w", spec, err)
+	}
+	m.HostPath = abs
+
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return Mount{}, fmt.Errorf("invalid mount %q: %w", spec, err)
+	}
+	if !fi.IsDir() {
+		return Mount{}, fmt.Errorf("invalid mount %q: %s is not a directory", spec, abs)
+	}
+
+	if m.Alias == "" {
+		m.Alias = filepath.Base(abs)
+	}
+	if !validAlias(m.Alias) {
+		return Mount{}, fmt.Errorf("invalid mount %q: guest alias %q must be a single path component", spec, m.Alias)
+	}
+
+	return m, nil
+}
+
+// ParseMounts parses a list of --mount specs, rejecting duplicate aliases.
+func ParseMounts(specs []string) ([]Mount, error) {
+	var mounts []Mount
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		m, err := ParseMount(spec)
+		if err != nil {
+			return nil, err
+		}
+		if seen[m.Alias] {
+			return nil, fmt.Errorf("duplicate mount alias %q", m.Alias)
+		}
+		seen[m.Alias] = true
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// ValidateMounts checks mounts that arrive already parsed, as in a pool
+// request: host paths must be absolute paths of existing directories and
+// aliases single, distinct path components.
+func ValidateMounts(mounts []Mount) error {
+	seen := make(map[string]bool, len(mounts))
+	for _, m := range mounts {
+		if !validAlias(m.Alias) {
+			return fmt.Errorf("invalid mount alias %q: must be a single path component", m.Alias)
+		}
+		if seen[m.Alias] {
+			return fmt.Errorf("duplicate mount alias %q", m.Alias)
+		}
+		seen[m.Alias] = true
+		if !filepath.IsAbs(m.HostPath) {
+			return fmt.Errorf("invalid mount %q: host path %q is not absolute", m.Alias, m.HostPath)
+		}
+		fi, err := os.Stat(m.HostPath)
+		if err != nil {
+			return fmt.Errorf("invalid mount %q: %w", m.Alias, err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("invalid mount %q: %s is not a directory", m.Alias, m.HostPath)
+		}
+	}
+	return nil
+}
+
+func validAlias(alias string) bool {
+	return alias != "" && alias != "." && alias != ".." && !strings.ContainsRune(alias, '/')
+}
+
+// ResolveWritePaths resolves --allow-write paths, relative to rootDir, the
+// directory served as /workspace, to absolute paths. Each must be within
+// rootDir; it need not exist yet.
+func ResolveWritePaths(rootDir string, paths []string) ([]string, error) {
+	var resolved []string
+	for _, p := range paths {
+		abs := p
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(rootDir, p)
+		}
+		abs = filepath.Clean(abs)
+		if rel, err := filepath.Rel(rootDir, abs); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return nil, fmt.Errorf("invalid --allow-write %q: not in the working directory %s", p, rootDir)
+		}
+		resolved = append(resolved, abs)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A named VM is restored by 'dh vm up --name NAME' and kept running by a
+// daemon of its own until 'dh vm down NAME', so that every
+// 'dh exec --vm --instance NAME' runs in the same Deephaven server, with
+// the variables and tables earlier runs left. The daemon serves
+// PoolRequests of type "exec", "status" and "stop" on NamedSocket(NAME),
+// one exec at a time. 'dh exec --vm --session NAME' starts one the same
+// way when it is not up, with SessionIdleTimeout.
+
+// SessionIdleTimeout is how long the named VM of an exec session, started
+// by 'dh exec --vm --session', stays up without execs.
+const SessionIdleTimeout = 30 * time.Minute
+
+// NamedVMStatus describes a named VM, as its daemon reports it.
+type NamedVMStatus struct {
+	Name       string    `json:"name"`
+	PID        int       `json:"pid"` // the daemon's
+	Version    string    `json:"version"`
+	Profile    string    `json:"profile,omitempty"`
+	Net        string    `json:"net,omitempty"`
+	Jailed     bool      `json:"jailed,omitempty"`
+	InstanceID string    `json:"instance_id"` // for dh vm logs --instance
+	StartedAt  time.Time `json:"started_at"`
+	Execs      int       `json:"execs"` // exec requests served
+
+	IdleSeconds int `json:"idle_seconds"`
+	IdleTimeout int `json:"idle_timeout_seconds,omitempty"` // 0: up until stopped
+}
+
+// ValidateVMName checks the name of a named VM. The rules are those of
+// profile names.
+func ValidateVMName(name string) error {
+	if !validProfile.MatchString(name) {
+		return fmt.Errorf("invalid VM name %q: use lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// NamedSocket returns the socket the daemon of named VM name listens on.
+func (p *VMPaths) NamedSocket(name string) string {
+	return filepath.Join(p.NamedDir, name+".sock")
+}
+
+// NamedLog returns the log file of the daemon of named VM name.
+func (p *VMPaths) NamedLog(name string) string {
+	return filepath.Join(p.NamedDir, name+".log")
+}
+
+// NamedVMs returns the names of the named VMs that have a socket, sorted.
+// A daemon that died leaves its socket behind; NamedVMProbe tells.
+func NamedVMs(paths *VMPaths) []string {
+	matches, _ := filepath.Glob(filepath.Join(paths.NamedDir, "*.sock"))
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(m), ".sock"))
+	}
+	return names
+}
@@ -0,0 +1,384 @@
+//go:build linux
+
+package vm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// NamedVMConfig configures RunNamedVM.
+type NamedVMConfig struct {
+	DHHome  string
+	Name    string
+	Version string
+	Profile string // snapshot profile, "" for the version's own snapshot
+	UseUffd bool
+	EagerMB *int   // see VMConfig.EagerMB
+	Jailed  bool   // restore under the jailer from a jailed snapshot
+	Net     string // see VMConfig.Net
+
+	// IdleTimeout stops the VM after that long without execs; 0 keeps it
+	// up until stopped.
+	IdleTimeout time.Duration
+}
+
+// namedVM is the daemon of a named VM.
+type namedVM struct {
+	name       string
+	version    string
+	profile    string
+	net        string
+	jailed     bool
+	paths      *VMPaths
+	info       *InstanceInfo
+	machine    *firecracker.Machine
+	uffdCloser io.Closer
+	startedAt  time.Time
+
+	execMu      sync.Mutex // one exec at a time: they share the server and the file server socket
+	mu          sync.Mutex
+	execs       int
+	running     int // execs in progress or waiting their turn; the VM is not idle meanwhile
+	lastReq     time.Time
+	idleTimeout time.Duration
+	listener    net.Listener
+	done        chan struct{}
+	stderr      io.Writer
+}
+
+// NamedVMProbe reports whether the daemon of named VM name accepts
+// connections.
+func NamedVMProbe(paths *VMPaths, name string) bool {
+	conn, err := net.DialTimeout("unix", paths.NamedSocket(name), 100*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// NamedVMCommand sends a request (exec, status or stop) to the daemon of
+// named VM name and returns the response.
+func NamedVMCommand(paths *VMPaths, name string, req *PoolRequest) (*PoolResponse, error) {
+	return socketRPC(paths.NamedSocket(name), fmt.Sprintf("VM %s", name), req)
+}
+
+// NamedVMCommandContext is NamedVMCommand that hangs up when ctx is done,
+// which interrupts the script of an exec request; the VM is kept.
+func NamedVMCommandContext(ctx context.Context, paths *VMPaths, name string, req *PoolRequest) (*PoolResponse, error) {
+	return socketRPCContext(ctx, paths.NamedSocket(name), fmt.Sprintf("VM %s", name), req)
+}
+
+// RunNamedVM restores a VM from the snapshot of cfg.Version and serves it
+// as named VM cfg.Name until ctx is cancelled, a stop request arrives or
+// the VM exits. The VM is destroyed and its socket removed on return.
+func RunNamedVM(ctx context.Context, cfg *NamedVMConfig, stderr io.Writer) error {
+	if err := ValidateVMName(cfg.Name); err != nil {
+		return err
+	}
+	paths := NewVMPaths(cfg.DHHome)
+	if err := os.MkdirAll(paths.NamedDir, 0o700); err != nil {
+		return fmt.Errorf("creating %s: %w", paths.NamedDir, err)
+	}
+	socketPath := paths.NamedSocket(cfg.Name)
+	if NamedVMProbe(paths, cfg.Name) {
+		return fmt.Errorf("VM %s is already up; stop it with 'dh vm down %s'", cfg.Name, cfg.Name)
+	}
+	os.Remove(socketPath) // left by a daemon that died
+
+	if errs := CheckPrerequisites(paths); len(errs) > 0 {
+		var msgs []string
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		return fmt.Errorf("VM prerequisites not met:\n  %s", strings.Join(msgs, "\n  "))
+	}
+	go CleanupStaleInstances(paths)
+
+	n := &namedVM{
+		name:        cfg.Name,
+		version:     cfg.Version,
+		profile:     cfg.Profile,
+		net:         cfg.Net,
+		jailed:      cfg.Jailed,
+		paths:       paths,
+		startedAt:   time.Now(),
+		lastReq:     time.Now(),
+		idleTimeout: cfg.IdleTimeout,
+		done:        make(chan struct{}),
+		stderr:      stderr,
+	}
+
+	vmCfg := &VMConfig{
+		DHHome:  cfg.DHHome,
+		Version: cfg.Version,
+		Profile: cfg.Profile,
+		UseUffd: cfg.UseUffd,
+		EagerMB: cfg.EagerMB,
+		Jailed:  cfg.Jailed,
+		Net:     cfg.Net,
+	}
+	var err error
+	n.info, n.machine, n.uffdCloser, err = RestoreFromSnapshot(ctx, vmCfg, paths, stderr)
+	if err != nil {
+		return fmt.Errorf("restoring VM: %w", err)
+	}
+	defer n.destroy()
+
+	n.listener, err = net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		n.listener.Close()
+		return fmt.Errorf("setting permissions of %s: %w", socketPath, err)
+	}
+	n.log("VM %s up (version=%s, instance=%s, idle_timeout=%s), listening on %s", cfg.Name, SnapshotKey(cfg.Version, cfg.Profile), n.info.ID, n.idleTimeout, socketPath)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n.acceptLoop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		n.idleWatcher()
+	}()
+
+	// The VM exiting on its own, e.g. the guest crashing, ends the daemon.
+	machineExited := make(chan struct{})
+	go func() {
+		n.machine.Wait(context.Background())
+		close(machineExited)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-n.done:
+	case <-machineExited:
+		n.log("The VM exited; see 'dh vm logs --instance %s'", n.info.ID)
+	}
+	n.shutdown()
+	wg.Wait()
+	return nil
+}
+
+// acceptLoop accepts connections on the socket until shutdown.
+func (n *namedVM) acceptLoop(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			select {
+			case <-n.done:
+				return
+			default:
+				continue
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.handleConnection(ctx, conn)
+		}()
+	}
+}
+
+// handleConnection reads a single request and dispatches it.
+func (n *namedVM) handleConnection(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+
+	var req PoolRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		n.sendResponse(conn, &PoolResponse{Type: "error", Error: "invalid request JSON"})
+		return
+	}
+
+	switch req.Type {
+	case "exec":
+		// The client hanging up, e.g. on Ctrl+C, interrupts its script so
+		// the VM is free for the next one.
+		execCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			reader.ReadByte()
+			cancel()
+		}()
+		n.handleExec(execCtx, conn, &req)
+	case "status":
+		n.sendResponse(conn, &PoolResponse{Type: "status", Named: n.status(), Version: n.version})
+	case "stop":
+		n.sendResponse(conn, &PoolResponse{Type: "ok", Version: n.version})
+		go n.shutdown()
+	default:
+		n.sendResponse(conn, &PoolResponse{Type: "error", Error: fmt.Sprintf("unknown request type: %s", req.Type)})
+	}
+}
+
+// handleExec runs the code of req in the VM, after any exec in progress,
+// with a file server for req.CWD and req.Mounts. Unlike the pool's, the VM
+// is kept, and with it what the code left in the server.
+func (n *namedVM) handleExec(ctx context.Context, conn net.Conn, req *PoolRequest) {
+	if req.Version != "" && req.Version != n.version {
+		n.sendResponse(conn, &PoolResponse{Type: "error", Error: fmt.Sprintf("VM %s runs version %s, not %s", n.name, n.version, req.Version), Version: n.version})
+		return
+	}
+	if err := ValidateMounts(req.Mounts); err != nil {
+		n.sendResponse(conn, &PoolResponse{Type: "error", Error: err.Error()})
+		return
+	}
+
+	n.mu.Lock()
+	n.running++
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.running--
+		n.lastReq = time.Now()
+		n.mu.Unlock()
+	}()
+
+	n.execMu.Lock()
+	defer n.execMu.Unlock()
+	if ctx.Err() != nil {
+		return // the client gave up waiting
+	}
+	n.mu.Lock()
+	n.execs++
+	n.mu.Unlock()
+
+	// See Pool.handleExec for why the file server uses GuestVsockPath.
+	cwd := req.CWD
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	fileServer, err := StartFileServer(n.info.GuestVsockPath, cwd, req.AllowWrite, req.Mounts...)
+	if err != nil {
+		n.log("Warning: file server: %v", err)
+	}
+	if fileServer != nil {
+		defer fileServer.Close()
+	}
+
+	resp, err := ExecuteViaVsockStream(ctx, n.info.VsockPath, VsockPort, req.vsockRequest(), nil)
+	if err != nil {
+		n.sendResponse(conn, &PoolResponse{
+			Type:    "error",
+			Error:   fmt.Sprintf("vsock exec: %v", err),
+			Version: n.version,
+		})
+		return
+	}
+	n.sendResponse(conn, &PoolResponse{
+		Type:    "exec_result",
+		Exec:    resp,
+		Version: n.version,
+	})
+}
+
+// status describes the named VM.
+func (n *namedVM) status() *NamedVMStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return &NamedVMStatus{
+		Name:        n.name,
+		PID:         os.Getpid(),
+		Version:     n.version,
+		Profile:     n.profile,
+		Net:         n.net,
+		Jailed:      n.jailed,
+		InstanceID:  n.info.ID,
+		StartedAt:   n.startedAt,
+		Execs:       n.execs,
+		IdleSeconds: int(time.Since(n.lastReq).Seconds()),
+		IdleTimeout: int(n.idleTimeout.Seconds()),
+	}
+}
+
+// idleWatcher stops the VM after idleTimeout without execs.
+func (n *namedVM) idleWatcher() {
+	if n.idleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.mu.Lock()
+			idle := time.Since(n.lastReq)
+			running := n.running
+			n.mu.Unlock()
+			if running == 0 && idle > n.idleTimeout {
+				n.log("Idle timeout reached (%.0fs > %s), shutting down", idle.Seconds(), n.idleTimeout)
+				n.shutdown()
+				return
+			}
+		}
+	}
+}
+
+// shutdown stops accepting connections. RunNamedVM destroys the VM once
+// it returns.
+func (n *namedVM) shutdown() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.done:
+		return // already shutting down
+	default:
+		close(n.done)
+	}
+	n.log("Shutting down VM %s...", n.name)
+	if n.listener != nil {
+		n.listener.Close()
+	}
+}
+
+// destroy tears down the VM.
+func (n *namedVM) destroy() {
+	DestroyInstance(n.machine, n.info, n.paths)
+	if n.uffdCloser != nil {
+		n.uffdCloser.Close()
+	}
+}
+
+// sendResponse writes a JSON response to the connection.
+func (n *namedVM) sendResponse(conn net.Conn, resp *PoolResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	conn.Write(data)
+}
+
+// log writes a timestamped message to stderr.
+func (n *namedVM) log(format string, args ...any) {
+	if n.stderr != nil {
+		msg := fmt.Sprintf(format, args...)
+		fmt.Fprintf(n.stderr, "[%s] %s %s\n", n.name, time.Now().Format("15:04:05"), msg)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package vm
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNamedVMExecAndStatus(t *testing.T) {
+	vsockPath, reqCh := fakeRunner(t, `{"exit_code": 0, "stdout": "42\n", "stderr": "", "result_repr": null, "error": null, "tables": []}`)
+	n := &namedVM{
+		name:    "dev",
+		version: "0.37.0",
+		paths:   NewVMPaths(t.TempDir()),
+		info:    &InstanceInfo{ID: "exec-1", Version: "0.37.0", VsockPath: vsockPath, GuestVsockPath: filepath.Join(t.TempDir(), "vsock.sock")},
+		done:    make(chan struct{}),
+	}
+
+	resp := poolRoundTrip(t, func(c net.Conn) {
+		n.handleExec(context.Background(), c, &PoolRequest{Type: "exec", Code: "print(x)", CWD: t.TempDir()})
+	})
+	if resp.Type != "exec_result" || resp.Exec == nil || resp.Exec.Stdout != "42\n" || resp.Version != "0.37.0" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if req := <-reqCh; req.Code != "print(x)" {
+		t.Errorf("runner got code %q", req.Code)
+	}
+
+	resp = poolRoundTrip(t, func(c net.Conn) {
+		n.handleExec(context.Background(), c, &PoolRequest{Type: "exec", Code: "x", Version: "0.36.0"})
+	})
+	if resp.Type != "error" || !strings.Contains(resp.Error, "runs version 0.37.0") {
+		t.Errorf("version mismatch response = %+v", resp)
+	}
+
+	s := n.status()
+	if s.Name != "dev" || s.Version != "0.37.0" || s.InstanceID != "exec-1" || s.Execs != 1 || s.PID != os.Getpid() {
+		t.Errorf("status = %+v", s)
+	}
+}
+
+func TestNamedVMs(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	if names := NamedVMs(paths); len(names) != 0 {
+		t.Errorf("NamedVMs() = %v, want none", names)
+	}
+	os.MkdirAll(paths.NamedDir, 0o700)
+	for _, name := range []string{"web", "dev"} {
+		os.WriteFile(paths.NamedSocket(name), nil, 0o600)
+	}
+	os.WriteFile(paths.NamedLog("dev"), nil, 0o600)
+	if names := NamedVMs(paths); strings.Join(names, ",") != "dev,web" {
+		t.Errorf("NamedVMs() = %v, want [dev web]", names)
+	}
+	if NamedVMProbe(paths, "dev") {
+		t.Error("a socket nothing listens on probed as up")
+	}
+
+	for _, name := range []string{"dev", "ci-2"} {
+		if err := ValidateVMName(name); err != nil {
+			t.Errorf("ValidateVMName(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Dev", "-x", "a/b"} {
+		if err := ValidateVMName(name); err == nil {
+			t.Errorf("ValidateVMName(%q) accepted", name)
+		}
+	}
+}
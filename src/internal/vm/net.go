@@ -0,0 +1,160 @@
+package vm
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// VMs are isolated by default: the host talks to them only over vsock. A
+// snapshot prepared with --net=nat has a network interface whose traffic
+// goes out through NAT on the host. Its guest address, gateway and tap
+// device are in the snapshot, so every VM restored from it gets a network
+// namespace of its own holding a tap device of that name and address, and
+// reaches the host through a veth pair:
+//
+//	guest eth0 172.16.0.2 ─ dhtap0 172.16.0.1 ─ [NAT] veth0 10.201.x.y+2
+//	  ─ dhv<n> 10.201.x.y+1 on the host ─ [NAT] the host's default route
+//
+// Setting this up runs ip and iptables, and needs root.
+
+// NetNAT is the --net mode that gives VMs outbound network access.
+const NetNAT = "nat"
+
+// NetModes are the valid values of --net.
+var NetModes = []string{"none", NetNAT}
+
+// Addresses of the VM network. The guest configures eth0 in init.sh; the
+// tap has a fixed MAC so the guest's ARP entry for its gateway, kept in the
+// snapshot, stays valid in every VM restored from it.
+const (
+	netTapName   = "dhtap0"
+	netTapMAC    = "06:00:ac:10:00:01"
+	netGuestMAC  = "06:00:ac:10:00:02"
+	netGatewayIP = "172.16.0.1"
+	netGuestIP   = "172.16.0.2"
+	netPrefixLen = 30
+
+	// netVethBase is the range the veth pair of VM network n gets its
+	// /30 from.
+	netVethBase = "10.201.0.0"
+	netMaxIndex = 1<<14 - 1
+)
+
+// ParseNetMode validates a --net value and returns it as stored in VMConfig
+// and the snapshot metadata: "" for none.
+func ParseNetMode(mode string) (string, error) {
+	switch mode {
+	case "", "none":
+		return "", nil
+	case NetNAT:
+		return NetNAT, nil
+	}
+	return "", fmt.Errorf("invalid --net %q: use %s", mode, strings.Join(NetModes, " or "))
+}
+
+// netKernelArgs returns the kernel command line parameters that have
+// init.sh configure the guest's network for mode, with nameserver dns.
+func netKernelArgs(mode, dns string) string {
+	if mode != NetNAT {
+		return ""
+	}
+	return fmt.Sprintf(" dh_net=%s dh_dns=%s", mode, dns)
+}
+
+// hostNameserver returns the first nameserver in resolv.conf the guest can
+// reach: loopback resolvers such as systemd-resolved's stub only answer on
+// the host.
+func hostNameserver(resolvConf string) string {
+	for _, line := range strings.Split(resolvConf, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(fields[1]); err == nil && addr.Is4() && !addr.IsLoopback() {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+// guestNameserver returns the nameserver for guests, from the host's
+// resolver configuration or else a public one.
+func guestNameserver() string {
+	// systemd-resolved lists its upstream servers here.
+	for _, path := range []string{"/run/systemd/resolve/resolv.conf", "/etc/resolv.conf"} {
+		if data, err := os.ReadFile(path); err == nil {
+			if ns := hostNameserver(string(data)); ns != "" {
+				return ns
+			}
+		}
+	}
+	return "1.1.1.1"
+}
+
+// netNamespace returns the name of the network namespace of VM network n.
+func netNamespace(n int) string {
+	return fmt.Sprintf("dhnet%d", n)
+}
+
+// netVethAddrs returns the /30 of VM network n's veth pair and the
+// addresses of its host and namespace ends.
+func netVethAddrs(n int) (subnet, host, peer netip.Addr) {
+	b := netip.MustParseAddr(netVethBase).As4()
+	off := uint32(n) * 4
+	b[2] += byte(off >> 8)
+	b[3] += byte(off)
+	subnet = netip.AddrFrom4(b)
+	host = subnet.Next()
+	return subnet, host, host.Next()
+}
+
+// netSetupCommands returns the commands that, after the namespace of VM
+// network n is added, connect it to the host and give it the tap device.
+func netSetupCommands(n int) [][]string {
+	ns, veth := netNamespace(n), fmt.Sprintf("dhv%d", n)
+	_, host, peer := netVethAddrs(n)
+	cmds := [][]string{
+		{"ip", "link", "add", veth, "type", "veth", "peer", "name", "veth0", "netns", ns},
+		{"ip", "addr", "add", fmt.Sprintf("%s/%d", host, netPrefixLen), "dev", veth},
+		{"ip", "link", "set", veth, "up"},
+		{"ip", "-n", ns, "link", "set", "lo", "up"},
+		{"ip", "-n", ns, "addr", "add", fmt.Sprintf("%s/%d", peer, netPrefixLen), "dev", "veth0"},
+		{"ip", "-n", ns, "link", "set", "veth0", "up"},
+		{"ip", "-n", ns, "route", "add", "default", "via", host.String()},
+		{"ip", "-n", ns, "tuntap", "add", "dev", netTapName, "mode", "tap"},
+		{"ip", "-n", ns, "link", "set", netTapName, "address", netTapMAC},
+		{"ip", "-n", ns, "addr", "add", fmt.Sprintf("%s/%d", netGatewayIP, netPrefixLen), "dev", netTapName},
+		{"ip", "-n", ns, "link", "set", netTapName, "up"},
+		{"ip", "netns", "exec", ns, "sysctl", "-qw", "net.ipv4.ip_forward=1"},
+		{"ip", "netns", "exec", ns, "iptables", "-t", "nat", "-A", "POSTROUTING", "-o", "veth0", "-j", "MASQUERADE"},
+	}
+	for _, rule := range netHostRules(n) {
+		cmds = append(cmds, append([]string{"iptables", "-A"}, rule...))
+	}
+	return cmds
+}
+
+// netTeardownCommands returns the commands that remove VM network n.
+// Deleting the namespace's end of the veth pair deletes both ends.
+func netTeardownCommands(n int) [][]string {
+	var cmds [][]string
+	for _, rule := range netHostRules(n) {
+		cmds = append(cmds, append([]string{"iptables", "-D"}, rule...))
+	}
+	return append(cmds, []string{"ip", "netns", "del", netNamespace(n)})
+}
+
+// netHostRules returns the host iptables rules of VM network n, as
+// arguments after -A or -D: the namespace's traffic is forwarded and
+// masqueraded as the host's.
+func netHostRules(n int) [][]string {
+	veth := fmt.Sprintf("dhv%d", n)
+	subnet, _, _ := netVethAddrs(n)
+	return [][]string{
+		{"POSTROUTING", "-t", "nat", "-s", fmt.Sprintf("%s/%d", subnet, netPrefixLen), "!", "-o", veth, "-j", "MASQUERADE"},
+		{"FORWARD", "-i", veth, "-j", "ACCEPT"},
+		{"FORWARD", "-o", veth, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package vm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+)
+
+// newVMNet sets up a VM network (see net.go) and returns its index, which
+// removeVMNet takes. Namespaces are numbered from 1: the first free number
+// is taken by adding its namespace, which fails when another VM has it.
+func newVMNet() (int, error) {
+	if os.Geteuid() != 0 {
+		return 0, fmt.Errorf("--net=nat needs root: it adds a network namespace, tap device and iptables rules for the VM")
+	}
+	for _, tool := range []string{"ip", "iptables"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return 0, fmt.Errorf("--net=nat needs %s on PATH", tool)
+		}
+	}
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0o644); err != nil {
+		return 0, fmt.Errorf("enabling IP forwarding: %w", err)
+	}
+
+	for n := 1; n <= netMaxIndex; n++ {
+		if _, err := os.Stat(netNamespacePath(n)); err == nil {
+			continue
+		}
+		if err := runNetCommand([]string{"ip", "netns", "add", netNamespace(n)}); err != nil {
+			if strings.Contains(err.Error(), "exists") {
+				continue
+			}
+			return 0, err
+		}
+		for _, args := range netSetupCommands(n) {
+			if err := runNetCommand(args); err != nil {
+				removeVMNet(n)
+				return 0, err
+			}
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("no free VM network: %d are in use", netMaxIndex)
+}
+
+// removeVMNet removes VM network n. Errors are ignored: it also cleans up
+// after a partial setup.
+func removeVMNet(n int) {
+	if n <= 0 {
+		return
+	}
+	for _, args := range netTeardownCommands(n) {
+		runNetCommand(args)
+	}
+}
+
+// netNamespacePath returns the handle of VM network n's namespace, which
+// Firecracker (or the jailer) is started in.
+func netNamespacePath(n int) string {
+	return filepath.Join("/var/run/netns", netNamespace(n))
+}
+
+func runNetCommand(args []string) error {
+	output.Tracef("net: %s", strings.Join(args, " "))
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
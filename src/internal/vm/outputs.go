@@ -0,0 +1,98 @@
+package vm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// GuestOutputDir is where scripts run with 'dh exec --collect-outputs'
+// save files for the host. The runner empties it before the script starts
+// and exports it as DH_OUTPUT_DIR.
+const GuestOutputDir = "/tmp/outputs"
+
+// OutputFile is a file the runner sent back from GuestOutputDir.
+type OutputFile struct {
+	Path string `json:"path"` // relative to GuestOutputDir, slash-separated
+	Size int64  `json:"size"`
+}
+
+// OutputDir saves the files a runner sends back from GuestOutputDir under
+// a host directory. File paths from the guest can't leave it, not even
+// through symlinks already in the directory.
+type OutputDir struct {
+	root  *os.Root
+	files map[string]*os.File
+}
+
+// OpenOutputDir creates dir if needed and opens it for output files.
+func OpenOutputDir(dir string) (*OutputDir, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &OutputDir{root: root, files: map[string]*os.File{}}, nil
+}
+
+// Name returns the host directory.
+func (d *OutputDir) Name() string { return d.root.Name() }
+
+// write appends a chunk of the file at path, the base64 data of a "file"
+// frame. The first chunk of a path replaces any file already there.
+func (d *OutputDir) write(path, data string) error {
+	name := filepath.FromSlash(path)
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("output file %q is outside the output directory", path)
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("output file %q: %w", path, err)
+	}
+	f := d.files[name]
+	if f == nil {
+		if dir := filepath.Dir(name); dir != "." {
+			if err := d.root.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+		}
+		if f, err = d.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644); err != nil {
+			return err
+		}
+		d.files[name] = f
+	}
+	_, err = f.Write(b)
+	return err
+}
+
+// check compares the files saved against the list in the response.
+func (d *OutputDir) check(files []OutputFile) error {
+	for _, of := range files {
+		fi, err := d.root.Stat(filepath.FromSlash(of.Path))
+		if err != nil {
+			return fmt.Errorf("output file %s was not received", of.Path)
+		}
+		if fi.Size() != of.Size {
+			return fmt.Errorf("output file %s: received %d of %d bytes", of.Path, fi.Size(), of.Size)
+		}
+	}
+	return nil
+}
+
+// Close closes the files and the directory.
+func (d *OutputDir) Close() error {
+	var first error
+	for _, f := range d.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	d.files = nil
+	if err := d.root.Close(); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
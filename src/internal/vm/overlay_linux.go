@@ -0,0 +1,63 @@
+//go:build linux
+
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneDisk is cloneFile; tests replace it to act as a filesystem without
+// reflinks.
+var cloneDisk = cloneFile
+
+// instanceDisk gives a restored VM its own copy of the snapshot disk, so
+// that concurrent VMs never write to the shared disk.ext4. It returns the
+// path of the copy in instanceDir: a reflink clone of snapDisk, with cloned
+// set, or on filesystems without reflinks (ext4, tmpfs) a full copy, when
+// there is room for one.
+func instanceDisk(snapDisk, instanceDir string) (path string, cloned bool, err error) {
+	overlay := filepath.Join(instanceDir, "disk.ext4")
+	cloneErr := cloneDisk(snapDisk, overlay)
+	if cloneErr == nil {
+		return overlay, true, nil
+	}
+
+	fi, err := os.Stat(snapDisk)
+	if err != nil {
+		return "", false, fmt.Errorf("copying snapshot disk: %w", err)
+	}
+	needMiB := int(fi.Size()>>20) + minFreeDiskMiB
+	if free, err := diskFreeMiB(instanceDir); err == nil && free < needMiB {
+		return "", false, fmt.Errorf("no reflinks under %s (%v), and no room for a copy of the snapshot disk: %d MiB free, %d MiB needed", instanceDir, cloneErr, free, needMiB)
+	}
+	if err := copyFile(snapDisk, overlay); err != nil {
+		os.Remove(overlay)
+		return "", false, fmt.Errorf("copying snapshot disk: %w", err)
+	}
+	return overlay, false, nil
+}
+
+// cloneFile makes dst a reflink clone of src (FICLONE): it shares src's
+// blocks until either is written, so creating it copies no data.
+func cloneFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("cloning %s: %w", filepath.Base(src), err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstanceDisk(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "snap", "disk.ext4")
+	instance := filepath.Join(dir, "run", "exec-1")
+	for _, d := range []string{filepath.Dir(src), instance} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := bytes.Repeat([]byte("ext4"), 4096)
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	overlay, cloned, err := instanceDisk(src, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cloned {
+		t.Log("filesystem has no reflinks; got a full copy")
+	}
+	if overlay != filepath.Join(instance, "disk.ext4") {
+		t.Errorf("overlay = %q", overlay)
+	}
+	got, err := os.ReadFile(overlay)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("clone differs from the snapshot disk (%v)", err)
+	}
+
+	// Writing the clone leaves the snapshot disk alone.
+	if err := os.WriteFile(overlay, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(src); !bytes.Equal(got, data) {
+		t.Error("writing the clone changed the snapshot disk")
+	}
+}
+
+func TestInstanceDisk_NoReflinks(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "disk.ext4")
+	instance := filepath.Join(dir, "exec-1")
+	os.Mkdir(instance, 0o755)
+	data := bytes.Repeat([]byte("ext4"), 4096)
+	os.WriteFile(src, data, 0o644)
+
+	cloneDisk = func(src, dst string) error { return errors.New("operation not supported") }
+	defer func() { cloneDisk = cloneFile }()
+
+	// Without reflinks the VM gets a full copy, never the shared disk: a
+	// restored VM's drive is as writable as it was in the snapshot.
+	overlay, cloned, err := instanceDisk(src, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cloned || overlay != filepath.Join(instance, "disk.ext4") {
+		t.Errorf("instanceDisk = %q, cloned %v", overlay, cloned)
+	}
+	if err := os.WriteFile(overlay, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(src); !bytes.Equal(got, data) {
+		t.Error("writing the copy changed the snapshot disk")
+	}
+}
+
+func TestCloneFile_ExistingTarget(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	os.WriteFile(src, []byte("a"), 0o644)
+	os.WriteFile(dst, []byte("keep"), 0o644)
+
+	if err := cloneFile(src, dst); err == nil {
+		t.Fatal("expected an error cloning onto an existing file")
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "keep" {
+		t.Errorf("existing file was changed to %q", got)
+	}
+}
@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RootfsPackage is one package installed in a VM rootfs image.
+type RootfsPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Type    string `json:"type"` // "deb" or "pypi"
+}
+
+// RootfsManifest lists what a rootfs image was built from. It is written
+// next to the image when the rootfs is built.
+type RootfsManifest struct {
+	OS        string          `json:"os"`                   // e.g. "ubuntu 22.04", from /etc/os-release
+	BaseImage string          `json:"base_image,omitempty"` // FROM image; empty in manifests from before it was recorded
+	Python    string          `json:"python,omitempty"`     // e.g. "3.10.12", from python3 --version
+	Packages  []RootfsPackage `json:"packages"`
+
+	// ReadOnlyRoot is set when the image's init.sh can run the guest on an
+	// overlay of a read-only disk. Images from before it can't.
+	ReadOnlyRoot bool `json:"read_only_root,omitempty"`
+}
+
+// ManifestForVersion returns the path of the package manifest for a
+// version's rootfs.
+func (p *VMPaths) ManifestForVersion(version string) string {
+	return filepath.Join(p.RootfsDir, rootfsName(version)+".packages.json")
+}
+
+// ReadRootfsManifest reads the package manifest for a version's rootfs.
+// Rootfs images built before manifests were recorded have none; the error
+// then satisfies os.IsNotExist.
+func ReadRootfsManifest(paths *VMPaths, version string) (*RootfsManifest, error) {
+	data, err := os.ReadFile(paths.ManifestForVersion(version))
+	if err != nil {
+		return nil, err
+	}
+	var m RootfsManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing rootfs manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// WriteRootfsManifest writes the package manifest for a version's rootfs.
+func WriteRootfsManifest(paths *VMPaths, version string, m *RootfsManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(paths.ManifestForVersion(version), data, 0o644)
+}
+
+// ParseOSRelease returns "ID VERSION_ID" from /etc/os-release content.
+func ParseOSRelease(content string) string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			fields[k] = strings.Trim(v, `"'`)
+		}
+	}
+	return strings.TrimSpace(fields["ID"] + " " + fields["VERSION_ID"])
+}
+
+// ParseDpkgList parses `dpkg-query -W -f '${Package}\t${Version}\n'` output.
+func ParseDpkgList(out string) []RootfsPackage {
+	return parsePackageLines(out, "\t", "deb")
+}
+
+// ParsePipFreeze parses `pip list --format=freeze` output.
+func ParsePipFreeze(out string) []RootfsPackage {
+	return parsePackageLines(out, "==", "pypi")
+}
+
+func parsePackageLines(out, sep, typ string) []RootfsPackage {
+	var pkgs []RootfsPackage
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		name, version, ok := strings.Cut(strings.TrimSpace(sc.Text()), sep)
+		if !ok || name == "" || version == "" {
+			continue
+		}
+		pkgs = append(pkgs, RootfsPackage{Name: name, Version: version, Type: typ})
+	}
+	return pkgs
+}
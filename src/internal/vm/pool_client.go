@@ -4,16 +4,24 @@ package vm
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
 )
 
 // PoolSocketPath returns the Unix socket path for the pool daemon.
-// Uses the current user's UID to avoid conflicts between users.
+// Uses the current user's UID to avoid conflicts between users, unless
+// DH_POOL_SOCKET names another, such as a shared pool another user runs.
 func PoolSocketPath() string {
+	if p := os.Getenv("DH_POOL_SOCKET"); p != "" {
+		return p
+	}
 	return fmt.Sprintf("/tmp/dh-pool-%d.sock", os.Getuid())
 }
 
@@ -38,14 +46,71 @@ func PoolCommand(req *PoolRequest) (*PoolResponse, error) {
 	return poolRPC(req)
 }
 
+// PoolShell asks the pool daemon for a shell in one of its warm VMs. On
+// success the returned connection carries the shell's terminal bytes in
+// both directions; closing it ends the shell and destroys the VM.
+func PoolShell(req *PoolRequest) (*PoolResponse, io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("unix", PoolSocketPath(), 2*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to pool daemon: %w", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	reqBytes = append(reqBytes, '\n')
+	output.TraceFrame("pool", "->", reqBytes)
+	if _, err := conn.Write(reqBytes); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	respLine, err := reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("reading response: %w", err)
+	}
+	output.TraceFrame("pool", "<-", respLine)
+
+	var resp PoolResponse
+	if err := json.Unmarshal(respLine, &resp); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("parsing response: %w", err)
+	}
+	if resp.Type != "shell" {
+		conn.Close()
+		return &resp, nil, nil
+	}
+	conn.SetDeadline(time.Time{})
+	return &resp, &bufferedConn{Reader: reader, Conn: conn}, nil
+}
+
 // poolRPC sends a request to the pool daemon over the Unix socket and reads
 // the response. Uses newline-delimited JSON (same pattern as vsock protocol).
 func poolRPC(req *PoolRequest) (*PoolResponse, error) {
-	conn, err := net.DialTimeout("unix", PoolSocketPath(), 2*time.Second)
+	return socketRPC(PoolSocketPath(), "pool daemon", req)
+}
+
+// socketRPC sends a request to the daemon (the pool's, or a named VM's)
+// listening on socketPath and reads the response.
+func socketRPC(socketPath, daemon string, req *PoolRequest) (*PoolResponse, error) {
+	return socketRPCContext(context.Background(), socketPath, daemon, req)
+}
+
+// socketRPCContext is socketRPC that hangs up when ctx is done, and then
+// fails with an error wrapping ctx.Err().
+func socketRPCContext(ctx context.Context, socketPath, daemon string, req *PoolRequest) (*PoolResponse, error) {
+	conn, err := net.DialTimeout("unix", socketPath, 2*time.Second)
 	if err != nil {
-		return nil, fmt.Errorf("connecting to pool daemon: %w", err)
+		return nil, fmt.Errorf("connecting to %s: %w", daemon, err)
 	}
 	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
 	conn.SetDeadline(time.Now().Add(5 * time.Minute))
 
@@ -54,6 +119,7 @@ func poolRPC(req *PoolRequest) (*PoolResponse, error) {
 		return nil, fmt.Errorf("marshaling request: %w", err)
 	}
 	reqBytes = append(reqBytes, '\n')
+	output.TraceFrame("pool", "->", reqBytes)
 	if _, err := conn.Write(reqBytes); err != nil {
 		return nil, fmt.Errorf("sending request: %w", err)
 	}
@@ -61,8 +127,12 @@ func poolRPC(req *PoolRequest) (*PoolResponse, error) {
 	reader := bufio.NewReader(conn)
 	respLine, err := reader.ReadBytes('\n')
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s: %w", daemon, ctx.Err())
+		}
 		return nil, fmt.Errorf("reading response: %w", err)
 	}
+	output.TraceFrame("pool", "<-", respLine)
 
 	var resp PoolResponse
 	if err := json.Unmarshal(respLine, &resp); err != nil {
@@ -6,39 +6,69 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+	"golang.org/x/sys/unix"
 )
 
-// Pool manages a set of pre-warmed Firecracker VMs for fast execution.
+// maxPoolWait caps PoolRequest.WaitMs.
+const maxPoolWait = 10 * time.Second
+
+// resourceBackoff is how long backfill waits after the host had no room
+// for another VM.
+const resourceBackoff = 10 * time.Second
+
+// prefillWorkers is how many of the initial warm VMs are restored at once.
+const prefillWorkers = 4
+
+// Pool manages a set of pre-warmed Firecracker VMs for fast execution,
+// with a warm queue for each Deephaven version it serves.
 type Pool struct {
 	mu          sync.Mutex
-	version     string
+	versions    []string // served versions; the first is the default
+	queues      map[string]*poolQueue
 	paths       *VMPaths
 	dhHome      string
-	targetSize  int
 	idleTimeout time.Duration
 	verbose     bool
 	useUffd     bool
-
-	// Warm VM queue — buffered channel acts as a thread-safe FIFO.
-	ready chan *poolVM
+	eagerMB     *int
+	metricsAddr string
+	metrics     *poolMetrics
+	uid         int           // the daemon's user; others are refused unless allowed
+	allowUIDs   map[int]bool  // other users whose requests are served
+	seq         atomic.Uint64 // keeps concurrently created instance IDs apart
 
 	// Lifecycle
 	listener net.Listener
+	httpSrv  *http.Server // serves /metrics when metricsAddr is set
 	lastReq  time.Time
+	shells   int // interactive shells in progress; the pool stays up for them
 	done     chan struct{}
 	wg       sync.WaitGroup
 	stderr   io.Writer
 }
 
+// poolQueue holds the warm VMs of one version.
+type poolQueue struct {
+	version    string
+	targetSize int // guarded by Pool.mu
+
+	// Warm VM queue — buffered channel acts as a thread-safe FIFO.
+	ready chan *poolVM
+}
+
 // poolVM is a pre-warmed VM instance waiting in the ready queue.
 type poolVM struct {
 	info       *InstanceInfo
@@ -51,50 +81,95 @@ type poolVM struct {
 // PoolConfig configures a new Pool.
 type PoolConfig struct {
 	DHHome      string
-	Version     string
-	TargetSize  int
+	Versions    []string // a warm queue is kept for each; the first is the default
+	TargetSize  int      // warm VMs per version
 	IdleTimeout time.Duration
 	Verbose     bool
 	UseUffd     bool
+	EagerMB     *int   // see VMConfig.EagerMB
+	MetricsAddr string // serve Prometheus metrics over HTTP at this address; "" for none
+	AllowUIDs   []int  // users besides the daemon's own who may use the pool
 }
 
 // NewPool creates a new pool manager. Call Start to begin operation.
 func NewPool(cfg PoolConfig) *Pool {
 	paths := NewVMPaths(cfg.DHHome)
-	return &Pool{
-		version:     cfg.Version,
+	p := &Pool{
+		queues:      make(map[string]*poolQueue, len(cfg.Versions)),
 		paths:       paths,
 		dhHome:      cfg.DHHome,
-		targetSize:  cfg.TargetSize,
 		idleTimeout: cfg.IdleTimeout,
 		verbose:     cfg.Verbose,
 		useUffd:     cfg.UseUffd,
-		ready:       make(chan *poolVM, cfg.TargetSize+4), // small buffer headroom
+		eagerMB:     cfg.EagerMB,
+		metricsAddr: cfg.MetricsAddr,
+		metrics:     newPoolMetrics(),
+		uid:         os.Geteuid(),
+		allowUIDs:   make(map[int]bool, len(cfg.AllowUIDs)),
 		done:        make(chan struct{}),
 		lastReq:     time.Now(),
 	}
+	for _, uid := range cfg.AllowUIDs {
+		p.allowUIDs[uid] = true
+	}
+	for _, v := range cfg.Versions {
+		if p.queues[v] != nil {
+			continue
+		}
+		p.versions = append(p.versions, v)
+		p.queues[v] = &poolQueue{
+			version:    v,
+			targetSize: cfg.TargetSize,
+			ready:      make(chan *poolVM, cfg.TargetSize+4), // small buffer headroom
+		}
+	}
+	return p
+}
+
+// queue returns the warm queue for version, or the default version's when
+// version is empty. It is nil for versions the pool doesn't serve.
+func (p *Pool) queue(version string) *poolQueue {
+	if version == "" && len(p.versions) > 0 {
+		version = p.versions[0]
+	}
+	return p.queues[version]
 }
 
-// Start fills the pool, starts the Unix socket listener, and runs the
-// idle timer. Blocks until Shutdown is called or context is cancelled.
+// Start fills the pool, starts the Unix socket listener once the first VM
+// is warm, and runs the idle timer. Blocks until Shutdown is called or
+// context is cancelled.
 func (p *Pool) Start(ctx context.Context, stderr io.Writer) error {
 	p.stderr = stderr
 
-	// Verify snapshot exists
-	if err := CheckSnapshot(p.paths, p.version); err != nil {
+	if len(p.versions) == 0 {
+		return fmt.Errorf("no version to serve")
+	}
+
+	// Verify snapshots exist
+	for _, v := range p.versions {
+		if err := CheckSnapshot(p.paths, v); err != nil {
+			return err
+		}
+	}
+
+	if err := p.fitToHost(); err != nil {
 		return err
 	}
 
 	// Start page cache warming
-	WarmSnapshotPageCacheAsync(p.paths, p.version)
+	for _, v := range p.versions {
+		WarmSnapshotPageCacheAsync(p.paths, v)
+	}
 
-	// Pre-fill pool
-	for i := 0; i < p.targetSize; i++ {
-		if err := p.fillOne(ctx); err != nil {
-			p.log("Warning: failed to pre-fill VM %d/%d: %v", i+1, p.targetSize, err)
-		} else {
-			p.log("Pre-warmed VM %d/%d", i+1, p.targetSize)
-		}
+	// Pre-fill pool, and serve as soon as there is a VM to hand out
+	firstReady, prefilled := p.prefill(ctx)
+	select {
+	case <-firstReady:
+	case <-ctx.Done():
+		p.Shutdown()
+		p.wg.Wait()
+		p.drainAll() // VMs that finished restoring during shutdown
+		return nil
 	}
 
 	// Start Unix socket listener
@@ -106,13 +181,54 @@ func (p *Pool) Start(ctx context.Context, stderr io.Writer) error {
 		p.drainAll()
 		return fmt.Errorf("listening on %s: %w", socketPath, err)
 	}
+	// Only the owner may connect unless other users are allowed;
+	// handleConnection checks who is on the other end either way.
+	socketMode := os.FileMode(0o600)
+	if len(p.allowUIDs) > 0 {
+		socketMode = 0o666
+	}
+	if err := os.Chmod(socketPath, socketMode); err != nil {
+		p.listener.Close()
+		os.Remove(socketPath)
+		p.drainAll()
+		return fmt.Errorf("setting permissions of %s: %w", socketPath, err)
+	}
 
-	p.log("Pool daemon listening on %s (version=%s, pool_size=%d, idle_timeout=%s)",
-		socketPath, p.version, p.targetSize, p.idleTimeout)
+	if p.metricsAddr != "" {
+		ln, err := net.Listen("tcp", p.metricsAddr)
+		if err != nil {
+			p.listener.Close()
+			os.Remove(socketPath)
+			p.drainAll()
+			return fmt.Errorf("listening on %s for metrics: %w", p.metricsAddr, err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			p.writeMetrics(w)
+		})
+		p.httpSrv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go p.httpSrv.Serve(ln)
+		p.log("Serving metrics on http://%s/metrics", ln.Addr())
+	}
 
-	// Start backfill goroutine
+	p.log("Pool daemon listening on %s (versions=%s, pool_size=%d, idle_timeout=%s)",
+		socketPath, strings.Join(p.versions, ","), p.queues[p.versions[0]].targetSize, p.idleTimeout)
+
+	// Start a backfill goroutine per version once the pre-fill is over
 	p.wg.Add(1)
-	go p.backfillLoop(ctx)
+	go func() {
+		defer p.wg.Done()
+		select {
+		case <-prefilled:
+		case <-p.done:
+			return
+		}
+		for _, v := range p.versions {
+			p.wg.Add(1)
+			go p.backfillLoop(ctx, p.queues[v])
+		}
+	}()
 
 	// Start idle watcher
 	p.wg.Add(1)
@@ -130,12 +246,128 @@ func (p *Pool) Start(ctx context.Context, stderr io.Writer) error {
 	}
 
 	p.wg.Wait()
+	p.drainAll() // VMs that finished restoring during shutdown
 	return nil
 }
 
-// fillOne restores one VM from snapshot and puts it in the ready channel.
-func (p *Pool) fillOne(ctx context.Context) error {
-	instanceID := fmt.Sprintf("pool-%d", time.Now().UnixNano())
+// fitToHost lowers the target sizes of the queues to the warm VMs the host
+// has memory and disk for, taking the versions in turn as prefill does. It
+// fails when there is no room for a single VM.
+func (p *Pool) fitToHost() error {
+	if resourceChecksDisabled() {
+		return nil
+	}
+	r, err := ReadHostResources(p.paths)
+	if err != nil {
+		p.log("Warning: not checking host resources: %v", err)
+		return nil
+	}
+	vmMiB := make(map[string]int, len(p.versions))
+	for _, v := range p.versions {
+		meta, err := ReadSnapshotMetadata(p.paths.SnapshotDirForVersion(v))
+		if err != nil {
+			meta = &SnapshotMetadata{}
+		}
+		vmMiB[v] = VMMemEstimateMiB(meta)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fit := make(map[string]int, len(p.versions))
+	memMiB, diskMiB, want, total := 0, 0, 0, 0
+	for _, v := range p.versions {
+		want += p.queues[v].targetSize
+	}
+	for i := 0; total < want; i++ {
+		for _, v := range p.versions {
+			if i >= p.queues[v].targetSize {
+				continue
+			}
+			if memMiB+vmMiB[v] > r.MemFreeMiB() || diskMiB+instanceDiskMiB > r.DiskFreeMiB-minFreeDiskMiB {
+				if total == 0 {
+					return r.CheckVMs(1, vmMiB[v])
+				}
+				for _, v := range p.versions {
+					p.queues[v].targetSize = fit[v]
+				}
+				p.log("Warning: the host has room for %d of the %d warm VMs asked for (memory %s, %d MiB disk free); pool size reduced",
+					total, want, r, r.DiskFreeMiB)
+				return nil
+			}
+			memMiB += vmMiB[v]
+			diskMiB += instanceDiskMiB
+			fit[v]++
+			total++
+		}
+	}
+	return nil
+}
+
+// prefill restores the initial warm VMs of every queue, prefillWorkers at
+// a time, taking the versions in turn so each gets a VM early. firstReady
+// is closed once a VM is in a queue, or every attempt has failed; done
+// once the pre-fill is over.
+func (p *Pool) prefill(ctx context.Context) (firstReady, done <-chan struct{}) {
+	var jobs []*poolQueue
+	for i := 0; ; i++ {
+		n := len(jobs)
+		for _, v := range p.versions {
+			if q := p.queues[v]; i < q.targetSize {
+				jobs = append(jobs, q)
+			}
+		}
+		if len(jobs) == n {
+			break
+		}
+	}
+
+	first := make(chan struct{})
+	finished := make(chan struct{})
+	var once sync.Once
+	signalFirst := func() { once.Do(func() { close(first) }) }
+
+	work := make(chan *poolQueue)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(work)
+		for _, q := range jobs {
+			select {
+			case work <- q:
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for range min(prefillWorkers, len(jobs)) {
+		workers.Add(1)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer workers.Done()
+			for q := range work {
+				if err := p.fillOne(ctx, q); err != nil {
+					p.log("Warning: failed to pre-fill %s VM: %v", q.version, err)
+					continue
+				}
+				p.log("Pre-warmed %s VM (%d/%d ready)", q.version, len(q.ready), q.targetSize)
+				signalFirst()
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		signalFirst()
+		close(finished)
+	}()
+	return first, finished
+}
+
+// fillOne restores one VM from snapshot and puts it in q's ready channel.
+func (p *Pool) fillOne(ctx context.Context, q *poolQueue) error {
+	instanceID := fmt.Sprintf("pool-%d-%d", time.Now().UnixNano(), p.seq.Add(1))
 	instanceDir := p.paths.InstanceDir(instanceID)
 	if err := os.MkdirAll(instanceDir, 0o755); err != nil {
 		return fmt.Errorf("creating instance dir: %w", err)
@@ -145,11 +377,11 @@ func (p *Pool) fillOne(ctx context.Context) error {
 
 	cfg := &VMConfig{
 		DHHome:       p.dhHome,
-		Version:      p.version,
+		Version:      q.version,
 		Verbose:      false, // suppress per-VM verbose output in pool
 		UseUffd:      p.useUffd,
+		EagerMB:      p.eagerMB,
 		VsockUDSPath: vsockPath,
-		ReadOnlyDisk: true,
 	}
 
 	info, machine, uffdCloser, err := RestoreFromSnapshot(ctx, cfg, p.paths, io.Discard)
@@ -167,7 +399,7 @@ func (p *Pool) fillOne(ctx context.Context) error {
 	}
 
 	select {
-	case p.ready <- pvm:
+	case q.ready <- pvm:
 		return nil
 	default:
 		// Channel full — destroy this VM
@@ -176,8 +408,8 @@ func (p *Pool) fillOne(ctx context.Context) error {
 	}
 }
 
-// backfillLoop keeps the ready channel at target size.
-func (p *Pool) backfillLoop(ctx context.Context) {
+// backfillLoop keeps q's ready channel at its target size.
+func (p *Pool) backfillLoop(ctx context.Context, q *poolQueue) {
 	defer p.wg.Done()
 	for {
 		select {
@@ -187,16 +419,23 @@ func (p *Pool) backfillLoop(ctx context.Context) {
 		}
 
 		p.mu.Lock()
-		target := p.targetSize
+		target := q.targetSize
 		p.mu.Unlock()
 
-		current := len(p.ready)
+		current := len(q.ready)
 		if current < target {
-			if err := p.fillOne(ctx); err != nil {
-				p.log("Backfill error: %v", err)
-				// Back off briefly on error to avoid tight loops
+			if err := p.fillOne(ctx, q); err != nil {
+				p.log("Backfill error (%s): %v", q.version, err)
+				p.metrics.backfillFailed()
+				// Back off briefly on error to avoid tight loops, and for
+				// longer when the host is out of room, until VMs exit
+				backoff := 500 * time.Millisecond
+				var resErr *ResourceError
+				if errors.As(err, &resErr) {
+					backoff = resourceBackoff
+				}
 				select {
-				case <-time.After(500 * time.Millisecond):
+				case <-time.After(backoff):
 				case <-p.done:
 					return
 				}
@@ -238,6 +477,16 @@ func (p *Pool) handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 	conn.SetDeadline(time.Now().Add(5 * time.Minute))
 
+	peerUID := p.uid
+	if uc, ok := conn.(*net.UnixConn); ok {
+		var err error
+		if peerUID, err = p.checkPeer(uc); err != nil {
+			p.log("Refused a connection: %v", err)
+			p.sendResponse(conn, &PoolResponse{Type: "error", Error: err.Error()})
+			return
+		}
+	}
+
 	reader := bufio.NewReader(conn)
 	line, err := reader.ReadBytes('\n')
 	if err != nil {
@@ -252,11 +501,17 @@ func (p *Pool) handleConnection(ctx context.Context, conn net.Conn) {
 
 	switch req.Type {
 	case "exec":
-		p.handleExec(ctx, conn, &req)
+		p.handleExec(ctx, conn, &req, peerUID)
+	case "shell":
+		p.handleShell(conn, &req, peerUID)
 	case "status":
 		p.handleStatus(conn)
+	case "metrics":
+		var b strings.Builder
+		p.writeMetrics(&b)
+		p.sendResponse(conn, &PoolResponse{Type: "metrics", Metrics: b.String(), Version: p.versions[0]})
 	case "scale":
-		p.handleScale(conn, req.TargetSize)
+		p.handleScale(conn, req.Version, req.TargetSize)
 	case "stop":
 		p.sendResponse(conn, &PoolResponse{Type: "ok"})
 		go p.Shutdown()
@@ -265,37 +520,108 @@ func (p *Pool) handleConnection(ctx context.Context, conn net.Conn) {
 	}
 }
 
+// checkPeer refuses connections from users other than the daemon's own
+// and those it was told to allow, going by the SO_PEERCRED of conn. It
+// returns the peer's UID.
+func (p *Pool) checkPeer(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, fmt.Errorf("reading peer credentials: %w", credErr)
+	}
+	uid := int(cred.Uid)
+	if uid != p.uid && !p.allowUIDs[uid] {
+		return uid, fmt.Errorf("user %d may not use this pool daemon (it runs as user %d; see --allow-user)", uid, p.uid)
+	}
+	return uid, nil
+}
+
+// checkMounts validates the extra mounts of an exec request from user uid.
+// Read-write mounts would let the guest write as the daemon's user, so
+// they are only served to that user, and another user's read-only mounts
+// must be directories it can read itself.
+func (p *Pool) checkMounts(mounts []Mount, uid int) error {
+	if err := ValidateMounts(mounts); err != nil {
+		return err
+	}
+	if uid == p.uid {
+		return nil
+	}
+	peer := newPeerAccess(uid)
+	for _, m := range mounts {
+		if !m.ReadOnly {
+			return fmt.Errorf("read-write mount %q: the pool daemon serves these only to user %d", m.Alias, p.uid)
+		}
+		if !peer.allowed(m.HostPath) {
+			return fmt.Errorf("mount %q: user %d can't read %s", m.Alias, uid, m.HostPath)
+		}
+	}
+	return nil
+}
+
+// workDir returns the directory the file server of a request from user
+// uid serves, and the access it is served with: nil for the daemon's own
+// user. Another user only gets a directory it can read itself, and within
+// it the files it can read.
+func (p *Pool) workDir(cwd string, uid int) (string, *peerAccess, error) {
+	if uid == p.uid {
+		if cwd == "" {
+			cwd, _ = os.Getwd()
+		}
+		return cwd, nil, nil
+	}
+	peer := newPeerAccess(uid)
+	if !filepath.IsAbs(cwd) || !peer.allowed(cwd) {
+		return "", nil, fmt.Errorf("user %d can't read the working directory %q", uid, cwd)
+	}
+	return cwd, peer, nil
+}
+
 // handleExec dequeues a warm VM, starts a file server, executes code, and
-// destroys the VM. Triggers backfill to replace the consumed VM.
-func (p *Pool) handleExec(ctx context.Context, conn net.Conn, req *PoolRequest) {
+// destroys the VM. Triggers backfill to replace the consumed VM. uid is
+// the user who sent the request.
+func (p *Pool) handleExec(ctx context.Context, conn net.Conn, req *PoolRequest, uid int) {
+	start := time.Now()
 	p.mu.Lock()
-	p.lastReq = time.Now()
+	p.lastReq = start
 	p.mu.Unlock()
 
-	// Non-blocking dequeue — fail fast if no warm VM is available so the
-	// client can fall through to cold restore immediately.
-	var pvm *poolVM
-	select {
-	case pvm = <-p.ready:
-	default:
-		p.sendResponse(conn, &PoolResponse{Type: "error", Error: "no warm VMs available"})
+	if err := p.checkMounts(req.Mounts, uid); err != nil {
+		p.sendResponse(conn, &PoolResponse{Type: "error", Error: err.Error()})
+		return
+	}
+	cwd, peer, err := p.workDir(req.CWD, uid)
+	if err != nil {
+		p.sendResponse(conn, &PoolResponse{Type: "error", Error: err.Error()})
 		return
 	}
 
+	// Dequeue, waiting at most req.WaitMs — a VM being backfilled is often
+	// ready sooner than a cold restore would be — then fail so the client
+	// falls through to cold restore.
+	q, pvm := p.take(conn, req.Version, req.WaitMs, req.Generation)
+	if pvm == nil {
+		p.metrics.observeExec("unavailable", 0)
+		return
+	}
 	defer p.destroyPoolVM(pvm)
 
-	// Start file server at the SNAPSHOT vsock path (not per-instance path).
-	// Firecracker remembers the original vsock UDS path internally and uses
-	// it to construct guest-to-host listener paths ({vsockPath}_{port}).
-	// The renamed per-instance socket works for host-to-guest connections
-	// (ExecuteViaVsock), but guest-to-host (file server) must use the
-	// original snapshot path.
-	snapVsockPath := filepath.Join(p.paths.SnapshotDirForVersion(p.version), "vsock.sock")
-	cwd := req.CWD
-	if cwd == "" {
-		cwd, _ = os.Getwd()
-	}
-	fileServer, err := StartFileServer(snapVsockPath, cwd)
+	// Start file server at the path the VM's vsock socket was bound at, not
+	// the renamed per-instance path. Firecracker remembers the original
+	// vsock UDS path internally and uses it to construct guest-to-host
+	// listener paths ({vsockPath}_{port}). The renamed per-instance socket
+	// works for host-to-guest connections (ExecuteViaVsock), but
+	// guest-to-host (file server) must use the original path.
+	fileServer, err := startFileServerAs(peer, pvm.info.GuestVsockPath, cwd, nil, req.Mounts...)
 	if err != nil {
 		p.log("Warning: file server for %s: %v", pvm.instanceID, err)
 	}
@@ -305,72 +631,223 @@ func (p *Pool) handleExec(ctx context.Context, conn net.Conn, req *PoolRequest)
 
 	// Execute code via vsock — use the per-instance (renamed) path for
 	// host-to-guest communication.
-	vsockReq := &VsockRequest{
-		Code:          req.Code,
-		ShowTables:    req.ShowTables,
-		ShowTableMeta: req.ShowTableMeta,
-	}
-
-	resp, err := ExecuteViaVsock(pvm.vsockPath, VsockPort, vsockReq)
+	resp, err := ExecuteViaVsock(pvm.vsockPath, VsockPort, req.vsockRequest())
 	if err != nil {
+		p.metrics.observeExec("error", time.Since(start))
 		p.sendResponse(conn, &PoolResponse{
 			Type:    "error",
 			Error:   fmt.Sprintf("vsock exec: %v", err),
-			Version: p.version,
+			Version: q.version,
 		})
 		return
 	}
 
+	p.metrics.observeExec("ok", time.Since(start))
 	p.sendResponse(conn, &PoolResponse{
-		Type:    "exec_result",
-		Exec:    resp,
-		Version: p.version,
+		Type:       "exec_result",
+		Exec:       resp,
+		Version:    q.version,
+		Generation: pvm.info.Generation,
 	})
 }
 
+// take dequeues a warm VM of version (the default version if empty),
+// waiting up to waitMs for one when none is ready, and answers the client
+// with an error when the pool has none.
+//
+// A client that gives the generation of the snapshot it sees on disk only
+// gets a VM restored from that snapshot. When the VM is from another, the
+// snapshot has been replaced since the pool restored it: the pool drops
+// its VMs of the old snapshot, which backfill replaces from the new one,
+// and the client is told to restore its own meanwhile.
+func (p *Pool) take(conn net.Conn, version string, waitMs int, generation string) (*poolQueue, *poolVM) {
+	q := p.queue(version)
+	if q == nil {
+		p.sendResponse(conn, &PoolResponse{Type: "error", Error: fmt.Sprintf("pool does not serve version %s", version)})
+		return nil, nil
+	}
+	var pvm *poolVM
+	select {
+	case pvm = <-q.ready:
+	default:
+	}
+	if pvm == nil && waitMs > 0 {
+		timer := time.NewTimer(min(time.Duration(waitMs)*time.Millisecond, maxPoolWait))
+		defer timer.Stop()
+		select {
+		case pvm = <-q.ready:
+		case <-timer.C:
+		case <-p.done:
+		}
+	}
+	if pvm == nil {
+		p.sendResponse(conn, &PoolResponse{Type: "error", Error: "no warm VMs available", Version: q.version})
+		return q, nil
+	}
+	if generation != "" && pvm.info.Generation != generation {
+		p.log("The snapshot of %s was replaced (generation %s, VMs have %s); dropping its old VMs", q.version, generation, pvm.info.Generation)
+		p.destroyPoolVM(pvm)
+		p.dropStale(q, generation)
+		p.sendResponse(conn, &PoolResponse{Type: "error", Error: "warm VMs are from a replaced snapshot; the pool is restoring new ones", Version: q.version})
+		return q, nil
+	}
+	return q, pvm
+}
+
+// dropStale destroys the VMs in q's ready channel that were not restored
+// from the snapshot of generation, and keeps the others.
+func (p *Pool) dropStale(q *poolQueue, generation string) {
+	for range len(q.ready) {
+		select {
+		case pvm := <-q.ready:
+			if pvm.info.Generation != generation {
+				p.destroyPoolVM(pvm)
+				continue
+			}
+			select {
+			case q.ready <- pvm:
+			default:
+				p.destroyPoolVM(pvm)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// handleShell dequeues a warm VM, starts a shell in it, and relays bytes
+// between the client and the shell until either side hangs up. The VM is
+// destroyed afterwards, like an exec VM. uid is the user who sent the
+// request.
+func (p *Pool) handleShell(conn net.Conn, req *PoolRequest, uid int) {
+	cwd, peer, err := p.workDir(req.CWD, uid)
+	if err != nil {
+		p.sendResponse(conn, &PoolResponse{Type: "error", Error: err.Error()})
+		return
+	}
+
+	p.mu.Lock()
+	p.lastReq = time.Now()
+	p.shells++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.lastReq = time.Now()
+		p.shells--
+		p.mu.Unlock()
+	}()
+
+	q, pvm := p.take(conn, req.Version, req.WaitMs, req.Generation)
+	if pvm == nil {
+		return
+	}
+	defer p.destroyPoolVM(pvm)
+
+	// See handleExec for why the file server uses GuestVsockPath.
+	fileServer, err := startFileServerAs(peer, pvm.info.GuestVsockPath, cwd, nil)
+	if err != nil {
+		p.log("Warning: file server for %s: %v", pvm.instanceID, err)
+	}
+	if fileServer != nil {
+		defer fileServer.Close()
+	}
+
+	shell, err := OpenShell(pvm.vsockPath, VsockPort, req.Rows, req.Cols, req.Term)
+	if err != nil {
+		p.sendResponse(conn, &PoolResponse{
+			Type:    "error",
+			Error:   fmt.Sprintf("vsock shell: %v", err),
+			Version: q.version,
+		})
+		return
+	}
+	defer shell.Close()
+
+	p.sendResponse(conn, &PoolResponse{Type: "shell", Version: q.version, Generation: pvm.info.Generation})
+	conn.SetDeadline(time.Time{})
+	go func() {
+		io.Copy(shell, conn)
+		shell.Close()
+	}()
+	io.Copy(conn, shell)
+}
+
 // handleStatus returns the current pool state.
 func (p *Pool) handleStatus(conn net.Conn) {
+	p.sendResponse(conn, &PoolResponse{Type: "status", Status: p.status(), Version: p.versions[0]})
+}
+
+// writeMetrics writes the pool's metrics in the Prometheus text format.
+func (p *Pool) writeMetrics(w io.Writer) {
 	p.mu.Lock()
-	idleSecs := int(time.Since(p.lastReq).Seconds())
-	target := p.targetSize
+	shells := p.shells
 	p.mu.Unlock()
+	p.metrics.write(w, p.status(), shells, uffdFaults.Load())
+}
 
+// status returns the current pool state. Ready and TargetSize are totals
+// over all versions.
+func (p *Pool) status() *PoolStatus {
 	status := &PoolStatus{
 		Running:     true,
 		PID:         os.Getpid(),
-		Version:     p.version,
-		Ready:       len(p.ready),
-		TargetSize:  target,
-		IdleSeconds: idleSecs,
+		Version:     p.versions[0],
 		IdleTimeout: int(p.idleTimeout.Seconds()),
 	}
-	p.sendResponse(conn, &PoolResponse{Type: "status", Status: status, Version: p.version})
+	p.mu.Lock()
+	status.IdleSeconds = int(time.Since(p.lastReq).Seconds())
+	for _, v := range p.versions {
+		q := p.queues[v]
+		vs := PoolVersionStatus{Version: v, Ready: len(q.ready), TargetSize: q.targetSize}
+		status.Versions = append(status.Versions, vs)
+		status.Ready += vs.Ready
+		status.TargetSize += vs.TargetSize
+	}
+	p.mu.Unlock()
+	return status
 }
 
-// handleScale adjusts the target pool size.
-func (p *Pool) handleScale(conn net.Conn, newSize int) {
+// handleScale adjusts the target size of version's queue, or of every
+// queue when version is empty.
+func (p *Pool) handleScale(conn net.Conn, version string, newSize int) {
 	if newSize < 0 {
 		p.sendResponse(conn, &PoolResponse{Type: "error", Error: "target size must be >= 0"})
 		return
 	}
+	queues := make([]*poolQueue, 0, len(p.versions))
+	if version != "" {
+		q := p.queues[version]
+		if q == nil {
+			p.sendResponse(conn, &PoolResponse{Type: "error", Error: fmt.Sprintf("pool does not serve version %s", version)})
+			return
+		}
+		queues = append(queues, q)
+	} else {
+		for _, v := range p.versions {
+			queues = append(queues, p.queues[v])
+		}
+	}
 
-	p.mu.Lock()
-	oldSize := p.targetSize
-	p.targetSize = newSize
-	p.mu.Unlock()
+	for _, q := range queues {
+		p.mu.Lock()
+		oldSize := q.targetSize
+		q.targetSize = newSize
+		p.mu.Unlock()
 
-	// If shrinking, drain excess VMs
-	for len(p.ready) > newSize {
-		select {
-		case pvm := <-p.ready:
-			p.destroyPoolVM(pvm)
-		default:
-			break
+		// If shrinking, drain excess VMs
+	drain:
+		for len(q.ready) > newSize {
+			select {
+			case pvm := <-q.ready:
+				p.destroyPoolVM(pvm)
+			default:
+				break drain
+			}
 		}
-	}
 
-	p.log("Pool scaled: %d → %d", oldSize, newSize)
-	p.sendResponse(conn, &PoolResponse{Type: "ok", Version: p.version})
+		p.log("Pool scaled (%s): %d → %d", q.version, oldSize, newSize)
+	}
+	p.sendResponse(conn, &PoolResponse{Type: "ok", Version: p.versions[0]})
 }
 
 // idleWatcher shuts down the pool after idleTimeout of inactivity.
@@ -387,9 +864,10 @@ func (p *Pool) idleWatcher() {
 			p.mu.Lock()
 			idle := time.Since(p.lastReq)
 			timeout := p.idleTimeout
+			shells := p.shells
 			p.mu.Unlock()
 
-			if timeout > 0 && idle > timeout {
+			if timeout > 0 && idle > timeout && shells == 0 {
 				p.log("Idle timeout reached (%.0fs > %s), shutting down", idle.Seconds(), timeout)
 				go p.Shutdown()
 				return
@@ -416,6 +894,9 @@ func (p *Pool) Shutdown() {
 	if p.listener != nil {
 		p.listener.Close()
 	}
+	if p.httpSrv != nil {
+		p.httpSrv.Close()
+	}
 
 	// Remove socket file
 	os.Remove(PoolSocketPath())
@@ -424,14 +905,17 @@ func (p *Pool) Shutdown() {
 	p.drainAll()
 }
 
-// drainAll destroys all VMs in the ready channel.
+// drainAll destroys all VMs in the ready channels.
 func (p *Pool) drainAll() {
-	for {
-		select {
-		case pvm := <-p.ready:
-			p.destroyPoolVM(pvm)
-		default:
-			return
+	for _, q := range p.queues {
+	drain:
+		for {
+			select {
+			case pvm := <-q.ready:
+				p.destroyPoolVM(pvm)
+			default:
+				break drain
+			}
 		}
 	}
 }
@@ -0,0 +1,348 @@
+//go:build linux
+
+package vm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoolQueue(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0", "0.36.0", "0.37.0"}, TargetSize: 2})
+	if len(p.versions) != 2 || p.versions[0] != "0.37.0" {
+		t.Fatalf("versions = %v", p.versions)
+	}
+	if q := p.queue(""); q == nil || q.version != "0.37.0" {
+		t.Errorf("default queue = %+v", q)
+	}
+	if q := p.queue("0.36.0"); q == nil || q.version != "0.36.0" || q.targetSize != 2 {
+		t.Errorf("0.36.0 queue = %+v", q)
+	}
+	if q := p.queue("0.35.0"); q != nil {
+		t.Errorf("unserved version has queue %+v", q)
+	}
+}
+
+// poolRoundTrip runs handle on one end of a pipe and returns the response
+// it writes.
+func poolRoundTrip(t *testing.T, handle func(net.Conn)) *PoolResponse {
+	t.Helper()
+	server, client := net.Pipe()
+	go func() {
+		handle(server)
+		server.Close()
+	}()
+	defer client.Close()
+	line, err := bufio.NewReader(client).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp PoolResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
+
+func TestPoolStatusAndScale(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0", "0.36.0"}, TargetSize: 1})
+
+	resp := poolRoundTrip(t, func(c net.Conn) { p.handleScale(c, "0.36.0", 3) })
+	if resp.Type != "ok" {
+		t.Fatalf("scale: %+v", resp)
+	}
+	resp = poolRoundTrip(t, func(c net.Conn) { p.handleScale(c, "0.35.0", 3) })
+	if resp.Type != "error" {
+		t.Errorf("scaling an unserved version: %+v", resp)
+	}
+
+	resp = poolRoundTrip(t, p.handleStatus)
+	s := resp.Status
+	if s == nil || s.Version != "0.37.0" || s.TargetSize != 4 || len(s.Versions) != 2 {
+		t.Fatalf("status = %+v", s)
+	}
+	if v := s.Versions[1]; v.Version != "0.36.0" || v.TargetSize != 3 || v.Ready != 0 {
+		t.Errorf("0.36.0 status = %+v", v)
+	}
+
+	resp = poolRoundTrip(t, func(c net.Conn) { p.handleScale(c, "", 0) })
+	if resp.Type != "ok" || p.queue("0.37.0").targetSize != 0 || p.queue("0.36.0").targetSize != 0 {
+		t.Errorf("scaling all queues: %+v", resp)
+	}
+}
+
+func TestPoolTake_UnservedVersion(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0"}, TargetSize: 1})
+	resp := poolRoundTrip(t, func(c net.Conn) {
+		if _, pvm := p.take(c, "0.36.0", 0, ""); pvm != nil {
+			t.Error("took a VM of an unserved version")
+		}
+	})
+	if resp.Type != "error" {
+		t.Errorf("response = %+v", resp)
+	}
+	resp = poolRoundTrip(t, func(c net.Conn) { p.take(c, "", 0, "") })
+	if resp.Type != "error" || resp.Version != "0.37.0" {
+		t.Errorf("empty default queue: %+v", resp)
+	}
+}
+
+func TestPoolTake_Wait(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0"}, TargetSize: 1})
+	want := &poolVM{instanceID: "pool-1"}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.queue("").ready <- want
+	}()
+
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if _, pvm := p.take(server, "", 5000, ""); pvm != want {
+		t.Errorf("took %+v, want the VM backfilled while waiting", pvm)
+	}
+
+	// Nothing arrives: the wait ends with an error.
+	start := time.Now()
+	resp := poolRoundTrip(t, func(c net.Conn) { p.take(c, "", 50, "") })
+	if resp.Type != "error" || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("response = %+v after %s", resp, time.Since(start))
+	}
+}
+
+func TestPoolTake_StaleGeneration(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0"}, TargetSize: 3})
+	q := p.queue("")
+	for i, gen := range []string{"old", "old", "new"} {
+		q.ready <- &poolVM{instanceID: fmt.Sprintf("pool-%d", i), info: &InstanceInfo{ID: fmt.Sprintf("pool-%d", i), Generation: gen}}
+	}
+
+	// The snapshot was replaced: the VMs of the old one are dropped.
+	resp := poolRoundTrip(t, func(c net.Conn) {
+		if _, pvm := p.take(c, "", 0, "new"); pvm != nil {
+			t.Error("took a VM of a replaced snapshot")
+		}
+	})
+	if resp.Type != "error" || !strings.Contains(resp.Error, "replaced snapshot") {
+		t.Errorf("response = %+v", resp)
+	}
+	if len(q.ready) != 1 {
+		t.Fatalf("%d VMs left, want the one of the new snapshot", len(q.ready))
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if _, pvm := p.take(server, "", 0, "new"); pvm == nil || pvm.info.Generation != "new" {
+		t.Errorf("took %+v, want the VM of the new snapshot", pvm)
+	}
+}
+
+func TestPoolMetrics(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0", "0.36.0"}, TargetSize: 1})
+	p.metrics.observeExec("ok", 30*time.Millisecond)
+	p.metrics.observeExec("ok", 2*time.Second)
+	p.metrics.observeExec("unavailable", 0)
+	p.metrics.backfillFailed()
+
+	resp := poolRoundTrip(t, func(c net.Conn) {
+		p.handleConnection(context.Background(), &requestConn{Conn: c, req: `{"type":"metrics"}` + "\n"})
+	})
+	if resp.Type != "metrics" {
+		t.Fatalf("response = %+v", resp)
+	}
+	for _, want := range []string{
+		"# TYPE dh_pool_ready_vms gauge\n",
+		`dh_pool_ready_vms{version="0.36.0"} 0` + "\n",
+		`dh_pool_target_vms{version="0.37.0"} 1` + "\n",
+		`dh_pool_execs_total{result="ok"} 2` + "\n",
+		`dh_pool_execs_total{result="unavailable"} 1` + "\n",
+		`dh_pool_exec_duration_seconds_bucket{le="0.025"} 0` + "\n",
+		`dh_pool_exec_duration_seconds_bucket{le="0.05"} 1` + "\n",
+		`dh_pool_exec_duration_seconds_bucket{le="2.5"} 2` + "\n",
+		`dh_pool_exec_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"dh_pool_exec_duration_seconds_count 2\n",
+		"dh_pool_backfill_failures_total 1\n",
+		"# TYPE dh_pool_uffd_faults_total counter\n",
+	} {
+		if !strings.Contains(resp.Metrics, want) {
+			t.Errorf("metrics lack %q:\n%s", want, resp.Metrics)
+		}
+	}
+}
+
+func TestPoolCheckPeer(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "pool.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	uc := server.(*net.UnixConn)
+
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0"}, TargetSize: 1})
+	if uid, err := p.checkPeer(uc); err != nil || uid != os.Geteuid() {
+		t.Errorf("own user: uid %d, %v", uid, err)
+	}
+
+	// The daemon runs as someone else: refused unless allowed.
+	p.uid = os.Geteuid() + 1
+	if _, err := p.checkPeer(uc); err == nil {
+		t.Error("another user was let in")
+	}
+	p.allowUIDs[os.Geteuid()] = true
+	if _, err := p.checkPeer(uc); err != nil {
+		t.Errorf("allowed user refused: %v", err)
+	}
+}
+
+func TestPoolCheckMounts(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0"}, TargetSize: 1})
+	dir := sharedTempDir(t)
+	ro := []Mount{{HostPath: dir, Alias: "ref", ReadOnly: true}}
+	rw := []Mount{{HostPath: dir, Alias: "out"}}
+
+	if err := p.checkMounts(ro, p.uid+1); err != nil {
+		t.Errorf("read-only mount for another user: %v", err)
+	}
+	if err := p.checkMounts(rw, p.uid); err != nil {
+		t.Errorf("read-write mount for the daemon's user: %v", err)
+	}
+	if err := p.checkMounts(rw, p.uid+1); err == nil {
+		t.Error("read-write mount served to another user")
+	}
+	if err := p.checkMounts([]Mount{{HostPath: "ref", Alias: "ref", ReadOnly: true}}, p.uid); err == nil {
+		t.Error("relative host path accepted")
+	}
+	private := []Mount{{HostPath: privateTempDir(t), Alias: "home", ReadOnly: true}}
+	if err := p.checkMounts(private, p.uid+1); err == nil || !strings.Contains(err.Error(), "can't read") {
+		t.Errorf("another user's mount of a directory it can't read: %v", err)
+	}
+	if err := p.checkMounts(private, p.uid); err != nil {
+		t.Errorf("the daemon's user's own directory: %v", err)
+	}
+
+	// Rejected before a VM is taken, so an empty pool still says why.
+	resp := poolRoundTrip(t, func(c net.Conn) {
+		p.handleExec(context.Background(), c, &PoolRequest{Type: "exec", Mounts: rw}, p.uid+1)
+	})
+	if resp.Type != "error" || !strings.Contains(resp.Error, "read-write mount") {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestPoolWorkDir(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0"}, TargetSize: 1})
+	shared, private := sharedTempDir(t), privateTempDir(t)
+
+	if cwd, peer, err := p.workDir(private, p.uid); err != nil || cwd != private || peer != nil {
+		t.Errorf("daemon's user: %q, %v, %v", cwd, peer, err)
+	}
+	if _, _, err := p.workDir(private, p.uid+1); err == nil {
+		t.Error("another user was served a directory it can't read")
+	}
+	if _, _, err := p.workDir("", p.uid+1); err == nil {
+		t.Error("another user was served the daemon's working directory")
+	}
+	if cwd, peer, err := p.workDir(shared, p.uid+1); err != nil || cwd != shared || peer == nil || peer.uid != p.uid+1 {
+		t.Errorf("another user's readable directory: %q, %v, %v", cwd, peer, err)
+	}
+
+	// Refused before a VM is taken, for exec and shell alike.
+	for _, req := range []*PoolRequest{{Type: "exec", CWD: private}, {Type: "shell", CWD: private}} {
+		resp := poolRoundTrip(t, func(c net.Conn) {
+			if req.Type == "exec" {
+				p.handleExec(context.Background(), c, req, p.uid+1)
+			} else {
+				p.handleShell(c, req, p.uid+1)
+			}
+		})
+		if resp.Type != "error" || !strings.Contains(resp.Error, "can't read the working directory") {
+			t.Errorf("%s response = %+v", req.Type, resp)
+		}
+	}
+}
+
+// sharedTempDir returns a temporary directory every user can read.
+func sharedTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chmod(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// privateTempDir returns a temporary directory only its owner can read.
+func privateTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestPoolSocketPath(t *testing.T) {
+	if got, want := PoolSocketPath(), fmt.Sprintf("/tmp/dh-pool-%d.sock", os.Getuid()); got != want {
+		t.Errorf("PoolSocketPath() = %q, want %q", got, want)
+	}
+	t.Setenv("DH_POOL_SOCKET", "/run/dh/shared.sock")
+	if got := PoolSocketPath(); got != "/run/dh/shared.sock" {
+		t.Errorf("PoolSocketPath() with DH_POOL_SOCKET = %q", got)
+	}
+}
+
+// requestConn is a net.Conn whose reads return req.
+type requestConn struct {
+	net.Conn
+	req string
+}
+
+func (c *requestConn) Read(b []byte) (int, error) {
+	if c.req == "" {
+		return 0, io.EOF
+	}
+	n := copy(b, c.req)
+	c.req = c.req[n:]
+	return n, nil
+}
+
+func TestPoolPrefill_Failures(t *testing.T) {
+	p := NewPool(PoolConfig{DHHome: t.TempDir(), Versions: []string{"0.37.0", "0.36.0"}, TargetSize: 3})
+	p.stderr = io.Discard
+	// There are no snapshots, so every restore fails: the pool must still
+	// report the pre-fill over rather than wait for a first VM forever.
+	firstReady, done := p.prefill(context.Background())
+	for _, ch := range []<-chan struct{}{firstReady, done} {
+		select {
+		case <-ch:
+		case <-time.After(10 * time.Second):
+			t.Fatal("pre-fill did not finish")
+		}
+	}
+	p.wg.Wait()
+	if n := len(p.queue("").ready) + len(p.queue("0.36.0").ready); n != 0 {
+		t.Errorf("%d VMs ready without snapshots", n)
+	}
+}
@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// poolExecBuckets are the upper bounds, in seconds, of the exec latency
+// histogram: from a trivial script on a warm VM to a long report.
+var poolExecBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// poolMetrics counts what the pool daemon has done since it started.
+type poolMetrics struct {
+	mu               sync.Mutex
+	execs            map[string]uint64 // by result: "ok", "error", "unavailable"
+	execBuckets      []uint64          // per poolExecBuckets, not cumulative
+	execSum          float64
+	execCount        uint64
+	backfillFailures uint64
+}
+
+func newPoolMetrics() *poolMetrics {
+	return &poolMetrics{
+		execs:       map[string]uint64{},
+		execBuckets: make([]uint64, len(poolExecBuckets)),
+	}
+}
+
+// observeExec records an exec request and, unless no VM was available for
+// it, how long it took.
+func (m *poolMetrics) observeExec(result string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.execs[result]++
+	if result == "unavailable" {
+		return
+	}
+	secs := d.Seconds()
+	m.execSum += secs
+	m.execCount++
+	for i, le := range poolExecBuckets {
+		if secs <= le {
+			m.execBuckets[i]++
+			break
+		}
+	}
+}
+
+func (m *poolMetrics) backfillFailed() {
+	m.mu.Lock()
+	m.backfillFailures++
+	m.mu.Unlock()
+}
+
+// write renders the metrics and the pool state in st in the Prometheus
+// text exposition format.
+func (m *poolMetrics) write(w io.Writer, st *PoolStatus, shells int, uffdFaults uint64) {
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("dh_pool_ready_vms", "gauge", "Warm VMs waiting in the pool.")
+	for _, v := range st.Versions {
+		fmt.Fprintf(w, "dh_pool_ready_vms{version=%q} %d\n", v.Version, v.Ready)
+	}
+	metric("dh_pool_target_vms", "gauge", "Warm VMs the pool keeps.")
+	for _, v := range st.Versions {
+		fmt.Fprintf(w, "dh_pool_target_vms{version=%q} %d\n", v.Version, v.TargetSize)
+	}
+	metric("dh_pool_shells", "gauge", "Interactive shells in progress.")
+	fmt.Fprintf(w, "dh_pool_shells %d\n", shells)
+	metric("dh_pool_idle_seconds", "gauge", "Seconds since the last request.")
+	fmt.Fprintf(w, "dh_pool_idle_seconds %d\n", st.IdleSeconds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metric("dh_pool_execs_total", "counter", "Exec requests by result.")
+	results := make([]string, 0, len(m.execs))
+	for r := range m.execs {
+		results = append(results, r)
+	}
+	sort.Strings(results)
+	for _, r := range results {
+		fmt.Fprintf(w, "dh_pool_execs_total{result=%q} %d\n", r, m.execs[r])
+	}
+	metric("dh_pool_exec_duration_seconds", "histogram", "Time to serve an exec request on a warm VM.")
+	var cum uint64
+	for i, le := range poolExecBuckets {
+		cum += m.execBuckets[i]
+		fmt.Fprintf(w, "dh_pool_exec_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(le, 'g', -1, 64), cum)
+	}
+	fmt.Fprintf(w, "dh_pool_exec_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.execCount)
+	fmt.Fprintf(w, "dh_pool_exec_duration_seconds_sum %g\n", m.execSum)
+	fmt.Fprintf(w, "dh_pool_exec_duration_seconds_count %d\n", m.execCount)
+	metric("dh_pool_backfill_failures_total", "counter", "Failed attempts to restore a warm VM.")
+	fmt.Fprintf(w, "dh_pool_backfill_failures_total %d\n", m.backfillFailures)
+	metric("dh_pool_uffd_faults_total", "counter", "Guest page faults served by the UFFD handlers of pool VMs.")
+	fmt.Fprintf(w, "dh_pool_uffd_faults_total %d\n", uffdFaults)
+}
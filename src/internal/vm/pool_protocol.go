@@ -1,31 +1,105 @@
 package vm
 
-// PoolRequest is sent from the client (dh exec --vm / dh vm pool) to the pool daemon.
+// PoolWaitMs is how long clients let the pool daemon wait for a warm VM
+// when none is ready, typically one being backfilled, before they fall
+// back to a cold restore (~700ms).
+const PoolWaitMs = 300
+
+// PoolRequest is sent from the client (dh exec --vm / dh vm pool) to the pool daemon,
+// or to the daemon of a named VM.
 type PoolRequest struct {
-	Type          string `json:"type"`                      // "exec", "scale", "status", "stop"
-	Code          string `json:"code,omitempty"`            // for exec
-	CWD           string `json:"cwd,omitempty"`             // for exec
-	ShowTables    bool   `json:"show_tables,omitempty"`     // for exec
-	ShowTableMeta bool   `json:"show_table_meta,omitempty"` // for exec
-	TargetSize    int    `json:"target_size,omitempty"`     // for scale
+	Type          string        `json:"type"`                      // "exec", "shell", "scale", "status", "metrics", "stop"
+	Code          string        `json:"code,omitempty"`            // for exec
+	CWD           string        `json:"cwd,omitempty"`             // for exec and shell
+	ShowTables    bool          `json:"show_tables,omitempty"`     // for exec
+	ShowTableMeta bool          `json:"show_table_meta,omitempty"` // for exec
+	PythonPath    []string      `json:"python_path,omitempty"`     // for exec (guest paths)
+	HashTables    bool          `json:"hash_tables,omitempty"`     // for exec
+	MountMode     string        `json:"mount_mode,omitempty"`      // for exec: "fuse", or empty for LD_PRELOAD
+	Mounts        []Mount       `json:"mounts,omitempty"`          // for exec: extra roots, with absolute host paths
+	AllowWrite    []string      `json:"allow_write,omitempty"`     // for exec in a named VM: absolute host paths the script may write
+	TableFormat   string        `json:"table_format,omitempty"`    // for exec: "arrow" to get tables back as Arrow IPC streams
+	ImportTables  []TableImport `json:"import_tables,omitempty"`   // for exec (guest paths)
+	ExportTables  []TableExport `json:"export_tables,omitempty"`   // for exec (without paths)
+	TableOutput   string        `json:"table_output,omitempty"`    // for exec: preview format; default: pretty
+	MaxRows       *int          `json:"max_rows,omitempty"`        // for exec: preview rows, 0 for all; default: 10
+	MaxCols       int           `json:"max_cols,omitempty"`        // for exec: preview columns; default: all
+	TargetSize    int           `json:"target_size,omitempty"`     // for scale
+	Rows          int           `json:"rows,omitempty"`            // for shell
+	Cols          int           `json:"cols,omitempty"`            // for shell
+	Term          string        `json:"term,omitempty"`            // for shell (TERM in the guest)
+	Version       string        `json:"version,omitempty"`         // for exec, shell and scale; default: the pool's first version
+	WaitMs        int           `json:"wait_ms,omitempty"`         // for exec and shell: wait up to this long for a warm VM
+	Generation    string        `json:"generation,omitempty"`      // for exec and shell: the snapshot generation the client sees on disk
 }
 
 // PoolResponse is sent from the pool daemon to the client.
 type PoolResponse struct {
-	Type    string          `json:"type"`              // "exec_result", "status", "error", "ok"
-	Exec    *VsockResponse  `json:"exec,omitempty"`    // for exec_result
-	Status  *PoolStatus     `json:"status,omitempty"`  // for status
-	Error   string          `json:"error,omitempty"`   // for error
-	Version string          `json:"version,omitempty"` // pool's version
+	Type    string         `json:"type"`              // "exec_result", "shell", "status", "metrics", "error", "ok"
+	Exec    *VsockResponse `json:"exec,omitempty"`    // for exec_result
+	Status  *PoolStatus    `json:"status,omitempty"`  // for status
+	Named   *NamedVMStatus `json:"named,omitempty"`   // for status, from a named VM's daemon
+	Error   string         `json:"error,omitempty"`   // for error
+	Metrics string         `json:"metrics,omitempty"` // for metrics: Prometheus text format
+	Version string         `json:"version,omitempty"` // pool's version
+
+	// Generation is, for exec_result and shell, the generation of the
+	// snapshot the VM was restored from. Pool daemons that predate it
+	// leave it empty.
+	Generation string `json:"generation,omitempty"`
+}
+
+// vsockRequest returns the runner request for an exec request.
+func (r *PoolRequest) vsockRequest() *VsockRequest {
+	return &VsockRequest{
+		Code:          r.Code,
+		ShowTables:    r.ShowTables,
+		ShowTableMeta: r.ShowTableMeta,
+		PythonPath:    r.PythonPath,
+		HashTables:    r.HashTables,
+		MountMode:     r.MountMode,
+		TableFormat:   r.TableFormat,
+		ImportTables:  r.ImportTables,
+		ExportTables:  r.ExportTables,
+		TableOutput:   r.TableOutput,
+		MaxRows:       r.MaxRows,
+		MaxCols:       r.MaxCols,
+	}
+}
+
+// TableImport is a file loaded as a table before the code runs (dh exec
+// --import-table).
+type TableImport struct {
+	Name   string `json:"name"`   // the variable the table is bound to
+	Path   string `json:"path"`   // absolute; a guest path in VM requests
+	Format string `json:"format"` // "csv" or "parquet"
+}
+
+// TableExport is a table the code leaves in scope, saved to a file after
+// it runs (dh exec --export-table). VM requests carry no path: the runner
+// sends the file back, and dh saves it on the host.
+type TableExport struct {
+	Name   string `json:"name"`           // the variable the table is bound to
+	Path   string `json:"path,omitempty"` // absolute host path
+	Format string `json:"format"`         // "parquet", "csv" or "arrow"
 }
 
-// PoolStatus describes the current state of the pool daemon.
+// PoolStatus describes the current state of the pool daemon. Version is
+// the default version; Ready and TargetSize are totals over Versions.
 type PoolStatus struct {
-	Running     bool   `json:"running"`
-	PID         int    `json:"pid"`
-	Version     string `json:"version"`
-	Ready       int    `json:"ready"`
-	TargetSize  int    `json:"target_size"`
-	IdleSeconds int    `json:"idle_seconds"`
-	IdleTimeout int    `json:"idle_timeout_seconds"`
+	Running     bool                `json:"running"`
+	PID         int                 `json:"pid"`
+	Version     string              `json:"version"`
+	Ready       int                 `json:"ready"`
+	TargetSize  int                 `json:"target_size"`
+	IdleSeconds int                 `json:"idle_seconds"`
+	IdleTimeout int                 `json:"idle_timeout_seconds"`
+	Versions    []PoolVersionStatus `json:"versions,omitempty"`
+}
+
+// PoolVersionStatus is the warm queue of one version in the pool daemon.
+type PoolVersionStatus struct {
+	Version    string `json:"version"`
+	Ready      int    `json:"ready"`
+	TargetSize int    `json:"target_size"`
 }
@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PoolServiceName is the systemd user unit that runs the pool daemon.
+const PoolServiceName = "dh-pool.service"
+
+// PoolServiceConfig is what the pool daemon unit runs: the same settings
+// as 'dh vm pool start'.
+type PoolServiceConfig struct {
+	Executable  string // path to dh
+	DHHome      string
+	SocketPath  string // where the daemon listens; removed when it stops
+	Versions    []string
+	TargetSize  int
+	IdleTimeout time.Duration // 0 keeps the daemon up until stopped
+	MetricsAddr string
+	AllowUsers  []string // names or UIDs for --allow-user
+}
+
+// PoolServicePath returns where the pool daemon unit is installed:
+// $XDG_CONFIG_HOME/systemd/user, or ~/.config/systemd/user.
+func PoolServicePath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user", PoolServiceName), nil
+}
+
+// PoolServiceUnit renders the systemd user unit for cfg. The daemon is
+// restarted if it fails but not when it stops on purpose, through
+// 'dh vm pool stop' or the idle timeout.
+func PoolServiceUnit(cfg PoolServiceConfig) string {
+	args := []string{cfg.Executable, "vm", "pool", "start",
+		"-n", fmt.Sprintf("%d", cfg.TargetSize),
+		"--idle-timeout", cfg.IdleTimeout.String(),
+	}
+	for _, v := range cfg.Versions {
+		args = append(args, "--version", v)
+	}
+	if cfg.MetricsAddr != "" {
+		args = append(args, "--metrics-addr", cfg.MetricsAddr)
+	}
+	for _, u := range cfg.AllowUsers {
+		args = append(args, "--allow-user", u)
+	}
+	for i, a := range args {
+		args[i] = systemdQuote(a)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Written by 'dh vm pool install-service'; rerun it to change the settings.\n")
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=dh VM pool daemon (%s)\n", strings.Join(cfg.Versions, ", "))
+	fmt.Fprintf(&b, "Documentation=https://github.com/dsmmcken/dh-cli\n")
+	fmt.Fprintf(&b, "\n[Service]\n")
+	fmt.Fprintf(&b, "Type=simple\n")
+	fmt.Fprintf(&b, "Environment=%s\n", systemdQuote("DH_HOME="+cfg.DHHome))
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(args, " "))
+	fmt.Fprintf(&b, "ExecStopPost=/bin/rm -f %s\n", systemdQuote(cfg.SocketPath))
+	fmt.Fprintf(&b, "Restart=on-failure\n")
+	fmt.Fprintf(&b, "RestartSec=5\n")
+	fmt.Fprintf(&b, "\n[Install]\n")
+	fmt.Fprintf(&b, "WantedBy=default.target\n")
+	return b.String()
+}
+
+// systemdQuote escapes s for a systemd command line or assignment:
+// specifiers and variables are doubled, and words with spaces or quotes
+// are double-quoted.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	s = strings.ReplaceAll(s, "$", "$$")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
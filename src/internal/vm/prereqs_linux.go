@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"runtime"
 	"strings"
 )
 
@@ -40,12 +41,24 @@ func KVMAccessible() bool {
 func CheckPrerequisites(paths *VMPaths) []*PrereqError {
 	var errs []*PrereqError
 
+	// Firecracker runs on x86_64 and aarch64 only
+	if _, err := firecrackerArch(runtime.GOARCH); err != nil {
+		errs = append(errs, &PrereqError{
+			Check:   "architecture",
+			Message: err.Error(),
+		})
+	}
+
 	// /dev/kvm exists and is accessible
 	if _, err := os.Stat("/dev/kvm"); err != nil {
+		hint := "Enable KVM: sudo modprobe kvm_intel (or kvm_amd)"
+		if runtime.GOARCH == "arm64" {
+			hint = "Use a host with KVM: a bare-metal instance (e.g. AWS *.metal Graviton) or a kernel built with CONFIG_KVM"
+		}
 		errs = append(errs, &PrereqError{
 			Check:   "/dev/kvm",
 			Message: "KVM not available — is this a virtual machine without nested virtualization?",
-			Hint:    "Enable KVM: sudo modprobe kvm_intel (or kvm_amd)",
+			Hint:    hint,
 		})
 	} else if !KVMAccessible() {
 		errs = append(errs, &PrereqError{
@@ -149,15 +162,19 @@ func FormatPrereqErrors(errs []*PrereqError) string {
 	return b.String()
 }
 
-// CheckSnapshot verifies a snapshot exists and is valid for the given version.
+// CheckSnapshot verifies a snapshot exists and can be restored with the
+// installed Firecracker and kernel. A snapshot taken with others gets an
+// *IncompatibleSnapshotError.
 func CheckSnapshot(paths *VMPaths, version string) error {
-	snapDir := paths.SnapshotDirForVersion(version)
-
-	for _, name := range []string{"metadata.json", "snapshot_mem", "snapshot_vmstate", "disk.ext4"} {
-		path := fmt.Sprintf("%s/%s", snapDir, name)
-		if _, err := os.Stat(path); err != nil {
-			return fmt.Errorf("no valid snapshot for version %s (missing %s). Run: dh vm prepare --version %s", version, name, version)
-		}
+	if err := snapshotComplete(paths, version); err != nil {
+		return err
+	}
+	meta, err := ReadSnapshotMetadata(paths.SnapshotDirForVersion(version))
+	if err != nil {
+		return nil // restore reports a broken metadata.json
+	}
+	if problems := snapshotToolchainProblems(paths, meta); len(problems) > 0 {
+		return &IncompatibleSnapshotError{Key: version, Problems: problems}
 	}
 	return nil
 }
@@ -0,0 +1,36 @@
+//go:build linux
+
+package vm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckSnapshotToolchain(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	writeFakeFirecracker(t, paths, "v1.12.0")
+	writeTestSnapshot(t, paths, "0.36.0", SnapshotMetadata{Version: "0.36.0", Firecracker: "v1.12.0"})
+	if err := CheckSnapshot(paths, "0.36.0"); err != nil {
+		t.Fatalf("expected a usable snapshot, got %v", err)
+	}
+
+	writeFakeFirecracker(t, paths, "v1.13.0")
+	var incompatible *IncompatibleSnapshotError
+	if err := CheckSnapshot(paths, "0.36.0"); !errors.As(err, &incompatible) || incompatible.Key != "0.36.0" {
+		t.Fatalf("expected an IncompatibleSnapshotError, got %v", err)
+	}
+
+	// An incompatible snapshot is still complete, so dh vm gc keeps it.
+	snaps, _ := ListSnapshots(paths)
+	if len(snaps) != 1 || !snaps[0].Complete {
+		t.Errorf("expected a complete snapshot, got %+v", snaps)
+	}
+
+	os.Remove(filepath.Join(paths.SnapshotDirForVersion("0.36.0"), "disk.ext4"))
+	if err := CheckSnapshot(paths, "0.36.0"); err == nil || errors.As(err, &incompatible) {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A profile is a variant of a version's snapshot whose rootfs also has the
+// Python packages of a requirements file, prepared with
+// 'dh vm prepare --requirements FILE --profile NAME'. Its snapshot is in
+// snapshots/VERSION/NAME and its rootfs is deephaven-VERSION+NAME.ext4.
+//
+// Functions that take a version to find a snapshot or rootfs also accept
+// a SnapshotKey naming a profile.
+
+var validProfile = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ValidateProfile checks a profile name: lowercase letters, digits and
+// dashes, not starting with a dash.
+func ValidateProfile(name string) error {
+	if !validProfile.MatchString(name) {
+		return fmt.Errorf("invalid profile %q: use lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// SnapshotKey returns the key of a version's snapshot, or of one of its
+// profiles: "VERSION/PROFILE".
+func SnapshotKey(version, profile string) string {
+	if profile == "" {
+		return version
+	}
+	return version + "/" + profile
+}
+
+// SplitSnapshotKey returns the version and profile of a snapshot key.
+func SplitSnapshotKey(key string) (version, profile string) {
+	version, profile, _ = strings.Cut(key, "/")
+	return version, profile
+}
+
+// PrepareCommand returns the 'dh vm prepare' command line that creates the
+// snapshot for key.
+func PrepareCommand(key string) string {
+	version, profile := SplitSnapshotKey(key)
+	if profile == "" {
+		return "dh vm prepare --version " + version
+	}
+	return "dh vm prepare --version " + version + " --profile " + profile
+}
+
+// rootfsName returns the part of the rootfs file names for key.
+func rootfsName(key string) string {
+	return "deephaven-" + strings.Replace(key, "/", "+", 1)
+}
+
+// RequirementsForVersion returns where the requirements file a profile's
+// rootfs was built from is kept.
+func (p *VMPaths) RequirementsForVersion(key string) string {
+	return filepath.Join(p.RootfsDir, rootfsName(key)+".requirements.txt")
+}
+
+// ProfileRootfsFiles returns the rootfs images, package manifests and
+// requirements files of every profile of a version.
+func (p *VMPaths) ProfileRootfsFiles(version string) []string {
+	files, _ := filepath.Glob(filepath.Join(p.RootfsDir, rootfsName(version)+"+*"))
+	return files
+}
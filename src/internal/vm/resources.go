@@ -0,0 +1,222 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Before restoring a VM, and before pre-filling the pool, dh checks that
+// the host has the memory and disk for it: too many VMs then fail with a
+// message, or the pool keeps fewer, instead of the host's OOM killer
+// picking a victim. DH_VM_SKIP_RESOURCE_CHECK=1 skips the checks.
+
+const (
+	// vmGrowthMiB is the memory a restored VM is expected to take beyond
+	// what its guest had in use when the snapshot was taken: the data of
+	// the scripts it runs, and the JVM's growth.
+	vmGrowthMiB = 512
+
+	// hostReserveMiB is the memory left to the host and its other
+	// processes when counting how many VMs fit.
+	hostReserveMiB = 1024
+
+	// instanceDiskMiB is the disk an instance directory takes: the
+	// Firecracker log and metrics, the console log and the sockets.
+	instanceDiskMiB = 64
+
+	// minFreeDiskMiB is the disk left free under ~/.dh/vm however few VMs
+	// run, for the logs dh keeps of finished instances.
+	minFreeDiskMiB = 512
+)
+
+// HostResources is what the host has left for VMs.
+type HostResources struct {
+	MemAvailableMiB int // MemAvailable of /proc/meminfo
+
+	// CgroupLimitMiB is the tightest memory.max of dh's cgroup and the
+	// cgroups above it, 0 without a limit, and CgroupFreeMiB what is left
+	// of it. VMs are children of dh and count against the same limit.
+	CgroupLimitMiB int
+	CgroupFreeMiB  int
+
+	DiskFreeMiB int    // free space on the filesystem of the instance directories
+	DiskPath    string // where DiskFreeMiB was measured
+}
+
+// ReadHostResources measures what the host has left for VMs.
+func ReadHostResources(paths *VMPaths) (*HostResources, error) {
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return nil, fmt.Errorf("reading free memory: %w", err)
+	}
+	avail, ok := parseMeminfo(string(meminfo))["MemAvailable"]
+	if !ok {
+		return nil, fmt.Errorf("reading free memory: no MemAvailable in /proc/meminfo")
+	}
+	r := &HostResources{MemAvailableMiB: avail / 1024}
+
+	if data, err := os.ReadFile("/proc/self/cgroup"); err == nil {
+		if cg, ok := cgroupV2Path(string(data)); ok {
+			r.CgroupLimitMiB, r.CgroupFreeMiB = cgroupMemory("/sys/fs/cgroup", cg)
+		}
+	}
+
+	// The run directory may not exist yet; measure the closest directory
+	// that does.
+	r.DiskPath = paths.RunDir
+	for {
+		if _, err := os.Stat(r.DiskPath); err == nil || filepath.Dir(r.DiskPath) == r.DiskPath {
+			break
+		}
+		r.DiskPath = filepath.Dir(r.DiskPath)
+	}
+	if r.DiskFreeMiB, err = diskFreeMiB(r.DiskPath); err != nil {
+		return nil, fmt.Errorf("reading free disk of %s: %w", r.DiskPath, err)
+	}
+	return r, nil
+}
+
+// parseMeminfo returns the fields of a /proc/meminfo, in kB for those
+// that have a unit.
+func parseMeminfo(meminfo string) map[string]int {
+	fields := map[string]int{}
+	for _, line := range strings.Split(meminfo, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), " kB")); err == nil {
+			fields[name] = n
+		}
+	}
+	return fields
+}
+
+// cgroupV2Path returns the cgroup v2 path of a /proc/self/cgroup. Memory
+// limits of cgroup v1 hierarchies are not checked.
+func cgroupV2Path(procCgroup string) (string, bool) {
+	for _, line := range strings.Split(procCgroup, "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// cgroupMemory returns the tightest memory limit of cgroup cg, under the
+// cgroup v2 mount root, and of its ancestors, and what is left of it; 0,
+// 0 when none of them has a limit.
+func cgroupMemory(root, cg string) (limitMiB, freeMiB int) {
+	readMiB := func(dir, name string) (int, bool) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, false // "max"
+		}
+		return int(n / (1024 * 1024)), true
+	}
+	for dir := filepath.Join(root, cg); ; dir = filepath.Dir(dir) {
+		if limit, ok := readMiB(dir, "memory.max"); ok {
+			used, _ := readMiB(dir, "memory.current")
+			if free := max(limit-used, 0); limitMiB == 0 || free < freeMiB {
+				limitMiB, freeMiB = limit, free
+			}
+		}
+		if len(dir) <= len(root) {
+			return limitMiB, freeMiB
+		}
+	}
+}
+
+// MemFreeMiB returns the memory VMs may take: what the host has available,
+// within the cgroup's limit, less what is left to the host.
+func (r *HostResources) MemFreeMiB() int {
+	free := r.MemAvailableMiB
+	if r.CgroupLimitMiB > 0 {
+		free = min(free, r.CgroupFreeMiB)
+	}
+	return max(free-hostReserveMiB, 0)
+}
+
+// VMsFit returns how many more VMs that take vmMiB of memory each the host
+// has room for.
+func (r *HostResources) VMsFit(vmMiB int) int {
+	n := max(r.DiskFreeMiB-minFreeDiskMiB, 0) / instanceDiskMiB
+	if vmMiB > 0 {
+		n = min(n, r.MemFreeMiB()/vmMiB)
+	}
+	return n
+}
+
+// ResourceError is the error of a host without room for more VMs.
+type ResourceError struct {
+	msg string
+}
+
+func (e *ResourceError) Error() string { return e.msg }
+
+// CheckVMs returns a *ResourceError saying what is short when the host has
+// no room for n more VMs that take vmMiB of memory each.
+func (r *HostResources) CheckVMs(n, vmMiB int) error {
+	if r.VMsFit(vmMiB) >= n {
+		return nil
+	}
+	vms := "a VM"
+	if n > 1 {
+		vms = fmt.Sprintf("%d VMs", n)
+	}
+	if need := n*instanceDiskMiB + minFreeDiskMiB; r.DiskFreeMiB < need {
+		return &ResourceError{fmt.Sprintf("not enough disk for %s: %s has %d MiB free, %d MiB needed", vms, r.DiskPath, r.DiskFreeMiB, need)}
+	}
+	return &ResourceError{fmt.Sprintf("not enough memory for %s of about %d MiB each: %s, %d MiB kept for the host (stop other VMs, or set DH_VM_SKIP_RESOURCE_CHECK=1 to restore anyway)",
+		vms, vmMiB, r, hostReserveMiB)}
+}
+
+// String describes the memory the host has left, as in "3120 MiB available
+// (cgroup limit 4096 MiB, 2048 MiB free)".
+func (r *HostResources) String() string {
+	s := fmt.Sprintf("%d MiB available", r.MemAvailableMiB)
+	if r.CgroupLimitMiB > 0 {
+		s += fmt.Sprintf(" (cgroup limit %d MiB, %d MiB free)", r.CgroupLimitMiB, r.CgroupFreeMiB)
+	}
+	return s
+}
+
+// VMMemEstimateMiB returns the host memory a VM restored from a snapshot
+// with meta is expected to take: what its guest had in use, outside the
+// balloon, when the snapshot was taken, and room to grow. A VM with huge
+// page memory takes it from the huge page pool instead, which is checked
+// on its own.
+func VMMemEstimateMiB(meta *SnapshotMetadata) int {
+	if meta.HugePages {
+		return 0
+	}
+	_, memMiB := meta.MachineSize()
+	return min(memMiB-meta.BalloonMiB+vmGrowthMiB, memMiB)
+}
+
+// resourceChecksDisabled reports whether DH_VM_SKIP_RESOURCE_CHECK=1 turns
+// the resource checks off.
+func resourceChecksDisabled() bool {
+	return os.Getenv("DH_VM_SKIP_RESOURCE_CHECK") == "1"
+}
+
+// checkHostResources checks that the host has room for n more VMs
+// restored from a snapshot with meta. A host whose resources can't be
+// read passes.
+func checkHostResources(paths *VMPaths, meta *SnapshotMetadata, n int) error {
+	if resourceChecksDisabled() {
+		return nil
+	}
+	r, err := ReadHostResources(paths)
+	if err != nil {
+		return nil
+	}
+	return r.CheckVMs(n, VMMemEstimateMiB(meta))
+}
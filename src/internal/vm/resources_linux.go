@@ -0,0 +1,13 @@
+package vm
+
+import "golang.org/x/sys/unix"
+
+// diskFreeMiB returns the space left to unprivileged users on the
+// filesystem of path.
+func diskFreeMiB(path string) (int, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int(stat.Bavail * uint64(stat.Bsize) / (1024 * 1024)), nil
+}
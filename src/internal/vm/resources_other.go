@@ -0,0 +1,10 @@
+//go:build !linux
+
+package vm
+
+import "fmt"
+
+// diskFreeMiB is unsupported: VM mode requires Linux.
+func diskFreeMiB(_ string) (int, error) {
+	return 0, fmt.Errorf("VM mode requires Linux")
+}
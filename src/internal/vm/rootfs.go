@@ -0,0 +1,67 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// RootfsOptions are the choices a rootfs image is built with. Zero values
+// are the defaults.
+type RootfsOptions struct {
+	BaseImage    string // FROM image; "" is DefaultBaseImage
+	Python       string // Python version from the image's packages, e.g. "3.11"; "" is its python3
+	Requirements string // requirements file installed in a profile's rootfs
+}
+
+var validPython = regexp.MustCompile(`^3\.[0-9]+$`)
+
+// Validate checks the base image and Python version.
+func (o RootfsOptions) Validate() error {
+	if o.BaseImage != "" && strings.ContainsAny(o.BaseImage, " \t\n") {
+		return fmt.Errorf("invalid base image %q", o.BaseImage)
+	}
+	if o.Python != "" && !validPython.MatchString(o.Python) {
+		return fmt.Errorf("invalid Python version %q: use 3.X, e.g. 3.11", o.Python)
+	}
+	return nil
+}
+
+// RootfsCurrent reports whether the rootfs for a version or SnapshotKey
+// exists and was built with the options that are set: the same base
+// image and Python version, and a requirements file with the same content.
+// Unset options accept whatever the rootfs was built with.
+func RootfsCurrent(paths *VMPaths, version string, opts RootfsOptions) bool {
+	if _, err := os.Stat(paths.RootfsForVersion(version)); err != nil {
+		return false
+	}
+	if opts.BaseImage != "" || opts.Python != "" {
+		// Images from before the manifest recorded them were built from
+		// DefaultBaseImage's python3.
+		m, err := ReadRootfsManifest(paths, version)
+		if err != nil {
+			return false
+		}
+		base := m.BaseImage
+		if base == "" {
+			base = DefaultBaseImage
+		}
+		if opts.BaseImage != "" && opts.BaseImage != base {
+			return false
+		}
+		if opts.Python != "" && m.Python != opts.Python && !strings.HasPrefix(m.Python, opts.Python+".") {
+			return false
+		}
+	}
+	if opts.Requirements == "" {
+		return true
+	}
+	want, err := os.ReadFile(opts.Requirements)
+	if err != nil {
+		return false
+	}
+	have, err := os.ReadFile(paths.RequirementsForVersion(version))
+	return err == nil && bytes.Equal(have, want)
+}
@@ -9,6 +9,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 )
 
 //go:embed vm_runner.py
@@ -17,24 +19,13 @@ var vmRunnerScript string
 //go:embed embed/libworkspace.c
 var libworkspaceSource string
 
-// dockerfileTemplate creates a minimal Linux image with JVM + Deephaven.
-const dockerfileTemplate = `FROM ubuntu:22.04
-
-ENV DEBIAN_FRONTEND=noninteractive
-
-RUN apt-get update && apt-get install -y --no-install-recommends \
-    python3 python3-pip python3-venv python3-dev \
-    openjdk-17-jre-headless \
-    iproute2 \
-    && rm -rf /var/lib/apt/lists/*
-
-RUN python3 -m pip install --no-cache-dir --upgrade setuptools wheel
-RUN python3 -m pip install --no-cache-dir \
-    deephaven-server==%s \
-    pydeephaven==%s \
-    deephaven-plugin-ui \
-    deephaven-plugin-plotly-express
+//go:embed embed/workspace_fuse.py
+var workspaceFuseScript string
 
+// vmDockerfile is the part of the rootfs Dockerfile that ServerDockerfile
+// doesn't cover: the workspace library and FUSE daemon, init script and
+// runner daemon.
+const vmDockerfile = `
 COPY libworkspace.c /tmp/libworkspace.c
 RUN apt-get update && apt-get install -y --no-install-recommends gcc \
     && gcc -shared -fPIC -O2 -o /opt/libworkspace.so /tmp/libworkspace.c -ldl -lpthread \
@@ -45,11 +36,36 @@ RUN apt-get update && apt-get install -y --no-install-recommends gcc \
 COPY init.sh /sbin/init.sh
 RUN chmod +x /sbin/init.sh
 COPY vm_runner.py /opt/vm_runner.py
+COPY workspace_fuse.py /opt/workspace_fuse.py
+`
+
+// requirementsDockerfile installs a profile's requirements file into the
+// rootfs.
+const requirementsDockerfile = `
+COPY requirements.txt /tmp/requirements.txt
+RUN python3 -m pip install --no-cache-dir -r /tmp/requirements.txt \
+    && rm /tmp/requirements.txt
 `
 
 // initScriptTemplate is the VM init process that starts Deephaven.
-// Communication with the host is via vsock (no TAP networking needed).
+// Communication with the host is via vsock; the TAP device of --net=nat is
+// only for the guest's outbound traffic.
 const initScriptTemplate = `#!/bin/bash
+# With a read-only root (dh_ro_root=1 on the kernel command line, the
+# default of dh vm prepare) the disk is attached read-only, and the guest
+# runs on an overlay of it that keeps every change in a tmpfs: VMs restored
+# from the snapshot share the disk without copies, and nothing they do
+# reaches it. The rest of this script then runs again in the overlay.
+if [ "${dh_ro_root:-}" = 1 ] && [ "${1:-}" != overlay ]; then
+    mount -t tmpfs -o mode=0755 tmpfs /tmp
+    mkdir -p /tmp/rw/upper /tmp/rw/work /tmp/root
+    if mount -t overlay overlay -o lowerdir=/,upperdir=/tmp/rw/upper,workdir=/tmp/rw/work /tmp/root; then
+        cd /tmp/root && pivot_root . mnt && exec chroot . /sbin/init.sh overlay
+    fi
+    echo "dh: no overlay root (does the kernel have overlayfs?); the root stays read-only" > /dev/ttyS0 2>/dev/null || true
+    umount /tmp
+fi
+
 # Mount essential filesystems
 mount -t proc proc /proc
 mount -t sysfs sysfs /sys
@@ -59,32 +75,57 @@ mount -t tmpfs tmpfs /tmp
 # Ensure loopback interface is up (required for localhost TCP after snapshot restore)
 ip link set lo up
 
+# With dh vm prepare --net=nat the VM has a network interface behind NAT on
+# the host (dh_net=nat on the kernel command line). Its address is the same
+# in every VM restored from the snapshot: each one has a network of its own.
+if [ "${dh_net:-}" = nat ]; then
+    ip addr add 172.16.0.2/30 dev eth0
+    ip link set eth0 up
+    ip route add default via 172.16.0.1
+    rm -f /etc/resolv.conf
+    echo "nameserver ${dh_dns:-1.1.1.1}" > /etc/resolv.conf
+fi
+
 # Transparent workspace file access via LD_PRELOAD.
 # libworkspace.so intercepts file operations for /workspace/* paths and proxies
 # them to the host file server over vsock. It is dormant during boot (no process
 # accesses /workspace/*) and only activates after snapshot restore when the
-# runner does os.chdir('/workspace').
+# runner does os.chdir('/workspace'). With --mount-mode=fuse the runner mounts
+# /workspace instead, and the library steps aside.
 export LD_PRELOAD=/opt/libworkspace.so
 mkdir -p /workspace
 
 # Ensure pip-installed packages are on Python's path.
 # Firecracker's minimal boot can cause sys.prefix detection issues.
-export PYTHONPATH=/usr/local/lib/python3.10/dist-packages
+for d in /usr/local/lib/python3*/dist-packages; do
+    [ -d "$d" ] && export PYTHONPATH=$d
+done
+
+# A rootfs built with dh vm prepare --python has its packages in a
+# virtualenv.
+[ -d /opt/python/bin ] && export PATH=/opt/python/bin:$PATH
 
-# Start Deephaven server
+# The JDK directory is named after the Debian architecture (amd64, arm64)
+export JAVA_HOME=$(echo /usr/lib/jvm/java-17-openjdk-*)
+
+# Start Deephaven server. The heap limit comes from the kernel command line
+# (dh_heap_mib, set by dh vm prepare from the VM memory); rootfs images are
+# shared by snapshots of every size. So do the extra JVM arguments of
+# dh vm prepare --guest-jvm-args (dh_jvm_args, base64), which come last
+# and so override the defaults.
+export DH_JVM_ARGS=$(echo "${dh_jvm_args:-}" | base64 -d 2>/dev/null)
 python3 -c "
 import os, sys, time, pathlib
-os.environ.setdefault('JAVA_HOME', '/usr/lib/jvm/java-17-openjdk-amd64')
 from deephaven_server import Server
 s = Server(port=10000, jvm_args=[
-    '-Xms32m', '-Xmx4g',
+    '-Xms32m', '-Xmx${dh_heap_mib:-4096}m',
     '-XX:-AlwaysPreTouch',
     '-XX:+UseG1GC',
     '-XX:MaxMetaspaceSize=256m',
     '-XX:+TieredCompilation',
     '-XX:CompileThreshold=100',
     '-DAuthHandlers=io.deephaven.auth.AnonymousAuthenticationHandler',
-])
+] + os.environ.get('DH_JVM_ARGS', '').split())
 s.start()
 pathlib.Path('/tmp/dh_ready').touch()
 while True:
@@ -116,67 +157,109 @@ echo "RUNNER_READY" > /dev/ttyS0 2>/dev/null || true
 exec sleep infinity
 `
 
-// buildRootfsDocker builds an ext4 rootfs image using Docker.
-func buildRootfsDocker(paths *VMPaths, version string, stderr io.Writer) error {
-	rootfsPath := paths.RootfsForVersion(version)
-
-	// Create temp build context
-	tmpDir, err := os.MkdirTemp("", "dh-vm-build-*")
-	if err != nil {
-		return fmt.Errorf("creating temp dir: %w", err)
+// writeRootfsContext writes the rootfs Dockerfile and the files it copies
+// into the build context dir.
+func writeRootfsContext(dir, version string, opts RootfsOptions) error {
+	dockerfile := ServerDockerfileWith(opts, version, DefaultPlugins)
+	if opts.Requirements != "" {
+		if err := copyFile(opts.Requirements, filepath.Join(dir, "requirements.txt")); err != nil {
+			return fmt.Errorf("copying requirements: %w", err)
+		}
+		dockerfile += requirementsDockerfile
 	}
-	defer os.RemoveAll(tmpDir)
-
-	// Write Dockerfile
-	dockerfile := fmt.Sprintf(dockerfileTemplate, version, version)
-	if err := os.WriteFile(filepath.Join(tmpDir, "Dockerfile"), []byte(dockerfile), 0o644); err != nil {
+	dockerfile += vmDockerfile
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0o644); err != nil {
 		return fmt.Errorf("writing Dockerfile: %w", err)
 	}
 
 	// Write init script
-	if err := os.WriteFile(filepath.Join(tmpDir, "init.sh"), []byte(initScriptTemplate), 0o755); err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "init.sh"), []byte(initScriptTemplate), 0o755); err != nil {
 		return fmt.Errorf("writing init.sh: %w", err)
 	}
 
 	// Write vm_runner.py (the in-VM execution daemon)
-	if err := os.WriteFile(filepath.Join(tmpDir, "vm_runner.py"), []byte(vmRunnerScript), 0o644); err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "vm_runner.py"), []byte(vmRunnerScript), 0o644); err != nil {
 		return fmt.Errorf("writing vm_runner.py: %w", err)
 	}
 
 	// Write libworkspace.c (LD_PRELOAD library for transparent workspace access)
-	if err := os.WriteFile(filepath.Join(tmpDir, "libworkspace.c"), []byte(libworkspaceSource), 0o644); err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "libworkspace.c"), []byte(libworkspaceSource), 0o644); err != nil {
 		return fmt.Errorf("writing libworkspace.c: %w", err)
 	}
 
-	imageName := fmt.Sprintf("dh-vm-%s", version)
+	// Write workspace_fuse.py (FUSE daemon for --mount-mode=fuse)
+	if err := os.WriteFile(filepath.Join(dir, "workspace_fuse.py"), []byte(workspaceFuseScript), 0o644); err != nil {
+		return fmt.Errorf("writing workspace_fuse.py: %w", err)
+	}
+	return nil
+}
+
+// buildRootfsDocker builds an ext4 rootfs image with the container CLI
+// engine (docker, podman or nerdctl). For a profile, key is a SnapshotKey.
+func buildRootfsDocker(paths *VMPaths, engine, key string, opts RootfsOptions, stderr io.Writer) error {
+	name := filepath.Base(engine)
+	rootfsPath := paths.RootfsForVersion(key)
+	version, _ := SplitSnapshotKey(key)
+
+	// Create temp build context
+	tmpDir, err := os.MkdirTemp("", "dh-vm-build-*")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := writeRootfsContext(tmpDir, version, opts); err != nil {
+		return err
+	}
+
+	imageName := "dh-vm-" + strings.Replace(key, "/", "-", 1)
 
-	// Docker build
-	fmt.Fprintf(stderr, "Building Docker image %s...\n", imageName)
-	buildCmd := exec.Command("docker", "build", "-t", imageName, tmpDir)
+	// Image build
+	fmt.Fprintf(stderr, "Building image %s with %s...\n", imageName, name)
+	buildCmd := exec.Command(engine, "build", "--platform", "linux/"+runtime.GOARCH, "-t", imageName, tmpDir)
 	buildCmd.Stdout = stderr
 	buildCmd.Stderr = stderr
 	if err := buildCmd.Run(); err != nil {
-		return fmt.Errorf("docker build failed: %w", err)
+		return fmt.Errorf("%s build failed: %w", name, err)
+	}
+
+	// Record the installed packages for `dh versions sbom`. A failure here
+	// only loses the manifest, so it is not fatal.
+	runInImage := func(name string) (string, error) {
+		out, err := exec.Command(engine, "run", "--rm", imageName, "sh", "-c", manifestScripts[name]).Output()
+		return string(out), err
+	}
+	if err := recordRootfsManifest(paths, key, opts, runInImage); err != nil {
+		fmt.Fprintf(stderr, "Warning: could not record rootfs package list: %v\n", err)
 	}
 
 	// Create container (remove any stale one from a previous interrupted run first)
 	containerName := "dh-vm-export-tmp"
-	exec.Command("docker", "rm", "-f", containerName).Run()
-	createCmd := exec.Command("docker", "create", "--name", containerName, imageName)
+	exec.Command(engine, "rm", "-f", containerName).Run()
+	createCmd := exec.Command(engine, "create", "--name", containerName, imageName)
 	createOut, err := createCmd.Output()
 	if err != nil {
-		return fmt.Errorf("docker create failed: %w", err)
+		return fmt.Errorf("%s create failed: %w", name, err)
 	}
-	containerID := string(createOut[:12])
-	defer exec.Command("docker", "rm", "-f", containerName).Run()
+	// Engines differ in what else they print; the ID comes last.
+	fields := strings.Fields(string(createOut))
+	if len(fields) == 0 {
+		return fmt.Errorf("%s create printed no container ID", name)
+	}
+	containerID := fields[len(fields)-1]
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+	defer exec.Command(engine, "rm", "-f", containerName).Run()
 
-	// Export container filesystem to tarball
+	// Export container filesystem to tarball. Rootless engines write the
+	// files with their owners inside the container, as Docker does, so
+	// the extraction below needs no special casing.
 	tarPath := filepath.Join(tmpDir, "rootfs.tar")
 	fmt.Fprintf(stderr, "Exporting container %s filesystem...\n", containerID)
-	exportCmd := exec.Command("docker", "export", "-o", tarPath, containerName)
+	exportCmd := exec.Command(engine, "export", "-o", tarPath, containerName)
 	exportCmd.Stderr = stderr
 	if err := exportCmd.Run(); err != nil {
-		return fmt.Errorf("docker export failed: %w", err)
+		return fmt.Errorf("%s export failed: %w", name, err)
 	}
 
 	// Create ext4 image from tarball
@@ -185,13 +268,63 @@ func buildRootfsDocker(paths *VMPaths, version string, stderr io.Writer) error {
 		return fmt.Errorf("creating ext4 image: %w", err)
 	}
 
-	// Cleanup Docker image
-	exec.Command("docker", "rmi", imageName).Run()
+	// Cleanup image
+	exec.Command(engine, "rmi", imageName).Run()
+
+	// Keep the requirements next to the rootfs, so a later prepare can
+	// tell whether they changed.
+	if opts.Requirements != "" {
+		if err := copyFile(opts.Requirements, paths.RequirementsForVersion(key)); err != nil {
+			return fmt.Errorf("saving requirements: %w", err)
+		}
+	}
 
 	fmt.Fprintf(stderr, "Rootfs created at %s\n", rootfsPath)
 	return nil
 }
 
+// manifestScripts are the commands whose output recordRootfsManifest
+// reads from the rootfs.
+var manifestScripts = map[string]string{
+	"os-release": "cat /etc/os-release",
+	"debs":       `dpkg-query -W -f '${Package}\t${Version}\n'`,
+	"pips":       "python3 -m pip list --format=freeze",
+	"python":     "python3 --version",
+}
+
+// recordRootfsManifest lists the OS and the deb and pip packages of a
+// rootfs, built with opts, and writes them to the version's rootfs
+// manifest. run returns the output of one of manifestScripts.
+func recordRootfsManifest(paths *VMPaths, version string, opts RootfsOptions, run func(name string) (string, error)) error {
+	osRelease, err := run("os-release")
+	if err != nil {
+		return fmt.Errorf("reading os-release: %w", err)
+	}
+	debs, err := run("debs")
+	if err != nil {
+		return fmt.Errorf("listing deb packages: %w", err)
+	}
+	pips, err := run("pips")
+	if err != nil {
+		return fmt.Errorf("listing pip packages: %w", err)
+	}
+	python, err := run("python")
+	if err != nil {
+		return fmt.Errorf("reading Python version: %w", err)
+	}
+	m := &RootfsManifest{
+		OS:           ParseOSRelease(osRelease),
+		BaseImage:    opts.BaseImage,
+		Python:       strings.TrimPrefix(strings.TrimSpace(python), "Python "),
+		Packages:     append(ParseDpkgList(debs), ParsePipFreeze(pips)...),
+		ReadOnlyRoot: true,
+	}
+	if m.BaseImage == "" {
+		m.BaseImage = DefaultBaseImage
+	}
+	return WriteRootfsManifest(paths, version, m)
+}
+
 // fixMergedUsr restores the merged-usr symlinks that Docker export breaks.
 // On Ubuntu 22.04+, /lib is a symlink to /usr/lib (and similarly for /bin,
 // /sbin, /lib64). Docker export stores files under both /lib/... and /usr/lib/...,
@@ -288,10 +421,9 @@ func createExt4WithSudo(tarPath, outputPath, extractDir string, stderr io.Writer
 		return fmt.Errorf("mke2fs failed: %w", err)
 	}
 
-	// Make the output file owned by the calling user
-	if u := os.Getenv("USER"); u != "" {
-		exec.Command("sudo", "chown", u+":"+u, outputPath).Run()
-	}
+	// Make the output file owned by the calling user. $USER is often unset
+	// in CI containers, and a user's group need not share its name.
+	exec.Command("sudo", "chown", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()), outputPath).Run()
 
 	return nil
 }
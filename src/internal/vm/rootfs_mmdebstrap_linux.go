@@ -0,0 +1,128 @@
+//go:build linux
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// rootfsSuite is the Ubuntu release of the FROM image in ServerDockerfile.
+const rootfsSuite = "jammy"
+
+// ubuntuMirror returns the Ubuntu archive mmdebstrap installs from:
+// DH_VM_UBUNTU_MIRROR, or the public archive for the host's architecture.
+func ubuntuMirror() string {
+	if m := os.Getenv("DH_VM_UBUNTU_MIRROR"); m != "" {
+		return m
+	}
+	if runtime.GOARCH == "amd64" {
+		return "http://archive.ubuntu.com/ubuntu"
+	}
+	return "http://ports.ubuntu.com/ubuntu-ports"
+}
+
+// buildRootfsMmdebstrap builds the ext4 rootfs image without a container
+// engine. mmdebstrap (run unprivileged in a user namespace) installs a
+// minimal Ubuntu into a tarball, and a customize hook runs the rootfs
+// Dockerfile in it as a shell script (see DockerfileScript), so the result
+// matches the Docker build. It only builds on DefaultBaseImage. For a
+// profile, key is a SnapshotKey.
+func buildRootfsMmdebstrap(paths *VMPaths, mmdebstrap, key string, opts RootfsOptions, stderr io.Writer) error {
+	rootfsPath := paths.RootfsForVersion(key)
+	version, _ := SplitSnapshotKey(key)
+	if opts.BaseImage != "" && opts.BaseImage != DefaultBaseImage {
+		return fmt.Errorf("building on base image %s needs Docker, Podman or nerdctl", opts.BaseImage)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "dh-vm-build-*")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	contextDir := filepath.Join(tmpDir, "context")
+	manifestDir := filepath.Join(tmpDir, "manifest")
+	for _, dir := range []string{contextDir, manifestDir} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := writeRootfsContext(contextDir, version, opts); err != nil {
+		return err
+	}
+
+	// The build context goes to /tmp/dh-build in the new root, and the
+	// manifest lists come back out of /tmp/dh-manifest.
+	dockerfile, err := os.ReadFile(filepath.Join(contextDir, "Dockerfile"))
+	if err != nil {
+		return err
+	}
+	script, err := DockerfileScript(string(dockerfile), "/tmp/dh-build")
+	if err != nil {
+		return err
+	}
+	script += "mkdir -p /tmp/dh-manifest\n"
+	for name, cmd := range manifestScripts {
+		script += fmt.Sprintf("%s > /tmp/dh-manifest/%s || true\n", cmd, name)
+	}
+	if err := os.WriteFile(filepath.Join(contextDir, "build.sh"), []byte(script), 0o644); err != nil {
+		return fmt.Errorf("writing build.sh: %w", err)
+	}
+
+	tarPath := filepath.Join(tmpDir, "rootfs.tar")
+	fmt.Fprintf(stderr, "Installing Ubuntu %s with mmdebstrap...\n", rootfsSuite)
+	cmd := exec.Command(mmdebstrap,
+		"--variant=minbase",
+		"--components=main,universe",
+		"--architectures="+runtime.GOARCH,
+		`--customize-hook=mkdir -p "$1/tmp/dh-build"`,
+		"--customize-hook=sync-in "+contextDir+" /tmp/dh-build",
+		`--customize-hook=chroot "$1" /bin/sh /tmp/dh-build/build.sh`,
+		"--customize-hook=sync-out /tmp/dh-manifest "+manifestDir,
+		`--customize-hook=rm -rf "$1/tmp/dh-build" "$1/tmp/dh-manifest"`,
+		rootfsSuite, tarPath, ubuntuMirror(),
+	)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mmdebstrap failed: %w", err)
+	}
+
+	// Record the installed packages for `dh versions sbom`. A failure here
+	// only loses the manifest, so it is not fatal.
+	readManifest := func(name string) (string, error) {
+		out, err := os.ReadFile(filepath.Join(manifestDir, name))
+		return string(out), err
+	}
+	if err := recordRootfsManifest(paths, key, opts, readManifest); err != nil {
+		fmt.Fprintf(stderr, "Warning: could not record rootfs package list: %v\n", err)
+	}
+
+	fmt.Fprintf(stderr, "Creating ext4 rootfs image...\n")
+	if err := createExt4FromTar(tarPath, rootfsPath, stderr); err != nil {
+		return fmt.Errorf("creating ext4 image: %w", err)
+	}
+
+	if opts.Requirements != "" {
+		if err := copyFile(opts.Requirements, paths.RequirementsForVersion(key)); err != nil {
+			return fmt.Errorf("saving requirements: %w", err)
+		}
+	}
+
+	fmt.Fprintf(stderr, "Rootfs created at %s\n", rootfsPath)
+	return nil
+}
+
+// findMmdebstrap returns the path of mmdebstrap, the rootfs builder used
+// when no container engine is available.
+func findMmdebstrap() (string, error) {
+	path, err := exec.LookPath("mmdebstrap")
+	if err != nil {
+		return "", fmt.Errorf("mmdebstrap not found")
+	}
+	return path, nil
+}
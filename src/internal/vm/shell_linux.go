@@ -0,0 +1,215 @@
+//go:build linux
+
+package vm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/output"
+	"golang.org/x/sys/unix"
+)
+
+// ShellConfig configures RunShell.
+type ShellConfig struct {
+	DHHome  string
+	Version string
+	Verbose bool
+	UseUffd bool
+	EagerMB *int   // see VMConfig.EagerMB
+	UsePool bool   // take a warm VM from the pool daemon if it is running
+	Jailed  bool   // restore under the jailer; pool VMs aren't jailed
+	Net     string // see VMConfig.Net; pool VMs have no network
+}
+
+// shellRequest asks the runner for an interactive shell instead of running
+// code. Runners that predate shells answer it with an exec response.
+type shellRequest struct {
+	Shell bool   `json:"shell"`
+	Rows  int    `json:"rows"`
+	Cols  int    `json:"cols"`
+	Term  string `json:"term,omitempty"`
+}
+
+// bufferedConn is a net.Conn whose reads go through the bufio.Reader that
+// read the handshake, so no bytes buffered after it are lost.
+type bufferedConn struct {
+	*bufio.Reader
+	net.Conn
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+
+// OpenShell asks the runner in a VM to start an interactive shell on a PTY
+// of rows x cols and returns the connection, which carries the terminal's
+// bytes in both directions until the shell exits.
+func OpenShell(vsockPath string, port uint32, rows, cols int, term string) (io.ReadWriteCloser, error) {
+	conn, err := connectVsock(vsockPath, port)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to VM runner: %w", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	reqBytes, err := json.Marshal(&shellRequest{Shell: true, Rows: rows, Cols: cols, Term: term})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	reqBytes = append(reqBytes, '\n')
+	output.TraceFrame(fmt.Sprintf("vsock:%d", port), "->", reqBytes)
+	if _, err := conn.Write(reqBytes); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	output.TraceFrame(fmt.Sprintf("vsock:%d", port), "<-", line)
+
+	var frame vsockFrame
+	if err := json.Unmarshal(line, &frame); err != nil || frame.Type != "shell" {
+		conn.Close()
+		return nil, fmt.Errorf("the runner in this snapshot has no shell; rebuild it with 'dh vm clean --version VERSION' and 'dh vm prepare --version VERSION'")
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{Reader: reader, Conn: conn}, nil
+}
+
+// RunShell attaches the terminal on stdin/stdout to a shell in a VM: one
+// taken from the pool daemon when cfg.UsePool is set and the pool serves
+// cfg.Version, otherwise one restored from the snapshot. The VM is
+// destroyed when the shell exits.
+func RunShell(ctx context.Context, cfg *ShellConfig, stdin *os.File, stdout, stderr io.Writer) error {
+	fd := int(stdin.Fd())
+	rows, cols := 24, 80
+	if ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ); err == nil && ws.Row > 0 {
+		rows, cols = int(ws.Row), int(ws.Col)
+	}
+	term := os.Getenv("TERM")
+	cwd, _ := os.Getwd()
+
+	var shell io.ReadWriteCloser
+	if cfg.UsePool && PoolProbe() {
+		resp, conn, err := PoolShell(&PoolRequest{Type: "shell", Version: cfg.Version, WaitMs: PoolWaitMs, CWD: cwd, Rows: rows, Cols: cols, Term: term,
+			Generation: SnapshotGeneration(NewVMPaths(cfg.DHHome), cfg.Version)})
+		switch {
+		case err != nil:
+			err = fmt.Errorf("pool shell failed: %w", err)
+		case conn == nil:
+			err = fmt.Errorf("pool error: %s", resp.Error)
+		case resp.Version != cfg.Version:
+			conn.Close()
+			err = fmt.Errorf("pool version mismatch (pool=%s, requested=%s)", resp.Version, cfg.Version)
+		default:
+			shell = conn
+		}
+		if err != nil && cfg.Verbose {
+			fmt.Fprintf(stderr, "%v, falling back to cold restore\n", err)
+		}
+	}
+
+	if shell == nil {
+		paths := NewVMPaths(cfg.DHHome)
+		if errs := CheckPrerequisites(paths); len(errs) > 0 {
+			var msgs []string
+			for _, e := range errs {
+				msgs = append(msgs, e.Error())
+			}
+			return fmt.Errorf("VM prerequisites not met:\n  %s", strings.Join(msgs, "\n  "))
+		}
+		if err := CheckSnapshot(paths, cfg.Version); err != nil {
+			return err
+		}
+		go CleanupStaleInstances(paths)
+
+		if cfg.Verbose {
+			fmt.Fprintf(stderr, "Restoring VM from snapshot for version %s...\n", cfg.Version)
+		}
+		vmCfg := &VMConfig{
+			DHHome:  cfg.DHHome,
+			Version: cfg.Version,
+			Verbose: cfg.Verbose,
+			UseUffd: cfg.UseUffd,
+			EagerMB: cfg.EagerMB,
+			Jailed:  cfg.Jailed,
+			Net:     cfg.Net,
+		}
+		info, machine, uffdCloser, err := RestoreFromSnapshot(ctx, vmCfg, paths, stderr)
+		if err != nil {
+			return fmt.Errorf("restoring VM: %w", err)
+		}
+		defer func() {
+			DestroyInstance(machine, info, paths)
+			if uffdCloser != nil {
+				uffdCloser.Close()
+			}
+		}()
+
+		fileServer, err := StartFileServer(info.GuestVsockPath, cwd, nil)
+		if err != nil && cfg.Verbose {
+			fmt.Fprintf(stderr, "Warning: file server: %v\n", err)
+		}
+		if fileServer != nil {
+			defer fileServer.Close()
+		}
+
+		shell, err = OpenShell(info.VsockPath, VsockPort, rows, cols, term)
+		if err != nil {
+			return err
+		}
+	}
+	defer shell.Close()
+
+	// Keystrokes, Ctrl+C included, go to the shell in the VM; a signal to
+	// this process ends the session, which tears the VM down.
+	if restore, err := makeRaw(fd); err == nil {
+		defer restore()
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	go func() {
+		if _, ok := <-sigCh; ok {
+			shell.Close()
+		}
+	}()
+
+	go io.Copy(shell, stdin)
+	io.Copy(stdout, shell)
+	return nil
+}
+
+// makeRaw puts the terminal fd in raw mode, as cfmakeraw(3) does, and
+// returns a function that restores its previous mode. It fails if fd is
+// not a terminal.
+func makeRaw(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, old) }, nil
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ShellConfig configures RunShell.
+type ShellConfig struct {
+	DHHome  string
+	Version string
+	Verbose bool
+	UseUffd bool
+	UsePool bool
+	Jailed  bool
+}
+
+func RunShell(_ context.Context, _ *ShellConfig, _ *os.File, _, _ io.Writer) error {
+	return fmt.Errorf("VM mode requires Linux with KVM support")
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockSnapshot takes the lock of the snapshot for key: shared while a VM
+// is restored from it, exclusive while it is taken, imported or deleted.
+// When another process holds a conflicting lock it says so on stderr and
+// waits, or with a nil stderr fails. The lock file is outside the snapshot
+// directory, which those replace.
+func lockSnapshot(paths *VMPaths, key string, exclusive bool, stderr io.Writer) (func(), error) {
+	lockPath := paths.snapshotLockFile(key)
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0o755); err != nil {
+		return nil, fmt.Errorf("creating snapshot lock directory: %w", err)
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening snapshot lock: %w", err)
+	}
+
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	if err := unix.Flock(int(f.Fd()), how|unix.LOCK_NB); err == unix.EWOULDBLOCK {
+		if stderr == nil {
+			f.Close()
+			return nil, fmt.Errorf("the snapshot for version %s is in use by another dh process; try again when it is done", key)
+		}
+		if exclusive {
+			fmt.Fprintf(stderr, "Waiting for VMs being restored from the snapshot for version %s...\n", key)
+		} else {
+			fmt.Fprintf(stderr, "Waiting for the snapshot for version %s to be prepared...\n", key)
+		}
+		err = unix.Flock(int(f.Fd()), how)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking snapshot: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
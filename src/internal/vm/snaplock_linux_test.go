@@ -0,0 +1,56 @@
+//go:build linux
+
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockSnapshot(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+
+	// Restores share the lock.
+	unlock1, err := lockSnapshot(paths, "0.36.0", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock2, err := lockSnapshot(paths, "0.36.0", false, nil)
+	if err != nil {
+		t.Fatalf("expected restores to share the lock, got %v", err)
+	}
+
+	// Replacing the snapshot fails without stderr, and waits with it.
+	if _, err := lockSnapshot(paths, "0.36.0", true, nil); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected an in use error, got %v", err)
+	}
+	if unlock, err := lockSnapshot(paths, "0.36.0/myproj", true, nil); err != nil {
+		t.Errorf("expected a profile to have a lock of its own, got %v", err)
+	} else {
+		unlock()
+	}
+	unlock1()
+	locked := make(chan struct{})
+	var stderr bytes.Buffer
+	go func() {
+		unlock, err := lockSnapshot(paths, "0.36.0", true, &stderr)
+		if err != nil {
+			t.Error(err)
+		} else {
+			unlock()
+		}
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("exclusive lock taken while a restore holds it")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock2()
+	<-locked
+	if !strings.Contains(stderr.String(), "Waiting for VMs being restored") {
+		t.Errorf("expected a waiting message, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package vm
+
+import "io"
+
+// lockSnapshot is a no-op: VM mode requires Linux.
+func lockSnapshot(_ *VMPaths, _ string, _ bool, _ io.Writer) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,277 @@
+package vm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// snapshotFiles are the files that make up a complete snapshot. A
+// compressed snapshot has snapshot_mem.zst in place of snapshot_mem.
+var snapshotFiles = []string{"metadata.json", "snapshot_mem", "snapshot_vmstate", "disk.ext4"}
+
+// SnapshotInfo describes one snapshot directory under ~/.dh/vm/snapshots.
+type SnapshotInfo struct {
+	Version   string
+	Dir       string
+	SizeBytes int64             // disk space actually used (snapshot_mem is sparse)
+	Complete  bool              // all snapshot files present
+	Meta      *SnapshotMetadata // nil when metadata.json is missing or unreadable
+}
+
+// ListSnapshots returns every snapshot directory, sorted by version. The
+// profiles of a version follow it, with a SnapshotKey as their Version; a
+// version directory holding only profiles is not listed itself. A missing
+// snapshots directory is not an error.
+func ListSnapshots(paths *VMPaths) ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(paths.SnapshotDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading snapshots: %w", err)
+	}
+	var infos []SnapshotInfo
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue // hidden: an import in progress
+		}
+		ver := e.Name()
+		profiles := snapshotProfiles(paths, ver)
+		var profileBytes int64
+		for _, p := range profiles {
+			info := snapshotInfo(paths, SnapshotKey(ver, p))
+			profileBytes += info.SizeBytes
+			infos = append(infos, info)
+		}
+		info := snapshotInfo(paths, ver)
+		info.SizeBytes -= profileBytes
+		if len(profiles) > 0 && info.SizeBytes == 0 {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Version < infos[j].Version })
+	return infos, nil
+}
+
+// snapshotProfiles returns the profiles with a directory in a version's
+// snapshot directory.
+func snapshotProfiles(paths *VMPaths, version string) []string {
+	entries, _ := os.ReadDir(paths.SnapshotDirForVersion(version))
+	var profiles []string
+	for _, e := range entries {
+		if e.IsDir() && ValidateProfile(e.Name()) == nil {
+			profiles = append(profiles, e.Name())
+		}
+	}
+	return profiles
+}
+
+func snapshotInfo(paths *VMPaths, key string) SnapshotInfo {
+	dir := paths.SnapshotDirForVersion(key)
+	info := SnapshotInfo{
+		Version:   key,
+		Dir:       dir,
+		SizeBytes: dirDiskUsage(dir),
+		Complete:  snapshotComplete(paths, key) == nil,
+	}
+	if meta, err := ReadSnapshotMetadata(dir); err == nil {
+		info.Meta = meta
+	}
+	return info
+}
+
+// ReadSnapshotMetadata reads metadata.json from a snapshot directory.
+func ReadSnapshotMetadata(snapDir string) (*SnapshotMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(snapDir, "metadata.json"))
+	if err != nil {
+		return nil, err
+	}
+	var meta SnapshotMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parsing metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// SnapshotGeneration returns the GenerationID of the snapshot for key, or
+// "" when it has no readable metadata.
+func SnapshotGeneration(paths *VMPaths, key string) string {
+	meta, err := ReadSnapshotMetadata(paths.SnapshotDirForVersion(key))
+	if err != nil {
+		return ""
+	}
+	return meta.GenerationID()
+}
+
+// newGeneration returns a random snapshot generation.
+func newGeneration() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// snapshotComplete checks that all the files of a snapshot exist.
+func snapshotComplete(paths *VMPaths, version string) error {
+	snapDir := paths.SnapshotDirForVersion(version)
+	for _, name := range snapshotFiles {
+		path := filepath.Join(snapDir, name)
+		if name == "snapshot_mem" {
+			path = snapshotMemPath(snapDir)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("no valid snapshot for version %s (missing %s). Run: %s", version, name, PrepareCommand(version))
+		}
+	}
+	return nil
+}
+
+// VerifySnapshot checks a snapshot's integrity and returns a description of
+// each problem found, or nil when it is usable. Besides the checks done by
+// CheckSnapshot it validates the metadata, file sizes, and that the kernel
+// and Firecracker binary have not changed since the snapshot was taken.
+func VerifySnapshot(paths *VMPaths, version string) []string {
+	snapDir := paths.SnapshotDirForVersion(version)
+	var problems []string
+	memPath := snapshotMemPath(snapDir)
+	for _, name := range snapshotFiles {
+		if name == "snapshot_mem" {
+			name = filepath.Base(memPath)
+		}
+		fi, err := os.Stat(filepath.Join(snapDir, name))
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("missing %s", name))
+		case fi.Size() == 0:
+			problems = append(problems, fmt.Sprintf("%s is empty", name))
+		}
+	}
+
+	meta, err := ReadSnapshotMetadata(snapDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("unreadable metadata.json: %v", err))
+		}
+		return problems
+	}
+	if key := SnapshotKey(meta.Version, meta.Profile); key != version {
+		problems = append(problems, fmt.Sprintf("metadata is for version %s", key))
+	}
+	if meta.MemSizeMiB > 0 {
+		want := int64(meta.MemSizeMiB) << 20
+		if filepath.Base(memPath) == CompressedMemFile {
+			if c, err := OpenCompressedMem(memPath); err != nil {
+				problems = append(problems, err.Error())
+			} else {
+				if got := int64(c.Size()); got != want {
+					problems = append(problems, fmt.Sprintf("%s holds %d bytes, expected %d", CompressedMemFile, got, want))
+				}
+				c.Close()
+			}
+		} else if fi, err := os.Stat(memPath); err == nil {
+			if fi.Size() != want {
+				problems = append(problems, fmt.Sprintf("snapshot_mem is %d bytes, expected %d", fi.Size(), want))
+			}
+		}
+	}
+	problems = append(problems, snapshotToolchainProblems(paths, meta)...)
+	return problems
+}
+
+// RebuildSnapshot takes the snapshot for cfg.Version and cfg.Profile again
+// from its rootfs, with the machine size, memory compression, jailer,
+// network and guest JVM arguments of the snapshot it replaces. dh exec --auto-rebuild
+// uses it after a Firecracker or kernel upgrade.
+func RebuildSnapshot(ctx context.Context, cfg *VMConfig, paths *VMPaths, stderr io.Writer) error {
+	key := SnapshotKey(cfg.Version, cfg.Profile)
+	meta, err := ReadSnapshotMetadata(paths.SnapshotDirForVersion(key))
+	if err != nil {
+		return fmt.Errorf("reading snapshot metadata: %w", err)
+	}
+	if _, err := os.Stat(paths.RootfsForVersion(key)); err != nil {
+		return fmt.Errorf("no rootfs to rebuild the snapshot for version %s from. Run: %s", key, PrepareCommand(key))
+	}
+	vcpus, memMiB := meta.MachineSize()
+	return BootAndSnapshot(ctx, &VMConfig{
+		DHHome:       cfg.DHHome,
+		Version:      cfg.Version,
+		Profile:      cfg.Profile,
+		Verbose:      cfg.Verbose,
+		Compress:     meta.Compressed,
+		VCPUs:        vcpus,
+		MemSizeMiB:   memMiB,
+		GuestJVMArgs: meta.GuestJVMArgs,
+		Jailed:       meta.Jailed,
+		HugePages:    meta.HugePages,
+		Net:          meta.Net,
+		WritableRoot: !meta.ReadOnlyRoot,
+	}, paths, stderr)
+}
+
+// DeleteSnapshot removes the snapshot for a version or SnapshotKey. The
+// rootfs image is kept so a new snapshot can be prepared without
+// rebuilding it, and so are the version's profiles.
+func DeleteSnapshot(paths *VMPaths, version string) error {
+	snapDir := paths.SnapshotDirForVersion(version)
+	if _, err := os.Stat(snapDir); err != nil {
+		return fmt.Errorf("no snapshot for version %s", version)
+	}
+	unlock, err := lockSnapshot(paths, version, true, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, profile := SplitSnapshotKey(version); profile == "" {
+		if profiles := snapshotProfiles(paths, version); len(profiles) > 0 {
+			return deleteSnapshotFiles(snapDir, profiles)
+		}
+	}
+	if err := os.RemoveAll(snapDir); err != nil {
+		return fmt.Errorf("deleting snapshot: %w", err)
+	}
+	return nil
+}
+
+// deleteSnapshotFiles removes everything in snapDir except the profile
+// directories.
+func deleteSnapshotFiles(snapDir string, profiles []string) error {
+	entries, err := os.ReadDir(snapDir)
+	if err != nil {
+		return fmt.Errorf("deleting snapshot: %w", err)
+	}
+	for _, e := range entries {
+		if slices.Contains(profiles, e.Name()) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(snapDir, e.Name())); err != nil {
+			return fmt.Errorf("deleting snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// DiskUsage returns the disk space used by the file or directory tree at
+// path. Sparse files count only their allocated blocks.
+func DiskUsage(path string) int64 {
+	return dirDiskUsage(path)
+}
+
+func dirDiskUsage(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			total += fileDiskUsage(fi)
+		}
+		return nil
+	})
+	return total
+}
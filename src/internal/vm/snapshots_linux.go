@@ -0,0 +1,17 @@
+//go:build linux
+
+package vm
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileDiskUsage returns the allocated size of a file, which for the sparse
+// snapshot_mem is much smaller than its apparent size.
+func fileDiskUsage(fi os.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return fi.Size()
+}
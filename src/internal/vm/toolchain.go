@@ -0,0 +1,142 @@
+package vm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A snapshot only restores with the Firecracker release and guest kernel
+// it was taken with. Both are recorded in its metadata and checked before
+// every restore. Asking the binary for its version and hashing the kernel
+// are too slow for that, so their results are kept in toolchain.json,
+// valid while the file keeps its size and modification time.
+
+// IncompatibleSnapshotError is returned by CheckSnapshot for a snapshot
+// taken with another Firecracker release or kernel than the installed ones.
+type IncompatibleSnapshotError struct {
+	Key      string   // snapshot key
+	Problems []string // what changed since the snapshot was taken
+}
+
+func (e *IncompatibleSnapshotError) Error() string {
+	return fmt.Sprintf("snapshot for version %s can't be restored (%s): snapshots only restore with the Firecracker and kernel they were taken with. Run: %s (or pass --auto-rebuild to dh exec)",
+		e.Key, strings.Join(e.Problems, "; "), PrepareCommand(e.Key))
+}
+
+// toolchainFingerprint is a cached fingerprint of a file in ~/.dh/vm.
+type toolchainFingerprint struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Value   string    `json:"value"`
+}
+
+// InstalledFirecracker returns the release of the installed Firecracker
+// binary, e.g. "v1.12.0", from firecracker --version.
+func InstalledFirecracker(paths *VMPaths) (string, error) {
+	return cachedFingerprint(paths, paths.Firecracker, firecrackerVersion)
+}
+
+// currentFirecracker returns the release of the installed Firecracker
+// binary, or FirecrackerVersion, which dh vm prepare installs, when it
+// can't be told.
+func currentFirecracker(paths *VMPaths) string {
+	if v, err := InstalledFirecracker(paths); err == nil {
+		return v
+	}
+	return FirecrackerVersion
+}
+
+// KernelFingerprint returns the SHA-256 of the guest kernel image, used to
+// detect snapshots taken with a different kernel.
+func KernelFingerprint(paths *VMPaths) (string, error) {
+	return cachedFingerprint(paths, paths.Kernel, sha256File)
+}
+
+// snapshotToolchainProblems describes how the installed Firecracker and
+// kernel differ from those a snapshot was taken with. Fingerprints the
+// metadata doesn't have are not checked.
+func snapshotToolchainProblems(paths *VMPaths, meta *SnapshotMetadata) []string {
+	var problems []string
+	if meta.Firecracker != "" {
+		if installed := currentFirecracker(paths); installed != meta.Firecracker {
+			problems = append(problems, fmt.Sprintf("taken with Firecracker %s, installed is %s", meta.Firecracker, installed))
+		}
+	}
+	if meta.KernelSHA256 != "" {
+		if sum, err := KernelFingerprint(paths); err != nil {
+			problems = append(problems, "kernel is missing")
+		} else if sum != meta.KernelSHA256 {
+			problems = append(problems, "kernel has changed since the snapshot was taken")
+		}
+	}
+	return problems
+}
+
+// firecrackerVersion runs bin --version, which prints e.g.
+// "Firecracker v1.12.0" on its first line.
+func firecrackerVersion(bin string) (string, error) {
+	out, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("running %s --version: %w", bin, err)
+	}
+	first, _, _ := strings.Cut(string(out), "\n")
+	for _, f := range strings.Fields(first) {
+		if len(f) > 1 && f[0] == 'v' && f[1] >= '0' && f[1] <= '9' {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unrecognized %s --version output %q", bin, first)
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachedFingerprint returns compute(path), from toolchain.json when path
+// is unchanged since it was last computed.
+func cachedFingerprint(paths *VMPaths, path string, compute func(string) (string, error)) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	cache := map[string]toolchainFingerprint{}
+	if data, err := os.ReadFile(paths.Toolchain); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	name := filepath.Base(path)
+	if c, ok := cache[name]; ok && c.Size == fi.Size() && c.ModTime.Equal(fi.ModTime()) {
+		return c.Value, nil
+	}
+
+	value, err := compute(path)
+	if err != nil {
+		return "", err
+	}
+	cache[name] = toolchainFingerprint{Size: fi.Size(), ModTime: fi.ModTime(), Value: value}
+	// Best effort: concurrent execs each write a whole file and rename it
+	// into place, and a lost update is only computed again.
+	if data, err := json.Marshal(cache); err == nil {
+		tmp := fmt.Sprintf("%s.%d.tmp", paths.Toolchain, os.Getpid())
+		if os.WriteFile(tmp, data, 0o644) == nil && os.Rename(tmp, paths.Toolchain) != nil {
+			os.Remove(tmp)
+		}
+	}
+	return value, nil
+}
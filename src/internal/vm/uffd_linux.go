@@ -7,25 +7,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
-	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
+	"github.com/dsmmcken/dh-cli/src/internal/output"
 	"golang.org/x/sys/unix"
 )
 
-// UFFD ioctl numbers for amd64.
+// UFFD ioctl numbers, encoded as _IOWR(0xAA, nr, struct) with the generic
+// _IOC layout (dir<<30 | size<<16 | type<<8 | nr) that amd64 and arm64,
+// the architectures Firecracker runs on, both use.
 const (
-	// UFFDIO_COPY: _IOWR(0xAA, 0x03, struct uffdio_copy) where sizeof = 40.
-	_UFFDIO_COPY = 0xc028aa03
+	_IOC_WRITE = 1
+	_IOC_READ  = 2
+	_UFFDIO    = 0xAA
 
-	// UFFDIO_ZEROPAGE: _IOWR(0xAA, 0x04, struct uffdio_zeropage) where sizeof = 32.
-	_UFFDIO_ZEROPAGE = 0xc020aa04
+	// UFFDIO_COPY: _IOWR(0xAA, 0x03, struct uffdio_copy) = 0xc028aa03.
+	_UFFDIO_COPY = (_IOC_READ|_IOC_WRITE)<<30 | unsafe.Sizeof(ufffdioCopy{})<<16 | _UFFDIO<<8 | 0x03
+
+	// UFFDIO_ZEROPAGE: _IOWR(0xAA, 0x04, struct uffdio_zeropage) = 0xc020aa04.
+	_UFFDIO_ZEROPAGE = (_IOC_READ|_IOC_WRITE)<<30 | unsafe.Sizeof(uffdioZeropage{})<<16 | _UFFDIO<<8 | 0x04
 )
 
+// hostPageSize is the granularity of UFFDIO_ZEROPAGE. It is 4 KiB on amd64
+// but may be 16 or 64 KiB on arm64 hosts.
+var hostPageSize = uint64(os.Getpagesize())
+
 // copyChunkSize is the size of each UFFDIO_COPY request. 128MB chunks balance
 // ioctl count vs memory bandwidth utilization for parallel copy goroutines.
 // Used only in eager mode (DH_VM_EAGER_UFFD=1).
@@ -40,7 +56,7 @@ const copyWorkers = 4
 // the hugepage boundary for efficient memcpy.
 const lazyChunkSize = 2 * 1024 * 1024
 
-// uffdMsgSize is the size of struct uffd_msg (32 bytes on amd64).
+// uffdMsgSize is the size of struct uffd_msg (32 bytes on amd64 and arm64).
 const uffdMsgSize = 32
 
 // UFFD event types from linux/userfaultfd.h.
@@ -82,10 +98,16 @@ type memRegion struct {
 	PageSizeKiB      uint64 `json:"page_size_kib"` // deprecated, actually bytes despite name
 }
 
-// dataExtent describes a contiguous range of non-hole data in the snapshot file.
-type dataExtent struct {
-	offset uint64 // offset in file
-	length uint64 // length of data region
+// pageSize returns the size of the pages backing the region: 2 MiB for
+// huge page memory.
+func (r memRegion) pageSize() uint64 {
+	switch {
+	case r.PageSize != 0:
+		return r.PageSize
+	case r.PageSizeKiB != 0:
+		return r.PageSizeKiB
+	}
+	return hostPageSize
 }
 
 // regionInfo pairs a memory region with its data/hole extent map for the lazy handler.
@@ -98,7 +120,7 @@ type regionInfo struct {
 type copyJob struct {
 	uffdFd  int
 	dst     uint64 // destination in VM address space
-	src     uint64 // source in our mmap
+	off     uint64 // offset in the snapshot memory
 	length  uint64
 }
 
@@ -118,7 +140,12 @@ func ProbeUffd() bool {
 // faults are served on demand with 2MB-aligned UFFDIO_COPY from a pre-cached
 // mmap. In eager mode (DH_VM_EAGER_UFFD=1), data pages are bulk-copied
 // before VM resume. The snapshot file is pre-loaded into the page cache to
-// minimize I/O latency in both modes.
+// minimize I/O latency in both modes. A compressed snapshot is not mapped;
+// each copy decompresses the blocks it needs into a worker's buffer.
+// uffdFaults counts the page faults served by every UFFD handler in this
+// process, for the pool daemon's metrics.
+var uffdFaults atomic.Uint64
+
 type uffdHandler struct {
 	socketPath string
 	memFile    string
@@ -127,12 +154,26 @@ type uffdHandler struct {
 	done       chan error // signaled when population setup completes (nil = success)
 	cancel     context.CancelFunc
 
+	// faultWorkers is the number of goroutines serving lazy faults; twice
+	// the VM's vCPUs, and at least 4.
+	faultWorkers int
+
+	// eagerMB is VMConfig.EagerMB.
+	eagerMB *int
+
+	// memInfo is memFile as restored from; hot chunks are saved only while
+	// the snapshot has not been taken again.
+	memInfo os.FileInfo
+
 	// Pre-loaded file data (available before Firecracker connects)
 	file     *os.File
 	fileSize uint64
 	mmapData []byte
 	mmapBase uintptr
 
+	// Set instead of the mmap when the snapshot is compressed.
+	compressed *CompressedMem
+
 	// Pre-scanned data extents (computed during preload, used by doPopulate)
 	preExtents []dataExtent // sorted by offset, covering the whole file
 	preSparse  bool         // true if sparse scanning succeeded
@@ -142,12 +183,61 @@ type uffdHandler struct {
 	// Protected by lazyMu. Only used in lazy mode.
 	lazyMu          sync.Mutex
 	populatedChunks map[uint64]struct{}
+
+	// File offsets of the chunks with snapshot data that faulted, saved to
+	// the snapshot's hot set on Close. Protected by lazyMu.
+	hotChunks map[uint64]struct{}
+	learned   atomic.Bool // the eager copy was the hot set
+	hugePages atomic.Bool // guest memory is in huge pages
+
+	// Telemetry, reported by stats.
+	faults     atomic.Uint64
+	eagerBytes atomic.Uint64
+	lazyBytes  atomic.Uint64
+	eagerNanos atomic.Int64
+	samplesMu  sync.Mutex
+	samples    []time.Duration // fault service times, the first maxFaultSamples
+}
+
+// pendingFault is a page fault waiting for a fault worker.
+type pendingFault struct {
+	addr uint64
+	read time.Time // when the fault message was read
+}
+
+// recordFault counts a served fault and how long it took since read.
+func (h *uffdHandler) recordFault(read time.Time) {
+	h.faults.Add(1)
+	uffdFaults.Add(1)
+	d := time.Since(read)
+	h.samplesMu.Lock()
+	if len(h.samples) < maxFaultSamples {
+		h.samples = append(h.samples, d)
+	}
+	h.samplesMu.Unlock()
+}
+
+// stats returns the handler's telemetry so far.
+func (h *uffdHandler) stats() UffdStats {
+	h.samplesMu.Lock()
+	p50, p99 := faultPercentiles(h.samples)
+	h.samplesMu.Unlock()
+	return UffdStats{
+		Faults:     h.faults.Load(),
+		EagerBytes: h.eagerBytes.Load(),
+		LazyBytes:  h.lazyBytes.Load(),
+		EagerMs:    float64(h.eagerNanos.Load()) / 1e6,
+		Learned:    h.learned.Load(),
+		HugePages:  h.hugePages.Load(),
+		FaultP50Us: p50,
+		FaultP99Us: p99,
+	}
 }
 
 // startUffdHandler creates a UDS listener, pre-loads the snapshot file into
 // the page cache, and spawns a goroutine that handles UFFD population.
 // The socket file exists after this returns (satisfying SDK validation).
-func startUffdHandler(ctx context.Context, socketPath, memFilePath string, stderr io.Writer) (*uffdHandler, error) {
+func startUffdHandler(ctx context.Context, socketPath, memFilePath string, vcpus int, eagerMB *int, stderr io.Writer) (*uffdHandler, error) {
 	// Remove stale socket if present
 	os.Remove(socketPath)
 
@@ -165,8 +255,13 @@ func startUffdHandler(ctx context.Context, socketPath, memFilePath string, stder
 		uffdFd:     -1,
 		done:       make(chan error, 1),
 		cancel:     cancel,
+
+		faultWorkers: max(4, 2*vcpus),
+		eagerMB:      eagerMB,
 	}
 
+	h.memInfo, _ = os.Stat(memFilePath)
+
 	// Pre-load: open file, mmap (no MAP_POPULATE), trigger async readahead.
 	// This overlaps disk I/O with Firecracker's startup (~150ms), so the file
 	// is already partially or fully in the page cache when UFFDIO_COPY starts.
@@ -184,6 +279,10 @@ func startUffdHandler(ctx context.Context, socketPath, memFilePath string, stder
 // data extents, and starts warming the page cache — all before Firecracker
 // connects. This overlaps ~150ms of I/O with Firecracker's launch time.
 func (h *uffdHandler) preload() error {
+	if strings.HasSuffix(h.memFile, ".zst") {
+		return h.preloadCompressed()
+	}
+
 	f, err := os.Open(h.memFile)
 	if err != nil {
 		return fmt.Errorf("opening: %w", err)
@@ -253,6 +352,44 @@ func (h *uffdHandler) preload() error {
 	return nil
 }
 
+// preloadCompressed opens a compressed snapshot memory file, takes the data
+// extents from its index, and reads the compressed file into the page cache.
+func (h *uffdHandler) preloadCompressed() error {
+	c, err := OpenCompressedMem(h.memFile)
+	if err != nil {
+		return err
+	}
+	h.compressed = c
+	h.fileSize = c.Size()
+	h.preExtents = c.dataExtents()
+	h.preSparse = true
+
+	h.preWarm = make(chan struct{})
+	go func() {
+		defer close(h.preWarm)
+		warmSnapshotPageCache(h.memFile)
+	}()
+	return nil
+}
+
+// source returns the address to UFFDIO_COPY length bytes of guest memory
+// at snapshot offset off from: the mmap, or for a compressed snapshot buf,
+// into which the bytes are decompressed. buf is grown as needed and must
+// stay alive until the copy is done.
+func (h *uffdHandler) source(off, length uint64, buf *[]byte) (uint64, error) {
+	if h.compressed == nil {
+		return uint64(h.mmapBase) + off, nil
+	}
+	if uint64(cap(*buf)) < length {
+		*buf = make([]byte, length)
+	}
+	b := (*buf)[:length]
+	if _, err := h.compressed.ReadAt(b, int64(off)); err != nil {
+		return 0, err
+	}
+	return uint64(uintptr(unsafe.Pointer(&b[0]))), nil
+}
+
 // Wait blocks until eager data population completes or the context is cancelled.
 func (h *uffdHandler) Wait(ctx context.Context) error {
 	select {
@@ -266,6 +403,7 @@ func (h *uffdHandler) Wait(ctx context.Context) error {
 // Close cleans up the UFFD handler: closes the fd, munmaps, and removes the socket.
 func (h *uffdHandler) Close() error {
 	h.cancel()
+	h.saveHotChunks()
 	if h.uffdFd >= 0 {
 		unix.Close(h.uffdFd)
 		h.uffdFd = -1
@@ -278,6 +416,10 @@ func (h *uffdHandler) Close() error {
 		h.file.Close()
 		h.file = nil
 	}
+	if h.compressed != nil {
+		h.compressed.Close()
+		h.compressed = nil
+	}
 	h.listener.Close()
 	os.Remove(h.socketPath)
 	return nil
@@ -307,6 +449,16 @@ func (h *uffdHandler) doPopulate(ctx context.Context, stderr io.Writer) error {
 		return fmt.Errorf("Firecracker sent 0 memory regions")
 	}
 
+	// Huge pages can only be copied whole, which the lazy chunks are.
+	for _, region := range regions {
+		if ps := region.pageSize(); ps > hostPageSize {
+			if lazyChunkSize%ps != 0 {
+				return fmt.Errorf("memory region page size %d does not divide the %d byte chunks", ps, lazyChunkSize)
+			}
+			h.hugePages.Store(true)
+		}
+	}
+
 	// Use pre-scanned extents from preload (already computed during FC launch).
 	// Map the whole-file extents to per-region extents by clipping.
 	var regionInfos []regionInfo
@@ -326,9 +478,17 @@ func (h *uffdHandler) doPopulate(ctx context.Context, stderr io.Writer) error {
 	}
 
 	// Eager mode: pre-copy all data pages before VM resume (old behavior).
-	if os.Getenv("DH_VM_EAGER_UFFD") == "1" {
+	// Its copies follow the data extents and it serves holes with
+	// UFFDIO_ZEROPAGE, neither of which works on huge pages.
+	if os.Getenv("DH_VM_EAGER_UFFD") == "1" && !h.hugePages.Load() {
 		<-h.preWarm
 
+		// Compressed snapshots are decompressed per job, so keep jobs small.
+		chunkSize := uint64(copyChunkSize)
+		if h.compressed != nil {
+			chunkSize = lazyChunkSize
+		}
+
 		var jobs []copyJob
 		for _, ri := range regionInfos {
 			base := ri.region.BaseHostVirtAddr
@@ -338,22 +498,22 @@ func (h *uffdHandler) doPopulate(ctx context.Context, stderr io.Writer) error {
 				if extEnd > ri.region.Offset+ri.region.Size {
 					extEnd = ri.region.Offset + ri.region.Size
 				}
-				for off := ext.offset; off < extEnd; off += copyChunkSize {
-					chunkLen := uint64(copyChunkSize)
+				for off := ext.offset; off < extEnd; off += chunkSize {
+					chunkLen := chunkSize
 					if remaining := extEnd - off; remaining < chunkLen {
 						chunkLen = remaining
 					}
 					jobs = append(jobs, copyJob{
 						uffdFd: uffdFd,
 						dst:    base + (off - regionStart),
-						src:    uint64(h.mmapBase) + off,
+						off:    off,
 						length: chunkLen,
 					})
 				}
 			}
 		}
 
-		if err := parallelCopy(jobs, copyWorkers); err != nil {
+		if err := h.timedCopy(jobs); err != nil {
 			return fmt.Errorf("parallel UFFDIO_COPY: %w", err)
 		}
 
@@ -366,14 +526,26 @@ func (h *uffdHandler) doPopulate(ctx context.Context, stderr io.Writer) error {
 	// Hybrid mode (default): pre-copy the first N MB of data extents to avoid
 	// cold-start page faults on the critical path (Python interpreter, JVM code
 	// cache, kernel), then serve remaining faults lazily with 2MB chunks.
-	eagerPreloadBytes := uint64(256 * 1024 * 1024) // default 256MB
-	if v := os.Getenv("DH_VM_EAGER_MB"); v != "" {
-		if mb, err := strconv.ParseUint(v, 10, 64); err == nil {
-			eagerPreloadBytes = mb * 1024 * 1024
-		}
+	eagerPreloadBytes := uint64(DefaultEagerMB) << 20
+	if h.eagerMB != nil {
+		eagerPreloadBytes = uint64(*h.eagerMB) << 20
 	}
 
 	h.populatedChunks = make(map[uint64]struct{})
+	h.hotChunks = make(map[uint64]struct{})
+
+	// Once restores of this snapshot have recorded the chunks they faulted
+	// in, copy exactly those instead, unless EagerMB is set.
+	if h.eagerMB == nil {
+		if jobs := h.hotChunkJobs(uffdFd, regionInfos); len(jobs) > 0 {
+			<-h.preWarm
+			h.learned.Store(true)
+			if err := h.timedCopy(jobs); err != nil {
+				return fmt.Errorf("hot chunk UFFDIO_COPY: %w", err)
+			}
+			eagerPreloadBytes = 0
+		}
+	}
 
 	if eagerPreloadBytes > 0 {
 		// Wait for page cache warming before UFFDIO_COPY
@@ -418,7 +590,7 @@ func (h *uffdHandler) doPopulate(ctx context.Context, stderr io.Writer) error {
 					eagerJobs = append(eagerJobs, copyJob{
 						uffdFd: uffdFd,
 						dst:    base + chunkOff,
-						src:    uint64(h.mmapBase) + regionOff + chunkOff,
+						off:    regionOff + chunkOff,
 						length: chunkEnd - chunkOff,
 					})
 					eagerTotal += chunkEnd - chunkOff
@@ -427,7 +599,7 @@ func (h *uffdHandler) doPopulate(ctx context.Context, stderr io.Writer) error {
 		}
 
 		if len(eagerJobs) > 0 {
-			if err := parallelCopy(eagerJobs, copyWorkers); err != nil {
+			if err := h.timedCopy(eagerJobs); err != nil {
 				return fmt.Errorf("hybrid eager UFFDIO_COPY: %w", err)
 			}
 		}
@@ -438,6 +610,54 @@ func (h *uffdHandler) doPopulate(ctx context.Context, stderr io.Writer) error {
 	return nil
 }
 
+// hotChunkJobs returns copy jobs for the hot chunks recorded for the
+// snapshot, marking them populated.
+func (h *uffdHandler) hotChunkJobs(uffdFd int, regions []regionInfo) []copyJob {
+	var jobs []copyJob
+	for _, off := range readHotChunks(filepath.Dir(h.memFile), lazyChunkSize) {
+		for _, ri := range regions {
+			if off < ri.region.Offset || off >= ri.region.Offset+ri.region.Size {
+				continue
+			}
+			chunkOff := off - ri.region.Offset
+			chunkKey := ri.region.BaseHostVirtAddr + chunkOff
+			if chunkOff%lazyChunkSize != 0 {
+				break // not a chunk of this region layout
+			}
+			if _, ok := h.populatedChunks[chunkKey]; ok {
+				break
+			}
+			h.populatedChunks[chunkKey] = struct{}{}
+			jobs = append(jobs, copyJob{
+				uffdFd: uffdFd,
+				dst:    chunkKey,
+				off:    off,
+				length: min(lazyChunkSize, ri.region.Size-chunkOff),
+			})
+			break
+		}
+	}
+	return jobs
+}
+
+// saveHotChunks adds the chunks with snapshot data that this VM faulted
+// in to the snapshot's hot set.
+func (h *uffdHandler) saveHotChunks() {
+	h.lazyMu.Lock()
+	offsets := slices.Collect(maps.Keys(h.hotChunks))
+	h.hotChunks = nil
+	h.lazyMu.Unlock()
+	if len(offsets) == 0 {
+		return
+	}
+	if fi, err := os.Stat(h.memFile); err != nil || h.memInfo == nil || !os.SameFile(fi, h.memInfo) {
+		return // the snapshot was taken again or deleted
+	}
+	if err := addHotChunks(filepath.Dir(h.memFile), lazyChunkSize, offsets); err != nil {
+		output.Tracef("uffd: saving hot chunks: %v", err)
+	}
+}
+
 // clipExtentsToRegion returns the subset of whole-file data extents that
 // overlap with a given region [regionOffset, regionOffset+regionSize).
 func clipExtentsToRegion(allExtents []dataExtent, regionOffset, regionSize uint64) []dataExtent {
@@ -463,8 +683,21 @@ func clipExtentsToRegion(allExtents []dataExtent, regionOffset, regionSize uint6
 	return result
 }
 
+// timedCopy copies jobs before the VM resumes, counting them as eager.
+func (h *uffdHandler) timedCopy(jobs []copyJob) error {
+	start := time.Now()
+	err := h.parallelCopy(jobs, copyWorkers)
+	h.eagerNanos.Add(int64(time.Since(start)))
+	if err == nil {
+		for _, j := range jobs {
+			h.eagerBytes.Add(j.length)
+		}
+	}
+	return err
+}
+
 // parallelCopy distributes UFFDIO_COPY jobs across n worker goroutines.
-func parallelCopy(jobs []copyJob, workers int) error {
+func (h *uffdHandler) parallelCopy(jobs []copyJob, workers int) error {
 	if len(jobs) == 0 {
 		return nil
 	}
@@ -485,10 +718,16 @@ func parallelCopy(jobs []copyJob, workers int) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			var buf []byte
 			for job := range jobCh {
+				src, err := h.source(job.off, job.length, &buf)
+				if err != nil {
+					errCh <- err
+					return
+				}
 				cp := ufffdioCopy{
 					dst:  job.dst,
-					src:  job.src,
+					src:  src,
 					len:  job.length,
 					mode: 0,
 				}
@@ -555,6 +794,7 @@ func (h *uffdHandler) lazyFaultHandler(ctx context.Context, uffdFd int, regions
 			}
 			return
 		}
+		read := time.Now()
 
 		numMsgs := nr / uffdMsgSize
 		for i := 0; i < numMsgs; i++ {
@@ -564,19 +804,24 @@ func (h *uffdHandler) lazyFaultHandler(ctx context.Context, uffdFd int, regions
 			switch event {
 			case _UFFD_EVENT_PAGEFAULT:
 				faultAddr := *(*uint64)(unsafe.Pointer(&msg[16]))
-				pageAddr := faultAddr & ^uint64(4095)
+				pageAddr := faultAddr &^ (hostPageSize - 1)
+				output.Tracef("uffd: fault at %#x, zero page %#x", faultAddr, pageAddr)
 
 				zp := uffdioZeropage{
 					start: pageAddr,
-					len:   4096,
+					len:   hostPageSize,
 					mode:  0,
 				}
-				unix.Syscall(
+				_, _, errno := unix.Syscall(
 					unix.SYS_IOCTL,
 					uintptr(uffdFd),
 					uintptr(_UFFDIO_ZEROPAGE),
 					uintptr(unsafe.Pointer(&zp)),
 				)
+				if errno == 0 {
+					h.lazyBytes.Add(hostPageSize)
+				}
+				h.recordFault(read)
 
 			case _UFFD_EVENT_REMOVE:
 				// Balloon deflation — no action needed
@@ -590,7 +835,7 @@ func (h *uffdHandler) lazyFaultHandler(ctx context.Context, uffdFd int, regions
 // this way — the mmap reads zeros for hole regions, so UFFDIO_COPY produces
 // the same result as UFFDIO_ZEROPAGE but with a unified code path.
 //
-// Faults are dispatched to a pool of 4 worker goroutines so that multiple
+// Faults are dispatched to a pool of faultWorkers goroutines so that multiple
 // vCPUs can have their faults served in parallel (the populatedChunks mutex
 // already provides thread safety).
 func (h *uffdHandler) lazyFaultHandlerV2(ctx context.Context, uffdFd int, regions []regionInfo) {
@@ -598,15 +843,17 @@ func (h *uffdHandler) lazyFaultHandlerV2(ctx context.Context, uffdFd int, region
 	var buf [uffdMsgSize * maxBatch]byte
 
 	// Dispatch faults to a worker pool for parallel handling.
-	// 4 workers gives headroom beyond DefaultVCPUCount=2.
-	faultCh := make(chan uint64, 64)
+	// Two workers per vCPU gives headroom while one waits on the disk.
+	faultCh := make(chan pendingFault, 64)
 	var wg sync.WaitGroup
-	for w := 0; w < 4; w++ {
+	for w := 0; w < h.faultWorkers; w++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for faultAddr := range faultCh {
-				h.handleLazyFault(uffdFd, faultAddr, regions)
+			var buf []byte
+			for f := range faultCh {
+				h.lazyBytes.Add(h.handleLazyFault(uffdFd, f.addr, regions, &buf))
+				h.recordFault(f.read)
 			}
 		}()
 	}
@@ -644,6 +891,7 @@ func (h *uffdHandler) lazyFaultHandlerV2(ctx context.Context, uffdFd int, region
 			}
 			return
 		}
+		read := time.Now()
 
 		numMsgs := nr / uffdMsgSize
 		for i := 0; i < numMsgs; i++ {
@@ -653,7 +901,7 @@ func (h *uffdHandler) lazyFaultHandlerV2(ctx context.Context, uffdFd int, region
 			switch event {
 			case _UFFD_EVENT_PAGEFAULT:
 				faultAddr := *(*uint64)(unsafe.Pointer(&msg[16]))
-				faultCh <- faultAddr
+				faultCh <- pendingFault{addr: faultAddr, read: read}
 
 			case _UFFD_EVENT_REMOVE:
 				// Balloon deflation — no action needed
@@ -663,8 +911,10 @@ func (h *uffdHandler) lazyFaultHandlerV2(ctx context.Context, uffdFd int, region
 }
 
 // handleLazyFault resolves a single page fault by UFFDIO_COPY'ing a 2MB-aligned
-// chunk from the pre-cached mmap into the VM's address space.
-func (h *uffdHandler) handleLazyFault(uffdFd int, faultAddr uint64, regions []regionInfo) {
+// chunk from the pre-cached mmap (or decompressed into buf) into the VM's
+// address space. It returns the bytes copied: none when another fault
+// already populated the chunk.
+func (h *uffdHandler) handleLazyFault(uffdFd int, faultAddr uint64, regions []regionInfo, buf *[]byte) uint64 {
 	// Find which region contains the fault address
 	for _, ri := range regions {
 		base := ri.region.BaseHostVirtAddr
@@ -687,7 +937,7 @@ func (h *uffdHandler) handleLazyFault(uffdFd int, faultAddr uint64, regions []re
 		h.lazyMu.Lock()
 		if _, ok := h.populatedChunks[chunkKey]; ok {
 			h.lazyMu.Unlock()
-			return
+			return 0
 		}
 		h.populatedChunks[chunkKey] = struct{}{}
 		h.lazyMu.Unlock()
@@ -695,9 +945,18 @@ func (h *uffdHandler) handleLazyFault(uffdFd int, faultAddr uint64, regions []re
 		// UFFDIO_COPY from mmap — works for both data and holes since the
 		// mmap reads zeros for sparse hole regions.
 		fileOffset := ri.region.Offset + chunkStart
+		src, err := h.source(fileOffset, chunkLen, buf)
+		if err != nil {
+			// Leave the chunk unpopulated; the faulting thread retries.
+			output.Tracef("uffd: fault at %#x: %v", faultAddr, err)
+			h.lazyMu.Lock()
+			delete(h.populatedChunks, chunkKey)
+			h.lazyMu.Unlock()
+			return 0
+		}
 		cp := ufffdioCopy{
 			dst:  base + chunkStart,
-			src:  uint64(h.mmapBase) + fileOffset,
+			src:  src,
 			len:  chunkLen,
 			mode: 0,
 		}
@@ -707,20 +966,32 @@ func (h *uffdHandler) handleLazyFault(uffdFd int, faultAddr uint64, regions []re
 			uintptr(_UFFDIO_COPY),
 			uintptr(unsafe.Pointer(&cp)),
 		)
+		if output.IsTrace() {
+			output.Tracef("uffd: fault at %#x, copied %d KiB chunk at %#x (file offset %#x, errno %d)",
+				faultAddr, chunkLen/1024, base+chunkStart, fileOffset, errno)
+		}
 		if errno != 0 && errno != unix.EEXIST {
 			// EEXIST is benign (race with another fault in same range).
 			// Other errors: log but don't crash — faulting thread retries.
-			return
+			return 0
 		}
-		return
+		if extentsOverlap(ri.extents, fileOffset, chunkLen) {
+			h.lazyMu.Lock()
+			if h.hotChunks != nil {
+				h.hotChunks[fileOffset] = struct{}{}
+			}
+			h.lazyMu.Unlock()
+		}
+		return chunkLen
 	}
 
 	// Fault address not in any region — shouldn't happen. Unblock with a
 	// single zero page to prevent the VM from hanging.
-	pageAddr := faultAddr & ^uint64(4095)
+	pageAddr := faultAddr &^ (hostPageSize - 1)
+	output.Tracef("uffd: fault at %#x outside all regions, zero page %#x", faultAddr, pageAddr)
 	zp := uffdioZeropage{
 		start: pageAddr,
-		len:   4096,
+		len:   hostPageSize,
 		mode:  0,
 	}
 	unix.Syscall(
@@ -729,6 +1000,7 @@ func (h *uffdHandler) handleLazyFault(uffdFd int, faultAddr uint64, regions []re
 		uintptr(_UFFDIO_ZEROPAGE),
 		uintptr(unsafe.Pointer(&zp)),
 	)
+	return hostPageSize
 }
 
 // isInDataExtent checks if a file offset falls within any data extent.
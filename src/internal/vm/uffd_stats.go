@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"io"
+	"slices"
+	"time"
+)
+
+// maxFaultSamples bounds the fault service times a UFFD handler keeps for
+// its percentiles. A VM rarely faults in more 2 MiB chunks than this.
+const maxFaultSamples = 1 << 16
+
+// UffdStats is how a UFFD handler populated a restored VM's memory, to
+// tune VMConfig.EagerMB: faults served lazily cost the VM a wait each,
+// eager copies cost restore time whether the VM needs the pages or not.
+type UffdStats struct {
+	Faults     uint64  `json:"faults"`       // page faults served
+	EagerBytes uint64  `json:"eager_bytes"`  // copied before the VM resumed
+	LazyBytes  uint64  `json:"lazy_bytes"`   // copied when the VM faulted
+	EagerMs    float64 `json:"eager_ms"`     // time spent on the eager copy
+	Learned    bool    `json:"learned"`      // the eager copy was the snapshot's hot chunks
+	HugePages  bool    `json:"huge_pages"`   // guest memory is in 2 MiB huge pages
+	FaultP50Us float64 `json:"fault_p50_us"` // median fault service time
+	FaultP99Us float64 `json:"fault_p99_us"` // 99th percentile fault service time
+}
+
+// UffdStatsOf returns the stats of the UFFD handler RestoreFromSnapshot
+// returned, or nil when the VM wasn't restored with UFFD.
+func UffdStatsOf(c io.Closer) *UffdStats {
+	if s, ok := c.(interface{ stats() UffdStats }); ok {
+		st := s.stats()
+		return &st
+	}
+	return nil
+}
+
+// faultPercentiles returns the median and 99th percentile of the fault
+// service times, in microseconds.
+func faultPercentiles(samples []time.Duration) (p50, p99 float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	at := func(p float64) float64 {
+		i := int(p * float64(len(sorted)-1))
+		return float64(sorted[i].Nanoseconds()) / 1e3
+	}
+	return at(0.50), at(0.99)
+}
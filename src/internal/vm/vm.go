@@ -3,7 +3,10 @@
 package vm
 
 import (
+	"encoding/base64"
+	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -20,11 +23,25 @@ const (
 	// DefaultVCPUCount is the default number of vCPUs.
 	DefaultVCPUCount = 2
 
+	// MinMemSizeMiB and MaxVCPUCount bound the machine size of a snapshot.
+	// Firecracker allows at most 32 vCPUs.
+	MinMemSizeMiB = 1024
+	MaxVCPUCount  = 32
+
+	// balloonHeadroomMiB is the guest memory left outside the balloon when
+	// it is inflated before snapshotting, and outside the JVM heap: room
+	// for the kernel, JVM overhead and Python.
+	balloonHeadroomMiB = 512
+
 	// FileServerPort is the vsock port for the host file server.
 	// The guest LD_PRELOAD library connects to CID=2:FileServerPort to fetch
 	// workspace files on demand.
 	FileServerPort = 10001
 
+	// DefaultEagerMB is how many MiB of snapshot data UFFD copies before
+	// a restored VM resumes, until the snapshot has learned hot chunks.
+	DefaultEagerMB = 256
+
 	// FirecrackerVersion is the version of Firecracker to download.
 	FirecrackerVersion = "v1.12.0"
 )
@@ -33,29 +50,133 @@ const (
 type VMConfig struct {
 	DHHome string // ~/.dh
 	Version string // Deephaven version
+	Profile string // snapshot profile, "" for the version's own snapshot
 	Verbose bool
 	UseUffd bool // use UFFD eager page population for snapshot restore
 
+	// EagerMB is how many MiB of snapshot data UFFD copies into a
+	// restored VM before it resumes. nil copies the snapshot's learned hot
+	// chunks, or DefaultEagerMB before there are any.
+	EagerMB *int
+
 	// VsockUDSPath overrides the default vsock UDS path derived from the
 	// snapshot directory. Pool VMs set this to an instance-specific path so
 	// multiple VMs from the same snapshot don't collide on the socket.
 	VsockUDSPath string
 
-	// ReadOnlyDisk mounts the root disk as read-only. Pool VMs use this
-	// because /tmp is a tmpfs (added to the init script), so nothing needs
-	// to write to the ext4 disk. This allows concurrent VMs to share the
-	// same disk image safely.
-	ReadOnlyDisk bool
+	// Compress stores the snapshot memory compressed (snapshot_mem.zst)
+	// when preparing. Restoring it then requires UFFD.
+	Compress bool
+
+	// VCPUs and MemSizeMiB size the VM when preparing; 0 means
+	// DefaultVCPUCount and DefaultMemSizeMiB. They are recorded in the
+	// snapshot metadata, and a restored VM always has the size of its
+	// snapshot.
+	VCPUs      int
+	MemSizeMiB int
+
+	// GuestJVMArgs are JVM arguments for the Deephaven server, after the
+	// defaults, when preparing. They are recorded in the snapshot metadata.
+	GuestJVMArgs string
+
+	// Jailed runs Firecracker under the jailer: chrooted, in its own
+	// cgroup, as DH_VM_JAIL_UID, with seccomp filters. It needs root, and a
+	// snapshot prepared jailed can only be restored jailed (and vice versa).
+	Jailed bool
+
+	// HugePages backs the guest memory with 2 MiB huge pages when
+	// preparing. The snapshot then restores only with UFFD, and every VM
+	// restored from it takes its memory from the host's huge page pool.
+	HugePages bool
+
+	// Net is the VM's network: "" for none, or NetNAT for outbound access
+	// through NAT on the host, which needs root. A snapshot prepared with
+	// a network can only be restored with it (and vice versa).
+	Net string
+
+	// WritableRoot gives the guest the disk as its root, read-write, when
+	// preparing. Otherwise the disk is attached read-only and the guest's
+	// changes go to a tmpfs overlay, so restored VMs share the disk as is.
+	WritableRoot bool
+}
+
+// machineSize returns the vCPU count and memory of a VM to prepare.
+func (c *VMConfig) machineSize() (vcpus, memMiB int) {
+	vcpus, memMiB = c.VCPUs, c.MemSizeMiB
+	if vcpus == 0 {
+		vcpus = DefaultVCPUCount
+	}
+	if memMiB == 0 {
+		memMiB = DefaultMemSizeMiB
+	}
+	return vcpus, memMiB
+}
+
+// CheckMachineSize validates --vcpus and --memory for dh vm prepare.
+func CheckMachineSize(vcpus, memMiB int) error {
+	if vcpus < 1 || vcpus > MaxVCPUCount {
+		return fmt.Errorf("vCPU count must be between 1 and %d, got %d", MaxVCPUCount, vcpus)
+	}
+	if memMiB < MinMemSizeMiB {
+		return fmt.Errorf("VM memory must be at least %d MiB, got %d", MinMemSizeMiB, memMiB)
+	}
+	return nil
+}
+
+// balloonTargetMiB is the balloon size that reclaims all but
+// balloonHeadroomMiB of a VM's memory.
+func balloonTargetMiB(memMiB int) int {
+	return memMiB - balloonHeadroomMiB
+}
+
+// maxGuestJVMArgs bounds --guest-jvm-args, which go on the kernel command
+// line (2048 bytes on x86_64 and arm64) base64-encoded.
+const maxGuestJVMArgs = 1024
+
+// ValidateGuestJVMArgs checks JVM arguments for the Deephaven server in
+// the VM: whitespace-separated options that start with -.
+func ValidateGuestJVMArgs(args string) error {
+	if len(args) > maxGuestJVMArgs {
+		return fmt.Errorf("guest JVM arguments are longer than %d bytes", maxGuestJVMArgs)
+	}
+	for _, a := range strings.Fields(args) {
+		if !strings.HasPrefix(a, "-") {
+			return fmt.Errorf("invalid guest JVM argument %s: not a JVM option (options start with -)", a)
+		}
+	}
+	return nil
+}
+
+// guestJVMArgsParam returns the kernel parameter that passes JVM arguments
+// to init.sh, or "" for none.
+func guestJVMArgsParam(args string) string {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return ""
+	}
+	return " dh_jvm_args=" + base64.StdEncoding.EncodeToString([]byte(strings.Join(fields, " ")))
+}
+
+// heapSizeMiB is the JVM heap limit for a VM with memMiB of memory: 4 GiB
+// at the default size.
+func heapSizeMiB(memMiB int) int {
+	return memMiB - balloonHeadroomMiB
 }
 
 // VMPaths returns canonical paths for VM artifacts.
 type VMPaths struct {
 	Base        string // ~/.dh/vm
 	Firecracker string // ~/.dh/vm/firecracker
+	Jailer      string // ~/.dh/vm/jailer
 	Kernel      string // ~/.dh/vm/vmlinux
+	Toolchain   string // ~/.dh/vm/toolchain.json, cached Firecracker and kernel fingerprints
 	RootfsDir   string // ~/.dh/vm/rootfs
 	SnapshotDir string // ~/.dh/vm/snapshots
 	RunDir      string // ~/.dh/vm/run
+	LogDir      string // ~/.dh/vm/logs, console logs of finished instances
+	JailDir     string // ~/.dh/vm/jail, chroot base of jailed VMs
+	NamedDir    string // ~/.dh/vm/named, sockets and logs of the VMs of `dh vm up`
+	BundlesDir  string // ~/.dh/bundles, unpacked by `dh bundle install`
 }
 
 // NewVMPaths creates VMPaths for a given DHG home directory.
@@ -64,23 +185,47 @@ func NewVMPaths(dhHome string) *VMPaths {
 	return &VMPaths{
 		Base:        base,
 		Firecracker: filepath.Join(base, "firecracker"),
+		Jailer:      filepath.Join(base, "jailer"),
 		Kernel:      filepath.Join(base, "vmlinux"),
+		Toolchain:   filepath.Join(base, "toolchain.json"),
 		RootfsDir:   filepath.Join(base, "rootfs"),
 		SnapshotDir: filepath.Join(base, "snapshots"),
 		RunDir:      filepath.Join(base, "run"),
+		LogDir:      filepath.Join(base, "logs"),
+		JailDir:     filepath.Join(base, "jail"),
+		NamedDir:    filepath.Join(base, "named"),
+		BundlesDir:  filepath.Join(dhHome, "bundles"),
+	}
+}
+
+// BundledFile returns the path of name (e.g. "firecracker") in the vm/
+// directory of an installed offline bundle, or "" if no bundle has it.
+func (p *VMPaths) BundledFile(name string) string {
+	matches, _ := filepath.Glob(filepath.Join(p.BundlesDir, "*", "vm", name))
+	if len(matches) == 0 {
+		return ""
 	}
+	return matches[len(matches)-1]
 }
 
-// RootfsForVersion returns the path to the ext4 rootfs for a version.
+// RootfsForVersion returns the path to the ext4 rootfs for a version or
+// SnapshotKey.
 func (p *VMPaths) RootfsForVersion(version string) string {
-	return filepath.Join(p.RootfsDir, "deephaven-"+version+".ext4")
+	return filepath.Join(p.RootfsDir, rootfsName(version)+".ext4")
 }
 
-// SnapshotDirForVersion returns the snapshot directory for a version.
+// SnapshotDirForVersion returns the snapshot directory for a version or
+// SnapshotKey. A profile's directory is inside its version's.
 func (p *VMPaths) SnapshotDirForVersion(version string) string {
 	return filepath.Join(p.SnapshotDir, version)
 }
 
+// snapshotLockFile returns the lock file of the snapshot for a version or
+// SnapshotKey. Lock files are hidden from ListSnapshots.
+func (p *VMPaths) snapshotLockFile(key string) string {
+	return filepath.Join(p.SnapshotDir, ".locks", key+".lock")
+}
+
 // InstanceDir returns the run directory for a specific instance.
 func (p *VMPaths) InstanceDir(instanceID string) string {
 	return filepath.Join(p.RunDir, instanceID)
@@ -89,10 +234,65 @@ func (p *VMPaths) InstanceDir(instanceID string) string {
 // SnapshotMetadata is persisted alongside each snapshot.
 type SnapshotMetadata struct {
 	Version    string    `json:"version"`
+	Profile    string    `json:"profile,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 	DHPort     int       `json:"dh_port"`
 	MemSizeMiB int       `json:"mem_size_mib,omitempty"` // VM memory at snapshot time
+	VCPUCount  int       `json:"vcpu_count,omitempty"`   // vCPUs at snapshot time
 	BalloonMiB int       `json:"balloon_mib,omitempty"`  // balloon inflation at snapshot time
+
+	// Toolchain fingerprints, checked by CheckSnapshot and
+	// VerifySnapshot. Empty for snapshots taken before they were recorded.
+	Firecracker  string `json:"firecracker,omitempty"`   // release of the Firecracker binary
+	KernelSHA256 string `json:"kernel_sha256,omitempty"` // SHA-256 of vmlinux
+
+	Compressed bool   `json:"compressed,omitempty"` // memory is in snapshot_mem.zst
+	Jailed     bool   `json:"jailed,omitempty"`     // taken under the jailer; paths in it are inside the chroot
+	HugePages  bool   `json:"huge_pages,omitempty"` // guest memory in 2 MiB huge pages; restores need UFFD
+	Net        string `json:"net,omitempty"`        // --net mode; restores need the same
+
+	// VsockRelative is set when the vsock socket path in the snapshot is
+	// relative to Firecracker's working directory, so concurrent restores
+	// each bind their own. Older snapshots have one in the snapshot directory.
+	VsockRelative bool `json:"vsock_relative,omitempty"`
+
+	// How the snapshot was built, from its rootfs manifest and the
+	// prepare options. Empty when not recorded.
+	BaseImage    string `json:"base_image,omitempty"`     // FROM image of the rootfs
+	Python       string `json:"python,omitempty"`         // Python version in the rootfs
+	GuestJVMArgs string `json:"guest_jvm_args,omitempty"` // --guest-jvm-args
+
+	// ReadOnlyRoot is set when the guest runs on an overlay of the disk,
+	// which is then always attached read-only. Snapshots taken with
+	// --writable-root, or before this existed, mount the disk read-write.
+	ReadOnlyRoot bool `json:"read_only_root,omitempty"`
+
+	// Generation is a random ID given to each snapshot taken, so VMs
+	// restored from one that has since been replaced can be told apart.
+	Generation string `json:"generation,omitempty"`
+}
+
+// GenerationID returns the snapshot's generation, or for snapshots taken
+// before it was recorded, their creation time, which serves the same.
+func (m *SnapshotMetadata) GenerationID() string {
+	if m.Generation != "" || m.CreatedAt.IsZero() {
+		return m.Generation
+	}
+	return m.CreatedAt.UTC().Format(time.RFC3339Nano)
+}
+
+// MachineSize returns the vCPU count and memory the snapshot was taken
+// with, which a restored VM must match. Snapshots from before they were
+// recorded have the defaults.
+func (m *SnapshotMetadata) MachineSize() (vcpus, memMiB int) {
+	vcpus, memMiB = m.VCPUCount, m.MemSizeMiB
+	if vcpus == 0 {
+		vcpus = DefaultVCPUCount
+	}
+	if memMiB == 0 {
+		memMiB = DefaultMemSizeMiB
+	}
+	return vcpus, memMiB
 }
 
 // InstanceInfo tracks a running VM instance.
@@ -101,4 +301,15 @@ type InstanceInfo struct {
 	PID       int    `json:"pid"`
 	Version   string `json:"version"`
 	VsockPath string `json:"vsock_path"` // Path to the vsock UDS
+
+	// GuestVsockPath is the path guest connections to host port N go to
+	// as GuestVsockPath_N, where StartFileServer listens.
+	GuestVsockPath string `json:"guest_vsock_path"`
+
+	// NetIndex is the VM network of a --net=nat VM, 0 for none.
+	NetIndex int `json:"net_index,omitempty"`
+
+	// Generation is the GenerationID of the snapshot the VM was restored
+	// from.
+	Generation string `json:"generation,omitempty"`
 }
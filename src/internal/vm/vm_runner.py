@@ -7,13 +7,64 @@ This daemon + its warm Session are captured in the VM snapshot.
 import ast
 import json
 import os
+import select
 import socket
+import subprocess
 import sys
+import threading
 import traceback
 
 VMADDR_CID_ANY = 0xFFFFFFFF
 VSOCK_PORT = 10000
 
+# The runner's answer to a hello from the host: the version of the
+# host-runner protocol it speaks, and the optional request fields it
+# honours. Must match VsockProtocol and the Feature constants in
+# machine_linux.go. Runners from before the hello treat it as a request
+# with no code.
+PROTOCOL_VERSION = 1
+FEATURES = ["stream", "cancel", "collect_outputs", "fuse", "table_format", "shell", "import_tables", "export_tables", "table_output"]
+
+
+def features():
+    """FEATURES, plus read_only_root when init.sh put the guest on an
+    overlay of the read-only disk."""
+    try:
+        with open("/proc/mounts") as f:
+            for line in f:
+                fields = line.split()
+                if len(fields) > 2 and fields[1] == "/" and fields[2] == "overlay":
+                    return FEATURES + ["read_only_root"]
+    except OSError:
+        pass
+    return FEATURES
+
+# The wrapper appends ["stdout"|"stderr", text] lines here as the script
+# writes, for streamed requests; the runner forwards them to the host.
+STREAM_FILE = "/tmp/__dh_stream.ndjson"
+
+# The runner creates this when the host sends a cancel frame; a watcher
+# thread in the wrapper then raises KeyboardInterrupt in the script.
+CANCEL_FILE = "/tmp/__dh_cancel"
+
+# The FUSE daemon for requests with mount_mode "fuse", and its log.
+WORKSPACE_FUSE = "/opt/workspace_fuse.py"
+WORKSPACE_FUSE_LOG = "/tmp/workspace_fuse.log"
+
+# Scripts save files for the host here when the request has
+# collect_outputs (dh exec --collect-outputs); must match GuestOutputDir in
+# outputs.go. The runner sends them back after the script finishes, in
+# chunks of OUTPUT_CHUNK bytes.
+OUTPUT_DIR = "/tmp/outputs"
+OUTPUT_CHUNK = 512 * 1024
+
+
+def log(msg):
+    """Write a line to the serial console, where the host keeps it in the
+    instance's log; dh vm logs --runner shows only these lines."""
+    for line in str(msg).splitlines():
+        print(f"RUNNER: {line}", file=sys.stderr, flush=True)
+
 
 # --- AST helpers ---
 
@@ -50,16 +101,37 @@ def _extract_names(target):
 
 # --- Wrapper script builder ---
 
-def build_wrapper(code):
-    """Build the wrapper script that captures output and writes result to file."""
+def build_wrapper(code, python_path=None, stream=False, fuse=False, outputs=False):
+    """Build the wrapper script that captures output and writes result to file.
+
+    With stream, output is also appended to STREAM_FILE as it is written.
+    With fuse, /workspace is a FUSE mount and the LD_PRELOAD interceptor is
+    turned off, in the server process and anything it starts.
+    With outputs, DH_OUTPUT_DIR tells the script where to save files for
+    the host.
+    """
     code_repr = repr(code)
     lines = []
 
+    # Prepend --pythonpath dirs (already mapped to guest /workspace paths)
+    # so helper packages on the host are importable by user code.
+    if python_path:
+        lines.append("import sys as __dh_sys")
+        lines.append(f"for __dh_p in reversed({python_path!r}):")
+        lines.append("    if __dh_p not in __dh_sys.path:")
+        lines.append("        __dh_sys.path.insert(0, __dh_p)")
+        lines.append("del __dh_sys, __dh_p")
+        lines.append("")
+
     # Set CWD to /workspace so relative paths in user code resolve to
     # /workspace/* which triggers the LD_PRELOAD interceptor to fetch
     # files from the host transparently. This runs inside the Deephaven
     # server process (not the runner), which is where file I/O happens.
     lines.append("import os as __dh_os")
+    if fuse:
+        lines.append("__dh_os.environ['DH_WORKSPACE_FUSE'] = '1'")
+    if outputs:
+        lines.append(f"__dh_os.environ['DH_OUTPUT_DIR'] = {OUTPUT_DIR!r}")
     lines.append("try:")
     lines.append("    __dh_os.chdir('/workspace')")
     lines.append("except OSError:")
@@ -69,15 +141,66 @@ def build_wrapper(code):
     lines.append("import io as __dh_io")
     lines.append("import sys as __dh_sys")
     lines.append("import json as __dh_json")
+    lines.append("import warnings as __dh_warnings")
     lines.append("")
     lines.append("__dh_stdout_buf = __dh_io.StringIO()")
     lines.append("__dh_stderr_buf = __dh_io.StringIO()")
     lines.append("__dh_orig_stdout = __dh_sys.stdout")
     lines.append("__dh_orig_stderr = __dh_sys.stderr")
-    lines.append("__dh_sys.stdout = __dh_stdout_buf")
-    lines.append("__dh_sys.stderr = __dh_stderr_buf")
+    if stream:
+        # Names inside the class body avoid a __ prefix, which Python
+        # would mangle.
+        lines.append(f"__dh_stream_f = open({STREAM_FILE!r}, 'a', buffering=1)")
+        lines.append("class __DhStreamTee(__dh_io.TextIOBase):")
+        lines.append("    def __init__(self, name, buf, out, dumps):")
+        lines.append("        self.name, self.buf, self.out, self.dumps = name, buf, out, dumps")
+        lines.append("    def writable(self):")
+        lines.append("        return True")
+        lines.append("    def write(self, s):")
+        lines.append("        self.buf.write(s)")
+        lines.append("        self.out.write(self.dumps([self.name, s]) + '\\n')")
+        lines.append("        return len(s)")
+        lines.append('__dh_sys.stdout = __DhStreamTee("stdout", __dh_stdout_buf, __dh_stream_f, __dh_json.dumps)')
+        lines.append('__dh_sys.stderr = __DhStreamTee("stderr", __dh_stderr_buf, __dh_stream_f, __dh_json.dumps)')
+    else:
+        lines.append("__dh_sys.stdout = __dh_stdout_buf")
+        lines.append("__dh_sys.stderr = __dh_stderr_buf")
     lines.append("__dh_result = None")
     lines.append("__dh_error = None")
+    lines.append("__dh_interrupted = False")
+    lines.append("")
+    # Raise KeyboardInterrupt in this thread once the runner creates
+    # CANCEL_FILE. Like Ctrl+C in Python, it lands at the next bytecode, so
+    # a long call into Java finishes first.
+    lines.append("import threading as __dh_threading")
+    lines.append("import ctypes as __dh_ctypes")
+    lines.append("import os.path as __dh_os_path")
+    lines.append("def __dh_watch_cancel(tid, done, exists, ctypes):")
+    lines.append("    while not done.wait(0.05):")
+    lines.append(f"        if exists({CANCEL_FILE!r}):")
+    lines.append("            ctypes.pythonapi.PyThreadState_SetAsyncExc(ctypes.c_ulong(tid), ctypes.py_object(KeyboardInterrupt))")
+    lines.append("            return")
+    lines.append("__dh_done = __dh_threading.Event()")
+    lines.append("__dh_watcher = __dh_threading.Thread(target=__dh_watch_cancel, daemon=True,")
+    lines.append("    args=(__dh_threading.get_ident(), __dh_done, __dh_os_path.exists, __dh_ctypes))")
+    lines.append("__dh_watcher.start()")
+    lines.append("__dh_warn_ctx = __dh_warnings.catch_warnings(record=True)")
+    lines.append("__dh_warn_list = __dh_warn_ctx.__enter__()")
+    lines.append('__dh_warnings.simplefilter("default")')
+    # Warnings the server logs while the code runs (Python logging records
+    # at WARNING and above, from Deephaven's modules or the code itself)
+    # are reported too, apart from the warnings module's by their source.
+    lines.append("import logging as __dh_logging")
+    lines.append("class __DhLogRecorder(__dh_logging.Handler):")
+    lines.append("    def emit(self, record):")
+    lines.append("        try:")
+    lines.append('            self.records.append({"source": "server_log", "category": record.name, "level": record.levelname, '
+                 '"message": record.getMessage(), "filename": record.pathname, "lineno": record.lineno})')
+    lines.append("        except Exception:")
+    lines.append("            self.handleError(record)")
+    lines.append("__dh_log_handler = __DhLogRecorder(__dh_logging.WARNING)")
+    lines.append("__dh_log_handler.records = []")
+    lines.append("__dh_logging.getLogger().addHandler(__dh_log_handler)")
     lines.append("")
     lines.append("try:")
     lines.append("    try:")
@@ -87,23 +210,40 @@ def build_wrapper(code):
     lines.append("except Exception as __dh_e:")
     lines.append("    import traceback as __dh_tb")
     lines.append("    __dh_error = __dh_tb.format_exc()")
+    lines.append("except KeyboardInterrupt:")
+    lines.append("    import traceback as __dh_tb")
+    lines.append("    __dh_error = __dh_tb.format_exc()")
+    lines.append("    __dh_interrupted = True")
     lines.append("finally:")
+    lines.append("    __dh_done.set()")
+    lines.append("    __dh_watcher.join()")
+    lines.append("    __dh_warn_ctx.__exit__(None, None, None)")
+    lines.append("    __dh_logging.getLogger().removeHandler(__dh_log_handler)")
     lines.append("    __dh_sys.stdout = __dh_orig_stdout")
     lines.append("    __dh_sys.stderr = __dh_orig_stderr")
+    if stream:
+        lines.append("    __dh_stream_f.close()")
+        lines.append("    del __dh_stream_f, __DhStreamTee")
     lines.append("")
     lines.append("__dh_results_dict = {")
     lines.append('    "stdout": __dh_stdout_buf.getvalue(),')
     lines.append('    "stderr": __dh_stderr_buf.getvalue(),')
     lines.append('    "result_repr": repr(__dh_result) if __dh_result is not None else None,')
     lines.append('    "error": __dh_error,')
+    lines.append('    "interrupted": __dh_interrupted,')
+    lines.append('    "warnings": [{"source": "python", "category": __dh_w.category.__name__, "message": str(__dh_w.message), '
+                 '"filename": __dh_w.filename, "lineno": __dh_w.lineno} for __dh_w in __dh_warn_list]'
+                 ' + __dh_log_handler.records,')
     lines.append("}")
     lines.append("")
     lines.append("with open('/tmp/__dh_result.json', 'w') as __dh_f:")
     lines.append("    __dh_json.dump(__dh_results_dict, __dh_f)")
     lines.append("")
-    lines.append("del __dh_io, __dh_sys, __dh_json")
+    lines.append("del __dh_io, __dh_sys, __dh_json, __dh_warnings, __dh_warn_ctx, __dh_warn_list")
     lines.append("del __dh_stdout_buf, __dh_stderr_buf, __dh_orig_stdout, __dh_orig_stderr")
     lines.append("del __dh_result, __dh_error, __dh_results_dict, __dh_f")
+    lines.append("del __dh_interrupted, __dh_threading, __dh_ctypes, __dh_watch_cancel, __dh_done, __dh_watcher")
+    lines.append("del __dh_os_path, __dh_logging, __DhLogRecorder, __dh_log_handler")
 
     return "\n".join(lines)
 
@@ -119,9 +259,226 @@ def read_result_file():
         return {"error": f"Failed to read results: {e}"}
 
 
+# --- FUSE workspace mount ---
+
+def workspace_mounted():
+    with open("/proc/self/mountinfo") as f:
+        return any(line.split()[4] == "/workspace" for line in f)
+
+
+def mount_workspace_fuse():
+    """Start the FUSE daemon that serves /workspace from the host file server
+    and wait for the mount. Returns an error message, or None."""
+    import time
+    if workspace_mounted():
+        return None
+    env = dict(os.environ)
+    env.pop("LD_PRELOAD", None)
+    with open(WORKSPACE_FUSE_LOG, "w") as log:
+        proc = subprocess.Popen([sys.executable, WORKSPACE_FUSE], env=env,
+                                stdin=subprocess.DEVNULL, stdout=log, stderr=log,
+                                start_new_session=True)
+    for _ in range(250):
+        if workspace_mounted():
+            return None
+        if proc.poll() is not None:
+            break
+        time.sleep(0.02)
+    with open(WORKSPACE_FUSE_LOG) as log:
+        detail = log.read().strip().splitlines()
+    reason = detail[-1] if detail else "timed out"
+    return f"Mounting /workspace over FUSE failed: {reason}"
+
+
+# --- Output files (dh exec --collect-outputs) ---
+
+def reset_output_dir():
+    import shutil
+    shutil.rmtree(OUTPUT_DIR, ignore_errors=True)
+    os.makedirs(OUTPUT_DIR)
+
+
+def send_outputs(conn):
+    """Send each regular file under OUTPUT_DIR to conn as base64 "file"
+    frames, at least one per file. Returns [{"path", "size"}] for the
+    response."""
+    import base64
+    sent = []
+    for dirpath, dirnames, filenames in os.walk(OUTPUT_DIR):
+        dirnames.sort()
+        for name in sorted(filenames):
+            full = os.path.join(dirpath, name)
+            if os.path.islink(full) or not os.path.isfile(full):
+                continue
+            rel = os.path.relpath(full, OUTPUT_DIR)
+            size = 0
+            with open(full, "rb") as f:
+                chunk = f.read(OUTPUT_CHUNK)
+                while True:
+                    frame = {"type": "file", "path": rel, "data": base64.b64encode(chunk).decode("ascii")}
+                    conn.sendall(json.dumps(frame).encode("utf-8") + b"\n")
+                    size += len(chunk)
+                    chunk = f.read(OUTPUT_CHUNK)
+                    if not chunk:
+                        break
+            sent.append({"path": rel, "size": size})
+    return sent
+
+
+# --- Output streaming ---
+
+def stream_output(conn, done):
+    """Forward lines appended to STREAM_FILE to conn as stdout/stderr frames
+    until done is set and the file has been read to the end."""
+    pending = b""
+    try:
+        with open(STREAM_FILE, "rb") as f:
+            while True:
+                finished = done.is_set()
+                chunk = f.read()
+                if chunk:
+                    pending += chunk
+                    *complete, pending = pending.split(b"\n")
+                    for line in complete:
+                        name, data = json.loads(line)
+                        frame = {"type": name, "data": data}
+                        conn.sendall(json.dumps(frame).encode("utf-8") + b"\n")
+                elif finished:
+                    return
+                else:
+                    done.wait(0.05)
+    except Exception:
+        # The host went away or the file is gone; the response still
+        # carries the exit code.
+        pass
+
+
+def watch_cancel(conn, pending, done):
+    """Create CANCEL_FILE when the host sends a cancel frame or hangs up,
+    until done is set."""
+    def cancelled(lines):
+        for line in lines:
+            try:
+                if json.loads(line).get("type") == "cancel":
+                    return True
+            except (ValueError, AttributeError):
+                pass
+        return False
+
+    try:
+        while not done.is_set():
+            *complete, pending = pending.split(b"\n")
+            if cancelled(complete):
+                break
+            ready, _, _ = select.select([conn], [], [], 0.1)
+            if not ready:
+                continue
+            chunk = conn.recv(4096)
+            if not chunk:
+                # Nobody is waiting for the result any more.
+                break
+            pending += chunk
+        else:
+            return
+        open(CANCEL_FILE, "w").close()
+    except Exception:
+        pass
+
+
+# --- Interactive shell (dh vm shell) ---
+
+def serve_shell(conn, request, pending):
+    """Run an interactive shell on a PTY and relay its bytes over conn until
+    the shell exits or the host hangs up."""
+    import fcntl
+    import pty
+    import signal
+    import struct
+    import termios
+
+    shell = "/bin/bash" if os.path.exists("/bin/bash") else "/bin/sh"
+    pid, master = pty.fork()
+    if pid == 0:
+        os.environ["TERM"] = request.get("term") or "xterm"
+        os.chdir("/workspace" if os.path.isdir("/workspace") else "/")
+        os.execv(shell, [shell, "-i"])
+
+    rows, cols = request.get("rows") or 24, request.get("cols") or 80
+    fcntl.ioctl(master, termios.TIOCSWINSZ, struct.pack("HHHH", rows, cols, 0, 0))
+    conn.sendall(json.dumps({"type": "shell", "pid": pid}).encode("utf-8") + b"\n")
+    if pending:
+        os.write(master, pending)
+
+    try:
+        while True:
+            ready, _, _ = select.select([conn, master], [], [])
+            if conn in ready:
+                data = conn.recv(65536)
+                if not data:
+                    break
+                os.write(master, data)
+            if master in ready:
+                try:
+                    data = os.read(master, 65536)
+                except OSError:
+                    # EIO: the shell exited and closed the PTY.
+                    data = b""
+                if not data:
+                    break
+                conn.sendall(data)
+    finally:
+        try:
+            os.kill(pid, signal.SIGHUP)
+        except ProcessLookupError:
+            pass
+        os.waitpid(pid, 0)
+        os.close(master)
+
+
+# --- Content hashing (for dh exec --record/--replay) ---
+
+def _table_content_hash(arrow_table):
+    """Return a sha256 hex digest of a table's row values, independent of
+    Arrow metadata so it stays stable across Deephaven upgrades."""
+    import hashlib
+    import pandas as pd
+    digest = hashlib.sha256()
+    if arrow_table.num_rows > 0:
+        df = arrow_table.to_pandas()
+        digest.update(pd.util.hash_pandas_object(df, index=False).values.tobytes())
+    return digest.hexdigest()
+
+
 # --- Table preview ---
 
-def get_table_preview(session, name, show_meta=True):
+def _markdown_table(df):
+    """Render df as a GitHub-flavored Markdown table."""
+    def cell(v):
+        return str(v).replace("|", "\\|").replace("\n", " ")
+    lines = ["| " + " | ".join(cell(c) for c in df.columns) + " |",
+             "|" + "|".join(" --- " for _ in df.columns) + "|"]
+    for row in df.itertuples(index=False):
+        lines.append("| " + " | ".join(cell(v) for v in row) + " |")
+    return "\n".join(lines)
+
+
+def format_preview(df, table_output):
+    """Render the preview rows df in the --table-output format."""
+    if table_output == "csv":
+        return df.to_csv(index=False).rstrip("\n")
+    if table_output == "markdown":
+        return _markdown_table(df)
+    if table_output == "html":
+        return df.to_html(index=False)
+    if table_output == "json":
+        return df.to_json(orient="records", date_format="iso")
+    if len(df) == 0:
+        return "(empty table)"
+    return df.to_string(index=False)
+
+
+def get_table_preview(session, name, show_meta=True, hash_content=False,
+                      table_output="pretty", max_rows=10, max_cols=0):
     """Get table metadata and preview string. Returns dict or None on error."""
     try:
         table = session.open_table(name)
@@ -132,7 +489,8 @@ def get_table_preview(session, name, show_meta=True):
         columns = [{"name": field.name, "type": str(field.type)} for field in schema]
 
         lines = []
-        if show_meta:
+        # The column list would not parse as the other formats.
+        if show_meta and table_output == "pretty":
             col_info = ", ".join(f"{c['name']} ({c['type']})" for c in columns)
             if len(f"Columns: {col_info}") > 80:
                 lines.append("Columns:")
@@ -142,33 +500,145 @@ def get_table_preview(session, name, show_meta=True):
                 lines.append(f"Columns: {col_info}")
             lines.append("")
 
-        if total_rows == 0:
-            lines.append("(empty table)")
-        else:
-            preview_df = arrow_table.slice(0, 10).to_pandas()
-            lines.append(preview_df.to_string(index=False))
+        preview_table = arrow_table.slice(0, max_rows) if max_rows else arrow_table
+        if max_cols and preview_table.num_columns > max_cols:
+            preview_table = preview_table.select(range(max_cols))
+        preview_df = preview_table.to_pandas()
+        lines.append(format_preview(preview_df, table_output))
 
-        return {
+        info = {
             "name": name,
             "row_count": total_rows,
             "is_refreshing": is_refreshing,
             "columns": columns,
             "preview": "\n".join(lines),
         }
+        if table_output == "json":
+            info["rows"] = json.loads(lines[-1])
+        if hash_content:
+            # A table whose content can't be hashed is still reported,
+            # with the reason, rather than dropped.
+            try:
+                info["content_hash"] = _table_content_hash(arrow_table)
+            except Exception as e:
+                info["content_hash"] = None
+                info["content_hash_error"] = f"{type(e).__name__}: {e}"
+        return info
     except Exception:
         return None
 
 
+def send_arrow_tables(session, names, conn):
+    """Send each table of names to conn as an Arrow IPC stream in base64
+    "table" frames, at least one per table. Names that are not tables are
+    skipped. Returns [{"name", "size"}] for the response."""
+    import base64
+    import pyarrow as pa
+    sent = []
+    for name in sorted(names):
+        try:
+            arrow_table = session.open_table(name).to_arrow()
+        except Exception:
+            continue
+        sink = pa.BufferOutputStream()
+        with pa.ipc.new_stream(sink, arrow_table.schema) as writer:
+            writer.write_table(arrow_table)
+        data = sink.getvalue().to_pybytes()
+        for i in range(0, max(len(data), 1), OUTPUT_CHUNK):
+            frame = {"type": "table", "name": name, "data": base64.b64encode(data[i:i + OUTPUT_CHUNK]).decode("ascii")}
+            conn.sendall(json.dumps(frame).encode("utf-8") + b"\n")
+        sent.append({"name": name, "size": len(data)})
+    return sent
+
+
+# --- Table imports (dh exec --import-table) ---
+
+def import_tables(session, imports):
+    """Load each file of imports into the server as a table bound to its
+    name. The server reads the files, through /workspace or a mount, as
+    the script would. Returns an error message, or None."""
+    for imp in imports:
+        name, path = imp["name"], imp["path"]
+        if imp.get("format") == "parquet":
+            read = "from deephaven.parquet import read as __dh_read"
+        else:
+            read = "from deephaven import read_csv as __dh_read"
+        script = f"{read}\n{name} = __dh_read({path!r})\ndel __dh_read\n"
+        try:
+            session.run_script(script)
+        except Exception as e:
+            return f"--import-table {name}: reading {path}: {e}"
+    return None
+
+
+# --- Table exports (dh exec --export-table) ---
+
+def encode_table(arrow_table, fmt):
+    """Return arrow_table as the contents of a file in fmt: "parquet",
+    "csv" or "arrow" (an Arrow IPC stream)."""
+    import pyarrow as pa
+    sink = pa.BufferOutputStream()
+    if fmt == "parquet":
+        import pyarrow.parquet as pq
+        pq.write_table(arrow_table, sink)
+    elif fmt == "csv":
+        import pyarrow.csv as pa_csv
+        pa_csv.write_csv(arrow_table, sink)
+    else:
+        with pa.ipc.new_stream(sink, arrow_table.schema) as writer:
+            writer.write_table(arrow_table)
+    return sink.getvalue().to_pybytes()
+
+
+def send_exported_tables(session, exports, conn):
+    """Send each table of exports to conn, in its format, in base64
+    "export" frames, at least one per table. Returns the
+    [{"name", "size"}] for the response, and an error message or None."""
+    import base64
+    sent = []
+    for exp in exports:
+        name = exp["name"]
+        try:
+            arrow_table = session.open_table(name).to_arrow()
+        except Exception:
+            return sent, f"--export-table {name}: no table {name} in scope"
+        try:
+            data = encode_table(arrow_table, exp.get("format"))
+        except Exception as e:
+            return sent, f"--export-table {name}: {e}"
+        for i in range(0, max(len(data), 1), OUTPUT_CHUNK):
+            frame = {"type": "export", "name": name, "data": base64.b64encode(data[i:i + OUTPUT_CHUNK]).decode("ascii")}
+            conn.sendall(json.dumps(frame).encode("utf-8") + b"\n")
+        sent.append({"name": name, "size": len(data)})
+    return sent, None
+
+
 # --- Request handling ---
 
-def handle_request(session, request):
-    """Process a single execution request. Returns response dict."""
+def handle_request(session, request, conn=None, pending=b""):
+    """Process a single execution request. Returns response dict.
+
+    When the request asks to stream and conn is given, stdout and stderr
+    are sent on conn as they are written and left out of the response.
+    While the script runs, a cancel frame on conn (pending holds what was
+    read past the request) interrupts it.
+    """
     import time as _t
     _t0 = _t.time()
 
     code = request.get("code", "")
     show_tables = request.get("show_tables", False)
     show_table_meta = request.get("show_table_meta", False)
+    python_path = request.get("python_path") or []
+    hash_tables = request.get("hash_tables", False)
+    table_output = request.get("table_output") or "pretty"
+    max_rows = request.get("max_rows", 10)
+    max_cols = request.get("max_cols", 0)
+    stream = bool(request.get("stream")) and conn is not None
+    fuse = request.get("mount_mode") == "fuse"
+    outputs = bool(request.get("collect_outputs")) and conn is not None
+    arrow = request.get("table_format") == "arrow" and conn is not None
+    exports = (request.get("export_tables") or []) if conn is not None else []
 
     if not code.strip():
         return {
@@ -180,16 +650,58 @@ def handle_request(session, request):
             "tables": [],
         }
 
-    if show_tables:
+    if show_tables or arrow:
         assigned_names = get_assigned_names(code)
     else:
         assigned_names = set()
-    wrapper = build_wrapper(code)
+    if fuse:
+        err = mount_workspace_fuse()
+        if err:
+            return {
+                "exit_code": 1,
+                "stdout": "",
+                "stderr": "",
+                "result_repr": None,
+                "error": err,
+                "tables": [],
+                "mount_mode": "fuse",
+            }
+    if outputs:
+        reset_output_dir()
+    err = import_tables(session, request.get("import_tables") or [])
+    if err:
+        return {
+            "exit_code": 1,
+            "stdout": "",
+            "stderr": "",
+            "result_repr": None,
+            "error": err,
+            "tables": [],
+            "mount_mode": "fuse" if fuse else None,
+        }
+    wrapper = build_wrapper(code, python_path, stream=stream, fuse=fuse, outputs=outputs)
     _t1 = _t.time()
 
+    try:
+        os.remove(CANCEL_FILE)
+    except FileNotFoundError:
+        pass
+    done = threading.Event()
+    helpers = []
+    if conn is not None:
+        helpers.append(threading.Thread(target=watch_cancel, args=(conn, pending, done), daemon=True))
+    if stream:
+        open(STREAM_FILE, "w").close()
+        helpers.append(threading.Thread(target=stream_output, args=(conn, done), daemon=True))
+    for t in helpers:
+        t.start()
+
     try:
         session.run_script(wrapper)
     except Exception as e:
+        done.set()
+        for t in helpers:
+            t.join()
         return {
             "exit_code": 1,
             "stdout": "",
@@ -199,6 +711,11 @@ def handle_request(session, request):
             "tables": [],
         }
 
+    done.set()
+    for t in helpers:
+        t.join()
+    output_files = send_outputs(conn) if outputs else None
+
     _t2 = _t.time()
     result = read_result_file()
     _t3 = _t.time()
@@ -207,62 +724,106 @@ def handle_request(session, request):
     stderr_text = result.get("stderr", "")
     result_repr = result.get("result_repr")
     error_text = result.get("error")
+    interrupted = bool(result.get("interrupted"))
+    warnings_list = result.get("warnings") or []
 
     tables_info = []
-    if show_tables and assigned_names:
+    if show_tables and assigned_names and not interrupted:
         # Use assigned_names directly to avoid a session.tables gRPC call.
         # Each get_table_preview opens the table individually; if it doesn't
         # exist on the server, it returns None.
         for tname in assigned_names:
-            info = get_table_preview(session, tname, show_meta=show_table_meta)
+            info = get_table_preview(session, tname, show_meta=show_table_meta, hash_content=hash_tables,
+                                     table_output=table_output, max_rows=max_rows, max_cols=max_cols)
             if info:
                 tables_info.append(info)
+    arrow_tables = None
+    if arrow:
+        arrow_tables = send_arrow_tables(session, assigned_names, conn) if not interrupted else []
+    exported_tables = []
+    if exports and not interrupted and not error_text:
+        exported_tables, err = send_exported_tables(session, exports, conn)
+        if err:
+            error_text = err
+
+    _t4 = _t.time()
+
+    if stream:
+        stdout_text = stderr_text = ""
 
     return {
-        "exit_code": 1 if error_text else 0,
+        "exit_code": 130 if interrupted else 1 if error_text else 0,
+        "mount_mode": "fuse" if fuse else None,
+        "outputs": output_files,
+        "arrow_tables": arrow_tables,
+        "exported_tables": exported_tables,
+        "streamed": stream,
+        "interrupted": interrupted,
         "stdout": stdout_text,
         "stderr": stderr_text,
         "result_repr": result_repr,
         "error": error_text,
+        "warnings": warnings_list,
         "tables": tables_info,
         "_timing": {
             "build_wrapper_ms": int((_t1-_t0)*1000),
             "run_script_ms": int((_t2-_t1)*1000),
             "read_result_ms": int((_t3-_t2)*1000),
+            "tables_ms": int((_t4-_t3)*1000),
         },
     }
 
 
 # --- Vsock server ---
 
+def read_line(conn, data):
+    """Read from conn until data holds a line. Returns the line and what
+    was read past it; the line is empty if conn closed first."""
+    while b"\n" not in data:
+        chunk = conn.recv(65536)
+        if not chunk:
+            return b"", b""
+        data += chunk
+    line, _, rest = data.partition(b"\n")
+    return line, rest
+
+
 def serve_forever(session):
     """Listen on vsock, handle one request per connection."""
     vs = socket.socket(socket.AF_VSOCK, socket.SOCK_STREAM)
     vs.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
     vs.bind((VMADDR_CID_ANY, VSOCK_PORT))
     vs.listen(5)
+    log(f"listening on vsock port {VSOCK_PORT}")
 
     while True:
         conn, _ = vs.accept()
         try:
-            data = b""
-            while True:
-                chunk = conn.recv(65536)
-                if not chunk:
-                    break
-                data += chunk
-                if b"\n" in data:
-                    break
-
-            line = data.split(b"\n", 1)[0]
+            line, pending = read_line(conn, b"")
             if not line.strip():
                 # Probe connection from waitForVsock -- just close
                 continue
 
             request = json.loads(line)
-            response = handle_request(session, request)
+            if request.get("type") == "hello":
+                # The request follows on the same connection.
+                hello = {"type": "hello", "protocol": PROTOCOL_VERSION, "features": features()}
+                conn.sendall(json.dumps(hello).encode("utf-8") + b"\n")
+                line, pending = read_line(conn, pending)
+                if not line.strip():
+                    continue
+                request = json.loads(line)
+            if request.get("shell"):
+                log("shell request")
+                serve_shell(conn, request, pending)
+                log("shell closed")
+                continue
+            log(f"exec request: {len(request.get('code', ''))} bytes of code")
+            response = handle_request(session, request, conn, pending)
+            log(f"exec done: exit code {response.get('exit_code')}")
             conn.sendall(json.dumps(response).encode("utf-8") + b"\n")
         except Exception:
+            log(f"request failed: {traceback.format_exc().rstrip()}")
             try:
                 err_resp = json.dumps({
                     "exit_code": 2,
@@ -292,10 +853,11 @@ def main():
             break
         time.sleep(0.1)
     else:
-        print("RUNNER: Timed out waiting for DH", file=sys.stderr, flush=True)
+        log("Timed out waiting for DH")
         sys.exit(1)
 
     from pydeephaven import Session
+    log("connecting a session to the Deephaven server")
     session = Session(host="localhost", port=10000)
 
     # Signal readiness via marker file
@@ -1,9 +1,22 @@
 package vm
 
 import (
+	"archive/tar"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestNewVMPaths(t *testing.T) {
@@ -27,6 +40,9 @@ func TestNewVMPaths(t *testing.T) {
 	if paths.RunDir != "/home/user/.dh/vm/run" {
 		t.Errorf("RunDir = %q, want %q", paths.RunDir, "/home/user/.dh/vm/run")
 	}
+	if paths.BundlesDir != "/home/user/.dh/bundles" {
+		t.Errorf("BundlesDir = %q, want %q", paths.BundlesDir, "/home/user/.dh/bundles")
+	}
 }
 
 func TestRootfsForVersion(t *testing.T) {
@@ -87,6 +103,33 @@ func TestCheckSnapshot_Valid(t *testing.T) {
 	}
 }
 
+func TestSnapshotGeneration(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	if g := SnapshotGeneration(paths, "0.36.0"); g != "" {
+		t.Errorf("generation of a missing snapshot = %q", g)
+	}
+
+	snapDir := paths.SnapshotDirForVersion("0.36.0")
+	os.MkdirAll(snapDir, 0o755)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		meta SnapshotMetadata
+		want string
+	}{
+		{SnapshotMetadata{CreatedAt: created, Generation: "1f2e"}, "1f2e"},
+		{SnapshotMetadata{CreatedAt: created}, "2026-03-01T12:00:00Z"}, // taken before generations
+	} {
+		data, _ := json.Marshal(tc.meta)
+		os.WriteFile(filepath.Join(snapDir, "metadata.json"), data, 0o644)
+		if g := SnapshotGeneration(paths, "0.36.0"); g != tc.want {
+			t.Errorf("generation = %q, want %q", g, tc.want)
+		}
+	}
+	if a, b := newGeneration(), newGeneration(); a == b || len(a) != 16 {
+		t.Errorf("newGeneration() = %q, %q", a, b)
+	}
+}
+
 func TestCheckSnapshot_Incomplete(t *testing.T) {
 	tmpDir := t.TempDir()
 	paths := NewVMPaths(tmpDir)
@@ -126,3 +169,1072 @@ func TestCheckPrerequisites(t *testing.T) {
 	}
 }
 
+
+func TestParseMount(t *testing.T) {
+	tmpDir := t.TempDir()
+	dataDir := filepath.Join(tmpDir, "data")
+	if err := os.Mkdir(dataDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec     string
+		alias    string
+		readOnly bool
+	}{
+		{dataDir, "data", true},
+		{dataDir + ":ref", "ref", true},
+		{dataDir + ":rw", "data", false},
+		{dataDir + ":ref:rw", "ref", false},
+		{dataDir + ":ref:ro", "ref", true},
+	}
+	for _, tt := range tests {
+		m, err := ParseMount(tt.spec)
+		if err != nil {
+			t.Errorf("ParseMount(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if m.HostPath != dataDir || m.Alias != tt.alias || m.ReadOnly != tt.readOnly {
+			t.Errorf("ParseMount(%q) = %+v, want alias=%q readOnly=%v", tt.spec, m, tt.alias, tt.readOnly)
+		}
+	}
+
+	for _, spec := range []string{"", filepath.Join(tmpDir, "missing"), dataDir + ":a:b", dataDir + ":..", dataDir + ":a:ro:x"} {
+		if _, err := ParseMount(spec); err == nil {
+			t.Errorf("ParseMount(%q) expected error, got nil", spec)
+		}
+	}
+}
+
+func TestParseMounts_DuplicateAlias(t *testing.T) {
+	tmpDir := t.TempDir()
+	if _, err := ParseMounts([]string{tmpDir + ":x", tmpDir + ":x:rw"}); err == nil {
+		t.Error("expected error for duplicate alias, got nil")
+	}
+}
+
+func TestValidateMounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "f")
+	os.WriteFile(file, nil, 0o644)
+
+	if err := ValidateMounts([]Mount{{HostPath: tmpDir, Alias: "a"}, {HostPath: tmpDir, Alias: "b", ReadOnly: true}}); err != nil {
+		t.Errorf("valid mounts: %v", err)
+	}
+	for _, mounts := range [][]Mount{
+		{{HostPath: "rel", Alias: "a"}},
+		{{HostPath: file, Alias: "a"}},
+		{{HostPath: filepath.Join(tmpDir, "missing"), Alias: "a"}},
+		{{HostPath: tmpDir, Alias: ""}},
+		{{HostPath: tmpDir, Alias: "a/b"}},
+		{{HostPath: tmpDir, Alias: ".."}},
+		{{HostPath: tmpDir, Alias: "a"}, {HostPath: tmpDir, Alias: "a"}},
+	} {
+		if err := ValidateMounts(mounts); err == nil {
+			t.Errorf("ValidateMounts(%+v) expected error, got nil", mounts)
+		}
+	}
+}
+
+func writeTestSnapshot(t *testing.T, paths *VMPaths, version string, meta SnapshotMetadata) string {
+	t.Helper()
+	snapDir := paths.SnapshotDirForVersion(version)
+	if err := os.MkdirAll(snapDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"snapshot_mem", "snapshot_vmstate", "disk.ext4"} {
+		if err := os.WriteFile(filepath.Join(snapDir, name), []byte("test"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, _ := json.Marshal(meta)
+	if err := os.WriteFile(filepath.Join(snapDir, "metadata.json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return snapDir
+}
+
+func TestListSnapshots(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+
+	snaps, err := ListSnapshots(paths)
+	if err != nil || len(snaps) != 0 {
+		t.Fatalf("expected no snapshots, got %v (err %v)", snaps, err)
+	}
+
+	writeTestSnapshot(t, paths, "0.37.0", SnapshotMetadata{Version: "0.37.0", Firecracker: FirecrackerVersion})
+	os.MkdirAll(paths.SnapshotDirForVersion("0.36.0"), 0o755)
+
+	snaps, err = ListSnapshots(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 || snaps[0].Version != "0.36.0" || snaps[1].Version != "0.37.0" {
+		t.Fatalf("unexpected snapshots: %+v", snaps)
+	}
+	if snaps[0].Complete || snaps[0].Meta != nil {
+		t.Errorf("empty snapshot dir should be incomplete without metadata: %+v", snaps[0])
+	}
+	if !snaps[1].Complete || snaps[1].Meta == nil || snaps[1].Meta.Firecracker != FirecrackerVersion {
+		t.Errorf("expected complete snapshot with metadata: %+v", snaps[1])
+	}
+}
+
+func TestVerifySnapshot(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	os.MkdirAll(paths.Base, 0o755)
+	if err := os.WriteFile(paths.Kernel, []byte("kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := KernelFingerprint(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestSnapshot(t, paths, "0.36.0", SnapshotMetadata{
+		Version:      "0.36.0",
+		Firecracker:  FirecrackerVersion,
+		KernelSHA256: sum,
+	})
+	if problems := VerifySnapshot(paths, "0.36.0"); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+
+	// Swapping the kernel invalidates the snapshot.
+	os.WriteFile(paths.Kernel, []byte("other kernel"), 0o644)
+	if problems := VerifySnapshot(paths, "0.36.0"); len(problems) != 1 {
+		t.Errorf("expected kernel change to be reported, got %v", problems)
+	}
+
+	snapDir := writeTestSnapshot(t, paths, "0.37.0", SnapshotMetadata{Version: "0.37.0", MemSizeMiB: 1})
+	os.WriteFile(filepath.Join(snapDir, "disk.ext4"), nil, 0o644)
+	problems := VerifySnapshot(paths, "0.37.0")
+	if len(problems) != 2 {
+		t.Errorf("expected empty disk and wrong mem size, got %v", problems)
+	}
+}
+
+// writeFakeFirecracker installs a firecracker that reports version.
+func writeFakeFirecracker(t *testing.T, paths *VMPaths, version string) {
+	t.Helper()
+	os.MkdirAll(paths.Base, 0o755)
+	script := fmt.Sprintf("#!/bin/sh\necho 'Firecracker %s'\necho\necho 'Supported snapshot data format versions: v5.0.0'\n", version)
+	if err := os.WriteFile(paths.Firecracker, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotToolchain(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	if v := currentFirecracker(paths); v != FirecrackerVersion {
+		t.Errorf("expected %s without a binary, got %s", FirecrackerVersion, v)
+	}
+
+	writeFakeFirecracker(t, paths, "v1.12.0")
+	if v, err := InstalledFirecracker(paths); err != nil || v != "v1.12.0" {
+		t.Fatalf("expected v1.12.0, got %q (err %v)", v, err)
+	}
+	if _, err := os.Stat(paths.Toolchain); err != nil {
+		t.Errorf("expected the version to be cached: %v", err)
+	}
+
+	os.WriteFile(paths.Kernel, []byte("kernel"), 0o644)
+	sum, _ := KernelFingerprint(paths)
+	meta := &SnapshotMetadata{Version: "0.36.0", Firecracker: "v1.12.0", KernelSHA256: sum}
+	if problems := snapshotToolchainProblems(paths, meta); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+	if problems := snapshotToolchainProblems(paths, &SnapshotMetadata{Version: "0.36.0"}); len(problems) != 0 {
+		t.Errorf("snapshots without fingerprints should pass, got %v", problems)
+	}
+
+	// An upgraded binary has another size, so the cache is not used.
+	writeFakeFirecracker(t, paths, "v1.13.10")
+	problems := snapshotToolchainProblems(paths, meta)
+	if len(problems) != 1 || !strings.Contains(problems[0], "taken with Firecracker v1.12.0, installed is v1.13.10") {
+		t.Errorf("expected a Firecracker change, got %v", problems)
+	}
+
+	os.Remove(paths.Kernel)
+	if problems := snapshotToolchainProblems(paths, meta); len(problems) != 2 || problems[1] != "kernel is missing" {
+		t.Errorf("expected Firecracker and kernel problems, got %v", problems)
+	}
+
+	err := (&IncompatibleSnapshotError{Key: "0.36.0/ml", Problems: problems}).Error()
+	if !strings.Contains(err, "Run: dh vm prepare --version 0.36.0 --profile ml") || !strings.Contains(err, "--auto-rebuild") {
+		t.Errorf("error should say how to rebuild: %s", err)
+	}
+}
+
+func TestRebuildSnapshotNeedsRootfs(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	writeTestSnapshot(t, paths, "0.36.0", SnapshotMetadata{Version: "0.36.0"})
+	err := RebuildSnapshot(context.Background(), &VMConfig{Version: "0.36.0"}, paths, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "no rootfs") {
+		t.Errorf("expected a missing rootfs error, got %v", err)
+	}
+}
+
+func TestPinnedKernels(t *testing.T) {
+	kernels, err := PinnedKernels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for arch, k := range kernels {
+		if arch != "x86_64" && arch != "aarch64" {
+			t.Errorf("kernel pinned for unknown architecture %s", arch)
+		}
+		if err := k.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+	// dh vm prepare refuses to download a kernel that isn't pinned, so
+	// every architecture dh runs VMs on needs one.
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		if _, ok := kernels[arch]; !ok {
+			t.Errorf("no kernel pinned for %s; run go generate ./internal/vm", arch)
+		}
+	}
+
+	for _, k := range []PinnedKernel{
+		{Name: "vmlinux-6.1.1", SHA256: strings.Repeat("a", 64)},
+		{Name: "vmlinux-6.1.1", URL: "https://example.com/vmlinux", SHA256: "abc"},
+	} {
+		if k.Validate() == nil {
+			t.Errorf("expected %+v to be invalid", k)
+		}
+	}
+}
+
+func TestFaultPercentiles(t *testing.T) {
+	if p50, p99 := faultPercentiles(nil); p50 != 0 || p99 != 0 {
+		t.Errorf("expected zeros without samples, got %v %v", p50, p99)
+	}
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Microsecond)
+	}
+	if p50, p99 := faultPercentiles(samples); p50 != 50 || p99 != 99 {
+		t.Errorf("faultPercentiles = %v, %v; want 50, 99", p50, p99)
+	}
+	if samples[0] != 100*time.Microsecond {
+		t.Error("faultPercentiles must not reorder its argument")
+	}
+	if UffdStatsOf(nil) != nil || UffdStatsOf(io.NopCloser(nil)) != nil {
+		t.Error("expected no stats without a UFFD handler")
+	}
+}
+
+func TestHotChunks(t *testing.T) {
+	const chunk = 2 << 20
+	dir := t.TempDir()
+	if hot := readHotChunks(dir, chunk); hot != nil {
+		t.Errorf("expected no hot chunks, got %v", hot)
+	}
+	if err := addHotChunks(dir, chunk, []uint64{4 << 20, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addHotChunks(dir, chunk, []uint64{2 << 20, 4 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	if hot := readHotChunks(dir, chunk); !slices.Equal(hot, []uint64{0, 2 << 20, 4 << 20}) {
+		t.Errorf("expected the union of both runs, sorted, got %v", hot)
+	}
+	if hot := readHotChunks(dir, 4096); hot != nil {
+		t.Errorf("hot chunks of another size should be ignored, got %v", hot)
+	}
+
+	var many []uint64
+	for i := range uint64(maxHotChunks + 10) {
+		many = append(many, i*chunk)
+	}
+	addHotChunks(dir, chunk, many)
+	if hot := readHotChunks(dir, chunk); len(hot) != maxHotChunks || hot[0] != 0 {
+		t.Errorf("expected the lowest %d chunks, got %d", maxHotChunks, len(hot))
+	}
+
+	extents := []dataExtent{{offset: 4096, length: 4096}, {offset: 8 << 20, length: 1 << 20}}
+	for _, tc := range []struct {
+		off  uint64
+		want bool
+	}{{0, true}, {2 << 20, false}, {6 << 20, false}, {8 << 20, true}, {10 << 20, false}} {
+		if got := extentsOverlap(extents, tc.off, chunk); got != tc.want {
+			t.Errorf("extentsOverlap(%#x) = %v, want %v", tc.off, got, tc.want)
+		}
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	snapDir := writeTestSnapshot(t, paths, "0.36.0", SnapshotMetadata{Version: "0.36.0"})
+
+	if err := DeleteSnapshot(paths, "0.36.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(snapDir); !os.IsNotExist(err) {
+		t.Error("snapshot dir should be removed")
+	}
+	if err := DeleteSnapshot(paths, "0.36.0"); err == nil {
+		t.Error("expected error deleting a missing snapshot")
+	}
+}
+
+func TestSnapshotProfilePaths(t *testing.T) {
+	paths := NewVMPaths("/home/user/.dh")
+	key := SnapshotKey("0.36.0", "myproj")
+	if key != "0.36.0/myproj" || SnapshotKey("0.36.0", "") != "0.36.0" {
+		t.Fatalf("SnapshotKey = %q", key)
+	}
+	if v, p := SplitSnapshotKey(key); v != "0.36.0" || p != "myproj" {
+		t.Errorf("SplitSnapshotKey = %q, %q", v, p)
+	}
+	for got, want := range map[string]string{
+		paths.SnapshotDirForVersion(key):  "/home/user/.dh/vm/snapshots/0.36.0/myproj",
+		paths.RootfsForVersion(key):       "/home/user/.dh/vm/rootfs/deephaven-0.36.0+myproj.ext4",
+		paths.ManifestForVersion(key):     "/home/user/.dh/vm/rootfs/deephaven-0.36.0+myproj.packages.json",
+		paths.RequirementsForVersion(key): "/home/user/.dh/vm/rootfs/deephaven-0.36.0+myproj.requirements.txt",
+		PrepareCommand(key):               "dh vm prepare --version 0.36.0 --profile myproj",
+	} {
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+
+	for _, name := range []string{"myproj", "ml-2", "0"} {
+		if err := ValidateProfile(name); err != nil {
+			t.Errorf("ValidateProfile(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "MyProj", "-x", "a/b", "..", "a+b", "a_b"} {
+		if err := ValidateProfile(name); err == nil {
+			t.Errorf("ValidateProfile(%q) should fail", name)
+		}
+	}
+}
+
+func TestSnapshotProfiles(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	writeTestSnapshot(t, paths, "0.36.0", SnapshotMetadata{Version: "0.36.0"})
+	writeTestSnapshot(t, paths, "0.36.0/myproj", SnapshotMetadata{Version: "0.36.0", Profile: "myproj"})
+	writeTestSnapshot(t, paths, "0.37.0/ml", SnapshotMetadata{Version: "0.37.0", Profile: "ml"})
+
+	snaps, err := ListSnapshots(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, s := range snaps {
+		keys = append(keys, s.Version)
+		if !s.Complete {
+			t.Errorf("%s should be complete", s.Version)
+		}
+	}
+	if want := []string{"0.36.0", "0.36.0/myproj", "0.37.0/ml"}; !slices.Equal(keys, want) {
+		t.Fatalf("ListSnapshots = %v, want %v", keys, want)
+	}
+	if snaps[0].SizeBytes != snaps[1].SizeBytes {
+		t.Errorf("version size %d should not include its profile (%d)", snaps[0].SizeBytes, snaps[1].SizeBytes)
+	}
+
+	if problems := VerifySnapshot(paths, "0.36.0/myproj"); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+	writeTestSnapshot(t, paths, "0.37.0/other", SnapshotMetadata{Version: "0.37.0", Profile: "ml"})
+	if problems := VerifySnapshot(paths, "0.37.0/other"); len(problems) != 1 || !strings.Contains(problems[0], "0.37.0/ml") {
+		t.Errorf("expected a metadata problem, got %v", problems)
+	}
+
+	// Deleting a version's snapshot keeps its profiles.
+	if err := DeleteSnapshot(paths, "0.36.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSnapshot(paths, "0.36.0"); err == nil {
+		t.Error("the version's snapshot should be deleted")
+	}
+	if err := CheckSnapshot(paths, "0.36.0/myproj"); err != nil {
+		t.Errorf("the profile should be kept: %v", err)
+	}
+}
+
+func TestRootfsCurrent(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	key := SnapshotKey("0.36.0", "myproj")
+	reqs := filepath.Join(t.TempDir(), "requirements.txt")
+	os.WriteFile(reqs, []byte("numpy==2.0\n"), 0o644)
+
+	if RootfsCurrent(paths, key, RootfsOptions{Requirements: reqs}) {
+		t.Error("missing rootfs should not be current")
+	}
+	os.MkdirAll(paths.RootfsDir, 0o755)
+	os.WriteFile(paths.RootfsForVersion(key), []byte("ext4"), 0o644)
+	if !RootfsCurrent(paths, key, RootfsOptions{}) {
+		t.Error("rootfs without --requirements should be current")
+	}
+	if RootfsCurrent(paths, key, RootfsOptions{Requirements: reqs}) {
+		t.Error("rootfs without saved requirements should not be current")
+	}
+	os.WriteFile(paths.RequirementsForVersion(key), []byte("numpy==2.0\n"), 0o644)
+	if !RootfsCurrent(paths, key, RootfsOptions{Requirements: reqs}) {
+		t.Error("rootfs with the same requirements should be current")
+	}
+	os.WriteFile(reqs, []byte("numpy==2.1\n"), 0o644)
+	if RootfsCurrent(paths, key, RootfsOptions{Requirements: reqs}) {
+		t.Error("rootfs with changed requirements should not be current")
+	}
+
+	// Base image and Python are checked against the manifest; one from
+	// before they were recorded was built on DefaultBaseImage.
+	if RootfsCurrent(paths, key, RootfsOptions{BaseImage: DefaultBaseImage}) {
+		t.Error("rootfs without a manifest should not be current for a base image")
+	}
+	WriteRootfsManifest(paths, key, &RootfsManifest{OS: "ubuntu 22.04"})
+	if !RootfsCurrent(paths, key, RootfsOptions{BaseImage: DefaultBaseImage}) {
+		t.Error("rootfs from before base images were recorded should be on DefaultBaseImage")
+	}
+	WriteRootfsManifest(paths, key, &RootfsManifest{BaseImage: "ubuntu:24.04", Python: "3.11.9"})
+	for opts, want := range map[RootfsOptions]bool{
+		{BaseImage: "ubuntu:24.04"}:                 true,
+		{BaseImage: DefaultBaseImage}:               false,
+		{Python: "3.11"}:                            true,
+		{Python: "3.1"}:                             false,
+		{BaseImage: "ubuntu:24.04", Python: "3.12"}: false,
+	} {
+		if got := RootfsCurrent(paths, key, opts); got != want {
+			t.Errorf("RootfsCurrent(%+v) = %v, want %v", opts, got, want)
+		}
+	}
+
+	if files := paths.ProfileRootfsFiles("0.36.0"); len(files) != 3 {
+		t.Errorf("ProfileRootfsFiles = %v", files)
+	}
+}
+
+func TestFindGarbage(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	for _, v := range []string{"0.35.0", "0.36.0", "0.37.0"} {
+		writeTestSnapshot(t, paths, v, SnapshotMetadata{Version: v})
+	}
+	incomplete := paths.SnapshotDirForVersion("0.34.0")
+	os.MkdirAll(incomplete, 0o755)
+	os.MkdirAll(paths.RootfsDir, 0o755)
+	for _, key := range []string{"0.33.0", "0.36.0"} {
+		os.WriteFile(paths.RootfsForVersion(key), []byte("ext4"), 0o644)
+	}
+	os.WriteFile(paths.ManifestForVersion("0.33.0"), []byte("{}"), 0o644)
+
+	summary := func(garbage []Garbage) []string {
+		var out []string
+		for _, g := range garbage {
+			out = append(out, g.Kind+" "+g.Version)
+		}
+		return out
+	}
+	later := time.Now().Add(2 * time.Hour)
+	for _, tc := range []struct {
+		policy GCPolicy
+		now    time.Time
+		want   []string
+	}{
+		{GCPolicy{}, time.Now(), nil},
+		{GCPolicy{}, later, []string{"incomplete-snapshot 0.34.0", "rootfs 0.33.0"}},
+		{GCPolicy{KeepLatest: 2}, later, []string{"incomplete-snapshot 0.34.0", "snapshot 0.35.0", "rootfs 0.33.0"}},
+		{GCPolicy{KeepLatest: 1, Keep: []string{"0.36.0"}}, later, []string{"incomplete-snapshot 0.34.0", "snapshot 0.35.0", "rootfs 0.33.0"}},
+		{GCPolicy{KeepLatest: 1}, later, []string{"incomplete-snapshot 0.34.0", "snapshot 0.35.0", "snapshot 0.36.0", "rootfs 0.33.0", "rootfs 0.36.0"}},
+		{GCPolicy{OlderThan: 30 * 24 * time.Hour}, later, nil},
+		{GCPolicy{OlderThan: time.Hour}, later, []string{"incomplete-snapshot 0.34.0", "snapshot 0.35.0", "snapshot 0.36.0", "snapshot 0.37.0", "rootfs 0.33.0", "rootfs 0.36.0"}},
+	} {
+		if got := summary(FindGarbage(paths, tc.policy, tc.now)); !slices.Equal(got, tc.want) {
+			t.Errorf("FindGarbage(%+v) = %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+
+	for _, g := range FindGarbage(paths, GCPolicy{KeepLatest: 2}, later) {
+		if err := RemoveGarbage(paths, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snaps, _ := ListSnapshots(paths)
+	if len(snaps) != 2 || snaps[0].Version != "0.36.0" || snaps[1].Version != "0.37.0" {
+		t.Errorf("snapshots after gc: %+v", snaps)
+	}
+	for _, f := range []string{paths.RootfsForVersion("0.33.0"), paths.ManifestForVersion("0.33.0")} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed", f)
+		}
+	}
+	if _, err := os.Stat(paths.RootfsForVersion("0.36.0")); err != nil {
+		t.Errorf("rootfs of a kept snapshot should stay: %v", err)
+	}
+}
+
+func TestInstanceLogs(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	if _, err := FindInstanceLog(paths, ""); err == nil {
+		t.Error("expected an error without logs")
+	}
+
+	console := "Linux version 6.1\r\nRUNNER: listening on vsock port 10000\r\nDH_READY\r\nRUNNER: exec done: exit code 0\r\n"
+	for _, id := range []string{"exec-1", "exec-2"} {
+		os.MkdirAll(paths.InstanceDir(id), 0o755)
+		os.WriteFile(paths.serialLog(id), []byte(console), 0o644)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(paths.serialLog("exec-1"), old, old)
+	if err := RemoveInstanceDir(paths.InstanceDir("exec-1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(paths.InstanceDir("exec-1")); !os.IsNotExist(err) {
+		t.Error("instance directory should be removed")
+	}
+
+	logs := ListInstanceLogs(paths)
+	if len(logs) != 2 || logs[0].ID != "exec-2" || !logs[0].Running || logs[1].ID != "exec-1" || logs[1].Running {
+		t.Fatalf("ListInstanceLogs = %+v", logs)
+	}
+	if l, err := FindInstanceLog(paths, ""); err != nil || l.ID != "exec-2" {
+		t.Errorf("FindInstanceLog(last) = %+v, %v", l, err)
+	}
+	l, err := FindInstanceLog(paths, "exec-1")
+	if err != nil || l.Path != filepath.Join(paths.LogDir, "exec-1.log") {
+		t.Fatalf("FindInstanceLog(exec-1) = %+v, %v", l, err)
+	}
+
+	var all, runner strings.Builder
+	if err := WriteInstanceLog(context.Background(), l, &all, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if all.String() != console {
+		t.Errorf("log = %q", all.String())
+	}
+	if err := WriteInstanceLog(context.Background(), l, &runner, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := "listening on vsock port 10000\nexec done: exit code 0\n"; runner.String() != want {
+		t.Errorf("runner lines = %q, want %q", runner.String(), want)
+	}
+
+	// Following a running instance stops once it is torn down.
+	live, _ := FindInstanceLog(paths, "exec-2")
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		f, _ := os.OpenFile(live.Path, os.O_APPEND|os.O_WRONLY, 0o644)
+		f.WriteString("RUNNER: shell closed\r\n")
+		f.Close()
+		RemoveInstanceDir(paths.InstanceDir("exec-2"))
+	}()
+	runner.Reset()
+	if err := WriteInstanceLog(context.Background(), live, &runner, true, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(runner.String(), "exit code 0\nshell closed\n") {
+		t.Errorf("followed runner lines = %q", runner.String())
+	}
+
+	// Only the newest maxInstanceLogs are kept.
+	for i := range maxInstanceLogs + 2 {
+		id := fmt.Sprintf("pool-%d", i)
+		os.MkdirAll(paths.InstanceDir(id), 0o755)
+		os.WriteFile(paths.serialLog(id), []byte("x"), 0o644)
+		RemoveInstanceDir(paths.InstanceDir(id))
+	}
+	if kept, _ := filepath.Glob(filepath.Join(paths.LogDir, "*.log")); len(kept) != maxInstanceLogs {
+		t.Errorf("kept %d logs, want %d", len(kept), maxInstanceLogs)
+	}
+	if _, err := FindInstanceLog(paths, "exec-1"); err == nil {
+		t.Error("the oldest log should be dropped")
+	}
+}
+
+func TestRootfsOptions(t *testing.T) {
+	for _, opts := range []RootfsOptions{{}, {BaseImage: "debian:bookworm", Python: "3.11"}} {
+		if err := opts.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", opts, err)
+		}
+	}
+	for _, opts := range []RootfsOptions{{BaseImage: "ubuntu 24.04"}, {Python: "3"}, {Python: "python3.11"}} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", opts)
+		}
+	}
+
+	if got := ServerDockerfileWith(RootfsOptions{}, "0.36.0", nil); got != ServerDockerfile("0.36.0", nil) ||
+		!strings.HasPrefix(got, "FROM ubuntu:22.04\n\nENV DEBIAN_FRONTEND=noninteractive\n\nRUN apt-get update") ||
+		strings.Contains(got, "PIP_BREAK_SYSTEM_PACKAGES") {
+		t.Errorf("default Dockerfile changed:\n%s", got)
+	}
+	df := ServerDockerfileWith(RootfsOptions{BaseImage: "ubuntu:24.04", Python: "3.12"}, "0.36.0", nil)
+	for _, want := range []string{
+		"FROM ubuntu:24.04\n",
+		"ENV PIP_BREAK_SYSTEM_PACKAGES=1\n",
+		"    python3.12 python3.12-venv python3.12-dev \\\n",
+		"RUN python3.12 -m venv /opt/python\nENV PATH=/opt/python/bin:$PATH\n",
+	} {
+		if !strings.Contains(df, want) {
+			t.Errorf("Dockerfile missing %q:\n%s", want, df)
+		}
+	}
+}
+
+func TestGuestJVMArgs(t *testing.T) {
+	if err := ValidateGuestJVMArgs("-Xss4m  -Duser.timezone=UTC"); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateGuestJVMArgs("-Xss4m UTC"); err == nil {
+		t.Error("an argument without - should be rejected")
+	}
+	if err := ValidateGuestJVMArgs(strings.Repeat("-X", maxGuestJVMArgs)); err == nil {
+		t.Error("overlong arguments should be rejected")
+	}
+	if p := guestJVMArgsParam("  "); p != "" {
+		t.Errorf("guestJVMArgsParam of no arguments = %q", p)
+	}
+	p := guestJVMArgsParam("-Xss4m  -Duser.timezone=UTC")
+	enc, ok := strings.CutPrefix(p, " dh_jvm_args=")
+	if !ok {
+		t.Fatalf("guestJVMArgsParam = %q", p)
+	}
+	if dec, _ := base64.StdEncoding.DecodeString(enc); string(dec) != "-Xss4m -Duser.timezone=UTC" {
+		t.Errorf("decoded %q", dec)
+	}
+}
+
+func TestDockerfileScript(t *testing.T) {
+	dockerfile := ServerDockerfile("0.36.0", []string{"deephaven-plugin-ui"}) + `
+COPY init.sh /sbin/init.sh
+RUN chmod +x /sbin/init.sh
+`
+	script, err := DockerfileScript(dockerfile, "/ctx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"set -e\n",
+		"export DEBIAN_FRONTEND=noninteractive\n",
+		"    deephaven-server==0.36.0 \\\n    pydeephaven==0.36.0 \\\n    deephaven-plugin-ui\n",
+		"cp /ctx/init.sh /sbin/init.sh\nchmod +x /sbin/init.sh\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Contains(script, "FROM") || strings.Contains(script, "RUN") {
+		t.Errorf("script kept Dockerfile instructions:\n%s", script)
+	}
+
+	for _, bad := range []string{"WORKDIR /app", "COPY *.py /opt/", "COPY --chown=1 a b"} {
+		if _, err := DockerfileScript(bad, "/ctx"); err == nil {
+			t.Errorf("DockerfileScript(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRootfsManifestRoundtrip(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	os.MkdirAll(paths.RootfsDir, 0o755)
+
+	if _, err := ReadRootfsManifest(paths, "0.36.0"); !os.IsNotExist(err) {
+		t.Fatalf("expected not-exist error for a missing manifest, got %v", err)
+	}
+
+	osName := ParseOSRelease("NAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"22.04\"\n")
+	debs := ParseDpkgList("bash\t5.1-6ubuntu1\nlibc6\t2.35-0ubuntu3\n\n")
+	pips := ParsePipFreeze("deephaven-server==0.36.0\n-e git+https://example/x\n")
+	if osName != "ubuntu 22.04" {
+		t.Errorf("os = %q", osName)
+	}
+	if len(debs) != 2 || debs[1].Name != "libc6" || debs[1].Type != "deb" {
+		t.Errorf("unexpected deb packages %+v", debs)
+	}
+	if len(pips) != 1 || pips[0].Version != "0.36.0" || pips[0].Type != "pypi" {
+		t.Errorf("unexpected pip packages %+v", pips)
+	}
+
+	want := &RootfsManifest{OS: osName, Packages: append(debs, pips...), ReadOnlyRoot: true}
+	if err := WriteRootfsManifest(paths, "0.36.0", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadRootfsManifest(paths, "0.36.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OS != want.OS || len(got.Packages) != 3 || !got.ReadOnlyRoot {
+		t.Errorf("roundtrip mismatch: %+v", got)
+	}
+}
+
+func TestBundledFile(t *testing.T) {
+	paths := NewVMPaths(t.TempDir())
+	if got := paths.BundledFile("vmlinux"); got != "" {
+		t.Errorf("BundledFile with no bundle = %q, want empty", got)
+	}
+
+	dir := filepath.Join(paths.BundlesDir, "0.36.0", "vm")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "vmlinux")
+	if err := os.WriteFile(want, []byte("kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := paths.BundledFile("vmlinux"); got != want {
+		t.Errorf("BundledFile = %q, want %q", got, want)
+	}
+	if got := paths.BundledFile("firecracker"); got != "" {
+		t.Errorf("BundledFile(firecracker) = %q, want empty", got)
+	}
+}
+
+func TestSnapshotMetadata_MachineSize(t *testing.T) {
+	// Snapshots from before the size was recorded have the defaults.
+	if vcpus, mem := (&SnapshotMetadata{}).MachineSize(); vcpus != DefaultVCPUCount || mem != DefaultMemSizeMiB {
+		t.Errorf("MachineSize() of old metadata = %d, %d", vcpus, mem)
+	}
+	if vcpus, mem := (&SnapshotMetadata{VCPUCount: 8, MemSizeMiB: 16384}).MachineSize(); vcpus != 8 || mem != 16384 {
+		t.Errorf("MachineSize() = %d, %d, want 8, 16384", vcpus, mem)
+	}
+	if got := balloonTargetMiB(DefaultMemSizeMiB); got != 4096 {
+		t.Errorf("balloonTargetMiB(default) = %d, want 4096", got)
+	}
+}
+
+func TestCheckMachineSize(t *testing.T) {
+	for _, tt := range []struct {
+		vcpus, mem int
+		ok         bool
+	}{
+		{DefaultVCPUCount, DefaultMemSizeMiB, true},
+		{32, 65536, true},
+		{0, DefaultMemSizeMiB, false},
+		{33, DefaultMemSizeMiB, false},
+		{2, 512, false},
+	} {
+		if err := CheckMachineSize(tt.vcpus, tt.mem); (err == nil) != tt.ok {
+			t.Errorf("CheckMachineSize(%d, %d) = %v, want ok=%v", tt.vcpus, tt.mem, err, tt.ok)
+		}
+	}
+}
+
+func TestExportImportSnapshot(t *testing.T) {
+	src := NewVMPaths(t.TempDir())
+	os.MkdirAll(src.Base, 0o755)
+	if err := os.WriteFile(src.Kernel, []byte("kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, _ := KernelFingerprint(src)
+	snapDir := writeTestSnapshot(t, src, "0.36.0", SnapshotMetadata{
+		Version:      "0.36.0",
+		Firecracker:  FirecrackerVersion,
+		KernelSHA256: sum,
+	})
+	// A disk with a run of zeros, which the import leaves as a hole.
+	disk := append(make([]byte, 3<<20), "ext4"...)
+	if err := os.WriteFile(filepath.Join(snapDir, "disk.ext4"), disk, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "snap.tar.zst")
+	if _, err := ExportSnapshot(src, "0.36.0", archive); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewVMPaths(t.TempDir())
+	m, err := ImportSnapshot(dst, archive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != "0.36.0" {
+		t.Errorf("imported version %q", m.Version)
+	}
+	if problems := VerifySnapshot(dst, "0.36.0"); len(problems) != 0 {
+		t.Errorf("imported snapshot has problems: %v", problems)
+	}
+	got, err := os.ReadFile(filepath.Join(dst.SnapshotDirForVersion("0.36.0"), "disk.ext4"))
+	if err != nil || string(got) != string(disk) {
+		t.Errorf("imported disk differs (%v)", err)
+	}
+
+	if _, err := ImportSnapshot(dst, archive, false); err == nil {
+		t.Error("expected an existing snapshot to be kept without force")
+	}
+	if _, err := ImportSnapshot(dst, archive, true); err != nil {
+		t.Errorf("import with force: %v", err)
+	}
+
+	// A different kernel on the importing machine is not replaced.
+	other := NewVMPaths(t.TempDir())
+	os.MkdirAll(other.Base, 0o755)
+	os.WriteFile(other.Kernel, []byte("other kernel"), 0o644)
+	if _, err := ImportSnapshot(other, archive, false); err == nil {
+		t.Error("expected a kernel mismatch to be refused")
+	}
+	if _, err := os.Stat(other.SnapshotDirForVersion("0.36.0")); !os.IsNotExist(err) {
+		t.Error("refused import left a snapshot behind")
+	}
+}
+
+func TestImportSnapshot_Corrupt(t *testing.T) {
+	m := SnapshotArchive{
+		Format:      SnapshotArchiveFormat,
+		Version:     "0.36.0",
+		Arch:        runtime.GOARCH,
+		Firecracker: FirecrackerVersion,
+		Files:       []string{"metadata.json"},
+	}
+	manifest, _ := json.Marshal(m)
+	meta := []byte(`{"version":"0.36.0"}`)
+
+	// writeArchive writes a snapshot archive with the given entries, in order.
+	writeArchive := func(entries ...[2]string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "snap.tar.zst")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		zw, _ := zstd.NewWriter(f)
+		tw := tar.NewWriter(zw)
+		for _, e := range entries {
+			if err := writeTarBytes(tw, e[0], []byte(e[1]), time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		zw.Close()
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		entries [][2]string
+	}{
+		{"no manifest", [][2]string{{"metadata.json", string(meta)}}},
+		{"no checksums", [][2]string{{"manifest.json", string(manifest)}, {"metadata.json", string(meta)}}},
+		{"bad checksum", [][2]string{{"manifest.json", string(manifest)}, {"metadata.json", string(meta)}, {"SHA256SUMS", strings.Repeat("0", 64) + "  metadata.json\n"}}},
+		{"unexpected entry", [][2]string{{"manifest.json", string(manifest)}, {"../evil", "x"}}},
+	}
+	for _, tt := range tests {
+		paths := NewVMPaths(t.TempDir())
+		if _, err := ImportSnapshot(paths, writeArchive(tt.entries...), false); err == nil {
+			t.Errorf("%s: expected the import to fail", tt.name)
+		}
+		if _, err := os.Stat(paths.SnapshotDirForVersion("0.36.0")); !os.IsNotExist(err) {
+			t.Errorf("%s: failed import left a snapshot behind", tt.name)
+		}
+	}
+}
+
+func TestPoolServiceUnit(t *testing.T) {
+	unit := PoolServiceUnit(PoolServiceConfig{
+		Executable: "/opt/dh tools/dh",
+		DHHome:     "/home/u/.dh",
+		SocketPath: "/tmp/dh-pool-1000.sock",
+		Versions:   []string{"0.37.0", "0.36.0"},
+		TargetSize: 2,
+	})
+	for _, want := range []string{
+		"Environment=DH_HOME=/home/u/.dh\n",
+		`ExecStart="/opt/dh tools/dh" vm pool start -n 2 --idle-timeout 0s --version 0.37.0 --version 0.36.0` + "\n",
+		"ExecStopPost=/bin/rm -f /tmp/dh-pool-1000.sock\n",
+		"Restart=on-failure\n",
+		"WantedBy=default.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit lacks %q:\n%s", want, unit)
+		}
+	}
+	if strings.Contains(unit, "--metrics-addr") {
+		t.Errorf("unit has --metrics-addr without one set:\n%s", unit)
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	tests := map[string]string{
+		"0.36.0":    "0.36.0",
+		"/a b/dh":   `"/a b/dh"`,
+		"100%":      "100%%",
+		"$HOME/.dh": "$$HOME/.dh",
+		`say "hi"`:  `"say \"hi\""`,
+		"":          `""`,
+	}
+	for in, want := range tests {
+		if got := systemdQuote(in); got != want {
+			t.Errorf("systemdQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPoolServicePath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/home/u/.config-alt")
+	got, err := PoolServicePath()
+	if err != nil || got != "/home/u/.config-alt/systemd/user/dh-pool.service" {
+		t.Errorf("PoolServicePath() = %q, %v", got, err)
+	}
+}
+
+func TestResolveWritePaths(t *testing.T) {
+	got, err := ResolveWritePaths("/home/u/proj", []string{".", "out", "/home/u/proj/results/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/home/u/proj", "/home/u/proj/out", "/home/u/proj/results"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ResolveWritePaths = %v, want %v", got, want)
+	}
+	for _, p := range []string{"..", "../other", "/tmp"} {
+		if _, err := ResolveWritePaths("/home/u/proj", []string{p}); err == nil {
+			t.Errorf("ResolveWritePaths(%q) allowed a path outside the working directory", p)
+		}
+	}
+}
+
+func TestHugePagesShortfall(t *testing.T) {
+	meminfo := func(total, free, sizeKB int) string {
+		return fmt.Sprintf("MemTotal:       16314236 kB\nHugePages_Total:    %d\nHugePages_Free:     %d\nHugepagesize:       %d kB\n", total, free, sizeKB)
+	}
+	if got := hugePagesNeeded(DefaultMemSizeMiB); got != 2304 {
+		t.Errorf("hugePagesNeeded(%d) = %d, want 2304", DefaultMemSizeMiB, got)
+	}
+	if err := hugePagesShortfall(meminfo(2304, 2304, 2048), DefaultMemSizeMiB); err != nil {
+		t.Errorf("expected enough huge pages, got %v", err)
+	}
+	err := hugePagesShortfall(meminfo(2400, 2000, 2048), DefaultMemSizeMiB)
+	if err == nil || !strings.Contains(err.Error(), "vm.nr_hugepages=2704") {
+		t.Errorf("expected a shortfall of 304 pages, got %v", err)
+	}
+	if err := hugePagesShortfall(meminfo(4, 4, 1048576), 1024); err == nil || !strings.Contains(err.Error(), "1048576 kB") {
+		t.Errorf("expected a page size error, got %v", err)
+	}
+}
+
+func TestParseNetMode(t *testing.T) {
+	for in, want := range map[string]string{"": "", "none": "", "nat": NetNAT} {
+		if got, err := ParseNetMode(in); err != nil || got != want {
+			t.Errorf("ParseNetMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseNetMode("bridge"); err == nil || !strings.Contains(err.Error(), "use none or nat") {
+		t.Errorf("expected an invalid --net error, got %v", err)
+	}
+	if got := netKernelArgs(NetNAT, "192.0.2.53"); got != " dh_net=nat dh_dns=192.0.2.53" {
+		t.Errorf("netKernelArgs = %q", got)
+	}
+	if got := netKernelArgs("", "192.0.2.53"); got != "" {
+		t.Errorf("netKernelArgs without a network = %q, want none", got)
+	}
+}
+
+func TestHostNameserver(t *testing.T) {
+	for conf, want := range map[string]string{
+		"nameserver 127.0.0.53\noptions edns0\n":               "",
+		"# comment\nnameserver ::1\nnameserver 192.0.2.53\n":   "192.0.2.53",
+		"search example.com\nnameserver 10.0.0.2 \n":           "10.0.0.2",
+		"nameserver 2001:db8::53\nnameserver 198.51.100.1\n\n": "198.51.100.1",
+	} {
+		if got := hostNameserver(conf); got != want {
+			t.Errorf("hostNameserver(%q) = %q, want %q", conf, got, want)
+		}
+	}
+}
+
+func TestVMNetCommands(t *testing.T) {
+	subnet, host, peer := netVethAddrs(1)
+	if subnet.String() != "10.201.0.4" || host.String() != "10.201.0.5" || peer.String() != "10.201.0.6" {
+		t.Errorf("netVethAddrs(1) = %s, %s, %s", subnet, host, peer)
+	}
+	if subnet, _, _ := netVethAddrs(netMaxIndex); subnet.String() != "10.201.255.252" {
+		t.Errorf("netVethAddrs(%d) = %s, want the last /30 of 10.201.0.0/16", netMaxIndex, subnet)
+	}
+
+	// Every host rule set up is deleted again, and the namespace last.
+	var added, deleted []string
+	for _, args := range netSetupCommands(3) {
+		if args[0] == "iptables" && args[1] == "-A" {
+			added = append(added, strings.Join(args[2:], " "))
+		}
+	}
+	teardown := netTeardownCommands(3)
+	for _, args := range teardown {
+		if args[0] == "iptables" && args[1] == "-D" {
+			deleted = append(deleted, strings.Join(args[2:], " "))
+		}
+	}
+	if len(added) == 0 || !slices.Equal(added, deleted) {
+		t.Errorf("host rules added %q, deleted %q", added, deleted)
+	}
+	if last := strings.Join(teardown[len(teardown)-1], " "); last != "ip netns del dhnet3" {
+		t.Errorf("last teardown command = %q", last)
+	}
+}
+
+func TestHostResourcesFit(t *testing.T) {
+	r := &HostResources{MemAvailableMiB: 8192, DiskFreeMiB: 10240, DiskPath: "/home"}
+	if got := r.VMsFit(1024); got != 7 {
+		t.Errorf("VMsFit(1024) = %d, want 7 (8192 MiB less the host's 1024)", got)
+	}
+	if err := r.CheckVMs(7, 1024); err != nil {
+		t.Errorf("CheckVMs(7): %v", err)
+	}
+	err := r.CheckVMs(8, 1024)
+	var resErr *ResourceError
+	if !errors.As(err, &resErr) || !strings.Contains(err.Error(), "not enough memory for 8 VMs") {
+		t.Errorf("CheckVMs(8) = %v", err)
+	}
+
+	// The cgroup's limit wins over the host's memory.
+	r.CgroupLimitMiB, r.CgroupFreeMiB = 4096, 3072
+	if got := r.VMsFit(1024); got != 2 {
+		t.Errorf("VMsFit(1024) in a cgroup = %d, want 2", got)
+	}
+	if err := r.CheckVMs(3, 1024); err == nil || !strings.Contains(err.Error(), "cgroup limit 4096 MiB, 3072 MiB free") {
+		t.Errorf("CheckVMs(3) in a cgroup = %v", err)
+	}
+
+	// Huge page VMs take no host memory, only disk.
+	r.DiskFreeMiB = 600
+	if got := r.VMsFit(0); got != 1 {
+		t.Errorf("VMsFit(0) with 600 MiB of disk = %d, want 1", got)
+	}
+	if err := r.CheckVMs(2, 0); err == nil || !strings.Contains(err.Error(), "not enough disk for 2 VMs: /home") {
+		t.Errorf("CheckVMs(2) short of disk = %v", err)
+	}
+
+	if got := VMMemEstimateMiB(&SnapshotMetadata{MemSizeMiB: 4608, BalloonMiB: 4096}); got != 1024 {
+		t.Errorf("VMMemEstimateMiB = %d, want 1024", got)
+	}
+	if got := VMMemEstimateMiB(&SnapshotMetadata{}); got != DefaultMemSizeMiB {
+		t.Errorf("VMMemEstimateMiB without a balloon = %d, want %d", got, DefaultMemSizeMiB)
+	}
+	if got := VMMemEstimateMiB(&SnapshotMetadata{HugePages: true}); got != 0 {
+		t.Errorf("VMMemEstimateMiB with huge pages = %d, want 0", got)
+	}
+}
+
+func TestCgroupMemory(t *testing.T) {
+	cg, ok := cgroupV2Path("12:cpu:/x\n0::/user.slice/dh.scope\n")
+	if !ok || cg != "/user.slice/dh.scope" {
+		t.Fatalf("cgroupV2Path = %q, %v", cg, ok)
+	}
+	if _, ok := cgroupV2Path("4:memory:/docker/abc\n"); ok {
+		t.Error("cgroupV2Path found a path in a cgroup v1 file")
+	}
+
+	root := t.TempDir()
+	write := func(dir, name, value string) {
+		os.MkdirAll(filepath.Join(root, dir), 0o755)
+		os.WriteFile(filepath.Join(root, dir, name), []byte(value+"\n"), 0o644)
+	}
+	write("user.slice/dh.scope", "memory.max", "max")
+	write("user.slice/dh.scope", "memory.current", "1048576")
+	if limit, free := cgroupMemory(root, cg); limit != 0 || free != 0 {
+		t.Errorf("cgroupMemory without limits = %d, %d", limit, free)
+	}
+
+	write("user.slice", "memory.max", "8589934592")     // 8 GiB
+	write("user.slice", "memory.current", "6442450944") // 6 GiB
+	write("", "memory.max", "4294967296")               // 4 GiB, with 3 GiB free
+	write("", "memory.current", "1073741824")
+	if limit, free := cgroupMemory(root, cg); limit != 8192 || free != 2048 {
+		t.Errorf("cgroupMemory = %d, %d, want the tightest: 8192, 2048", limit, free)
+	}
+}
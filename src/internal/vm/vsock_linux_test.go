@@ -0,0 +1,411 @@
+//go:build linux
+
+package vm
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRunner serves one connection on a Firecracker-style vsock UDS: it
+// answers CONNECT, decodes the request, and writes lines as the response.
+func fakeRunner(t *testing.T, lines ...string) (string, <-chan VsockRequest) {
+	t.Helper()
+	return fakeRunnerFunc(t, func(_ *bufio.Reader, conn net.Conn) {
+		for _, l := range lines {
+			conn.Write([]byte(l + "\n"))
+		}
+	})
+}
+
+// currentHello is the hello of a runner with every feature.
+const currentHello = `{"type": "hello", "protocol": 1, "features": ["stream", "cancel", "collect_outputs", "fuse", "table_format", "shell", "import_tables", "export_tables"]}`
+
+// fakeRunnerFunc is fakeRunner with the response written by respond, which
+// can also read what the host sends after the request.
+func fakeRunnerFunc(t *testing.T, respond func(r *bufio.Reader, conn net.Conn)) (string, <-chan VsockRequest) {
+	t.Helper()
+	return fakeRunnerHello(t, currentHello, respond)
+}
+
+// fakeRunnerHello is fakeRunnerFunc answering hellos with hello, or, when
+// it is empty, as runners from before the hello do: with the response to
+// a request without code, before hanging up.
+func fakeRunnerHello(t *testing.T, hello string, respond func(r *bufio.Reader, conn net.Conn)) (string, <-chan VsockRequest) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vsock.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	reqCh := make(chan VsockRequest, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			if _, err := r.ReadString('\n'); err != nil { // CONNECT <port>
+				conn.Close()
+				return
+			}
+			conn.Write([]byte("OK 1\n"))
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if strings.Contains(string(line), `"type":"hello"`) {
+				if hello == "" {
+					conn.Write([]byte(`{"exit_code": 0, "stdout": "", "stderr": "", "result_repr": null, "error": null, "tables": []}` + "\n"))
+					conn.Close()
+					continue
+				}
+				conn.Write([]byte(hello + "\n"))
+				if line, err = r.ReadBytes('\n'); err != nil {
+					conn.Close()
+					return
+				}
+			}
+			var req VsockRequest
+			json.Unmarshal(line, &req)
+			reqCh <- req
+			respond(r, conn)
+			conn.Close()
+			return
+		}
+	}()
+	return path, reqCh
+}
+
+func TestExecuteViaVsockStream(t *testing.T) {
+	path, reqCh := fakeRunner(t,
+		`{"type": "stdout", "data": "one\n"}`,
+		`{"type": "stderr", "data": "warn\n"}`,
+		`{"type": "stdout", "data": "two"}`,
+		`{"exit_code": 0, "streamed": true, "stdout": "", "stderr": "", "result_repr": null, "error": null, "tables": []}`,
+	)
+
+	var got []string
+	resp, err := ExecuteViaVsockStream(context.Background(), path, VsockPort, &VsockRequest{Code: "x"}, func(stream, data string) {
+		got = append(got, stream+":"+data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := <-reqCh; !req.Stream {
+		t.Error("request did not ask to stream")
+	}
+	if want := "stdout:one\n|stderr:warn\n|stdout:two"; strings.Join(got, "|") != want {
+		t.Errorf("frames = %q, want %q", strings.Join(got, "|"), want)
+	}
+	if !resp.Streamed || resp.Stdout != "one\ntwo" || resp.Stderr != "warn\n" {
+		t.Errorf("resp = streamed %v, stdout %q, stderr %q", resp.Streamed, resp.Stdout, resp.Stderr)
+	}
+}
+
+func TestExecuteViaVsockStream_OldRunner(t *testing.T) {
+	// Runners in older snapshots ignore "stream" and send one line.
+	path, _ := fakeRunner(t,
+		`{"exit_code": 0, "stdout": "all at once\n", "stderr": "", "result_repr": null, "error": null, "tables": []}`,
+	)
+
+	called := false
+	resp, err := ExecuteViaVsockStream(context.Background(), path, VsockPort, &VsockRequest{Code: "x"}, func(string, string) { called = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called || resp.Streamed || resp.Stdout != "all at once\n" {
+		t.Errorf("called %v, resp = streamed %v, stdout %q", called, resp.Streamed, resp.Stdout)
+	}
+}
+
+func TestExecuteViaVsockStream_Hello(t *testing.T) {
+	for _, tc := range []struct {
+		name, hello string
+		protocol    int
+		stream      bool
+		tableFormat string
+	}{
+		{"current", currentHello, 1, true, "arrow"},
+		{"no features", `{"type": "hello", "protocol": 1, "features": ["cancel"]}`, 1, false, ""},
+		{"before the hello", "", 0, true, "arrow"},
+	} {
+		path, reqCh := fakeRunnerHello(t, tc.hello, func(_ *bufio.Reader, conn net.Conn) {
+			conn.Write([]byte(`{"exit_code": 0, "stdout": "ok\n", "stderr": "", "result_repr": null, "error": null, "tables": []}` + "\n"))
+		})
+		resp, err := ExecuteViaVsockStream(context.Background(), path, VsockPort, &VsockRequest{Code: "x", TableFormat: "arrow"}, func(string, string) {})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		req := <-reqCh
+		if req.Code != "x" || req.Stream != tc.stream || req.TableFormat != tc.tableFormat {
+			t.Errorf("%s: request = %+v", tc.name, req)
+		}
+		if resp.Stdout != "ok\n" || resp.Runner == nil || resp.Runner.Protocol != tc.protocol {
+			t.Errorf("%s: resp = %+v, runner %+v", tc.name, resp, resp.Runner)
+		}
+	}
+
+	if h := (&RunnerHello{}); !h.Supports(FeatureShell) {
+		t.Error("a runner from before the hello is not assumed to support features")
+	}
+}
+
+func TestExecuteViaVsockStream_ImportTables(t *testing.T) {
+	imports := []TableImport{{Name: "trades", Path: "/workspace/trades.csv", Format: "csv"}}
+	for _, tc := range []struct {
+		name, hello string
+		ok          bool
+	}{
+		{"current", currentHello, true},
+		{"no import_tables", `{"type": "hello", "protocol": 1, "features": ["stream", "cancel"]}`, false},
+		{"before the hello", "", false},
+	} {
+		path, reqCh := fakeRunnerHello(t, tc.hello, func(_ *bufio.Reader, conn net.Conn) {
+			conn.Write([]byte(`{"exit_code": 0, "stdout": "", "stderr": "", "result_repr": null, "error": null, "tables": []}` + "\n"))
+		})
+		_, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "x", ImportTables: imports})
+		if !tc.ok {
+			// Sending the code without its tables would only fail later.
+			if err == nil || !strings.Contains(err.Error(), "can't import tables") {
+				t.Errorf("%s: err = %v, want a refusal", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if req := <-reqCh; len(req.ImportTables) != 1 || req.ImportTables[0] != imports[0] {
+			t.Errorf("%s: import_tables = %+v", tc.name, req.ImportTables)
+		}
+	}
+}
+
+func TestExecuteViaVsockStream_ExportTables(t *testing.T) {
+	exports := []TableExport{{Name: "result", Format: "csv"}}
+	path, reqCh := fakeRunner(t,
+		`{"type": "export", "name": "result", "data": "`+base64.StdEncoding.EncodeToString([]byte("A,B\n"))+`"}`,
+		`{"type": "export", "name": "result", "data": "`+base64.StdEncoding.EncodeToString([]byte("1,2\n"))+`"}`,
+		`{"exit_code": 0, "stdout": "", "stderr": "", "tables": [], "exported_tables": [{"name": "result", "size": 8}]}`,
+	)
+	resp, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "x", ExportTables: exports})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := <-reqCh; len(req.ExportTables) != 1 || req.ExportTables[0] != exports[0] {
+		t.Errorf("export_tables = %+v", req.ExportTables)
+	}
+	if len(resp.ExportedTables) != 1 || string(resp.ExportedTables[0].Data) != "A,B\n1,2\n" {
+		t.Errorf("exported tables = %+v", resp.ExportedTables)
+	}
+
+	// A table cut short is an error, not a truncated file.
+	path, _ = fakeRunner(t,
+		`{"type": "export", "name": "result", "data": "`+base64.StdEncoding.EncodeToString([]byte("A,B\n"))+`"}`,
+		`{"exit_code": 0, "stdout": "", "stderr": "", "tables": [], "exported_tables": [{"name": "result", "size": 8}]}`,
+	)
+	if _, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "x", ExportTables: exports}); err == nil {
+		t.Error("an incomplete export was accepted")
+	}
+
+	// Runners without the feature are refused, as for imports.
+	path, _ = fakeRunnerHello(t, `{"type": "hello", "protocol": 1, "features": ["stream", "import_tables"]}`, func(_ *bufio.Reader, conn net.Conn) {
+		conn.Write([]byte(`{"exit_code": 0, "stdout": "", "stderr": "", "result_repr": null, "error": null, "tables": []}` + "\n"))
+	})
+	if _, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "x", ExportTables: exports}); err == nil || !strings.Contains(err.Error(), "can't export tables") {
+		t.Errorf("err = %v, want a refusal", err)
+	}
+}
+
+func TestExecuteViaVsockStream_Outputs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	outputs, err := OpenOutputDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outputs.Close()
+	os.WriteFile(filepath.Join(dir, "old.png"), []byte("stale"), 0o644)
+
+	path, reqCh := fakeRunner(t,
+		`{"type": "file", "path": "old.png", "data": "`+base64.StdEncoding.EncodeToString([]byte("new"))+`"}`,
+		`{"type": "file", "path": "plots/a.csv", "data": "`+base64.StdEncoding.EncodeToString([]byte("x,y\n"))+`"}`,
+		`{"type": "file", "path": "plots/a.csv", "data": "`+base64.StdEncoding.EncodeToString([]byte("1,2\n"))+`"}`,
+		`{"type": "file", "path": "empty", "data": ""}`,
+		`{"exit_code": 0, "stdout": "", "stderr": "", "tables": [], "outputs": [{"path": "old.png", "size": 3}, {"path": "plots/a.csv", "size": 8}, {"path": "empty", "size": 0}]}`,
+	)
+	resp, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "x", Outputs: outputs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := <-reqCh; !req.CollectOutputs || req.Stream {
+		t.Errorf("request = %+v", req)
+	}
+	if len(resp.Outputs) != 3 {
+		t.Errorf("outputs = %+v", resp.Outputs)
+	}
+	for name, want := range map[string]string{"old.png": "new", "plots/a.csv": "x,y\n1,2\n", "empty": ""} {
+		if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestExecuteViaVsockStream_OutputsRejected(t *testing.T) {
+	outside := t.TempDir()
+	dir := t.TempDir()
+	os.Symlink(outside, filepath.Join(dir, "link"))
+	for _, tc := range []struct{ name, lines string }{
+		{"escape", `{"type": "file", "path": "../x", "data": ""}`},
+		{"symlink", `{"type": "file", "path": "link/x", "data": ""}`},
+		{"truncated", `{"exit_code": 0, "tables": [], "outputs": [{"path": "big", "size": 10}]}`},
+		{"unrequested", `{"type": "file", "path": "x", "data": ""}`},
+	} {
+		outputs, err := OpenOutputDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := &VsockRequest{Code: "x", Outputs: outputs}
+		if tc.name == "unrequested" {
+			req.Outputs = nil
+		}
+		path, _ := fakeRunner(t, tc.lines)
+		if _, err := ExecuteViaVsock(path, VsockPort, req); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+		outputs.Close()
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("files written outside the output directory: %v", entries)
+	}
+}
+
+func TestExecuteViaVsockStream_ArrowTables(t *testing.T) {
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	path, reqCh := fakeRunner(t,
+		`{"type": "table", "name": "t", "data": "`+b64("ARROW1")+`"}`,
+		`{"type": "table", "name": "t", "data": "`+b64("rest")+`"}`,
+		`{"type": "table", "name": "u", "data": ""}`,
+		`{"exit_code": 0, "stdout": "", "stderr": "", "tables": [], "arrow_tables": [{"name": "t", "size": 10}, {"name": "u", "size": 0}]}`,
+	)
+	resp, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "t = x", TableFormat: "arrow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := <-reqCh; req.TableFormat != "arrow" {
+		t.Errorf("request = %+v", req)
+	}
+	if len(resp.ArrowTables) != 2 || string(resp.ArrowTables[0].Data) != "ARROW1rest" || len(resp.ArrowTables[1].Data) != 0 {
+		t.Errorf("arrow tables = %+v", resp.ArrowTables)
+	}
+
+	for _, tc := range []struct {
+		name, format, line string
+	}{
+		{"truncated", "arrow", `{"exit_code": 0, "tables": [], "arrow_tables": [{"name": "t", "size": 4}]}`},
+		{"unrequested", "", `{"type": "table", "name": "t", "data": ""}`},
+	} {
+		path, _ := fakeRunner(t, tc.line)
+		if _, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "x", TableFormat: tc.format}); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
+
+func TestExecuteViaVsock_NoStream(t *testing.T) {
+	path, reqCh := fakeRunner(t, `{"exit_code": 1, "stdout": "", "stderr": "", "error": "boom", "tables": []}`)
+
+	resp, err := ExecuteViaVsock(path, VsockPort, &VsockRequest{Code: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := <-reqCh; req.Stream {
+		t.Error("ExecuteViaVsock asked to stream")
+	}
+	if resp.ExitCode != 1 || resp.Error == nil || *resp.Error != "boom" {
+		t.Errorf("resp = %+v", resp)
+	}
+}
+
+func TestExecuteViaVsockStream_Interrupt(t *testing.T) {
+	path, _ := fakeRunnerFunc(t, func(r *bufio.Reader, conn net.Conn) {
+		conn.Write([]byte(`{"type": "stdout", "data": "working\n"}` + "\n"))
+		line, err := r.ReadString('\n')
+		if err != nil || !strings.Contains(line, `"cancel"`) {
+			return
+		}
+		conn.Write([]byte(`{"exit_code": 130, "streamed": true, "interrupted": true, "error": "KeyboardInterrupt", "tables": []}` + "\n"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := ExecuteViaVsockStream(ctx, path, VsockPort, &VsockRequest{Code: "x"}, func(string, string) { cancel() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Interrupted || resp.ExitCode != 130 || resp.Stdout != "working\n" {
+		t.Errorf("resp = interrupted %v, exit %d, stdout %q", resp.Interrupted, resp.ExitCode, resp.Stdout)
+	}
+}
+
+func TestExecuteViaVsockStream_InterruptOldRunner(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits out the interrupt grace period")
+	}
+	// Runners in older snapshots never answer a cancel frame.
+	path, _ := fakeRunnerFunc(t, func(r *bufio.Reader, conn net.Conn) {
+		io.Copy(io.Discard, r)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := ExecuteViaVsockStream(ctx, path, VsockPort, &VsockRequest{Code: "x"}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > vsockInterruptGrace+time.Second {
+		t.Errorf("gave up after %v, want about %v", d, vsockInterruptGrace)
+	}
+}
+
+func TestOpenShell(t *testing.T) {
+	path, _ := fakeRunnerFunc(t, func(r *bufio.Reader, conn net.Conn) {
+		// The first bytes of the session follow the ack in the same write.
+		conn.Write([]byte(`{"type": "shell", "pid": 42}` + "\n$ "))
+		line, _ := r.ReadString('\n')
+		conn.Write([]byte("got " + line))
+	})
+
+	shell, err := OpenShell(path, VsockPort, 40, 120, "xterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shell.Close()
+	shell.Write([]byte("ls\n"))
+	got, _ := io.ReadAll(shell)
+	if string(got) != "$ got ls\n" {
+		t.Errorf("session = %q, want %q", got, "$ got ls\n")
+	}
+}
+
+func TestOpenShell_OldRunner(t *testing.T) {
+	// Runners in older snapshots treat the request as empty code.
+	path, _ := fakeRunner(t, `{"exit_code": 0, "stdout": "", "stderr": "", "result_repr": null, "error": null, "tables": []}`)
+
+	if _, err := OpenShell(path, VsockPort, 24, 80, ""); err == nil || !strings.Contains(err.Error(), "dh vm prepare") {
+		t.Errorf("err = %v, want a hint to run dh vm prepare", err)
+	}
+}
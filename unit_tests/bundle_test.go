@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/bundle"
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestBundle builds a bundle with stubbed downloads and returns its path.
+func createTestBundle(t *testing.T, jdk int) string {
+	t.Helper()
+	origWheels, origJDK := bundle.DownloadWheels, bundle.DownloadJDK
+	bundle.DownloadWheels = func(_ context.Context, _, version, _ string, _ []string, dest string, _ io.Writer) error {
+		return os.WriteFile(filepath.Join(dest, "deephaven_server-"+version+"-py3-none-any.whl"), []byte("wheel"), 0o644)
+	}
+	bundle.DownloadJDK = func(_ int, w io.Writer) error {
+		_, err := w.Write([]byte("jdk"))
+		return err
+	}
+	t.Cleanup(func() { bundle.DownloadWheels, bundle.DownloadJDK = origWheels, origJDK })
+
+	uv := filepath.Join(t.TempDir(), "uv")
+	require.NoError(t, os.WriteFile(uv, []byte("#!/bin/sh\n"), 0o755))
+
+	out := filepath.Join(t.TempDir(), "bundle.tar")
+	m, err := bundle.Create(context.Background(), bundle.CreateOptions{
+		DHHome:     t.TempDir(),
+		Version:    "0.36.0",
+		Python:     "3.12",
+		Plugins:    []string{"deephaven-plugin-ui"},
+		Tool:       "test",
+		UV:         uv,
+		JDKVersion: jdk,
+	}, out, nil, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.Wheels)
+	return out
+}
+
+func tarNames(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestBundleCreateLayout(t *testing.T) {
+	path := createTestBundle(t, 21)
+
+	names := tarNames(t, path)
+	require.NotEmpty(t, names)
+	assert.Equal(t, bundle.ManifestName, names[0])
+	assert.Contains(t, names, "wheels/deephaven_server-0.36.0-py3-none-any.whl")
+	assert.Contains(t, names, "bin/uv")
+	assert.Contains(t, names, "jdk/temurin-21.tar.gz")
+	assert.False(t, slices.Contains(names, "vm/firecracker"))
+
+	m, err := bundle.ReadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, "0.36.0", m.Version)
+	assert.Equal(t, "3.12", m.Python)
+	assert.Equal(t, []string{"deephaven-plugin-ui"}, m.Plugins)
+	assert.Equal(t, runtime.GOOS, m.OS)
+	assert.Equal(t, runtime.GOARCH, m.Arch)
+	assert.Equal(t, 21, m.JDK)
+	assert.True(t, m.UV)
+	assert.False(t, m.VM)
+}
+
+func TestBundleReadManifestNotABundle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.tar")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, tar.NewWriter(f).Close())
+	f.Close()
+
+	_, err = bundle.ReadManifest(path)
+	assert.ErrorContains(t, err, "not a dh bundle")
+}
+
+func TestBundleInstallUsesBundledWheels(t *testing.T) {
+	path := createTestBundle(t, 0)
+	dhHome := t.TempDir()
+
+	var calls [][]string
+	orig := versions.ExecCommand
+	versions.ExecCommand = func(name string, arg ...string) *exec.Cmd {
+		calls = append(calls, append([]string{name}, arg...))
+		return exec.Command("/bin/sh", "-c", "true")
+	}
+	defer func() { versions.ExecCommand = orig }()
+	// No uv on PATH, so the bundled one is installed and used.
+	t.Setenv("PATH", "")
+
+	res, err := bundle.Install(context.Background(), dhHome, path, nil, io.Discard)
+	require.NoError(t, err)
+	assert.True(t, res.VersionInstalled)
+	assert.False(t, res.JavaInstalled)
+	assert.Equal(t, versions.BundleDir(dhHome, "0.36.0"), res.Dir)
+
+	uv := filepath.Join(dhHome, "bin", "uv")
+	assert.FileExists(t, uv)
+	assert.Equal(t, uv, versions.UVCommand(dhHome))
+
+	wheels := versions.BundledWheels(dhHome, "0.36.0")
+	require.NotEmpty(t, wheels)
+	assert.FileExists(t, filepath.Join(wheels, "deephaven_server-0.36.0-py3-none-any.whl"))
+
+	require.Len(t, calls, 2)
+	assert.Equal(t, uv, calls[0][0])
+	assert.Contains(t, calls[0], "--offline")
+	pip := calls[1]
+	assert.Equal(t, uv, pip[0])
+	i := slices.Index(pip, "--find-links")
+	require.GreaterOrEqual(t, i, 0)
+	assert.Equal(t, wheels, pip[i+1])
+	assert.Contains(t, pip, "--no-index")
+	assert.Contains(t, pip, "deephaven-plugin-ui")
+
+	// A second install only refreshes the unpacked bundle.
+	res, err = bundle.Install(context.Background(), dhHome, path, nil, io.Discard)
+	require.NoError(t, err)
+	assert.False(t, res.VersionInstalled)
+	assert.Len(t, calls, 2)
+}
+
+func TestInstallWithoutBundleUsesIndex(t *testing.T) {
+	tmp := t.TempDir()
+
+	var pip []string
+	orig := versions.ExecCommand
+	versions.ExecCommand = func(name string, arg ...string) *exec.Cmd {
+		if len(arg) > 0 && arg[0] == "pip" {
+			pip = arg
+		}
+		return exec.Command("true")
+	}
+	defer func() { versions.ExecCommand = orig }()
+
+	require.NoError(t, versions.Install(tmp, "0.36.0", "3.13", nil, nil))
+	assert.NotContains(t, pip, "--no-index")
+	assert.Empty(t, versions.BundledWheels(tmp, "0.36.0"))
+}
+
+func TestBundledJDK(t *testing.T) {
+	dhHome := t.TempDir()
+	assert.Empty(t, java.BundledJDK(dhHome, 21))
+
+	dir := filepath.Join(versions.BundleDir(dhHome, "0.36.0"), "jdk")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, java.JDKArchiveName(21)), nil, 0o644))
+
+	assert.Equal(t, filepath.Join(dir, "temurin-21.tar.gz"), java.BundledJDK(dhHome, 21))
+	assert.Empty(t, java.BundledJDK(dhHome, 17))
+}
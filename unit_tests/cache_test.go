@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+		"90m": 90 * time.Minute,
+		"0d":  0,
+	} {
+		got, err := cache.ParseAge(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "d", "-1d", "30 days", "1y"} {
+		_, err := cache.ParseAge(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestCacheFormatSize(t *testing.T) {
+	assert.Equal(t, "512 B", cache.FormatSize(512))
+	assert.Equal(t, "1.5 KiB", cache.FormatSize(1536))
+	assert.Equal(t, "2.0 GiB", cache.FormatSize(2<<30))
+}
+
+func TestCacheLookup(t *testing.T) {
+	c, err := cache.Lookup("snapshots")
+	require.NoError(t, err)
+	assert.Equal(t, "snapshots", c.Name)
+
+	_, err = cache.Lookup("nope")
+	assert.ErrorContains(t, err, `unknown cache "nope"`)
+	assert.ErrorContains(t, err, "vm-downloads")
+}
+
+func TestCacheEntries(t *testing.T) {
+	home := t.TempDir()
+	snap := filepath.Join(home, "vm", "snapshots", "0.35.1")
+	require.NoError(t, os.MkdirAll(snap, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(snap, "snapshot_mem"), make([]byte, 8192), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "cache", "release-notes"), 0o755))
+	notes := filepath.Join(home, "cache", "release-notes", "0.35.1.json")
+	require.NoError(t, os.WriteFile(notes, []byte("{}"), 0o644))
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	require.NoError(t, os.Chtimes(notes, old, old))
+
+	c, _ := cache.Lookup("snapshots")
+	entries := c.Entries(home)
+	require.Len(t, entries, 1)
+	assert.Equal(t, snap, entries[0].Path)
+	assert.Equal(t, "snapshots", entries[0].Category)
+	assert.Greater(t, entries[0].SizeBytes, int64(0))
+	assert.WithinDuration(t, time.Now(), entries[0].Modified, time.Minute)
+
+	c, _ = cache.Lookup("metadata")
+	entries = c.Entries(home)
+	require.Len(t, entries, 1)
+	assert.Equal(t, notes, entries[0].Path)
+	assert.WithinDuration(t, old, entries[0].Modified, time.Second)
+
+	require.NoError(t, c.Remove(entries[0]))
+	assert.NoFileExists(t, notes)
+	assert.Empty(t, c.Entries(home))
+
+	c, _ = cache.Lookup("vm-downloads")
+	assert.Empty(t, c.Entries(home))
+}
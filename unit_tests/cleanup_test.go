@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/cleanup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupClassifyCmdline(t *testing.T) {
+	runDir := "/home/u/.dh/vm/run"
+	runner := `"""Embedded runner for dh exec/serve. Executed via python -c, reads user code from stdin."""`
+
+	for _, tc := range []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"/home/u/.dh/versions/0.36.0/.venv/bin/python", "-c", runner, "--mode", "embedded"}, cleanup.KindRunner},
+		{[]string{"python", "-c", "print(1)"}, ""},
+		{[]string{"/home/u/.dh/vm/firecracker", "--api-sock", runDir + "/exec-1/firecracker.sock"}, cleanup.KindVMM},
+		{[]string{"firecracker", "--api-sock=" + runDir + "/pool-2/firecracker.sock"}, cleanup.KindVMM},
+		// Firecracker that dh didn't start
+		{[]string{"firecracker", "--api-sock", "/tmp/fc.sock"}, ""},
+		{[]string{"firecracker"}, ""},
+		{nil, ""},
+	} {
+		assert.Equal(t, tc.want, cleanup.ClassifyCmdline(tc.argv, runDir), "%q", tc.argv)
+	}
+}
+
+func writeInstance(t *testing.T, dir, json string, age time.Duration) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	if json != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "instance.json"), []byte(json), 0o644))
+	}
+	old := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(dir, old, old))
+}
+
+func TestCleanupFindQuick(t *testing.T) {
+	home := t.TempDir()
+	run := filepath.Join(home, "vm", "run")
+	writeInstance(t, filepath.Join(run, "dead"), `{"id":"dead","pid":2147483647}`, 0)
+	writeInstance(t, filepath.Join(run, "alive"), fmt.Sprintf(`{"id":"alive","pid":%d}`, os.Getpid()), 0)
+	writeInstance(t, filepath.Join(run, "starting"), "", time.Minute)
+	writeInstance(t, filepath.Join(run, "abandoned"), "", time.Hour)
+	jail := filepath.Join(home, "vm", "jail", "firecracker", "dead")
+	require.NoError(t, os.MkdirAll(filepath.Join(jail, "root"), 0o755))
+
+	snap := filepath.Join(home, "vm", "snapshots", "0.36.0")
+	require.NoError(t, os.MkdirAll(snap, 0o755))
+	stale, err := net.Listen("unix", filepath.Join(snap, "vsock.sock_10000"))
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	live, err := net.Listen("unix", filepath.Join(snap, "vsock.sock"))
+	require.NoError(t, err)
+	defer live.Close()
+
+	found := map[string]string{}
+	for _, it := range cleanup.FindQuick(home) {
+		found[it.Path] = it.Kind
+	}
+	assert.Equal(t, map[string]string{
+		filepath.Join(run, "dead"):              cleanup.KindInstance,
+		filepath.Join(run, "abandoned"):         cleanup.KindInstance,
+		filepath.Join(snap, "vsock.sock_10000"): cleanup.KindSocket,
+	}, found)
+
+	removed := cleanup.Sweep(home)
+	assert.Len(t, removed, 3)
+	assert.NoDirExists(t, filepath.Join(run, "dead"))
+	assert.NoDirExists(t, jail, "the jail goes with the instance")
+	assert.DirExists(t, filepath.Join(run, "alive"))
+	assert.NoFileExists(t, filepath.Join(snap, "vsock.sock_10000"))
+	assert.FileExists(t, filepath.Join(snap, "vsock.sock"))
+	assert.Empty(t, cleanup.FindQuick(home))
+}
+
+func TestCleanupPartialInstalls(t *testing.T) {
+	home := t.TempDir()
+	versions := filepath.Join(home, "versions")
+	old := time.Now().Add(-2 * time.Hour)
+
+	done := filepath.Join(versions, "0.36.0")
+	require.NoError(t, os.MkdirAll(done, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(done, "meta.toml"), []byte("installed_at = 2025-01-01T00:00:00Z\n"), 0o644))
+	require.NoError(t, os.Chtimes(done, old, old))
+
+	interrupted := filepath.Join(versions, "0.35.1")
+	require.NoError(t, os.MkdirAll(filepath.Join(interrupted, ".venv"), 0o755))
+	require.NoError(t, os.Chtimes(filepath.Join(interrupted, ".venv"), old, old))
+	require.NoError(t, os.Chtimes(interrupted, old, old))
+
+	// Still installing
+	require.NoError(t, os.MkdirAll(filepath.Join(versions, "0.37.0", ".venv"), 0o755))
+
+	var partial []cleanup.Item
+	for _, it := range cleanup.Find(home) {
+		if it.Kind == cleanup.KindPartialInstall {
+			partial = append(partial, it)
+		}
+	}
+	require.Len(t, partial, 1)
+	assert.Equal(t, interrupted, partial[0].Path)
+
+	require.NoError(t, cleanup.Remove(partial[0]))
+	assert.NoDirExists(t, interrupted)
+	assert.DirExists(t, done)
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/cmd"
+	"github.com/dsmmcken/dh-cli/src/internal/completion"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletionScript_AllShells(t *testing.T) {
+	root := cmd.NewRootCmd()
+	for _, shell := range completion.Shells {
+		script, err := completion.Script(root, shell)
+		require.NoError(t, err, shell)
+		assert.Contains(t, string(script), "__complete", shell)
+	}
+
+	_, err := completion.Script(root, "tcsh")
+	assert.ErrorContains(t, err, `unsupported shell "tcsh"`)
+}
+
+func TestCompletionScript_Nushell(t *testing.T) {
+	script := completion.NushellScript("dh-dev")
+	assert.Contains(t, script, `if ($spans | first) == "dh-dev"`)
+	assert.Contains(t, script, "^dh-dev __complete")
+	// Variable names can't contain dashes
+	assert.Contains(t, script, "let __dh_dev_previous_completer")
+	assert.NotContains(t, script, "{{")
+}
+
+func TestCompletionDetect(t *testing.T) {
+	t.Setenv("NU_VERSION", "")
+	for shell, want := range map[string]string{
+		"/bin/bash":     "bash",
+		"/usr/bin/zsh":  "zsh",
+		"/opt/bin/fish": "fish",
+		"/usr/bin/nu":   "nushell",
+		"/bin/tcsh":     "",
+	} {
+		t.Setenv("SHELL", shell)
+		assert.Equal(t, want, completion.Detect(), shell)
+	}
+
+	t.Setenv("SHELL", "/bin/bash")
+	t.Setenv("NU_VERSION", "0.101.0")
+	assert.Equal(t, "nushell", completion.Detect())
+}
+
+func TestCompletionInstallPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	path, hint, err := completion.InstallPath("bash", "dh")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".local/share/bash-completion/completions/dh"), path)
+	assert.Empty(t, hint)
+
+	path, hint, err = completion.InstallPath("zsh", "dh")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".zfunc/_dh"), path)
+	assert.Contains(t, hint, "fpath=(")
+
+	path, _, err = completion.InstallPath("fish", "dh")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".config/fish/completions/dh.fish"), path)
+
+	path, _, err = completion.InstallPath("nushell", "dh")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(path, filepath.Join("nushell", "autoload", "dh.nu")), path)
+
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	path, _, err = completion.InstallPath("fish", "dh")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(xdg, "fish/completions/dh.fish"), path)
+
+	_, _, err = completion.InstallPath("powershell", "dh")
+	assert.ErrorContains(t, err, "Invoke-Expression")
+}
+
+func TestCompletionInstall_WritesScript(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	path, _, err := completion.Install(cmd.NewRootCmd(), "fish")
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "complete -c dh")
+}
+
+func TestCompletion_DynamicValues(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.35.1"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.36.0"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "config.toml"), []byte(`
+[hosts.prod]
+host = "dh.example.com"
+port = 8443
+`), 0o644))
+
+	out, err := execRoot(t, "--config-dir", tmp, "__complete", "use", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, "0.36.0\n0.35.1\n")
+
+	// Only the first argument is a version
+	out, err = execRoot(t, "--config-dir", tmp, "__complete", "use", "0.36.0", "")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "0.35.1")
+
+	out, err = execRoot(t, "--config-dir", tmp, "__complete", "exec", "--version", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, "0.35.1")
+
+	out, err = execRoot(t, "--config-dir", tmp, "__complete", "exec", "--host", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, "prod\tdh.example.com:8443")
+
+	out, err = execRoot(t, "--config-dir", tmp, "__complete", "completion", "install", "")
+	require.NoError(t, err)
+	assert.Contains(t, out, "nushell")
+}
@@ -202,6 +202,57 @@ func TestResolveVersionLatestInstalled(t *testing.T) {
 	assert.Equal(t, "3.0.0", ver)
 }
 
+func TestResolveVersionConstraintPicksNewestInstalled(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	for _, v := range []string{"0.39.1", "0.40.2", "41.0"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", v), 0o755))
+	}
+
+	ver, err := config.ResolveVersion(">=0.39,<41", "")
+	require.NoError(t, err)
+	assert.Equal(t, "0.40.2", ver)
+
+	require.NoError(t, config.Set("default_version", "~41.0"))
+	ver, err = config.ResolveVersion("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "41.0", ver)
+}
+
+func TestResolveVersionConstraintNoMatch(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.36.0"), 0o755))
+
+	_, err := config.ResolveVersion("", "~41.0")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no installed version matches")
+}
+
+func TestResolveVersionLatestInstalledTwoComponent(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	for _, v := range []string{"0.9.0", "0.36.0", "41.1"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", v), 0o755))
+	}
+
+	ver, err := config.ResolveVersion("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "41.1", ver)
+}
+
+func TestSetDefaultVersionRejectsInvalidConstraint(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	err := config.Set("default_version", ">=41.*")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid default_version")
+}
+
 func TestResolveVersionNothingConfigured(t *testing.T) {
 	_, cleanup := withTempDHHome(t)
 	defer cleanup()
@@ -237,3 +288,470 @@ func TestSetInstallPlugins(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "a,b,c", val)
 }
+
+func TestSetExecPythonPath(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("exec.pythonpath", "/opt/lib,/srv/helpers"))
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/opt/lib", "/srv/helpers"}, cfg.Exec.PythonPath)
+
+	require.NoError(t, config.Set("exec.pythonpath", ""))
+	val, err := config.Get("exec.pythonpath")
+	require.NoError(t, err)
+	assert.Equal(t, "", val)
+}
+
+func TestSetTUISkipConfirm(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("tui.skip_confirm", "uninstall,pool-stop"))
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uninstall", "pool-stop"}, cfg.TUI.SkipConfirm)
+
+	err = config.Set("tui.skip_confirm", "format-disk")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid tui.skip_confirm action")
+}
+
+func TestSetBackendAndVMMounts(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("backend", "vm"))
+	require.NoError(t, config.Set("vm.mounts", "../shared:libs:ro,/data"))
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "vm", cfg.Backend)
+	assert.Equal(t, []string{"../shared:libs:ro", "/data"}, cfg.VM.Mounts)
+
+	err = config.Set("backend", "cloud")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid backend")
+}
+
+func TestSetVMPrepareDefaults(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("vm.base_image", "ubuntu:24.04"))
+	require.NoError(t, config.Set("vm.python_version", "3.12"))
+	require.NoError(t, config.Set("vm.guest_jvm_args", "-Xss4m -Duser.timezone=UTC"))
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "ubuntu:24.04", cfg.VM.BaseImage)
+	assert.Equal(t, "3.12", cfg.VM.PythonVersion)
+	val, err := config.Get("vm.guest_jvm_args")
+	require.NoError(t, err)
+	assert.Equal(t, "-Xss4m -Duser.timezone=UTC", val)
+}
+
+func TestSetVMGC(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("vm.auto_gc", "true"))
+	require.NoError(t, config.Set("vm.gc_keep_latest", "2"))
+	require.NoError(t, config.Set("vm.gc_older_than", "30d"))
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.True(t, cfg.VM.AutoGC)
+	assert.Equal(t, 2, cfg.VM.GCKeepLatest)
+	assert.Equal(t, "30d", cfg.VM.GCOlderThan)
+
+	for key, value := range map[string]string{
+		"vm.auto_gc":        "sometimes",
+		"vm.gc_keep_latest": "0",
+		"vm.gc_older_than":  "a month",
+	} {
+		err := config.Set(key, value)
+		require.Error(t, err, key)
+		assert.Contains(t, err.Error(), "invalid "+key)
+	}
+}
+
+func TestFindProjectConfigDotDH(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".dh"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".dh", "config.toml"), []byte("backend = \"vm\"\n"), 0o644))
+	sub := filepath.Join(root, "a", "b")
+	require.NoError(t, os.MkdirAll(sub, 0o755))
+
+	proj, err := config.FindProjectConfig(sub)
+	require.NoError(t, err)
+	require.NotNil(t, proj)
+	assert.Equal(t, filepath.Join(root, ".dh", "config.toml"), proj.Path)
+	assert.Equal(t, root, proj.Dir)
+}
+
+func TestFindProjectConfigPyproject(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "pyproject.toml"),
+		[]byte("[tool.dh]\ndefault_version = \"0.36.0\"\n"), 0o644))
+	// A pyproject.toml without [tool.dh] does not stop the walk.
+	sub := filepath.Join(root, "pkg")
+	require.NoError(t, os.MkdirAll(sub, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(sub, "pyproject.toml"),
+		[]byte("[project]\nname = \"pkg\"\n"), 0o644))
+
+	proj, err := config.FindProjectConfig(sub)
+	require.NoError(t, err)
+	require.NotNil(t, proj)
+	assert.Equal(t, filepath.Join(root, "pyproject.toml"), proj.Path)
+}
+
+func TestFindProjectConfigSkipsGlobalConfig(t *testing.T) {
+	root := t.TempDir()
+	dhHome := filepath.Join(root, ".dh")
+	config.SetConfigDir(dhHome)
+	defer config.SetConfigDir("")
+	require.NoError(t, config.Set("default_version", "1.0.0"))
+
+	proj, err := config.FindProjectConfig(root)
+	require.NoError(t, err)
+	assert.Nil(t, proj)
+}
+
+func TestLoadEffectiveProjectOverridesGlobal(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("default_version", "1.0.0"))
+	require.NoError(t, config.Set("install.python_version", "3.12"))
+	require.NoError(t, config.Set("history.scope", "project"))
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "pyproject.toml"), []byte(`
+[tool.dh]
+default_version = "0.36.0"
+backend = "vm"
+
+[tool.dh.exec]
+pythonpath = ["src", "/opt/lib"]
+
+[tool.dh.vm]
+mounts = ["../shared:libs:ro"]
+
+[tool.dh.hosts.prod]
+host = "dh.example.com"
+port = 8443
+tls = true
+`), 0o644))
+
+	cfg, proj, err := config.LoadEffectiveAt(root)
+	require.NoError(t, err)
+	require.NotNil(t, proj)
+	assert.Equal(t, "0.36.0", cfg.DefaultVersion)
+	assert.Equal(t, "vm", cfg.Backend)
+	assert.Equal(t, "3.12", cfg.Install.PythonVersion, "unset in project, kept from global")
+	assert.Equal(t, "project", cfg.History.Scope)
+	assert.Equal(t, []string{filepath.Join(root, "src"), "/opt/lib"}, cfg.Exec.PythonPath)
+	assert.Equal(t, []string{filepath.Join(filepath.Dir(root), "shared") + ":libs:ro"}, cfg.VM.Mounts)
+	assert.Equal(t, config.Host{Host: "dh.example.com", Port: 8443, TLS: true}, cfg.Hosts["prod"])
+
+	// The global file is untouched.
+	global, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", global.DefaultVersion)
+	assert.Empty(t, global.Backend)
+}
+
+func TestLoadEffectiveProjectHostTLSPaths(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	root := t.TempDir()
+	writeProjectConfig(t, root, `
+[hosts.prod]
+host = "dh.example.com"
+tls = true
+tls_ca_cert = "certs/ca.pem"
+tls_client_cert = "/etc/dh/client.pem"
+tls_client_key = "~/.dh/client.key"
+`)
+
+	cfg, _, err := config.LoadEffectiveAt(root)
+	require.NoError(t, err)
+	h := cfg.Hosts["prod"]
+	assert.Equal(t, filepath.Join(root, "certs", "ca.pem"), h.TLSCACert, "relative to the project root")
+	assert.Equal(t, "/etc/dh/client.pem", h.TLSClientCert)
+	assert.Equal(t, "~/.dh/client.key", h.TLSClientKey, "expanded when used")
+}
+
+func TestLoadEffectiveRefusesProjectTokenSources(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	for _, source := range []string{`auth_token_env = "HOME"`, `auth_token_file = "~/.ssh/id_rsa"`} {
+		root := t.TempDir()
+		writeProjectConfig(t, root, "[hosts.prod]\nhost = \"evil.example.com\"\n"+source+"\n")
+
+		_, _, err := config.LoadEffectiveAt(root)
+		assert.ErrorContains(t, err, "hosts.prod: auth_token_env and auth_token_file can only be set in", source)
+	}
+
+	// The global config may set them.
+	require.NoError(t, os.WriteFile(config.ConfigPath(), []byte("[hosts.prod]\nhost = \"dh.example.com\"\nauth_token_env = \"DH_PROD_TOKEN\"\n"), 0o644))
+	cfg, _, err := config.LoadEffectiveAt(t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, "DH_PROD_TOKEN", cfg.Hosts["prod"].AuthTokenEnv)
+}
+
+// writeProjectConfig writes data as the .dh/config.toml of a project at root.
+func writeProjectConfig(t *testing.T, root, data string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".dh"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".dh", "config.toml"), []byte(data), 0o644))
+}
+
+func TestHostToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	require.NoError(t, os.WriteFile(filepath.Join(home, "token"), []byte("  from-file\n"), 0o600))
+	t.Setenv("DH_TEST_TOKEN", "from-env")
+
+	for _, tc := range []struct {
+		host config.Host
+		want string
+	}{
+		{config.Host{Host: "h"}, ""},
+		{config.Host{Host: "h", AuthToken: "inline"}, "inline"},
+		{config.Host{Host: "h", AuthTokenEnv: "DH_TEST_TOKEN"}, "from-env"},
+		{config.Host{Host: "h", AuthTokenFile: filepath.Join(home, "token")}, "from-file"},
+		{config.Host{Host: "h", AuthTokenFile: "~/token"}, "from-file"},
+	} {
+		token, err := tc.host.Token()
+		require.NoError(t, err, "%+v", tc.host)
+		assert.Equal(t, tc.want, token, "%+v", tc.host)
+	}
+
+	_, err := config.Host{Host: "h", AuthTokenEnv: "DH_TEST_NO_SUCH_TOKEN"}.Token()
+	assert.ErrorContains(t, err, "$DH_TEST_NO_SUCH_TOKEN is not set")
+	_, err = config.Host{Host: "h", AuthTokenFile: filepath.Join(home, "missing")}.Token()
+	assert.ErrorContains(t, err, "auth_token_file")
+}
+
+func TestValidateHostTokenSourcesAndTLS(t *testing.T) {
+	for _, tc := range []struct {
+		host config.Host
+		want string
+	}{
+		{config.Host{Host: "h", AuthTokenEnv: "T"}, ""},
+		{config.Host{Host: "h", AuthToken: "t", AuthTokenEnv: "T"}, "set only one of"},
+		{config.Host{Host: "h", AuthTokenEnv: "T", AuthTokenFile: "f"}, "set only one of"},
+		{config.Host{Host: "h", TLSClientCert: "c.pem", TLSClientKey: "c.key"}, ""},
+		{config.Host{Host: "h", TLSClientCert: "c.pem"}, "tls_client_cert and tls_client_key go together"},
+		{config.Host{Host: "h", TLSClientKey: "c.key"}, "tls_client_cert and tls_client_key go together"},
+	} {
+		err := (&config.Config{Hosts: map[string]config.Host{"prod": tc.host}}).Validate()
+		if tc.want == "" {
+			assert.NoError(t, err, "%+v", tc.host)
+		} else {
+			assert.ErrorContains(t, err, "invalid hosts.prod: "+tc.want, "%+v", tc.host)
+		}
+	}
+}
+
+func TestResolveVersionUsesProjectConfig(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+	require.NoError(t, config.Set("default_version", "1.0.0"))
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".dh"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".dh", "config.toml"), []byte("default_version = \"2.0.0\"\n"), 0o644))
+	t.Chdir(root)
+
+	ver, err := config.ResolveVersion("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", ver)
+
+	val, err := config.Get("default_version")
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", val)
+}
+
+func TestSetVMTuningKeys(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("vm.backend", "file"))
+	require.NoError(t, config.Set("vm.eager_mb", "64"))
+	require.NoError(t, config.Set("vm.no_pool", "true"))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "file", cfg.VM.Backend)
+	require.NotNil(t, cfg.VM.EagerMB)
+	assert.Equal(t, 64, *cfg.VM.EagerMB)
+	assert.True(t, cfg.VM.NoPool)
+
+	val, err := config.Get("vm.eager_mb")
+	require.NoError(t, err)
+	assert.Equal(t, "64", val)
+
+	assert.ErrorContains(t, config.Set("vm.backend", "mmap"), "use uffd or file")
+	assert.ErrorContains(t, config.Set("vm.eager_mb", "lots"), "must be a number of MiB")
+	assert.ErrorContains(t, config.Set("vm.no_pool", "maybe"), "use true or false")
+}
+
+func TestResolveVMTuningPrecedence(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+	t.Chdir(t.TempDir())
+	t.Setenv("DH_VM_NO_UFFD", "")
+	t.Setenv("DH_VM_EAGER_MB", "")
+	t.Setenv("DH_VM_POOL", "")
+
+	tuning, err := config.ResolveVMTuning("", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, config.VMTuning{Backend: "uffd"}, tuning, "defaults")
+
+	require.NoError(t, config.Set("vm.backend", "file"))
+	require.NoError(t, config.Set("vm.eager_mb", "64"))
+	require.NoError(t, config.Set("vm.no_pool", "true"))
+	tuning, err = config.ResolveVMTuning("", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, "file", tuning.Backend)
+	require.NotNil(t, tuning.EagerMB)
+	assert.Equal(t, 64, *tuning.EagerMB)
+	assert.True(t, tuning.NoPool)
+
+	// The environment overrides the config, and flags override both.
+	require.NoError(t, config.Set("vm.backend", "uffd"))
+	t.Setenv("DH_VM_NO_UFFD", "1")
+	t.Setenv("DH_VM_EAGER_MB", "128")
+	tuning, err = config.ResolveVMTuning("", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, "file", tuning.Backend)
+	assert.Equal(t, 128, *tuning.EagerMB)
+
+	zero := 0
+	tuning, err = config.ResolveVMTuning("uffd", &zero, false)
+	require.NoError(t, err)
+	assert.Equal(t, "uffd", tuning.Backend)
+	assert.Equal(t, 0, *tuning.EagerMB)
+
+	_, err = config.ResolveVMTuning("mmap", nil, false)
+	assert.ErrorContains(t, err, "invalid --vm-backend")
+	negative := -1
+	_, err = config.ResolveVMTuning("", &negative, false)
+	assert.ErrorContains(t, err, "must not be negative")
+	t.Setenv("DH_VM_EAGER_MB", "lots")
+	_, err = config.ResolveVMTuning("", nil, false)
+	assert.ErrorContains(t, err, "invalid DH_VM_EAGER_MB")
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := &config.Config{Hosts: map[string]config.Host{
+		"prod": {Host: "dh.example.com", AuthToken: "s3cret"},
+		"dev":  {Host: "localhost"},
+	}}
+	red := cfg.Redacted()
+	assert.Equal(t, config.RedactedValue, red.Hosts["prod"].AuthToken)
+	assert.Empty(t, red.Hosts["dev"].AuthToken)
+	assert.Equal(t, "s3cret", cfg.Hosts["prod"].AuthToken, "original is unchanged")
+}
+
+func TestPlanImportMerge(t *testing.T) {
+	cur := &config.Config{DefaultVersion: "0.36.0", Backend: "local"}
+	cur.Install.PythonVersion = "3.12"
+
+	next, err := config.PlanImport(cur, []byte("backend = \"vm\"\n[install]\nplugins = [\"p\"]\n"), false)
+	require.NoError(t, err)
+	assert.Equal(t, "0.36.0", next.DefaultVersion)
+	assert.Equal(t, "vm", next.Backend)
+	assert.Equal(t, "3.12", next.Install.PythonVersion)
+	assert.Equal(t, []string{"p"}, next.Install.Plugins)
+	assert.Equal(t, "local", cur.Backend, "cur is unchanged")
+}
+
+func TestPlanImportReplace(t *testing.T) {
+	cur := &config.Config{DefaultVersion: "0.36.0", Backend: "local"}
+
+	next, err := config.PlanImport(cur, []byte("backend = \"vm\"\n"), true)
+	require.NoError(t, err)
+	assert.Empty(t, next.DefaultVersion)
+	assert.Equal(t, "vm", next.Backend)
+}
+
+func TestPlanImportRejectsInvalid(t *testing.T) {
+	cur := &config.Config{}
+
+	_, err := config.PlanImport(cur, []byte("colour = \"blue\"\n"), false)
+	assert.ErrorContains(t, err, "parsing import file")
+
+	_, err = config.PlanImport(cur, []byte("backend = \"cloud\"\n"), false)
+	assert.ErrorContains(t, err, "invalid backend")
+
+	_, err = config.PlanImport(cur, []byte("[history]\ndedup = \"sometimes\"\n"), false)
+	assert.ErrorContains(t, err, "invalid history.dedup")
+}
+
+func TestPlanImportKeepsRedactedToken(t *testing.T) {
+	cur := &config.Config{Hosts: map[string]config.Host{
+		"prod": {Host: "dh.example.com", AuthToken: "s3cret"},
+	}}
+	data := []byte("[hosts.prod]\nhost = \"dh2.example.com\"\nauth_token = \"<redacted>\"\n")
+
+	next, err := config.PlanImport(cur, data, true)
+	require.NoError(t, err)
+	assert.Equal(t, "dh2.example.com", next.Hosts["prod"].Host)
+	assert.Equal(t, "s3cret", next.Hosts["prod"].AuthToken)
+}
+
+func TestConfigDiff(t *testing.T) {
+	old := &config.Config{DefaultVersion: "0.35.0"}
+	next := &config.Config{DefaultVersion: "0.36.0"}
+	next.Install.Plugins = []string{"p"}
+
+	diff, err := config.Diff(old, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"- default_version = '0.35.0'",
+		"+ default_version = '0.36.0'",
+		"+ [install]",
+		"+ plugins = ['p']",
+	}, diff)
+
+	diff, err = config.Diff(old, old)
+	require.NoError(t, err)
+	assert.Nil(t, diff)
+}
+
+func TestConfigDiffHidesTokens(t *testing.T) {
+	old := &config.Config{Hosts: map[string]config.Host{"prod": {Host: "h", AuthToken: "a"}}}
+	next := &config.Config{Hosts: map[string]config.Host{"prod": {Host: "h", AuthToken: "b"}}}
+
+	diff, err := config.Diff(old, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"~ host auth tokens changed"}, diff)
+}
+
+func TestExportRedacts(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Save(&config.Config{Hosts: map[string]config.Host{
+		"prod": {Host: "h", AuthToken: "s3cret"},
+	}}))
+
+	data, err := config.Export(false)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "s3cret")
+
+	data, err = config.Export(true)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cret")
+	assert.Contains(t, string(data), config.RedactedValue)
+}
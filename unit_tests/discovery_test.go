@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dsmmcken/dh-cli/src/internal/discovery"
@@ -152,3 +154,150 @@ func TestDeduplicateByPort(t *testing.T) {
 	assert.Equal(t, 8080, result[1].Port)
 	assert.Equal(t, 9090, result[2].Port)
 }
+
+func TestRegister(t *testing.T) {
+	dhHome := t.TempDir()
+	unregister, err := discovery.Register(dhHome, discovery.Registration{Server: discovery.Server{
+		Port:   54321,
+		PID:    os.Getpid(),
+		Source: "dh exec",
+		Script: "report.py",
+	}})
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dhHome, "servers", "54321.json"))
+
+	servers := discovery.Registered(dhHome)
+	require.Len(t, servers, 1)
+	assert.Equal(t, 54321, servers[0].Port)
+	assert.Equal(t, os.Getpid(), servers[0].PID)
+	assert.Equal(t, "dh exec", servers[0].Source)
+	assert.Equal(t, "report.py", servers[0].Script)
+
+	unregister()
+	assert.Empty(t, discovery.Registered(dhHome))
+}
+
+func TestRegistered_RemovesStale(t *testing.T) {
+	dhHome := t.TempDir()
+	dir := filepath.Join(dhHome, "servers")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	// A PID that cannot belong to a running process
+	stale := filepath.Join(dir, "54322.json")
+	require.NoError(t, os.WriteFile(stale, []byte(`{"port":54322,"pid":2147483647,"source":"dh serve"}`), 0o644))
+	garbage := filepath.Join(dir, "54323.json")
+	require.NoError(t, os.WriteFile(garbage, []byte("not json"), 0o644))
+
+	assert.Empty(t, discovery.Registered(dhHome))
+	assert.NoFileExists(t, stale)
+	assert.NoFileExists(t, garbage)
+}
+
+func TestRegistered_NoDirectory(t *testing.T) {
+	assert.Empty(t, discovery.Registered(t.TempDir()))
+}
+
+func TestLookup(t *testing.T) {
+	dhHome := t.TempDir()
+	reg := discovery.Registration{
+		Server: discovery.Server{
+			Port:     54324,
+			PID:      os.Getpid(),
+			Source:   "dh serve",
+			Name:     "analytics",
+			Version:  "0.36.0",
+			AuthType: discovery.PSKAuthType,
+		},
+		AuthToken: "s3cret",
+	}
+	unregister, err := discovery.Register(dhHome, reg)
+	require.NoError(t, err)
+	defer unregister()
+
+	info, err := os.Stat(filepath.Join(dhHome, "servers", "54324.json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "the record may hold a token")
+
+	got, err := discovery.Lookup(dhHome, "analytics")
+	require.NoError(t, err)
+	assert.Equal(t, reg, *got)
+
+	_, err = discovery.Lookup(dhHome, "other")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no running server named "other"`)
+}
+
+func TestRegister_DuplicateName(t *testing.T) {
+	dhHome := t.TempDir()
+	first := discovery.Registration{Server: discovery.Server{Port: 54325, PID: os.Getpid(), Name: "analytics"}}
+	unregister, err := discovery.Register(dhHome, first)
+	require.NoError(t, err)
+	defer unregister()
+
+	second := first
+	second.Port = 54326
+	_, err = discovery.Register(dhHome, second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `a server named "analytics" is already running on port 54325`)
+}
+
+func TestAuthFromJVMArgs(t *testing.T) {
+	authType, token := discovery.AuthFromJVMArgs([]string{"-Xmx4g", "-Dauthentication.psk=s3cret"})
+	assert.Equal(t, discovery.PSKAuthType, authType)
+	assert.Equal(t, "s3cret", token)
+
+	authType, token = discovery.AuthFromJVMArgs([]string{"-Xmx4g", "-DAuthHandlers=io.deephaven.auth.AnonymousAuthenticationHandler"})
+	assert.Empty(t, authType)
+	assert.Empty(t, token)
+}
+
+func TestParseAttach(t *testing.T) {
+	tests := []struct {
+		spec    string
+		port    int
+		pid     int
+		wantErr string
+	}{
+		{":10000", 10000, 0, ""},
+		{"4321", 0, 4321, ""},
+		{":", 0, 0, "want :PORT or PID"},
+		{"localhost:10000", 0, 0, "want :PORT or PID"},
+		{"-5", 0, 0, "want :PORT or PID"},
+		{":70000", 0, 0, "port out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			port, pid, err := discovery.ParseAttach(tt.spec)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.port, port)
+			assert.Equal(t, tt.pid, pid)
+		})
+	}
+}
+
+func TestFind_Registered(t *testing.T) {
+	dhHome := t.TempDir()
+	reg := discovery.Registration{
+		Server:    discovery.Server{Port: 54327, PID: os.Getpid(), Source: "dh serve", Version: "0.36.0", AuthType: discovery.PSKAuthType},
+		AuthToken: "s3cret",
+	}
+	unregister, err := discovery.Register(dhHome, reg)
+	require.NoError(t, err)
+	defer unregister()
+
+	got, err := discovery.Find(dhHome, 54327, 0)
+	require.NoError(t, err)
+	assert.Equal(t, reg, *got)
+
+	got, err = discovery.Find(dhHome, 0, os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, reg, *got)
+
+	_, err = discovery.Find(dhHome, 1, 0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no Deephaven server is listening on port 1")
+}
@@ -2,6 +2,8 @@ package tests
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/dsmmcken/dh-cli/src/internal/cmd"
@@ -217,3 +219,130 @@ func TestDoctorHumanOutputWithWarnings(t *testing.T) {
 
 	assert.Contains(t, out, "Everything looks good (1 warning).")
 }
+
+func TestDoctorJSONIncludesFixActions(t *testing.T) {
+	origUV := cmd.UVChecker
+	origJava := cmd.JavaChecker
+	origVersions := cmd.VersionsChecker
+	origDefault := cmd.DefaultVersionChecker
+	origDisk := cmd.DiskSpaceChecker
+	defer func() {
+		cmd.UVChecker = origUV
+		cmd.JavaChecker = origJava
+		cmd.VersionsChecker = origVersions
+		cmd.DefaultVersionChecker = origDefault
+		cmd.DiskSpaceChecker = origDisk
+	}()
+
+	cmd.UVChecker = func() cmd.CheckResult {
+		return cmd.CheckResult{Name: "uv", Status: "ok", Detail: "/usr/bin/uv (0.5.14)"}
+	}
+	cmd.JavaChecker = func(string) cmd.CheckResult {
+		return cmd.CheckResult{Name: "Java", Status: "error", Detail: "not found", Fixes: []cmd.FixAction{
+			{Description: "install Java", Command: "dh java install", Privileges: cmd.PrivilegesUser},
+		}}
+	}
+	cmd.VersionsChecker = func(string) cmd.CheckResult {
+		return cmd.CheckResult{Name: "Versions", Status: "ok", Detail: "1 installed"}
+	}
+	cmd.DiskSpaceChecker = func(string) cmd.CheckResult {
+		return cmd.CheckResult{Name: "Disk", Status: "ok", Detail: "50.0 GB free"}
+	}
+
+	// The real default version check, with 0.36.0 installed but 0.35.0 the default.
+	tmp := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.36.0"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "config.toml"), []byte("default_version = \"0.35.0\"\n"), 0o644))
+
+	out, err := execRoot(t, "doctor", "--json", "--config-dir", tmp)
+	require.NoError(t, err)
+
+	var report cmd.DoctorReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Len(t, report.Checks, 5)
+
+	assert.Empty(t, report.Checks[0].Fixes)
+	assert.Equal(t, "dh java install", report.Checks[1].Fixes[0].Command)
+
+	def := report.Checks[3]
+	assert.Equal(t, "error", def.Status)
+	assert.Equal(t, []cmd.FixAction{
+		{Description: "install Deephaven 0.35.0", Command: "dh install 0.35.0", Privileges: "user"},
+		{Description: "set the default version to 0.36.0", Command: "dh use 0.36.0", AutoFixable: true, Privileges: "user"},
+	}, def.Fixes)
+}
+
+func TestDoctorFixAppliesAutoFixable(t *testing.T) {
+	origJava := cmd.JavaChecker
+	defer func() { cmd.JavaChecker = origJava }()
+	cmd.JavaChecker = func(string) cmd.CheckResult {
+		return cmd.CheckResult{Name: "Java", Status: "error", Detail: "not found", Fixes: []cmd.FixAction{
+			{Description: "install Java", Command: "dh java install", Privileges: cmd.PrivilegesUser},
+		}}
+	}
+
+	tmp := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.36.0"), 0o755))
+
+	out, err := execRoot(t, "doctor", "--fix", "--config-dir", tmp)
+	require.NoError(t, err)
+	assert.Contains(t, out, "Fix: Run 'dh java install' to install Java.")
+	assert.Contains(t, out, "Fix: Set the default version to 0.36.0.")
+
+	data, err := os.ReadFile(filepath.Join(tmp, "config.toml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "0.36.0")
+}
+
+func TestDoctorOnlyStrictAndSeverity(t *testing.T) {
+	origUV := cmd.UVChecker
+	origJava := cmd.JavaChecker
+	origVM := cmd.VMChecker
+	defer func() {
+		cmd.UVChecker = origUV
+		cmd.JavaChecker = origJava
+		cmd.VMChecker = origVM
+	}()
+
+	cmd.UVChecker = func() cmd.CheckResult {
+		return cmd.CheckResult{Name: "uv", Status: "ok", Detail: "/usr/bin/uv (0.5.14)"}
+	}
+	cmd.JavaChecker = func(string) cmd.CheckResult {
+		return cmd.CheckResult{Name: "Java", Status: "warning", Detail: "11.0.2 (PATH) — below minimum 17"}
+	}
+	cmd.VMChecker = func(string) cmd.CheckResult {
+		return cmd.CheckResult{Name: "VM", Status: "error", Detail: "kernel: vmlinux kernel not found"}
+	}
+	tmp := t.TempDir()
+
+	report := func(args ...string) cmd.DoctorReport {
+		t.Helper()
+		out, err := execRoot(t, append([]string{"doctor", "--json", "--config-dir", tmp}, args...)...)
+		require.NoError(t, err)
+		var r cmd.DoctorReport
+		require.NoError(t, json.Unmarshal([]byte(out), &r))
+		return r
+	}
+
+	r := report("--only", "uv,java")
+	require.Len(t, r.Checks, 2)
+	assert.Equal(t, "uv", r.Checks[0].Name)
+	assert.Equal(t, "Java", r.Checks[1].Name)
+	assert.True(t, r.Healthy)
+
+	r = report("--only", "uv,java", "--strict")
+	assert.False(t, r.Healthy)
+
+	r = report("--only", "java", "--severity", "java=error")
+	assert.Equal(t, "error", r.Checks[0].Status)
+	assert.False(t, r.Healthy)
+
+	// The VM check only runs when named.
+	for _, c := range report().Checks {
+		assert.NotEqual(t, "VM", c.Name)
+	}
+	r = report("--only", "vm", "--severity", "vm=warning")
+	require.Len(t, r.Checks, 1)
+	assert.Equal(t, "warning", r.Checks[0].Status)
+	assert.True(t, r.Healthy)
+}
@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"bytes"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -148,12 +150,62 @@ func TestRunnerScriptEmbedded(t *testing.T) {
 	assert.Contains(t, script, "--output-json")
 }
 
+func TestResolvePort_Free(t *testing.T) {
+	// Find a port that is free, then ask for it
+	free, err := dhexec.ResolvePort(0)
+	require.NoError(t, err)
+	assert.NotZero(t, free)
+
+	port, err := dhexec.ResolvePort(free)
+	require.NoError(t, err)
+	assert.Equal(t, free, port)
+}
+
+func TestResolvePort_Busy(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer l.Close()
+	busy := l.Addr().(*net.TCPAddr).Port
+
+	port, err := dhexec.ResolvePort(busy)
+	require.NoError(t, err)
+	assert.NotEqual(t, busy, port)
+	assert.NotZero(t, port)
+}
+
+func TestResolvePort_Invalid(t *testing.T) {
+	_, err := dhexec.ResolvePort(70000)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid port 70000")
+}
+
+func TestReportPort(t *testing.T) {
+	var buf bytes.Buffer
+	dhexec.ReportPort(10000, 10000, true, false, &buf)
+	assert.Empty(t, buf.String())
+
+	dhexec.ReportPort(10000, 54321, false, false, &buf)
+	assert.Equal(t, "Port 10000 is in use; using port 54321\n", buf.String())
+
+	buf.Reset()
+	dhexec.ReportPort(10000, 54321, false, true, &buf)
+	assert.Empty(t, buf.String(), "quiet hides the message")
+
+	dhexec.ReportPort(0, 54321, false, false, &buf)
+	assert.Empty(t, buf.String(), "--port 0 is only reported with --verbose")
+	dhexec.ReportPort(0, 54321, true, false, &buf)
+	assert.Equal(t, "Using port 54321\n", buf.String())
+}
+
 func TestExecCommandHelp(t *testing.T) {
 	out, err := execRoot(t, "exec", "--help")
 	require.NoError(t, err)
 	assert.True(t, strings.Contains(out, "-c") || strings.Contains(out, "--code"),
 		"help should mention -c flag")
 	assert.Contains(t, out, "--timeout")
+	assert.Contains(t, out, "--connect-timeout")
+	assert.Contains(t, out, "--exec-timeout")
+	assert.Contains(t, out, "--kill-after")
 	assert.Contains(t, out, "--host")
 	assert.Contains(t, out, "--port")
 	assert.Contains(t, out, "exec")
@@ -164,3 +216,29 @@ func TestExecCommandInRootHelp(t *testing.T) {
 	require.NoError(t, err)
 	assert.Contains(t, out, "exec")
 }
+
+func TestParseTraceback(t *testing.T) {
+	line, msg := dhexec.ParseTraceback(`Traceback (most recent call last):
+  File "<string>", line 40, in <module>
+  File "<string>", line 3, in <module>
+  File "/venv/lib/python3.12/site-packages/deephaven/table.py", line 900, in update
+    raise DHError(e, "table update operation failed.") from e
+deephaven.dherror.DHError: table update operation failed. : Cannot find variable B
+`)
+	assert.Equal(t, 3, line)
+	assert.Equal(t, "deephaven.dherror.DHError: table update operation failed. : Cannot find variable B", msg)
+
+	line, msg = dhexec.ParseTraceback(`Traceback (most recent call last):
+  File "<string>", line 41, in <module>
+  File "<string>", line 2
+    x = = 1
+        ^
+SyntaxError: invalid syntax
+`)
+	assert.Equal(t, 2, line)
+	assert.Equal(t, "SyntaxError: invalid syntax", msg)
+
+	line, msg = dhexec.ParseTraceback("Execution timed out after 5 seconds")
+	assert.Equal(t, 0, line)
+	assert.Equal(t, "Execution timed out after 5 seconds", msg)
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/execlog"
+	"github.com/dsmmcken/dh-cli/src/internal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecLogAppendAndFind(t *testing.T) {
+	dhHome, dir := t.TempDir(), t.TempDir()
+	t.Setenv("DH_HOME", dhHome)
+	first := &history.Entry{Code: "print(1)", Dir: dir, Run: &history.Run{Mode: execlog.ModeEmbedded, Args: []string{"exec", "-c", "print(1)"}}}
+	require.NoError(t, execlog.Append(dhHome, first, "print(1)", true))
+	again := &history.Entry{Code: "print(1)", Dir: dir, Run: &history.Run{Mode: execlog.ModeEmbedded, Args: []string{"exec", "-c", "print(1)"}}}
+	require.NoError(t, execlog.Append(dhHome, again, "print(1)", true))
+	second := &history.Entry{Code: "token = 'abc'", Dir: dir, Run: &history.Run{Mode: execlog.ModeRemote, ExitCode: 1, Stdout: strings.Repeat("x", execlog.MaxOutput+10)}}
+	require.NoError(t, execlog.Append(dhHome, second, "token = 'abc'", false))
+
+	// Runs are history entries: logged once, each run of the same code
+	// kept, and code that looks like a secret left out
+	entries, err := execlog.List(dhHome, dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, history.SourceExec, entries[0].Source)
+	assert.Equal(t, first.Run.ID, entries[0].Run.ID)
+	assert.Equal(t, execlog.Hash("print(1)"), entries[0].Run.CodeHash)
+	assert.Empty(t, entries[2].Code)
+	assert.Len(t, entries[2].Run.Stdout, execlog.MaxOutput+len("[...]"))
+	data, err := os.ReadFile(filepath.Join(dhHome, "history.jsonl"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "abc")
+
+	got, err := execlog.Find(dhHome, dir, first.Run.ID[:4])
+	require.NoError(t, err)
+	code, err := execlog.Code(dhHome, got)
+	require.NoError(t, err)
+	assert.Equal(t, "print(1)", code)
+
+	last, err := execlog.Find(dhHome, dir, "last")
+	require.NoError(t, err)
+	assert.Equal(t, second.Run.ID, last.Run.ID)
+	_, err = execlog.Code(dhHome, last)
+	assert.ErrorContains(t, err, "was not kept")
+
+	_, err = execlog.Find(dhHome, dir, "zzzz")
+	assert.True(t, errors.Is(err, execlog.ErrNotFound))
+}
+
+func TestExecLogEmpty(t *testing.T) {
+	dhHome := t.TempDir()
+	t.Setenv("DH_HOME", dhHome)
+	entries, err := execlog.List(dhHome, t.TempDir())
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, err = execlog.Find(dhHome, t.TempDir(), "last")
+	assert.True(t, errors.Is(err, execlog.ErrNotFound))
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := []string{"exec", "-c", "x = 1", "--host", "h", "--auth-token", "s3cret", "--auth-token=s3cret"}
+	assert.Equal(t, []string{"exec", "-c", "x = 1", "--host", "h"}, execlog.RedactArgs(args, false))
+	assert.Equal(t, []string{"exec", "-c", execlog.NotKept, "--host", "h"}, execlog.RedactArgs(args, true))
+	assert.Equal(t, []string{"exec", "--code=" + execlog.NotKept}, execlog.RedactArgs([]string{"exec", "--code=x = 1"}, true))
+}
+
+func TestTailWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &execlog.TailWriter{W: &out}
+	for range 3 {
+		w.Write(bytes.Repeat([]byte("a"), execlog.MaxOutput))
+	}
+	w.Write([]byte("end"))
+	assert.Equal(t, 3*execlog.MaxOutput+3, out.Len())
+	assert.True(t, strings.HasPrefix(w.String(), "[...]"))
+	assert.True(t, strings.HasSuffix(w.String(), "aend"))
+	assert.Len(t, w.String(), execlog.MaxOutput+len("[...]"))
+}
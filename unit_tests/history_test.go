@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, h config.History) *history.Store {
+	t.Helper()
+	opts, err := history.OptionsFromConfig(h)
+	require.NoError(t, err)
+	s, err := history.NewStore(filepath.Join(t.TempDir(), "history.jsonl"), opts)
+	require.NoError(t, err)
+	return s
+}
+
+func codes(s *history.Store) []string {
+	var out []string
+	for _, e := range s.Entries() {
+		out = append(out, e.Code)
+	}
+	return out
+}
+
+func TestHistoryDedupConsecutive(t *testing.T) {
+	s := newTestStore(t, config.History{})
+	for _, c := range []string{"a", "a", "b", "a"} {
+		_, err := s.Add(history.Entry{Source: history.SourceREPL, Code: c})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"a", "b", "a"}, codes(s))
+}
+
+func TestHistoryDedupAll(t *testing.T) {
+	s := newTestStore(t, config.History{Dedup: history.DedupAll})
+	for _, c := range []string{"a", "b", "a"} {
+		_, err := s.Add(history.Entry{Source: history.SourceREPL, Code: c})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"b", "a"}, codes(s))
+}
+
+func TestHistoryMaxEntries(t *testing.T) {
+	s := newTestStore(t, config.History{MaxEntries: 2, Dedup: history.DedupNone})
+	for _, c := range []string{"a", "b", "c"} {
+		_, err := s.Add(history.Entry{Source: history.SourceExec, Code: c})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"b", "c"}, codes(s))
+
+	// Entries persist across reopen.
+	reopened, err := history.NewStore(s.Path(), history.Options{MaxEntries: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, codes(reopened))
+}
+
+func TestHistorySensitiveExclusion(t *testing.T) {
+	s := newTestStore(t, config.History{Exclude: []string{`internal\.corp`}})
+
+	for _, c := range []string{`api_key = "abc123"`, `PASSWORD="hunter2"`, `connect("db.internal.corp")`} {
+		added, err := s.Add(history.Entry{Source: history.SourceREPL, Code: c})
+		require.NoError(t, err)
+		assert.False(t, added, c)
+	}
+	added, err := s.Add(history.Entry{Source: history.SourceREPL, Code: "t = empty_table(5)"})
+	require.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, []string{"t = empty_table(5)"}, codes(s))
+}
+
+func TestHistoryProjectScopePath(t *testing.T) {
+	dhHome := t.TempDir()
+	project := t.TempDir()
+	sub := filepath.Join(project, "analysis")
+	require.NoError(t, os.MkdirAll(sub, 0o755))
+	require.NoError(t, config.WriteDHRC(project, "0.36.0"))
+
+	assert.Equal(t, filepath.Join(dhHome, "history.jsonl"), history.Path(dhHome, history.ScopeGlobal, sub))
+	assert.Equal(t, history.Path(dhHome, history.ScopeProject, project), history.Path(dhHome, history.ScopeProject, sub))
+	assert.NotEqual(t, history.Path(dhHome, history.ScopeProject, project), history.Path(dhHome, history.ScopeProject, t.TempDir()))
+}
+
+func TestHistoryImportsLegacyREPLHistory(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	legacy := "\"print(1)\"\n\"token = 'xyz'\"\nplain line\n"
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "repl_history"), []byte(legacy), 0o644))
+
+	s, err := history.Open(tmp, tmp)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"print(1)", "plain line"}, codes(s))
+	assert.NoFileExists(t, filepath.Join(tmp, "repl_history"))
+	assert.FileExists(t, filepath.Join(tmp, "history.jsonl"))
+}
+
+func TestSetHistoryConfigValidation(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	require.NoError(t, config.Set("history.max_entries", "1000"))
+	require.NoError(t, config.Set("history.dedup", "all"))
+	require.NoError(t, config.Set("history.scope", "project"))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, 1000, cfg.History.MaxEntries)
+	assert.Equal(t, "all", cfg.History.Dedup)
+	assert.Equal(t, "project", cfg.History.Scope)
+
+	assert.Error(t, config.Set("history.max_entries", "lots"))
+	assert.Error(t, config.Set("history.dedup", "sometimes"))
+	assert.Error(t, config.Set("history.scope", "team"))
+	assert.Error(t, config.Set("history.exclude", "([unclosed"))
+}
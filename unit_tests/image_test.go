@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/image"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageDockerfile(t *testing.T) {
+	df := image.Dockerfile(image.Options{
+		Version:  "0.36.0",
+		Packages: []string{"deephaven-plugin-ui", "polars==1.0"},
+		Script:   "jobs/nightly.py",
+		Exit:     true,
+		Tool:     "1.2.3",
+	})
+	assert.True(t, strings.HasPrefix(df, vm.ServerDockerfile("0.36.0", []string{"deephaven-plugin-ui", "polars==1.0"})))
+	assert.Contains(t, df, "    polars==1.0\n")
+	assert.Contains(t, df, `io.deephaven.version="0.36.0"`)
+	assert.Contains(t, df, `dev.dh-cli.tool="1.2.3"`)
+	assert.Contains(t, df, "DH_PORT=10000")
+	assert.Contains(t, df, "ENV DH_SCRIPT=/app/nightly.py \\\n    DH_EXIT=1\n")
+	assert.Contains(t, df, "EXPOSE 10000\n")
+
+	serve := image.Dockerfile(image.Options{Version: "0.36.0", Port: 8080})
+	assert.NotContains(t, serve, "DH_SCRIPT")
+	assert.NotContains(t, serve, "COPY app/")
+	assert.Contains(t, serve, "EXPOSE 8080\n")
+	assert.Contains(t, serve, `DH_JVM_ARGS="`+image.DefaultJVMArgs+`"`)
+}
+
+func TestImageDefaultTag(t *testing.T) {
+	assert.Equal(t, "dh-server:0.36.0", image.DefaultTag(image.Options{Version: "0.36.0"}))
+	assert.Equal(t, "dh-my-dashboard:0.36.0", image.DefaultTag(image.Options{Version: "0.36.0", Script: "src/My_Dashboard.py"}))
+}
+
+func TestImageWriteContext(t *testing.T) {
+	src := t.TempDir()
+	script := filepath.Join(src, "app.py")
+	require.NoError(t, os.WriteFile(script, []byte("import util\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "pkg", "__pycache__"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "pkg", "util.py"), []byte("x = 1\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "pkg", "__pycache__", "util.pyc"), nil, 0o644))
+
+	ctx := filepath.Join(t.TempDir(), "ctx")
+	require.NoError(t, image.WriteContext(ctx, image.Options{
+		Version: "0.36.0",
+		Script:  script,
+		Files:   []string{filepath.Join(src, "pkg")},
+	}))
+	assert.FileExists(t, filepath.Join(ctx, "Dockerfile"))
+	assert.FileExists(t, filepath.Join(ctx, "entrypoint.py"))
+	assert.FileExists(t, filepath.Join(ctx, "app", "app.py"))
+	assert.FileExists(t, filepath.Join(ctx, "app", "pkg", "util.py"))
+	assert.NoDirExists(t, filepath.Join(ctx, "app", "pkg", "__pycache__"))
+
+	err := image.WriteContext(ctx, image.Options{Version: "0.36.0", Script: script, Files: []string{t.TempDir()}})
+	assert.ErrorContains(t, err, "not inside the script's directory")
+}
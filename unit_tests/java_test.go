@@ -3,6 +3,7 @@ package tests
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/dsmmcken/dh-cli/src/internal/java"
@@ -135,3 +136,105 @@ echo 'openjdk version "17.0.2" 2022-01-18' >&2
 	assert.Equal(t, "17.0.2", info.Version)
 	assert.Equal(t, "JAVA_HOME", info.Source)
 }
+
+func TestParseFlagError_UnrecognizedOption(t *testing.T) {
+	out := `Unrecognized option: -Xmxx4g
+Error: Could not create the Java Virtual Machine.
+Error: A fatal exception has occurred. Program will exit.`
+	e := java.ParseFlagError(out, []string{"-Xmxx4g", "-Dfoo=bar"})
+	assert.Equal(t, "-Xmxx4g", e.Flag)
+	assert.Equal(t, "Unrecognized option: -Xmxx4g", e.Message)
+}
+
+func TestParseFlagError_UnrecognizedVMOption(t *testing.T) {
+	out := `Unrecognized VM option 'UseZGCC'
+Error: Could not create the Java Virtual Machine.
+Error: A fatal exception has occurred. Program will exit.`
+	e := java.ParseFlagError(out, []string{"-Xmx4g", "-XX:+UseZGCC"})
+	assert.Equal(t, "-XX:+UseZGCC", e.Flag)
+	assert.Contains(t, e.Error(), "invalid JVM flag -XX:+UseZGCC")
+}
+
+func TestParseFlagError_ImproperlySpecified(t *testing.T) {
+	out := `Improperly specified VM option 'MaxGCPauseMillis=abc'
+Error: Could not create the Java Virtual Machine.`
+	e := java.ParseFlagError(out, []string{"-XX:MaxGCPauseMillis=abc"})
+	assert.Equal(t, "-XX:MaxGCPauseMillis=abc", e.Flag)
+}
+
+func TestParseFlagError_InvalidHeapSize(t *testing.T) {
+	out := `Error occurred during initialization of VM
+Invalid maximum heap size: -Xmx4q`
+	e := java.ParseFlagError(out, []string{"-Xmx4q"})
+	assert.Equal(t, "-Xmx4q", e.Flag)
+	assert.Equal(t, "Invalid maximum heap size: -Xmx4q", e.Message)
+}
+
+func TestParseFlagError_Unidentified(t *testing.T) {
+	out := `Error occurred during initialization of VM
+Initial heap size set to a larger value than the maximum heap size`
+	e := java.ParseFlagError(out, []string{"-Xms8g", "-Xmx4g"})
+	assert.Empty(t, e.Flag)
+	assert.Equal(t, "Initial heap size set to a larger value than the maximum heap size", e.Message)
+	assert.Contains(t, e.Error(), "invalid JVM arguments")
+}
+
+// fakeJava writes a java stand-in that rejects -Xbad and logs each run.
+func fakeJava(t *testing.T) (javaPath, logPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	javaPath = filepath.Join(dir, "java")
+	logPath = filepath.Join(dir, "runs")
+	script := `#!/bin/sh
+echo run >> ` + logPath + `
+for a in "$@"; do
+  if [ "$a" = "-Xbad" ]; then
+    echo "Unrecognized option: -Xbad" >&2
+    echo "Error: Could not create the Java Virtual Machine." >&2
+    exit 1
+  fi
+done
+echo 'openjdk version "21.0.5" 2024-10-15' >&2
+`
+	require.NoError(t, os.WriteFile(javaPath, []byte(script), 0o755))
+	return javaPath, logPath
+}
+
+func TestCheckFlags(t *testing.T) {
+	javaPath, _ := fakeJava(t)
+
+	assert.NoError(t, java.CheckFlags(javaPath, java.SplitFlags("-Xmx4g  -Dx=y")))
+
+	err := java.CheckFlags(javaPath, []string{"-Xmx4g", "-Xbad"})
+	var flagErr *java.FlagError
+	require.ErrorAs(t, err, &flagErr)
+	assert.Equal(t, "-Xbad", flagErr.Flag)
+
+	err = java.CheckFlags(javaPath, []string{"Xmx4g"})
+	require.ErrorAs(t, err, &flagErr)
+	assert.Equal(t, "Xmx4g", flagErr.Flag)
+}
+
+func TestValidateFlagsCachesSuccess(t *testing.T) {
+	javaPath, logPath := fakeJava(t)
+	dhHome := t.TempDir()
+	runs := func() int {
+		data, _ := os.ReadFile(logPath)
+		return len(strings.Fields(string(data)))
+	}
+
+	require.NoError(t, java.ValidateFlags(dhHome, javaPath, []string{"-Xmx4g"}))
+	require.NoError(t, java.ValidateFlags(dhHome, javaPath, []string{"-Xmx4g"}))
+	assert.Equal(t, 1, runs())
+
+	require.NoError(t, java.ValidateFlags(dhHome, javaPath, []string{"-Xmx8g"}))
+	assert.Equal(t, 2, runs())
+
+	// Failures are not cached.
+	assert.Error(t, java.ValidateFlags(dhHome, javaPath, []string{"-Xbad"}))
+	assert.Error(t, java.ValidateFlags(dhHome, javaPath, []string{"-Xbad"}))
+	assert.Equal(t, 4, runs())
+
+	require.NoError(t, java.ValidateFlags(dhHome, javaPath, nil))
+	assert.Equal(t, 4, runs())
+}
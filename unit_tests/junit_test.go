@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/junit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJUnitWrite(t *testing.T) {
+	cases := []junit.Case{
+		{Name: "tests/test_a.py", Classname: "tests.test_a", Time: 1.5},
+		{Name: "tests/test_b.py", Classname: "tests.test_b", Time: 0.25,
+			Failure:    &junit.Problem{Message: "AssertionError: 3 rows", Type: "assertion", Text: "Traceback <here>\nAssertionError: 3 rows"},
+			Properties: &junit.Properties{Items: []junit.Property{{Name: "table.t", Value: "3 rows"}}}},
+		{Name: "tests/test_c.py", Classname: "tests.test_c", Error: &junit.Problem{Message: "Failed to connect", Type: "connection"}},
+	}
+	var out bytes.Buffer
+	require.NoError(t, junit.Write(&out, "dh test", junit.NewSuite("dh test", time.Now(), cases)))
+	assert.Contains(t, out.String(), `<testsuites name="dh test" tests="3" failures="1" errors="1" time="1.750">`)
+	assert.Contains(t, out.String(), `Traceback &lt;here&gt;`)
+	assert.Equal(t, 1, bytes.Count(out.Bytes(), []byte("<properties>")))
+
+	var parsed junit.Suites
+	require.NoError(t, xml.Unmarshal(out.Bytes(), &parsed))
+	require.Len(t, parsed.Suites, 1)
+	assert.Equal(t, "assertion", parsed.Suites[0].Cases[1].Failure.Type)
+	assert.Nil(t, parsed.Suites[0].Cases[0].Failure)
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dsmmcken/dh-cli/src/internal/discovery"
+	"github.com/dsmmcken/dh-cli/src/internal/repl"
+	"github.com/dsmmcken/dh-cli/src/internal/tui"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/screens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata/golden")
+
+var ansiRE = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+
+// assertGolden compares a rendered view, with styling and trailing spaces
+// stripped, against testdata/golden/<name>.golden. Run with -update to
+// rewrite the file.
+func assertGolden(t *testing.T, name, view string) {
+	t.Helper()
+	lines := strings.Split(ansiRE.ReplaceAllString(view, ""), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " ")
+	}
+	got := strings.Join(lines, "\n") + "\n"
+
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+		return
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "missing golden file; run go test -update")
+	assert.Equal(t, string(want), got)
+}
+
+func resize[M tea.Model](t *testing.T, m M, width, height int) M {
+	t.Helper()
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: width, Height: height})
+	return updated.(M)
+}
+
+func TestGolden_AppTooSmall(t *testing.T) {
+	dhHome, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	app := resize(t, tui.NewApp(tui.MenuMode, dhHome), 24, 6)
+	assertGolden(t, "app_too_small", app.View())
+}
+
+func TestGolden_MainMenuNarrow(t *testing.T) {
+	dhHome, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	m := resize(t, screens.NewMainMenu(dhHome), 40, 24)
+	assertGolden(t, "mainmenu_narrow", m.View())
+}
+
+func TestGolden_VersionsNarrow(t *testing.T) {
+	m := resize(t, screens.NewVersionsScreen(t.TempDir()), 40, 20)
+	updated, _ := m.Update(screens.VersionsListLoadedMsg{
+		Entries: []screens.VersionEntry{
+			{Version: "0.37.1", DateStr: "2025-01-10"},
+			{Version: "0.36.0", Installed: true, IsDefault: true, DateStr: "2024-09-02"},
+		},
+		Dflt: "0.36.0",
+	})
+	assertGolden(t, "versions_narrow", updated.View())
+}
+
+func TestGolden_ServersNarrow(t *testing.T) {
+	m := resize(t, screens.NewServersScreen(), 40, 20)
+	updated, _ := m.Update(screens.ServersLoadedMsg{Servers: []discovery.Server{
+		{Port: 10000, PID: 4242, Source: "dh serve", Script: "/home/user/projects/analytics/dashboard.py"},
+		{Port: 10001, Source: "docker", ContainerID: "3f2a9c1b7d4e"},
+	}})
+	assertGolden(t, "servers_narrow", updated.View())
+}
+
+func TestGolden_REPLWide(t *testing.T) {
+	m := resize(t, repl.NewREPLModel(repl.SessionConfig{DHHome: t.TempDir()}), 90, 16)
+	assertGolden(t, "repl_wide", m.View())
+}
+
+func TestGolden_REPLNarrow(t *testing.T) {
+	m := resize(t, repl.NewREPLModel(repl.SessionConfig{DHHome: t.TempDir()}), 40, 16)
+	assertGolden(t, "repl_narrow", m.View())
+}
+
+func TestGolden_REPLTooSmall(t *testing.T) {
+	m := resize(t, repl.NewREPLModel(repl.SessionConfig{DHHome: t.TempDir()}), 40, 6)
+	assertGolden(t, "repl_too_small", m.View())
+}
+
+func TestGolden_TabBarCompact(t *testing.T) {
+	tb := repl.NewTabBar()
+	tb.AddTableTab("trades", 1200, true)
+	tb.AddTableTab("a_table_with_a_very_long_name", 5, false)
+	tb.SetActiveByName("a_table_with_a_very_long_name")
+	tb.SetWidth(30)
+	tb.SetCompact(true)
+	assertGolden(t, "tabbar_compact", tb.View())
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/lint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lintRules(diags []lint.Diagnostic) []string {
+	var rules []string
+	for _, d := range diags {
+		rules = append(rules, d.Rule)
+	}
+	return rules
+}
+
+func TestLint_Clean(t *testing.T) {
+	src := `from deephaven import empty_table, agg
+
+t = empty_table(10).update(["X = i", "Y = X * 2 + NULL_INT", "S = ` + "`a`" + `"])
+t = t.where("X > 3 && Y < 10").view(["X", "Z = Y"])
+s = t.agg_by([agg.sum_(["Total = Z"]), agg.count_("N")], by=["X"])
+`
+	assert.Empty(t, lint.File("q.py", src, lint.Options{Version: "0.36.0"}))
+}
+
+func TestLint_PythonIdioms(t *testing.T) {
+	src := `t = t.update(["A = B and C", "D = True", "E = int(B)"])` + "\n"
+	diags := lint.File("q.py", src, lint.Options{})
+	require.Len(t, diags, 3)
+	assert.Equal(t, []string{lint.RulePythonOperator, lint.RulePythonLiteral, lint.RulePythonCast}, lintRules(diags))
+	for _, d := range diags {
+		assert.Equal(t, lint.SeverityError, d.Severity)
+	}
+	// "A = B and C" starts at column 16; "and" is 6 bytes in.
+	assert.Equal(t, 1, diags[0].Line)
+	assert.Equal(t, 22, diags[0].Column)
+	assert.Equal(t, `q.py:1:22: error: use && instead of Python's "and" [python-operator]`, diags[0].String())
+}
+
+func TestLint_FormulaShape(t *testing.T) {
+	src := `t = t.update(["A + 1", "1X = 2", "B = (A + 1", "C = A)", "D = 'x"])` + "\n"
+	diags := lint.File("q.py", src, lint.Options{})
+	assert.Equal(t, []string{
+		lint.RuleMissingAssign, lint.RuleInvalidColumn, lint.RuleUnbalanced, lint.RuleUnbalanced, lint.RuleUnbalanced,
+	}, lintRules(diags))
+}
+
+func TestLint_ComparisonIsNotAssignment(t *testing.T) {
+	src := `t = t.where(["A == 1", "B >= 2", "C != 3"])` + "\n"
+	assert.Empty(t, lint.File("q.py", src, lint.Options{}))
+}
+
+func TestLint_UnknownColumnInChain(t *testing.T) {
+	src := `t = (
+    empty_table(5)
+    .update("X = i")
+    .where("Y > 1")
+    .view("X")
+    .update("Z = X + Timestamp")
+)
+tt = time_table("PT1s").update("V = Timestamp")
+`
+	diags := lint.File("q.py", src, lint.Options{})
+	require.Len(t, diags, 2)
+	assert.Equal(t, lint.RuleUnknownColumn, diags[0].Rule)
+	assert.Equal(t, lint.SeverityWarning, diags[0].Severity)
+	assert.Equal(t, 4, diags[0].Line)
+	assert.Contains(t, diags[0].Message, `"Y"`)
+	// view() drops the columns it does not select.
+	assert.Equal(t, 6, diags[1].Line)
+	assert.Contains(t, diags[1].Message, `"Timestamp"`)
+}
+
+func TestLint_UnknownTableSkipsColumnCheck(t *testing.T) {
+	src := `t = source.update("X = Y")` + "\n"
+	assert.Empty(t, lint.File("q.py", src, lint.Options{}))
+}
+
+func TestLint_PythonVariablesAreNotColumns(t *testing.T) {
+	src := `limit = 5
+t = empty_table(5).update("X = i").where("X < limit")
+`
+	assert.Empty(t, lint.File("q.py", src, lint.Options{}))
+}
+
+func TestLint_AggColumns(t *testing.T) {
+	src := `t = t.agg_by([agg.avg(["A = B", "C = D = E", "F G"]), agg.formula("each * 2", cols=["X"])])` + "\n"
+	diags := lint.File("q.py", src, lint.Options{})
+	assert.Equal(t, []string{lint.RuleInvalidAggInput, lint.RuleInvalidAggInput}, lintRules(diags))
+}
+
+func TestLint_Deprecated(t *testing.T) {
+	src := `t = t.update("T = currentTime()")` + "\n"
+
+	diags := lint.File("q.py", src, lint.Options{})
+	require.Len(t, diags, 1)
+	assert.Equal(t, lint.RuleDeprecated, diags[0].Rule)
+	assert.Equal(t, lint.SeverityWarning, diags[0].Severity)
+	assert.Contains(t, diags[0].Message, "now")
+
+	diags = lint.File("q.py", src, lint.Options{Version: "0.36.0"})
+	require.Len(t, diags, 1)
+	assert.Equal(t, lint.SeverityError, diags[0].Severity)
+
+	assert.Empty(t, lint.File("q.py", src, lint.Options{Version: "0.25.3"}))
+}
+
+func TestLint_SkipsFStringsAndComments(t *testing.T) {
+	src := `# t.update("A = B and C")
+t = t.update(f"A = {x} and B")
+t = t.update("""X = 1""")
+`
+	assert.Empty(t, lint.File("q.py", src, lint.Options{}))
+}
+
+func TestLint_MultiLineStringLine(t *testing.T) {
+	src := `t = t.update([
+    "A = 1",
+    "B = None",
+])
+`
+	diags := lint.File("q.py", src, lint.Options{})
+	require.Len(t, diags, 1)
+	assert.Equal(t, 3, diags[0].Line)
+	assert.Equal(t, 10, diags[0].Column)
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/notebook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKernelSpec_Embedded(t *testing.T) {
+	k := &notebook.Kernel{
+		Version:   "0.36.0",
+		PythonBin: "/home/u/.dh/versions/0.36.0/.venv/bin/python",
+		JavaHome:  "/opt/jdk",
+		JVMArgs:   "-Xmx8g",
+		Port:      10000,
+	}
+	assert.Equal(t, "deephaven-0.36.0", k.Name())
+	assert.Equal(t, "Deephaven 0.36.0", k.DisplayName())
+
+	spec := k.Spec("/tmp/startup.py")
+	assert.Equal(t, []string{
+		"/home/u/.dh/versions/0.36.0/.venv/bin/python", "-m", "ipykernel_launcher",
+		"-f", "{connection_file}", "--IPKernelApp.exec_files=/tmp/startup.py",
+	}, spec.Argv)
+	assert.Equal(t, "python", spec.Language)
+	assert.Equal(t, map[string]string{
+		"DH_NOTEBOOK_MODE":     "embedded",
+		"DH_NOTEBOOK_PORT":     "10000",
+		"DH_NOTEBOOK_JVM_ARGS": "-Xmx8g",
+		"JAVA_HOME":            "/opt/jdk",
+	}, spec.Env)
+}
+
+func TestKernelSpec_Remote(t *testing.T) {
+	k := &notebook.Kernel{
+		Version:   "0.36.0",
+		PythonBin: "python",
+		Port:      8080,
+		Host:      "localhost",
+		AuthType:  "io.deephaven.authentication.psk.PskAuthenticationHandler",
+		AuthToken: "s3cret",
+		TLS:       true,
+	}
+	assert.Equal(t, "Deephaven 0.36.0 (localhost:8080)", k.DisplayName())
+
+	spec := k.Spec("startup.py")
+	assert.Equal(t, "remote", spec.Env["DH_NOTEBOOK_MODE"])
+	assert.Equal(t, "localhost", spec.Env["DH_NOTEBOOK_HOST"])
+	assert.Equal(t, "8080", spec.Env["DH_NOTEBOOK_PORT"])
+	assert.Equal(t, "s3cret", spec.Env["DH_NOTEBOOK_AUTH_TOKEN"])
+	assert.Equal(t, "1", spec.Env["DH_NOTEBOOK_TLS"])
+	assert.NotContains(t, spec.Env, "JAVA_HOME")
+}
+
+func TestKernelInstall(t *testing.T) {
+	dhHome := t.TempDir()
+	k := &notebook.Kernel{Version: "0.36.0", PythonBin: "python", JavaHome: "/opt/jdk", Port: 10000}
+
+	dir, err := notebook.Install(dhHome, k)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dhHome, "jupyter", "kernels", "deephaven-0.36.0"), dir)
+
+	startup, err := os.ReadFile(filepath.Join(dir, "startup.py"))
+	require.NoError(t, err)
+	assert.Contains(t, string(startup), "deephaven_server")
+
+	info, err := os.Stat(filepath.Join(dir, "kernel.json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "the spec may hold an auth token")
+
+	data, err := os.ReadFile(filepath.Join(dir, "kernel.json"))
+	require.NoError(t, err)
+	var spec notebook.KernelSpec
+	require.NoError(t, json.Unmarshal(data, &spec))
+	assert.Equal(t, "Deephaven 0.36.0", spec.DisplayName)
+	assert.Contains(t, spec.Argv, "--IPKernelApp.exec_files="+filepath.Join(dir, "startup.py"))
+
+	// Installing again replaces the configuration
+	k.Host = "localhost"
+	_, err = notebook.Install(dhHome, k)
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(dir, "kernel.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"DH_NOTEBOOK_MODE": "remote"`)
+}
+
+func TestJupyterPathEnv(t *testing.T) {
+	t.Setenv("JUPYTER_PATH", "")
+	assert.Equal(t, "JUPYTER_PATH="+filepath.Join("/h", "jupyter"), notebook.JupyterPathEnv("/h"))
+
+	t.Setenv("JUPYTER_PATH", "/other")
+	assert.Equal(t, "JUPYTER_PATH="+filepath.Join("/h", "jupyter")+string(os.PathListSeparator)+"/other", notebook.JupyterPathEnv("/h"))
+}
+
+func TestNotebookCommandHelp(t *testing.T) {
+	out, err := execRoot(t, "notebook", "--help")
+	require.NoError(t, err)
+	assert.Contains(t, out, "JupyterLab")
+	assert.Contains(t, out, "--kernel-only")
+	assert.Contains(t, out, "--target")
+}
@@ -3,6 +3,7 @@ package tests
 import (
 	"bytes"
 	"encoding/json"
+	"os/exec"
 	"testing"
 
 	"github.com/dsmmcken/dh-cli/src/internal/output"
@@ -56,3 +57,108 @@ func TestSetAndGetFlags(t *testing.T) {
 	// Reset
 	output.SetFlags(false, false, false)
 }
+
+func TestTraceOnlyWhenEnabled(t *testing.T) {
+	buf := new(bytes.Buffer)
+	old := output.SetTraceWriter(buf)
+	defer output.SetTraceWriter(old)
+	defer output.SetTrace(false)
+
+	output.SetTrace(false)
+	output.Tracef("hidden %d", 1)
+	assert.Empty(t, buf.String())
+
+	output.SetTrace(true)
+	assert.True(t, output.IsTrace())
+	output.Tracef("shown %d", 2)
+	assert.Regexp(t, `^\[trace \d\d:\d\d:\d\d\.\d{3}\] shown 2\n$`, buf.String())
+}
+
+func TestTraceCommandElidesScriptsAndSecrets(t *testing.T) {
+	buf := new(bytes.Buffer)
+	old := output.SetTraceWriter(buf)
+	defer output.SetTraceWriter(old)
+	output.SetTrace(true)
+	defer output.SetTrace(false)
+
+	cmd := exec.Command("python", "-c", "import sys\nprint(1)\n", "--auth-token", "s3cret", "--tls-key=k", "--password=hunter2", "--jvm-args=-Xmx4g -Dx=1")
+	output.TraceCommand(cmd)
+	out := buf.String()
+	assert.Contains(t, out, "run: python -c <20 bytes> --auth-token <redacted> --tls-key=k --password=<redacted> '--jvm-args=-Xmx4g -Dx=1'")
+	assert.NotContains(t, out, "s3cret")
+	assert.NotContains(t, out, "hunter2")
+}
+
+func TestTraceFrameTruncates(t *testing.T) {
+	buf := new(bytes.Buffer)
+	old := output.SetTraceWriter(buf)
+	defer output.SetTraceWriter(old)
+	output.SetTrace(true)
+	defer output.SetTrace(false)
+
+	output.TraceFrame("vsock:10000", "->", []byte(`{"code":"x"}`+"\n"))
+	assert.Contains(t, buf.String(), `vsock:10000 -> {"code":"x"}`+"\n")
+
+	buf.Reset()
+	output.TraceFrame("pool", "<-", bytes.Repeat([]byte("a"), 5000))
+	assert.Contains(t, buf.String(), "... (5000 bytes)")
+	assert.Less(t, buf.Len(), 2200)
+}
+
+func TestDoubleVerboseEnablesTrace(t *testing.T) {
+	defer output.SetFlags(false, false, false)
+	defer output.SetTrace(false)
+
+	_, err := execRoot(t, "-vv", "config", "path")
+	require.NoError(t, err)
+	assert.True(t, output.IsVerbose())
+	assert.True(t, output.IsTrace())
+
+	_, err = execRoot(t, "--verbose", "config", "path")
+	require.NoError(t, err)
+	assert.True(t, output.IsVerbose())
+	assert.False(t, output.IsTrace())
+}
+
+func TestAnnotate(t *testing.T) {
+	t.Setenv("GITHUB_WORKSPACE", "/work/repo")
+	buf := new(bytes.Buffer)
+
+	output.Annotate(buf, output.Annotation{
+		Level: output.AnnotationError, File: "/work/repo/src/a.py", Line: 3, Col: 7,
+		Title: "dh lint: rule", Message: "50% off\nsecond line",
+	})
+	assert.Equal(t, "::error file=src/a.py,line=3,col=7,title=dh lint: rule::50%25 off%0Asecond line\n", buf.String())
+
+	// No file: the line is dropped too; "," and "::" in properties are escaped
+	buf.Reset()
+	output.Annotate(buf, output.Annotation{Level: output.AnnotationWarning, Line: 3, Title: "a,b::c", Message: "m"})
+	assert.Equal(t, "::warning title=a%2Cb%3A%3Ac::m\n", buf.String())
+
+	// Files outside the workspace keep their absolute path
+	assert.Equal(t, "/elsewhere/b.py", output.AnnotationPath("/elsewhere/b.py"))
+}
+
+func TestAnnotateFlag(t *testing.T) {
+	defer output.SetAnnotate(false)
+	defer output.SetFlags(false, false, false)
+
+	t.Setenv("GITHUB_ACTIONS", "true")
+	_, err := execRoot(t, "config", "path")
+	require.NoError(t, err)
+	assert.True(t, output.IsAnnotate())
+
+	_, err = execRoot(t, "--annotate=false", "config", "path")
+	require.NoError(t, err)
+	assert.False(t, output.IsAnnotate())
+
+	// Never in JSON mode
+	_, err = execRoot(t, "--json", "config", "path")
+	require.NoError(t, err)
+	assert.False(t, output.IsAnnotate())
+
+	t.Setenv("GITHUB_ACTIONS", "")
+	_, err = execRoot(t, "--annotate", "config", "path")
+	require.NoError(t, err)
+	assert.True(t, output.IsAnnotate())
+}
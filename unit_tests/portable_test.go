@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/portable"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePortableInstall makes a uv-style install of version under dhHome: a
+// standalone Python and a venv linking to it, with a console script.
+func fakePortableInstall(t *testing.T, dhHome, version string) {
+	t.Helper()
+	python := filepath.Join(t.TempDir(), "cpython-3.13.1-linux")
+	require.NoError(t, os.MkdirAll(filepath.Join(python, "bin"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(python, "lib", "python3.13"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(python, "bin", "python3.13"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.Symlink("python3.13", filepath.Join(python, "bin", "python3")))
+
+	venv := filepath.Join(dhHome, "versions", version, ".venv")
+	require.NoError(t, os.MkdirAll(filepath.Join(venv, "bin"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(venv, "pyvenv.cfg"),
+		[]byte("home = "+filepath.Join(python, "bin")+"\nimplementation = CPython\nversion_info = 3.13.1\n"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(python, "bin", "python3.13"), filepath.Join(venv, "bin", "python")))
+	require.NoError(t, os.Symlink("python", filepath.Join(venv, "bin", "python3")))
+	require.NoError(t, os.WriteFile(filepath.Join(venv, "bin", "jupyter"),
+		[]byte("#!"+filepath.Join(venv, "bin", "python")+"\nimport sys\n"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dhHome, "versions", version, "meta.toml"), []byte("installed_at = 2025-01-01T00:00:00Z\n"), 0o644))
+}
+
+func TestPortableExportImport(t *testing.T) {
+	dhHome := t.TempDir()
+	fakePortableInstall(t, dhHome, "0.36.0")
+	binary := filepath.Join(t.TempDir(), "dh")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0o755))
+
+	out := filepath.Join(t.TempDir(), "dh-portable.tar.gz")
+	m, err := portable.Export(portable.ExportOptions{DHHome: dhHome, Version: "0.36.0", Binary: binary, Tool: "1.0.0", NoJava: true}, out, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "3.13.1", m.Python)
+	assert.Empty(t, m.Java)
+
+	dest := t.TempDir()
+	res, err := portable.Import(out, dest)
+	require.NoError(t, err)
+	dir := filepath.Join(dest, "dh-portable")
+	assert.Equal(t, dir, res.Dir)
+	assert.Equal(t, "0.36.0", res.Manifest.Version)
+
+	assert.FileExists(t, filepath.Join(dir, "bin", "dh"))
+	assert.FileExists(t, filepath.Join(dir, "home", "versions", "0.36.0", "meta.toml"))
+	launcher, err := os.ReadFile(filepath.Join(dir, "dh"))
+	require.NoError(t, err)
+	assert.Contains(t, string(launcher), `export DH_HOME="$here/home"`)
+	assert.NotContains(t, string(launcher), "JAVA_HOME")
+	cfg, err := os.ReadFile(filepath.Join(dir, "home", "config.toml"))
+	require.NoError(t, err)
+	assert.Equal(t, "default_version = \"0.36.0\"\n", string(cfg))
+
+	// The venv's python now links into the portable directory.
+	venv := filepath.Join(dir, "home", "versions", "0.36.0", ".venv")
+	link, err := os.Readlink(filepath.Join(venv, "bin", "python"))
+	require.NoError(t, err)
+	assert.False(t, filepath.IsAbs(link))
+	resolved, err := filepath.EvalSymlinks(filepath.Join(venv, "bin", "python3"))
+	require.NoError(t, err)
+	realDir, _ := filepath.EvalSymlinks(dir)
+	assert.Equal(t, filepath.Join(realDir, "python", "bin", "python3.13"), resolved)
+
+	pyvenv, err := os.ReadFile(filepath.Join(venv, "pyvenv.cfg"))
+	require.NoError(t, err)
+	assert.Contains(t, string(pyvenv), "home = "+filepath.Join(realDir, "python", "bin")+"\n")
+	assert.Contains(t, string(pyvenv), "version_info = 3.13.1")
+
+	script, err := os.ReadFile(filepath.Join(venv, "bin", "jupyter"))
+	require.NoError(t, err)
+	realVenv := filepath.Join(realDir, "home", "versions", "0.36.0", ".venv")
+	assert.Equal(t, "#!"+filepath.Join(realVenv, "bin", "python")+"\nimport sys\n", string(script))
+
+	// Moving the directory and relocating again follows it.
+	moved := filepath.Join(t.TempDir(), "elsewhere")
+	require.NoError(t, os.Rename(dir, moved))
+	_, err = portable.Import(moved, "")
+	require.NoError(t, err)
+	realMoved, _ := filepath.EvalSymlinks(moved)
+	pyvenv, _ = os.ReadFile(filepath.Join(moved, "home", "versions", "0.36.0", ".venv", "pyvenv.cfg"))
+	assert.Contains(t, string(pyvenv), "home = "+filepath.Join(realMoved, "python", "bin")+"\n")
+	location, _ := os.ReadFile(filepath.Join(moved, ".location"))
+	assert.Equal(t, realMoved, strings.TrimSpace(string(location)))
+
+	// Importing over an existing directory is refused.
+	require.NoError(t, os.MkdirAll(filepath.Join(dest, "dh-portable"), 0o755))
+	_, err = portable.Import(out, dest)
+	assert.ErrorContains(t, err, "already exists")
+}
+
+func TestPortableExportRefusesSystemPython(t *testing.T) {
+	dhHome := t.TempDir()
+	venv := filepath.Join(dhHome, "versions", "0.36.0", ".venv")
+	require.NoError(t, os.MkdirAll(venv, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(venv, "pyvenv.cfg"), []byte("home = /usr/bin\n"), 0o644))
+
+	_, err := portable.Export(portable.ExportOptions{DHHome: dhHome, Version: "0.36.0", NoJava: true}, filepath.Join(t.TempDir(), "p.tar"), nil)
+	assert.ErrorContains(t, err, "can't be moved")
+
+	_, err = portable.Export(portable.ExportOptions{DHHome: dhHome, Version: "0.99.0", NoJava: true}, filepath.Join(t.TempDir(), "p.tar"), nil)
+	assert.ErrorContains(t, err, "not installed")
+}
+
+func TestPortableImportRefusesLinkChains(t *testing.T) {
+	// Each link stays inside dh-portable on its own, but b resolves to
+	// a/.., the directory above it, and the next entry is written through b.
+	out := filepath.Join(t.TempDir(), "evil.tar")
+	f, err := os.Create(out)
+	require.NoError(t, err)
+	tw := tar.NewWriter(f)
+	for _, h := range []*tar.Header{
+		{Name: "dh-portable/" + portable.ManifestName, Typeflag: tar.TypeReg, Mode: 0o644, Size: 2},
+		{Name: "dh-portable/a", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "dh-portable/b", Typeflag: tar.TypeSymlink, Linkname: "a/.."},
+		{Name: "dh-portable/b/escaped.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 2},
+	} {
+		require.NoError(t, tw.WriteHeader(h))
+		if h.Size > 0 {
+			_, err := tw.Write([]byte("{}"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, f.Close())
+
+	dest := t.TempDir()
+	_, err = portable.Import(out, dest)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dest, "escaped.txt"))
+}
+
+func TestPortableDirName(t *testing.T) {
+	assert.Equal(t, "dh-portable", portable.DirName("out/dh-portable.tar"))
+	assert.Equal(t, "dh-0.36", portable.DirName("dh-0.36.tar.gz"))
+	assert.Equal(t, "deephaven", portable.DirName("deephaven.tgz"))
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dsmmcken/dh-cli/src/internal/repl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCellDetail_PrettyPrintsJSONString(t *testing.T) {
+	m := repl.NewCellDetail("Payload", "java.lang.String", 0, `{"a":1,"b":[true,null]}`)
+	assert.Equal(t, "{\n  \"a\": 1,\n  \"b\": [\n    true,\n    null\n  ]\n}", m.Text())
+}
+
+func TestCellDetail_PrettyPrintsNestedValue(t *testing.T) {
+	m := repl.NewCellDetail("Tags", "", 0, []any{"x", "y"})
+	assert.Equal(t, "[\n  \"x\",\n  \"y\"\n]", m.Text())
+}
+
+func TestCellDetail_KeepsPlainValues(t *testing.T) {
+	assert.Equal(t, "{not json", repl.NewCellDetail("S", "", 0, "{not json").Text())
+	assert.Equal(t, "42", repl.NewCellDetail("N", "", 0, float64(42)).Text())
+	assert.Equal(t, "null", repl.NewCellDetail("N", "", 0, nil).Text())
+}
+
+func TestCellDetail_ViewWrapsLongValues(t *testing.T) {
+	long := strings.Repeat("word ", 40)
+	m := repl.NewCellDetail("Notes", "java.lang.String", 4, long)
+	m.SetSize(40, 20)
+	view := m.View()
+	assert.Contains(t, view, "Notes")
+	assert.Contains(t, view, "row 5")
+	for _, line := range strings.Split(view, "\n") {
+		assert.LessOrEqual(t, lipgloss.Width(line), 40)
+	}
+}
+
+func TestCellDetail_EscCloses(t *testing.T) {
+	m := repl.NewCellDetail("S", "", 0, "v")
+	m.SetSize(40, 10)
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if assert.NotNil(t, cmd) {
+		assert.IsType(t, repl.CellDetailClosedMsg{}, cmd())
+	}
+}
+
+func TestNewSnapshotCmd(t *testing.T) {
+	data, err := json.Marshal(repl.NewSnapshotCmd("ticks", 200, 200))
+	assert.NoError(t, err)
+	var got map[string]any
+	assert.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, "snapshot", got["type"])
+	assert.Equal(t, "ticks", got["name"])
+	assert.Equal(t, float64(200), got["offset"])
+	assert.Equal(t, float64(200), got["limit"])
+	assert.NotEmpty(t, got["id"])
+}
+
+func tablePage(offset, n, total int) *repl.Response {
+	rows := make([][]any, n)
+	for i := range rows {
+		rows[i] = []any{float64(offset + i)}
+	}
+	return &repl.Response{Name: "t", Columns: []string{"X"}, Types: []string{"int64"}, Rows: rows, TotalRows: total, Offset: offset}
+}
+
+func TestTableView_PrefetchesNextPage(t *testing.T) {
+	tv := repl.NewTableView("t", repl.TableMeta{Name: "t", RowCount: 1000, Columns: []repl.ColumnMeta{{Name: "X", Type: "int64"}}})
+	tv.SetSize(80, 20)
+
+	cmd := tv.SetData(tablePage(0, 200, 1000))
+	if assert.NotNil(t, cmd) {
+		assert.Equal(t, repl.TablePageRequestMsg{Name: "t", Offset: 200, Prefetch: true}, cmd())
+	}
+	assert.Contains(t, tv.View(), "rows 1–200 of 1000")
+}
+
+func TestTableView_JumpToEndShowsProgressUntilLoaded(t *testing.T) {
+	tv := repl.NewTableView("t", repl.TableMeta{Name: "t", RowCount: 1000, Columns: []repl.ColumnMeta{{Name: "X", Type: "int64"}}})
+	tv.SetSize(80, 20)
+	tv.SetData(tablePage(0, 200, 1000))
+
+	assert.NotNil(t, tv.JumpToEnd())
+	assert.Contains(t, tv.View(), "loading rows 801–1000 of 1000")
+
+	tv.SetData(tablePage(800, 200, 1000))
+	offset, limit := tv.Window()
+	assert.Equal(t, 800, offset)
+	assert.Equal(t, 200, limit)
+	assert.Contains(t, tv.View(), "rows 801–1000 of 1000")
+	assert.NotContains(t, tv.View(), "loading")
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dsmmcken/dh-cli/src/internal/java"
+	"github.com/dsmmcken/dh-cli/src/internal/sbom"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeDistInfo creates a *.dist-info directory with a METADATA file in a
+// fake venv for version.
+func writeDistInfo(t *testing.T, dhHome, version, name, ver, license string) {
+	t.Helper()
+	dir := filepath.Join(dhHome, "versions", version, ".venv", "lib", "python3.13", "site-packages", name+"-"+ver+".dist-info")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	meta := "Metadata-Version: 2.4\nName: " + name + "\nVersion: " + ver + "\n"
+	if license != "" {
+		meta += "License-Expression: " + license + "\n"
+	}
+	meta += "\nName: not-a-header\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "METADATA"), []byte(meta), 0o644))
+}
+
+func stubJava(t *testing.T, info *java.JavaInfo, err error) {
+	t.Helper()
+	orig := sbom.JavaDetector
+	sbom.JavaDetector = func(string) (*java.JavaInfo, error) { return info, err }
+	t.Cleanup(func() { sbom.JavaDetector = orig })
+}
+
+func TestSBOMCollectVenvAndJava(t *testing.T) {
+	dhHome := t.TempDir()
+	writeDistInfo(t, dhHome, "0.36.0", "deephaven_server", "0.36.0", "Apache-2.0")
+	writeDistInfo(t, dhHome, "0.36.0", "Jpy", "1.1.0", "")
+	stubJava(t, &java.JavaInfo{Found: true, Version: "21.0.5"}, nil)
+
+	inv, err := sbom.Collect(dhHome, "0.36.0", "1.2.3")
+	require.NoError(t, err)
+	require.Len(t, inv.Components, 3)
+
+	assert.Equal(t, "deephaven_server", inv.Components[0].Name)
+	assert.Equal(t, "pkg:pypi/deephaven-server@0.36.0", inv.Components[0].PURL)
+	assert.Equal(t, "Apache-2.0", inv.Components[0].License)
+	assert.Equal(t, "pkg:pypi/jpy@1.1.0", inv.Components[1].PURL)
+	assert.Equal(t, sbom.SourceJava, inv.Components[2].Source)
+	assert.Equal(t, "21.0.5", inv.Components[2].Version)
+	assert.Empty(t, inv.Notes)
+}
+
+func TestSBOMCollectIncludesRootfsManifest(t *testing.T) {
+	dhHome := t.TempDir()
+	writeDistInfo(t, dhHome, "0.36.0", "deephaven-server", "0.36.0", "")
+	stubJava(t, nil, errors.New("not found"))
+
+	paths := vm.NewVMPaths(dhHome)
+	require.NoError(t, os.MkdirAll(paths.RootfsDir, 0o755))
+	require.NoError(t, vm.WriteRootfsManifest(paths, "0.36.0", &vm.RootfsManifest{
+		OS: "ubuntu 22.04",
+		Packages: []vm.RootfsPackage{
+			{Name: "libc6", Version: "2.35-0ubuntu3", Type: "deb"},
+			{Name: "deephaven-server", Version: "0.36.0", Type: "pypi"},
+		},
+	}))
+
+	inv, err := sbom.Collect(dhHome, "0.36.0", "dev")
+	require.NoError(t, err)
+	assert.Contains(t, inv.Notes, "no Java runtime found")
+
+	var rootfs []sbom.Component
+	for _, c := range inv.Components {
+		if c.Source == sbom.SourceRootfs {
+			rootfs = append(rootfs, c)
+		}
+	}
+	require.Len(t, rootfs, 3)
+	assert.Equal(t, "operating-system", rootfs[0].Type)
+	assert.Equal(t, "pkg:deb/ubuntu/libc6@2.35-0ubuntu3", rootfs[1].PURL)
+}
+
+func TestSBOMCollectNotInstalled(t *testing.T) {
+	_, err := sbom.Collect(t.TempDir(), "0.36.0", "dev")
+	assert.ErrorContains(t, err, "not installed")
+}
+
+func TestSBOMEncodeCycloneDX(t *testing.T) {
+	inv := &sbom.Inventory{
+		Version: "0.36.0",
+		Tool:    "1.2.3",
+		Created: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Serial:  "00000000-0000-4000-8000-000000000000",
+		Components: []sbom.Component{
+			{Type: "library", Name: "deephaven-server", Version: "0.36.0", PURL: "pkg:pypi/deephaven-server@0.36.0", License: "Apache-2.0", Source: sbom.SourceVenv},
+			{Type: "library", Name: "deephaven-server", Version: "0.36.0", PURL: "pkg:pypi/deephaven-server@0.36.0", Source: sbom.SourceRootfs},
+		},
+	}
+	data, err := sbom.Encode(inv, "cyclonedx")
+	require.NoError(t, err)
+
+	var doc struct {
+		BOMFormat    string `json:"bomFormat"`
+		SpecVersion  string `json:"specVersion"`
+		SerialNumber string `json:"serialNumber"`
+		Metadata     struct {
+			Timestamp string `json:"timestamp"`
+		} `json:"metadata"`
+		Components []struct {
+			BOMRef   string `json:"bom-ref"`
+			Licenses []struct {
+				Expression string `json:"expression"`
+			} `json:"licenses"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, "CycloneDX", doc.BOMFormat)
+	assert.Equal(t, "1.5", doc.SpecVersion)
+	assert.Equal(t, "urn:uuid:00000000-0000-4000-8000-000000000000", doc.SerialNumber)
+	assert.Equal(t, "2025-01-02T03:04:05Z", doc.Metadata.Timestamp)
+	require.Len(t, doc.Components, 2)
+	assert.NotEqual(t, doc.Components[0].BOMRef, doc.Components[1].BOMRef, "bom-refs must be unique")
+	assert.Equal(t, "Apache-2.0", doc.Components[0].Licenses[0].Expression)
+}
+
+func TestSBOMEncodeSPDX(t *testing.T) {
+	inv := &sbom.Inventory{
+		Version: "0.36.0",
+		Tool:    "dev",
+		Serial:  "00000000-0000-4000-8000-000000000000",
+		Components: []sbom.Component{
+			{Type: "library", Name: "jpy", Version: "1.1.0", PURL: "pkg:pypi/jpy@1.1.0", Source: sbom.SourceVenv},
+		},
+	}
+	data, err := sbom.Encode(inv, "spdx")
+	require.NoError(t, err)
+
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			SPDXID          string `json:"SPDXID"`
+			LicenseDeclared string `json:"licenseDeclared"`
+			ExternalRefs    []struct {
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+		Relationships []struct {
+			RelationshipType string `json:"relationshipType"`
+		} `json:"relationships"`
+	}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	require.Len(t, doc.Packages, 2)
+	assert.Equal(t, "NOASSERTION", doc.Packages[1].LicenseDeclared)
+	assert.Equal(t, "pkg:pypi/jpy@1.1.0", doc.Packages[1].ExternalRefs[0].ReferenceLocator)
+	assert.Equal(t, "DESCRIBES", doc.Relationships[0].RelationshipType)
+	assert.Equal(t, "CONTAINS", doc.Relationships[1].RelationshipType)
+}
+
+func TestSBOMEncodeUnknownFormat(t *testing.T) {
+	_, err := sbom.Encode(&sbom.Inventory{}, "xml")
+	assert.ErrorContains(t, err, "unknown SBOM format")
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/config"
+	"github.com/dsmmcken/dh-cli/src/internal/scaffold"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaffoldInit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "proj")
+
+	files, err := scaffold.Init(dir, scaffold.Options{Version: "0.36.0", VSCode: true})
+	require.NoError(t, err)
+
+	var paths []string
+	for _, f := range files {
+		assert.Equal(t, scaffold.Created, f.Status, f.Path)
+		assert.FileExists(t, filepath.Join(dir, f.Path))
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{".dh/config.toml", "example.py", ".gitignore", ".dhrc", ".vscode/tasks.json"}, paths)
+
+	// The pin is the one dh reads
+	v, err := config.ReadDHRC(filepath.Join(dir, ".dhrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "0.36.0", v)
+
+	tasks, err := os.ReadFile(filepath.Join(dir, ".vscode", "tasks.json"))
+	require.NoError(t, err)
+	var parsed struct {
+		Tasks []struct {
+			Label   string   `json:"label"`
+			Command string   `json:"command"`
+			Args    []string `json:"args"`
+		} `json:"tasks"`
+	}
+	require.NoError(t, json.Unmarshal(tasks, &parsed))
+	require.NotEmpty(t, parsed.Tasks)
+	for _, task := range parsed.Tasks {
+		assert.Equal(t, "dh", task.Command, task.Label)
+	}
+}
+
+func TestScaffoldInitOptional(t *testing.T) {
+	dir := t.TempDir()
+
+	files, err := scaffold.Init(dir, scaffold.Options{})
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+	assert.NoFileExists(t, filepath.Join(dir, ".dhrc"))
+	assert.NoDirExists(t, filepath.Join(dir, ".vscode"))
+}
+
+func TestScaffoldInitExisting(t *testing.T) {
+	dir := t.TempDir()
+	example := filepath.Join(dir, "example.py")
+	require.NoError(t, os.WriteFile(example, []byte("mine\n"), 0o644))
+
+	files, err := scaffold.Init(dir, scaffold.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, scaffold.File{Path: "example.py", Status: scaffold.Skipped}, files[1])
+	data, _ := os.ReadFile(example)
+	assert.Equal(t, "mine\n", string(data))
+
+	files, err = scaffold.Init(dir, scaffold.Options{Force: true})
+	require.NoError(t, err)
+	for _, f := range files {
+		assert.Equal(t, scaffold.Overwritten, f.Status, f.Path)
+	}
+	data, _ = os.ReadFile(example)
+	assert.Contains(t, string(data), "from deephaven import")
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/shellenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+// applyShellChanges applies c to env, the way a shell evaluating it would.
+func applyShellChanges(env map[string]string, c shellenv.Changes) {
+	for _, name := range c.Unset {
+		delete(env, name)
+	}
+	for name, v := range c.Set {
+		env[name] = v
+	}
+}
+
+var testShellEnv = shellenv.Env{
+	Version:  "0.36.0",
+	VenvDir:  "/h/.dh/versions/0.36.0/.venv",
+	BinDir:   "/h/.dh/versions/0.36.0/.venv/bin",
+	JavaHome: "/opt/jdk21",
+}
+
+func TestShellEnv_Activate(t *testing.T) {
+	env := map[string]string{"PATH": "/usr/bin:/bin"}
+	c := shellenv.Activate(testShellEnv, envLookup(env))
+
+	assert.Equal(t, "/h/.dh/versions/0.36.0/.venv/bin:/usr/bin:/bin", c.Set["PATH"])
+	assert.Equal(t, "/h/.dh/versions/0.36.0/.venv", c.Set["VIRTUAL_ENV"])
+	assert.Equal(t, "0.36.0", c.Set["DH_VERSION"])
+	assert.Equal(t, "/opt/jdk21", c.Set["JAVA_HOME"])
+	assert.Equal(t, "0.36.0", c.Set["DH_ENV"])
+	assert.Equal(t, "/usr/bin:/bin", c.Set["_DH_OLD_PATH"])
+	assert.Equal(t, []string{"_DH_OLD_DH_VERSION", "_DH_OLD_JAVA_HOME", "_DH_OLD_VIRTUAL_ENV"}, c.Unset)
+}
+
+func TestShellEnv_DeactivateRestores(t *testing.T) {
+	before := map[string]string{"PATH": "/usr/bin", "JAVA_HOME": "/opt/jdk17", "DH_VERSION": "0.35.1"}
+	env := map[string]string{}
+	for k, v := range before {
+		env[k] = v
+	}
+
+	applyShellChanges(env, shellenv.Activate(testShellEnv, envLookup(env)))
+	assert.Equal(t, "/opt/jdk21", env["JAVA_HOME"])
+
+	c, active := shellenv.Deactivate(envLookup(env))
+	require.True(t, active)
+	applyShellChanges(env, c)
+	assert.Equal(t, before, env)
+}
+
+func TestShellEnv_ReactivateKeepsOriginal(t *testing.T) {
+	env := map[string]string{"PATH": "/usr/bin"}
+	applyShellChanges(env, shellenv.Activate(testShellEnv, envLookup(env)))
+
+	other := testShellEnv
+	other.Version = "0.35.1"
+	other.BinDir = "/h/.dh/versions/0.35.1/.venv/bin"
+	applyShellChanges(env, shellenv.Activate(other, envLookup(env)))
+	// The first venv is replaced, not stacked
+	assert.Equal(t, "/h/.dh/versions/0.35.1/.venv/bin:/usr/bin", env["PATH"])
+	assert.Equal(t, "0.35.1", env["DH_VERSION"])
+
+	c, _ := shellenv.Deactivate(envLookup(env))
+	applyShellChanges(env, c)
+	assert.Equal(t, map[string]string{"PATH": "/usr/bin"}, env)
+}
+
+func TestShellEnv_NoJava(t *testing.T) {
+	env := map[string]string{"PATH": "/usr/bin", "JAVA_HOME": "/mine"}
+	e := testShellEnv
+	e.JavaHome = ""
+	c := shellenv.Activate(e, envLookup(env))
+	assert.Equal(t, "/mine", c.Set["JAVA_HOME"])
+}
+
+func TestShellEnv_DeactivateInactive(t *testing.T) {
+	_, active := shellenv.Deactivate(envLookup(map[string]string{"PATH": "/usr/bin"}))
+	assert.False(t, active)
+}
+
+func TestShellEnv_Format(t *testing.T) {
+	c := shellenv.Changes{
+		Set:   map[string]string{"PATH": "/a b:/usr/bin", "X": "it's"},
+		Unset: []string{"Y"},
+	}
+
+	out, err := shellenv.Format(c, "bash")
+	require.NoError(t, err)
+	assert.Equal(t, "unset Y;\nexport PATH='/a b:/usr/bin';\nexport X='it'\\''s';\nhash -r 2>/dev/null;\n", out)
+
+	out, err = shellenv.Format(c, "fish")
+	require.NoError(t, err)
+	assert.Equal(t, "set -e Y;\nset -gx PATH '/a b' '/usr/bin';\nset -gx X 'it\\'s';\n", out)
+
+	_, err = shellenv.Format(c, "tcsh")
+	assert.ErrorContains(t, err, "unsupported shell")
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/snippet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnippetAddGetRemove(t *testing.T) {
+	tmp := t.TempDir()
+
+	require.NoError(t, snippet.Add(tmp, snippet.Snippet{Name: "top10", Code: "t.head(10)", Description: "first rows"}, false))
+
+	s, err := snippet.Get(tmp, "top10")
+	require.NoError(t, err)
+	assert.Equal(t, "top10", s.Name)
+	assert.Equal(t, "t.head(10)", s.Code)
+	assert.Equal(t, "first rows", s.Description)
+	assert.False(t, s.CreatedAt.IsZero())
+
+	err = snippet.Add(tmp, snippet.Snippet{Name: "top10", Code: "x"}, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+	require.NoError(t, snippet.Add(tmp, snippet.Snippet{Name: "top10", Code: "x"}, true))
+
+	require.NoError(t, snippet.Remove(tmp, "top10"))
+	_, err = snippet.Get(tmp, "top10")
+	assert.True(t, errors.Is(err, snippet.ErrNotFound))
+}
+
+func TestSnippetInvalidName(t *testing.T) {
+	err := snippet.Add(t.TempDir(), snippet.Snippet{Name: "../evil", Code: "x"}, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid snippet name")
+}
+
+func TestSnippetListSorted(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, snippet.Add(tmp, snippet.Snippet{Name: "b", Code: "1"}, false))
+	require.NoError(t, snippet.Add(tmp, snippet.Snippet{Name: "a", Code: "2"}, false))
+
+	list, err := snippet.List(tmp)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "a", list[0].Name)
+	assert.Equal(t, "b", list[1].Name)
+}
+
+func TestSnippetRender(t *testing.T) {
+	code := "t = {{table}}.head({{n:10}})\nprint({{ table }})"
+
+	assert.Equal(t, []string{"table", "n"}, snippet.Params(code))
+
+	out, err := snippet.Render(code, map[string]string{"table": "trades"})
+	require.NoError(t, err)
+	assert.Equal(t, "t = trades.head(10)\nprint(trades)", out)
+
+	out, err = snippet.Render(code, map[string]string{"table": "quotes", "n": "5"})
+	require.NoError(t, err)
+	assert.Equal(t, "t = quotes.head(5)\nprint(quotes)", out)
+
+	_, err = snippet.Render(code, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing value for parameter(s): table")
+
+	_, err = snippet.Render(code, map[string]string{"table": "t", "tabel": "x"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown parameter(s): tabel")
+
+	// \{{ is a literal {{, for Python's own escaped braces
+	code = `label = "\{{col}}: {}".format({{table}}.size)` + "\n" + `f"\{{{{n:3}}}}"`
+	assert.Equal(t, []string{"table", "n"}, snippet.Params(code))
+	out, err = snippet.Render(code, map[string]string{"table": "trades"})
+	require.NoError(t, err)
+	assert.Equal(t, `label = "{{col}}: {}".format(trades.size)`+"\n"+`f"{{3}}"`, out)
+}
+
+func TestSnippetParseParams(t *testing.T) {
+	params, err := snippet.ParseParams([]string{"a=1", "b=x=y", "c="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "x=y", "c": ""}, params)
+
+	_, err = snippet.ParseParams([]string{"novalue"})
+	require.Error(t, err)
+}
+
+func TestSnippetExportImport(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, snippet.Add(src, snippet.Snippet{Name: "one", Code: "1"}, false))
+	require.NoError(t, snippet.Add(src, snippet.Snippet{Name: "two", Code: "2"}, false))
+
+	var buf bytes.Buffer
+	require.NoError(t, snippet.Export(src, []string{"two"}, &buf))
+
+	dst := t.TempDir()
+	require.NoError(t, snippet.Add(dst, snippet.Snippet{Name: "one", Code: "local"}, false))
+	var all bytes.Buffer
+	require.NoError(t, snippet.Export(src, nil, &all))
+
+	imported, skipped, err := snippet.Import(dst, &all, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"two"}, imported)
+	assert.Equal(t, []string{"one"}, skipped)
+
+	s, err := snippet.Get(dst, "one")
+	require.NoError(t, err)
+	assert.Equal(t, "local", s.Code)
+
+	_, _, err = snippet.Import(dst, bytes.NewReader(buf.Bytes()), true)
+	require.NoError(t, err)
+	s, err = snippet.Get(dst, "two")
+	require.NoError(t, err)
+	assert.Equal(t, "2", s.Code)
+}
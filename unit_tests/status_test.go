@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/cmd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func statusReport(t *testing.T, args ...string) cmd.StatusReport {
+	t.Helper()
+	out, err := execRoot(t, append([]string{"status", "--json"}, args...)...)
+	require.NoError(t, err)
+	var r cmd.StatusReport
+	require.NoError(t, json.Unmarshal([]byte(out), &r), out)
+	return r
+}
+
+func TestStatusJSON(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+	t.Setenv("DH_VERSION", "")
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.35.1"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.36.0"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "config.toml"), []byte("default_version = \"0.35.1\"\n"), 0o644))
+
+	orig := cmd.LatestVersionFetcher
+	defer func() { cmd.LatestVersionFetcher = orig }()
+	cmd.LatestVersionFetcher = func() (string, error) { return "0.37.0", nil }
+
+	r := statusReport(t, "--config-dir", tmp)
+	assert.Equal(t, "0.35.1", r.Version)
+	assert.Equal(t, "0.35.1", r.DefaultVersion)
+	require.Len(t, r.Installed, 2)
+	assert.Equal(t, "0.36.0", r.Installed[0].Version)
+	assert.Equal(t, tmp, r.Disk.DHHome)
+	assert.Greater(t, r.Disk.UsedBytes, int64(0))
+	assert.NotNil(t, r.Servers)
+	assert.NotNil(t, r.Snapshots)
+	assert.Equal(t, "0.37.0", r.Update.Latest)
+	assert.True(t, r.Update.Available)
+}
+
+func TestStatusUpdate(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "versions", "0.37.0"), 0o755))
+
+	orig := cmd.LatestVersionFetcher
+	defer func() { cmd.LatestVersionFetcher = orig }()
+
+	// Newest installed is the latest release
+	cmd.LatestVersionFetcher = func() (string, error) { return "0.37.0", nil }
+	r := statusReport(t, "--config-dir", tmp)
+	assert.True(t, r.Update.Checked)
+	assert.False(t, r.Update.Available)
+
+	// A failed check is reported, not fatal
+	cmd.LatestVersionFetcher = func() (string, error) { return "", errors.New("no network") }
+	r = statusReport(t, "--config-dir", tmp)
+	assert.True(t, r.Update.Checked)
+	assert.Equal(t, "no network", r.Update.Error)
+
+	// --offline skips the check
+	cmd.LatestVersionFetcher = func() (string, error) {
+		t.Error("fetched the latest version with --offline")
+		return "", nil
+	}
+	r = statusReport(t, "--config-dir", tmp, "--offline")
+	assert.False(t, r.Update.Checked)
+}
+
+func TestStatusText(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+	t.Setenv("DH_VERSION", "")
+
+	out, err := execRoot(t, "--config-dir", tmp, "status", "--offline")
+	require.NoError(t, err)
+	assert.Contains(t, out, "none installed (run: dh install)")
+	assert.Contains(t, out, "Pool        not running")
+	assert.Contains(t, out, "not checked (--offline)")
+}
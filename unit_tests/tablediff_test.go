@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/dsmmcken/dh-cli/src/internal/tablediff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleDiff() *tablediff.Result {
+	return &tablediff.Result{Tables: []tablediff.TableDiff{{
+		Name:          "trades",
+		LeftSchema:    []tablediff.Column{{Name: "Sym", Type: "java.lang.String"}, {Name: "Price", Type: "double"}},
+		RightSchema:   []tablediff.Column{{Name: "Sym", Type: "java.lang.String"}, {Name: "Price", Type: "float"}, {Name: "Size", Type: "int"}},
+		LeftRows:      3,
+		RightRows:     4,
+		Added:         2,
+		Removed:       1,
+		Changed:       1,
+		AddedSample:   []map[string]any{{"Sym": "MSFT", "Price": 3.5}},
+		RemovedSample: []map[string]any{{"Sym": "IBM", "Price": 1.0}},
+		ChangedSample: []tablediff.Change{{
+			Key: map[string]any{"Sym": "AAPL"},
+			Old: map[string]any{"Price": 1.5},
+			New: map[string]any{"Price": 2.5},
+		}},
+	}}}
+}
+
+func TestTableDiffParse(t *testing.T) {
+	stdout := "setup output\n__DH_DIFF__{\"tables\": [{\"name\": \"t\", \"left_rows\": 2, \"right_rows\": 2, \"added\": 0, \"removed\": 0, \"changed\": 0}]}\n"
+	res, err := tablediff.Parse(stdout)
+	require.NoError(t, err)
+	require.Len(t, res.Tables, 1)
+	assert.Equal(t, "t", res.Tables[0].Name)
+	assert.True(t, res.Equal())
+
+	_, err = tablediff.Parse("no result here\n")
+	assert.ErrorContains(t, err, "did not return a diff result")
+}
+
+func TestTableDiffSchemaChanges(t *testing.T) {
+	d := sampleDiff().Tables[0]
+	assert.Equal(t, []string{"- Price (double)", "+ Price (float)", "+ Size (int)"}, d.SchemaChanges())
+	assert.False(t, d.Equal())
+	assert.False(t, (&tablediff.TableDiff{Name: "x", OnlyIn: "left"}).Equal())
+}
+
+func TestTableDiffPatch(t *testing.T) {
+	ops := sampleDiff().Patch()
+	assert.Equal(t, []tablediff.Op{
+		{Op: "replace", Path: "/trades/columns/Price", Value: "float", Old: "double"},
+		{Op: "add", Path: "/trades/columns/Size", Value: "int"},
+		{Op: "remove", Path: "/trades/rows", Old: map[string]any{"Sym": "IBM", "Price": 1.0}},
+		{Op: "add", Path: "/trades/rows", Value: map[string]any{"Sym": "MSFT", "Price": 3.5}},
+		{Op: "replace", Path: "/trades/rows/Sym=AAPL/Price", Value: 2.5, Old: 1.5},
+	}, ops)
+
+	only := &tablediff.Result{Tables: []tablediff.TableDiff{{Name: "a/b", OnlyIn: "right"}}}
+	assert.Equal(t, []tablediff.Op{{Op: "add", Path: "/a~1b"}}, only.Patch())
+}
+
+func TestTableDiffRender(t *testing.T) {
+	var buf bytes.Buffer
+	tablediff.Render(&buf, sampleDiff(), false)
+	out := buf.String()
+	assert.Contains(t, out, "Table trades")
+	assert.Contains(t, out, "  - Price (double)\n")
+	assert.Contains(t, out, "rows: 3 -> 4 (2 added, 1 removed, 1 changed)")
+	assert.Contains(t, out, `  + {"Price":3.5,"Sym":"MSFT"}`)
+	assert.Contains(t, out, "  ... and 1 more")
+	assert.Contains(t, out, "  ~ Sym=AAPL: Price 1.5 -> 2.5")
+	assert.NotContains(t, out, "\x1b[")
+
+	buf.Reset()
+	tablediff.Render(&buf, sampleDiff(), true)
+	assert.Contains(t, buf.String(), "\x1b[32m+ Size (int)\x1b[0m")
+
+	buf.Reset()
+	tablediff.Render(&buf, &tablediff.Result{Tables: []tablediff.TableDiff{{Name: "t", LeftRows: 5, RightRows: 5}}}, false)
+	assert.Contains(t, buf.String(), "identical (5 rows)")
+}
+
+func TestTableDiffScript(t *testing.T) {
+	code, err := tablediff.Script(tablediff.Options{
+		Left: "old.py", Right: "new.py", Compare: true,
+		LeftCode: "t = 1\nprint('it\\'s \"quoted\"')\n", RightCode: "t = 2\n",
+		Key: []string{"Id"}, MaxRows: 5,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, code, "def __dh_diff(opts):")
+
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not available")
+	}
+	// Check that the options decode in Python, without running the diff.
+	head, _, _ := strings.Cut(code, "\ndef __dh_diff")
+	out, err := exec.Command(python, "-c", head+"\nprint(__dh_diff_opts['left_code'], __dh_diff_opts['key'], __dh_diff_opts['max_rows'])").CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "t = 1\nprint('it\\'s \"quoted\"')\n ['Id'] 5\n", string(out))
+
+	// The whole script parses.
+	cmd := exec.Command(python, "-c", "import ast, sys; ast.parse(sys.stdin.read())")
+	cmd.Stdin = strings.NewReader(code)
+	out, err = cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
@@ -1,15 +1,21 @@
 package tests
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dsmmcken/dh-cli/src/internal/config"
 	"github.com/dsmmcken/dh-cli/src/internal/discovery"
 	"github.com/dsmmcken/dh-cli/src/internal/tui"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/components"
 	"github.com/dsmmcken/dh-cli/src/internal/tui/screens"
+	"github.com/dsmmcken/dh-cli/src/internal/tui/tasks"
 	"github.com/dsmmcken/dh-cli/src/internal/versions"
+	"github.com/dsmmcken/dh-cli/src/internal/vm"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -17,7 +23,7 @@ import (
 func TestMainMenu_InitialCursor(t *testing.T) {
 	m := screens.NewMainMenu(t.TempDir())
 	assert.Equal(t, 0, m.Cursor())
-	assert.Equal(t, 5, m.ItemCount())
+	assert.Equal(t, 6, m.ItemCount())
 }
 
 func TestMainMenu_CursorMovesDown(t *testing.T) {
@@ -39,9 +45,9 @@ func TestMainMenu_CursorMovesUp(t *testing.T) {
 
 func TestMainMenu_CursorWrapsDown(t *testing.T) {
 	m := screens.NewMainMenu(t.TempDir())
-	// Move down 5 times (past last item)
+	// Move down 6 times (past last item)
 	var model tea.Model = m
-	for i := 0; i < 5; i++ {
+	for i := 0; i < 6; i++ {
 		model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")})
 	}
 	menu := model.(screens.MainMenu)
@@ -53,7 +59,7 @@ func TestMainMenu_CursorWrapsUp(t *testing.T) {
 	// Move up from position 0 should wrap to last
 	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("k")})
 	menu := updated.(screens.MainMenu)
-	assert.Equal(t, 4, menu.Cursor())
+	assert.Equal(t, 5, menu.Cursor())
 }
 
 func TestMainMenu_ViewContainsItems(t *testing.T) {
@@ -65,6 +71,7 @@ func TestMainMenu_ViewContainsItems(t *testing.T) {
 	assert.Contains(t, view, "Running servers")
 	assert.Contains(t, view, "Java status")
 	assert.Contains(t, view, "Environment doctor")
+	assert.Contains(t, view, "VM snapshots")
 	assert.Contains(t, view, "Configuration")
 }
 
@@ -220,6 +227,42 @@ func TestVersionsScreen_HasExpectedKeyBindings(t *testing.T) {
 	assert.Contains(t, view, "set default")
 	assert.Contains(t, view, "install")
 	assert.Contains(t, view, "uninstall")
+	assert.Contains(t, view, "release notes")
+}
+
+func TestVersionsScreen_NPushesReleaseNotes(t *testing.T) {
+	entries := []screens.VersionEntry{
+		{Version: "41.1", Installed: false},
+	}
+	m := versionsScreenWithEntries(entries, "")
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("n")})
+	require.NotNil(t, cmd)
+	push, ok := cmd().(screens.PushScreenMsg)
+	require.True(t, ok)
+	assert.IsType(t, screens.ReleaseNotesScreen{}, push.Screen)
+}
+
+func TestReleaseNotesScreen_RendersNotes(t *testing.T) {
+	var model tea.Model = screens.NewReleaseNotesScreen(t.TempDir(), "41.1", false)
+	model, _ = model.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	assert.Contains(t, model.View(), "Fetching release notes")
+
+	model, _ = model.Update(screens.ReleaseNotesLoadedMsg{Notes: &versions.ReleaseNotes{
+		Version: "41.1",
+		Title:   "v41.1",
+		Date:    "2025-12-01",
+		Body:    "## Bug fixes\n* Fixed **ticking** tables",
+		Cached:  true,
+	}})
+	view := model.View()
+	assert.Contains(t, view, "v41.1 · 2025-12-01 · cached")
+	assert.Contains(t, view, "Bug fixes")
+	assert.Contains(t, view, "• Fixed ticking tables")
+
+	// Installing from the notes starts a background task and goes back.
+	_, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("i")})
+	require.NotNil(t, cmd)
+	assert.IsType(t, tea.BatchMsg{}, cmd())
 }
 
 // helper to build a VersionsScreen pre-loaded with entries (bypasses async load).
@@ -281,7 +324,8 @@ func TestVersionsScreen_IOnInstalledDoesNothing(t *testing.T) {
 }
 
 func TestVersionsScreen_UOnInstalledUninstalls(t *testing.T) {
-	tmp := t.TempDir()
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
 	// Create a fake installed version directory
 	vDir := tmp + "/versions/0.36.0"
 	require.NoError(t, os.MkdirAll(vDir, 0o755))
@@ -295,14 +339,47 @@ func TestVersionsScreen_UOnInstalledUninstalls(t *testing.T) {
 	updated, _ := m.Update(loaded)
 	vs := updated.(screens.VersionsScreen)
 
-	// Press u to uninstall
+	// Press u to uninstall; nothing happens until confirmed
 	updated, cmd := vs.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("u")})
-	vs = updated.(screens.VersionsScreen)
-
 	assert.Nil(t, cmd)
+	assert.True(t, updated.(screens.VersionsScreen).Entries()[0].Installed)
+	assert.Contains(t, updated.View(), "Uninstall Deephaven 0.36.0?")
+
+	vs = answerConfirm(t, updated, "y").(screens.VersionsScreen)
 	assert.False(t, vs.Entries()[0].Installed)
 	assert.False(t, vs.Entries()[0].IsDefault)
 	assert.Equal(t, "", vs.Entries()[0].DateStr)
+	assert.NoDirExists(t, vDir)
+}
+
+func TestVersionsScreen_DontAskAgainSkipsConfirm(t *testing.T) {
+	tmp, cleanup := withTempDHHome(t)
+	defer cleanup()
+	for _, v := range []string{"0.36.0", "0.37.0"} {
+		require.NoError(t, os.MkdirAll(tmp+"/versions/"+v, 0o755))
+	}
+
+	entries := []screens.VersionEntry{
+		{Version: "0.37.0", Installed: true},
+		{Version: "0.36.0", Installed: true},
+	}
+	var model tea.Model = screens.NewVersionsScreen(tmp)
+	model, _ = model.Update(screens.VersionsListLoadedMsg{Entries: entries})
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("u")})
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")})
+	model = answerConfirm(t, model, "y")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uninstall"}, cfg.TUI.SkipConfirm)
+
+	// The next uninstall happens immediately.
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")})
+	model, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("u")})
+	assert.Nil(t, cmd)
+	assert.False(t, model.(screens.VersionsScreen).Entries()[1].Installed)
+	assert.NotContains(t, model.View(), "Uninstall Deephaven")
 }
 
 func TestVersionsScreen_UOnNotInstalledDoesNothing(t *testing.T) {
@@ -402,3 +479,226 @@ func TestServersScreen_PollTickTriggersRefresh(t *testing.T) {
 	// Poll tick should produce commands (refresh + next tick)
 	assert.NotNil(t, cmd)
 }
+
+// --- SnapshotsScreen tests ---
+
+func snapshotsScreenWith(t *testing.T, snaps []vm.SnapshotInfo) screens.SnapshotsScreen {
+	m := screens.NewSnapshotsScreen(t.TempDir())
+	updated, _ := m.Update(screens.SnapshotsLoadedMsg{Snapshots: snaps})
+	return updated.(screens.SnapshotsScreen)
+}
+
+func TestSnapshotsScreen_ViewShowsLoading(t *testing.T) {
+	m := screens.NewSnapshotsScreen(t.TempDir())
+	assert.Contains(t, m.View(), "Loading...")
+}
+
+func TestSnapshotsScreen_ViewShowsNoSnapshots(t *testing.T) {
+	m := snapshotsScreenWith(t, nil)
+	assert.Contains(t, m.View(), "No snapshots found")
+}
+
+func TestSnapshotsScreen_ViewShowsDetails(t *testing.T) {
+	created := time.Date(2025, 3, 4, 12, 30, 0, 0, time.Local)
+	m := snapshotsScreenWith(t, []vm.SnapshotInfo{{
+		Version:   "0.36.0",
+		SizeBytes: 3 << 30,
+		Complete:  true,
+		Meta: &vm.SnapshotMetadata{
+			Version:      "0.36.0",
+			CreatedAt:    created,
+			Firecracker:  "v1.12.0",
+			KernelSHA256: "0123456789abcdef0123",
+		},
+	}})
+	view := m.View()
+	assert.Contains(t, view, "0.36.0")
+	assert.Contains(t, view, "3.0 GiB")
+	assert.Contains(t, view, "2025-03-04 12:30")
+	assert.Contains(t, view, "ready")
+	assert.Contains(t, view, "firecracker v1.12.0")
+	assert.Contains(t, view, "kernel 0123456789ab")
+}
+
+func TestSnapshotsScreen_VerifyShowsProblems(t *testing.T) {
+	m := snapshotsScreenWith(t, []vm.SnapshotInfo{{Version: "0.36.0", Complete: true}})
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("v")})
+	require.NotNil(t, cmd)
+	updated, _ = updated.Update(screens.SnapshotVerifiedMsg{Version: "0.36.0", Problems: []string{"snapshot_mem is empty"}})
+	view := updated.View()
+	assert.Contains(t, view, "corrupt")
+	assert.Contains(t, view, "snapshot_mem is empty")
+}
+
+func TestSnapshotsScreen_DeleteRequiresConfirmation(t *testing.T) {
+	home, cleanup := withTempDHHome(t)
+	defer cleanup()
+	paths := vm.NewVMPaths(home)
+	require.NoError(t, os.MkdirAll(paths.SnapshotDirForVersion("0.36.0"), 0o755))
+
+	var model tea.Model = screens.NewSnapshotsScreen(home)
+	model, _ = model.Update(screens.SnapshotsLoadedMsg{Snapshots: []vm.SnapshotInfo{{Version: "0.36.0"}}})
+
+	// Declining leaves the snapshot alone.
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	assert.Contains(t, model.View(), "Delete the VM snapshot for 0.36.0?")
+	model = answerConfirm(t, model, "n")
+	assert.DirExists(t, paths.SnapshotDirForVersion("0.36.0"))
+	assert.NotContains(t, model.View(), "Delete the VM snapshot")
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	model = answerConfirm(t, model, "y")
+	assert.NoDirExists(t, paths.SnapshotDirForVersion("0.36.0"))
+	assert.Contains(t, model.(screens.SnapshotsScreen).Status(), "Deleted snapshot 0.36.0")
+}
+
+// answerConfirm presses key in an open confirm dialog and delivers the result.
+func answerConfirm(t *testing.T, model tea.Model, key string) tea.Model {
+	t.Helper()
+	model, cmd := model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)})
+	require.NotNil(t, cmd)
+	msg := cmd()
+	require.IsType(t, components.ConfirmResultMsg{}, msg)
+	model, _ = model.Update(msg)
+	return model
+}
+
+// --- Confirm dialog ---
+
+func TestConfirm_DefaultsToNo(t *testing.T) {
+	c := components.NewConfirm("kill", "Kill it?", "")
+	c, cmd := c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Equal(t, components.ConfirmResultMsg{Action: "kill"}, cmd())
+
+	// Moving focus to Yes confirms on enter.
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeyTab})
+	_, cmd = c.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, components.ConfirmResultMsg{Action: "kill", Confirmed: true}, cmd())
+}
+
+func TestConfirm_DontAskAgain(t *testing.T) {
+	c := components.NewConfirm("uninstall", "Uninstall?", "")
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")})
+	assert.True(t, c.DontAskAgain())
+	assert.Contains(t, c.View(), "[x] Don't ask again")
+
+	_, cmd := c.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	assert.Equal(t, components.ConfirmResultMsg{Action: "uninstall", Confirmed: true, DontAskAgain: true}, cmd())
+
+	// Declining never records "don't ask again".
+	_, cmd = c.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, components.ConfirmResultMsg{Action: "uninstall"}, cmd())
+}
+
+func TestServersScreen_KillAsksForConfirmation(t *testing.T) {
+	_, cleanup := withTempDHHome(t)
+	defer cleanup()
+
+	m := serversScreenWithServers([]discovery.Server{{Port: 10000, PID: 1234, Source: "java"}})
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	assert.Nil(t, cmd)
+	assert.Contains(t, updated.View(), "Kill the server on port 10000?")
+
+	updated = answerConfirm(t, updated, "n")
+	assert.Equal(t, "", updated.(screens.ServersScreen).Status())
+	assert.NotContains(t, updated.View(), "Kill the server")
+}
+
+// --- Background tasks ---
+
+func waitForTask(t *testing.T, m *tasks.Manager, id int) tasks.Info {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, info := range m.List() {
+			if info.ID == id && info.State != tasks.Running {
+				return info
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("task %d did not finish", id)
+	return tasks.Info{}
+}
+
+func TestTaskManager_RunsAndLogs(t *testing.T) {
+	m := tasks.NewManager()
+	id := m.Start("job", "Job", func(ctx context.Context, log func(string)) error {
+		log("step 1")
+		log("step 2")
+		return nil
+	})
+	info := waitForTask(t, m, id)
+	assert.Equal(t, tasks.Succeeded, info.State)
+	assert.Equal(t, []string{"step 1", "step 2"}, info.Log)
+	assert.Equal(t, "step 2", info.LastLine())
+
+	failed := waitForTask(t, m, m.Start("bad", "Bad", func(context.Context, func(string)) error {
+		return errors.New("boom")
+	}))
+	assert.Equal(t, tasks.Failed, failed.State)
+	assert.EqualError(t, failed.Err, "boom")
+
+	m.ClearFinished()
+	assert.Empty(t, m.List())
+}
+
+func TestTaskManager_CancelAndDedup(t *testing.T) {
+	m := tasks.NewManager()
+	started := make(chan struct{})
+	id := m.Start("install:1.0", "Install", func(ctx context.Context, log func(string)) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+
+	// Starting the same operation again returns the running task.
+	assert.Equal(t, id, m.Start("install:1.0", "Install", func(context.Context, func(string)) error { return nil }))
+	assert.Equal(t, 1, m.Running())
+
+	assert.True(t, m.Cancel(id))
+	assert.Equal(t, tasks.Canceled, waitForTask(t, m, id).State)
+	assert.False(t, m.Cancel(id))
+	assert.Equal(t, 0, m.Running())
+}
+
+func TestTaskLogWriter_SplitsProgressLines(t *testing.T) {
+	var lines []string
+	w := tasks.NewLogWriter(func(l string) { lines = append(lines, l) })
+	w.Write([]byte("Resolving\nDownloading 10%\rDownloading 5"))
+	w.Write([]byte("0%\r\nDone"))
+	w.Flush()
+	assert.Equal(t, []string{"Resolving", "Downloading 10%", "Downloading 50%", "Done"}, lines)
+}
+
+func TestTasksScreen_ShowsTasksAndLogs(t *testing.T) {
+	m := tasks.NewManager()
+	id := m.Start("", "Prepare VM snapshot 41.1", func(ctx context.Context, log func(string)) error {
+		log("Booting VM...")
+		return errors.New("no KVM")
+	})
+	waitForTask(t, m, id)
+
+	var model tea.Model = screens.NewTasksScreen(m)
+	model, _ = model.Update(tea.WindowSizeMsg{Width: 100, Height: 30})
+	view := model.View()
+	assert.Contains(t, view, "Prepare VM snapshot 41.1")
+	assert.Contains(t, view, "no KVM")
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Contains(t, model.View(), "Booting VM...")
+
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	assert.Contains(t, model.View(), "No tasks.")
+}
+
+func TestApp_CtrlTOpensTasks(t *testing.T) {
+	app := tui.NewApp(tui.MenuMode, t.TempDir())
+	_, cmd := app.Update(tea.KeyMsg{Type: tea.KeyCtrlT})
+	require.NotNil(t, cmd)
+	push, ok := cmd().(screens.PushScreenMsg)
+	require.True(t, ok)
+	assert.IsType(t, screens.TasksScreen{}, push.Screen)
+}
@@ -207,6 +207,160 @@ func TestFetchLatestVersionWithMockServer(t *testing.T) {
 	assert.Equal(t, "0.37.0", latest)
 }
 
+func TestFetchMatchingVersionWithMockServer(t *testing.T) {
+	resp := map[string]any{
+		"releases": map[string]any{
+			"0.39.2": []any{},
+			"0.40.1": []any{},
+			"41.0":   []any{},
+			"41.0.3": []any{},
+			"41.1":   []any{},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	origURL := versions.PyPIURL
+	versions.PyPIURL = server.URL
+	defer func() { versions.PyPIURL = origURL }()
+
+	v, err := versions.FetchMatchingVersion("~41.0")
+	require.NoError(t, err)
+	assert.Equal(t, "41.0.3", v)
+
+	v, err = versions.FetchMatchingVersion(">=0.39,<41")
+	require.NoError(t, err)
+	assert.Equal(t, "0.40.1", v)
+
+	_, err = versions.FetchMatchingVersion(">=42")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no release on PyPI matches")
+}
+
+func TestParseVersionTwoComponent(t *testing.T) {
+	v, err := versions.ParseVersion("41.1")
+	require.NoError(t, err)
+	assert.Equal(t, versions.Version{Major: 41, Minor: 1}, v)
+
+	w, err := versions.ParseVersion("41.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, 0, v.Compare(w))
+
+	_, err = versions.ParseVersion("41")
+	assert.Error(t, err)
+}
+
+func TestConstraintMatches(t *testing.T) {
+	cases := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"0.36.0", []string{"0.36.0"}, []string{"0.36.1"}},
+		{"41.1", []string{"41.1", "41.1.0"}, []string{"41.1.1"}},
+		{"~41.0", []string{"41.0", "41.0.5"}, []string{"41.1", "40.9"}},
+		{"~0.39.2", []string{"0.39.2", "0.39.9"}, []string{"0.39.1", "0.40.0"}},
+		{"^0.39", []string{"0.39.0", "0.39.7"}, []string{"0.40.0"}},
+		{"^41.1", []string{"41.1", "41.9"}, []string{"42.0", "41.0"}},
+		{"~=41.1", []string{"41.1", "41.5"}, []string{"42.0"}},
+		{"~=0.39.2", []string{"0.39.4"}, []string{"0.40.0"}},
+		{">=0.39,<41", []string{"0.39.0", "0.40.3"}, []string{"0.38.9", "41.0"}},
+		{"41.*", []string{"41.0", "41.3"}, []string{"42.0"}},
+		{"!=0.37.0, >0.36", []string{"0.36.1", "0.38.0"}, []string{"0.37.0", "0.36.0"}},
+	}
+	for _, tc := range cases {
+		c, err := versions.ParseConstraint(tc.constraint)
+		require.NoError(t, err, tc.constraint)
+		for _, v := range tc.match {
+			assert.True(t, c.Matches(v), "%s should match %s", tc.constraint, v)
+		}
+		for _, v := range tc.noMatch {
+			assert.False(t, c.Matches(v), "%s should not match %s", tc.constraint, v)
+		}
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	for _, s := range []string{"", "latest", ">=", "~=41", ">=41.*", "1.2.3.4", ">=0.39,"} {
+		_, err := versions.ParseConstraint(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestIsConstraint(t *testing.T) {
+	assert.False(t, versions.IsConstraint("0.36.0"))
+	assert.False(t, versions.IsConstraint("41.1"))
+	assert.True(t, versions.IsConstraint("~41.0"))
+	assert.True(t, versions.IsConstraint(">=0.39,<41"))
+}
+
+func TestSelect(t *testing.T) {
+	installed := []string{"0.36.0", "41.0.2", "41.1"}
+
+	v, ok := versions.Select("~41.0", installed)
+	assert.True(t, ok)
+	assert.Equal(t, "41.0.2", v)
+
+	v, ok = versions.Select("0.36.0", installed)
+	assert.True(t, ok)
+	assert.Equal(t, "0.36.0", v)
+
+	_, ok = versions.Select("0.37.0", installed)
+	assert.False(t, ok)
+}
+
+func TestFetchReleaseNotesCachesOffline(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/v0.37.0", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":         "v0.37.0",
+			"body":         "## Features\r\n* Faster tables",
+			"html_url":     "https://github.com/deephaven/deephaven-core/releases/tag/v0.37.0",
+			"published_at": "2024-11-05T18:00:00Z",
+		})
+	}))
+	defer server.Close()
+
+	origURL := versions.ReleaseNotesURL
+	versions.ReleaseNotesURL = server.URL + "/v%s"
+	defer func() { versions.ReleaseNotesURL = origURL }()
+
+	home := t.TempDir()
+	notes, err := versions.FetchReleaseNotes(home, "0.37.0")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-11-05", notes.Date)
+	assert.Equal(t, "## Features\n* Faster tables", notes.Body)
+	assert.False(t, notes.Cached)
+	assert.FileExists(t, versions.ReleaseNotesCachePath(home, "0.37.0"))
+
+	// The second lookup is served from the cache even with GitHub down.
+	server.Close()
+	notes, err = versions.FetchReleaseNotes(home, "0.37.0")
+	require.NoError(t, err)
+	assert.True(t, notes.Cached)
+	assert.Equal(t, "v0.37.0", notes.Title)
+	assert.Equal(t, 1, calls)
+}
+
+func TestFetchReleaseNotesNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	origURL := versions.ReleaseNotesURL
+	versions.ReleaseNotesURL = server.URL + "/v%s"
+	defer func() { versions.ReleaseNotesURL = origURL }()
+
+	_, err := versions.FetchReleaseNotes(t.TempDir(), "0.1.0")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no release notes published for 0.1.0")
+}
+
 func TestListInstalledEmpty(t *testing.T) {
 	tmp := t.TempDir()
 